@@ -0,0 +1,163 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// buildArgs collects the files given to build after the first, which may
+// be separated by flags, and expands wildcards in them for shells that do
+// not, such as cmd.exe. It returns the files and the arguments as given.
+func buildArgs(flagSet *flag.FlagSet, first string, rest []string) ([]string, []string) {
+	given := []string{first}
+	for len(rest) > 0 {
+		given = append(given, rest[0])
+		flagSet.Parse(rest[1:])
+		rest = flagSet.Args()
+	}
+
+	var files []string
+	for _, arg := range given {
+		if !strings.ContainsAny(arg, "*?[") {
+			files = append(files, arg)
+			continue
+		}
+		matches, err := filepath.Glob(arg)
+		if err != nil {
+			errorf("bad pattern %q: %v", arg, err)
+			os.Exit(1)
+		}
+		if len(matches) == 0 {
+			errorf("%s matches no files", arg)
+			os.Exit(1)
+		}
+		files = append(files, matches...)
+	}
+	return files, given
+}
+
+// buildBatch builds several programs in parallel, each with its own
+// dbasic build, and prints each one's output as it finishes and then a
+// summary. Executables are named after their files, in the -o directory if
+// one is given. given holds the file arguments, which are left out of the
+// flags passed on.
+func buildBatch(files, given []string, outputDir string, jobs int) {
+	if outputDir != "" {
+		if err := os.MkdirAll(outputDir, 0755); err != nil {
+			errorf("%v", err)
+			os.Exit(1)
+		}
+	}
+	outputs := make(map[string]string)
+	for _, f := range files {
+		if f == "-" {
+			errorf("stdin cannot be built along with other files")
+			os.Exit(1)
+		}
+		base := filepath.Base(f)
+		out := filepath.Join(outputDir, strings.TrimSuffix(base, filepath.Ext(base)))
+		if other, ok := outputs[out]; ok {
+			errorf("%s and %s would both be built as %s", other, f, out)
+			os.Exit(1)
+		}
+		outputs[out] = f
+	}
+
+	self, err := os.Executable()
+	if err != nil {
+		errorf("%v", err)
+		os.Exit(1)
+	}
+	flags := batchFlags(os.Args[2:], given)
+	if jobs < 1 {
+		jobs = 1
+	}
+
+	type built struct {
+		file, output string
+		log          []byte
+		err          error
+		elapsed      time.Duration
+	}
+	results := make(chan built)
+	work := make(chan string)
+	var wg sync.WaitGroup
+	for i := 0; i < jobs; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for out := range work {
+				file := outputs[out]
+				args := append(append([]string{"build"}, flags...), "-o", out, file)
+				cmd := exec.Command(self, args...)
+				var log bytes.Buffer
+				cmd.Stdout, cmd.Stderr = &log, &log
+				start := time.Now()
+				err := cmd.Run()
+				results <- built{file, out, log.Bytes(), err, time.Since(start)}
+			}
+		}()
+	}
+	go func() {
+		for _, f := range files {
+			base := filepath.Base(f)
+			work <- filepath.Join(outputDir, strings.TrimSuffix(base, filepath.Ext(base)))
+		}
+		close(work)
+		wg.Wait()
+		close(results)
+	}()
+
+	var failed []string
+	for r := range results {
+		if r.err != nil {
+			failed = append(failed, r.file)
+			fmt.Fprintf(os.Stderr, "FAIL  %s\n", r.file)
+			os.Stderr.Write(r.log)
+			continue
+		}
+		fmt.Fprintf(os.Stderr, "ok    %s -> %s (%.1fs)\n", r.file, r.output, r.elapsed.Seconds())
+		if verboseMode {
+			os.Stderr.Write(r.log)
+		}
+	}
+
+	if len(failed) > 0 {
+		fmt.Fprintf(os.Stderr, "built %d of %d programs; failed: %s\n", len(files)-len(failed), len(files), strings.Join(failed, ", "))
+		os.Exit(1)
+	}
+	fmt.Fprintf(os.Stderr, "built %d programs\n", len(files))
+}
+
+// batchFlags returns the build arguments without the files and -o, to pass
+// on to the build of each file
+func batchFlags(args, given []string) []string {
+	files := make(map[string]int)
+	for _, f := range given {
+		files[f]++
+	}
+	var flags []string
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		switch {
+		case files[arg] > 0:
+			files[arg]--
+		case arg == "-o" || arg == "--o":
+			i++
+		case strings.HasPrefix(arg, "-o=") || strings.HasPrefix(arg, "--o="):
+		case arg == "-j" || arg == "--j":
+			i++
+		case strings.HasPrefix(arg, "-j=") || strings.HasPrefix(arg, "--j="):
+		default:
+			flags = append(flags, arg)
+		}
+	}
+	return flags
+}
@@ -0,0 +1,297 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"go/constant"
+	"go/importer"
+	"go/token"
+	"go/types"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/zditech/dbasic/pkg/analyzer"
+	dberrors "github.com/zditech/dbasic/pkg/errors"
+	"github.com/zditech/dbasic/pkg/lexer"
+	"github.com/zditech/dbasic/pkg/parser"
+	"github.com/zditech/dbasic/pkg/preprocessor"
+	"github.com/zditech/dbasic/pkg/project"
+)
+
+// bind writes the .dbasi declaration file of a Go package, which lets the
+// analyzer check calls into the package. The file is written to output, or
+// else to the package's declaration file name in the current directory.
+func bind(importPath, output string) {
+	pkg, err := loadGoPackage(importPath)
+	if err != nil {
+		errorf("%s: %v", importPath, err)
+		os.Exit(1)
+	}
+
+	if output == "" {
+		output = analyzer.DeclarationName(importPath)
+	}
+	var w io.Writer = os.Stdout
+	if output != "-" {
+		f, err := os.Create(output)
+		if err != nil {
+			errorf("%v", err)
+			os.Exit(1)
+		}
+		defer f.Close()
+		w = f
+	}
+	bw := bufio.NewWriter(w)
+	writeDeclarations(bw, pkg)
+	if err := bw.Flush(); err != nil {
+		errorf("%v", err)
+		os.Exit(1)
+	}
+	if output != "-" {
+		fmt.Printf("%s: declarations for %s\n", output, importPath)
+	}
+}
+
+// loadGoPackage type-checks a Go package from the export data go list
+// builds for it, in a module that requires it. Versions come from the
+// dbasic.lock in the current directory, as they do for programs there.
+func loadGoPackage(importPath string) (*types.Package, error) {
+	dir, err := makeWorkDir("dbasic-bind-*")
+	if err != nil {
+		return nil, err
+	}
+	defer removeWorkDir(dir)
+
+	goCode := fmt.Sprintf("package main\n\nimport _ %q\n\nfunc main() {}\n", importPath)
+	if err := os.WriteFile(filepath.Join(dir, "main.go"), []byte(goCode), 0644); err != nil {
+		return nil, err
+	}
+	lockFile := ""
+	if _, err := os.Stat(project.LockName); err == nil {
+		lockFile, _ = filepath.Abs(project.LockName)
+	}
+	if err := initModule(dir, goCode, lockFile); err != nil {
+		return nil, err
+	}
+
+	list := goCommand(dir, "list", "-export", "-deps", "-f", "{{.ImportPath}}\t{{.Export}}", importPath)
+	out, err := list.Output()
+	if err != nil {
+		return nil, fmt.Errorf("listing package: %v", err)
+	}
+	exports := make(map[string]string)
+	for _, line := range strings.Split(strings.TrimSpace(string(out)), "\n") {
+		if path, file, ok := strings.Cut(line, "\t"); ok {
+			exports[path] = file
+		}
+	}
+
+	imp := importer.ForCompiler(token.NewFileSet(), "gc", func(path string) (io.ReadCloser, error) {
+		file := exports[path]
+		if file == "" {
+			return nil, fmt.Errorf("no export data for %s", path)
+		}
+		return os.Open(file)
+	})
+	return imp.Import(importPath)
+}
+
+// writeDeclarations writes the DECLARE lines for the exported types,
+// constants, variables and functions of pkg. Methods and generic functions
+// are left out, and so stay unchecked.
+func writeDeclarations(w io.Writer, pkg *types.Package) {
+	fmt.Fprintf(w, "' Declarations for the Go package %s, written by dbasic bind\n", pkg.Path())
+	fmt.Fprintf(w, "DECLARE PACKAGE %q\n", pkg.Path())
+
+	scope := pkg.Scope()
+	var typeNames, consts, vars, funcs []string
+	for _, name := range scope.Names() {
+		obj := scope.Lookup(name)
+		if !obj.Exported() {
+			continue
+		}
+		switch obj := obj.(type) {
+		case *types.TypeName:
+			typeNames = append(typeNames, "DECLARE TYPE "+name)
+		case *types.Const:
+			if t := constantType(obj); t != "" {
+				consts = append(consts, fmt.Sprintf("DECLARE CONST %s AS %s", name, t))
+			}
+		case *types.Var:
+			vars = append(vars, fmt.Sprintf("DECLARE DIM %s AS %s", name, declarationType(obj.Type(), pkg)))
+		case *types.Func:
+			if sig := obj.Type().(*types.Signature); sig.TypeParams() == nil {
+				funcs = append(funcs, funcDeclaration(name, sig, pkg))
+			}
+		}
+	}
+
+	for _, group := range [][]string{typeNames, consts, vars, funcs} {
+		if len(group) == 0 {
+			continue
+		}
+		fmt.Fprintln(w)
+		for _, line := range group {
+			fmt.Fprintln(w, line)
+		}
+	}
+}
+
+// funcDeclaration returns the DECLARE line of a function: a SUB when it
+// returns nothing, a FUNCTION otherwise
+func funcDeclaration(name string, sig *types.Signature, pkg *types.Package) string {
+	var params []string
+	for i := 0; i < sig.Params().Len(); i++ {
+		param := sig.Params().At(i)
+		paramName := param.Name()
+		// Unnamed parameters and those named like keywords get names of their own
+		if paramName == "" || paramName == "_" || lexer.LookupIdent(strings.ToUpper(paramName)) != lexer.TOKEN_IDENT {
+			paramName = fmt.Sprintf("arg%d", i+1)
+		}
+		paramType := declarationType(param.Type(), pkg)
+		if sig.Variadic() && i == sig.Params().Len()-1 {
+			params = append(params, fmt.Sprintf("PARAMARRAY %s AS %s", paramName, paramType))
+			continue
+		}
+		params = append(params, fmt.Sprintf("%s AS %s", paramName, paramType))
+	}
+
+	decl := fmt.Sprintf("%s(%s)", name, strings.Join(params, ", "))
+	var results []string
+	for i := 0; i < sig.Results().Len(); i++ {
+		results = append(results, declarationType(sig.Results().At(i).Type(), pkg))
+	}
+	switch len(results) {
+	case 0:
+		return "DECLARE SUB " + decl
+	case 1:
+		return "DECLARE FUNCTION " + decl + " AS " + results[0]
+	default:
+		return "DECLARE FUNCTION " + decl + " AS (" + strings.Join(results, ", ") + ")"
+	}
+}
+
+// constantType returns the DBasic type of a constant, or "" for an integer
+// too large for an INTEGER
+func constantType(c *types.Const) string {
+	if basic, ok := c.Type().(*types.Basic); ok {
+		switch basic.Kind() {
+		case types.UntypedInt, types.UntypedRune:
+			if _, exact := constant.Int64Val(c.Val()); !exact {
+				return ""
+			}
+			return "INTEGER"
+		case types.UntypedFloat:
+			return "DOUBLE"
+		case types.UntypedString:
+			return "STRING"
+		case types.UntypedBool:
+			return "BOOLEAN"
+		}
+	}
+	return declarationType(c.Type(), c.Pkg())
+}
+
+// declarationType spells a Go type as a DBasic type. Types DBasic has no
+// exact equivalent for, such as int32 or another package's types, are ANY,
+// so that Go rather than the analyzer checks them.
+func declarationType(t types.Type, pkg *types.Package) string {
+	switch t := t.(type) {
+	case *types.Basic:
+		switch t.Kind() {
+		case types.String:
+			return "STRING"
+		case types.Bool:
+			return "BOOLEAN"
+		case types.Int:
+			return "INTEGER"
+		case types.Int64:
+			return "LONG"
+		case types.Float32:
+			return "SINGLE"
+		case types.Float64:
+			return "DOUBLE"
+		}
+	case *types.Slice:
+		if elem, ok := t.Elem().(*types.Basic); ok && elem.Kind() == types.Byte {
+			return "BYTES"
+		}
+		return "[]" + declarationType(t.Elem(), pkg)
+	case *types.Map:
+		return "MAP OF " + declarationType(t.Key(), pkg) + " TO " + declarationType(t.Elem(), pkg)
+	case *types.Pointer:
+		return "POINTER TO " + declarationType(t.Elem(), pkg)
+	case *types.Chan:
+		// DBasic channels go both ways
+		if t.Dir() == types.SendRecv {
+			return "CHAN OF " + declarationType(t.Elem(), pkg)
+		}
+	case *types.Interface:
+		if t.Empty() {
+			return "ANY"
+		}
+	case *types.Alias:
+		return declarationType(types.Unalias(t), pkg)
+	case *types.Named:
+		obj := t.Obj()
+		if obj.Pkg() == nil {
+			if obj.Name() == "error" {
+				return "ERROR"
+			}
+			break
+		}
+		switch obj.Pkg().Path() + "." + obj.Name() {
+		case "time.Time":
+			return "DATETIME"
+		case "time.Duration":
+			return "DURATION"
+		case "context.Context":
+			return "CONTEXT"
+		}
+		if obj.Pkg() == pkg && obj.Exported() && t.TypeArgs() == nil {
+			return obj.Name()
+		}
+	}
+	return "ANY"
+}
+
+// declarePackages gives the analyzer the .dbasi file of each Go package the
+// program imports, looked for where INCLUDE looks for files
+func declarePackages(a *analyzer.Analyzer, pp *preprocessor.Preprocessor, program *parser.Program) []CompileError {
+	var errs []CompileError
+	for _, stmt := range program.Statements {
+		is, ok := stmt.(*parser.ImportStatement)
+		if !ok {
+			continue
+		}
+		path := pp.Find(analyzer.DeclarationName(is.Package))
+		if path == "" {
+			continue
+		}
+		file := displayPath(path)
+		source, err := os.ReadFile(path)
+		if err != nil {
+			errs = append(errs, CompileError{File: file, Message: err.Error(), Phase: "analyzer"})
+			continue
+		}
+		infof("declarations for %s from %s", is.Package, file)
+		for _, e := range a.Declare(file, string(source)) {
+			errs = append(errs, declarationError(e, file))
+		}
+	}
+	return errs
+}
+
+// declarationError turns an error in a .dbasi file into a CompileError
+func declarationError(msg, file string) CompileError {
+	ce := CompileError{File: file, Message: msg, Phase: "analyzer"}
+	if parsed := dberrors.Parse(msg); parsed != nil {
+		parsed.File = file
+		ce.Message, ce.Hint, ce.Code = parsed.Message, parsed.Hint, parsed.Code
+		ce.Line, ce.Column, ce.Phase = parsed.Line, parsed.Column, "parser"
+		ce.Text = parsed.Error()
+	}
+	return ce
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	goparser "go/parser"
+	"go/token"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/zditech/dbasic/pkg/project"
+)
+
+// cacheDir returns the directory build files are cached in: $DBASIC_CACHE,
+// the configuration's cache, or dbasic in the user's cache directory. It
+// returns "" if caching is turned off with DBASIC_CACHE=off or cache = "off".
+func cacheDir() string {
+	dir := os.Getenv("DBASIC_CACHE")
+	if dir == "" {
+		dir = config.Cache
+	}
+	switch dir {
+	case "off":
+		return ""
+	case "":
+		base, err := os.UserCacheDir()
+		if err != nil {
+			return ""
+		}
+		return filepath.Join(base, "dbasic")
+	default:
+		return dir
+	}
+}
+
+// cachedModule returns the directory that caches the go.mod and go.sum for
+// goCode. Programs share one when they import the same packages from
+// outside the standard library, at the same pinned versions. It returns ""
+// if caching is off or the code cannot be read.
+func cachedModule(goCode string, locked []project.Dependency) string {
+	root := cacheDir()
+	if root == "" {
+		return ""
+	}
+	f, err := goparser.ParseFile(token.NewFileSet(), "main.go", goCode, goparser.ImportsOnly)
+	if err != nil {
+		return ""
+	}
+
+	var deps []string
+	for _, imp := range f.Imports {
+		path, err := strconv.Unquote(imp.Path.Value)
+		if err != nil {
+			return ""
+		}
+		// Standard library paths have no dot in their first element
+		if first := strings.Split(path, "/")[0]; strings.Contains(first, ".") {
+			deps = append(deps, path)
+		}
+	}
+	sort.Strings(deps)
+	for _, dep := range modulePins(locked) {
+		deps = append(deps, dep.Module+"@"+dep.Version)
+	}
+
+	sum := sha256.Sum256([]byte(strings.Join(deps, "\n")))
+	return filepath.Join(root, "modules", hex.EncodeToString(sum[:8]))
+}
+
+// modulePins returns the module versions to require: those the project
+// pins, then those in the lock file for other modules
+func modulePins(locked []project.Dependency) []project.Dependency {
+	var pins []project.Dependency
+	pinned := make(map[string]bool)
+	if manifest != nil {
+		pins = append(pins, manifest.Dependencies...)
+		for _, dep := range manifest.Dependencies {
+			pinned[dep.Module] = true
+		}
+	}
+	for _, dep := range locked {
+		if !pinned[dep.Module] {
+			pins = append(pins, dep)
+		}
+	}
+	return pins
+}
+
+// updateLock records the versions of the modules required by the go.mod in
+// dir in lockFile, which held locked. Programs that only use the standard
+// library get no lock file.
+func updateLock(dir, lockFile string, locked []project.Dependency) error {
+	if lockFile == "" {
+		return nil
+	}
+	cmd := goCommand(dir, "mod", "edit", "-json")
+	out, err := cmd.Output()
+	if err != nil {
+		return fmt.Errorf("reading go.mod: %v", err)
+	}
+	var mod struct {
+		Require []struct{ Path, Version string }
+	}
+	if err := json.Unmarshal(out, &mod); err != nil {
+		return fmt.Errorf("reading go.mod: %v", err)
+	}
+	if len(mod.Require) == 0 {
+		return nil
+	}
+
+	var deps []project.Dependency
+	for _, r := range mod.Require {
+		deps = append(deps, project.Dependency{Module: r.Path, Version: r.Version})
+	}
+	merged := project.MergeLock(locked, deps)
+	if reflect.DeepEqual(merged, locked) {
+		return nil
+	}
+	infof("recording module versions in %s", lockFile)
+	return project.WriteLock(lockFile, merged)
+}
+
+// restoreModule copies a cached go.mod and go.sum into dir. It returns
+// false if they are not cached yet.
+func restoreModule(cached, dir string) bool {
+	mod, err := os.ReadFile(filepath.Join(cached, "go.mod"))
+	if err != nil {
+		return false
+	}
+	sum, err := os.ReadFile(filepath.Join(cached, "go.sum"))
+	if err != nil && !os.IsNotExist(err) {
+		return false
+	}
+	if os.WriteFile(filepath.Join(dir, "go.mod"), mod, 0644) != nil {
+		return false
+	}
+	if sum != nil && os.WriteFile(filepath.Join(dir, "go.sum"), sum, 0644) != nil {
+		return false
+	}
+	return true
+}
+
+// saveModule caches the go.mod and go.sum in dir. go.mod is written last,
+// and each file is renamed into place, so another build never sees half
+// a module.
+func saveModule(dir, cached string) error {
+	if err := os.MkdirAll(cached, 0755); err != nil {
+		return err
+	}
+	for _, name := range []string{"go.sum", "go.mod"} {
+		data, err := os.ReadFile(filepath.Join(dir, name))
+		if os.IsNotExist(err) && name == "go.sum" {
+			// A program that only uses the standard library has no go.sum
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		tmp, err := os.CreateTemp(cached, name+".*")
+		if err != nil {
+			return err
+		}
+		_, err = tmp.Write(data)
+		if closeErr := tmp.Close(); err == nil {
+			err = closeErr
+		}
+		if err == nil {
+			err = os.Rename(tmp.Name(), filepath.Join(cached, name))
+		}
+		if err != nil {
+			os.Remove(tmp.Name())
+			return err
+		}
+	}
+	return nil
+}
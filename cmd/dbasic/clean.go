@@ -0,0 +1,199 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/zditech/dbasic/pkg/project"
+)
+
+// ownerFile is written into each temporary build directory. It holds the
+// process ID of the dbasic that builds there, or "kept" when -work keeps the
+// directory.
+const ownerFile = "dbasic.owner"
+
+// workDirRe matches the names of temporary build directories
+var workDirRe = regexp.MustCompile(`^dbasic-(test-)?\d+$`)
+
+// clean removes what builds leave behind: the temporary build directories
+// of builds that crashed or were killed, and the executables and daemon module directories of the
+// given files, or of the project in the current directory. With all, the
+// whole cache goes too. With dryRun, it only prints what it would remove.
+func clean(files []string, all, dryRun bool) {
+	var paths []string
+
+	entries, _ := os.ReadDir(tempDir())
+	for _, e := range entries {
+		if !e.IsDir() || !workDirRe.MatchString(e.Name()) {
+			continue
+		}
+		if dir := filepath.Join(tempDir(), e.Name()); orphaned(dir) {
+			paths = append(paths, dir)
+		}
+	}
+
+	// Each program's executable and its module directory in the daemon
+	var programs, outputs []string
+	if len(files) == 0 {
+		p, err := project.Find(".")
+		if err != nil && err != project.ErrNoManifest {
+			errorf("%v", err)
+			os.Exit(1)
+		}
+		if p != nil {
+			infof("project %s (%s)", p.Name, filepath.Join(p.Dir, project.ManifestName))
+			programs = append(programs, p.Main)
+			outputs = append(outputs, p.Output)
+		}
+	}
+	for _, f := range files {
+		output, err := executableOf(f)
+		if err != nil {
+			errorf("%v", err)
+			os.Exit(1)
+		}
+		abs, err := filepath.Abs(f)
+		if err != nil {
+			errorf("%v", err)
+			os.Exit(1)
+		}
+		programs = append(programs, abs)
+		outputs = append(outputs, output)
+	}
+	socket := daemonSocket()
+	if socket != "" {
+		for _, program := range programs {
+			paths = append(paths, daemonWorkDir(daemonRoot(socket), program))
+		}
+	}
+
+	if all {
+		if root := cacheDir(); root != "" {
+			entries, _ := os.ReadDir(root)
+			for _, e := range entries {
+				path := filepath.Join(root, e.Name())
+				if path == socket && daemonListening(socket) {
+					continue
+				}
+				paths = append(paths, path)
+			}
+		}
+		if socket != "" && !daemonListening(socket) {
+			paths = append(paths, socket, daemonRoot(socket))
+		}
+	}
+
+	removed := 0
+	for _, output := range outputs {
+		if removeExecutable(output, dryRun) {
+			removed++
+		}
+	}
+	seen := make(map[string]bool)
+	for _, path := range paths {
+		if seen[path] {
+			continue
+		}
+		seen[path] = true
+		if _, err := os.Lstat(path); err != nil {
+			continue
+		}
+		if dryRun {
+			fmt.Printf("would remove %s\n", path)
+		} else if err := os.RemoveAll(path); err != nil {
+			errorf("%v", err)
+			continue
+		} else {
+			fmt.Printf("removed %s\n", path)
+		}
+		removed++
+	}
+	if removed == 0 {
+		fmt.Println("nothing to clean")
+	}
+}
+
+// orphaned reports whether a temporary build directory was left by a build
+// that crashed or was killed, as its owner file names a process that has
+// gone. A directory kept with -work, still in use, or without an owner file
+// is not.
+func orphaned(dir string) bool {
+	data, err := os.ReadFile(filepath.Join(dir, ownerFile))
+	if err != nil {
+		return false
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil {
+		return false
+	}
+	return !processAlive(pid)
+}
+
+// processAlive reports whether the process pid is running
+func processAlive(pid int) bool {
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		// On Windows finding a process opens it, which fails once it exits
+		return false
+	}
+	if runtime.GOOS == "windows" {
+		p.Release()
+		return true
+	}
+	return !errors.Is(p.Signal(syscall.Signal(0)), os.ErrProcessDone)
+}
+
+// executableOf returns the executable dbasic build makes of a .dbas file
+func executableOf(file string) (string, error) {
+	if filepath.Ext(file) != ".dbas" {
+		return "", fmt.Errorf("%s is not a .dbas file", file)
+	}
+	name := strings.TrimSuffix(filepath.Base(file), ".dbas")
+	if runtime.GOOS == "windows" {
+		name += ".exe"
+	}
+	return name, nil
+}
+
+// removeExecutable removes a program's executable, or with dryRun prints
+// that it would. Anything but a regular file is left alone, so a directory
+// that happens to share the program's name is never removed.
+func removeExecutable(path string, dryRun bool) bool {
+	info, err := os.Lstat(path)
+	if err != nil {
+		return false
+	}
+	if !info.Mode().IsRegular() {
+		warnf("%s is not an executable; leaving it", path)
+		return false
+	}
+	if dryRun {
+		fmt.Printf("would remove %s\n", path)
+		return true
+	}
+	if err := os.Remove(path); err != nil {
+		errorf("%v", err)
+		return false
+	}
+	fmt.Printf("removed %s\n", path)
+	return true
+}
+
+// daemonListening reports whether a daemon answers on socket
+func daemonListening(socket string) bool {
+	conn, err := net.DialTimeout("unix", socket, time.Second)
+	if err != nil {
+		return false
+	}
+	conn.Close()
+	return true
+}
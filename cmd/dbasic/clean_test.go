@@ -0,0 +1,87 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"testing"
+)
+
+func TestExecutableOf(t *testing.T) {
+	if _, err := executableOf("src"); err == nil {
+		t.Error("expected an error for an argument that is not a .dbas file")
+	}
+	name, err := executableOf(filepath.Join("dir", "hello.dbas"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "hello"
+	if runtime.GOOS == "windows" {
+		want += ".exe"
+	}
+	if name != want {
+		t.Errorf("expected %q, got %q", want, name)
+	}
+}
+
+func TestRemoveExecutableKeepsDirectories(t *testing.T) {
+	dir := t.TempDir()
+	sub := filepath.Join(dir, "foo")
+	if err := os.MkdirAll(filepath.Join(sub, "keep"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if removeExecutable(sub, false) {
+		t.Error("expected a directory not to be removed")
+	}
+	if _, err := os.Stat(filepath.Join(sub, "keep")); err != nil {
+		t.Errorf("directory was removed: %v", err)
+	}
+
+	exe := filepath.Join(dir, "bar")
+	if err := os.WriteFile(exe, []byte("binary"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if !removeExecutable(exe, false) {
+		t.Error("expected the executable to be removed")
+	}
+	if _, err := os.Stat(exe); !os.IsNotExist(err) {
+		t.Errorf("expected %s to be gone, got %v", exe, err)
+	}
+}
+
+func TestOrphanedWorkDirs(t *testing.T) {
+	// A process that has exited, for a build that crashed
+	cmd := exec.Command(os.Args[0], "-test.run=^$")
+	if err := cmd.Run(); err != nil {
+		t.Fatal(err)
+	}
+	gone := cmd.Process.Pid
+
+	root := t.TempDir()
+	tests := []struct {
+		owner    string // "" for no owner file
+		orphaned bool
+	}{
+		{strconv.Itoa(gone), true},
+		{strconv.Itoa(os.Getpid()), false},
+		{"kept", false},
+		{"", false},
+	}
+	for i, tt := range tests {
+		dir := filepath.Join(root, fmt.Sprintf("dbasic-%d", i))
+		if err := os.Mkdir(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+		if tt.owner != "" {
+			if err := os.WriteFile(filepath.Join(dir, ownerFile), []byte(tt.owner+"\n"), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if got := orphaned(dir); got != tt.orphaned {
+			t.Errorf("orphaned with owner %q = %v, expected %v", tt.owner, got, tt.orphaned)
+		}
+	}
+}
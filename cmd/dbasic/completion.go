@@ -0,0 +1,257 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// completionCommand describes a command for shell completion
+type completionCommand struct {
+	name  string
+	desc  string
+	flags []string
+	files bool // Takes .dbas files
+}
+
+// compileFlags are the flags shared by the commands that compile a program
+var compileFlags = []string{"-o", "-json", "-suppress", "-debug", "-release", "-v", "-I", "-work", "-goflags", "-map"}
+
+var completionCommands = []completionCommand{
+	{"build", "Compile to executables", append([]string{"-lib", "-pkg", "-j"}, compileFlags...), true},
+	{"run", "Compile and run", compileFlags, true},
+	{"debug", "Compile and step through with Delve", compileFlags, true},
+	{"emit", "Output generated Go code", compileFlags, true},
+	{"check", "Check for errors without compiling", compileFlags, true},
+	{"vet", "Check for constructs that are probably mistakes", compileFlags, true},
+	{"test", "Run TEST and BENCHMARK blocks", append([]string{"-bench", "-benchtime"}, compileFlags...), true},
+	{"doc", "Write API docs from declaration comments", []string{"-html", "-o", "-I"}, true},
+	{"get", "Fetch DBasic libraries into the project", []string{"-v"}, false},
+	{"new", "Start a cli, tui, gui or web project", nil, false},
+	{"bind", "Write a Go package's declarations", []string{"-o", "-v"}, false},
+	{"clean", "Remove executables and stale build files", []string{"-all", "-n", "-v"}, true},
+	{"ast", "Print the parse tree", []string{"-json", "-I"}, true},
+	{"tokens", "Print the token stream", []string{"-json", "-I"}, true},
+	{"lsp", "Start a language server on stdin/stdout", nil, false},
+	{"daemon", "Serve fast rebuilds to build and run", []string{"-stop", "-v"}, false},
+	{"completion", "Print a shell completion script", nil, false},
+	{"version", "Print version", nil, false},
+	{"help", "Print help", nil, false},
+}
+
+// completionShells are the shells completion writes scripts for
+var completionShells = []string{"bash", "zsh", "fish", "powershell"}
+
+// Flags whose value is a file, a directory, or something else that cannot
+// be completed
+var (
+	fileValueFlags  = []string{"-o"}
+	dirValueFlags   = []string{"-I"}
+	otherValueFlags = []string{"-pkg", "-j", "-suppress", "-goflags", "-bench", "-benchtime"}
+)
+
+// completion prints the completion script for a shell
+func completion(shell string) {
+	var names []string
+	for _, c := range completionCommands {
+		names = append(names, c.name)
+	}
+
+	var sb strings.Builder
+	switch shell {
+	case "bash":
+		writeBashCompletion(&sb, names)
+	case "zsh":
+		writeZshCompletion(&sb)
+	case "fish":
+		writeFishCompletion(&sb)
+	case "powershell":
+		writePowerShellCompletion(&sb)
+	default:
+		if shell == "" {
+			errorf("no shell specified")
+		} else {
+			errorf("unknown shell: %s", shell)
+		}
+		fmt.Fprintf(os.Stderr, "Usage: dbasic completion %s\n", strings.Join(completionShells, "|"))
+		os.Exit(1)
+	}
+	fmt.Print(sb.String())
+}
+
+func writeBashCompletion(sb *strings.Builder, names []string) {
+	sb.WriteString("# bash completion for dbasic. Load it with\n")
+	sb.WriteString("#   source <(dbasic completion bash)\n")
+	sb.WriteString("_dbasic() {\n")
+	sb.WriteString("    local cur=\"${COMP_WORDS[COMP_CWORD]}\" prev=\"${COMP_WORDS[COMP_CWORD-1]}\"\n")
+	sb.WriteString("    if [ \"$COMP_CWORD\" -eq 1 ]; then\n")
+	fmt.Fprintf(sb, "        COMPREPLY=($(compgen -W \"%s\" -- \"$cur\"))\n", strings.Join(names, " "))
+	sb.WriteString("        return\n    fi\n")
+	sb.WriteString("    case \"$prev\" in\n")
+	fmt.Fprintf(sb, "    %s) COMPREPLY=($(compgen -f -- \"$cur\")); return ;;\n", strings.Join(fileValueFlags, "|"))
+	fmt.Fprintf(sb, "    %s) COMPREPLY=($(compgen -d -- \"$cur\")); return ;;\n", strings.Join(dirValueFlags, "|"))
+	fmt.Fprintf(sb, "    %s) return ;;\n", strings.Join(otherValueFlags, "|"))
+	sb.WriteString("    esac\n")
+	sb.WriteString("    local flags=\"\" files=\"\"\n")
+	sb.WriteString("    case \"${COMP_WORDS[1]}\" in\n")
+	sb.WriteString("    completion) COMPREPLY=($(compgen -W \"" + strings.Join(completionShells, " ") + "\" -- \"$cur\")); return ;;\n")
+	for _, c := range completionCommands {
+		if c.name == "completion" {
+			continue
+		}
+		fmt.Fprintf(sb, "    %s) flags=\"%s\"", c.name, strings.Join(c.flags, " "))
+		if c.files {
+			sb.WriteString(" files=1")
+		}
+		sb.WriteString(" ;;\n")
+	}
+	sb.WriteString("    esac\n")
+	sb.WriteString("    if [[ \"$cur\" == -* ]]; then\n")
+	sb.WriteString("        COMPREPLY=($(compgen -W \"$flags\" -- \"$cur\"))\n")
+	sb.WriteString("    elif [ -n \"$files\" ]; then\n")
+	sb.WriteString("        COMPREPLY=($(compgen -f -X '!*.dbas' -- \"$cur\") $(compgen -d -- \"$cur\"))\n")
+	sb.WriteString("    fi\n")
+	sb.WriteString("}\n")
+	sb.WriteString("complete -o filenames -F _dbasic dbasic\n")
+}
+
+func writeZshCompletion(sb *strings.Builder) {
+	sb.WriteString("#compdef dbasic\n")
+	sb.WriteString("# zsh completion for dbasic. Load it with\n")
+	sb.WriteString("#   source <(dbasic completion zsh)\n")
+	sb.WriteString("# or save it as _dbasic in a directory on $fpath.\n")
+	sb.WriteString("_dbasic() {\n")
+	sb.WriteString("    local -a commands flags\n")
+	sb.WriteString("    local files=\"\"\n")
+	sb.WriteString("    commands=(\n")
+	for _, c := range completionCommands {
+		fmt.Fprintf(sb, "        '%s:%s'\n", c.name, c.desc)
+	}
+	sb.WriteString("    )\n")
+	sb.WriteString("    if (( CURRENT == 2 )); then\n")
+	sb.WriteString("        _describe 'command' commands\n")
+	sb.WriteString("        return\n    fi\n")
+	sb.WriteString("    case $words[CURRENT-1] in\n")
+	fmt.Fprintf(sb, "    %s) _files; return ;;\n", strings.Join(fileValueFlags, "|"))
+	fmt.Fprintf(sb, "    %s) _files -/; return ;;\n", strings.Join(dirValueFlags, "|"))
+	fmt.Fprintf(sb, "    %s) return ;;\n", strings.Join(otherValueFlags, "|"))
+	sb.WriteString("    esac\n")
+	sb.WriteString("    case $words[2] in\n")
+	sb.WriteString("    completion) compadd -- " + strings.Join(completionShells, " ") + "; return ;;\n")
+	for _, c := range completionCommands {
+		if c.name == "completion" {
+			continue
+		}
+		fmt.Fprintf(sb, "    %s) flags=(%s)", c.name, strings.Join(c.flags, " "))
+		if c.files {
+			sb.WriteString(" files=1")
+		}
+		sb.WriteString(" ;;\n")
+	}
+	sb.WriteString("    esac\n")
+	sb.WriteString("    if [[ $words[CURRENT] == -* ]]; then\n")
+	sb.WriteString("        compadd -- $flags\n")
+	sb.WriteString("    elif [[ -n $files ]]; then\n")
+	sb.WriteString("        _files -g '*.dbas'\n")
+	sb.WriteString("    fi\n")
+	sb.WriteString("}\n")
+	sb.WriteString("if [ \"$funcstack[1]\" = \"_dbasic\" ]; then\n")
+	sb.WriteString("    _dbasic \"$@\"\n")
+	sb.WriteString("else\n")
+	sb.WriteString("    compdef _dbasic dbasic\n")
+	sb.WriteString("fi\n")
+}
+
+func writeFishCompletion(sb *strings.Builder) {
+	sb.WriteString("# fish completion for dbasic. Load it with\n")
+	sb.WriteString("#   dbasic completion fish | source\n")
+	sb.WriteString("# or save it as ~/.config/fish/completions/dbasic.fish.\n")
+	sb.WriteString("complete -c dbasic -f\n")
+	var fileCommands []string
+	for _, c := range completionCommands {
+		fmt.Fprintf(sb, "complete -c dbasic -n __fish_use_subcommand -a %s -d '%s'\n", c.name, c.desc)
+		if c.files {
+			fileCommands = append(fileCommands, c.name)
+		}
+	}
+	for _, c := range completionCommands {
+		for _, f := range c.flags {
+			fmt.Fprintf(sb, "complete -c dbasic -n '__fish_seen_subcommand_from %s' -o %s", c.name, f[1:])
+			switch {
+			case contains(fileValueFlags, f):
+				sb.WriteString(" -r -F")
+			case contains(dirValueFlags, f):
+				sb.WriteString(" -r -a '(__fish_complete_directories)'")
+			case contains(otherValueFlags, f):
+				sb.WriteString(" -r")
+			}
+			sb.WriteString("\n")
+		}
+	}
+	fmt.Fprintf(sb, "complete -c dbasic -n '__fish_seen_subcommand_from %s' -a '(__fish_complete_suffix .dbas)'\n", strings.Join(fileCommands, " "))
+	fmt.Fprintf(sb, "complete -c dbasic -n '__fish_seen_subcommand_from completion' -a '%s'\n", strings.Join(completionShells, " "))
+}
+
+func writePowerShellCompletion(sb *strings.Builder) {
+	sb.WriteString("# PowerShell completion for dbasic. Load it with\n")
+	sb.WriteString("#   dbasic completion powershell | Out-String | Invoke-Expression\n")
+	sb.WriteString("# or add that line to your $PROFILE.\n")
+	sb.WriteString("Register-ArgumentCompleter -Native -CommandName dbasic -ScriptBlock {\n")
+	sb.WriteString("    param($wordToComplete, $commandAst, $cursorPosition)\n")
+	sb.WriteString("    $commands = [ordered]@{\n")
+	for _, c := range completionCommands {
+		fmt.Fprintf(sb, "        '%s' = '%s'\n", c.name, c.desc)
+	}
+	sb.WriteString("    }\n")
+	sb.WriteString("    $flags = @{\n")
+	var fileCommands []string
+	for _, c := range completionCommands {
+		quoted := make([]string, len(c.flags))
+		for i, f := range c.flags {
+			quoted[i] = "'" + f + "'"
+		}
+		fmt.Fprintf(sb, "        '%s' = @(%s)\n", c.name, strings.Join(quoted, ", "))
+		if c.files {
+			fileCommands = append(fileCommands, "'"+c.name+"'")
+		}
+	}
+	sb.WriteString("    }\n")
+	sb.WriteString("    $words = @($commandAst.CommandElements | ForEach-Object { $_.ToString() })\n")
+	sb.WriteString("    if ($words.Count -lt 2 -or ($words.Count -eq 2 -and $wordToComplete -ne '')) {\n")
+	sb.WriteString("        $commands.Keys | Where-Object { $_ -like \"$wordToComplete*\" } | ForEach-Object {\n")
+	sb.WriteString("            [System.Management.Automation.CompletionResult]::new($_, $_, 'ParameterValue', $commands[$_])\n")
+	sb.WriteString("        }\n")
+	sb.WriteString("        return\n")
+	sb.WriteString("    }\n")
+	sb.WriteString("    if ($words[1] -eq 'completion') {\n")
+	fmt.Fprintf(sb, "        @('%s') | Where-Object { $_ -like \"$wordToComplete*\" } | ForEach-Object {\n", strings.Join(completionShells, "', '"))
+	sb.WriteString("            [System.Management.Automation.CompletionResult]::new($_, $_, 'ParameterValue', $_)\n")
+	sb.WriteString("        }\n")
+	sb.WriteString("        return\n")
+	sb.WriteString("    }\n")
+	sb.WriteString("    if ($wordToComplete -like '-*') {\n")
+	sb.WriteString("        $flags[$words[1]] | Where-Object { $_ -like \"$wordToComplete*\" } | ForEach-Object {\n")
+	sb.WriteString("            [System.Management.Automation.CompletionResult]::new($_, $_, 'ParameterName', $_)\n")
+	sb.WriteString("        }\n")
+	sb.WriteString("        return\n")
+	sb.WriteString("    }\n")
+	fmt.Fprintf(sb, "    if (@(%s) -notcontains $words[1]) { return }\n", strings.Join(fileCommands, ", "))
+	sb.WriteString("    $dir = Split-Path -Parent $wordToComplete\n")
+	sb.WriteString("    Get-ChildItem -Path \"$wordToComplete*\" -ErrorAction SilentlyContinue |\n")
+	sb.WriteString("        Where-Object { $_.PSIsContainer -or $_.Extension -eq '.dbas' } | ForEach-Object {\n")
+	sb.WriteString("            $name = if ($dir) { Join-Path $dir $_.Name } else { $_.Name }\n")
+	sb.WriteString("            if ($_.PSIsContainer) { $name += [IO.Path]::DirectorySeparatorChar }\n")
+	sb.WriteString("            [System.Management.Automation.CompletionResult]::new($name, $name, 'ProviderItem', $name)\n")
+	sb.WriteString("        }\n")
+	sb.WriteString("}\n")
+}
+
+// contains reports whether list holds s
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,373 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"net"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/zditech/dbasic/pkg/codegen"
+	"github.com/zditech/dbasic/pkg/project"
+)
+
+// The daemon builds the Go code of programs for build and run. Each program
+// keeps a module directory in the daemon, so its dependencies are set up
+// once and Go's build cache is hit when little has changed, where building
+// in a new temporary directory each time starts from scratch. A run gets the
+// executable of its version of the program, which is built once.
+
+// daemonRequest asks the daemon to build a program, or to stop
+type daemonRequest struct {
+	Version      string
+	Stop         bool   `json:",omitempty"`
+	Program      string // Absolute path of the main file, which picks the module directory
+	GoCode       string
+	Embeds       []codegen.Embed
+	Dependencies []project.Dependency
+	GoFlags      []string
+	Env          []string // The client's environment, for go
+	Output       string   // Executable to write; "" for one in the module directory for this version of the program
+	LockFile     string   // dbasic.lock of the program, or ""
+}
+
+// daemonResponse reports a build
+type daemonResponse struct {
+	Version string
+	Output  string // What go wrote
+	Error   string // Why the build failed, or ""
+	Binary  string // The executable built
+	WorkDir string // The program's module directory
+}
+
+// daemonSocket returns the path of the daemon's socket: $DBASIC_DAEMON, or
+// daemon.sock in the cache directory. It returns "" if the daemon is turned
+// off with DBASIC_DAEMON=off or there is no cache directory.
+func daemonSocket() string {
+	switch path := os.Getenv("DBASIC_DAEMON"); path {
+	case "off":
+		return ""
+	case "":
+		if root := cacheDir(); root != "" {
+			return filepath.Join(root, "daemon.sock")
+		}
+		return ""
+	default:
+		return path
+	}
+}
+
+// daemonRoot returns the directory that holds the module directories of
+// the daemon listening on socket
+func daemonRoot(socket string) string {
+	return filepath.Join(filepath.Dir(socket), "daemon")
+}
+
+// daemonWorkDir returns the module directory of the program whose main file
+// is program
+func daemonWorkDir(root, program string) string {
+	sum := sha256.Sum256([]byte(program))
+	return filepath.Join(root, hex.EncodeToString(sum[:8]))
+}
+
+// callDaemon sends a request to the daemon. It returns an error if no daemon
+// is listening.
+func callDaemon(socket string, req daemonRequest) (*daemonResponse, error) {
+	conn, err := net.DialTimeout("unix", socket, time.Second)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	req.Version = version
+	if err := json.NewEncoder(conn).Encode(req); err != nil {
+		return nil, err
+	}
+	var resp daemonResponse
+	if err := json.NewDecoder(conn).Decode(&resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// daemonBuild has a running daemon build a compiled program into output,
+// or into an executable of its own when output is "", and returns the
+// executable. It returns false if no daemon is running, so the caller
+// builds the program itself, and exits if the build fails.
+func daemonBuild(result *CompileResult, output string) (string, bool) {
+	socket := daemonSocket()
+	if socket == "" {
+		return "", false
+	}
+	program, err := filepath.Abs(result.SourceFile)
+	if err != nil {
+		return "", false
+	}
+	req := daemonRequest{
+		Program:  program,
+		GoCode:   result.GoCode,
+		Embeds:   result.Embeds,
+		GoFlags:  goFlags,
+		Env:      os.Environ(),
+		Output:   output,
+		LockFile: result.LockFile,
+	}
+	if manifest != nil {
+		req.Dependencies = manifest.Dependencies
+	}
+
+	resp, err := callDaemon(socket, req)
+	if err != nil {
+		infof("no daemon on %s: %v", socket, err)
+		return "", false
+	}
+	if resp.Version != version {
+		warnf("the daemon on %s is version %s; building without it", socket, resp.Version)
+		return "", false
+	}
+	infof("built by the daemon in %s", resp.WorkDir)
+	if keepWork {
+		fmt.Fprintf(os.Stderr, "WORK=%s\n", resp.WorkDir)
+	}
+
+	os.Stderr.WriteString(resp.Output)
+	if resp.Error != "" {
+		errorf("building executable: %s", resp.Error)
+		os.Exit(1)
+	}
+	return resp.Binary, true
+}
+
+// daemon serves build requests on a socket
+type daemon struct {
+	mu      sync.Mutex        // Requests are handled one at a time, as they share the compiler's settings
+	root    string            // Directory of the programs' module directories
+	modules map[string]string // The cached module each module directory was set up from
+	stop    chan struct{}
+	stopped sync.Once // Closes stop once, however many stop requests arrive
+}
+
+// serveDaemon runs the daemon until it is stopped with dbasic daemon -stop
+// or a signal
+func serveDaemon(socket string) {
+	if socket == "" {
+		errorf("the daemon needs a cache directory; set DBASIC_DAEMON to a socket path")
+		os.Exit(1)
+	}
+	if daemonListening(socket) {
+		errorf("a daemon is already listening on %s", socket)
+		os.Exit(1)
+	}
+	// A socket left by a daemon that did not stop cleanly
+	os.Remove(socket)
+
+	if err := os.MkdirAll(filepath.Dir(socket), 0755); err != nil {
+		errorf("%v", err)
+		os.Exit(1)
+	}
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		errorf("%v", err)
+		os.Exit(1)
+	}
+
+	d := &daemon{
+		root:    daemonRoot(socket),
+		modules: make(map[string]string),
+		stop:    make(chan struct{}),
+	}
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		select {
+		case <-signals:
+		case <-d.stop:
+		}
+		listener.Close()
+	}()
+
+	fmt.Fprintf(os.Stderr, "dbasic daemon %s listening on %s\n", version, socket)
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			break
+		}
+		go d.serve(conn)
+	}
+	// Closing the listener removes the socket
+	fmt.Fprintln(os.Stderr, "dbasic daemon stopped")
+}
+
+// serve answers one request
+func (d *daemon) serve(conn net.Conn) {
+	defer conn.Close()
+	var req daemonRequest
+	if err := json.NewDecoder(conn).Decode(&req); err != nil {
+		return
+	}
+
+	resp := daemonResponse{Version: version}
+	switch {
+	case req.Stop:
+		d.stopped.Do(func() { close(d.stop) })
+	case req.Version != version:
+		// The client builds the program itself
+	default:
+		resp = d.build(req)
+	}
+	json.NewEncoder(conn).Encode(resp)
+}
+
+// build builds a program in its module directory
+func (d *daemon) build(req daemonRequest) daemonResponse {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	var out bytes.Buffer
+	goEnv, goOutput, goFlags = req.Env, &out, req.GoFlags
+	manifest = &project.Project{Dependencies: req.Dependencies}
+	defer func() {
+		goEnv, goOutput, goFlags, manifest = nil, os.Stderr, nil, nil
+	}()
+
+	dir := daemonWorkDir(d.root, req.Program)
+	resp := daemonResponse{Version: version, WorkDir: dir}
+	fail := func(err error) daemonResponse {
+		resp.Output = out.String()
+		resp.Error = err.Error()
+		return resp
+	}
+	infof("building %s in %s", req.Program, dir)
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fail(err)
+	}
+	// An unchanged main.go keeps its time stamp
+	goFile := filepath.Join(dir, "main.go")
+	if old, err := os.ReadFile(goFile); err != nil || string(old) != req.GoCode {
+		if err := os.WriteFile(goFile, []byte(req.GoCode), 0644); err != nil {
+			return fail(err)
+		}
+	}
+	os.RemoveAll(filepath.Join(dir, "dbasic_embed"))
+	if err := writeEmbeds(&CompileResult{Embeds: req.Embeds}, dir); err != nil {
+		return fail(err)
+	}
+
+	// The module is set up again when the program's imports change
+	locked, _ := project.ReadLock(req.LockFile)
+	module := cachedModule(req.GoCode, locked)
+	if _, err := os.Stat(filepath.Join(dir, "go.mod")); err != nil || d.modules[dir] != module {
+		os.Remove(filepath.Join(dir, "go.mod"))
+		os.Remove(filepath.Join(dir, "go.sum"))
+		if err := initModule(dir, req.GoCode, req.LockFile); err != nil {
+			delete(d.modules, dir)
+			return fail(err)
+		}
+		d.modules[dir] = module
+	}
+
+	resp.Binary = req.Output
+	if resp.Binary == "" {
+		// Each version of the program gets an executable of its own, so a
+		// run of an earlier version keeps its file, and an unchanged
+		// program runs the executable built before without going to go
+		key, err := executableKey(dir, req, module)
+		if err != nil {
+			return fail(err)
+		}
+		resp.Binary = filepath.Join(dir, "program-"+key)
+		if runtime.GOOS == "windows" {
+			resp.Binary += ".exe"
+		}
+		if _, err := os.Stat(resp.Binary); err == nil {
+			now := time.Now()
+			os.Chtimes(resp.Binary, now, now)
+			infof("reusing %s", resp.Binary)
+			resp.Output = out.String()
+			return resp
+		}
+		pruneExecutables(dir)
+	}
+	cmd := goCommand(dir, append(append([]string{"build"}, goFlags...), "-o", resp.Binary, ".")...)
+	if err := cmd.Run(); err != nil {
+		return fail(err)
+	}
+	resp.Output = out.String()
+	return resp
+}
+
+// executableKey returns what names the executable of a program in its
+// module directory dir. It changes with anything that changes the
+// executable: the Go code, the embedded files, the module, the go flags and
+// Go's settings in the client's environment.
+func executableKey(dir string, req daemonRequest, module string) (string, error) {
+	h := sha256.New()
+	fmt.Fprintf(h, "%q %q %q\n", req.GoCode, module, req.GoFlags)
+	for _, v := range req.Env {
+		if strings.HasPrefix(v, "GO") || strings.HasPrefix(v, "CGO_") {
+			fmt.Fprintf(h, "%q\n", v)
+		}
+	}
+	for _, e := range req.Embeds {
+		err := filepath.WalkDir(filepath.Join(dir, filepath.FromSlash(e.Target)), func(path string, entry fs.DirEntry, err error) error {
+			if err != nil || entry.IsDir() {
+				return err
+			}
+			data, err := os.ReadFile(path)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(h, "%q %d\n", path, len(data))
+			h.Write(data)
+			return nil
+		})
+		if err != nil {
+			return "", err
+		}
+	}
+	sum := h.Sum(nil)
+	return hex.EncodeToString(sum[:8]), nil
+}
+
+// staleAge is how long an executable in a module directory goes unused
+// before the daemon removes it
+const staleAge = time.Hour
+
+// pruneExecutables removes the executables in a module directory that no
+// build has asked for in staleAge. A program still running from one keeps
+// it open, where the system allows removing it.
+func pruneExecutables(dir string) {
+	entries, _ := os.ReadDir(dir)
+	for _, e := range entries {
+		if e.IsDir() || !strings.HasPrefix(e.Name(), "program-") {
+			continue
+		}
+		if info, err := e.Info(); err == nil && time.Since(info.ModTime()) > staleAge {
+			os.Remove(filepath.Join(dir, e.Name()))
+		}
+	}
+}
+
+// stopDaemon asks the daemon to stop
+func stopDaemon(socket string) {
+	if socket == "" {
+		errorf("no daemon socket; DBASIC_DAEMON is off")
+		os.Exit(1)
+	}
+	if _, err := callDaemon(socket, daemonRequest{Stop: true}); err != nil {
+		errorf("no daemon is listening on %s", socket)
+		os.Exit(1)
+	}
+	fmt.Fprintf(os.Stderr, "stopped the daemon on %s\n", socket)
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"encoding/json"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/zditech/dbasic/pkg/codegen"
+)
+
+func TestDaemonConcurrentStops(t *testing.T) {
+	d := &daemon{stop: make(chan struct{})}
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		client, server := net.Pipe()
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			d.serve(server)
+		}()
+		go func() {
+			defer client.Close()
+			json.NewEncoder(client).Encode(daemonRequest{Version: version, Stop: true})
+			var resp daemonResponse
+			json.NewDecoder(client).Decode(&resp)
+		}()
+	}
+	wg.Wait()
+
+	select {
+	case <-d.stop:
+	default:
+		t.Error("expected the daemon to be stopped")
+	}
+}
+
+func TestExecutableKey(t *testing.T) {
+	dir := t.TempDir()
+	embed := filepath.Join(dir, "dbasic_embed", "logo.txt")
+	if err := os.MkdirAll(filepath.Dir(embed), 0755); err != nil {
+		t.Fatal(err)
+	}
+	write := func(data string) {
+		if err := os.WriteFile(embed, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	key := func(req daemonRequest) string {
+		k, err := executableKey(dir, req, "module")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return k
+	}
+
+	write("one")
+	req := daemonRequest{
+		GoCode: "package main",
+		Embeds: []codegen.Embed{{Path: "logo.txt", Target: "dbasic_embed/logo.txt"}},
+		Env:    []string{"HOME=/home/a", "GOOS=linux"},
+	}
+	first := key(req)
+	if again := key(daemonRequest{GoCode: req.GoCode, Embeds: req.Embeds, Env: []string{"HOME=/home/b", "GOOS=linux"}}); again != first {
+		t.Errorf("expected the same executable for the same program, got %s and %s", first, again)
+	}
+
+	changed := []daemonRequest{
+		{GoCode: "package main // changed", Embeds: req.Embeds, Env: req.Env},
+		{GoCode: req.GoCode, Embeds: req.Embeds, Env: []string{"GOOS=windows"}},
+		{GoCode: req.GoCode, Embeds: req.Embeds, Env: req.Env, GoFlags: []string{"-race"}},
+	}
+	for _, r := range changed {
+		if k := key(r); k == first {
+			t.Errorf("expected a new executable for %+v", r)
+		}
+	}
+	write("two")
+	if k := key(req); k == first {
+		t.Error("expected a new executable when an embedded file changes")
+	}
+}
@@ -0,0 +1,639 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"go/token"
+	"net"
+	"net/rpc"
+	"net/rpc/jsonrpc"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/zditech/dbasic/pkg/codegen"
+)
+
+// debug builds a program without optimizations and debugs it with Delve.
+// Delve serves its API to a command loop here that speaks DBasic: it sets
+// breakpoints and reports locations by DBasic file and line, through the
+// program's source map, and knows variables by their DBasic names.
+func debug(filename string, args []string) {
+	dlv, err := exec.LookPath("dlv")
+	if err != nil {
+		errorf("debug needs Delve; install it with: go install github.com/go-delve/delve/cmd/dlv@latest")
+		os.Exit(1)
+	}
+
+	result, err := compile(filename)
+	if err != nil {
+		compileFailed(result, err)
+	}
+	printErrors(result)
+	if len(result.Lines) == 0 {
+		errorf("%s has no statements to debug", filename)
+		os.Exit(1)
+	}
+
+	tempDir, err := makeWorkDir("dbasic-*")
+	if err != nil {
+		errorf("creating temp directory: %v", err)
+		os.Exit(1)
+	}
+	defer removeWorkDir(tempDir)
+
+	goFile := filepath.Join(tempDir, "main.go")
+	if err := os.WriteFile(goFile, []byte(result.GoCode), 0644); err != nil {
+		errorf("writing Go file: %v", err)
+		os.Exit(1)
+	}
+	if err := writeEmbeds(result, tempDir); err != nil {
+		errorf("%v", err)
+		os.Exit(1)
+	}
+	if err := initModule(tempDir, result.GoCode, result.LockFile); err != nil {
+		errorf("%v", err)
+		os.Exit(1)
+	}
+
+	// Without optimizations and inlining, every statement has its own
+	// code and every variable can be read
+	binary := filepath.Join(tempDir, "program")
+	if runtime.GOOS == "windows" {
+		binary += ".exe"
+	}
+	infof("building %s for debugging", binary)
+	goArgs := append(append([]string{"build"}, goFlags...), "-gcflags=all=-N -l", "-o", binary, ".")
+	if err := goCommand(tempDir, goArgs...).Run(); err != nil {
+		errorf("building executable: %v", err)
+		os.Exit(1)
+	}
+
+	s, err := startDebugger(dlv, binary, args)
+	if err != nil {
+		errorf("starting Delve: %v", err)
+		os.Exit(1)
+	}
+	s.goFile = goFile
+	s.lines = result.Lines
+	s.byGo = make(map[int]codegen.LineMapping)
+	for _, m := range result.Lines {
+		s.byGo[m.GoLine] = m
+	}
+	if abs, err := filepath.Abs(filename); err == nil && filename != stdinName {
+		s.mainFile = abs
+	} else {
+		s.mainFile = result.Lines[len(result.Lines)-1].File
+	}
+
+	s.runToMain()
+	s.loop()
+	s.stop()
+}
+
+// The parts of Delve's JSON-RPC API, version 2, that debug uses
+type (
+	dlvFunction struct {
+		Name string `json:"name"`
+	}
+	dlvLocation struct {
+		File     string       `json:"file"`
+		Line     int          `json:"line"`
+		Function *dlvFunction `json:"function"`
+	}
+	dlvState struct {
+		CurrentThread *dlvLocation `json:"currentThread"`
+		Exited        bool         `json:"exited"`
+		ExitStatus    int          `json:"exitStatus"`
+	}
+	dlvBreakpoint struct {
+		ID           int    `json:"id,omitempty"`
+		File         string `json:"file,omitempty"`
+		Line         int    `json:"line,omitempty"`
+		FunctionName string `json:"functionName,omitempty"`
+	}
+	dlvVariable struct {
+		Name       string        `json:"name"`
+		Type       string        `json:"type"`
+		Kind       reflect.Kind  `json:"kind"`
+		Value      string        `json:"value"`
+		Len        int64         `json:"len"`
+		Children   []dlvVariable `json:"children"`
+		Unreadable string        `json:"unreadable"`
+	}
+	dlvScope struct {
+		GoroutineID int64
+		Frame       int
+	}
+	dlvLoadConfig struct {
+		FollowPointers     bool
+		MaxVariableRecurse int
+		MaxStringLen       int
+		MaxArrayValues     int
+		MaxStructFields    int
+	}
+)
+
+// dlvLoad is how much of a variable Delve reads
+var dlvLoad = dlvLoadConfig{FollowPointers: true, MaxVariableRecurse: 1, MaxStringLen: 256, MaxArrayValues: 64, MaxStructFields: -1}
+
+// debugSession is a program being debugged under Delve
+type debugSession struct {
+	dlv      *exec.Cmd
+	client   *rpc.Client
+	goFile   string                      // Path of the generated Go code
+	mainFile string                      // DBasic file a breakpoint with only a line is in
+	lines    []codegen.LineMapping       // The program's source map
+	byGo     map[int]codegen.LineMapping // The source map by Go line
+	state    dlvState
+	frame    int // Stack frame of the DBasic statement last stopped in
+	sources  map[string][]string
+}
+
+// startDebugger starts Delve serving its API for binary and connects to it.
+// Delve passes on what the program writes.
+func startDebugger(dlv, binary string, args []string) (*debugSession, error) {
+	dlvArgs := append([]string{"exec", "--headless", "--api-version=2", "--listen=127.0.0.1:0", binary, "--"}, args...)
+	cmd := exec.Command(dlv, dlvArgs...)
+	cmd.Stderr = os.Stderr
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+
+	out := bufio.NewReader(stdout)
+	var addr string
+	for addr == "" {
+		line, err := out.ReadString('\n')
+		if err != nil {
+			cmd.Wait()
+			return nil, fmt.Errorf("delve exited")
+		}
+		if rest, ok := strings.CutPrefix(line, "API server listening at:"); ok {
+			addr = strings.TrimSpace(rest)
+		} else {
+			os.Stdout.WriteString(line)
+		}
+	}
+	go out.WriteTo(os.Stdout)
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		cmd.Process.Kill()
+		cmd.Wait()
+		return nil, err
+	}
+	return &debugSession{dlv: cmd, client: jsonrpc.NewClient(conn), sources: make(map[string][]string)}, nil
+}
+
+// call calls a method of Delve's RPCServer
+func (s *debugSession) call(method string, in, out interface{}) error {
+	return s.client.Call("RPCServer."+method, in, out)
+}
+
+// stop kills the program and Delve
+func (s *debugSession) stop() {
+	var out struct{}
+	s.call("Detach", struct{ Kill bool }{true}, &out)
+	s.client.Close()
+	s.dlv.Wait()
+}
+
+// runToMain runs the program to the start of Main, where debugging starts
+func (s *debugSession) runToMain() {
+	var out struct{ Breakpoint dlvBreakpoint }
+	if err := s.call("CreateBreakpoint", struct{ Breakpoint dlvBreakpoint }{dlvBreakpoint{FunctionName: "main.Main"}}, &out); err != nil {
+		return
+	}
+	s.command("continue")
+	var cleared struct{}
+	s.call("ClearBreakpoint", struct{ Id int }{out.Breakpoint.ID}, &cleared)
+	s.stepTo("next", -1)
+	s.showStop()
+}
+
+// command runs one of Delve's commands, such as continue or next
+func (s *debugSession) command(name string) error {
+	var out struct{ State dlvState }
+	if err := s.call("Command", map[string]string{"name": name}, &out); err != nil {
+		return err
+	}
+	s.state = out.State
+	return nil
+}
+
+// lookup returns the DBasic line that a Go location was generated from
+func (s *debugSession) lookup(loc *dlvLocation) (codegen.LineMapping, bool) {
+	if loc == nil || loc.File != s.goFile {
+		return codegen.LineMapping{}, false
+	}
+	m, ok := s.byGo[loc.Line]
+	return m, ok
+}
+
+// stepTo steps with a Delve command until the program stops on a DBasic
+// line other than the one at Go line from. Runtime helpers, which come
+// before the first statement in the Go code, and Go's own packages are
+// stepped out of; other unmapped lines, such as function headers, are
+// stepped over.
+func (s *debugSession) stepTo(name string, from int) error {
+	start := s.byGo[from]
+	for i := 0; i < 10000; i++ {
+		if s.state.Exited {
+			return nil
+		}
+		loc := s.state.CurrentThread
+		if m, ok := s.lookup(loc); ok && i > 0 && (m.File != start.File || m.Line != start.Line) {
+			return nil
+		}
+		next := name
+		switch {
+		case i == 0:
+		case loc == nil:
+			return nil
+		case loc.File != s.goFile || loc.Line < s.lines[0].GoLine:
+			next = "stepOut"
+		case name == "stepOut":
+			next = "next"
+		}
+		if err := s.command(next); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// showStop reports where the program stopped: the DBasic statement of the
+// innermost frame that has one
+func (s *debugSession) showStop() {
+	if s.state.Exited {
+		fmt.Printf("Program exited with status %d\n", s.state.ExitStatus)
+		return
+	}
+	s.frame = 0
+	frames := s.stack()
+	for i, f := range frames {
+		m, ok := s.lookup(&f)
+		if !ok {
+			continue
+		}
+		s.frame = i
+		if i > 0 && frames[0].Function != nil {
+			fmt.Printf("Stopped in %s, called from:\n", frames[0].Function.Name)
+		}
+		s.showSource(m.File, m.Line, 0)
+		return
+	}
+	if loc := s.state.CurrentThread; loc != nil {
+		fmt.Printf("Stopped at %s:%d\n", loc.File, loc.Line)
+	}
+}
+
+// stack returns the frames of the current goroutine, innermost first
+func (s *debugSession) stack() []dlvLocation {
+	var out struct{ Locations []dlvLocation }
+	in := struct {
+		Id    int64
+		Depth int
+	}{-1, 50}
+	s.call("Stacktrace", in, &out)
+	return out.Locations
+}
+
+// showSource prints a line of a DBasic file, with context lines either side
+func (s *debugSession) showSource(file string, line, context int) {
+	text, ok := s.sources[file]
+	if !ok {
+		data, _ := os.ReadFile(file)
+		text = strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+		s.sources[file] = text
+	}
+	fmt.Printf("> %s:%d\n", displayPath(file), line)
+	for n := line - context; n <= line+context; n++ {
+		if n < 1 || n > len(text) {
+			continue
+		}
+		marker := " "
+		if n == line {
+			marker = ">"
+		}
+		fmt.Printf("%s%4d | %s\n", marker, n, strings.TrimRight(text[n-1], "\r"))
+	}
+}
+
+const debugHelp = `Commands:
+  break [file:]line   Set a breakpoint (b); the file defaults to the main file
+  clear <n>           Delete breakpoint n
+  breakpoints         List breakpoints (bp)
+  continue            Run to the next breakpoint (c)
+  next                Run to the next line, over calls (n)
+  step                Run to the next line, into calls (s)
+  stepout             Run until the current routine returns (so)
+  print <expr>        Print an expression (p)
+  locals              Print the arguments and local variables
+  stack               Print the call stack (bt)
+  list                Show the source around the current line (l)
+  quit                Stop debugging (q)
+An empty line repeats the last command.`
+
+// loop reads and runs commands until quit or the program exits
+func (s *debugSession) loop() {
+	fmt.Println(`Type "help" for commands.`)
+	input := bufio.NewScanner(os.Stdin)
+	last := ""
+	for !s.state.Exited {
+		fmt.Print("(dbasic) ")
+		if !input.Scan() {
+			fmt.Println()
+			return
+		}
+		line := strings.TrimSpace(input.Text())
+		if line == "" {
+			line = last
+		}
+		last = line
+		cmd, arg, _ := strings.Cut(line, " ")
+		arg = strings.TrimSpace(arg)
+
+		var err error
+		switch cmd {
+		case "":
+		case "help", "h":
+			fmt.Println(debugHelp)
+		case "break", "b":
+			err = s.setBreakpoint(arg)
+		case "clear":
+			id, _ := strconv.Atoi(arg)
+			var out struct{}
+			err = s.call("ClearBreakpoint", struct{ Id int }{id}, &out)
+		case "breakpoints", "bp":
+			err = s.listBreakpoints()
+		case "continue", "c":
+			if err = s.command("continue"); err == nil {
+				s.showStop()
+			}
+		case "next", "n", "step", "s", "stepout", "so":
+			name := map[string]string{"n": "next", "s": "step", "so": "stepOut", "stepout": "stepOut"}[cmd]
+			if name == "" {
+				name = cmd
+			}
+			from := 0
+			if loc := s.state.CurrentThread; loc != nil {
+				from = loc.Line
+			}
+			if err = s.stepTo(name, from); err == nil {
+				s.showStop()
+			}
+		case "print", "p":
+			err = s.print(arg)
+		case "locals":
+			err = s.locals()
+		case "stack", "bt":
+			s.printStack()
+		case "list", "l":
+			if m, ok := s.currentLine(); ok {
+				s.showSource(m.File, m.Line, 5)
+			}
+		case "quit", "q", "exit":
+			return
+		default:
+			err = fmt.Errorf("unknown command %q; type help for commands", cmd)
+		}
+		if err != nil {
+			fmt.Printf("error: %v\n", err)
+		}
+	}
+}
+
+// currentLine returns the DBasic line of the frame last stopped in
+func (s *debugSession) currentLine() (codegen.LineMapping, bool) {
+	frames := s.stack()
+	if s.frame >= len(frames) {
+		return codegen.LineMapping{}, false
+	}
+	return s.lookup(&frames[s.frame])
+}
+
+// setBreakpoint sets a breakpoint on the first Go line of a DBasic line, or
+// of the next line that has code
+func (s *debugSession) setBreakpoint(arg string) error {
+	file, lineText := s.mainFile, arg
+	if i := strings.LastIndex(arg, ":"); i >= 0 {
+		file, lineText = arg[:i], arg[i+1:]
+	}
+	line, err := strconv.Atoi(lineText)
+	if err != nil {
+		return fmt.Errorf("usage: break [file:]line")
+	}
+
+	var best *codegen.LineMapping
+	for i, m := range s.lines {
+		if !sameSourceFile(m.File, file) || m.Line < line {
+			continue
+		}
+		if best == nil || m.Line < best.Line {
+			best = &s.lines[i]
+		}
+	}
+	if best == nil {
+		return fmt.Errorf("no code at or after %s:%d", file, line)
+	}
+
+	var out struct{ Breakpoint dlvBreakpoint }
+	bp := dlvBreakpoint{File: s.goFile, Line: best.GoLine}
+	if err := s.call("CreateBreakpoint", struct{ Breakpoint dlvBreakpoint }{bp}, &out); err != nil {
+		return err
+	}
+	fmt.Printf("Breakpoint %d at %s:%d\n", out.Breakpoint.ID, displayPath(best.File), best.Line)
+	return nil
+}
+
+// sameSourceFile reports whether the absolute path of a DBasic file is the
+// file a user named, by its path or its name
+func sameSourceFile(path, name string) bool {
+	if abs, err := filepath.Abs(name); err == nil && abs == path {
+		return true
+	}
+	return filepath.Base(path) == name
+}
+
+func (s *debugSession) listBreakpoints() error {
+	var out struct{ Breakpoints []dlvBreakpoint }
+	if err := s.call("ListBreakpoints", struct{}{}, &out); err != nil {
+		return err
+	}
+	for _, bp := range out.Breakpoints {
+		// Delve's own breakpoints, such as the one on unrecovered
+		// panics, have negative IDs
+		if bp.ID < 0 {
+			continue
+		}
+		if m, ok := s.lookup(&dlvLocation{File: bp.File, Line: bp.Line}); ok {
+			fmt.Printf("%d  %s:%d\n", bp.ID, displayPath(m.File), m.Line)
+		}
+	}
+	return nil
+}
+
+// printStack prints the frames of the call stack that run DBasic
+// statements
+func (s *debugSession) printStack() {
+	for i, f := range s.stack() {
+		m, ok := s.lookup(&f)
+		if !ok {
+			continue
+		}
+		name := "?"
+		if f.Function != nil {
+			name = strings.TrimPrefix(f.Function.Name, "main.")
+		}
+		fmt.Printf("#%d  %s at %s:%d\n", i, name, displayPath(m.File), m.Line)
+	}
+}
+
+// variables returns the arguments and locals of the frame last stopped in
+func (s *debugSession) variables() ([]dlvVariable, error) {
+	in := struct {
+		Scope dlvScope
+		Cfg   dlvLoadConfig
+	}{dlvScope{GoroutineID: -1, Frame: s.frame}, dlvLoad}
+	var args, locals struct{ Args, Variables []dlvVariable }
+	if err := s.call("ListFunctionArgs", in, &args); err != nil {
+		return nil, err
+	}
+	if err := s.call("ListLocalVars", in, &locals); err != nil {
+		return nil, err
+	}
+	return append(args.Args, locals.Variables...), nil
+}
+
+func (s *debugSession) locals() error {
+	vars, err := s.variables()
+	if err != nil {
+		return err
+	}
+	sort.SliceStable(vars, func(i, j int) bool { return strings.ToLower(vars[i].Name) < strings.ToLower(vars[j].Name) })
+	for _, v := range vars {
+		if name := dbasicName(v.Name); name != "" {
+			fmt.Printf("%s = %s\n", name, formatVariable(v))
+		}
+	}
+	return nil
+}
+
+// identRe matches a DBasic identifier in an expression
+var identRe = regexp.MustCompile(`[A-Za-z_][A-Za-z0-9_]*\$?`)
+
+// print evaluates an expression in the frame last stopped in. Its names
+// are DBasic's, which are matched to the Go variables they became
+// regardless of case.
+func (s *debugSession) print(expr string) error {
+	if expr == "" {
+		return fmt.Errorf("usage: print <expr>")
+	}
+	names := make(map[string]string)
+	var globals struct{ Variables []dlvVariable }
+	s.call("ListPackageVars", struct {
+		Filter string
+		Cfg    dlvLoadConfig
+	}{`^main\.`, dlvLoadConfig{}}, &globals)
+	vars, err := s.variables()
+	if err != nil {
+		return err
+	}
+	for _, v := range append(globals.Variables, vars...) {
+		if name := dbasicName(v.Name); name != "" {
+			names[strings.ToLower(name)] = v.Name
+		}
+	}
+	goExpr := identRe.ReplaceAllStringFunc(expr, func(name string) string {
+		if goName, ok := names[strings.ToLower(name)]; ok {
+			return goName
+		}
+		if strings.HasSuffix(name, "$") {
+			return strings.TrimSuffix(name, "$") + "_str"
+		}
+		return name
+	})
+
+	in := struct {
+		Scope dlvScope
+		Expr  string
+		Cfg   *dlvLoadConfig
+	}{dlvScope{GoroutineID: -1, Frame: s.frame}, goExpr, &dlvLoad}
+	var out struct{ Variable *dlvVariable }
+	if err := s.call("Eval", in, &out); err != nil {
+		return err
+	}
+	fmt.Println(formatVariable(*out.Variable))
+	return nil
+}
+
+// dbasicName returns the DBasic name of a Go variable, or "" for the
+// compiler's own variables
+func dbasicName(goName string) string {
+	name := strings.TrimPrefix(goName, "main.")
+	switch {
+	case name == "_errNumber":
+		return "ERR"
+	case name == "_errLine":
+		return "ERL"
+	case strings.HasPrefix(name, "_"), strings.HasPrefix(name, "~"), strings.HasPrefix(name, "."):
+		return ""
+	case strings.HasSuffix(name, "_str"):
+		return strings.TrimSuffix(name, "_str") + "$"
+	case strings.HasSuffix(name, "_") && token.Lookup(strings.TrimSuffix(name, "_")).IsKeyword():
+		return strings.TrimSuffix(name, "_")
+	}
+	return name
+}
+
+// formatVariable formats a value Delve read
+func formatVariable(v dlvVariable) string {
+	if v.Unreadable != "" {
+		return "<unreadable: " + v.Unreadable + ">"
+	}
+	more := ""
+	if v.Len > int64(len(v.Children)) {
+		more = ", ..."
+	}
+	var parts []string
+	switch v.Kind {
+	case reflect.String:
+		return strconv.Quote(v.Value)
+	case reflect.Slice, reflect.Array:
+		for _, c := range v.Children {
+			parts = append(parts, formatVariable(c))
+		}
+		return "[" + strings.Join(parts, ", ") + more + "]"
+	case reflect.Struct:
+		for _, c := range v.Children {
+			parts = append(parts, c.Name+": "+formatVariable(c))
+		}
+		return "{" + strings.Join(parts, ", ") + "}"
+	case reflect.Map:
+		// Keys and values alternate
+		for i := 0; i+1 < len(v.Children); i += 2 {
+			parts = append(parts, formatVariable(v.Children[i])+": "+formatVariable(v.Children[i+1]))
+		}
+		return "{" + strings.Join(parts, ", ") + more + "}"
+	case reflect.Ptr, reflect.Interface:
+		if len(v.Children) == 0 || v.Value == "nil" {
+			return "NOTHING"
+		}
+		return formatVariable(v.Children[0])
+	}
+	if v.Value == "" {
+		return v.Type
+	}
+	return v.Value
+}
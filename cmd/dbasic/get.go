@@ -0,0 +1,147 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/zditech/dbasic/pkg/project"
+)
+
+// get fetches DBasic libraries into the project's libraries directory and
+// records them in its manifest. Each argument is a git URL, optionally
+// followed by @ and a tag, branch or commit; without one the latest commit
+// is fetched and recorded. With no arguments, the libraries the manifest
+// lists are fetched at their recorded refs.
+func get(args []string) {
+	p, err := project.Find(".")
+	if err == project.ErrNoManifest {
+		errorf("get needs a project, and no %s was found", project.ManifestName)
+		fmt.Fprintln(os.Stderr, "Usage: dbasic get [url[@ref]...]")
+		os.Exit(1)
+	}
+	if err != nil {
+		errorf("%v", err)
+		os.Exit(1)
+	}
+
+	if len(args) == 0 {
+		if len(p.Libraries) == 0 {
+			fmt.Printf("no libraries in %s\n", project.ManifestName)
+		}
+		failed := false
+		for _, lib := range p.Libraries {
+			if _, err := fetchLibrary(p, lib); err != nil {
+				errorf("%s: %v", lib.URL, err)
+				failed = true
+				continue
+			}
+			fmt.Printf("%s %s\n", lib.Name(), lib.Ref)
+		}
+		if failed {
+			os.Exit(1)
+		}
+		return
+	}
+
+	for _, arg := range args {
+		lib := splitRef(arg)
+		for _, other := range p.Libraries {
+			if other.URL != lib.URL && strings.EqualFold(other.Name(), lib.Name()) {
+				errorf("%s: library %s is already fetched from %s", lib.URL, lib.Name(), other.URL)
+				os.Exit(1)
+			}
+		}
+		commit, err := fetchLibrary(p, lib)
+		if err != nil {
+			errorf("%s: %v", lib.URL, err)
+			os.Exit(1)
+		}
+		if lib.Ref == "" {
+			lib.Ref = commit
+		}
+		if err := project.SetLibrary(filepath.Join(p.Dir, project.ManifestName), lib); err != nil {
+			errorf("recording %s: %v", lib.URL, err)
+			os.Exit(1)
+		}
+		p.Libraries = append(p.Libraries, lib)
+		fmt.Printf("%s %s\n", lib.Name(), lib.Ref)
+	}
+}
+
+// splitRef splits url@ref. An @ before the path, as in git@host:repo, is
+// part of the URL.
+func splitRef(arg string) project.Library {
+	i := strings.LastIndex(arg, "@")
+	if i < 0 || i < strings.LastIndexAny(arg, "/:") {
+		return project.Library{URL: arg}
+	}
+	return project.Library{URL: arg[:i], Ref: arg[i+1:]}
+}
+
+// cloneURL returns the URL to clone a library from. A URL without a scheme,
+// such as github.com/someone/strutil, is fetched over https unless it is a
+// local directory.
+func cloneURL(url string) string {
+	if strings.Contains(url, "://") || strings.HasPrefix(url, "git@") {
+		return url
+	}
+	if info, err := os.Stat(url); err == nil && info.IsDir() {
+		abs, err := filepath.Abs(url)
+		if err == nil {
+			return abs
+		}
+		return url
+	}
+	return "https://" + url
+}
+
+// fetchLibrary clones a library, or fetches into an existing clone, checks
+// out its ref (the default branch if it has none) and returns the commit
+func fetchLibrary(p *project.Project, lib project.Library) (string, error) {
+	// git would take a ref starting with - for an option
+	if strings.HasPrefix(lib.Ref, "-") {
+		return "", fmt.Errorf("invalid ref %q", lib.Ref)
+	}
+	dir := p.LibraryDir(lib)
+	if _, err := os.Stat(dir); os.IsNotExist(err) {
+		infof("cloning %s into %s", cloneURL(lib.URL), dir)
+		if err := os.MkdirAll(filepath.Dir(dir), 0755); err != nil {
+			return "", err
+		}
+		if _, err := git("", "clone", "--quiet", "--", cloneURL(lib.URL), dir); err != nil {
+			return "", err
+		}
+	} else if _, err := git(dir, "fetch", "--quiet", "--tags", "origin"); err != nil {
+		return "", err
+	}
+
+	// A branch is checked out as it is on the remote
+	target := "origin/HEAD"
+	if lib.Ref != "" {
+		target = lib.Ref
+		if _, err := git(dir, "rev-parse", "--verify", "--quiet", "origin/"+lib.Ref+"^{commit}"); err == nil {
+			target = "origin/" + lib.Ref
+		}
+	}
+	if _, err := git(dir, "checkout", "--quiet", "--detach", target, "--"); err != nil {
+		return "", err
+	}
+	return git(dir, "rev-parse", "HEAD")
+}
+
+// git runs a git command in dir and returns its trimmed output
+func git(dir string, args ...string) (string, error) {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		if msg := strings.TrimSpace(string(out)); msg != "" {
+			return "", fmt.Errorf("git %s: %s", args[0], msg)
+		}
+		return "", fmt.Errorf("git %s: %v", args[0], err)
+	}
+	return strings.TrimSpace(string(out)), nil
+}
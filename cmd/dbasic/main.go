@@ -1,26 +1,53 @@
 package main
 
 import (
+	"encoding/json"
 	"flag"
 	"fmt"
+	"go/format"
+	"go/token"
+	"io"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"regexp"
+	"runtime"
+	"strconv"
 	"strings"
+	"unicode"
 
 	"github.com/zditech/dbasic/pkg/analyzer"
 	"github.com/zditech/dbasic/pkg/codegen"
+	"github.com/zditech/dbasic/pkg/doc"
+	dberrors "github.com/zditech/dbasic/pkg/errors"
 	"github.com/zditech/dbasic/pkg/lexer"
+	"github.com/zditech/dbasic/pkg/lsp"
 	"github.com/zditech/dbasic/pkg/parser"
+	"github.com/zditech/dbasic/pkg/plugin"
 	"github.com/zditech/dbasic/pkg/preprocessor"
+	"github.com/zditech/dbasic/pkg/project"
 )
 
 const version = "0.2.0"
 
 var (
 	debugMode   bool
+	releaseMode bool
 	verboseMode bool
 	outputFile  string
+	jsonMode    bool                // Print diagnostics (or ast and tokens) as JSON
+	testMode    bool                // Compiling for dbasic test: TEST and BENCHMARK blocks are generated
+	vetMode     bool                // Compiling for dbasic vet: suspicious constructs are reported as warnings
+	benchFilter *regexp.Regexp      // BENCHMARK blocks to run, from test -bench
+	benchTime   string              // How long to run each benchmark, from test -benchtime
+	libraryPkg  string              // Compiling a Go package of this name, for build -lib
+	keepWork    bool                // Keep temporary build directories, for -work
+	writeMap    bool                // Write a source map next to the output, for -map
+	includeDirs []string            // INCLUDE search path from -I flags
+	goFlags     []string            // Extra go build flags for build and run, from -goflags
+	manifest    *project.Project    // Project being compiled, when no file is given
+	config      = &project.Config{} // Defaults from the user's configuration
+	suppressed  = map[string]bool{} // Codes of warnings not to report
 )
 
 func main() {
@@ -31,44 +58,182 @@ func main() {
 
 	command := os.Args[1]
 
+	// The user's configuration gives the defaults of flags
+	cfg, err := project.LoadConfig(project.ConfigPath())
+	if err != nil {
+		errorf("%v", err)
+		os.Exit(1)
+	}
+	config = cfg
+
 	// Handle flags after command
 	flagSet := flag.NewFlagSet(command, flag.ExitOnError)
 	flagSet.BoolVar(&debugMode, "debug", false, "Enable debug mode (include source line comments)")
-	flagSet.BoolVar(&verboseMode, "v", false, "Verbose output")
+	flagSet.BoolVar(&releaseMode, "release", false, "Strip ASSERT statements")
+	flagSet.BoolVar(&verboseMode, "v", config.Verbose, "Verbose output")
 	flagSet.StringVar(&outputFile, "o", "", "Output file name")
+	flagSet.BoolVar(&jsonMode, "json", false, "Print errors and warnings as JSON")
+	flagSet.Func("I", "Directory to search for INCLUDEd files (repeatable)", func(dir string) error {
+		includeDirs = append(includeDirs, dir)
+		return nil
+	})
+	flagSet.BoolVar(&keepWork, "work", false, "Print the temporary build directory and keep it")
+	flagSet.BoolVar(&writeMap, "map", false, "Write a source map of Go lines to DBasic lines next to the output")
+	flagSet.Func("goflags", "Flags passed on to go build, such as \"-race -ldflags='-s -w'\"", func(s string) error {
+		flags, err := splitGoFlags(s)
+		goFlags = append(goFlags, flags...)
+		return err
+	})
+	flagSet.Func("suppress", "Comma-separated codes of warnings not to report", suppress)
 
 	switch command {
 	case "build":
-		if len(os.Args) < 3 {
-			errorf("no input file specified")
-			fmt.Fprintln(os.Stderr, "Usage: dbasic build [-o output] [-debug] <file.dbas>")
-			os.Exit(1)
+		lib := flagSet.Bool("lib", false, "Build a Go package instead of a program")
+		flagSet.StringVar(&libraryPkg, "pkg", "", "Package name for -lib")
+		jobs := flagSet.Int("j", runtime.NumCPU(), "Number of programs to build at once")
+		filename, rest := parseArgs(flagSet, false)
+		setBuildTarget()
+		if filename != "" && filename != stdinName {
+			files, given := buildArgs(flagSet, filename, rest)
+			if len(files) > 1 {
+				if *lib {
+					errorf("-lib builds one package at a time")
+					os.Exit(1)
+				}
+				buildBatch(files, given, outputFile, *jobs)
+				return
+			}
+			filename = files[0]
+		}
+		if filename == "" {
+			filename = loadProject("Usage: dbasic build [-o output] [-lib [-pkg name]] [-debug] [-release] [file.dbas]")
+			if outputFile == "" && !*lib {
+				outputFile = manifest.Output
+			}
+		}
+		if *lib {
+			buildLibrary(filename, outputFile)
+		} else {
+			build(filename, outputFile)
 		}
-		flagSet.Parse(os.Args[3:])
-		build(os.Args[2], outputFile)
 	case "run":
-		if len(os.Args) < 3 {
-			errorf("no input file specified")
-			fmt.Fprintln(os.Stderr, "Usage: dbasic run [-debug] <file.dbas>")
-			os.Exit(1)
+		filename, args := parseArgs(flagSet, true)
+		if filename == "" {
+			filename = loadProject("Usage: dbasic run [-debug] [-release] [file.dbas] [-- args...]")
+		}
+		run(filename, args)
+	case "debug":
+		filename, args := parseArgs(flagSet, true)
+		if filename == "" {
+			filename = loadProject("Usage: dbasic debug [file.dbas] [-- args...]")
 		}
-		flagSet.Parse(os.Args[3:])
-		run(os.Args[2])
+		debug(filename, args)
 	case "emit":
-		if len(os.Args) < 3 {
-			errorf("no input file specified")
-			fmt.Fprintln(os.Stderr, "Usage: dbasic emit [-debug] <file.dbas>")
-			os.Exit(1)
+		filename, _ := parseArgs(flagSet, false)
+		if filename == "" {
+			filename = loadProject("Usage: dbasic emit [-o output.go] [-debug] [-release] [file.dbas]")
 		}
-		flagSet.Parse(os.Args[3:])
-		emit(os.Args[2])
+		emit(filename, outputFile)
 	case "check":
-		if len(os.Args) < 3 {
+		filename, _ := parseArgs(flagSet, false)
+		if filename == "" {
+			filename = loadProject("Usage: dbasic check [file.dbas]")
+		}
+		check(filename)
+	case "vet":
+		filename, _ := parseArgs(flagSet, false)
+		if filename == "" {
+			filename = loadProject("Usage: dbasic vet [-suppress codes] [file.dbas]")
+		}
+		vet(filename)
+	case "test":
+		flagSet.Func("bench", "Also run BENCHMARK blocks whose names match this regular expression", func(s string) error {
+			re, err := regexp.Compile(s)
+			benchFilter = re
+			return err
+		})
+		flagSet.StringVar(&benchTime, "benchtime", "", "Time or iterations (such as 100x) to run each benchmark for")
+		flagSet.Parse(os.Args[2:])
+		files := flagSet.Args()
+		if len(files) == 0 {
+			files, _ = filepath.Glob("*_test.dbas")
+		}
+		if len(files) == 0 {
+			errorf("no test files found")
+			fmt.Fprintln(os.Stderr, "Usage: dbasic test [-v] [-bench regexp [-benchtime t]] [file.dbas...]   (default: *_test.dbas)")
+			os.Exit(1)
+		}
+		if !runTests(files) {
+			os.Exit(1)
+		}
+	case "ast", "tokens":
+		filename, _ := parseArgs(flagSet, false)
+		if filename == "" {
 			errorf("no input file specified")
-			fmt.Fprintln(os.Stderr, "Usage: dbasic check <file.dbas>")
+			fmt.Fprintf(os.Stderr, "Usage: dbasic %s [-json] <file.dbas>\n", command)
+			os.Exit(1)
+		}
+		if command == "ast" {
+			dumpAST(filename, jsonMode)
+		} else {
+			dumpTokens(filename, jsonMode)
+		}
+	case "doc":
+		asHTML := flagSet.Bool("html", false, "Write HTML instead of Markdown")
+		flagSet.Parse(os.Args[2:])
+		files := flagSet.Args()
+		title := ""
+		if len(files) == 0 {
+			files, title = docFiles()
+		}
+		if len(files) == 0 {
+			errorf("no .dbas files found")
+			fmt.Fprintln(os.Stderr, "Usage: dbasic doc [-html] [-o output] [file.dbas...]")
+			os.Exit(1)
+		}
+		writeDocs(files, title, *asHTML, outputFile)
+	case "bind":
+		flagSet.Parse(os.Args[2:])
+		if flagSet.NArg() != 1 {
+			fmt.Fprintln(os.Stderr, "Usage: dbasic bind [-o file.dbasi] <import path>")
+			os.Exit(1)
+		}
+		bind(flagSet.Arg(0), outputFile)
+	case "get":
+		flagSet.Parse(os.Args[2:])
+		get(flagSet.Args())
+	case "new":
+		flagSet.Parse(os.Args[2:])
+		if flagSet.NArg() != 2 {
+			fmt.Fprintln(os.Stderr, "Usage: dbasic new cli|tui|gui|web <name>")
+			os.Exit(1)
+		}
+		newProject(flagSet.Arg(0), flagSet.Arg(1))
+	case "daemon":
+		stop := flagSet.Bool("stop", false, "Stop the running daemon")
+		flagSet.Parse(os.Args[2:])
+		if *stop {
+			stopDaemon(daemonSocket())
+		} else {
+			serveDaemon(daemonSocket())
+		}
+	case "clean":
+		all := flagSet.Bool("all", false, "Also remove the whole cache")
+		dryRun := flagSet.Bool("n", false, "Print what would be removed without removing it")
+		flagSet.Parse(os.Args[2:])
+		clean(flagSet.Args(), *all, *dryRun)
+	case "completion":
+		flagSet.Parse(os.Args[2:])
+		completion(flagSet.Arg(0))
+	case "lsp":
+		// Serve the Language Server Protocol on stdin/stdout for editors
+		flagSet.Parse(os.Args[2:])
+		server := lsp.NewServer(os.Stdin, os.Stdout)
+		server.SetIncludeDirs(append(append([]string{}, includeDirs...), config.Include...))
+		if err := server.Run(); err != nil {
+			errorf("lsp: %v", err)
 			os.Exit(1)
 		}
-		check(os.Args[2])
 	case "version", "-version", "--version":
 		fmt.Printf("DBasic Compiler v%s\n", version)
 	case "help", "-help", "--help", "-h":
@@ -76,7 +241,7 @@ func main() {
 	default:
 		// Treat as file if it ends with .dbas
 		if strings.HasSuffix(command, ".dbas") {
-			run(command)
+			run(command, os.Args[2:])
 		} else {
 			errorf("unknown command: %s", command)
 			printUsage()
@@ -85,6 +250,129 @@ func main() {
 	}
 }
 
+// parseArgs parses a command's flags, which may come before or after its
+// input file, and returns the file ("" if there is none) and the arguments
+// left over. For run, which passes arguments on to the program, only a name
+// ending in .dbas is taken as the file. A file named - is read from stdin,
+// and stdinName is returned for it.
+func parseArgs(flagSet *flag.FlagSet, takesArgs bool) (string, []string) {
+	flagSet.Parse(os.Args[2:])
+	rest := flagSet.Args()
+	if len(rest) == 0 || (takesArgs && rest[0] != "-" && !strings.HasSuffix(rest[0], ".dbas")) {
+		return "", rest
+	}
+	flagSet.Parse(rest[1:])
+	if rest[0] == "-" {
+		return readStdin(), flagSet.Args()
+	}
+	return rest[0], flagSet.Args()
+}
+
+// stdinName is the file name errors give for source read from stdin.
+// INCLUDEs in it are relative to the current directory.
+const stdinName = "<stdin>"
+
+// stdinSource is the source read from stdin, when the input file is -
+var stdinSource string
+
+// readStdin reads the program from stdin and returns stdinName
+func readStdin() string {
+	data, err := io.ReadAll(os.Stdin)
+	if err != nil {
+		errorf("reading stdin: %v", err)
+		os.Exit(1)
+	}
+	stdinSource = string(data)
+	return stdinName
+}
+
+// newPreprocessor returns a preprocessor for a program whose main file is
+// filename. INCLUDEs search the -I directories, then the include
+// directories of the configuration, then the libraries of the project,
+// then $DBASIC_PATH.
+func newPreprocessor(filename string) *preprocessor.Preprocessor {
+	pp := preprocessor.New(filepath.Dir(filename))
+	dirs := append(append([]string{}, includeDirs...), config.Include...)
+	dirs = append(dirs, project.SearchPath(filepath.Dir(filename))...)
+	pp.SetSearchPath(append(dirs, preprocessor.EnvSearchPath()...))
+	return pp
+}
+
+// loadPlugins loads the plugins of the project a program's main file
+// belongs to
+func loadPlugins(filename string) ([]*plugin.Plugin, error) {
+	plugins, err := plugin.LoadAll(project.PluginDirs(filepath.Dir(filename)))
+	for _, p := range plugins {
+		infof("plugin %s: %d builtin(s)", p.Name, len(p.Builtins))
+	}
+	return plugins, err
+}
+
+// preprocessFiles expands the INCLUDEs in a program's files, the first of
+// which is the main file and may be stdinName
+func preprocessFiles(pp *preprocessor.Preprocessor, files []string) (*preprocessor.Result, error) {
+	if files[0] == stdinName {
+		return pp.ProcessSource(stdinName, stdinSource)
+	}
+	return pp.ProcessFiles(files)
+}
+
+// splitGoFlags splits the value of -goflags into flags at spaces outside
+// quotes, so that one flag such as -ldflags='-s -w' may contain spaces
+func splitGoFlags(s string) ([]string, error) {
+	var flags []string
+	var flag strings.Builder
+	var quote rune
+	inFlag := false
+	for _, c := range s {
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			} else {
+				flag.WriteRune(c)
+			}
+		case c == '\'' || c == '"':
+			quote = c
+			inFlag = true
+		case c == ' ' || c == '\t' || c == '\n':
+			if inFlag {
+				flags = append(flags, flag.String())
+				flag.Reset()
+				inFlag = false
+			}
+		default:
+			flag.WriteRune(c)
+			inFlag = true
+		}
+	}
+	if quote != 0 {
+		return nil, fmt.Errorf("unterminated %c in %q", quote, s)
+	}
+	if inFlag {
+		flags = append(flags, flag.String())
+	}
+	return flags, nil
+}
+
+// loadProject finds the dbasic.toml for a command run without an input
+// file and returns the project's main file
+func loadProject(usage string) string {
+	p, err := project.Find(".")
+	if err == project.ErrNoManifest {
+		errorf("no input file specified and no %s found", project.ManifestName)
+		fmt.Fprintln(os.Stderr, usage)
+		os.Exit(1)
+	}
+	if err != nil {
+		errorf("%v", err)
+		os.Exit(1)
+	}
+	infof("project %s (%s)", p.Name, filepath.Join(p.Dir, project.ManifestName))
+	manifest = p
+	return p.Main
+}
+
 // errorf prints an error message to stderr
 func errorf(format string, args ...interface{}) {
 	fmt.Fprintf(os.Stderr, "error: "+format+"\n", args...)
@@ -108,51 +396,122 @@ func printUsage() {
 	fmt.Println("Usage: dbasic <command> [options] [arguments]")
 	fmt.Println("")
 	fmt.Println("Commands:")
-	fmt.Println("  build <file.dbas>     Compile to executable")
-	fmt.Println("  run <file.dbas>       Compile and run")
-	fmt.Println("  emit <file.dbas>      Output generated Go code")
-	fmt.Println("  check <file.dbas>     Check for errors without compiling")
+	fmt.Println("  build [file.dbas...]  Compile to executables (default: the dbasic.toml project)")
+	fmt.Println("  run [file.dbas]       Compile and run")
+	fmt.Println("  debug [file.dbas]     Compile and step through with Delve")
+	fmt.Println("  emit [file.dbas]      Output generated Go code, formatted with gofmt")
+	fmt.Println("  check [file.dbas]     Check for errors without compiling")
+	fmt.Println("  vet [file.dbas]       Check for constructs that are probably mistakes")
+	fmt.Println("  test [file.dbas...]   Run TEST blocks, and BENCHMARK blocks with -bench (default: *_test.dbas)")
+	fmt.Println("  doc [file.dbas...]    Write API docs from declaration comments")
+	fmt.Println("  get [url[@ref]...]    Fetch DBasic libraries into the project")
+	fmt.Println("  new <kind> <name>     Start a project: cli, tui (Bubble Tea), gui (Walk) or web (HTTP)")
+	fmt.Println("  bind <import path>    Write a Go package's declarations, so calls into it are checked")
+	fmt.Println("  clean [file.dbas...]  Remove executables and stale build files (-all: the cache too)")
+	fmt.Println("  ast <file.dbas>       Print the parse tree")
+	fmt.Println("  tokens <file.dbas>    Print the token stream")
+	fmt.Println("  lsp                   Start a language server on stdin/stdout")
+	fmt.Println("  daemon [-stop]        Serve fast rebuilds to build and run")
+	fmt.Println("  completion <shell>    Print a completion script for bash, zsh, fish or powershell")
 	fmt.Println("  version               Print version")
 	fmt.Println("  help                  Print this help")
 	fmt.Println("")
 	fmt.Println("Options:")
-	fmt.Println("  -o <file>             Output file name (for build)")
+	fmt.Println("  -o <file>             Output file name (for build, emit, doc and bind);")
+	fmt.Println("                        the output directory when building several files")
+	fmt.Println("  -j <n>                Programs to build at once (default: the number of CPUs)")
+	fmt.Println("  -lib                  Build a Go package instead of a program (for build)")
+	fmt.Println("  -pkg <name>           Package name for -lib (default: the output directory)")
+	fmt.Println("  -html                 Write HTML docs instead of Markdown (for doc)")
+	fmt.Println("  -json                 Print errors and warnings as JSON lines on stderr;")
+	fmt.Println("                        for ast and tokens, print JSON")
+	fmt.Println("  -suppress <codes>     Do not report warnings with these codes, e.g. DB3001")
 	fmt.Println("  -debug                Include source line comments in output")
-	fmt.Println("  -v                    Verbose output")
+	fmt.Println("  -map                  Write a source map, output.map, next to the output (for build and emit)")
+	fmt.Println("  -release              Skip ASSERT checks")
+	fmt.Println("  -v                    Verbose output (test: show output of passing tests)")
+	fmt.Println("  -bench <regexp>       Also run the BENCHMARK blocks whose names match (for test)")
+	fmt.Println("  -benchtime <t>        Run each benchmark for t, e.g. 5s or 1000x (for test)")
+	fmt.Println("  -I <dir>              Also look for INCLUDEd files in dir (repeatable)")
+	fmt.Println("  -work                 Print the temporary build directory and keep it")
+	fmt.Println("  -goflags <flags>      Flags for go build (for build and run), e.g. \"-race\"")
 	fmt.Println("")
 	fmt.Println("Examples:")
 	fmt.Println("  dbasic build hello.dbas           # Creates hello executable")
 	fmt.Println("  dbasic build -o myapp hello.dbas  # Creates myapp executable")
+	fmt.Println("  dbasic build                      # Builds the project in dbasic.toml")
+	fmt.Println("  dbasic build -o bin tools/*.dbas  # Builds each file into bin/")
+	fmt.Println("  dbasic build -lib -pkg geo geo.dbas  # Writes the Go package geo/geo.go")
 	fmt.Println("  dbasic run hello.dbas             # Compile and run")
+	fmt.Println("  dbasic build -goflags \"-trimpath -ldflags='-s -w'\" app.dbas  # Smaller binary")
+	fmt.Println("  dbasic run app.dbas -- a b        # Run with arguments a and b")
 	fmt.Println("  dbasic emit hello.dbas            # Print Go code to stdout")
+	fmt.Println("  dbasic emit -o hello.go hello.dbas  # Write Go code to hello.go")
+	fmt.Println("  gen | dbasic run -                # Run a program read from stdin")
 	fmt.Println("  dbasic check hello.dbas           # Syntax/semantic check only")
+	fmt.Println("  dbasic vet -suppress DB3004 app.dbas  # Vet all but discarded SPAWN results")
+	fmt.Println("  dbasic test                       # Run the tests in *_test.dbas")
+	fmt.Println("  dbasic test -bench .              # Run the tests and benchmarks")
+	fmt.Println("  dbasic doc -html -o api.html lib.dbas  # Document lib.dbas as HTML")
+	fmt.Println("  dbasic get github.com/someone/strutil@v0.2.0  # Add a library")
+	fmt.Println("  dbasic new tui todo               # Start a Bubble Tea project in todo/")
+	fmt.Println("  dbasic bind net/http              # Write net_http.dbasi for IMPORT \"net/http\"")
 }
 
 // CompileResult holds the result of compilation
 type CompileResult struct {
 	GoCode     string
+	TestCode   string             // Go test file, in test mode
+	Embeds     []codegen.Embed    // Files to copy next to the Go code; Path is absolute
+	LockFile   string             // dbasic.lock recording the Go module versions, or ""
+	Tests      []codegen.TestCase // TEST blocks, in test mode
+	Benchmarks []codegen.TestCase // BENCHMARK blocks, in test mode
 	SourceFile string
 	Errors     []CompileError
 	Warnings   []CompileError
+	Lines      []codegen.LineMapping // DBasic line of the statement lines of GoCode; File is absolute
 }
 
 // CompileError represents a compilation error with location
 type CompileError struct {
-	File    string
-	Line    int
-	Column  int
-	Message string
-	Phase   string // "lexer", "parser", "analyzer", "codegen"
+	File    string `json:"file"`
+	Line    int    `json:"line,omitempty"`
+	Column  int    `json:"column,omitempty"`
+	Message string `json:"message"`
+	Code    string `json:"code,omitempty"` // Stable identifier, such as DB2001
+	Hint    string `json:"hint,omitempty"`
+	Phase   string `json:"phase"` // "preprocessor", "parser", "analyzer", "vet", "codegen"
+	Text    string `json:"-"`     // The message with source context, as printed
 }
 
 func (e CompileError) String() string {
+	msg := e.Message
+	if e.Code != "" {
+		msg = fmt.Sprintf("%s [%s]", msg, e.Code)
+	}
 	if e.Line > 0 {
 		if e.Column > 0 {
-			return fmt.Sprintf("%s:%d:%d: %s", e.File, e.Line, e.Column, e.Message)
+			return fmt.Sprintf("%s:%d:%d: %s", e.File, e.Line, e.Column, msg)
 		}
-		return fmt.Sprintf("%s:%d: %s", e.File, e.Line, e.Message)
+		return fmt.Sprintf("%s:%d: %s", e.File, e.Line, msg)
 	}
-	return fmt.Sprintf("%s: %s", e.File, e.Message)
+	return fmt.Sprintf("%s: %s", e.File, msg)
+}
+
+// suppress adds the codes in a -suppress list to the suppressed warnings.
+// Errors cannot be suppressed.
+func suppress(list string) error {
+	for _, id := range strings.Split(list, ",") {
+		code := dberrors.LookupCode(strings.TrimSpace(id))
+		if code == nil {
+			return fmt.Errorf("unknown code %q", strings.TrimSpace(id))
+		}
+		if code.Phase != "warning" {
+			return fmt.Errorf("%s is an error, and only warnings can be suppressed", code.ID)
+		}
+		suppressed[code.ID] = true
+	}
+	return nil
 }
 
 func compile(filename string) (*CompileResult, error) {
@@ -160,17 +519,43 @@ func compile(filename string) (*CompileResult, error) {
 		SourceFile: filename,
 	}
 
+	// Go module versions are locked for the project, or else for the
+	// programs in the main file's directory
+	if manifest != nil {
+		result.LockFile = filepath.Join(manifest.Dir, project.LockName)
+	} else if filename != stdinName {
+		if abs, err := filepath.Abs(filename); err == nil {
+			result.LockFile = filepath.Join(filepath.Dir(abs), project.LockName)
+		}
+	}
+
+	// A project's other source files are compiled along with its main file
+	files := []string{filename}
+	if manifest != nil {
+		sources, err := manifest.SourceFiles()
+		if err != nil {
+			return result, err
+		}
+		files = append(files, sources...)
+	}
+
 	// Preprocess (handle INCLUDE directives)
-	pp := preprocessor.New(filepath.Dir(filename))
-	ppResult, err := pp.Process(filename)
+	pp := newPreprocessor(filename)
+	ppResult, err := preprocessFiles(pp, files)
 	if err != nil {
-		return nil, err
+		for _, e := range pp.Errors() {
+			result.Errors = append(result.Errors, preprocessorError(e))
+		}
+		if len(result.Errors) > 0 {
+			return result, fmt.Errorf("preprocessing failed with %d error(s)", len(result.Errors))
+		}
+		return result, err
 	}
 
 	source := ppResult.Source
 
-	if len(ppResult.IncludedFiles) > 1 {
-		infof("preprocessing complete: %d files included", len(ppResult.IncludedFiles)-1)
+	if len(ppResult.IncludedFiles) > len(files) {
+		infof("preprocessing complete: %d files included", len(ppResult.IncludedFiles)-len(files))
 	}
 
 	infof("compiling %s (%d bytes)", filename, len(source))
@@ -184,38 +569,48 @@ func compile(filename string) (*CompileResult, error) {
 
 	if len(p.Errors()) > 0 {
 		for _, e := range p.Errors() {
-			result.Errors = append(result.Errors, CompileError{
-				File:    filename,
-				Message: e,
-				Phase:   "parser",
-			})
+			result.Errors = append(result.Errors, sourceError(e, "parser", filename, ppResult))
 		}
 		return result, fmt.Errorf("parsing failed with %d error(s)", len(p.Errors()))
 	}
 
 	infof("parsed %d statements", len(program.Statements))
 
-	// Analyze
+	// Analyze, checking calls into Go packages that have declarations
 	a := analyzer.New()
 	a.SetSource(string(source)) // Set source for error context
+	a.SetVet(vetMode)
+	plugins, err := loadPlugins(filename)
+	if err != nil {
+		result.Errors = append(result.Errors, CompileError{File: filename, Message: err.Error(), Phase: "analyzer"})
+		return result, fmt.Errorf("loading plugins failed")
+	}
+	for _, p := range plugins {
+		a.AddPlugin(p)
+	}
+	if errs := declarePackages(a, pp, program); len(errs) > 0 {
+		result.Errors = append(result.Errors, errs...)
+		return result, fmt.Errorf("reading declarations failed with %d error(s)", len(errs))
+	}
 	symbols, errors := a.Analyze(program)
 
 	if len(errors) > 0 {
 		for _, e := range errors {
-			result.Errors = append(result.Errors, CompileError{
-				File:    filename,
-				Message: e,
-				Phase:   "analyzer",
-			})
+			result.Errors = append(result.Errors, sourceError(e, "analyzer", filename, ppResult))
 		}
 		return result, fmt.Errorf("analysis failed with %d error(s)", len(errors))
 	}
+	for _, w := range a.Warnings() {
+		result.Warnings = append(result.Warnings, vetWarning(w, filename, ppResult))
+	}
 
 	// Check for Main sub
-	if !a.HasMain() {
+	if !a.HasMain() && !testMode && libraryPkg == "" {
+		msg := "no Main() sub found - program may not execute"
 		result.Warnings = append(result.Warnings, CompileError{
 			File:    filename,
-			Message: "no Main() sub found - program may not execute",
+			Message: msg,
+			Code:    dberrors.NoMain,
 			Phase:   "analyzer",
 		})
 	}
@@ -225,88 +620,468 @@ func compile(filename string) (*CompileResult, error) {
 	// Generate Go code
 	g := codegen.New(program, symbols)
 	g.SetDebugMode(debugMode)
+	g.SetReleaseMode(releaseMode)
 	g.SetTypeRegistry(a.TypeRegistry())
 	g.SetSourceFile(filepath.Base(filename)) // Set source file for error messages
+	g.SetSourceMap(ppResult.GetOriginalLocation)
+	g.SetTestMode(testMode)
+	g.SetLibrary(libraryPkg)
+	for _, p := range plugins {
+		g.AddPlugin(p)
+	}
 	result.GoCode = g.Generate()
+	paths := make(map[string]string)
+	for _, m := range ppResult.LineMap {
+		paths[m.File] = m.Path
+	}
+	for _, m := range g.LineMap() {
+		if paths[m.File] != "" {
+			m.File = paths[m.File]
+		}
+		result.Lines = append(result.Lines, m)
+	}
+	if testMode {
+		result.Tests = g.Tests()
+		result.Benchmarks = g.Benchmarks()
+		result.TestCode = g.GenerateTests()
+	}
+
+	// Embedded paths are relative to the file the EMBEDFILE or EMBEDDIR
+	// is in
+	for _, e := range g.Embeds() {
+		path, line := ppResult.GetOriginalPath(e.Line)
+		written := e.Path
+		if !filepath.IsAbs(e.Path) {
+			e.Path = filepath.Join(filepath.Dir(path), e.Path)
+		}
+		if msg := checkEmbed(e, written); msg != "" {
+			ce := CompileError{File: filename, Line: line, Message: msg, Phase: "codegen", Code: dberrors.CannotEmbed}
+			if path != ppResult.MainFile {
+				ce.File = displayPath(path)
+			}
+			result.Errors = append(result.Errors, ce)
+			continue
+		}
+		result.Embeds = append(result.Embeds, e)
+	}
+	if len(result.Errors) > 0 {
+		return result, fmt.Errorf("embedding failed with %d error(s)", len(result.Errors))
+	}
 
 	infof("generated %d bytes of Go code", len(result.GoCode))
 
 	return result, nil
 }
 
+// checkEmbed returns why an embedded file or directory cannot be used, or
+// "". name is its path as written in the program.
+func checkEmbed(e codegen.Embed, name string) string {
+	info, err := os.Stat(e.Path)
+	switch {
+	case os.IsNotExist(err):
+		return fmt.Sprintf("cannot embed %s: no such file or directory", name)
+	case err != nil:
+		return fmt.Sprintf("cannot embed %s: %v", name, err)
+	case e.IsDir && !info.IsDir():
+		return fmt.Sprintf("cannot embed %s with EMBEDDIR: it is a file; use EMBEDFILE", name)
+	case !e.IsDir && info.IsDir():
+		return fmt.Sprintf("cannot embed %s with EMBEDFILE: it is a directory; use EMBEDDIR", name)
+	}
+	return ""
+}
+
+// writeEmbeds copies a program's embedded files and directories into the
+// directory its Go code is built in, where go:embed looks for them
+func writeEmbeds(result *CompileResult, dir string) error {
+	for _, e := range result.Embeds {
+		target := filepath.Join(dir, filepath.FromSlash(e.Target))
+		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			return err
+		}
+		var err error
+		if e.IsDir {
+			err = os.CopyFS(target, os.DirFS(e.Path))
+		} else {
+			var data []byte
+			if data, err = os.ReadFile(e.Path); err == nil {
+				err = os.WriteFile(target, data, 0644)
+			}
+		}
+		if err != nil {
+			return fmt.Errorf("embedding %s: %v", e.Path, err)
+		}
+	}
+	return nil
+}
+
+// sourceError turns a parser or analyzer message into a CompileError at
+// its place in the original source, which INCLUDEs and project files move
+// away from the line the message names
+func sourceError(msg, phase, filename string, pp *preprocessor.Result) CompileError {
+	ce := CompileError{File: filename, Message: msg, Phase: phase, Text: msg}
+	parsed := dberrors.Parse(msg)
+	if parsed == nil {
+		ce.Code = dberrors.CodeFor(phase, msg)
+		return ce
+	}
+	ce.Message, ce.Hint, ce.Code = parsed.Message, parsed.Hint, parsed.Code
+	ce.Line, ce.Column = parsed.Line, parsed.Column
+	ce.Text = parsed.Error()
+
+	if path, line := pp.GetOriginalPath(parsed.Line); path != "" {
+		ce.Line = line
+		parsed.Line = line
+		if path != pp.MainFile {
+			ce.File = displayPath(path)
+			parsed.File = ce.File
+		}
+		ce.Text = parsed.Error()
+	}
+	return ce
+}
+
+// vetWarning turns what vet found into a CompileError in the file it is in
+func vetWarning(w analyzer.Warning, filename string, pp *preprocessor.Result) CompileError {
+	ce := CompileError{File: filename, Line: w.Line, Message: w.Message, Hint: w.Hint, Phase: "vet", Code: w.Code}
+	if path, line := pp.GetOriginalPath(w.Line); path != "" {
+		ce.Line = line
+		if path != pp.MainFile {
+			ce.File = displayPath(path)
+		}
+	}
+	ce.Text = "warning: " + ce.String() + "\n"
+	if ce.Hint != "" {
+		ce.Text += "  hint: " + ce.Hint + "\n"
+	}
+	return ce
+}
+
+// preprocessorError turns a preprocessor message, which starts with the
+// file and line of the INCLUDE, into a CompileError
+func preprocessorError(msg string) CompileError {
+	ce := CompileError{Message: msg, Phase: "preprocessor"}
+	if parts := strings.SplitN(msg, ":", 3); len(parts) == 3 {
+		if line, err := strconv.Atoi(parts[1]); err == nil {
+			ce.File, ce.Line, ce.Message = parts[0], line, strings.TrimSpace(parts[2])
+		}
+	}
+	ce.Code = dberrors.CodeFor("preprocessor", ce.Message)
+	return ce
+}
+
+// displayPath shortens an absolute path to one relative to the current
+// directory, when it is inside it
+func displayPath(path string) string {
+	cwd, err := os.Getwd()
+	if err != nil {
+		return path
+	}
+	if rel, err := filepath.Rel(cwd, path); err == nil && !strings.HasPrefix(rel, "..") {
+		return rel
+	}
+	return path
+}
+
+// printErrors prints the errors and warnings of a compilation, as JSON
+// lines in -json mode
 func printErrors(result *CompileResult) {
+	if jsonMode {
+		enc := json.NewEncoder(os.Stderr)
+		enc.SetEscapeHTML(false) // File names such as <stdin>
+		for _, e := range result.Errors {
+			enc.Encode(struct {
+				CompileError
+				Severity string `json:"severity"`
+			}{e, "error"})
+		}
+		for _, w := range result.Warnings {
+			if suppressed[w.Code] {
+				continue
+			}
+			enc.Encode(struct {
+				CompileError
+				Severity string `json:"severity"`
+			}{w, "warning"})
+		}
+		return
+	}
+
 	for _, e := range result.Errors {
-		// If the message already contains formatting (newlines), print as-is
-		if strings.Contains(e.Message, "\n") {
-			fmt.Fprint(os.Stderr, e.Message)
+		// Messages with source context are printed as they are
+		if e.Text != "" {
+			fmt.Fprint(os.Stderr, e.Text)
 		} else {
 			fmt.Fprintln(os.Stderr, e.String())
 		}
 	}
 	for _, w := range result.Warnings {
-		if strings.Contains(w.Message, "\n") {
-			fmt.Fprint(os.Stderr, w.Message)
+		if suppressed[w.Code] {
+			continue
+		}
+		if w.Text != "" {
+			fmt.Fprint(os.Stderr, w.Text)
 		} else {
 			fmt.Fprintf(os.Stderr, "warning: %s\n", w.String())
 		}
 	}
 }
 
+// compileFailed reports why compiling failed and exits
+func compileFailed(result *CompileResult, err error) {
+	if jsonMode && len(result.Errors) == 0 {
+		// An error without a place, such as a missing file
+		result.Errors = append(result.Errors, CompileError{File: result.SourceFile, Message: err.Error(), Phase: "preprocessor"})
+	}
+	printErrors(result)
+	if !jsonMode {
+		errorf("%v", err)
+	}
+	os.Exit(1)
+}
+
 func check(filename string) {
 	result, err := compile(filename)
 	if err != nil {
-		printErrors(result)
-		errorf("%v", err)
-		os.Exit(1)
+		compileFailed(result, err)
 	}
+	printErrors(result)
 
-	for _, w := range result.Warnings {
-		fmt.Fprintf(os.Stderr, "warning: %s\n", w.String())
+	fmt.Printf("%s: OK\n", filename)
+}
+
+// vet reports suspicious constructs in a program that compiles, and exits
+// with status 1 if there are any that are not suppressed
+func vet(filename string) {
+	vetMode = true
+	result, err := compile(filename)
+	if err != nil {
+		compileFailed(result, err)
 	}
+	printErrors(result)
 
-	fmt.Printf("%s: OK\n", filename)
+	for _, w := range result.Warnings {
+		if w.Phase == "vet" && !suppressed[w.Code] {
+			os.Exit(1)
+		}
+	}
+	if !jsonMode {
+		fmt.Printf("%s: OK\n", filename)
+	}
 }
 
-func emit(filename string) {
+func emit(filename, outputName string) {
 	result, err := compile(filename)
 	if err != nil {
-		printErrors(result)
-		errorf("%v", err)
+		compileFailed(result, err)
+	}
+	printErrors(result)
+
+	if writeMap && outputName == "" {
+		errorf("-map needs -o to name the Go file")
 		os.Exit(1)
 	}
 
-	for _, w := range result.Warnings {
-		fmt.Fprintf(os.Stderr, "warning: %s\n", w.String())
+	code := []byte(result.GoCode)
+	if formatted, err := format.Source(code); err != nil {
+		warnf("generated code could not be formatted: %v", err)
+	} else if lines, ok := codegen.ReformatLineMap(result.Lines, result.GoCode, string(formatted)); ok {
+		code, result.Lines = formatted, lines
+	} else if writeMap {
+		warnf("formatting moved lines, so the code is left unformatted for the source map")
+	} else {
+		code = formatted
 	}
 
-	fmt.Print(result.GoCode)
+	if outputName == "" {
+		os.Stdout.Write(code)
+		return
+	}
+	if err := os.WriteFile(outputName, code, 0644); err != nil {
+		errorf("writing %s: %v", outputName, err)
+		os.Exit(1)
+	}
+	infof("wrote %s", outputName)
+	if writeMap {
+		if err := writeSourceMap(outputName+".map", filepath.Base(outputName), result.Lines); err != nil {
+			errorf("writing source map: %v", err)
+			os.Exit(1)
+		}
+	}
 }
 
-func build(filename, outputName string) {
-	result, err := compile(filename)
+// preprocess reads a source file and expands its INCLUDEs, exiting on error
+func preprocess(filename string) string {
+	ppResult, err := preprocessFiles(newPreprocessor(filename), []string{filename})
 	if err != nil {
-		printErrors(result)
 		errorf("%v", err)
 		os.Exit(1)
 	}
+	return ppResult.Source
+}
 
-	for _, w := range result.Warnings {
-		fmt.Fprintf(os.Stderr, "warning: %s\n", w.String())
+// dumpAST prints the parse tree of a file, then any parse errors
+func dumpAST(filename string, asJSON bool) {
+	p := parser.New(lexer.New(preprocess(filename)))
+	program := p.ParseProgram()
+
+	if asJSON {
+		out, err := parser.DumpJSON(program)
+		if err != nil {
+			errorf("%v", err)
+			os.Exit(1)
+		}
+		fmt.Println(string(out))
+	} else {
+		fmt.Print(parser.Dump(program))
 	}
 
+	if len(p.Errors()) > 0 {
+		for _, e := range p.Errors() {
+			fmt.Fprint(os.Stderr, e)
+		}
+		errorf("parsing failed with %d error(s)", len(p.Errors()))
+		os.Exit(1)
+	}
+}
+
+// dumpTokens prints the tokens of a file, one per line
+func dumpTokens(filename string, asJSON bool) {
+	type jsonToken struct {
+		Type    string `json:"type"`
+		Literal string `json:"literal"`
+		Line    int    `json:"line"`
+		Column  int    `json:"column"`
+	}
+
+	l := lexer.New(preprocess(filename))
+	var tokens []jsonToken
+	for {
+		tok := l.NextToken()
+		if asJSON {
+			tokens = append(tokens, jsonToken{tok.Type.String(), tok.Literal, tok.Line, tok.Column})
+		} else {
+			fmt.Printf("%d:%d\t%-12s %q\n", tok.Line, tok.Column, tok.Type, tok.Literal)
+		}
+		if tok.Type == lexer.TOKEN_EOF {
+			break
+		}
+	}
+
+	if asJSON {
+		out, _ := json.MarshalIndent(tokens, "", "  ")
+		fmt.Println(string(out))
+	}
+}
+
+// docFiles returns the files to document when none are named: the
+// project's, or the .dbas files in the current directory other than tests.
+// It also returns a title for the documentation.
+func docFiles() ([]string, string) {
+	if p, err := project.Find("."); err == nil {
+		sources, err := p.SourceFiles()
+		if err != nil {
+			errorf("%v", err)
+			os.Exit(1)
+		}
+		return append([]string{p.Main}, sources...), p.Name
+	} else if err != project.ErrNoManifest {
+		errorf("%v", err)
+		os.Exit(1)
+	}
+
+	var files []string
+	matches, _ := filepath.Glob("*.dbas")
+	for _, m := range matches {
+		if !strings.HasSuffix(m, "_test.dbas") {
+			files = append(files, m)
+		}
+	}
+	cwd, _ := os.Getwd()
+	return files, filepath.Base(cwd)
+}
+
+// writeDocs documents the declarations in files, each preprocessed so
+// that its INCLUDEs resolve but listing only its own declarations
+func writeDocs(files []string, title string, asHTML bool, outputName string) {
+	var entries []*doc.Entry
+	for _, filename := range files {
+		ppResult, err := newPreprocessor(filename).Process(filename)
+		if err != nil {
+			errorf("%v", err)
+			os.Exit(1)
+		}
+		p := parser.New(lexer.New(ppResult.Source))
+		program := p.ParseProgram()
+		if len(p.Errors()) > 0 {
+			for _, e := range p.Errors() {
+				fmt.Fprint(os.Stderr, e)
+			}
+			errorf("%s: parsing failed with %d error(s)", filename, len(p.Errors()))
+			os.Exit(1)
+		}
+		entries = append(entries, doc.Extract(program, ppResult.GetOriginalLocation, filepath.Base(filename))...)
+	}
+
+	if title == "" {
+		title = strings.TrimSuffix(filepath.Base(files[0]), filepath.Ext(files[0]))
+	}
+	out := doc.Markdown(title, entries)
+	if asHTML {
+		out = doc.HTML(title, entries)
+	}
+
+	if outputName == "" {
+		fmt.Print(out)
+		return
+	}
+	if err := os.WriteFile(outputName, []byte(out), 0644); err != nil {
+		errorf("writing %s: %v", outputName, err)
+		os.Exit(1)
+	}
+	infof("wrote %s", outputName)
+}
+
+func build(filename, outputName string) {
+	result, err := compile(filename)
+	if err != nil {
+		compileFailed(result, err)
+	}
+	printErrors(result)
+
 	// Determine output name
+	if outputName == "" && filename == stdinName {
+		errorf("-o is required when the source is read from stdin")
+		os.Exit(1)
+	}
 	if outputName == "" {
 		base := filepath.Base(filename)
 		outputName = strings.TrimSuffix(base, filepath.Ext(base))
 	}
+	outputPath, err := filepath.Abs(outputName)
+	if err != nil {
+		errorf("getting current directory: %v", err)
+		os.Exit(1)
+	}
+
+	if writeMap {
+		if err := writeSourceMap(outputPath+".map", "main.go", result.Lines); err != nil {
+			errorf("writing source map: %v", err)
+			os.Exit(1)
+		}
+	}
+
+	if _, ok := daemonBuild(result, outputPath); ok {
+		fmt.Fprintf(os.Stderr, "Built: %s\n", outputPath)
+		return
+	}
 
 	// Create temp directory for Go files
-	tempDir, err := os.MkdirTemp("", "dbasic-*")
+	tempDir, err := makeWorkDir("dbasic-*")
 	if err != nil {
 		errorf("creating temp directory: %v", err)
 		os.Exit(1)
 	}
-	defer os.RemoveAll(tempDir)
+	defer removeWorkDir(tempDir)
 
 	// Write Go source file
 	goFile := filepath.Join(tempDir, "main.go")
@@ -315,42 +1090,19 @@ func build(filename, outputName string) {
 		errorf("writing Go file: %v", err)
 		os.Exit(1)
 	}
-
-	// Initialize Go module in temp directory
-	modInit := exec.Command("go", "mod", "init", "dbasic_program")
-	modInit.Dir = tempDir
-	modInit.Stdout = nil
-	modInit.Stderr = nil
-	if err := modInit.Run(); err != nil {
-		errorf("initializing Go module: %v", err)
-		os.Exit(1)
-	}
-
-	// Run go mod tidy to fetch dependencies
-	modTidy := exec.Command("go", "mod", "tidy")
-	modTidy.Dir = tempDir
-	modTidy.Stdout = nil
-	modTidy.Stderr = os.Stderr
-	if err := modTidy.Run(); err != nil {
-		errorf("fetching dependencies: %v", err)
+	if err := writeEmbeds(result, tempDir); err != nil {
+		errorf("%v", err)
 		os.Exit(1)
 	}
 
-	// Get the current working directory for output
-	cwd, err := os.Getwd()
-	if err != nil {
-		errorf("getting current directory: %v", err)
+	if err := initModule(tempDir, result.GoCode, result.LockFile); err != nil {
+		errorf("%v", err)
 		os.Exit(1)
 	}
 
-	outputPath := filepath.Join(cwd, outputName)
-
 	// Build executable
 	infof("building %s", outputPath)
-	cmd := exec.Command("go", "build", "-o", outputPath, ".")
-	cmd.Dir = tempDir
-	cmd.Stderr = os.Stderr
-
+	cmd := goCommand(tempDir, append(append([]string{"build"}, goFlags...), "-o", outputPath, ".")...)
 	if err := cmd.Run(); err != nil {
 		errorf("building executable: %v", err)
 		os.Exit(1)
@@ -359,25 +1111,90 @@ func build(filename, outputName string) {
 	fmt.Fprintf(os.Stderr, "Built: %s\n", outputPath)
 }
 
-func run(filename string) {
+// initModule makes dir, which holds the generated Go code, a Go module and
+// fetches its dependencies, at the versions the project pins or else those
+// in lockFile, which is then updated. The module files are cached, so go mod
+// tidy only runs the first time a program imports a particular set of
+// packages.
+func initModule(dir, goCode, lockFile string) error {
+	var locked []project.Dependency
+	if lockFile != "" {
+		var err error
+		if locked, err = project.ReadLock(lockFile); err != nil {
+			return err
+		}
+	}
+
+	cached := cachedModule(goCode, locked)
+	if cached != "" && restoreModule(cached, dir) {
+		infof("using cached module %s", cached)
+		return updateLock(dir, lockFile, locked)
+	}
+
+	modInit := goCommand(dir, "mod", "init", "dbasic_program")
+	modInit.Stderr = nil // It reports the go.mod it creates
+	if err := modInit.Run(); err != nil {
+		return fmt.Errorf("initializing Go module: %v", err)
+	}
+
+	if pins := modulePins(locked); len(pins) > 0 {
+		args := []string{"mod", "edit"}
+		for _, dep := range pins {
+			args = append(args, "-require="+dep.Module+"@"+dep.Version)
+		}
+		if err := goCommand(dir, args...).Run(); err != nil {
+			return fmt.Errorf("pinning dependencies: %v", err)
+		}
+	}
+
+	// Run go mod tidy to fetch dependencies
+	if err := goCommand(dir, "mod", "tidy").Run(); err != nil {
+		return fmt.Errorf("fetching dependencies: %v", err)
+	}
+
+	if cached != "" {
+		if err := saveModule(dir, cached); err != nil {
+			infof("caching module: %v", err)
+		}
+	}
+	return updateLock(dir, lockFile, locked)
+}
+
+// The environment of go commands, nil for our own, and where they write
+// errors. The daemon sets them to its client's.
+var (
+	goEnv    []string
+	goOutput io.Writer = os.Stderr
+)
+
+// goCommand returns a go command that runs in dir
+func goCommand(dir string, args ...string) *exec.Cmd {
+	cmd := exec.Command("go", args...)
+	cmd.Dir = dir
+	cmd.Env = goEnv
+	cmd.Stderr = goOutput
+	return cmd
+}
+
+func run(filename string, args []string) {
 	result, err := compile(filename)
 	if err != nil {
-		printErrors(result)
-		errorf("%v", err)
-		os.Exit(1)
+		compileFailed(result, err)
 	}
+	printErrors(result)
 
-	for _, w := range result.Warnings {
-		fmt.Fprintf(os.Stderr, "warning: %s\n", w.String())
+	if binary, ok := daemonBuild(result, ""); ok {
+		runProgram(exec.Command(binary, args...), result.Lines)
+		return
 	}
 
 	// Create temp directory
-	tempDir, err := os.MkdirTemp("", "dbasic-*")
+	tempDir, err := makeWorkDir("dbasic-*")
 	if err != nil {
 		errorf("creating temp directory: %v", err)
 		os.Exit(1)
 	}
-	defer os.RemoveAll(tempDir)
+	defer removeWorkDir(tempDir)
 
 	// Write Go source file
 	goFile := filepath.Join(tempDir, "main.go")
@@ -386,35 +1203,41 @@ func run(filename string) {
 		errorf("writing Go file: %v", err)
 		os.Exit(1)
 	}
+	if err := writeEmbeds(result, tempDir); err != nil {
+		errorf("%v", err)
+		os.Exit(1)
+	}
 
-	// Initialize Go module
-	modInit := exec.Command("go", "mod", "init", "dbasic_program")
-	modInit.Dir = tempDir
-	modInit.Stdout = nil
-	modInit.Stderr = nil
-	if err := modInit.Run(); err != nil {
-		errorf("initializing Go module: %v", err)
+	if err := initModule(tempDir, result.GoCode, result.LockFile); err != nil {
+		errorf("%v", err)
 		os.Exit(1)
 	}
 
-	// Run go mod tidy to fetch dependencies
-	modTidy := exec.Command("go", "mod", "tidy")
-	modTidy.Dir = tempDir
-	modTidy.Stdout = nil
-	modTidy.Stderr = os.Stderr
-	if err := modTidy.Run(); err != nil {
-		errorf("fetching dependencies: %v", err)
+	// Build the program and run it in the current directory, as the
+	// daemon's builds are
+	binary := filepath.Join(tempDir, "program")
+	if runtime.GOOS == "windows" {
+		binary += ".exe"
+	}
+	if err := goCommand(tempDir, append(append([]string{"build"}, goFlags...), "-o", binary, ".")...).Run(); err != nil {
+		errorf("building program: %v", err)
 		os.Exit(1)
 	}
+	runProgram(exec.Command(binary, args...), result.Lines)
+}
 
-	// Run the program
-	cmd := exec.Command("go", "run", ".")
-	cmd.Dir = tempDir
+// runProgram runs a program with our standard streams, and exits with its
+// status if it fails. Stack traces in its errors name the DBasic lines of
+// the Go lines in lines.
+func runProgram(cmd *exec.Cmd, lines []codegen.LineMapping) {
+	stderr := newPanicTranslator(os.Stderr, lines)
 	cmd.Stdin = os.Stdin
 	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
+	cmd.Stderr = stderr
 
-	if err := cmd.Run(); err != nil {
+	err := cmd.Run()
+	stderr.Flush()
+	if err != nil {
 		if exitErr, ok := err.(*exec.ExitError); ok {
 			os.Exit(exitErr.ExitCode())
 		}
@@ -422,3 +1245,364 @@ func run(filename string) {
 		os.Exit(1)
 	}
 }
+
+// buildLibrary writes the Go package for a library to outputDir, after
+// checking that it builds. The package is named by -pkg, or else after
+// outputDir or the source file.
+func buildLibrary(filename, outputDir string) {
+	if libraryPkg == "" {
+		name := outputDir
+		if name == "" {
+			name = strings.TrimSuffix(filepath.Base(filename), filepath.Ext(filename))
+		}
+		libraryPkg = packageName(filepath.Base(name))
+	}
+	if !token.IsIdentifier(libraryPkg) || libraryPkg == "main" {
+		errorf("invalid package name %q", libraryPkg)
+		os.Exit(1)
+	}
+	if outputDir == "" {
+		outputDir = libraryPkg
+	}
+
+	result, err := compile(filename)
+	if err != nil {
+		compileFailed(result, err)
+	}
+	printErrors(result)
+
+	code, err := format.Source([]byte(result.GoCode))
+	if err != nil {
+		errorf("formatting generated code: %v", err)
+		os.Exit(1)
+	}
+
+	// Build the package on its own first, so errors show up here rather
+	// than in the program that imports it
+	tempDir, err := makeWorkDir("dbasic-*")
+	if err != nil {
+		errorf("creating temp directory: %v", err)
+		os.Exit(1)
+	}
+	defer removeWorkDir(tempDir)
+
+	goFile := libraryPkg + ".go"
+	if err := os.WriteFile(filepath.Join(tempDir, goFile), code, 0644); err != nil {
+		errorf("writing Go file: %v", err)
+		os.Exit(1)
+	}
+	if err := writeEmbeds(result, tempDir); err != nil {
+		errorf("%v", err)
+		os.Exit(1)
+	}
+	if err := initModule(tempDir, result.GoCode, result.LockFile); err != nil {
+		errorf("%v", err)
+		os.Exit(1)
+	}
+	cmd := goCommand(tempDir, append(append([]string{"build"}, goFlags...), ".")...)
+	if err := cmd.Run(); err != nil {
+		errorf("building package: %v", err)
+		os.Exit(1)
+	}
+
+	if err := os.MkdirAll(outputDir, 0755); err != nil {
+		errorf("creating %s: %v", outputDir, err)
+		os.Exit(1)
+	}
+	outputPath := filepath.Join(outputDir, goFile)
+	if err := os.WriteFile(outputPath, code, 0644); err != nil {
+		errorf("writing %s: %v", outputPath, err)
+		os.Exit(1)
+	}
+	// The package embeds its files from beside it, replacing any copies
+	// from an earlier build
+	os.RemoveAll(filepath.Join(outputDir, "dbasic_embed"))
+	if err := writeEmbeds(result, outputDir); err != nil {
+		errorf("%v", err)
+		os.Exit(1)
+	}
+	fmt.Fprintf(os.Stderr, "Built: package %s in %s\n", libraryPkg, outputPath)
+}
+
+// packageName makes a Go package name from a file or directory name, by
+// lowercasing it and dropping characters that are not letters or digits
+func packageName(name string) string {
+	var sb strings.Builder
+	for _, r := range strings.ToLower(name) {
+		if unicode.IsLetter(r) || (unicode.IsDigit(r) && sb.Len() > 0) {
+			sb.WriteRune(r)
+		}
+	}
+	return sb.String()
+}
+
+// testEvent is a line of go test -json output
+type testEvent struct {
+	Action  string
+	Test    string
+	Output  string
+	Elapsed float64
+}
+
+// goTestLocation matches the Go file and line that t.Error puts before a
+// message; DBasic messages carry their own location
+var goTestLocation = regexp.MustCompile(`^\s*\w+\.go:\d+: `)
+
+// runTests compiles each file's TEST blocks into Go tests, and with -bench
+// its BENCHMARK blocks into Go benchmarks, runs them and reports the
+// results. It returns false if anything failed.
+func runTests(files []string) bool {
+	testMode = true
+	passed, failed := 0, 0
+	ok := true
+
+	for _, filename := range files {
+		result, err := compile(filename)
+		if err != nil {
+			printErrors(result)
+			errorf("%v", err)
+			ok = false
+			continue
+		}
+		benchmarks := selectBenchmarks(result.Benchmarks)
+		if len(result.Tests) == 0 && len(benchmarks) == 0 {
+			fmt.Printf("%s: no tests\n", filename)
+			continue
+		}
+
+		p, f, err := runGoTests(result, benchmarks)
+		if err != nil {
+			errorf("%s: %v", filename, err)
+			ok = false
+		}
+		passed += p
+		failed += f
+		fmt.Printf("%s: %d passed, %d failed\n", filename, p, f)
+	}
+
+	if len(files) > 1 {
+		fmt.Printf("total: %d passed, %d failed\n", passed, failed)
+	}
+	return ok && failed == 0
+}
+
+// setBuildTarget sets GOOS and GOARCH for dbasic build to those of the
+// configuration, unless the environment sets them
+func setBuildTarget() {
+	for _, v := range [][2]string{{"GOOS", config.GOOS}, {"GOARCH", config.GOARCH}} {
+		if v[1] != "" && os.Getenv(v[0]) == "" {
+			infof("%s=%s from the configuration", v[0], v[1])
+			os.Setenv(v[0], v[1])
+		}
+	}
+}
+
+// tempDir returns the directory temporary build directories are made in:
+// the configuration's tempdir, or else the system's
+func tempDir() string {
+	if config.TempDir != "" {
+		return config.TempDir
+	}
+	return os.TempDir()
+}
+
+// makeWorkDir creates a temporary directory to build in, with an owner
+// file that tells clean it is in use. With -work, its path is printed, and
+// removeWorkDir leaves it in place.
+func makeWorkDir(pattern string) (string, error) {
+	dir, err := os.MkdirTemp(tempDir(), pattern)
+	if err != nil {
+		return "", err
+	}
+	owner := strconv.Itoa(os.Getpid())
+	if keepWork {
+		owner = "kept"
+		fmt.Fprintf(os.Stderr, "WORK=%s\n", dir)
+	}
+	if err := os.WriteFile(filepath.Join(dir, ownerFile), []byte(owner+"\n"), 0644); err != nil {
+		os.RemoveAll(dir)
+		return "", err
+	}
+	return dir, nil
+}
+
+// removeWorkDir deletes a temporary build directory, unless -work is set
+func removeWorkDir(dir string) {
+	if !keepWork {
+		os.RemoveAll(dir)
+	}
+}
+
+// selectBenchmarks returns the benchmarks whose names match -bench, or none
+// without it
+func selectBenchmarks(benchmarks []codegen.TestCase) []codegen.TestCase {
+	var selected []codegen.TestCase
+	for _, bc := range benchmarks {
+		if benchFilter != nil && benchFilter.MatchString(bc.Name) {
+			selected = append(selected, bc)
+		}
+	}
+	return selected
+}
+
+// runGoTests runs the generated tests and the given benchmarks with go test
+// and prints a line for each, with the output of failed ones
+func runGoTests(result *CompileResult, benchmarks []codegen.TestCase) (passed, failed int, err error) {
+	tempDir, err := makeWorkDir("dbasic-test-*")
+	if err != nil {
+		return 0, 0, fmt.Errorf("creating temp directory: %v", err)
+	}
+	defer removeWorkDir(tempDir)
+
+	if err := os.WriteFile(filepath.Join(tempDir, "main.go"), []byte(result.GoCode), 0644); err != nil {
+		return 0, 0, fmt.Errorf("writing Go file: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(tempDir, "main_test.go"), []byte(result.TestCode), 0644); err != nil {
+		return 0, 0, fmt.Errorf("writing Go test file: %v", err)
+	}
+	if err := writeEmbeds(result, tempDir); err != nil {
+		return 0, 0, err
+	}
+
+	if err := initModule(tempDir, result.GoCode, result.LockFile); err != nil {
+		return 0, 0, err
+	}
+
+	outputs := make(map[string][]string)
+	results := make(map[string]testEvent)
+	var pkgOutput []string
+	if len(result.Tests) > 0 {
+		pkgOutput = goTest(tempDir, []string{"-json", "."}, outputs, results)
+	}
+	// Benchmarks run in a go test of their own, as go test skips them when
+	// a test fails. If the tests did not build, neither will they.
+	built := len(result.Tests) == 0 || len(results) > 0 || len(outputs) > 0
+	if len(benchmarks) > 0 && built {
+		var names []string
+		for _, bc := range benchmarks {
+			names = append(names, bc.GoName)
+		}
+		args := []string{"-json", "-run", "^$", "-bench", "^(" + strings.Join(names, "|") + ")$", "-benchmem"}
+		if benchTime != "" {
+			args = append(args, "-benchtime", benchTime)
+		}
+		if out := goTest(tempDir, append(args, "."), outputs, results); pkgOutput == nil {
+			pkgOutput = out
+		}
+	}
+
+	for _, tc := range result.Tests {
+		ev, ran := results[tc.GoName]
+		if ran && ev.Action == "pass" {
+			passed++
+			fmt.Printf("  PASS  %s (%.2fs)\n", tc.Name, ev.Elapsed)
+			if verboseMode {
+				printTestOutput(outputs[tc.GoName])
+			}
+			continue
+		}
+
+		failed++
+		fmt.Printf("  FAIL  %s (%s:%d)\n", tc.Name, tc.File, tc.Line)
+		if !ran {
+			fmt.Println("        did not finish")
+		}
+		printTestOutput(outputs[tc.GoName])
+	}
+
+	// A benchmark that passes has no result event, only a line of output
+	// with its measurements
+	for _, bc := range benchmarks {
+		ev, ran := results[bc.GoName]
+		measured, output := benchmarkResult(bc.GoName, outputs[bc.GoName])
+		if measured != "" && !(ran && ev.Action == "fail") {
+			passed++
+			fmt.Printf("  BENCH %s  %s\n", bc.Name, measured)
+			if verboseMode {
+				printTestOutput(output)
+			}
+			continue
+		}
+
+		failed++
+		fmt.Printf("  FAIL  %s (%s:%d)\n", bc.Name, bc.File, bc.Line)
+		if !ran {
+			fmt.Println("        did not finish")
+		}
+		printTestOutput(output)
+	}
+
+	if len(results) == 0 && len(outputs) == 0 {
+		// Nothing ran, most likely because the generated code did not build
+		fmt.Print(strings.Join(pkgOutput, ""))
+	}
+	return passed, failed, nil
+}
+
+// goTest runs go test with the given arguments, which include -json, in
+// dir. It adds what each test wrote and its result to outputs and results,
+// and returns the output of the package itself. The exit status only
+// repeats what the events say, unless the tests did not build, which shows
+// up as tests without a result.
+func goTest(dir string, args []string, outputs map[string][]string, results map[string]testEvent) []string {
+	cmd := exec.Command("go", append([]string{"test"}, args...)...)
+	cmd.Dir = dir
+	cmd.Stderr = os.Stderr
+	out, _ := cmd.Output()
+
+	var pkgOutput []string
+	for _, line := range strings.Split(string(out), "\n") {
+		var ev testEvent
+		if json.Unmarshal([]byte(line), &ev) != nil {
+			continue
+		}
+		switch {
+		case ev.Action == "output" && ev.Test != "":
+			outputs[ev.Test] = append(outputs[ev.Test], ev.Output)
+		case ev.Action == "output" || ev.Action == "build-output":
+			pkgOutput = append(pkgOutput, ev.Output)
+		case (ev.Action == "pass" || ev.Action == "fail") && ev.Test != "":
+			results[ev.Test] = ev
+		}
+	}
+	return pkgOutput
+}
+
+// benchmarkResult splits a benchmark's output into its measurements, such
+// as "1000 runs  1234 ns/op  56 B/op  2 allocs/op" ("" if there are none),
+// and the rest of what it wrote
+func benchmarkResult(goName string, lines []string) (string, []string) {
+	measured := ""
+	var rest []string
+	for _, line := range lines {
+		// go test may write the benchmark's name and its measurements on
+		// one line or on two
+		fields := strings.Fields(line)
+		if len(fields) > 0 && (fields[0] == goName || strings.HasPrefix(fields[0], goName+"-")) {
+			fields = fields[1:]
+		} else if !strings.Contains(line, " ns/op") {
+			rest = append(rest, line)
+			continue
+		}
+		if len(fields) >= 3 && len(fields)%2 == 1 {
+			parts := []string{fields[0] + " runs"}
+			for i := 1; i < len(fields); i += 2 {
+				parts = append(parts, fields[i]+" "+fields[i+1])
+			}
+			measured = strings.Join(parts, "  ")
+		}
+	}
+	return measured, rest
+}
+
+// printTestOutput prints what a test wrote and reported, without go test's
+// own status lines and Go source locations
+func printTestOutput(lines []string) {
+	for _, line := range lines {
+		if strings.HasPrefix(line, "=== ") || strings.HasPrefix(line, "--- ") {
+			continue
+		}
+		line = goTestLocation.ReplaceAllString(strings.TrimRight(line, "\n"), "")
+		fmt.Printf("        %s\n", strings.TrimSpace(line))
+	}
+}
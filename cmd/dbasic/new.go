@@ -0,0 +1,102 @@
+package main
+
+import (
+	"bytes"
+	"embed"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"text/template"
+)
+
+// templates holds the starter projects of dbasic new, one directory each.
+// File names and contents are text/template templates of a templateData.
+//
+//go:embed all:templates
+var templates embed.FS
+
+// templateKinds describes each starter project, by the name dbasic new
+// takes
+var templateKinds = map[string]string{
+	"cli": "console tool",
+	"tui": "terminal UI with Bubble Tea",
+	"gui": "Windows GUI with Walk",
+	"web": "web service with a JSON API",
+}
+
+// templateData is what the templates of a starter project are filled in with
+type templateData struct {
+	Name string // Project name, the last element of its directory
+}
+
+// projectNameRe matches the project names dbasic new accepts, which must
+// be usable in file names and DBasic strings
+var projectNameRe = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*$`)
+
+// newProject writes a starter project of the given kind into dir, which
+// must not exist yet or be empty
+func newProject(kind, dir string) {
+	if templateKinds[kind] == "" {
+		errorf("unknown kind of project %q; choose cli, tui, gui or web", kind)
+		os.Exit(1)
+	}
+	data := templateData{Name: filepath.Base(dir)}
+	if !projectNameRe.MatchString(data.Name) {
+		errorf("%q is not a project name: use letters, digits, '.', '-' and '_'", data.Name)
+		os.Exit(1)
+	}
+	if entries, err := os.ReadDir(dir); err == nil && len(entries) > 0 {
+		errorf("%s already exists and is not empty", dir)
+		os.Exit(1)
+	}
+
+	root := path.Join("templates", kind)
+	err := fs.WalkDir(templates, root, func(name string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		rel, err := expand(strings.TrimPrefix(name, root+"/"), data)
+		if err != nil {
+			return err
+		}
+		src, err := templates.ReadFile(name)
+		if err != nil {
+			return err
+		}
+		content, err := expand(string(src), data)
+		if err != nil {
+			return fmt.Errorf("%s: %v", name, err)
+		}
+		target := filepath.Join(dir, filepath.FromSlash(rel))
+		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			return err
+		}
+		infof("writing %s", target)
+		return os.WriteFile(target, []byte(content), 0644)
+	})
+	if err != nil {
+		errorf("%v", err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("Created %s, a %s. To start:\n\n", dir, templateKinds[kind])
+	fmt.Printf("    cd %s\n    dbasic run\n\n", dir)
+	fmt.Printf("%s tells you more.\n", filepath.Join(dir, "README.md"))
+}
+
+// expand fills in a template
+func expand(text string, data templateData) (string, error) {
+	t, err := template.New("").Parse(text)
+	if err != nil {
+		return "", err
+	}
+	var buf bytes.Buffer
+	if err := t.Execute(&buf, data); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strconv"
+
+	"github.com/zditech/dbasic/pkg/codegen"
+)
+
+// sourceMap is the sidecar .map file written with -map. Each entry ties a
+// line of the Go file to the DBasic line it was generated from, so tools
+// can map locations either way; a DBasic line usually has several Go lines.
+type sourceMap struct {
+	Version int             `json:"version"`
+	GoFile  string          `json:"goFile"` // Name of the Go file the lines are in
+	Lines   []sourceMapLine `json:"lines"`  // In order of Go line
+}
+
+type sourceMapLine struct {
+	Go   int    `json:"go"`
+	File string `json:"file"` // Absolute path of the DBasic file
+	Line int    `json:"line"`
+}
+
+// writeSourceMap writes the source map of a compiled program to path, for
+// the Go code in goFile
+func writeSourceMap(path, goFile string, lines []codegen.LineMapping) error {
+	m := sourceMap{Version: 1, GoFile: goFile, Lines: []sourceMapLine{}}
+	for _, l := range lines {
+		m.Lines = append(m.Lines, sourceMapLine{Go: l.GoLine, File: l.File, Line: l.Line})
+	}
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(path, append(data, '\n'), 0644); err != nil {
+		return err
+	}
+	infof("wrote %s", path)
+	return nil
+}
+
+// goFrameRe matches a frame of a Go stack trace in the generated code, such
+// as "\t/tmp/dbasic-123/main.go:11 +0x9"
+var goFrameRe = regexp.MustCompile(`^\t(?:.*[/\\])?main\.go:(\d+)(?: \+0x[0-9a-f]+)?\r?\n$`)
+
+// panicTranslator passes on a program's standard error, naming the DBasic
+// line of each frame in a stack trace that a statement generated. Frames
+// start with a tab, so other partial lines, such as prompts, go out at once.
+type panicTranslator struct {
+	w       io.Writer
+	lines   map[int]codegen.LineMapping // By Go line
+	partial []byte                      // A frame not yet ended
+	midLine bool                        // The last write ended within a line that is not a frame
+}
+
+func newPanicTranslator(w io.Writer, lines []codegen.LineMapping) *panicTranslator {
+	t := &panicTranslator{w: w, lines: make(map[int]codegen.LineMapping)}
+	for _, l := range lines {
+		t.lines[l.GoLine] = l
+	}
+	return t
+}
+
+func (t *panicTranslator) Write(p []byte) (int, error) {
+	data := append(t.partial, p...)
+	t.partial = nil
+	var out bytes.Buffer
+	for len(data) > 0 {
+		end := bytes.IndexByte(data, '\n')
+		if end < 0 {
+			if !t.midLine && data[0] == '\t' {
+				t.partial = data
+			} else {
+				out.Write(data)
+				t.midLine = true
+			}
+			break
+		}
+		line := data[:end+1]
+		data = data[end+1:]
+		if t.midLine {
+			out.Write(line)
+			t.midLine = false
+			continue
+		}
+		out.Write(t.translate(line))
+	}
+	if _, err := t.w.Write(out.Bytes()); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// translate puts the DBasic location first in a frame of generated code
+func (t *panicTranslator) translate(line []byte) []byte {
+	match := goFrameRe.FindSubmatch(line)
+	if match == nil {
+		return line
+	}
+	goLine, _ := strconv.Atoi(string(match[1]))
+	m, ok := t.lines[goLine]
+	if !ok {
+		return line
+	}
+	frame := bytes.TrimRight(line[1:], "\r\n")
+	return []byte(fmt.Sprintf("\t%s:%d (%s)\n", displayPath(m.File), m.Line, frame))
+}
+
+// Flush writes a frame left without a newline
+func (t *panicTranslator) Flush() {
+	if len(t.partial) > 0 {
+		t.w.Write(t.partial)
+		t.partial = nil
+	}
+}
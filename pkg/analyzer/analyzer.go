@@ -2,9 +2,13 @@ package analyzer
 
 import (
 	"fmt"
+	"go/constant"
+	"sort"
 	"strings"
 
+	dberrors "github.com/zditech/dbasic/pkg/errors"
 	"github.com/zditech/dbasic/pkg/parser"
+	"github.com/zditech/dbasic/pkg/plugin"
 )
 
 // Analyzer performs semantic analysis on the AST
@@ -14,6 +18,79 @@ type Analyzer struct {
 	errors   []string
 	program  *parser.Program
 	lines    []string // source lines for error context
+	withTargets []*Type // Types of enclosing WITH targets, innermost last
+	module   *Scope   // Scope of the MODULE being declared or analyzed
+	assignTarget *parser.MemberExpression // Left side of the assignment being analyzed
+	locks    []*heldLock // Enclosing LOCK blocks, innermost last
+	loopDepth int        // Number of enclosing loops
+	trap     *errorTrap  // Error handling state of the SUB, FUNCTION or METHOD being analyzed
+	gosub    *gosubRoutine // GOSUBs of the SUB, FUNCTION or METHOD being analyzed
+	results  []*Type       // Return types of the FUNCTION or METHOD being analyzed
+	receiver *receiverCopy // Value receiver of the METHOD being analyzed
+	refs     []Reference   // Identifiers resolved to symbols, for editor tooling
+	tests    map[string]int // Line of each TEST block, by lower-case name
+	benchmarks map[string]int // Line of each BENCHMARK block, by lower-case name
+	vet      bool          // Also look for suspicious constructs, reported as warnings
+	warnings []Warning     // What vet found
+	printed  map[*parser.Identifier]bool // Variables that PRINT prints directly, for vet
+	declarations map[string]*declarationFile // Go package declarations, by import path
+	declaring *declaringPackage // Package whose declarations are being resolved
+	plugins  []*plugin.Plugin // Plugins whose builtins are added
+	globalConsts map[string]parser.Statement // Top-level CONSTs, by upper-case name
+	folding  map[string]bool // Top-level CONSTs whose values are being worked out
+	moduleNames map[string]string // Module member each Go name is given to, by upper-case Go name
+}
+
+// receiverCopy tracks the value receiver of a METHOD. The method works on a
+// copy, so changing its fields is lost unless the copy is returned or read.
+type receiverCopy struct {
+	sym      *Symbol
+	changed  int  // Line of the first assignment to a field of the copy, or 0
+	returned bool // Some RETURN passes the copy back
+	read     bool // The copy is read after it is changed
+	changing bool // An assignment to a field of the copy is being analyzed
+}
+
+// heldLock is a LOCK block enclosing the statement being analyzed
+type heldLock struct {
+	mutex     string // Source text of the locked MUTEX
+	loopDepth int    // Loops that were open when the LOCK started
+}
+
+// errorTrap is the error handling state of the routine being analyzed. With
+// an ON ERROR GOTO handler, the statements before the handler label are
+// generated as a separate Go function, so GOTO cannot cross the label.
+type errorTrap struct {
+	handler   string           // Label named by ON ERROR GOTO, or ""
+	top       parser.Statement // Top-level statement of the body being analyzed
+	inHandler bool             // Analyzing the statements from the handler label on
+	labels    map[string]bool  // Labels seen, and whether they are in the handler
+	gotos     []trapGoto
+}
+
+// trapGoto is a GOTO in a routine with an error handler
+type trapGoto struct {
+	stmt      *parser.GotoStatement
+	inHandler bool
+}
+
+// gosubRoutine records the GOSUBs of the routine being analyzed. Each
+// subroutine is generated as a separate Go closure, so jumps cannot cross
+// its boundary; they are checked once all the GOSUB targets are known.
+type gosubRoutine struct {
+	index  int            // Position of the top-level statement being analyzed
+	gosubs []*parser.GosubStatement
+	labels map[string]int // Top-level position of each label seen
+	jumps  []gosubJump
+}
+
+// gosubJump is a GOTO, EXIT SUB, EXIT FUNCTION or RETURN with a value in a
+// routine that may have GOSUB subroutines
+type gosubJump struct {
+	line  int
+	what  string
+	label string // GOTO target, or ""
+	index int    // Position of the enclosing top-level statement
 }
 
 // New creates a new Analyzer
@@ -47,6 +124,30 @@ func (a *Analyzer) registerBuiltins() {
 	a.addBuiltin("Chr", []*Type{IntegerType}, []*Type{StringType})
 	a.addBuiltin("Space", []*Type{IntegerType}, []*Type{StringType})
 
+	// Formatting for tabular output
+	a.addBuiltin("FormatNumber", []*Type{AnyType, IntegerType, StringType}, []*Type{StringType})
+	a.addBuiltin("Format", []*Type{AnyType, StringType}, []*Type{StringType})
+	a.addBuiltin("FormatCurrency", []*Type{AnyType, StringType, StringType}, []*Type{StringType})
+	a.addBuiltin("ParseNumberLocale", []*Type{StringType, StringType}, []*Type{DoubleType, ErrorType})
+	a.addBuiltin("PadLeft", []*Type{StringType, IntegerType}, []*Type{StringType})
+	a.addBuiltin("PadRight", []*Type{StringType, IntegerType}, []*Type{StringType})
+
+	// Rune-based string functions, used for all string functions under OPTION UNICODE
+	a.addBuiltin("LenR", []*Type{StringType}, []*Type{IntegerType})
+	a.addBuiltin("LeftR", []*Type{StringType, IntegerType}, []*Type{StringType})
+	a.addBuiltin("RightR", []*Type{StringType, IntegerType}, []*Type{StringType})
+	a.addBuiltin("MidR", []*Type{StringType, IntegerType, IntegerType}, []*Type{StringType})
+	a.addBuiltin("InstrR", []*Type{StringType, StringType}, []*Type{IntegerType})
+	a.addBuiltin("AscR", []*Type{StringType}, []*Type{IntegerType})
+	a.addBuiltin("RuneLen", []*Type{StringType}, []*Type{IntegerType})
+	a.addBuiltin("RuneAt", []*Type{StringType, IntegerType}, []*Type{IntegerType})
+	a.addBuiltin("Runes", []*Type{StringType}, []*Type{NewSliceType(IntegerType)})
+	a.addBuiltin("NormalizeNFC", []*Type{StringType}, []*Type{StringType})
+	// IsLetter, IsDigit and IsSpace take a STRING or an INTEGER code point
+	a.addBuiltin("IsLetter", []*Type{AnyType}, []*Type{BooleanType})
+	a.addBuiltin("IsDigit", []*Type{AnyType}, []*Type{BooleanType})
+	a.addBuiltin("IsSpace", []*Type{AnyType}, []*Type{BooleanType})
+
 	// Type conversion
 	a.addBuiltin("Int", []*Type{AnyType}, []*Type{IntegerType})
 	a.addBuiltin("Lng", []*Type{AnyType}, []*Type{LongType})
@@ -60,6 +161,17 @@ func (a *Analyzer) registerBuiltins() {
 	a.addBuiltin("MakeBytes", []*Type{IntegerType}, []*Type{BytesType})
 	a.addBuiltin("LenBytes", []*Type{BytesType}, []*Type{IntegerType})
 
+	// Base64 and hex; the encoders take a STRING or BYTES, checked in
+	// analyzeCallExpression, and the decoders return nil for invalid text
+	a.addBuiltin("Base64Encode", []*Type{AnyType}, []*Type{StringType})
+	a.addBuiltin("Base64Decode", []*Type{StringType}, []*Type{BytesType})
+	a.addBuiltin("HexEncode", []*Type{AnyType}, []*Type{StringType})
+	a.addBuiltin("HexDecode", []*Type{StringType}, []*Type{BytesType})
+
+	// AES-GCM encryption: key, then data, each a STRING or BYTES
+	a.addBuiltin("AESEncrypt", []*Type{AnyType, AnyType}, []*Type{BytesType})
+	a.addBuiltin("AESDecrypt", []*Type{AnyType, AnyType}, []*Type{BytesType})
+
 	// Math functions
 	a.addBuiltin("Abs", []*Type{DoubleType}, []*Type{DoubleType})
 	a.addBuiltin("Sqr", []*Type{DoubleType}, []*Type{DoubleType})
@@ -86,28 +198,164 @@ func (a *Analyzer) registerBuiltins() {
 	a.addBuiltin("Rnd", []*Type{}, []*Type{DoubleType})
 	a.addBuiltin("RndInt", []*Type{IntegerType}, []*Type{IntegerType})
 	a.addBuiltin("RndRange", []*Type{IntegerType, IntegerType}, []*Type{IntegerType})
-	a.addBuiltin("Randomize", []*Type{LongType}, []*Type{})
+	a.addVariadicBuiltin("Randomize", []*Type{}, []*Type{}) // Randomize() or Randomize(seed)
+	a.addBuiltin("RndBytes", []*Type{IntegerType}, []*Type{BytesType})
+	a.addBuiltin("RndSecureInt", []*Type{IntegerType}, []*Type{IntegerType})
 
 	// Date/Time functions
 	a.addBuiltin("Timer", []*Type{}, []*Type{DoubleType})
 	a.addBuiltin("Now", []*Type{}, []*Type{LongType})
 	a.addBuiltin("Date", []*Type{}, []*Type{StringType})
-	a.addBuiltin("Year", []*Type{}, []*Type{IntegerType})
-	a.addBuiltin("Month", []*Type{}, []*Type{IntegerType})
-	a.addBuiltin("Day", []*Type{}, []*Type{IntegerType})
-	a.addBuiltin("Hour", []*Type{}, []*Type{IntegerType})
-	a.addBuiltin("Minute", []*Type{}, []*Type{IntegerType})
-	a.addBuiltin("Second", []*Type{}, []*Type{IntegerType})
+	// Year() is the current year and Year(t) the year of a DATETIME; the
+	// other parts work the same way
+	for _, name := range []string{"Year", "Month", "Day", "Hour", "Minute", "Second"} {
+		a.addVariadicBuiltin(name, []*Type{}, []*Type{IntegerType})
+		a.symbols.Resolve(name).Type.VariadicType = DateTimeType
+	}
 	a.addBuiltin("Sleep", []*Type{IntegerType}, []*Type{})
+	a.addBuiltin("After", []*Type{IntegerType}, []*Type{NewChannelType(BooleanType)})
+	a.addBuiltin("Every", []*Type{IntegerType}, []*Type{NewChannelType(LongType)})
+
+	// DATETIME and DURATION functions
+	a.addBuiltin("DateTimeNow", []*Type{}, []*Type{DateTimeType})
+	a.addBuiltin("Today", []*Type{}, []*Type{DateTimeType})
+	a.addBuiltin("DateSerial", []*Type{IntegerType, IntegerType, IntegerType}, []*Type{DateTimeType})
+	a.addBuiltin("TimeSerial", []*Type{IntegerType, IntegerType, IntegerType}, []*Type{DurationType})
+	a.addBuiltin("Days", []*Type{DoubleType}, []*Type{DurationType})
+	a.addBuiltin("Hours", []*Type{DoubleType}, []*Type{DurationType})
+	a.addBuiltin("Minutes", []*Type{DoubleType}, []*Type{DurationType})
+	a.addBuiltin("Seconds", []*Type{DoubleType}, []*Type{DurationType})
+	a.addBuiltin("Milliseconds", []*Type{DoubleType}, []*Type{DurationType})
+	a.addBuiltin("TotalSeconds", []*Type{DurationType}, []*Type{DoubleType})
+	a.addBuiltin("DateParse", []*Type{StringType, StringType}, []*Type{DateTimeType, ErrorType})
+	a.addBuiltin("DateFormat", []*Type{DateTimeType, StringType}, []*Type{StringType})
+	a.addBuiltin("DateAdd", []*Type{DateTimeType, StringType, IntegerType}, []*Type{DateTimeType})
+	a.addBuiltin("DateDiff", []*Type{DateTimeType, DateTimeType, StringType}, []*Type{LongType})
+	a.addBuiltin("ToTimeZone", []*Type{DateTimeType, StringType}, []*Type{DateTimeType, ErrorType})
+	a.addBuiltin("ToUTC", []*Type{DateTimeType}, []*Type{DateTimeType})
+	a.addBuiltin("ToLocal", []*Type{DateTimeType}, []*Type{DateTimeType})
+
+	// CONTEXT functions
+	a.addBuiltin("Background", []*Type{}, []*Type{ContextType})
+	a.addBuiltin("WithCancel", []*Type{ContextType}, []*Type{ContextType, NewSubType(nil)})
+	a.addBuiltin("WithTimeout", []*Type{ContextType, IntegerType}, []*Type{ContextType, NewSubType(nil)})
+	a.addBuiltin("Done", []*Type{ContextType}, []*Type{NewChannelType(VoidType)})
+	a.addBuiltin("Cancelled", []*Type{ContextType}, []*Type{BooleanType})
+	a.addBuiltin("SleepContext", []*Type{ContextType, IntegerType}, []*Type{BooleanType})
 
 	// File functions
 	a.addBuiltin("FileExists", []*Type{StringType}, []*Type{BooleanType})
 	a.addBuiltin("ReadFile", []*Type{StringType}, []*Type{StringType})
+	a.addBuiltin("ReadLines", []*Type{StringType}, []*Type{NewChannelType(StringType)})
 	a.addBuiltin("WriteFile", []*Type{StringType, StringType}, []*Type{})
 	a.addBuiltin("AppendFile", []*Type{StringType, StringType}, []*Type{})
 	a.addBuiltin("DeleteFile", []*Type{StringType}, []*Type{})
 	a.addBuiltin("MkDir", []*Type{StringType}, []*Type{})
 	a.addBuiltin("RmDir", []*Type{StringType}, []*Type{})
+	a.addBuiltin("ListDir", []*Type{StringType}, []*Type{NewSliceType(StringType)})
+	a.addBuiltin("Glob", []*Type{StringType}, []*Type{NewSliceType(StringType)})
+	a.addBuiltin("IsDir", []*Type{StringType}, []*Type{BooleanType})
+	a.addBuiltin("FileSize", []*Type{StringType}, []*Type{LongType})
+	a.addBuiltin("FileModTime", []*Type{StringType}, []*Type{DateTimeType})
+	a.addBuiltin("TempFile", []*Type{StringType}, []*Type{StringType})
+	a.addBuiltin("TempDir", []*Type{StringType}, []*Type{StringType})
+	a.addBuiltin("RemoveAll", []*Type{StringType}, []*Type{ErrorType})
+	a.addBuiltin("WatchPath", []*Type{StringType}, []*Type{NewChannelType(StringType)})
+
+	// Environment functions
+	a.addBuiltin("GetEnv", []*Type{StringType}, []*Type{StringType})
+	a.addBuiltin("SetEnv", []*Type{StringType, StringType}, []*Type{})
+	a.addBuiltin("EnvExists", []*Type{StringType}, []*Type{BooleanType})
+	a.addBuiltin("Environ", []*Type{}, []*Type{JSONType})
+
+	// Command-line arguments
+	a.addBuiltin("Command$", []*Type{IntegerType}, []*Type{StringType})
+	a.addBuiltin("ArgCount", []*Type{}, []*Type{IntegerType})
+	a.addBuiltin("Args", []*Type{}, []*Type{NewSliceType(StringType)})
+
+	// Processes: output, exit code and an error if the program could not run
+	a.addBuiltin("Shell", []*Type{StringType}, []*Type{StringType, IntegerType, ErrorType})
+	a.addBuiltin("Exec", []*Type{StringType, NewSliceType(StringType)}, []*Type{StringType, IntegerType, ErrorType})
+	a.addBuiltin("ExecAsync", []*Type{StringType, NewSliceType(StringType)}, []*Type{NewChannelType(StringType)})
+
+	// Sound
+	a.addBuiltin("Beep", []*Type{}, []*Type{})
+	a.addBuiltin("Tone", []*Type{IntegerType, IntegerType}, []*Type{ErrorType})
+	a.addBuiltin("PlayWav", []*Type{StringType}, []*Type{ErrorType})
+
+	// Graphics; Circle and Rect take an optional fill flag
+	a.addBuiltin("Screen", []*Type{IntegerType, IntegerType}, []*Type{})
+	a.addBuiltin("PSet", []*Type{IntegerType, IntegerType, IntegerType}, []*Type{})
+	a.addBuiltin("Line", []*Type{IntegerType, IntegerType, IntegerType, IntegerType, IntegerType}, []*Type{})
+	a.addVariadicBuiltin("Circle", []*Type{IntegerType, IntegerType, IntegerType, IntegerType}, []*Type{})
+	a.symbols.Resolve("Circle").Type.VariadicType = BooleanType
+	a.addVariadicBuiltin("Rect", []*Type{IntegerType, IntegerType, IntegerType, IntegerType, IntegerType}, []*Type{})
+	a.symbols.Resolve("Rect").Type.VariadicType = BooleanType
+	a.addBuiltin("Text", []*Type{IntegerType, IntegerType, StringType, IntegerType}, []*Type{})
+	a.addBuiltin("Flip", []*Type{}, []*Type{})
+	a.addBuiltin("RGB", []*Type{IntegerType, IntegerType, IntegerType}, []*Type{IntegerType})
+	a.addBuiltin("DrawImage", []*Type{ImageType, IntegerType, IntegerType}, []*Type{})
+
+	// Images
+	a.addBuiltin("LoadImage", []*Type{StringType}, []*Type{ImageType, ErrorType})
+	a.addBuiltin("SaveImage", []*Type{ImageType, StringType}, []*Type{ErrorType})
+	a.addBuiltin("NewImage", []*Type{IntegerType, IntegerType}, []*Type{ImageType})
+	a.addBuiltin("ImageWidth", []*Type{ImageType}, []*Type{IntegerType})
+	a.addBuiltin("ImageHeight", []*Type{ImageType}, []*Type{IntegerType})
+	a.addBuiltin("GetPixel", []*Type{ImageType, IntegerType, IntegerType}, []*Type{IntegerType})
+	a.addBuiltin("SetPixel", []*Type{ImageType, IntegerType, IntegerType, IntegerType}, []*Type{})
+	a.addBuiltin("Resize", []*Type{ImageType, IntegerType, IntegerType}, []*Type{ImageType})
+
+	// TCP sockets; TcpClose is checked in analyzeCallExpression
+	a.addBuiltin("TcpListen", []*Type{StringType}, []*Type{ListenerType, ErrorType})
+	a.addBuiltin("TcpAccept", []*Type{ListenerType}, []*Type{ConnectionType, ErrorType})
+	a.addBuiltin("TcpConnect", []*Type{StringType}, []*Type{ConnectionType, ErrorType})
+	a.addBuiltin("TcpSend", []*Type{ConnectionType, StringType}, []*Type{ErrorType})
+	a.addBuiltin("TcpReceive", []*Type{ConnectionType}, []*Type{StringType, ErrorType})
+	a.addBuiltin("TcpReceiveAsync", []*Type{ConnectionType}, []*Type{NewChannelType(StringType)})
+
+	// UDP sockets: datagrams to and from any address
+	a.addBuiltin("UdpBind", []*Type{StringType}, []*Type{ConnectionType, ErrorType})
+	a.addBuiltin("UdpSendTo", []*Type{ConnectionType, StringType, StringType}, []*Type{ErrorType})
+	a.addBuiltin("UdpReceiveFrom", []*Type{ConnectionType}, []*Type{StringType, StringType, ErrorType})
+	a.addBuiltin("UdpClose", []*Type{ConnectionType}, []*Type{})
+
+	// WebSockets: whole text messages over a CONNECTION; WsClose is checked
+	// in analyzeCallExpression
+	a.addBuiltin("WsConnect", []*Type{StringType}, []*Type{ConnectionType, ErrorType})
+	a.addBuiltin("WsListen", []*Type{StringType}, []*Type{ListenerType, ErrorType})
+	a.addBuiltin("WsAccept", []*Type{ListenerType}, []*Type{ConnectionType, ErrorType})
+	a.addBuiltin("WsSend", []*Type{ConnectionType, StringType}, []*Type{ErrorType})
+	a.addBuiltin("WsReceive", []*Type{ConnectionType}, []*Type{StringType, ErrorType})
+	a.addBuiltin("WsReceiveAsync", []*Type{ConnectionType}, []*Type{NewChannelType(StringType)})
+
+	// SQL databases; statements run on a DATABASE or a TRANSACTION, checked
+	// in analyzeCallExpression, with arguments for the ? placeholders
+	a.addBuiltin("DBOpen", []*Type{StringType, StringType}, []*Type{DatabaseType, ErrorType})
+	a.addBuiltin("DBClose", []*Type{DatabaseType}, []*Type{})
+	a.addVariadicBuiltin("DBExec", []*Type{AnyType, StringType}, []*Type{LongType, ErrorType})
+	a.addVariadicBuiltin("DBInsert", []*Type{AnyType, StringType}, []*Type{LongType, ErrorType})
+	a.addVariadicBuiltin("DBQuery", []*Type{AnyType, StringType}, []*Type{NewSliceType(JSONType), ErrorType})
+	a.addVariadicBuiltin("DBQueryRow", []*Type{AnyType, StringType}, []*Type{JSONType, ErrorType})
+	a.addBuiltin("DBBegin", []*Type{DatabaseType}, []*Type{TransactionType, ErrorType})
+	a.addBuiltin("DBCommit", []*Type{TransactionType}, []*Type{ErrorType})
+	a.addBuiltin("DBRollback", []*Type{TransactionType}, []*Type{ErrorType})
+
+	// Key-value store kept in a file
+	a.addBuiltin("KVOpen", []*Type{StringType}, []*Type{KVStoreType, ErrorType})
+	a.addBuiltin("KVGet", []*Type{KVStoreType, StringType}, []*Type{StringType})
+	a.addBuiltin("KVHas", []*Type{KVStoreType, StringType}, []*Type{BooleanType})
+	a.addBuiltin("KVSet", []*Type{KVStoreType, StringType, StringType}, []*Type{ErrorType})
+	a.addBuiltin("KVDelete", []*Type{KVStoreType, StringType}, []*Type{ErrorType})
+	a.addBuiltin("KVKeys", []*Type{KVStoreType}, []*Type{NewSliceType(StringType)})
+
+	// Email: server, port, user, password, from, to, subject, body
+	a.addBuiltin("SendMail", []*Type{StringType, IntegerType, StringType, StringType,
+		StringType, StringType, StringType, StringType}, []*Type{ErrorType})
+	a.addBuiltin("EOF", []*Type{IntegerType}, []*Type{BooleanType}) // EOF(n) for a file opened AS #n
+
+	// Error trapping (ON ERROR GOTO)
+	a.addBuiltin("ErrorMessage", []*Type{}, []*Type{StringType})
 
 	// Printf functions (variadic - additional args not type-checked)
 	a.addVariadicBuiltin("Printf", []*Type{StringType}, []*Type{})            // fmt.Printf(format, args...)
@@ -122,12 +370,41 @@ func (a *Analyzer) registerBuiltins() {
 	a.addBuiltin("JSONParse", []*Type{StringType}, []*Type{JSONType})
 	a.addBuiltin("JSONStringify", []*Type{JSONType}, []*Type{StringType})
 	a.addBuiltin("JSONPretty", []*Type{JSONType}, []*Type{StringType})
+	a.addBuiltin("YAMLParse", []*Type{StringType}, []*Type{JSONType})
+	a.addBuiltin("YAMLStringify", []*Type{JSONType}, []*Type{StringType})
+	a.addBuiltin("TOMLParse", []*Type{StringType}, []*Type{JSONType})
+	a.addBuiltin("TOMLStringify", []*Type{JSONType}, []*Type{StringType})
+	a.addBuiltin("XMLParse", []*Type{StringType}, []*Type{JSONType})
+	a.addBuiltin("XMLGet", []*Type{JSONType, StringType}, []*Type{StringType})
+	a.addBuiltin("XMLGetAll", []*Type{JSONType, StringType}, []*Type{NewSliceType(StringType)})
+	a.addBuiltin("XMLStringify", []*Type{JSONType}, []*Type{StringType})
+	a.addBuiltin("RenderTemplate", []*Type{StringType, AnyType}, []*Type{StringType})
+	a.addBuiltin("RenderTemplateFile", []*Type{StringType, AnyType}, []*Type{StringType})
 	a.addBuiltin("JSONGet", []*Type{JSONType, StringType}, []*Type{AnyType})
 	a.addBuiltin("JSONSet", []*Type{JSONType, StringType, AnyType}, []*Type{})
 
 	// Struct/JSON conversion functions
 	a.addBuiltin("StructToJSON", []*Type{AnyType}, []*Type{JSONType})
 	a.addBuiltin("JSONToStruct", []*Type{JSONType, AnyType}, []*Type{AnyType})
+
+	// CSV functions
+	csvRows := NewSliceType(NewSliceType(StringType))
+	a.addBuiltin("CSVParse", []*Type{StringType}, []*Type{csvRows})
+	a.addBuiltin("CSVRead", []*Type{StringType}, []*Type{csvRows})
+	a.addBuiltin("CSVWrite", []*Type{StringType, csvRows}, []*Type{})
+	a.addBuiltin("CSVParseRecords", []*Type{StringType}, []*Type{NewSliceType(JSONType)})
+	a.addBuiltin("CSVReadRecords", []*Type{StringType}, []*Type{NewSliceType(JSONType)})
+
+	// INI functions: a JSON object of sections, each an object of keys
+	a.addBuiltin("INILoad", []*Type{StringType}, []*Type{JSONType})
+	a.addBuiltin("INIGet", []*Type{JSONType, StringType, StringType, StringType}, []*Type{StringType})
+	a.addBuiltin("INISave", []*Type{StringType, JSONType}, []*Type{})
+
+	// Assertions for TEST blocks, each with an optional message
+	a.addVariadicBuiltin("AssertEqual", []*Type{AnyType, AnyType}, []*Type{})
+	a.symbols.Resolve("AssertEqual").Type.VariadicType = StringType
+	a.addVariadicBuiltin("AssertTrue", []*Type{BooleanType}, []*Type{})
+	a.symbols.Resolve("AssertTrue").Type.VariadicType = StringType
 }
 
 func (a *Analyzer) addBuiltin(name string, params []*Type, returns []*Type) {
@@ -185,36 +462,66 @@ func (a *Analyzer) Analyze(program *parser.Program) (*SymbolTable, []string) {
 			a.symbols.AddImport(is.Package, is.Alias)
 		}
 	}
+	a.declarePackages()
+	a.declareBuiltins()
+
+	// Global CONSTs are found first, so the STRING * n widths and array
+	// bounds of TYPEs and DIMs declared before them can use their values
+	a.collectGlobalConsts(program)
 
-	// Second pass: collect all type definitions
+	// Second pass: collect all type definitions. Interface names are
+	// registered first so struct fields and method signatures can use them.
+	for _, stmt := range program.Statements {
+		if is, ok := stmt.(*parser.InterfaceStatement); ok {
+			a.types.Register(is.Name.Value, NewInterfaceType(is.Name.Value, nil))
+		}
+	}
 	for _, stmt := range program.Statements {
 		if ts, ok := stmt.(*parser.TypeStatement); ok {
 			a.declareType(ts)
 		}
 	}
+	for _, stmt := range program.Statements {
+		if is, ok := stmt.(*parser.InterfaceStatement); ok {
+			a.declareInterface(is)
+		}
+	}
 
 	// Third pass: collect all function/sub/method declarations
 	for _, stmt := range program.Statements {
 		switch s := stmt.(type) {
 		case *parser.SubStatement:
-			a.declareSubOrFunction(s.Name.Value, s.Params, nil, s)
+			a.declareSubOrFunction(s.Name, s.Params, nil, s)
 		case *parser.FunctionStatement:
-			a.declareSubOrFunction(s.Name.Value, s.Params, s.ReturnTypes, s)
+			a.declareSubOrFunction(s.Name, s.Params, s.ReturnTypes, s)
 		case *parser.MethodStatement:
 			a.declareMethod(s)
+		case *parser.ModuleStatement:
+			a.declareModule(s)
+		case *parser.TypeStatement:
+			if s.Constructor != nil {
+				a.declareConstructor(s)
+			}
 		}
 	}
 
 	// Fourth pass: declare global DIM statements (so they're available in all functions)
 	for _, stmt := range program.Statements {
-		if ds, ok := stmt.(*parser.DimStatement); ok {
-			varType := a.resolveTypeSpec(ds.Type)
-			sym := &Symbol{
-				Name: ds.Name.Value,
-				Kind: SymVariable,
-				Type: varType,
+		switch s := stmt.(type) {
+		case *parser.DimStatement:
+			a.declareGlobalDim(s)
+		case *parser.EmbedStatement:
+			a.declareEmbed(s)
+		case *parser.ModuleStatement:
+			if mod := a.symbols.GlobalScope.ResolveLocal(s.Name.Value); mod != nil && mod.Kind == SymModule {
+				a.inModule(mod.Members, func() {
+					for _, member := range s.Body {
+						if ds, ok := member.(*parser.DimStatement); ok {
+							a.declareGlobalDim(ds)
+						}
+					}
+				})
 			}
-			a.symbols.DefineGlobal(sym)
 		}
 	}
 
@@ -222,10 +529,88 @@ func (a *Analyzer) Analyze(program *parser.Program) (*SymbolTable, []string) {
 	for _, stmt := range program.Statements {
 		a.analyzeStatement(stmt)
 	}
+	a.vetUnassigned()
+	a.vetMaybeUnassigned(program)
 
 	return a.symbols, a.errors
 }
 
+// declareGlobalDim declares a DIM at the top level of the program or a module
+func (a *Analyzer) declareGlobalDim(ds *parser.DimStatement) {
+	sym := &Symbol{
+		Name: ds.Name.Value,
+		Kind: SymVariable,
+		Type: a.dimType(ds),
+		Node: ds,
+	}
+	if err := a.define(sym, ds.Name); err != nil {
+		a.error(ds.Token.Line, dberrors.DuplicateDefinition, err.Error())
+	}
+}
+
+// declareEmbed declares the variable of an EMBEDFILE, which holds the
+// file's BYTES, or EMBEDDIR, which maps each file's path to its BYTES
+func (a *Analyzer) declareEmbed(stmt *parser.EmbedStatement) {
+	typ := BytesType
+	if stmt.IsDir {
+		typ = NewMapType(StringType, BytesType)
+	}
+	sym := &Symbol{
+		Name: stmt.Name.Value,
+		Kind: SymVariable,
+		Type: typ,
+		Node: stmt,
+	}
+	if err := a.define(sym, stmt.Name); err != nil {
+		a.error(stmt.Token.Line, dberrors.DuplicateDefinition, err.Error())
+	}
+}
+
+// declareModule registers a MODULE and declares its SUBs and FUNCTIONs in
+// the module's own scope
+func (a *Analyzer) declareModule(stmt *parser.ModuleStatement) {
+	sym := &Symbol{
+		Name:    stmt.Name.Value,
+		Kind:    SymModule,
+		Type:    AnyType,
+		Node:    stmt,
+		Members: NewScope(stmt.Name.Value, a.symbols.GlobalScope),
+	}
+	// A module may take the name of a builtin, which it then hides
+	a.hideBuiltin(sym.Name)
+	sym.Decl = stmt.Name
+	if err := a.symbols.DefineGlobal(sym); err != nil {
+		a.error(stmt.Token.Line, dberrors.DuplicateDefinition, err.Error())
+		return
+	}
+	a.refer(stmt.Name, sym)
+
+	a.inModule(sym.Members, func() {
+		for _, member := range stmt.Body {
+			switch s := member.(type) {
+			case *parser.SubStatement:
+				a.declareSubOrFunction(s.Name, s.Params, nil, s)
+			case *parser.FunctionStatement:
+				a.declareSubOrFunction(s.Name, s.Params, s.ReturnTypes, s)
+			}
+		}
+	})
+}
+
+// inModule runs fn with the given module scope as the current scope
+func (a *Analyzer) inModule(scope *Scope, fn func()) {
+	savedScope, savedModule := a.symbols.CurrentScope, a.module
+	a.symbols.CurrentScope, a.module = scope, scope
+	fn()
+	a.symbols.CurrentScope, a.module = savedScope, savedModule
+}
+
+// atTopLevel reports whether declarations are at the top level of the
+// program or of a module rather than inside a routine
+func (a *Analyzer) atTopLevel() bool {
+	return a.symbols.IsGlobalScope() || (a.module != nil && a.symbols.CurrentScope == a.module)
+}
+
 // TypeRegistry returns the type registry
 func (a *Analyzer) TypeRegistry() *TypeRegistry {
 	return a.types
@@ -241,16 +626,74 @@ func (a *Analyzer) SymbolTable() *SymbolTable {
 	return a.symbols
 }
 
-func (a *Analyzer) error(line int, format string, args ...interface{}) {
-	a.errorWithHint(line, format, "", args...)
+// References returns every identifier resolved to a symbol, including the
+// names in declarations, in the order they were analyzed
+func (a *Analyzer) References() []Reference {
+	return a.refs
+}
+
+// define declares sym in the current scope under the identifier name. A
+// global of the program hides a builtin with the same name.
+func (a *Analyzer) define(sym *Symbol, name *parser.Identifier) error {
+	sym.Decl = name
+	if a.symbols.CurrentScope == a.symbols.GlobalScope {
+		a.hideBuiltin(sym.Name)
+	}
+	if err := a.symbols.Define(sym); err != nil {
+		return err
+	}
+	a.refer(name, sym)
+	return nil
+}
+
+// hideBuiltin removes the builtin named name, if there is one, so that a
+// SUB, FUNCTION, variable or module of the program can take its name, as
+// programs written before the builtin was added do
+func (a *Analyzer) hideBuiltin(name string) {
+	if old := a.symbols.GlobalScope.ResolveLocal(name); old != nil && old.Kind == SymFunction && old.Node == nil {
+		delete(a.symbols.GlobalScope.symbols, strings.ToUpper(name))
+	}
+}
+
+// isBuiltinCall reports whether a call names a builtin, and not a SUB,
+// FUNCTION or variable of the program that hides it
+func (a *Analyzer) isBuiltinCall(ident *parser.Identifier) bool {
+	sym := a.symbols.Resolve(ident.Value)
+	return sym == nil || (sym.Node == nil && sym.Decl == nil)
+}
+
+// isContainerOp reports whether name is an operation of a STACK, QUEUE or SET
+func isContainerOp(name string) bool {
+	switch strings.ToUpper(name) {
+	case "PUSH", "POP", "PEEK", "ENQUEUE", "DEQUEUE", "ADD", "HAS", "REMOVE":
+		return true
+	}
+	return false
+}
+
+// refer records that ident names sym
+func (a *Analyzer) refer(ident *parser.Identifier, sym *Symbol) {
+	a.refs = append(a.refs, Reference{Ident: ident, Symbol: sym})
 }
 
-func (a *Analyzer) errorWithHint(line int, format string, hint string, args ...interface{}) {
+// error reports an error with its code, one of the semantic codes in
+// package errors
+func (a *Analyzer) error(line int, code string, format string, args ...interface{}) {
+	a.errorWithHint(line, code, format, "", args...)
+}
+
+func (a *Analyzer) errorWithHint(line int, code string, format string, hint string, args ...interface{}) {
 	var sb strings.Builder
 	msg := fmt.Sprintf(format, args...)
 
+	// Lines of a .dbasi file are not lines of the program
+	if a.declaring != nil {
+		msg = fmt.Sprintf("%s (in %s, line %d)", msg, a.declaring.file, line)
+		line = 0
+	}
+
 	if line > 0 {
-		sb.WriteString(fmt.Sprintf("semantic error at line %d: %s\n", line, msg))
+		sb.WriteString(fmt.Sprintf("semantic error[%s] at line %d: %s\n", code, line, msg))
 
 		// Show source line with context
 		sourceLine := a.getSourceLine(line)
@@ -258,7 +701,7 @@ func (a *Analyzer) errorWithHint(line int, format string, hint string, args ...i
 			sb.WriteString(fmt.Sprintf("  %d | %s\n", line, sourceLine))
 		}
 	} else {
-		sb.WriteString(fmt.Sprintf("semantic error: %s\n", msg))
+		sb.WriteString(fmt.Sprintf("semantic error[%s]: %s\n", code, msg))
 	}
 
 	if hint != "" {
@@ -280,9 +723,88 @@ func (a *Analyzer) declareType(stmt *parser.TypeStatement) {
 
 	structType := NewStructType(stmt.Name.Value, fields)
 	structType.Implements = stmt.Implements // Copy interface info
+	for _, embed := range stmt.Embedded {
+		structType.Embedded = append(structType.Embedded, embed.TypeName)
+	}
+	structType.Properties = a.declareProperties(stmt, fields)
 	a.types.Register(stmt.Name.Value, structType)
 }
 
+// declareProperties merges the PROPERTY GET and SET accessors of a TYPE into
+// one entry per property name
+func (a *Analyzer) declareProperties(stmt *parser.TypeStatement, fields []*StructField) []*StructProperty {
+	var props []*StructProperty
+	for _, decl := range stmt.Properties {
+		kind, typeSpec := "GET", decl.ReturnType
+		if decl.IsSet {
+			kind, typeSpec = "SET", decl.Param.Type
+		}
+		propType := a.resolveTypeSpec(typeSpec)
+
+		for _, f := range fields {
+			if strings.EqualFold(f.Name, decl.Name.Value) {
+				a.error(decl.Token.Line, dberrors.DuplicateDefinition, "property %s conflicts with field %s of type %s",
+					decl.Name.Value, f.Name, stmt.Name.Value)
+			}
+		}
+
+		var prop *StructProperty
+		for _, p := range props {
+			if strings.EqualFold(p.Name, decl.Name.Value) {
+				prop = p
+				break
+			}
+		}
+		if prop == nil {
+			prop = &StructProperty{Name: decl.Name.Value, Type: propType}
+			props = append(props, prop)
+		} else if propType.GoType() != prop.Type.GoType() {
+			a.error(decl.Token.Line, dberrors.TypeMismatch, "PROPERTY %s %s uses type %s, but the other accessor uses %s",
+				kind, decl.Name.Value, propType.String(), prop.Type.String())
+		}
+
+		if (decl.IsSet && prop.HasSet) || (!decl.IsSet && prop.HasGet) {
+			a.error(decl.Token.Line, dberrors.DuplicateDefinition, "duplicate PROPERTY %s %s in TYPE %s", kind, decl.Name.Value, stmt.Name.Value)
+		}
+		if decl.IsSet {
+			prop.HasSet = true
+		} else {
+			prop.HasGet = true
+		}
+	}
+	return props
+}
+
+func (a *Analyzer) declareInterface(stmt *parser.InterfaceStatement) {
+	ifaceType := a.types.Lookup(stmt.Name.Value)
+	seen := make(map[string]bool)
+	for _, m := range stmt.Methods {
+		upper := strings.ToUpper(m.Name.Value)
+		if seen[upper] {
+			a.error(m.Token.Line, dberrors.DuplicateDefinition, "duplicate method %s in interface %s", m.Name.Value, stmt.Name.Value)
+			continue
+		}
+		seen[upper] = true
+
+		paramTypes, variadicType := a.resolveParamTypes(m.Params)
+		var retTypes []*Type
+		for _, rt := range m.ReturnTypes {
+			retTypes = append(retTypes, a.resolveTypeSpec(rt))
+		}
+
+		// Same shape as declareMethod so signatures compare directly
+		methodType := NewFunctionType(paramTypes, retTypes)
+		if variadicType != nil {
+			methodType.Variadic = true
+			methodType.VariadicType = variadicType
+		}
+		ifaceType.Methods = append(ifaceType.Methods, &InterfaceMethod{
+			Name: m.Name.Value,
+			Type: methodType,
+		})
+	}
+}
+
 func (a *Analyzer) declareMethod(stmt *parser.MethodStatement) {
 	// Get the receiver type name
 	var receiverTypeName string
@@ -295,10 +817,7 @@ func (a *Analyzer) declareMethod(stmt *parser.MethodStatement) {
 	// Method name is TypeName.MethodName for symbol table
 	methodName := receiverTypeName + "." + stmt.Name.Value
 
-	var paramTypes []*Type
-	for _, p := range stmt.Params {
-		paramTypes = append(paramTypes, a.resolveTypeSpec(p.Type))
-	}
+	paramTypes, variadicType := a.resolveParamTypes(stmt.Params)
 
 	var retTypes []*Type
 	for _, rt := range stmt.ReturnTypes {
@@ -306,6 +825,10 @@ func (a *Analyzer) declareMethod(stmt *parser.MethodStatement) {
 	}
 
 	symType := NewFunctionType(paramTypes, retTypes)
+	if variadicType != nil {
+		symType.Variadic = true
+		symType.VariadicType = variadicType
+	}
 
 	sym := &Symbol{
 		Name: methodName,
@@ -315,15 +838,38 @@ func (a *Analyzer) declareMethod(stmt *parser.MethodStatement) {
 	}
 
 	if err := a.symbols.DefineGlobal(sym); err != nil {
-		a.error(stmt.Token.Line, "duplicate method definition: %s", methodName)
+		a.error(stmt.Token.Line, dberrors.DuplicateDefinition, "duplicate method definition: %s", methodName)
 	}
 }
 
-func (a *Analyzer) declareSubOrFunction(name string, params []*parser.Parameter, returnTypes []*parser.TypeSpec, node parser.Node) {
-	var paramTypes []*Type
-	for _, p := range params {
-		paramTypes = append(paramTypes, a.resolveTypeSpec(p.Type))
+// declareConstructor declares the SUB New of a TYPE as TypeName.New, a
+// function returning a value of the type
+func (a *Analyzer) declareConstructor(stmt *parser.TypeStatement) {
+	structType := a.types.Lookup(stmt.Name.Value)
+	if structType == nil {
+		return
+	}
+	paramTypes, variadicType := a.resolveParamTypes(stmt.Constructor.Params)
+	symType := NewFunctionType(paramTypes, []*Type{structType})
+	if variadicType != nil {
+		symType.Variadic = true
+		symType.VariadicType = variadicType
+	}
+
+	name := stmt.Name.Value + ".New"
+	sym := &Symbol{
+		Name: name,
+		Kind: SymFunction,
+		Type: symType,
+		Node: stmt.Constructor,
+	}
+	if err := a.symbols.DefineGlobal(sym); err != nil {
+		a.error(stmt.Constructor.Token.Line, dberrors.DuplicateDefinition, "duplicate method definition: %s", name)
 	}
+}
+
+func (a *Analyzer) declareSubOrFunction(name *parser.Identifier, params []*parser.Parameter, returnTypes []*parser.TypeSpec, node parser.Node) {
+	paramTypes, variadicType := a.resolveParamTypes(params)
 
 	var retTypes []*Type
 	for _, rt := range returnTypes {
@@ -340,17 +886,48 @@ func (a *Analyzer) declareSubOrFunction(name string, params []*parser.Parameter,
 		symType = NewSubType(paramTypes)
 		symKind = SymSub
 	}
+	if variadicType != nil {
+		symType.Variadic = true
+		symType.VariadicType = variadicType
+	}
 
 	sym := &Symbol{
-		Name: name,
+		Name: name.Value,
 		Kind: symKind,
 		Type: symType,
 		Node: node,
 	}
 
-	if err := a.symbols.DefineGlobal(sym); err != nil {
-		a.error(0, "duplicate definition: %s", name)
+	if err := a.define(sym, name); err != nil {
+		a.error(0, dberrors.DuplicateDefinition, "duplicate definition: %s", name.Value)
+	}
+}
+
+// resolveParamTypes resolves the types of a parameter list. A trailing
+// PARAMARRAY parameter is not part of the fixed parameters; its element
+// type is returned separately (nil if there is none).
+func (a *Analyzer) resolveParamTypes(params []*parser.Parameter) ([]*Type, *Type) {
+	var paramTypes []*Type
+	var variadicType *Type
+	for _, p := range params {
+		paramType := a.resolveTypeSpec(p.Type)
+		if paramType.Kind == TypeMutex && !p.ByRef {
+			a.errorWithHint(p.Name.Token.Line, dberrors.InvalidMutex, "MUTEX parameter %s cannot be passed by value",
+				"a MUTEX cannot be copied; declare it as "+p.Name.Value+" AS POINTER TO MUTEX and pass @mutex", p.Name.Value)
+		}
+		if !p.ParamArray {
+			paramTypes = append(paramTypes, paramType)
+			continue
+		}
+		if paramType.Kind != TypeSlice {
+			a.errorWithHint(p.Name.Token.Line, dberrors.InvalidDeclaration, "PARAMARRAY parameter %s must be a slice",
+				"declare it as "+p.Name.Value+" AS []ANY or another slice type", p.Name.Value)
+			variadicType = AnyType
+			continue
+		}
+		variadicType = paramType.ElementType
 	}
+	return paramTypes, variadicType
 }
 
 func (a *Analyzer) resolveTypeSpec(spec *parser.TypeSpec) *Type {
@@ -358,6 +935,16 @@ func (a *Analyzer) resolveTypeSpec(spec *parser.TypeSpec) *Type {
 		return VoidType
 	}
 
+	if spec.Width != nil {
+		width, ok := a.intConstant(spec.Width)
+		if !ok || width <= 0 {
+			a.errorWithHint(spec.Token.Line, dberrors.InvalidConstant, "invalid width in STRING * %s",
+				"the width of a fixed-length string must be a positive integer constant", spec.Width.String())
+			return StringType
+		}
+		return NewFixedStringType(width)
+	}
+
 	if spec.IsPointer {
 		elemType := a.resolveTypeSpec(spec.ElementType)
 		return NewPointerType(elemType)
@@ -368,6 +955,43 @@ func (a *Analyzer) resolveTypeSpec(spec *parser.TypeSpec) *Type {
 		return NewChannelType(elemType)
 	}
 
+	if spec.IsFunction {
+		var paramTypes, retTypes []*Type
+		for _, pt := range spec.ParamTypes {
+			paramTypes = append(paramTypes, a.resolveTypeSpec(pt))
+		}
+		for _, rt := range spec.ReturnTypes {
+			retTypes = append(retTypes, a.resolveTypeSpec(rt))
+		}
+		if spec.Name == "SUB" {
+			return NewSubType(paramTypes)
+		}
+		return NewFunctionType(paramTypes, retTypes)
+	}
+
+	if spec.IsMap {
+		keyType := a.resolveTypeSpec(spec.KeyType)
+		switch keyType.Kind {
+		case TypeSlice, TypeMap, TypeJSON, TypeBytes, TypeFunction, TypeSub:
+			a.errorWithHint(spec.Token.Line, dberrors.InvalidDeclaration, "invalid map key type: %s",
+				"map keys must be comparable (numbers, strings, booleans, pointers or structs)", keyType.String())
+		}
+		valueType := a.resolveTypeSpec(spec.ElementType)
+		return NewMapType(keyType, valueType)
+	}
+
+	if spec.IsContainer {
+		elemType := a.resolveTypeSpec(spec.ElementType)
+		if spec.Name == "SET" {
+			switch elemType.Kind {
+			case TypeSlice, TypeMap, TypeJSON, TypeBytes, TypeFunction, TypeSub:
+				a.errorWithHint(spec.Token.Line, dberrors.InvalidDeclaration, "invalid set element type: %s",
+					"set elements must be comparable (numbers, strings, booleans, pointers or structs)", elemType.String())
+			}
+		}
+		return NewContainerType(spec.Name, elemType)
+	}
+
 	// Handle slice/array types with []TYPE syntax
 	if spec.IsArray {
 		var elemType *Type
@@ -381,7 +1005,7 @@ func (a *Analyzer) resolveTypeSpec(spec *parser.TypeSpec) *Type {
 				elemType = a.types.Lookup(spec.Name)
 			}
 			if elemType == nil {
-				a.error(spec.Token.Line, "unknown type: %s", spec.Name)
+				a.error(spec.Token.Line, dberrors.UnknownType, "unknown type: %s", spec.Name)
 				return AnyType
 			}
 		}
@@ -397,13 +1021,26 @@ func (a *Analyzer) resolveTypeSpec(spec *parser.TypeSpec) *Type {
 		// Verify the import exists
 		importInfo := a.symbols.GetImport(alias)
 		if importInfo == nil {
-			a.error(spec.Token.Line, "unknown package: %s", alias)
+			a.error(spec.Token.Line, dberrors.UndefinedName, "unknown package: %s", alias)
 			return AnyType
 		}
+		if importInfo.Members != nil {
+			if sym := importInfo.Members[typeName]; sym == nil || sym.Kind != SymType {
+				a.error(spec.Token.Line, dberrors.UnknownType, "package %s has no type %s", alias, typeName)
+				return AnyType
+			}
+		}
 
 		return NewExternalType(alias, typeName, importInfo.Path)
 	}
 
+	// In a .dbasi file, the package's own types are named as in Go
+	if a.declaring != nil {
+		if sym := a.declaring.members[spec.Token.Literal]; sym != nil && sym.Kind == SymType {
+			return sym.Type
+		}
+	}
+
 	// Try built-in types first
 	baseType := TypeFromName(spec.Name)
 	if baseType == nil {
@@ -411,7 +1048,7 @@ func (a *Analyzer) resolveTypeSpec(spec *parser.TypeSpec) *Type {
 		baseType = a.types.Lookup(spec.Name)
 	}
 	if baseType == nil {
-		a.error(spec.Token.Line, "unknown type: %s", spec.Name)
+		a.error(spec.Token.Line, dberrors.UnknownType, "unknown type: %s", spec.Name)
 		return AnyType
 	}
 
@@ -428,6 +1065,8 @@ func (a *Analyzer) analyzeStatement(stmt parser.Statement) {
 		a.analyzeLetStatement(s)
 	case *parser.ConstStatement:
 		a.analyzeConstStatement(s)
+	case *parser.ConstGroupStatement:
+		a.analyzeConstGroupStatement(s)
 	case *parser.AssignmentStatement:
 		a.analyzeAssignmentStatement(s)
 	case *parser.MultiAssignmentStatement:
@@ -436,20 +1075,45 @@ func (a *Analyzer) analyzeStatement(stmt parser.Statement) {
 		a.analyzePrintStatement(s)
 	case *parser.InputStatement:
 		a.analyzeInputStatement(s)
+	case *parser.OpenStatement:
+		a.analyzeOpenStatement(s)
+	case *parser.CloseStatement:
+		for _, n := range s.FileNumbers {
+			a.analyzeFileNumber(s.Token.Line, n)
+		}
+	case *parser.LineInputStatement:
+		a.analyzeLineInputStatement(s)
+	case *parser.GetPutStatement:
+		a.analyzeGetPutStatement(s)
 	case *parser.IfStatement:
 		a.analyzeIfStatement(s)
 	case *parser.ForStatement:
 		a.analyzeForStatement(s)
+	case *parser.ForEachStatement:
+		a.analyzeForEachStatement(s)
 	case *parser.WhileStatement:
 		a.analyzeWhileStatement(s)
 	case *parser.DoLoopStatement:
 		a.analyzeDoLoopStatement(s)
 	case *parser.SelectStatement:
 		a.analyzeSelectStatement(s)
+	case *parser.SelectChannelStatement:
+		a.analyzeSelectChannelStatement(s)
 	case *parser.TypeStatement:
-		// Already handled in first pass
+		a.analyzeTypeStatement(s)
+	case *parser.InterfaceStatement:
+		// Already handled in second pass
+	case *parser.ModuleStatement:
+		a.analyzeModuleStatement(s)
 	case *parser.SubStatement:
 		a.analyzeSubStatement(s)
+	case *parser.TestStatement:
+		a.analyzeTestStatement(s)
+	case *parser.BenchmarkStatement:
+		a.analyzeBenchmarkStatement(s)
+	case *parser.DeclareStatement:
+		a.errorWithHint(s.Token.Line, dberrors.NotAllowedHere, "DECLARE %s outside a declaration file",
+			"DECLARE belongs in a .dbasi file, which dbasic bind writes for a Go package", s.Kind)
 	case *parser.FunctionStatement:
 		a.analyzeFunctionStatement(s)
 	case *parser.MethodStatement:
@@ -457,9 +1121,36 @@ func (a *Analyzer) analyzeStatement(stmt parser.Statement) {
 	case *parser.ReturnStatement:
 		a.analyzeReturnStatement(s)
 	case *parser.ExitStatement:
-		// Valid exit types are checked by parser
+		a.analyzeExitStatement(s)
 	case *parser.GotoStatement:
 		// Label resolution is done later
+		a.checkLeavesLock(s.Token.Line, "GOTO")
+		if a.trap != nil {
+			a.trap.gotos = append(a.trap.gotos, trapGoto{stmt: s, inHandler: a.trap.inHandler})
+		}
+		a.addGosubJump(s.Token.Line, "GOTO "+s.Label, s.Label)
+	case *parser.GosubStatement:
+		a.analyzeGosubStatement(s)
+	case *parser.OnErrorStatement:
+		a.analyzeOnErrorStatement(s)
+	case *parser.OptionStatement:
+		a.analyzeOptionStatement(s)
+	case *parser.EmbedStatement:
+		// Declared with the global DIMs
+		if !a.symbols.IsGlobalScope() {
+			a.errorWithHint(s.Token.Line, dberrors.NotAllowedHere, "%s must be at the top level of the program",
+				"embedded files are global variables; move it out of the SUB or FUNCTION", strings.ToUpper(s.Token.Literal))
+		} else if s.Path == "" {
+			a.error(s.Token.Line, dberrors.InvalidDeclaration, "%s needs a path", strings.ToUpper(s.Token.Literal))
+		}
+	case *parser.CheckStatement:
+		a.analyzeCheckStatement(s)
+	case *parser.ErrorStatement:
+		a.analyzeErrorStatement(s)
+	case *parser.AssertStatement:
+		a.analyzeAssertStatement(s)
+	case *parser.LockStatement:
+		a.analyzeLockStatement(s)
 	case *parser.LabelStatement:
 		a.analyzeLabelStatement(s)
 	case *parser.SpawnStatement:
@@ -468,6 +1159,8 @@ func (a *Analyzer) analyzeStatement(stmt parser.Statement) {
 		a.analyzeSendStatement(s)
 	case *parser.ReceiveStatement:
 		a.analyzeReceiveStatement(s)
+	case *parser.WithStatement:
+		a.analyzeWithStatement(s)
 	case *parser.ExpressionStatement:
 		if s.Expression != nil {
 			a.analyzeExpression(s.Expression)
@@ -475,164 +1168,762 @@ func (a *Analyzer) analyzeStatement(stmt parser.Statement) {
 	}
 }
 
-func (a *Analyzer) analyzeDimStatement(stmt *parser.DimStatement) {
-	varType := a.resolveTypeSpec(stmt.Type)
-
-	// Skip defining global variables (they're already defined in the earlier pass)
-	if !a.symbols.IsGlobalScope() || a.symbols.Resolve(stmt.Name.Value) == nil {
-		sym := &Symbol{
-			Name: stmt.Name.Value,
-			Kind: SymVariable,
-			Type: varType,
-			Node: stmt,
-		}
-
-		if err := a.symbols.Define(sym); err != nil {
-			a.error(stmt.Token.Line, err.Error())
-		}
+// analyzeModuleStatement analyzes the members of a MODULE in the module's
+// scope. Each member gets a Go name prefixed with the module name, so members
+// of different modules with the same name do not collide.
+func (a *Analyzer) analyzeModuleStatement(stmt *parser.ModuleStatement) {
+	mod := a.symbols.GlobalScope.ResolveLocal(stmt.Name.Value)
+	if mod == nil || mod.Node != stmt {
+		return
 	}
-
-	if stmt.Value != nil {
-		valueType := a.analyzeExpression(stmt.Value)
-		if !varType.IsCompatibleWith(valueType) {
-			a.error(stmt.Token.Line, "type mismatch: cannot assign %s to %s",
-				valueType.String(), varType.String())
+	a.inModule(mod.Members, func() {
+		for _, member := range stmt.Body {
+			a.analyzeStatement(member)
 		}
+	})
+	members := mod.Members.AllSymbols()
+	sort.Slice(members, func(i, j int) bool { return members[i].Name < members[j].Name })
+	for _, member := range members {
+		member.GoName = mod.Name + "_" + member.Name
+		a.checkModuleGoName(mod, member)
 	}
 }
 
-func (a *Analyzer) analyzeLetStatement(stmt *parser.LetStatement) {
-	// Infer type from the value expression
-	valueType := a.analyzeExpression(stmt.Value)
-
-	sym := &Symbol{
-		Name: stmt.Name.Value,
-		Kind: SymVariable,
-		Type: valueType,
-		Node: stmt,
+// checkModuleGoName reports a module member whose Go name is also the name
+// of a global, a TYPE or a member of another module, as Math.Clamp and a
+// global Math_Clamp, or A_B.C and A.B_C
+func (a *Analyzer) checkModuleGoName(mod, member *Symbol) {
+	if a.moduleNames == nil {
+		a.moduleNames = make(map[string]string)
+	}
+	name := mod.Name + "." + member.Name
+	other := ""
+	if prev, ok := a.moduleNames[strings.ToUpper(member.GoName)]; ok {
+		other = prev
+	} else if sym := a.symbols.GlobalScope.ResolveLocal(member.GoName); sym != nil && sym.Decl != nil {
+		other = sym.Name
+	} else if a.types.Lookup(member.GoName) != nil {
+		other = "TYPE " + member.GoName
+	}
+	if other == "" {
+		a.moduleNames[strings.ToUpper(member.GoName)] = name
+		return
 	}
-
-	if err := a.symbols.Define(sym); err != nil {
-		a.error(stmt.Token.Line, err.Error())
+	line := 0
+	if member.Decl != nil {
+		line = member.Decl.Token.Line
 	}
+	a.errorWithHint(line, dberrors.DuplicateDefinition, "duplicate Go name %s for %s and %s",
+		"members of a MODULE are named Module_Member in Go; rename one of them",
+		member.GoName, name, other)
 }
 
-func (a *Analyzer) analyzeConstStatement(stmt *parser.ConstStatement) {
-	constType := a.resolveTypeSpec(stmt.Type)
-
-	sym := &Symbol{
-		Name: stmt.Name.Value,
-		Kind: SymConstant,
-		Type: constType,
-		Node: stmt,
-	}
-
-	if err := a.symbols.Define(sym); err != nil {
-		a.error(stmt.Token.Line, err.Error())
+// moduleOf returns the module named by expr, or nil if expr is not a module
+func (a *Analyzer) moduleOf(expr parser.Expression) *Symbol {
+	ident, ok := expr.(*parser.Identifier)
+	if !ok {
+		return nil
 	}
-
-	if stmt.Value != nil {
-		valueType := a.analyzeExpression(stmt.Value)
-		if !constType.IsCompatibleWith(valueType) {
-			a.error(stmt.Token.Line, "type mismatch in constant declaration")
-		}
+	if sym := a.symbols.Resolve(ident.Value); sym != nil && sym.Kind == SymModule {
+		a.refer(ident, sym)
+		return sym
 	}
+	return nil
 }
 
-func (a *Analyzer) analyzeAssignmentStatement(stmt *parser.AssignmentStatement) {
-	leftType := a.analyzeExpression(stmt.Left)
-	rightType := a.analyzeExpression(stmt.Value)
-
-	if !leftType.IsCompatibleWith(rightType) {
-		a.error(stmt.Token.Line, "type mismatch in assignment: cannot assign %s to %s",
-			rightType.String(), leftType.String())
+// moduleMember resolves a qualified Module.Member reference
+func (a *Analyzer) moduleMember(mod *Symbol, member *parser.Identifier) *Symbol {
+	sym := mod.Members.ResolveLocal(member.Value)
+	if sym == nil {
+		a.error(member.Token.Line, dberrors.UnknownMember, "module %s has no member %s", mod.Name, member.Value)
+	} else {
+		a.refer(member, sym)
 	}
+	return sym
 }
 
-func (a *Analyzer) analyzeMultiAssignmentStatement(stmt *parser.MultiAssignmentStatement) {
-	// Check for type assertion with ok pattern: value, ok = expr.(Type)
-	if typeAssert, ok := stmt.Value.(*parser.TypeAssertionExpression); ok {
-		if len(stmt.Targets) != 2 {
-			a.error(stmt.Token.Line, "type assertion with ok pattern requires exactly 2 targets (value, ok)")
-			return
-		}
-		// Analyze the type assertion value
-		a.analyzeExpression(typeAssert.Value)
-		// Analyze both targets
-		for _, target := range stmt.Targets {
-			a.analyzeExpression(target)
-		}
-		return
+// analyzeTypeStatement checks IMPLEMENTS clauses that name a DBasic INTERFACE
+func (a *Analyzer) analyzeTypeStatement(stmt *parser.TypeStatement) {
+	if stmt.Constructor != nil {
+		a.analyzeConstructor(stmt)
 	}
-
-	// Get the types of the right-hand side (should be a function call)
-	call, ok := stmt.Value.(*parser.CallExpression)
-	if !ok {
-		a.error(stmt.Token.Line, "multiple assignment requires function call or type assertion on right side")
-		return
+	for _, prop := range stmt.Properties {
+		a.analyzeProperty(stmt, prop)
 	}
 
-	// Check if this is an external Go method call - skip validation
-	if _, isMember := call.Function.(*parser.MemberExpression); isMember {
-		// External Go function call - analyze arguments and targets but don't validate return count
-		for _, arg := range call.Arguments {
-			a.analyzeExpression(arg)
-		}
-		for _, target := range stmt.Targets {
-			a.analyzeExpression(target)
-		}
+	if stmt.Implements == "" || strings.Contains(stmt.Implements, ".") {
+		// Go interfaces are checked by the Go compiler
 		return
 	}
-
-	funcSym := a.resolveFunctionCall(call)
-	if funcSym == nil || funcSym.Type == nil {
+	ifaceType := a.types.Lookup(stmt.Implements)
+	if ifaceType == nil || ifaceType.Kind != TypeInterface {
+		a.error(stmt.Token.Line, dberrors.UnknownType, "unknown interface: %s", stmt.Implements)
 		return
 	}
+	structType := a.types.Lookup(stmt.Name.Value)
+	if reason := a.interfaceMismatch(NewPointerType(structType), ifaceType); reason != "" {
+		a.error(stmt.Token.Line, dberrors.NotImplemented, "%s does not implement %s: %s",
+			stmt.Name.Value, ifaceType.Name, reason)
+	}
+}
 
-	if len(funcSym.Type.ReturnTypes) != len(stmt.Targets) {
-		a.error(stmt.Token.Line, "wrong number of values in multiple assignment: expected %d, got %d",
-			len(funcSym.Type.ReturnTypes), len(stmt.Targets))
+// analyzeConstructor analyzes the body of a TYPE's SUB New. The body acts as
+// a WITH block on the value being built, so fields are set with .Name = value.
+func (a *Analyzer) analyzeConstructor(stmt *parser.TypeStatement) {
+	structType := a.types.Lookup(stmt.Name.Value)
+	if structType == nil {
 		return
 	}
 
-	for i, target := range stmt.Targets {
-		targetType := a.analyzeExpression(target)
-		if !targetType.IsCompatibleWith(funcSym.Type.ReturnTypes[i]) {
-			a.error(stmt.Token.Line, "type mismatch in multiple assignment at position %d", i+1)
-		}
+	a.symbols.EnterScope(stmt.Name.Value + ".New")
+	defer a.symbols.ExitScope()
+
+	for _, param := range stmt.Constructor.Params {
+		a.define(&Symbol{
+			Name:    param.Name.Value,
+			Kind:    SymParameter,
+			Type:    a.resolveTypeSpec(param.Type),
+			IsByRef: param.ByRef,
+		}, param.Name)
+	}
+
+	a.withTargets = append(a.withTargets, structType)
+	a.analyzeBlockStatement(stmt.Constructor.Body)
+	a.withTargets = a.withTargets[:len(a.withTargets)-1]
+}
+
+// analyzeProperty analyzes the body of a PROPERTY GET or SET accessor. Like
+// SUB New, the body acts as a WITH block on the value.
+func (a *Analyzer) analyzeProperty(stmt *parser.TypeStatement, prop *parser.PropertyDeclaration) {
+	structType := a.types.Lookup(stmt.Name.Value)
+	if structType == nil {
+		return
+	}
+
+	a.symbols.EnterScope(stmt.Name.Value + "." + prop.Name.Value)
+	defer a.symbols.ExitScope()
+
+	if prop.IsSet {
+		a.define(&Symbol{
+			Name: prop.Param.Name.Value,
+			Kind: SymParameter,
+			Type: a.resolveTypeSpec(prop.Param.Type),
+		}, prop.Param.Name)
+	}
+
+	a.results = nil
+	if !prop.IsSet {
+		a.results = []*Type{a.resolveTypeSpec(prop.ReturnType)}
+	}
+	a.withTargets = append(a.withTargets, structType)
+	a.analyzeBlockStatement(prop.Body)
+	a.withTargets = a.withTargets[:len(a.withTargets)-1]
+	if !prop.IsSet {
+		a.checkReturnPaths(prop.Token.Line, "PROPERTY GET "+prop.Name.Value, prop.Body)
+	}
+	a.results = nil
+}
+
+// propertyType returns the type of a property access. Reading a property
+// needs a GET accessor and assigning to it needs a SET accessor.
+func (a *Analyzer) propertyType(expr *parser.MemberExpression, typeName string, prop *StructProperty) *Type {
+	if expr == a.assignTarget {
+		if !prop.HasSet {
+			a.errorWithHint(expr.Token.Line, dberrors.PropertyNotAccessible, "property %s of type %s is read-only",
+				fmt.Sprintf("add PROPERTY SET %s to TYPE %s", prop.Name, typeName), prop.Name, typeName)
+		}
+	} else if !prop.HasGet {
+		a.errorWithHint(expr.Token.Line, dberrors.PropertyNotAccessible, "property %s of type %s is write-only",
+			fmt.Sprintf("add PROPERTY GET %s to TYPE %s", prop.Name, typeName), prop.Name, typeName)
+	}
+	return prop.Type
+}
+
+// analyzeNewExpression checks NEW TypeName(args) against the TYPE's constructor
+func (a *Analyzer) analyzeNewExpression(expr *parser.NewExpression) *Type {
+	structType := a.types.Lookup(expr.TypeName.Value)
+	if structType == nil || structType.Kind != TypeStruct {
+		a.error(expr.Token.Line, dberrors.UnknownType, "NEW requires a TYPE name, got %s", expr.TypeName.Value)
+		for _, arg := range expr.Arguments {
+			a.analyzeExpression(arg)
+		}
+		return AnyType
+	}
+
+	sym := a.symbols.GlobalScope.ResolveLocal(structType.Name + ".New")
+	if sym == nil {
+		a.errorWithHint(expr.Token.Line, dberrors.UnknownMember, "type %s has no constructor",
+			fmt.Sprintf("declare SUB New(...) inside TYPE %s, or use a struct literal %s{...}", structType.Name, structType.Name),
+			structType.Name)
+		for _, arg := range expr.Arguments {
+			a.analyzeExpression(arg)
+		}
+		return structType
+	}
+
+	a.checkArguments(expr.Token.Line, sym.Type, expr.Arguments)
+	return structType
+}
+
+// checkInterfaceValue reports an error if value is stored in an interface it doesn't satisfy
+func (a *Analyzer) checkInterfaceValue(line int, target, value *Type) {
+	if target.Kind != TypeInterface {
+		return
+	}
+	if reason := a.interfaceMismatch(value, target); reason != "" {
+		hint := ""
+		if strings.Contains(reason, "pointer receiver") {
+			hint = "pass a POINTER TO the value (e.g. @value) instead"
+		}
+		a.errorWithHint(line, dberrors.NotImplemented, "%s does not implement %s: %s", hint,
+			value.String(), target.Name, reason)
+	}
+}
+
+// interfaceMismatch explains why value doesn't satisfy iface, or returns "" if it does.
+// Struct values only get methods with value receivers; pointers get all methods.
+func (a *Analyzer) interfaceMismatch(value, iface *Type) string {
+	if value.Kind == TypeInterface {
+		if missing := iface.MissingMethod(value); missing != "" {
+			return "missing method " + missing
+		}
+		return ""
+	}
+	if !value.isStructValue() {
+		return ""
+	}
+
+	structType := value
+	isPointer := value.Kind == TypePointer
+	if isPointer {
+		structType = value.ElementType
+	}
+
+	for _, m := range iface.Methods {
+		sym := a.methodSymbol(structType, m.Name, make(map[*Type]bool))
+		if sym == nil || sym.Kind != SymFunction {
+			return "missing method " + m.Name
+		}
+		if m.Type.GoType() != sym.Type.GoType() {
+			return "method " + m.Name + " has the wrong signature"
+		}
+		if ms, ok := sym.Node.(*parser.MethodStatement); ok && ms.ReceiverType.IsPointer && !isPointer {
+			return "method " + m.Name + " has a pointer receiver"
+		}
+	}
+	return ""
+}
+
+// methodSymbol finds METHOD name of a struct type, including the methods
+// promoted from its EMBEDded DBasic types
+func (a *Analyzer) methodSymbol(structType *Type, name string, seen map[*Type]bool) *Symbol {
+	if sym := a.symbols.GlobalScope.ResolveLocal(structType.Name + "." + name); sym != nil {
+		return sym
+	}
+	seen[structType] = true
+	for _, inner := range a.types.EmbeddedStructs(structType) {
+		if seen[inner] {
+			continue
+		}
+		if sym := a.methodSymbol(inner, name, seen); sym != nil {
+			return sym
+		}
+	}
+	return nil
+}
+
+func (a *Analyzer) analyzeDimStatement(stmt *parser.DimStatement) {
+	varType := a.dimType(stmt)
+	a.checkArrayBounds(stmt)
+
+	if stmt.Static && a.atTopLevel() {
+		a.errorWithHint(stmt.Token.Line, dberrors.NotAllowedHere, "STATIC can only be used inside a SUB, FUNCTION or METHOD",
+			"use DIM for global variables")
+		return
+	}
+
+	// Skip defining global variables (they're already defined in the earlier pass)
+	if !a.atTopLevel() || a.symbols.Resolve(stmt.Name.Value) == nil {
+		sym := &Symbol{
+			Name: stmt.Name.Value,
+			Kind: SymVariable,
+			Type: varType,
+			Node: stmt,
+		}
+
+		if err := a.define(sym, stmt.Name); err != nil {
+			a.error(stmt.Token.Line, dberrors.DuplicateDefinition, err.Error())
+		}
+	}
+
+	if stmt.Value != nil {
+		if varType.Kind == TypeMutex {
+			a.errorWithHint(stmt.Token.Line, dberrors.InvalidMutex, "MUTEX %s cannot be initialized with a value",
+				"a MUTEX starts unlocked; declare it as DIM "+stmt.Name.Value+" AS MUTEX", stmt.Name.Value)
+			return
+		}
+		if lit, ok := stmt.Value.(*parser.JSONLiteral); ok && varType.Kind == TypeMap {
+			a.analyzeMapLiteral(lit, varType)
+			return
+		}
+		valueType := a.analyzeExpression(stmt.Value)
+		if !varType.IsCompatibleWith(valueType) {
+			a.error(stmt.Token.Line, dberrors.TypeMismatch, "type mismatch: cannot assign %s to %s",
+				valueType.String(), varType.String())
+			return
+		}
+		a.checkInterfaceValue(stmt.Token.Line, varType, valueType)
+	}
+}
+
+// dimType returns the type declared by a DIM statement. DIM a(n) AS T
+// declares a slice of T; with a(lower TO upper) the slice also records its
+// lower bound so indexes can be translated.
+func (a *Analyzer) dimType(stmt *parser.DimStatement) *Type {
+	varType := a.resolveTypeSpec(stmt.Type)
+	if stmt.ArraySize == nil {
+		return varType
+	}
+	arrType := NewSliceType(varType)
+	if stmt.LowerBound != nil {
+		if lower, ok := a.intConstant(stmt.LowerBound); ok {
+			arrType.LowerBound = lower
+		}
+	}
+	return arrType
+}
+
+// checkArrayBounds checks the size and lower bound of an array DIM
+func (a *Analyzer) checkArrayBounds(stmt *parser.DimStatement) {
+	if stmt.ArraySize == nil {
+		return
+	}
+	if sizeType := a.analyzeExpression(stmt.ArraySize); !sizeType.IsInteger() && sizeType.Kind != TypeAny {
+		a.error(stmt.Token.Line, dberrors.TypeMismatch, "array size must be integer")
+	}
+	lower := 0
+	if stmt.LowerBound != nil {
+		var ok bool
+		if lower, ok = a.intConstant(stmt.LowerBound); !ok {
+			a.errorWithHint(stmt.Token.Line, dberrors.InvalidConstant, "array lower bound must be an integer constant",
+				fmt.Sprintf("use a number or a CONST, e.g. DIM %s(1 TO 10)", stmt.Name.Value))
+			return
+		}
+	}
+	if upper, ok := a.intConstant(stmt.ArraySize); ok {
+		size := upper
+		if stmt.LowerBound != nil {
+			size = upper - lower + 1
+		}
+		if size < 0 {
+			a.errorWithHint(stmt.Token.Line, dberrors.InvalidConstant, "negative array size in DIM %s",
+				"DIM a(n) holds n elements; DIM a(lower TO upper) holds upper - lower + 1", stmt.Name.Value)
+		}
+	}
+}
+
+// ArrayLowerBound returns the value of the lower bound in DIM a(lower TO upper).
+// Only integer literals, optionally negated, are accepted.
+func ArrayLowerBound(expr parser.Expression) (int, bool) {
+	switch e := expr.(type) {
+	case *parser.IntegerLiteral:
+		return int(e.Value), true
+	case *parser.PrefixExpression:
+		if e.Operator == "-" {
+			if v, ok := ArrayLowerBound(e.Right); ok {
+				return -v, true
+			}
+		}
+	}
+	return 0, false
+}
+
+// analyzeMapLiteral checks a {"key": value} literal used to initialize a MAP
+func (a *Analyzer) analyzeMapLiteral(lit *parser.JSONLiteral, mapType *Type) {
+	if mapType.KeyType.Kind != TypeString && mapType.KeyType.Kind != TypeAny {
+		a.error(lit.Token.Line, dberrors.TypeMismatch, "type mismatch: map literal keys are STRING, map key type is %s",
+			mapType.KeyType.String())
+	}
+	for key, value := range lit.Pairs {
+		valueType := a.analyzeExpression(value)
+		if !mapType.ElementType.IsCompatibleWith(valueType) {
+			a.error(lit.Token.Line, dberrors.TypeMismatch, "type mismatch: cannot use %s as %s for key %q",
+				valueType.String(), mapType.ElementType.String(), key)
+		}
+	}
+}
+
+func (a *Analyzer) analyzeLetStatement(stmt *parser.LetStatement) {
+	// Infer type from the value expression
+	valueType := a.analyzeExpression(stmt.Value)
+
+	sym := &Symbol{
+		Name: stmt.Name.Value,
+		Kind: SymVariable,
+		Type: valueType,
+		Node: stmt,
+	}
+
+	if err := a.define(sym, stmt.Name); err != nil {
+		a.error(stmt.Token.Line, dberrors.DuplicateDefinition, err.Error())
+	}
+}
+
+func (a *Analyzer) analyzeConstStatement(stmt *parser.ConstStatement) {
+	constType := a.resolveTypeSpec(stmt.Type)
+
+	sym := &Symbol{
+		Name: stmt.Name.Value,
+		Kind: SymConstant,
+		Type: constType,
+		Node: stmt,
+	}
+
+	if err := a.define(sym, stmt.Name); err != nil {
+		a.error(stmt.Token.Line, dberrors.DuplicateDefinition, err.Error())
+	}
+
+	if stmt.Value != nil {
+		valueType := a.analyzeExpression(stmt.Value)
+		if !constType.IsCompatibleWith(valueType) {
+			a.error(stmt.Token.Line, dberrors.TypeMismatch, "type mismatch in constant declaration")
+			return
+		}
+		a.checkConstValue(stmt.Name, stmt.Value)
+		if value, ok := a.constValue(stmt.Value); ok {
+			sym.Value = convertConst(value, constType)
+			if sym.Value == nil {
+				a.error(stmt.Token.Line, dberrors.InvalidConstant, "CONST %s AS %s cannot hold %s", stmt.Name.Value, constType.String(), value.String())
+			}
+		}
+	}
+}
+
+// analyzeConstGroupStatement declares the INTEGER constants of a CONST block
+func (a *Analyzer) analyzeConstGroupStatement(stmt *parser.ConstGroupStatement) {
+	next := constant.MakeInt64(0)
+	for i, name := range stmt.Names {
+		if value := stmt.Values[i]; value != nil {
+			valueType := a.analyzeExpression(value)
+			if !valueType.IsInteger() && valueType.Kind != TypeAny {
+				a.error(name.Token.Line, dberrors.InvalidConstant, "CONST %s must be an integer, got %s", name.Value, valueType.String())
+			}
+			a.checkConstValue(name, value)
+			next = nil
+			if v, ok := a.constValue(value); ok && v.Kind() == constant.Int {
+				next = v
+			}
+		}
+
+		sym := &Symbol{
+			Name:  name.Value,
+			Kind:  SymConstant,
+			Type:  IntegerType,
+			Node:  stmt,
+			Value: next,
+		}
+		if next != nil {
+			next = nextConst(next)
+		}
+		if err := a.define(sym, name); err != nil {
+			a.error(name.Token.Line, dberrors.DuplicateDefinition, err.Error())
+		}
+	}
+}
+
+func (a *Analyzer) analyzeAssignmentStatement(stmt *parser.AssignmentStatement) {
+	if a.receiver != nil && a.changesReceiverCopy(stmt.Left) {
+		if a.receiver.changed == 0 {
+			a.receiver.changed = stmt.Token.Line
+		}
+		// Reading the copy to change it again does not use the change
+		a.receiver.changing = true
+		defer func() { a.receiver.changing = false }()
+	}
+
+	// Assigning to a property calls its SET accessor
+	a.assignTarget, _ = stmt.Left.(*parser.MemberExpression)
+	leftType := a.analyzeExpression(stmt.Left)
+	a.assignTarget = nil
+	rightType := a.analyzeExpression(stmt.Value)
+
+	if leftType.Kind == TypeMutex {
+		a.errorWithHint(stmt.Token.Line, dberrors.InvalidMutex, "cannot assign to MUTEX %s",
+			"a MUTEX cannot be copied; share it through a POINTER TO MUTEX", stmt.Left.String())
+		return
+	}
+
+	if !leftType.IsCompatibleWith(rightType) {
+		a.error(stmt.Token.Line, dberrors.TypeMismatch, "type mismatch in assignment: cannot assign %s to %s",
+			rightType.String(), leftType.String())
+		return
+	}
+	a.checkInterfaceValue(stmt.Token.Line, leftType, rightType)
+}
+
+func (a *Analyzer) analyzeMultiAssignmentStatement(stmt *parser.MultiAssignmentStatement) {
+	// Check for type assertion with ok pattern: value, ok = expr.(Type)
+	if typeAssert, ok := stmt.Value.(*parser.TypeAssertionExpression); ok {
+		if len(stmt.Targets) != 2 {
+			a.error(stmt.Token.Line, dberrors.WrongCount, "type assertion with ok pattern requires exactly 2 targets (value, ok)")
+			return
+		}
+		// Analyze the type assertion value
+		a.analyzeExpression(typeAssert.Value)
+		// Analyze both targets
+		for _, target := range stmt.Targets {
+			a.analyzeExpression(target)
+		}
+		return
+	}
+
+	// Check for map lookup with ok pattern: value, ok = m[key]
+	if index, ok := stmt.Value.(*parser.IndexExpression); ok {
+		leftType := a.analyzeExpression(index.Left)
+		if leftType.Kind != TypeMap && leftType.Kind != TypeJSON && leftType.Kind != TypeAny {
+			a.error(stmt.Token.Line, dberrors.InvalidMultipleAssignment, "multiple assignment from index requires a map")
+			return
+		}
+		valueType := AnyType
+		if leftType.Kind == TypeMap {
+			valueType = leftType.ElementType
+			if keyType := a.analyzeExpression(index.Index); !leftType.KeyType.IsCompatibleWith(keyType) {
+				a.error(stmt.Token.Line, dberrors.TypeMismatch, "type mismatch: cannot use %s as map key of type %s",
+					keyType.String(), leftType.KeyType.String())
+			}
+		} else {
+			a.analyzeExpression(index.Index)
+		}
+		if len(stmt.Targets) != 2 {
+			a.error(stmt.Token.Line, dberrors.WrongCount, "map lookup with ok pattern requires exactly 2 targets (value, ok)")
+			return
+		}
+		if targetType := a.analyzeExpression(stmt.Targets[0]); !targetType.IsCompatibleWith(valueType) {
+			a.error(stmt.Token.Line, dberrors.TypeMismatch, "type mismatch in multiple assignment at position 1")
+		}
+		if okType := a.analyzeExpression(stmt.Targets[1]); okType.Kind != TypeBoolean && okType.Kind != TypeAny {
+			a.error(stmt.Token.Line, dberrors.TypeMismatch, "type mismatch in multiple assignment at position 2: expected BOOLEAN")
+		}
+		return
+	}
+
+	// Check for struct destructuring: a, b = value.{A, B}
+	if fields, ok := stmt.Value.(*parser.FieldListExpression); ok {
+		a.analyzeFieldListAssignment(stmt, fields)
+		return
+	}
+
+	// Get the types of the right-hand side (should be a function call)
+	call, ok := stmt.Value.(*parser.CallExpression)
+	if !ok {
+		a.error(stmt.Token.Line, dberrors.InvalidMultipleAssignment, "multiple assignment requires function call, type assertion, map lookup or field list on right side")
+		return
+	}
+
+	// Check if this is an external Go method call - skip validation, unless
+	// the package is declared
+	if member, isMember := call.Function.(*parser.MemberExpression); isMember && a.packageOf(member.Object) == nil {
+		// External Go function call - analyze arguments and targets but don't validate return count
+		for _, arg := range call.Arguments {
+			a.analyzeExpression(arg)
+		}
+		for _, target := range stmt.Targets {
+			a.analyzeExpression(target)
+		}
+		return
+	}
+
+	funcSym := a.resolveFunctionCall(call)
+	if funcSym == nil || funcSym.Type == nil {
+		return
+	}
+
+	if len(funcSym.Type.ReturnTypes) != len(stmt.Targets) {
+		a.error(stmt.Token.Line, dberrors.WrongCount, "wrong number of values in multiple assignment: expected %d, got %d",
+			len(funcSym.Type.ReturnTypes), len(stmt.Targets))
+		return
+	}
+
+	for i, target := range stmt.Targets {
+		targetType := a.analyzeExpression(target)
+		if !targetType.IsCompatibleWith(funcSym.Type.ReturnTypes[i]) {
+			a.error(stmt.Token.Line, dberrors.TypeMismatch, "type mismatch in multiple assignment at position %d", i+1)
+		}
+	}
+}
+
+// analyzeFieldListAssignment checks a, b = value.{A, B}: each target takes
+// the field in the same position
+func (a *Analyzer) analyzeFieldListAssignment(stmt *parser.MultiAssignmentStatement, fields *parser.FieldListExpression) {
+	// The value is read once per field, so it must not be a call
+	if _, isCall := fields.Object.(*parser.CallExpression); isCall {
+		a.errorWithHint(stmt.Token.Line, dberrors.InvalidMultipleAssignment, "field list requires a variable, not a function call",
+			"assign the result to a variable first")
+		return
+	}
+	if len(fields.Fields) != len(stmt.Targets) {
+		a.error(stmt.Token.Line, dberrors.WrongCount, "wrong number of values in multiple assignment: expected %d, got %d",
+			len(fields.Fields), len(stmt.Targets))
+		return
+	}
+
+	for i, member := range fields.Members() {
+		fieldType := a.analyzeMemberExpression(member)
+		if targetType := a.analyzeExpression(stmt.Targets[i]); !targetType.IsCompatibleWith(fieldType) {
+			a.error(stmt.Token.Line, dberrors.TypeMismatch, "type mismatch in multiple assignment at position %d: cannot assign %s to %s",
+				i+1, fieldType.String(), targetType.String())
+		}
 	}
 }
 
 func (a *Analyzer) analyzePrintStatement(stmt *parser.PrintStatement) {
+	if stmt.FileNumber != nil {
+		a.analyzeFileNumber(stmt.Token.Line, stmt.FileNumber)
+	}
 	for _, val := range stmt.Values {
 		a.analyzeExpression(val)
 	}
+	a.vetPrinted(stmt)
 }
 
 func (a *Analyzer) analyzeInputStatement(stmt *parser.InputStatement) {
 	if stmt.Prompt != nil {
 		a.analyzeExpression(stmt.Prompt)
 	}
+	if dim := stmt.Declaration(); dim != nil {
+		a.analyzeDimStatement(dim)
+	}
 	// Check that variable exists
 	sym := a.symbols.Resolve(stmt.Variable.Value)
 	if sym == nil {
-		a.error(stmt.Token.Line, "undefined variable: %s", stmt.Variable.Value)
+		a.error(stmt.Token.Line, dberrors.UndefinedName, "undefined variable: %s", stmt.Variable.Value)
+		return
+	}
+	if stmt.Declaration() == nil {
+		a.refer(stmt.Variable, sym)
 	}
+	if sym.Type != nil && !IsInputType(sym.Type) {
+		a.errorWithHint(stmt.Token.Line, dberrors.TypeMismatch, "INPUT cannot read into a variable of type %s",
+			"INPUT reads STRING, INTEGER, LONG, SINGLE, DOUBLE and BOOLEAN variables", sym.Type.String())
+		return
+	}
+}
+
+func (a *Analyzer) analyzeOpenStatement(stmt *parser.OpenStatement) {
+	if t := a.analyzeExpression(stmt.Path); t.Kind != TypeString && t.Kind != TypeAny {
+		a.error(stmt.Token.Line, dberrors.TypeMismatch, "OPEN requires a STRING path, got %s", t.String())
+	}
+	a.analyzeFileNumber(stmt.Token.Line, stmt.FileNumber)
+	if stmt.RecordLen != nil {
+		if stmt.Mode != "RANDOM" {
+			a.error(stmt.Token.Line, dberrors.NotAllowedHere, "LEN applies only to files opened FOR RANDOM")
+		}
+		if t := a.analyzeExpression(stmt.RecordLen); !t.IsInteger() && t.Kind != TypeAny {
+			a.error(stmt.Token.Line, dberrors.TypeMismatch, "record length must be an integer, got %s", t.String())
+		}
+	}
+}
+
+func (a *Analyzer) analyzeGetPutStatement(stmt *parser.GetPutStatement) {
+	line := stmt.Token.Line
+	a.analyzeFileNumber(line, stmt.FileNumber)
+	if stmt.Position != nil {
+		if t := a.analyzeExpression(stmt.Position); !t.IsInteger() && t.Kind != TypeAny {
+			a.error(line, dberrors.TypeMismatch, "%s position must be an integer, got %s", stmt.Name(), t.String())
+		}
+	}
+	if stmt.Name() == "GET" {
+		switch stmt.Variable.(type) {
+		case *parser.Identifier, *parser.MemberExpression, *parser.IndexExpression:
+		default:
+			a.error(line, dberrors.InvalidOperand, "GET requires a variable, got %s", stmt.Variable.String())
+			return
+		}
+	}
+	t := a.analyzeExpression(stmt.Variable)
+	if bad := a.unsizedRecordPart(t, true); bad != nil {
+		a.errorWithHint(line, dberrors.TypeMismatch, "%s cannot store %s: it has no fixed size",
+			"records hold numbers, BOOLEAN, STRING * n and TYPEs of these",
+			stmt.Name(), bad.String())
+	}
+}
+
+// unsizedRecordPart returns the part of a GET or PUT record of type t that
+// has no fixed size, or nil. A STRING variable (top) is read at its current
+// length, but a STRING inside a record needs a length: STRING * n.
+func (a *Analyzer) unsizedRecordPart(t *Type, top bool) *Type {
+	switch t.Kind {
+	case TypeInteger, TypeLong, TypeSingle, TypeDouble, TypeBoolean, TypeAny:
+		return nil
+	case TypeString:
+		if top || t.Width > 0 {
+			return nil
+		}
+	case TypeStruct:
+		for _, f := range t.Fields {
+			if bad := a.unsizedRecordPart(f.Type, false); bad != nil {
+				return bad
+			}
+		}
+		if len(a.types.EmbeddedStructs(t)) != len(t.Embedded) {
+			// An embedded Go type
+			return t
+		}
+		for _, inner := range a.types.EmbeddedStructs(t) {
+			if bad := a.unsizedRecordPart(inner, false); bad != nil {
+				return bad
+			}
+		}
+		return nil
+	}
+	return t
+}
+
+func (a *Analyzer) analyzeLineInputStatement(stmt *parser.LineInputStatement) {
+	a.analyzeFileNumber(stmt.Token.Line, stmt.FileNumber)
+	sym := a.symbols.Resolve(stmt.Variable.Value)
+	if sym == nil {
+		a.error(stmt.Token.Line, dberrors.UndefinedName, "undefined variable: %s", stmt.Variable.Value)
+		return
+	}
+	a.refer(stmt.Variable, sym)
+	if sym.Type != nil && sym.Type.Kind != TypeString && sym.Type.Kind != TypeAny {
+		a.error(stmt.Token.Line, dberrors.TypeMismatch, "LINE INPUT requires a STRING variable, got %s", sym.Type.String())
+	}
+}
+
+// analyzeFileNumber checks the n of #n, which must be an integer
+func (a *Analyzer) analyzeFileNumber(line int, n parser.Expression) {
+	if t := a.analyzeExpression(n); !t.IsInteger() && t.Kind != TypeAny {
+		a.error(line, dberrors.TypeMismatch, "file number must be an integer, got %s", t.String())
+	}
+}
+
+// IsInputType reports whether INPUT can read a value of type t; anything but
+// a STRING is parsed from the line that was typed
+func IsInputType(t *Type) bool {
+	switch t.Kind {
+	case TypeString, TypeInteger, TypeLong, TypeSingle, TypeDouble, TypeBoolean, TypeAny:
+		return true
+	}
+	return false
 }
 
 func (a *Analyzer) analyzeIfStatement(stmt *parser.IfStatement) {
 	condType := a.analyzeExpression(stmt.Condition)
 	if condType.Kind != TypeBoolean && condType.Kind != TypeAny {
-		a.error(stmt.Token.Line, "IF condition must be boolean, got %s", condType.String())
+		a.error(stmt.Token.Line, dberrors.ConditionNotBoolean, "IF condition must be boolean, got %s", condType.String())
 	}
+	a.vetCondition(stmt.Token.Line, stmt.Condition)
 
 	a.analyzeBlockStatement(stmt.Consequence)
 
 	for _, elseif := range stmt.ElseIfs {
 		condType := a.analyzeExpression(elseif.Condition)
 		if condType.Kind != TypeBoolean && condType.Kind != TypeAny {
-			a.error(elseif.Token.Line, "ELSEIF condition must be boolean")
+			a.error(elseif.Token.Line, dberrors.ConditionNotBoolean, "ELSEIF condition must be boolean")
 		}
+		a.vetCondition(elseif.Token.Line, elseif.Condition)
 		a.analyzeBlockStatement(elseif.Consequence)
 	}
 
@@ -652,33 +1943,91 @@ func (a *Analyzer) analyzeForStatement(stmt *parser.ForStatement) {
 		Type: IntegerType, // FOR loop variables are integers
 		Node: stmt,
 	}
-	a.symbols.Define(sym)
+	a.define(sym, stmt.Variable)
 
 	startType := a.analyzeExpression(stmt.Start)
 	endType := a.analyzeExpression(stmt.End)
 
 	if !startType.IsNumeric() || !endType.IsNumeric() {
-		a.error(stmt.Token.Line, "FOR loop bounds must be numeric")
+		a.error(stmt.Token.Line, dberrors.TypeMismatch, "FOR loop bounds must be numeric")
 	}
 
 	if stmt.Step != nil {
 		stepType := a.analyzeExpression(stmt.Step)
 		if !stepType.IsNumeric() {
-			a.error(stmt.Token.Line, "FOR loop step must be numeric")
+			a.error(stmt.Token.Line, dberrors.TypeMismatch, "FOR loop step must be numeric")
 		}
 	}
 
-	a.analyzeBlockStatement(stmt.Body)
+	a.analyzeLoopBody(stmt.Body)
+}
+
+func (a *Analyzer) analyzeForEachStatement(stmt *parser.ForEachStatement) {
+	collType := a.analyzeExpression(stmt.Collection)
+
+	// Infer the key and element types from the collection
+	var keyType, elemType *Type
+	switch collType.Kind {
+	case TypeArray, TypeSlice:
+		keyType = IntegerType
+		elemType = collType.ElementType
+	case TypeJSON:
+		keyType = StringType
+		elemType = AnyType
+	case TypeMap:
+		keyType = collType.KeyType
+		elemType = collType.ElementType
+	case TypeStack, TypeQueue, TypeSet:
+		keyType = IntegerType
+		elemType = collType.ElementType
+	case TypeChannel:
+		elemType = collType.ElementType
+		if stmt.Key != nil {
+			a.errorWithHint(stmt.Token.Line, dberrors.InvalidChannel, "FOR EACH over a channel takes a single variable",
+				"use FOR EACH item IN channel")
+		}
+	case TypeAny, TypeExternal, TypeUnknown:
+		keyType = AnyType
+		elemType = AnyType
+	default:
+		a.errorWithHint(stmt.Token.Line, dberrors.TypeMismatch, "cannot iterate over %s with FOR EACH",
+			"FOR EACH works with arrays, slices, maps, JSON objects, channels, stacks, queues and sets", collType)
+		keyType = AnyType
+		elemType = AnyType
+	}
+	if elemType == nil {
+		elemType = AnyType
+	}
+
+	a.symbols.EnterScope("foreach")
+	defer a.symbols.ExitScope()
+
+	if stmt.Key != nil && keyType != nil {
+		a.define(&Symbol{
+			Name: stmt.Key.Value,
+			Kind: SymVariable,
+			Type: keyType,
+			Node: stmt,
+		}, stmt.Key)
+	}
+	a.define(&Symbol{
+		Name: stmt.Value.Value,
+		Kind: SymVariable,
+		Type: elemType,
+		Node: stmt,
+	}, stmt.Value)
+
+	a.analyzeLoopBody(stmt.Body)
 }
 
 func (a *Analyzer) analyzeWhileStatement(stmt *parser.WhileStatement) {
 	condType := a.analyzeExpression(stmt.Condition)
 	if condType.Kind != TypeBoolean && condType.Kind != TypeAny {
-		a.error(stmt.Token.Line, "WHILE condition must be boolean")
+		a.error(stmt.Token.Line, dberrors.ConditionNotBoolean, "WHILE condition must be boolean")
 	}
 
 	a.symbols.EnterScope("while")
-	a.analyzeBlockStatement(stmt.Body)
+	a.analyzeLoopBody(stmt.Body)
 	a.symbols.ExitScope()
 }
 
@@ -686,12 +2035,12 @@ func (a *Analyzer) analyzeDoLoopStatement(stmt *parser.DoLoopStatement) {
 	if stmt.Condition != nil {
 		condType := a.analyzeExpression(stmt.Condition)
 		if condType.Kind != TypeBoolean && condType.Kind != TypeAny {
-			a.error(stmt.Token.Line, "DO/LOOP condition must be boolean")
+			a.error(stmt.Token.Line, dberrors.ConditionNotBoolean, "DO/LOOP condition must be boolean")
 		}
 	}
 
 	a.symbols.EnterScope("do")
-	a.analyzeBlockStatement(stmt.Body)
+	a.analyzeLoopBody(stmt.Body)
 	a.symbols.ExitScope()
 }
 
@@ -700,9 +2049,18 @@ func (a *Analyzer) analyzeSelectStatement(stmt *parser.SelectStatement) {
 
 	for _, caseClause := range stmt.Cases {
 		for _, val := range caseClause.Values {
-			caseType := a.analyzeExpression(val)
-			if !testType.IsCompatibleWith(caseType) {
-				a.error(caseClause.Token.Line, "case value type mismatch")
+			switch v := val.(type) {
+			case *parser.CaseRange:
+				a.checkCaseOrdered(caseClause.Token.Line, testType, "CASE ... TO ...")
+				a.checkCaseValue(caseClause.Token.Line, testType, v.Low)
+				a.checkCaseValue(caseClause.Token.Line, testType, v.High)
+			case *parser.CaseIs:
+				if v.Operator != "=" && v.Operator != "<>" {
+					a.checkCaseOrdered(caseClause.Token.Line, testType, "CASE IS "+v.Operator)
+				}
+				a.checkCaseValue(caseClause.Token.Line, testType, v.Value)
+			default:
+				a.checkCaseValue(caseClause.Token.Line, testType, val)
 			}
 		}
 		a.analyzeBlockStatement(caseClause.Body)
@@ -713,6 +2071,54 @@ func (a *Analyzer) analyzeSelectStatement(stmt *parser.SelectStatement) {
 	}
 }
 
+// analyzeSelectChannelStatement checks the channel operation of each arm of
+// a SELECT CHANNEL block
+func (a *Analyzer) analyzeSelectChannelStatement(stmt *parser.SelectChannelStatement) {
+	hasTimeout := false
+	for _, arm := range stmt.Cases {
+		switch {
+		case arm.Receive != nil:
+			a.analyzeReceiveStatement(arm.Receive)
+		case arm.Send != nil:
+			a.analyzeSendStatement(arm.Send)
+		default:
+			if hasTimeout {
+				a.error(arm.Token.Line, dberrors.InvalidChannel, "SELECT CHANNEL can have only one CASE TIMEOUT")
+			}
+			hasTimeout = true
+			t := a.analyzeExpression(arm.Timeout)
+			if !t.IsInteger() && t.Kind != TypeDuration && t.Kind != TypeAny {
+				a.error(arm.Token.Line, dberrors.TypeMismatch, "CASE TIMEOUT requires milliseconds or a DURATION, got %s", t.String())
+			}
+		}
+		a.analyzeBlockStatement(arm.Body)
+	}
+
+	if stmt.Default != nil {
+		if hasTimeout {
+			a.errorWithHint(stmt.Token.Line, dberrors.InvalidChannel, "SELECT CHANNEL cannot have both CASE TIMEOUT and CASE ELSE",
+				"CASE ELSE runs as soon as no channel is ready, so the timeout would never fire")
+		}
+		a.analyzeBlockStatement(stmt.Default)
+	}
+}
+
+func (a *Analyzer) checkCaseValue(line int, testType *Type, val parser.Expression) {
+	caseType := a.analyzeExpression(val)
+	if !testType.IsCompatibleWith(caseType) {
+		a.error(line, dberrors.TypeMismatch, "case value type mismatch")
+	}
+}
+
+// checkCaseOrdered reports ranges and ordering comparisons on types without an order
+func (a *Analyzer) checkCaseOrdered(line int, testType *Type, form string) {
+	if testType.IsNumeric() || testType.Kind == TypeString {
+		return
+	}
+	a.errorWithHint(line, dberrors.TypeMismatch, "%s requires a numeric or STRING SELECT expression, got %s",
+		"use CASE value or CASE IS = value to compare other types", form, testType.String())
+}
+
 func (a *Analyzer) analyzeSubStatement(stmt *parser.SubStatement) {
 	a.symbols.EnterScope(stmt.Name.Value)
 	defer a.symbols.ExitScope()
@@ -726,10 +2132,65 @@ func (a *Analyzer) analyzeSubStatement(stmt *parser.SubStatement) {
 			Type:    paramType,
 			IsByRef: param.ByRef,
 		}
-		a.symbols.Define(sym)
+		a.define(sym, param.Name)
 	}
 
-	a.analyzeBlockStatement(stmt.Body)
+	a.results = nil
+	a.analyzeRoutineBody(stmt.Body)
+}
+
+// analyzeTestStatement checks a TEST block, whose body runs like a SUB
+// without parameters
+func (a *Analyzer) analyzeTestStatement(stmt *parser.TestStatement) {
+	if !a.symbols.IsGlobalScope() {
+		a.errorWithHint(stmt.Token.Line, dberrors.NotAllowedHere, "TEST %q must be at the top level of the program",
+			"move the TEST block out of the SUB, FUNCTION or MODULE", stmt.Name)
+		return
+	}
+	if strings.TrimSpace(stmt.Name) == "" {
+		a.error(stmt.Token.Line, dberrors.InvalidDeclaration, "TEST needs a name")
+	}
+	if a.tests == nil {
+		a.tests = make(map[string]int)
+	}
+	if line, ok := a.tests[strings.ToLower(stmt.Name)]; ok {
+		a.error(stmt.Token.Line, dberrors.DuplicateDefinition, "duplicate TEST %q (first defined at line %d)", stmt.Name, line)
+	} else {
+		a.tests[strings.ToLower(stmt.Name)] = stmt.Token.Line
+	}
+
+	a.symbols.EnterScope("TEST " + stmt.Name)
+	defer a.symbols.ExitScope()
+
+	a.results = nil
+	a.analyzeRoutineBody(stmt.Body)
+}
+
+// analyzeBenchmarkStatement checks a BENCHMARK block, whose body, like a
+// TEST's, runs like a SUB without parameters
+func (a *Analyzer) analyzeBenchmarkStatement(stmt *parser.BenchmarkStatement) {
+	if !a.symbols.IsGlobalScope() {
+		a.errorWithHint(stmt.Token.Line, dberrors.NotAllowedHere, "BENCHMARK %q must be at the top level of the program",
+			"move the BENCHMARK block out of the SUB, FUNCTION or MODULE", stmt.Name)
+		return
+	}
+	if strings.TrimSpace(stmt.Name) == "" {
+		a.error(stmt.Token.Line, dberrors.InvalidDeclaration, "BENCHMARK needs a name")
+	}
+	if a.benchmarks == nil {
+		a.benchmarks = make(map[string]int)
+	}
+	if line, ok := a.benchmarks[strings.ToLower(stmt.Name)]; ok {
+		a.error(stmt.Token.Line, dberrors.DuplicateDefinition, "duplicate BENCHMARK %q (first defined at line %d)", stmt.Name, line)
+	} else {
+		a.benchmarks[strings.ToLower(stmt.Name)] = stmt.Token.Line
+	}
+
+	a.symbols.EnterScope("BENCHMARK " + stmt.Name)
+	defer a.symbols.ExitScope()
+
+	a.results = nil
+	a.analyzeRoutineBody(stmt.Body)
 }
 
 func (a *Analyzer) analyzeFunctionStatement(stmt *parser.FunctionStatement) {
@@ -745,69 +2206,469 @@ func (a *Analyzer) analyzeFunctionStatement(stmt *parser.FunctionStatement) {
 			Type:    paramType,
 			IsByRef: param.ByRef,
 		}
-		a.symbols.Define(sym)
+		a.define(sym, param.Name)
+	}
+
+	a.results = a.resolveReturnTypes(stmt.ReturnTypes)
+	a.analyzeRoutineBody(stmt.Body)
+	if len(a.results) > 0 {
+		a.checkReturnPaths(stmt.Token.Line, "FUNCTION "+stmt.Name.Value, stmt.Body)
+	}
+	a.results = nil
+}
+
+func (a *Analyzer) analyzeMethodStatement(stmt *parser.MethodStatement) {
+	// Get the receiver type name for scope naming
+	var receiverTypeName string
+	if stmt.ReceiverType.IsPointer {
+		receiverTypeName = stmt.ReceiverType.ElementType.Name
+	} else {
+		receiverTypeName = stmt.ReceiverType.Name
+	}
+
+	scopeName := receiverTypeName + "." + stmt.Name.Value
+	a.symbols.EnterScope(scopeName)
+	defer a.symbols.ExitScope()
+
+	// Define the receiver as a parameter
+	if stmt.ReceiverByRef && stmt.ReceiverType.ElementType.IsPointer {
+		a.error(stmt.Token.Line, dberrors.InvalidDeclaration, "BYREF receiver %s is already a pointer", stmt.ReceiverName.Value)
+	}
+	receiverType := a.resolveTypeSpec(stmt.ReceiverType)
+	receiverSym := &Symbol{
+		Name: stmt.ReceiverName.Value,
+		Kind: SymParameter,
+		Type: receiverType,
+	}
+	a.define(receiverSym, stmt.ReceiverName)
+
+	// Define parameters
+	for _, param := range stmt.Params {
+		paramType := a.resolveTypeSpec(param.Type)
+		sym := &Symbol{
+			Name:    param.Name.Value,
+			Kind:    SymParameter,
+			Type:    paramType,
+			IsByRef: param.ByRef,
+		}
+		a.define(sym, param.Name)
+	}
+
+	a.results = a.resolveReturnTypes(stmt.ReturnTypes)
+	if receiverType.Kind == TypeStruct {
+		a.receiver = &receiverCopy{sym: receiverSym}
+	}
+	a.analyzeRoutineBody(stmt.Body)
+	if len(a.results) > 0 {
+		a.checkReturnPaths(stmt.Token.Line, "FUNCTION "+stmt.Name.Value, stmt.Body)
+	}
+	if a.receiver != nil && a.receiver.changed > 0 && !a.receiver.returned && !a.receiver.read {
+		a.errorWithHint(a.receiver.changed, dberrors.ReceiverChangeLost, "%s changes a copy of its receiver %s, so the change is lost",
+			fmt.Sprintf("declare the receiver BYREF %s AS %s, or RETURN the changed copy",
+				stmt.ReceiverName.Value, receiverType.Name),
+			stmt.Name.Value, stmt.ReceiverName.Value)
+	}
+	a.results, a.receiver = nil, nil
+}
+
+// changesReceiverCopy reports whether assigning to target changes a field
+// of the value receiver: target is a chain of struct fields starting at the
+// receiver. Changes through pointers, slices and maps reach the caller.
+func (a *Analyzer) changesReceiverCopy(target parser.Expression) bool {
+	var names []string
+	for {
+		member, ok := target.(*parser.MemberExpression)
+		if !ok {
+			break
+		}
+		names = append([]string{member.Member.Value}, names...)
+		target = member.Object
+	}
+	ident, ok := target.(*parser.Identifier)
+	if !ok || len(names) == 0 || a.symbols.Resolve(ident.Value) != a.receiver.sym {
+		return false
+	}
+
+	t := a.receiver.sym.Type
+	for _, name := range names {
+		if t.Kind != TypeStruct {
+			return false
+		}
+		member, _ := a.types.Member(t, name)
+		if member == nil || member.Property != nil {
+			return false
+		}
+		t = member.Type
+	}
+	return true
+}
+
+// resolveReturnTypes resolves the return types of a FUNCTION or METHOD
+func (a *Analyzer) resolveReturnTypes(specs []*parser.TypeSpec) []*Type {
+	var types []*Type
+	for _, spec := range specs {
+		types = append(types, a.resolveTypeSpec(spec))
+	}
+	return types
+}
+
+// analyzeRoutineBody analyzes the body of a SUB, FUNCTION or METHOD and
+// checks its ON ERROR GOTO handler, if it has one
+func (a *Analyzer) analyzeRoutineBody(body *parser.BlockStatement) {
+	if body == nil {
+		return
+	}
+	onError, handler := errorHandler(body)
+	trap := &errorTrap{labels: make(map[string]bool)}
+	if onError != nil {
+		trap.handler = onError.Label
+		if handler < 0 {
+			a.errorWithHint(onError.Token.Line, dberrors.InvalidJump, "ON ERROR GOTO %s: no label %s at the top level of this routine",
+				"the handler label cannot be inside IF, loops or other blocks",
+				onError.Label, onError.Label)
+		}
+	}
+
+	gosub := &gosubRoutine{labels: make(map[string]int)}
+	outer, outerGosub := a.trap, a.gosub
+	a.trap, a.gosub = trap, gosub
+	for i, stmt := range body.Statements {
+		trap.top = stmt
+		trap.inHandler = handler >= 0 && i >= handler
+		gosub.index = i
+		a.analyzeStatement(stmt)
+	}
+	a.trap, a.gosub = outer, outerGosub
+	a.checkSubroutines(body, gosub, handler)
+
+	if handler < 0 {
+		return
+	}
+	for _, g := range trap.gotos {
+		inHandler, ok := trap.labels[strings.ToUpper(g.stmt.Label)]
+		switch {
+		case strings.EqualFold(g.stmt.Label, trap.handler):
+			a.errorWithHint(g.stmt.Token.Line, dberrors.InvalidJump, "GOTO %s jumps to the ON ERROR handler",
+				"raise an error with ERROR n to run the handler", g.stmt.Label)
+		case ok && inHandler != g.inHandler:
+			a.errorWithHint(g.stmt.Token.Line, dberrors.InvalidJump, "GOTO %s crosses the ON ERROR handler label %s",
+				"code before the handler label and the handler itself cannot jump into each other",
+				g.stmt.Label, trap.handler)
+		}
+	}
+}
+
+// analyzeGosubStatement records a GOSUB; its label is checked at the end of
+// the routine
+func (a *Analyzer) analyzeGosubStatement(stmt *parser.GosubStatement) {
+	if a.gosub == nil {
+		a.error(stmt.Token.Line, dberrors.NotAllowedHere, "GOSUB is only allowed inside a SUB, FUNCTION or METHOD")
+		return
+	}
+	a.gosub.gosubs = append(a.gosub.gosubs, stmt)
+}
+
+// addGosubJump records a statement that cannot cross the boundary of a
+// GOSUB subroutine
+func (a *Analyzer) addGosubJump(line int, what, label string) {
+	if a.gosub != nil {
+		a.gosub.jumps = append(a.gosub.jumps, gosubJump{line: line, what: what, label: label, index: a.gosub.index})
+	}
+}
+
+// checkSubroutines finds the subroutine of each GOSUB target in a routine
+// body and checks that no jump crosses a subroutine boundary
+func (a *Analyzer) checkSubroutines(body *parser.BlockStatement, gosub *gosubRoutine, handler int) {
+	var subs []*Subroutine
+	seen := make(map[string]bool)
+	for _, gs := range gosub.gosubs {
+		key := strings.ToUpper(gs.Label)
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+
+		sub := findSubroutine(body, gs.Label)
+		switch {
+		case sub == nil:
+			a.errorWithHint(gs.Token.Line, dberrors.InvalidJump, "GOSUB %s: no label %s at the top level of this routine",
+				"subroutine labels cannot be inside IF, loops or other blocks", gs.Label, gs.Label)
+		case sub.End < 0:
+			a.errorWithHint(gs.Token.Line, dberrors.InvalidJump, "GOSUB %s: the subroutine has no RETURN at the top level",
+				"end the subroutine with RETURN, outside any IF or loop", gs.Label)
+		default:
+			subs = append(subs, sub)
+		}
+	}
+	if len(subs) == 0 {
+		return
+	}
+	sort.Slice(subs, func(i, j int) bool { return subs[i].Start < subs[j].Start })
+	a.symbols.subroutines[body] = subs
+
+	for _, j := range gosub.jumps {
+		from := SubroutineAt(subs, j.index)
+		if j.label == "" {
+			if from != nil {
+				a.errorWithHint(j.line, dberrors.InvalidJump, "%s cannot leave the GOSUB subroutine %s",
+					"RETURN from the subroutine first", j.what, from.Label)
+			}
+			continue
+		}
+		index, ok := gosub.labels[strings.ToUpper(j.label)]
+		if !ok {
+			continue
+		}
+		switch to := SubroutineAt(subs, index); {
+		case to == from:
+			// Stays in the same subroutine, or outside all of them
+		case to != nil:
+			a.errorWithHint(j.line, dberrors.InvalidJump, "%s jumps into the GOSUB subroutine %s",
+				"use GOSUB to run a subroutine", j.what, to.Label)
+		default:
+			a.errorWithHint(j.line, dberrors.InvalidJump, "%s leaves the GOSUB subroutine %s",
+				"RETURN from the subroutine first", j.what, from.Label)
+		}
+	}
+
+	if handler >= 0 {
+		if sub := SubroutineAt(subs, handler); sub != nil {
+			a.error(body.Statements[handler].(*parser.LabelStatement).Token.Line,
+				dberrors.InvalidJump, "the ON ERROR handler cannot be inside the GOSUB subroutine %s", sub.Label)
+		}
+	}
+}
+
+// findSubroutine returns the subroutine starting at a top-level label of a
+// routine body, with an End of -1 if no top-level RETURN follows it, or nil
+// if there is no such label
+func findSubroutine(body *parser.BlockStatement, label string) *Subroutine {
+	for i, stmt := range body.Statements {
+		ls, ok := stmt.(*parser.LabelStatement)
+		if !ok || !strings.EqualFold(ls.Name, label) {
+			continue
+		}
+		sub := &Subroutine{Label: ls.Name, Start: i, End: -1}
+		for j := i + 1; j < len(body.Statements); j++ {
+			if rs, ok := body.Statements[j].(*parser.ReturnStatement); ok && len(rs.Values) == 0 {
+				sub.End = j
+				break
+			}
+		}
+		return sub
+	}
+	return nil
+}
+
+// SubroutineAt returns the innermost subroutine holding the top-level
+// statement at index, or nil. Subroutines sharing a RETURN nest.
+func SubroutineAt(subs []*Subroutine, index int) *Subroutine {
+	var found *Subroutine
+	for _, sub := range subs {
+		if sub.Start <= index && index <= sub.End {
+			found = sub
+		}
+	}
+	return found
+}
+
+// errorHandler returns the first top-level ON ERROR GOTO label statement of
+// a routine body and the position of the label it names, or -1 if that
+// label is not a top-level statement of the body
+func errorHandler(body *parser.BlockStatement) (*parser.OnErrorStatement, int) {
+	var onError *parser.OnErrorStatement
+	for _, stmt := range body.Statements {
+		if s, ok := stmt.(*parser.OnErrorStatement); ok && s.Label != "" {
+			onError = s
+			break
+		}
+	}
+	if onError == nil {
+		return nil, -1
+	}
+	for i, stmt := range body.Statements {
+		if label, ok := stmt.(*parser.LabelStatement); ok && strings.EqualFold(label.Name, onError.Label) {
+			return onError, i
+		}
+	}
+	return onError, -1
+}
+
+// IsErrorVariable reports whether name is ERR or ERL, the pseudo-variables
+// set when ON ERROR GOTO traps an error
+func IsErrorVariable(name string) bool {
+	return strings.EqualFold(name, "ERR") || strings.EqualFold(name, "ERL")
+}
+
+// ErrorHandlerIndex returns the position of the ON ERROR GOTO handler label
+// in a routine body, or -1 if the routine does not trap errors
+func ErrorHandlerIndex(body *parser.BlockStatement) int {
+	if body == nil {
+		return -1
+	}
+	_, handler := errorHandler(body)
+	return handler
+}
+
+// analyzeOptionStatement checks that OPTION names a known option and is
+// declared at the top level of the program
+func (a *Analyzer) analyzeOptionStatement(stmt *parser.OptionStatement) {
+	if stmt.Name != "UNICODE" {
+		a.errorWithHint(stmt.Token.Line, dberrors.UnknownOption, "unknown OPTION %s",
+			"the only option is OPTION UNICODE", stmt.Name)
+		return
+	}
+	if !a.symbols.IsGlobalScope() {
+		a.error(stmt.Token.Line, dberrors.NotAllowedHere, "OPTION %s must be at the top level of the program", stmt.Name)
+	}
+}
+
+// analyzeCheckStatement checks that CHECK is given an ERROR inside a
+// FUNCTION or METHOD whose last return value is an ERROR
+func (a *Analyzer) analyzeCheckStatement(stmt *parser.CheckStatement) {
+	if errType := a.analyzeExpression(stmt.Err); errType.Kind != TypeError && errType.Kind != TypeAny {
+		a.error(stmt.Token.Line, dberrors.TypeMismatch, "CHECK requires an ERROR, got %s", errType.String())
+	}
+	if n := len(a.results); n == 0 || a.results[n-1].Kind != TypeError {
+		a.errorWithHint(stmt.Token.Line, dberrors.NotAllowedHere, "CHECK requires the enclosing FUNCTION to return ERROR as its last value",
+			"declare the function AS ERROR or AS (..., ERROR)")
+	}
+	a.checkLeavesLock(stmt.Token.Line, "CHECK")
+	a.addGosubJump(stmt.Token.Line, "CHECK", "")
+}
+
+// analyzeOnErrorStatement checks that ON ERROR GOTO names the one handler of
+// its routine from the routine's top level
+func (a *Analyzer) analyzeOnErrorStatement(stmt *parser.OnErrorStatement) {
+	switch {
+	case a.trap == nil:
+		a.error(stmt.Token.Line, dberrors.NotAllowedHere, "ON ERROR is only allowed inside a SUB, FUNCTION or METHOD")
+	case stmt.Label == "":
+		// ON ERROR GOTO 0 can appear anywhere
+	case parser.Statement(stmt) != a.trap.top:
+		a.errorWithHint(stmt.Token.Line, dberrors.NotAllowedHere, "ON ERROR GOTO %s must be at the top level of its routine",
+			"only ON ERROR GOTO 0 can appear inside IF, loops or other blocks", stmt.Label)
+	case !strings.EqualFold(stmt.Label, a.trap.handler):
+		a.error(stmt.Token.Line, dberrors.InvalidJump, "a routine can have only one ON ERROR GOTO handler, and this one uses %s",
+			a.trap.handler)
+	}
+}
+
+// analyzeErrorStatement checks that ERROR is given an error number
+func (a *Analyzer) analyzeErrorStatement(stmt *parser.ErrorStatement) {
+	t := a.analyzeExpression(stmt.Code)
+	if !t.IsInteger() && t.Kind != TypeAny {
+		a.error(stmt.Token.Line, dberrors.TypeMismatch, "ERROR requires an error number, got %s", t.String())
+	}
+}
+
+// analyzeAssertStatement checks that ASSERT tests a BOOLEAN and that its
+// message is a STRING
+func (a *Analyzer) analyzeAssertStatement(stmt *parser.AssertStatement) {
+	condType := a.analyzeExpression(stmt.Condition)
+	if condType.Kind != TypeBoolean && condType.Kind != TypeAny {
+		a.error(stmt.Token.Line, dberrors.ConditionNotBoolean, "ASSERT condition must be boolean, got %s", condType.String())
+	}
+	if stmt.Message != nil {
+		msgType := a.analyzeExpression(stmt.Message)
+		if msgType.Kind != TypeString && msgType.Kind != TypeAny {
+			a.error(stmt.Token.Line, dberrors.TypeMismatch, "ASSERT message must be a STRING, got %s", msgType.String())
+		}
+	}
+}
+
+func (a *Analyzer) analyzeReturnStatement(stmt *parser.ReturnStatement) {
+	var types []*Type
+	for _, val := range stmt.Values {
+		types = append(types, a.analyzeExpression(val))
+		if ident, ok := val.(*parser.Identifier); ok && a.receiver != nil && a.symbols.Resolve(ident.Value) == a.receiver.sym {
+			a.receiver.returned = true
+		}
+	}
+	a.checkLeavesLock(stmt.Token.Line, "RETURN")
+	if len(stmt.Values) > 0 {
+		a.addGosubJump(stmt.Token.Line, "RETURN with a value", "")
 	}
-
-	a.analyzeBlockStatement(stmt.Body)
+	a.checkReturnValues(stmt, types)
 }
 
-func (a *Analyzer) analyzeMethodStatement(stmt *parser.MethodStatement) {
-	// Get the receiver type name for scope naming
-	var receiverTypeName string
-	if stmt.ReceiverType.IsPointer {
-		receiverTypeName = stmt.ReceiverType.ElementType.Name
-	} else {
-		receiverTypeName = stmt.ReceiverType.Name
+func (a *Analyzer) analyzeExitStatement(stmt *parser.ExitStatement) {
+	// Valid exit types are checked by parser
+	switch stmt.ExitType {
+	case "SUB", "FUNCTION":
+		a.addGosubJump(stmt.Token.Line, "EXIT "+stmt.ExitType, "")
+	case "FOR", "WHILE", "DO":
+		if n := len(a.locks); n > 0 && a.loopDepth > a.locks[n-1].loopDepth {
+			// Leaves a loop inside the LOCK, which is fine
+			return
+		}
 	}
+	a.checkLeavesLock(stmt.Token.Line, "EXIT "+stmt.ExitType)
+}
 
-	scopeName := receiverTypeName + "." + stmt.Name.Value
-	a.symbols.EnterScope(scopeName)
-	defer a.symbols.ExitScope()
+// analyzeLoopBody analyzes the body of a FOR, FOR EACH, WHILE or DO loop
+func (a *Analyzer) analyzeLoopBody(body *parser.BlockStatement) {
+	a.loopDepth++
+	a.analyzeBlockStatement(body)
+	a.loopDepth--
+}
 
-	// Define the receiver as a parameter
-	receiverType := a.resolveTypeSpec(stmt.ReceiverType)
-	receiverSym := &Symbol{
-		Name: stmt.ReceiverName.Value,
-		Kind: SymParameter,
-		Type: receiverType,
+// analyzeLockStatement checks that LOCK is given a MUTEX that the enclosing
+// LOCK blocks do not already hold
+func (a *Analyzer) analyzeLockStatement(stmt *parser.LockStatement) {
+	mutexType := a.analyzeExpression(stmt.Mutex)
+	if mutexType.Kind == TypePointer && mutexType.ElementType != nil {
+		mutexType = mutexType.ElementType
+	}
+	if mutexType.Kind != TypeMutex && mutexType.Kind != TypeAny {
+		a.error(stmt.Token.Line, dberrors.InvalidMutex, "LOCK requires a MUTEX, got %s", mutexType.String())
 	}
-	a.symbols.Define(receiverSym)
 
-	// Define parameters
-	for _, param := range stmt.Params {
-		paramType := a.resolveTypeSpec(param.Type)
-		sym := &Symbol{
-			Name:    param.Name.Value,
-			Kind:    SymParameter,
-			Type:    paramType,
-			IsByRef: param.ByRef,
+	name := stmt.Mutex.String()
+	for _, held := range a.locks {
+		if strings.EqualFold(held.mutex, name) {
+			a.errorWithHint(stmt.Token.Line, dberrors.InvalidMutex, "mutex %s is already locked by an enclosing LOCK",
+				"a MUTEX cannot be locked twice by the same code; this would deadlock", name)
 		}
-		a.symbols.Define(sym)
 	}
 
+	a.locks = append(a.locks, &heldLock{mutex: name, loopDepth: a.loopDepth})
+	a.symbols.EnterScope("lock")
 	a.analyzeBlockStatement(stmt.Body)
+	a.symbols.ExitScope()
+	a.locks = a.locks[:len(a.locks)-1]
 }
 
-func (a *Analyzer) analyzeReturnStatement(stmt *parser.ReturnStatement) {
-	for _, val := range stmt.Values {
-		a.analyzeExpression(val)
+// checkLeavesLock reports statements that jump out of a LOCK block, since
+// the mutex would stay locked
+func (a *Analyzer) checkLeavesLock(line int, what string) {
+	if len(a.locks) == 0 {
+		return
 	}
-	// TODO: Check return types match function signature
+	a.errorWithHint(line, dberrors.InvalidMutex, "%s inside LOCK would leave mutex %s locked",
+		"set a variable inside the LOCK and act on it after END LOCK",
+		what, a.locks[len(a.locks)-1].mutex)
 }
 
 func (a *Analyzer) analyzeLabelStatement(stmt *parser.LabelStatement) {
+	if a.trap != nil {
+		a.trap.labels[strings.ToUpper(stmt.Name)] = a.trap.inHandler
+	}
+	if a.gosub != nil {
+		a.gosub.labels[strings.ToUpper(stmt.Name)] = a.gosub.index
+	}
 	sym := &Symbol{
 		Name: stmt.Name,
 		Kind: SymLabel,
 		Node: stmt,
 	}
 	if err := a.symbols.CurrentScope.DefineLabel(stmt.Name, sym); err != nil {
-		a.error(stmt.Token.Line, err.Error())
+		a.error(stmt.Token.Line, dberrors.DuplicateDefinition, err.Error())
 	}
 }
 
 func (a *Analyzer) analyzeSpawnStatement(stmt *parser.SpawnStatement) {
-	a.analyzeExpression(stmt.Call)
+	a.vetSpawn(stmt, a.analyzeExpression(stmt.Call))
 }
 
 func (a *Analyzer) analyzeSendStatement(stmt *parser.SendStatement) {
@@ -815,31 +2676,61 @@ func (a *Analyzer) analyzeSendStatement(stmt *parser.SendStatement) {
 	chanType := a.analyzeExpression(stmt.Channel)
 
 	if chanType.Kind != TypeChannel {
-		a.error(stmt.Token.Line, "SEND target must be a channel")
+		a.error(stmt.Token.Line, dberrors.InvalidChannel, "SEND target must be a channel")
 		return
 	}
 
 	if !chanType.ElementType.IsCompatibleWith(valType) {
-		a.error(stmt.Token.Line, "cannot send %s to channel of %s",
+		a.error(stmt.Token.Line, dberrors.InvalidChannel, "cannot send %s to channel of %s",
 			valType.String(), chanType.ElementType.String())
 	}
 }
 
 func (a *Analyzer) analyzeReceiveStatement(stmt *parser.ReceiveStatement) {
-	varType := a.analyzeExpression(stmt.Variable)
 	chanType := a.analyzeExpression(stmt.Channel)
+	if stmt.Variable == nil {
+		// RECEIVE FROM ch waits for a value and discards it
+		if chanType.Kind != TypeChannel {
+			a.error(stmt.Token.Line, dberrors.InvalidChannel, "RECEIVE source must be a channel")
+		}
+		return
+	}
+	varType := a.analyzeExpression(stmt.Variable)
 
 	if chanType.Kind != TypeChannel {
-		a.error(stmt.Token.Line, "RECEIVE source must be a channel")
+		a.error(stmt.Token.Line, dberrors.InvalidChannel, "RECEIVE source must be a channel")
 		return
 	}
 
 	if !varType.IsCompatibleWith(chanType.ElementType) {
-		a.error(stmt.Token.Line, "cannot receive %s from channel of %s",
+		a.error(stmt.Token.Line, dberrors.InvalidChannel, "cannot receive %s from channel of %s",
 			chanType.ElementType.String(), varType.String())
 	}
 }
 
+func (a *Analyzer) analyzeWithStatement(stmt *parser.WithStatement) {
+	targetType := a.analyzeExpression(stmt.Target)
+
+	objType := targetType
+	if objType.Kind == TypePointer && objType.ElementType != nil {
+		objType = objType.ElementType
+	}
+	switch objType.Kind {
+	case TypeStruct, TypeJSON, TypeInterface, TypeExternal, TypeAny:
+	default:
+		a.errorWithHint(stmt.Token.Line, dberrors.TypeMismatch, "WITH requires a struct or object, got %s",
+			"WITH is used with TYPE values, e.g. WITH person ... .Name = \"Ann\" ... END WITH",
+			targetType.String())
+		targetType = AnyType
+	}
+
+	a.withTargets = append(a.withTargets, targetType)
+	a.symbols.EnterScope("with")
+	a.analyzeBlockStatement(stmt.Body)
+	a.symbols.ExitScope()
+	a.withTargets = a.withTargets[:len(a.withTargets)-1]
+}
+
 func (a *Analyzer) analyzeBlockStatement(block *parser.BlockStatement) {
 	if block == nil {
 		return
@@ -857,12 +2748,21 @@ func (a *Analyzer) analyzeExpression(expr parser.Expression) *Type {
 	switch e := expr.(type) {
 	case *parser.Identifier:
 		return a.analyzeIdentifier(e)
+	case *parser.NewExpression:
+		return a.analyzeNewExpression(e)
 	case *parser.IntegerLiteral:
 		return IntegerType
 	case *parser.FloatLiteral:
 		return DoubleType
 	case *parser.StringLiteral:
 		return StringType
+	case *parser.DateTimeLiteral:
+		return DateTimeType
+	case *parser.InterpolatedString:
+		for _, part := range e.Parts {
+			a.analyzeExpression(part)
+		}
+		return StringType
 	case *parser.BooleanLiteral:
 		return BooleanType
 	case *parser.NilLiteral:
@@ -871,6 +2771,12 @@ func (a *Analyzer) analyzeExpression(expr parser.Expression) *Type {
 		return JSONType
 	case *parser.ArrayLiteral:
 		return a.analyzeArrayLiteral(e)
+	case *parser.RangeExpression:
+		args := []parser.Expression{e.Start, e.End}
+		if e.Step != nil {
+			args = append(args, e.Step)
+		}
+		return a.analyzeRange(e.Token.Line, args)
 	case *parser.PrefixExpression:
 		return a.analyzePrefixExpression(e)
 	case *parser.InfixExpression:
@@ -881,13 +2787,22 @@ func (a *Analyzer) analyzeExpression(expr parser.Expression) *Type {
 		return a.analyzeIndexExpression(e)
 	case *parser.MemberExpression:
 		return a.analyzeMemberExpression(e)
+	case *parser.FieldListExpression:
+		a.error(e.Token.Line, dberrors.InvalidMultipleAssignment, "field list %s can only be used on the right of a multiple assignment", e.String())
+		return AnyType
+	case *parser.WithTargetExpression:
+		if len(a.withTargets) == 0 {
+			a.error(e.Token.Line, dberrors.NotAllowedHere, "leading '.' member used outside of WITH")
+			return AnyType
+		}
+		return a.withTargets[len(a.withTargets)-1]
 	case *parser.AddressOfExpression:
 		innerType := a.analyzeExpression(e.Value)
 		return NewPointerType(innerType)
 	case *parser.DereferenceExpression:
 		innerType := a.analyzeExpression(e.Value)
 		if innerType.Kind != TypePointer {
-			a.error(e.Token.Line, "cannot dereference non-pointer type")
+			a.error(e.Token.Line, dberrors.InvalidOperand, "cannot dereference non-pointer type")
 			return AnyType
 		}
 		return innerType.ElementType
@@ -897,7 +2812,7 @@ func (a *Analyzer) analyzeExpression(expr parser.Expression) *Type {
 	case *parser.ReceiveExpression:
 		chanType := a.analyzeExpression(e.Channel)
 		if chanType.Kind != TypeChannel {
-			a.error(e.Token.Line, "cannot receive from non-channel type")
+			a.error(e.Token.Line, dberrors.InvalidChannel, "cannot receive from non-channel type")
 			return AnyType
 		}
 		return chanType.ElementType
@@ -931,7 +2846,20 @@ func (a *Analyzer) analyzeIdentifier(ident *parser.Identifier) *Type {
 		if a.symbols.GetImport(ident.Value) != nil {
 			return AnyType // Package reference
 		}
-		a.error(ident.Token.Line, "undefined: %s", ident.Value)
+		// ERR and ERL hold the number and line of the last trapped error
+		if IsErrorVariable(ident.Value) {
+			return IntegerType
+		}
+		a.error(ident.Token.Line, dberrors.UndefinedName, "undefined: %s", ident.Value)
+		return AnyType
+	}
+	a.refer(ident, sym)
+	if r := a.receiver; r != nil && sym == r.sym && r.changed > 0 && !r.changing {
+		r.read = true
+	}
+	if sym.Kind == SymModule {
+		a.errorWithHint(ident.Token.Line, dberrors.NotAValue, "module %s cannot be used as a value",
+			fmt.Sprintf("refer to a member of the module, e.g. %s.Name", ident.Value), ident.Value)
 		return AnyType
 	}
 	return sym.Type
@@ -946,7 +2874,7 @@ func (a *Analyzer) analyzeArrayLiteral(arr *parser.ArrayLiteral) *Type {
 	for _, elem := range arr.Elements[1:] {
 		t := a.analyzeExpression(elem)
 		if !elemType.IsCompatibleWith(t) {
-			a.error(arr.Token.Line, "inconsistent array element types")
+			a.error(arr.Token.Line, dberrors.TypeMismatch, "inconsistent array element types")
 		}
 	}
 
@@ -959,12 +2887,12 @@ func (a *Analyzer) analyzePrefixExpression(expr *parser.PrefixExpression) *Type
 	switch expr.Operator {
 	case "-":
 		if !rightType.IsNumeric() {
-			a.error(expr.Token.Line, "cannot negate non-numeric type")
+			a.error(expr.Token.Line, dberrors.InvalidOperand, "cannot negate non-numeric type")
 		}
 		return rightType
 	case "NOT":
 		if rightType.Kind != TypeBoolean {
-			a.error(expr.Token.Line, "NOT requires boolean operand")
+			a.error(expr.Token.Line, dberrors.InvalidOperand, "NOT requires boolean operand")
 		}
 		return BooleanType
 	default:
@@ -975,6 +2903,19 @@ func (a *Analyzer) analyzePrefixExpression(expr *parser.PrefixExpression) *Type
 func (a *Analyzer) analyzeInfixExpression(expr *parser.InfixExpression) *Type {
 	leftType := a.analyzeExpression(expr.Left)
 	rightType := a.analyzeExpression(expr.Right)
+	a.checkDivisor(expr)
+
+	// DATETIME and DURATION have their own arithmetic and comparisons
+	isTime := func(t *Type) bool { return t.Kind == TypeDateTime || t.Kind == TypeDuration }
+	if (isTime(leftType) || isTime(rightType)) && leftType.Kind != TypeAny && rightType.Kind != TypeAny && expr.Operator != "??" {
+		if result := TimeResultType(expr.Operator, leftType, rightType); result != nil {
+			return result
+		}
+		a.errorWithHint(expr.Token.Line, dberrors.InvalidOperand, "operator %s cannot be used with %s and %s",
+			"DATETIME +/- DURATION gives a DATETIME, DATETIME - DATETIME gives a DURATION",
+			expr.Operator, leftType.String(), rightType.String())
+		return AnyType
+	}
 
 	switch expr.Operator {
 	case "+", "-", "*", "/", "\\":
@@ -987,7 +2928,7 @@ func (a *Analyzer) analyzeInfixExpression(expr *parser.InfixExpression) *Type {
 			if expr.Operator == "+" && leftType.Kind == TypeString && rightType.Kind == TypeString {
 				return StringType
 			}
-			a.error(expr.Token.Line, "arithmetic operators require numeric operands")
+			a.error(expr.Token.Line, dberrors.InvalidOperand, "arithmetic operators require numeric operands")
 			return AnyType
 		}
 		return PromoteNumeric(leftType, rightType)
@@ -998,33 +2939,270 @@ func (a *Analyzer) analyzeInfixExpression(expr *parser.InfixExpression) *Type {
 
 	case "MOD":
 		if !leftType.IsInteger() || !rightType.IsInteger() {
-			a.error(expr.Token.Line, "MOD requires integer operands")
+			a.error(expr.Token.Line, dberrors.InvalidOperand, "MOD requires integer operands")
 		}
 		return IntegerType
 
 	case "^":
 		if !leftType.IsNumeric() || !rightType.IsNumeric() {
-			a.error(expr.Token.Line, "exponentiation requires numeric operands")
+			a.error(expr.Token.Line, dberrors.InvalidOperand, "exponentiation requires numeric operands")
 		}
 		return DoubleType
 
 	case "=", "<>", "<", ">", "<=", ">=":
 		return BooleanType
 
+	case "IN":
+		a.checkIn(expr, leftType, rightType)
+		return BooleanType
+
+	case "LIKE":
+		if (leftType.Kind != TypeString && leftType.Kind != TypeAny) || (rightType.Kind != TypeString && rightType.Kind != TypeAny) {
+			a.error(expr.Token.Line, dberrors.InvalidOperand, "LIKE requires STRING operands, got %s and %s", leftType.String(), rightType.String())
+		}
+		return BooleanType
+
 	case "AND", "OR", "XOR":
 		if leftType.Kind != TypeBoolean || rightType.Kind != TypeBoolean {
-			a.error(expr.Token.Line, "logical operators require boolean operands")
+			a.error(expr.Token.Line, dberrors.InvalidOperand, "logical operators require boolean operands")
 		}
 		return BooleanType
 
+	case "??":
+		return a.coalesceType(expr.Token.Line, leftType, rightType)
+
+	default:
+		return AnyType
+	}
+}
+
+// checkIn checks that x IN collection looks for a value of the collection's
+// element type in an array or slice, or its key type in a map
+func (a *Analyzer) checkIn(expr *parser.InfixExpression, leftType, rightType *Type) {
+	var want *Type
+	switch rightType.Kind {
+	case TypeArray, TypeSlice:
+		want = rightType.ElementType
+	case TypeMap:
+		want = rightType.KeyType
+	case TypeJSON:
+		want = StringType
+	case TypeAny:
+		return
+	default:
+		a.errorWithHint(expr.Token.Line, dberrors.InvalidOperand, "IN requires an array, slice or map, got %s",
+			"list the values in brackets, e.g. x IN [1, 2, 3]", rightType.String())
+		return
+	}
+	_, literal := expr.Right.(*parser.ArrayLiteral)
+	mismatch := !want.IsCompatibleWith(leftType)
+	if !literal && leftType.IsNumeric() && want.IsNumeric() && leftType.Kind != want.Kind {
+		// Only literal lists adapt to the type of the value
+		mismatch = true
+	}
+	if mismatch {
+		a.error(expr.Token.Line, dberrors.InvalidOperand, "IN cannot look for %s in %s", leftType.String(), rightType.String())
+	}
+}
+
+// analyzeSliceHelper checks IndexOf(slice, value), Contains(slice, value),
+// Reverse(slice) and Unique(slice), which work on a slice or array of any
+// element type
+func (a *Analyzer) analyzeSliceHelper(call *parser.CallExpression, name string) *Type {
+	search := strings.EqualFold(name, "IndexOf") || strings.EqualFold(name, "Contains")
+	want := 1
+	if search {
+		want = 2
+	}
+	if len(call.Arguments) != want {
+		a.error(call.Token.Line, dberrors.WrongCount, "wrong number of arguments: expected %d, got %d", want, len(call.Arguments))
+		for _, arg := range call.Arguments {
+			a.analyzeExpression(arg)
+		}
+		return AnyType
+	}
+	sliceType := a.analyzeExpression(call.Arguments[0])
+	elemType := AnyType
+	switch sliceType.Kind {
+	case TypeSlice, TypeArray:
+		elemType = sliceType.ElementType
+	case TypeAny:
+	default:
+		a.error(call.Token.Line, dberrors.TypeMismatch, "%s requires a slice or array, got %s", name, sliceType.String())
+	}
+	if !search {
+		if elemType == AnyType {
+			return AnyType
+		}
+		// An array comes back as an ordinary 0-based slice
+		return NewSliceType(elemType)
+	}
+	if valueType := a.analyzeExpression(call.Arguments[1]); !elemType.IsCompatibleWith(valueType) {
+		a.error(call.Token.Line, dberrors.TypeMismatch, "type mismatch: cannot search %s for %s", sliceType.String(), valueType.String())
+	}
+	if strings.EqualFold(name, "IndexOf") {
+		return IntegerType
+	}
+	return BooleanType
+}
+
+// analyzeCollectionFunc checks Map(slice, fn), Filter(slice, fn) and
+// Reduce(slice, fn, initial). The function's parameter must have exactly
+// the element type, since the Go helpers are generic over it.
+func (a *Analyzer) analyzeCollectionFunc(call *parser.CallExpression, name string) *Type {
+	upper := strings.ToUpper(name)
+	want := 2
+	if upper == "REDUCE" {
+		want = 3
+	}
+	if len(call.Arguments) != want {
+		a.error(call.Token.Line, dberrors.WrongCount, "wrong number of arguments: expected %d, got %d", want, len(call.Arguments))
+		for _, arg := range call.Arguments {
+			a.analyzeExpression(arg)
+		}
+		return AnyType
+	}
+	sliceType := a.analyzeExpression(call.Arguments[0])
+	elemType := AnyType
+	switch sliceType.Kind {
+	case TypeSlice, TypeArray:
+		elemType = sliceType.ElementType
+	case TypeAny:
 	default:
+		a.error(call.Token.Line, dberrors.TypeMismatch, "%s requires a slice or array, got %s", name, sliceType.String())
+	}
+	fnType := a.analyzeExpression(call.Arguments[1])
+	var initialType *Type
+	if upper == "REDUCE" {
+		initialType = a.analyzeExpression(call.Arguments[2])
+	}
+	if fnType.Kind == TypeAny {
 		return AnyType
 	}
+	if fnType.Kind != TypeFunction || fnType.Variadic || len(fnType.ReturnTypes) != 1 {
+		a.error(call.Token.Line, dberrors.TypeMismatch, "%s requires a FUNCTION with one result, got %s", name, fnType.String())
+		return AnyType
+	}
+	params, result := fnType.ParamTypes, fnType.ReturnTypes[0]
+	switch upper {
+	case "MAP":
+		if len(params) != 1 || !sameGoType(params[0], elemType) {
+			a.error(call.Token.Line, dberrors.TypeMismatch, "%s requires a FUNCTION(%s), got %s", name, elemType.String(), fnType.String())
+		}
+		return NewSliceType(result)
+	case "FILTER":
+		if len(params) != 1 || !sameGoType(params[0], elemType) || result.Kind != TypeBoolean {
+			a.error(call.Token.Line, dberrors.TypeMismatch, "%s requires a FUNCTION(%s) AS BOOLEAN, got %s", name, elemType.String(), fnType.String())
+		}
+		if elemType == AnyType {
+			return AnyType
+		}
+		return NewSliceType(elemType)
+	}
+	// REDUCE folds each element into a running total of the result type
+	if len(params) != 2 || !sameGoType(params[0], result) || !sameGoType(params[1], elemType) {
+		a.error(call.Token.Line, dberrors.TypeMismatch, "%s requires a FUNCTION(%s, %s) AS %s, got %s", name,
+			result.String(), elemType.String(), result.String(), fnType.String())
+	} else if !result.IsCompatibleWith(initialType) {
+		a.error(call.Token.Line, dberrors.TypeMismatch, "type mismatch: cannot use %s as initial value of type %s", initialType.String(), result.String())
+	}
+	return result
+}
+
+// sameGoType reports whether t and other generate the same Go type, or
+// either is unknown
+func sameGoType(t, other *Type) bool {
+	return t.Kind == TypeAny || other.Kind == TypeAny || t.GoType() == other.GoType()
+}
+
+// analyzeContainerOp checks an operation on a STACK (Push, Pop, Peek), a
+// QUEUE (Enqueue, Dequeue, Peek) or a SET (Add, Has, Remove)
+func (a *Analyzer) analyzeContainerOp(call *parser.CallExpression, name string, t *Type) *Type {
+	upper := strings.ToUpper(name)
+	var valid bool
+	switch upper {
+	case "PUSH", "POP":
+		valid = t.Kind == TypeStack
+	case "ENQUEUE", "DEQUEUE":
+		valid = t.Kind == TypeQueue
+	case "PEEK":
+		valid = t.Kind == TypeStack || t.Kind == TypeQueue
+	default:
+		valid = t.Kind == TypeSet
+	}
+	if !valid {
+		a.error(call.Token.Line, dberrors.TypeMismatch, "%s cannot be used on %s", name, t.String())
+	}
+
+	want := 1
+	switch upper {
+	case "PUSH", "ENQUEUE", "ADD", "HAS", "REMOVE":
+		want = 2
+	}
+	if len(call.Arguments) != want {
+		a.error(call.Token.Line, dberrors.WrongCount, "wrong number of arguments: expected %d, got %d", want, len(call.Arguments))
+		for _, arg := range call.Arguments[1:] {
+			a.analyzeExpression(arg)
+		}
+	} else if want == 2 {
+		if valueType := a.analyzeExpression(call.Arguments[1]); !t.ElementType.IsCompatibleWith(valueType) {
+			a.error(call.Token.Line, dberrors.TypeMismatch, "type mismatch: cannot use %s as element of %s", valueType.String(), t.String())
+		}
+	}
+
+	switch upper {
+	case "POP", "DEQUEUE", "PEEK":
+		return t.ElementType
+	case "HAS":
+		return BooleanType
+	}
+	return VoidType
+}
+
+// analyzeRange checks the start, end and optional step of RANGE or
+// start TO end, which give an INTEGER slice
+func (a *Analyzer) analyzeRange(line int, args []parser.Expression) *Type {
+	for _, arg := range args {
+		if t := a.analyzeExpression(arg); !t.IsInteger() && t.Kind != TypeAny {
+			a.error(line, dberrors.TypeMismatch, "range bounds and step must be integers, got %s", t.String())
+		}
+	}
+	if len(args) == 3 {
+		if lit, ok := args[2].(*parser.IntegerLiteral); ok && lit.Value == 0 {
+			a.error(line, dberrors.InvalidConstant, "range step cannot be 0")
+		}
+	}
+	return NewSliceType(IntegerType)
+}
+
+// coalesceType checks value ?? fallback (or IFNULL) and returns its type.
+// An untyped value such as a JSON member takes the fallback's type.
+func (a *Analyzer) coalesceType(line int, valueType, fallbackType *Type) *Type {
+	if !valueType.IsCompatibleWith(fallbackType) {
+		a.error(line, dberrors.TypeMismatch, "type mismatch: cannot use %s as fallback for %s", fallbackType.String(), valueType.String())
+		return valueType
+	}
+	switch valueType.Kind {
+	case TypeAny, TypeExternal:
+		return fallbackType
+	}
+	return valueType
 }
 
 func (a *Analyzer) analyzeCallExpression(call *parser.CallExpression) *Type {
 	// Check if this is an external Go package function call
-	if _, ok := call.Function.(*parser.MemberExpression); ok {
+	if member, ok := call.Function.(*parser.MemberExpression); ok && a.moduleOf(member.Object) == nil && a.packageOf(member.Object) == nil {
+		// Method calls through a DBasic INTERFACE are checked against its signatures
+		switch obj := member.Object.(type) {
+		case *parser.Identifier:
+			if sym := a.symbols.Resolve(obj.Value); sym != nil && sym.Type != nil && sym.Type.Kind == TypeInterface {
+				return a.analyzeInterfaceCall(call, sym.Type, member.Member.Value)
+			}
+		case *parser.WithTargetExpression:
+			if objType := a.analyzeExpression(obj); objType.Kind == TypeInterface {
+				return a.analyzeInterfaceCall(call, objType, member.Member.Value)
+			}
+		}
 		// External Go function call - analyze arguments but don't check types
 		for _, arg := range call.Arguments {
 			a.analyzeExpression(arg)
@@ -1032,8 +3210,10 @@ func (a *Analyzer) analyzeCallExpression(call *parser.CallExpression) *Type {
 		return AnyType
 	}
 
-	// Check for Go builtin functions that need special handling
-	if ident, ok := call.Function.(*parser.Identifier); ok {
+	// Check for Go builtin functions that need special handling; a SUB or
+	// FUNCTION of the program with the same name is called like any other,
+	// except that a container's operations are still its own
+	if ident, ok := call.Function.(*parser.Identifier); ok && (a.isBuiltinCall(ident) || isContainerOp(ident.Value)) {
 		switch strings.ToUpper(ident.Value) {
 		case "APPEND":
 			// APPEND(slice, element...) returns the same slice type
@@ -1050,7 +3230,7 @@ func (a *Analyzer) analyzeCallExpression(call *parser.CallExpression) *Type {
 			if len(call.Arguments) == 1 {
 				argType := a.analyzeExpression(call.Arguments[0])
 				switch argType.Kind {
-				case TypeString, TypeSlice, TypeArray, TypeJSON, TypeChannel, TypeBytes:
+				case TypeString, TypeSlice, TypeArray, TypeJSON, TypeMap, TypeChannel, TypeBytes, TypeStack, TypeQueue, TypeSet:
 					return IntegerType
 				}
 			}
@@ -1076,10 +3256,132 @@ func (a *Analyzer) analyzeCallExpression(call *parser.CallExpression) *Type {
 			return IntegerType
 		case "DELETE":
 			// DELETE(map, key) returns nothing
-			for _, arg := range call.Arguments {
-				a.analyzeExpression(arg)
+			if len(call.Arguments) != 2 {
+				a.error(call.Token.Line, dberrors.WrongCount, "wrong number of arguments: expected 2, got %d", len(call.Arguments))
+				for _, arg := range call.Arguments {
+					a.analyzeExpression(arg)
+				}
+				return VoidType
+			}
+			mapType := a.analyzeExpression(call.Arguments[0])
+			keyType := a.analyzeExpression(call.Arguments[1])
+			switch mapType.Kind {
+			case TypeMap:
+				if !mapType.KeyType.IsCompatibleWith(keyType) {
+					a.error(call.Token.Line, dberrors.TypeMismatch, "type mismatch: cannot use %s as map key of type %s",
+						keyType.String(), mapType.KeyType.String())
+				}
+			case TypeJSON:
+				if keyType.Kind != TypeString && keyType.Kind != TypeAny {
+					a.error(call.Token.Line, dberrors.TypeMismatch, "JSON keys must be STRING")
+				}
+			case TypeAny, TypeExternal:
+			default:
+				a.error(call.Token.Line, dberrors.TypeMismatch, "DELETE requires a map, got %s", mapType.String())
+			}
+			return VoidType
+		case "RANGE":
+			// RANGE(start, end[, step]) is the INTEGER slice start..end
+			if len(call.Arguments) != 2 && len(call.Arguments) != 3 {
+				a.error(call.Token.Line, dberrors.WrongCount, "wrong number of arguments: expected 2 or 3, got %d", len(call.Arguments))
+			}
+			return a.analyzeRange(call.Token.Line, call.Arguments)
+		case "IFNULL":
+			// IFNULL(value, fallback) is the function form of value ?? fallback
+			if len(call.Arguments) != 2 {
+				a.error(call.Token.Line, dberrors.WrongCount, "wrong number of arguments: expected 2, got %d", len(call.Arguments))
+				for _, arg := range call.Arguments {
+					a.analyzeExpression(arg)
+				}
+				return AnyType
+			}
+			return a.coalesceType(call.Token.Line, a.analyzeExpression(call.Arguments[0]), a.analyzeExpression(call.Arguments[1]))
+		case "ISLETTER", "ISDIGIT", "ISSPACE":
+			// IsLetter/IsDigit/IsSpace(STRING or INTEGER code point) AS BOOLEAN
+			if len(call.Arguments) != 1 {
+				a.error(call.Token.Line, dberrors.WrongCount, "wrong number of arguments: expected 1, got %d", len(call.Arguments))
+				for _, arg := range call.Arguments {
+					a.analyzeExpression(arg)
+				}
+				return BooleanType
+			}
+			switch argType := a.analyzeExpression(call.Arguments[0]); argType.Kind {
+			case TypeString, TypeInteger, TypeLong, TypeAny:
+			default:
+				a.error(call.Token.Line, dberrors.TypeMismatch, "%s requires a STRING or INTEGER, got %s", ident.Value, argType.String())
+			}
+			return BooleanType
+		case "ASSERTEQUAL":
+			// AssertEqual(got, want [, message]) compares two values of compatible types
+			if len(call.Arguments) < 2 || len(call.Arguments) > 3 {
+				a.error(call.Token.Line, dberrors.WrongCount, "wrong number of arguments: expected 2 or 3, got %d", len(call.Arguments))
+				for _, arg := range call.Arguments {
+					a.analyzeExpression(arg)
+				}
+				return VoidType
+			}
+			got := a.analyzeExpression(call.Arguments[0])
+			want := a.analyzeExpression(call.Arguments[1])
+			if !got.IsCompatibleWith(want) && !want.IsCompatibleWith(got) {
+				a.error(call.Token.Line, dberrors.TypeMismatch, "AssertEqual cannot compare %s with %s", got.String(), want.String())
+			}
+			if len(call.Arguments) == 3 {
+				if msgType := a.analyzeExpression(call.Arguments[2]); !StringType.IsCompatibleWith(msgType) {
+					a.error(call.Token.Line, dberrors.TypeMismatch, "AssertEqual message must be a STRING, got %s", msgType.String())
+				}
+			}
+			return VoidType
+		case "ASSERTTRUE":
+			// AssertTrue(condition [, message])
+			if len(call.Arguments) > 2 {
+				a.error(call.Token.Line, dberrors.WrongCount, "wrong number of arguments: expected 1 or 2, got %d", len(call.Arguments))
+			}
+		case "TCPCLOSE", "WSCLOSE":
+			// TcpClose/WsClose(connection or listener) returns nothing
+			if len(call.Arguments) != 1 {
+				a.error(call.Token.Line, dberrors.WrongCount, "wrong number of arguments: expected 1, got %d", len(call.Arguments))
+				for _, arg := range call.Arguments {
+					a.analyzeExpression(arg)
+				}
+				return VoidType
+			}
+			switch argType := a.analyzeExpression(call.Arguments[0]); argType.Kind {
+			case TypeConnection, TypeListener, TypeAny:
+			default:
+				a.error(call.Token.Line, dberrors.TypeMismatch, "%s requires a CONNECTION or LISTENER, got %s", ident.Value, argType.String())
 			}
 			return VoidType
+		case "BASE64ENCODE", "HEXENCODE", "AESENCRYPT", "AESDECRYPT":
+			for _, arg := range call.Arguments {
+				switch argType := a.analyzeExpression(arg); argType.Kind {
+				case TypeString, TypeBytes, TypeAny:
+				default:
+					a.error(call.Token.Line, dberrors.TypeMismatch, "%s requires a STRING or BYTES, got %s", ident.Value, argType.String())
+				}
+			}
+			// Fall through to the builtin for the argument count
+		case "DBEXEC", "DBINSERT", "DBQUERY", "DBQUERYROW":
+			// The statement runs on a DATABASE or a TRANSACTION
+			if len(call.Arguments) > 0 {
+				switch dbType := a.analyzeExpression(call.Arguments[0]); dbType.Kind {
+				case TypeDatabase, TypeTransaction, TypeAny:
+				default:
+					a.error(call.Token.Line, dberrors.TypeMismatch, "%s requires a DATABASE or TRANSACTION, got %s", ident.Value, dbType.String())
+				}
+			}
+			// Fall through to the builtin for the other arguments
+		case "INDEXOF", "CONTAINS", "REVERSE", "UNIQUE":
+			return a.analyzeSliceHelper(call, ident.Value)
+		case "MAP", "FILTER", "REDUCE":
+			return a.analyzeCollectionFunc(call, ident.Value)
+		case "PUSH", "POP", "PEEK", "ENQUEUE", "DEQUEUE", "ADD", "HAS", "REMOVE":
+			// Container operations when the first argument is a STACK, QUEUE
+			// or SET; otherwise the name can be a FUNCTION or SUB of the program
+			if len(call.Arguments) > 0 {
+				if t := a.analyzeExpression(call.Arguments[0]); t.IsContainer() {
+					return a.analyzeContainerOp(call, ident.Value, t)
+				}
+			}
 		case "CLOSE":
 			// CLOSE(channel) returns nothing
 			for _, arg := range call.Arguments {
@@ -1122,38 +3424,107 @@ func (a *Analyzer) analyzeCallExpression(call *parser.CallExpression) *Type {
 		return AnyType
 	}
 
+	// Calling a declared Go type converts a value to it
+	if sym.Kind == SymType {
+		for _, arg := range call.Arguments {
+			a.analyzeExpression(arg)
+		}
+		return sym.Type
+	}
+
+	switch sym.Type.Kind {
+	case TypeFunction, TypeSub:
+	case TypeAny, TypeExternal:
+		// Calling through an untyped value - can't check the signature
+		for _, arg := range call.Arguments {
+			a.analyzeExpression(arg)
+		}
+		return AnyType
+	default:
+		a.error(call.Token.Line, dberrors.TypeMismatch, "cannot call %s: %s is not a function", sym.Name, sym.Type.String())
+		return AnyType
+	}
+
+	a.checkArguments(call.Token.Line, sym.Type, call.Arguments)
+
+	if len(sym.Type.ReturnTypes) > 0 {
+		return sym.Type.ReturnTypes[0]
+	}
+	return VoidType
+}
+
+// checkArguments checks the arguments of a call against a function type
+func (a *Analyzer) checkArguments(line int, fnType *Type, args []parser.Expression) {
 	// Check argument count
-	if sym.Type.Variadic {
+	if fnType.Variadic {
 		// Variadic functions require at least the defined params
-		if len(call.Arguments) < len(sym.Type.ParamTypes) {
-			a.error(call.Token.Line, "wrong number of arguments: expected at least %d, got %d",
-				len(sym.Type.ParamTypes), len(call.Arguments))
+		if len(args) < len(fnType.ParamTypes) {
+			a.error(line, dberrors.WrongCount, "wrong number of arguments: expected at least %d, got %d",
+				len(fnType.ParamTypes), len(args))
 		}
 	} else {
 		// Non-variadic functions require exact match
-		if len(call.Arguments) != len(sym.Type.ParamTypes) {
-			a.error(call.Token.Line, "wrong number of arguments: expected %d, got %d",
-				len(sym.Type.ParamTypes), len(call.Arguments))
+		if len(args) != len(fnType.ParamTypes) {
+			a.error(line, dberrors.WrongCount, "wrong number of arguments: expected %d, got %d",
+				len(fnType.ParamTypes), len(args))
 		}
 	}
 
-	// Check argument types (only for defined params, not variadic args)
-	for i, arg := range call.Arguments {
-		if i >= len(sym.Type.ParamTypes) {
-			// For variadic functions, still analyze extra args but don't type-check them
-			if sym.Type.Variadic {
-				a.analyzeExpression(arg)
+	// Check argument types (variadic args only when their element type is known)
+	for i, arg := range args {
+		if i >= len(fnType.ParamTypes) {
+			if !fnType.Variadic {
+				break
+			}
+			argType := a.analyzeExpression(arg)
+			if fnType.VariadicType != nil && !fnType.VariadicType.IsCompatibleWith(argType) {
+				a.error(line, dberrors.TypeMismatch, "argument %d type mismatch", i+1)
 			}
+			continue
+		}
+		argType := a.analyzeExpression(arg)
+		if !fnType.ParamTypes[i].IsCompatibleWith(argType) {
+			a.error(line, dberrors.TypeMismatch, "argument %d type mismatch", i+1)
+			continue
+		}
+		a.checkInterfaceValue(line, fnType.ParamTypes[i], argType)
+	}
+}
+
+func (a *Analyzer) analyzeInterfaceCall(call *parser.CallExpression, iface *Type, name string) *Type {
+	var method *InterfaceMethod
+	for _, m := range iface.Methods {
+		if strings.EqualFold(m.Name, name) {
+			method = m
 			break
 		}
+	}
+	if method == nil {
+		a.error(call.Token.Line, dberrors.UnknownMember, "interface %s has no method %s", iface.Name, name)
+		for _, arg := range call.Arguments {
+			a.analyzeExpression(arg)
+		}
+		return AnyType
+	}
+
+	params := method.Type.ParamTypes
+	if len(call.Arguments) < len(params) || !method.Type.Variadic && len(call.Arguments) > len(params) {
+		a.error(call.Token.Line, dberrors.WrongCount, "wrong number of arguments: expected %d, got %d",
+			len(params), len(call.Arguments))
+	}
+	for i, arg := range call.Arguments {
 		argType := a.analyzeExpression(arg)
-		if !sym.Type.ParamTypes[i].IsCompatibleWith(argType) {
-			a.error(call.Token.Line, "argument %d type mismatch", i+1)
+		paramType := method.Type.VariadicType
+		if i < len(params) {
+			paramType = params[i]
+		}
+		if paramType != nil && !paramType.IsCompatibleWith(argType) {
+			a.error(call.Token.Line, dberrors.TypeMismatch, "argument %d type mismatch", i+1)
 		}
 	}
 
-	if len(sym.Type.ReturnTypes) > 0 {
-		return sym.Type.ReturnTypes[0]
+	if len(method.Type.ReturnTypes) > 0 {
+		return method.Type.ReturnTypes[0]
 	}
 	return VoidType
 }
@@ -1163,11 +3534,18 @@ func (a *Analyzer) resolveFunctionCall(call *parser.CallExpression) *Symbol {
 	case *parser.Identifier:
 		sym := a.symbols.Resolve(fn.Value)
 		if sym == nil {
-			a.error(call.Token.Line, "undefined function: %s", fn.Value)
+			a.error(call.Token.Line, dberrors.UndefinedName, "undefined function: %s", fn.Value)
 			return nil
 		}
+		a.refer(fn, sym)
 		return sym
 	case *parser.MemberExpression:
+		if mod := a.moduleOf(fn.Object); mod != nil {
+			return a.moduleMember(mod, fn.Member)
+		}
+		if pkg := a.packageOf(fn.Object); pkg != nil {
+			return a.packageMember(fn.Object.(*parser.Identifier).Value, pkg, fn.Member)
+		}
 		// Package.Function call
 		// For Go package calls, we return a placeholder
 		return &Symbol{
@@ -1183,11 +3561,25 @@ func (a *Analyzer) resolveFunctionCall(call *parser.CallExpression) *Symbol {
 func (a *Analyzer) analyzeIndexExpression(expr *parser.IndexExpression) *Type {
 	leftType := a.analyzeExpression(expr.Left)
 
+	// Maps are indexed by key rather than position
+	if leftType.Kind == TypeMap {
+		if expr.IsSlice {
+			a.error(expr.Token.Line, dberrors.InvalidOperand, "cannot slice type %s", leftType.String())
+			return AnyType
+		}
+		keyType := a.analyzeExpression(expr.Index)
+		if !leftType.KeyType.IsCompatibleWith(keyType) {
+			a.error(expr.Token.Line, dberrors.TypeMismatch, "type mismatch: cannot use %s as map key of type %s",
+				keyType.String(), leftType.KeyType.String())
+		}
+		return leftType.ElementType
+	}
+
 	// Analyze index if present
 	if expr.Index != nil {
 		indexType := a.analyzeExpression(expr.Index)
 		if !indexType.IsInteger() {
-			a.error(expr.Token.Line, "array index must be integer")
+			a.error(expr.Token.Line, dberrors.InvalidOperand, "array index must be integer")
 		}
 	}
 
@@ -1195,7 +3587,7 @@ func (a *Analyzer) analyzeIndexExpression(expr *parser.IndexExpression) *Type {
 	if expr.End != nil {
 		endType := a.analyzeExpression(expr.End)
 		if !endType.IsInteger() {
-			a.error(expr.Token.Line, "slice end index must be integer")
+			a.error(expr.Token.Line, dberrors.InvalidOperand, "slice end index must be integer")
 		}
 	}
 
@@ -1209,7 +3601,7 @@ func (a *Analyzer) analyzeIndexExpression(expr *parser.IndexExpression) *Type {
 		case TypeBytes:
 			return BytesType
 		default:
-			a.error(expr.Token.Line, "cannot slice type %s", leftType.String())
+			a.error(expr.Token.Line, dberrors.InvalidOperand, "cannot slice type %s", leftType.String())
 			return AnyType
 		}
 	}
@@ -1225,16 +3617,35 @@ func (a *Analyzer) analyzeIndexExpression(expr *parser.IndexExpression) *Type {
 	case TypeJSON:
 		return AnyType
 	default:
-		a.error(expr.Token.Line, "cannot index type %s", leftType.String())
+		a.error(expr.Token.Line, dberrors.InvalidOperand, "cannot index type %s", leftType.String())
 		return AnyType
 	}
 }
 
 func (a *Analyzer) analyzeMemberExpression(expr *parser.MemberExpression) *Type {
+	if mod := a.moduleOf(expr.Object); mod != nil {
+		if sym := a.moduleMember(mod, expr.Member); sym != nil {
+			return sym.Type
+		}
+		return AnyType
+	}
+
 	objType := a.analyzeExpression(expr.Object)
 
 	// Check for package access
 	if ident, ok := expr.Object.(*parser.Identifier); ok {
+		if pkg := a.packageOf(ident); pkg != nil {
+			sym := a.packageMember(ident.Value, pkg, expr.Member)
+			if sym == nil {
+				return AnyType
+			}
+			if sym.Kind == SymType {
+				a.errorWithHint(expr.Member.Token.Line, dberrors.NotAValue, "%s.%s is a type, not a value",
+					fmt.Sprintf("declare a variable of the type with DIM x AS %s.%s", ident.Value, expr.Member.Value), ident.Value, expr.Member.Value)
+				return AnyType
+			}
+			return sym.Type
+		}
 		if a.symbols.GetImport(ident.Value) != nil {
 			// This is a package member access
 			return AnyType
@@ -1247,25 +3658,12 @@ func (a *Analyzer) analyzeMemberExpression(expr *parser.MemberExpression) *Type
 
 	// Handle struct field access
 	if objType.Kind == TypeStruct {
-		for _, field := range objType.Fields {
-			if strings.EqualFold(field.Name, expr.Member.Value) {
-				return field.Type
-			}
-		}
-		a.error(expr.Token.Line, "type %s has no field %s", objType.Name, expr.Member.Value)
-		return AnyType
+		return a.structMemberType(expr, objType)
 	}
 
 	// Handle pointer to struct field access
 	if objType.Kind == TypePointer && objType.ElementType != nil && objType.ElementType.Kind == TypeStruct {
-		structType := objType.ElementType
-		for _, field := range structType.Fields {
-			if strings.EqualFold(field.Name, expr.Member.Value) {
-				return field.Type
-			}
-		}
-		a.error(expr.Token.Line, "type %s has no field %s", structType.Name, expr.Member.Value)
-		return AnyType
+		return a.structMemberType(expr, objType.ElementType)
 	}
 
 	// Handle external Go type field/method access
@@ -1284,7 +3682,24 @@ func (a *Analyzer) analyzeMemberExpression(expr *parser.MemberExpression) *Type
 		return AnyType
 	}
 
-	a.error(expr.Token.Line, "cannot access member of type %s", objType.String())
+	a.error(expr.Token.Line, dberrors.UnknownMember, "cannot access member of type %s", objType.String())
+	return AnyType
+}
+
+// structMemberType returns the type of a field or property of a struct,
+// including the members promoted from its EMBEDded types
+func (a *Analyzer) structMemberType(expr *parser.MemberExpression, structType *Type) *Type {
+	member, external := a.types.Member(structType, expr.Member.Value)
+	switch {
+	case member != nil && member.Property != nil:
+		return a.propertyType(expr, member.Owner.Name, member.Property)
+	case member != nil:
+		return member.Type
+	case external:
+		// May be promoted from an embedded Go type, which Go checks
+		return AnyType
+	}
+	a.error(expr.Token.Line, dberrors.UnknownMember, "type %s has no field %s", structType.Name, expr.Member.Value)
 	return AnyType
 }
 
@@ -1,11 +1,16 @@
 package analyzer
 
 import (
+	"go/ast"
+	goparser "go/parser"
+	"go/token"
+	"path/filepath"
 	"strings"
 	"testing"
 
 	"github.com/zditech/dbasic/pkg/lexer"
 	"github.com/zditech/dbasic/pkg/parser"
+	"github.com/zditech/dbasic/pkg/plugin"
 )
 
 func parse(input string) *parser.Program {
@@ -240,6 +245,65 @@ END SUB`
 	}
 }
 
+func TestAnalyzeParamArray(t *testing.T) {
+	input := `FUNCTION Sum(first AS INTEGER, PARAMARRAY rest AS []INTEGER) AS INTEGER
+    DIM total AS INTEGER = first
+    FOR EACH n IN rest
+        total = total + n
+    NEXT
+    RETURN total
+END FUNCTION
+
+SUB Main()
+    DIM result AS INTEGER
+    result = Sum(1)
+    result = Sum(1, 2, 3, 4)
+END SUB`
+
+	program := parse(input)
+	a := New()
+	_, errors := a.Analyze(program)
+
+	if len(errors) > 0 {
+		t.Errorf("unexpected errors: %v", errors)
+	}
+}
+
+func TestAnalyzeParamArrayErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`FUNCTION Sum(first AS INTEGER, PARAMARRAY rest AS []INTEGER) AS INTEGER
+    RETURN first
+END FUNCTION
+DIM x AS INTEGER = Sum()`, "expected at least 1"},
+		{`FUNCTION Sum(PARAMARRAY rest AS []INTEGER) AS INTEGER
+    RETURN 0
+END FUNCTION
+DIM x AS INTEGER = Sum(1, "two")`, "argument 2 type mismatch"},
+		{`SUB F(PARAMARRAY rest AS INTEGER)
+END SUB`, "must be a slice"},
+	}
+
+	for _, tt := range tests {
+		program := parse(tt.input)
+		a := New()
+		_, errors := a.Analyze(program)
+
+		found := false
+		for _, e := range errors {
+			if strings.Contains(e, tt.expected) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			t.Errorf("input %q: expected error containing %q, got: %v", tt.input, tt.expected, errors)
+		}
+	}
+}
+
 func TestAnalyzeIfCondition(t *testing.T) {
 	input := `SUB Main()
     DIM x AS INTEGER = 5
@@ -292,12 +356,13 @@ END SUB`
 	_ = errors
 }
 
-func TestAnalyzeWhileLoop(t *testing.T) {
+func TestAnalyzeForEach(t *testing.T) {
 	input := `SUB Main()
-    DIM x AS INTEGER = 10
-    WHILE x > 0
-        x = x - 1
-    WEND
+    DIM names AS []STRING
+    DIM total AS INTEGER
+    FOR EACH i, name IN names
+        total = total + i + Len(name)
+    NEXT
 END SUB`
 
 	program := parse(input)
@@ -309,90 +374,163 @@ END SUB`
 	}
 }
 
-func TestAnalyzeChannelOperations(t *testing.T) {
-	input := `DIM ch AS CHAN OF INTEGER
-SEND 42 TO ch
-DIM x AS INTEGER
-RECEIVE x FROM ch`
+func TestAnalyzeForEachElementType(t *testing.T) {
+	input := `SUB Main()
+    DIM nums AS []INTEGER
+    DIM s AS STRING
+    FOR EACH n IN nums
+        s = n
+    NEXT
+END SUB`
 
 	program := parse(input)
 	a := New()
 	_, errors := a.Analyze(program)
 
-	if len(errors) > 0 {
-		t.Errorf("unexpected errors: %v", errors)
+	found := false
+	for _, e := range errors {
+		if strings.Contains(e, "type mismatch") {
+			found = true
+			break
+		}
+	}
+	if !found {
+		t.Errorf("expected type mismatch error for INTEGER element, got: %v", errors)
 	}
 }
 
-func TestAnalyzeChannelTypeMismatch(t *testing.T) {
-	input := `DIM ch AS CHAN OF INTEGER
-SEND "hello" TO ch`
+func TestAnalyzeForEachNotIterable(t *testing.T) {
+	input := `SUB Main()
+    DIM x AS INTEGER
+    FOR EACH v IN x
+        PRINT v
+    NEXT
+END SUB`
 
 	program := parse(input)
 	a := New()
 	_, errors := a.Analyze(program)
 
-	if len(errors) == 0 {
-		t.Error("expected channel type mismatch error")
+	found := false
+	for _, e := range errors {
+		if strings.Contains(e, "cannot iterate") {
+			found = true
+			break
+		}
+	}
+	if !found {
+		t.Errorf("expected cannot iterate error, got: %v", errors)
 	}
 }
 
-func TestAnalyzePointerType(t *testing.T) {
-	input := `DIM x AS INTEGER = 42
-DIM ptr AS POINTER TO INTEGER`
+func TestAnalyzeWith(t *testing.T) {
+	input := `TYPE Address
+    DIM City AS STRING
+END TYPE
+
+TYPE Person
+    DIM Name AS STRING
+    DIM Home AS Address
+END TYPE
+
+SUB Main()
+    DIM p AS Person
+    WITH p
+        .Name = "Ann"
+        WITH .Home
+            .City = "Oslo"
+        END WITH
+    END WITH
+END SUB`
 
 	program := parse(input)
 	a := New()
-	symbols, errors := a.Analyze(program)
+	_, errors := a.Analyze(program)
 
 	if len(errors) > 0 {
 		t.Errorf("unexpected errors: %v", errors)
 	}
+}
 
-	sym := symbols.Resolve("ptr")
-	if sym == nil {
-		t.Fatal("expected 'ptr' to be defined")
+func TestAnalyzeWithErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`TYPE Person
+    DIM Name AS STRING
+END TYPE
+SUB Main()
+    DIM p AS Person
+    WITH p
+        .Nmae = "Ann"
+    END WITH
+END SUB`, "type Person has no field Nmae"},
+		{`TYPE Person
+    DIM Name AS STRING
+END TYPE
+SUB Main()
+    DIM p AS Person
+    WITH p
+        .Name = 42
+    END WITH
+END SUB`, "type mismatch"},
+		{`SUB Main()
+    DIM n AS INTEGER
+    WITH n
+    END WITH
+END SUB`, "WITH requires a struct or object"},
 	}
 
-	if sym.Type.Kind != TypePointer {
-		t.Errorf("expected pointer type, got %s", sym.Type.String())
+	for _, tt := range tests {
+		program := parse(tt.input)
+		a := New()
+		_, errors := a.Analyze(program)
+
+		found := false
+		for _, e := range errors {
+			if strings.Contains(e, tt.expected) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			t.Errorf("input %q: expected error containing %q, got: %v", tt.input, tt.expected, errors)
+		}
 	}
 }
 
-func TestAnalyzeImport(t *testing.T) {
-	input := `IMPORT "fmt"
-IMPORT "net/http" AS http`
+func TestAnalyzeLike(t *testing.T) {
+	input := `SUB Main()
+    DIM name AS STRING = "Alice"
+    DIM ok AS BOOLEAN = name LIKE "A*" AND NOT (name LIKE "*#")
+    PRINT ok
+END SUB`
 
 	program := parse(input)
 	a := New()
-	symbols, errors := a.Analyze(program)
+	_, errors := a.Analyze(program)
 
 	if len(errors) > 0 {
-		t.Errorf("unexpected errors: %v", errors)
-	}
-
-	// Check that imports are registered
-	if symbols.GetImport("fmt") == nil {
-		t.Error("expected 'fmt' import to be registered")
+		t.Fatalf("unexpected errors: %v", errors)
 	}
 
-	if symbols.GetImport("http") == nil {
-		t.Error("expected 'http' import alias to be registered")
+	program = parse("DIM n AS INTEGER\nPRINT n LIKE \"1*\"")
+	a = New()
+	_, errors = a.Analyze(program)
+	if len(errors) == 0 || !strings.Contains(errors[0], "LIKE requires STRING operands, got INTEGER and STRING") {
+		t.Errorf("expected LIKE operand error, got %v", errors)
 	}
 }
 
-func TestAnalyzeMultipleReturnValues(t *testing.T) {
-	input := `FUNCTION Divide(a AS INTEGER, b AS INTEGER) AS (INTEGER, BOOLEAN)
-    IF b = 0 THEN
-        RETURN 0, FALSE
-    ENDIF
-    RETURN a / b, TRUE
-END FUNCTION
-
-SUB Main()
-    DIM result AS INTEGER
-    DIM ok AS BOOLEAN
-    result, ok = Divide(10, 2)
+func TestAnalyzeIn(t *testing.T) {
+	input := `SUB Main()
+    DIM x AS INTEGER = 2
+    DIM d AS DOUBLE = 2.5
+    DIM primes AS []INTEGER = []INTEGER{2, 3, 5}
+    DIM ages AS MAP OF STRING TO INTEGER
+    DIM found AS BOOLEAN = x IN [1, 2, 3] OR x IN primes OR d IN [2, 3] OR "ann" IN ages
+    PRINT found
 END SUB`
 
 	program := parse(input)
@@ -400,18 +538,47 @@ END SUB`
 	_, errors := a.Analyze(program)
 
 	if len(errors) > 0 {
-		t.Errorf("unexpected errors: %v", errors)
+		t.Fatalf("unexpected errors: %v", errors)
 	}
 }
 
-func TestAnalyzeArithmeticOperators(t *testing.T) {
+func TestAnalyzeInErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"DIM x AS INTEGER\nPRINT x IN 5", "IN requires an array, slice or map, got INTEGER"},
+		{"DIM s AS []INTEGER\nPRINT \"a\" IN s", "IN cannot look for STRING in INTEGER()"},
+		{"DIM s AS []INTEGER\nDIM n AS LONG\nPRINT n IN s", "IN cannot look for LONG in INTEGER()"},
+		{"DIM m AS MAP OF STRING TO INTEGER\nPRINT 1 IN m", "IN cannot look for INTEGER in MAP OF STRING TO INTEGER"},
+	}
+
+	for _, tt := range tests {
+		program := parse(tt.input)
+		a := New()
+		_, errors := a.Analyze(program)
+
+		found := false
+		for _, err := range errors {
+			if strings.Contains(err, tt.expected) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			t.Errorf("expected error containing %q for %q, got %v", tt.expected, tt.input, errors)
+		}
+	}
+}
+
+func TestAnalyzeRange(t *testing.T) {
 	input := `SUB Main()
-    DIM a AS INTEGER = 5 + 3
-    DIM b AS INTEGER = 10 - 2
-    DIM c AS INTEGER = 4 * 2
-    DIM d AS INTEGER = 8 / 2
-    DIM e AS INTEGER = 10 MOD 3
-    DIM f AS DOUBLE = 2 ^ 3
+    DIM n AS INTEGER = 3
+    DIM evens AS []INTEGER = RANGE(2, 10, 2)
+    FOR EACH i IN 1 TO n STEP 1
+        evens = APPEND(evens, i)
+    NEXT
+    PRINT evens
 END SUB`
 
 	program := parse(input)
@@ -419,14 +586,53 @@ END SUB`
 	_, errors := a.Analyze(program)
 
 	if len(errors) > 0 {
-		t.Errorf("unexpected errors: %v", errors)
+		t.Fatalf("unexpected errors: %v", errors)
 	}
 }
 
-func TestAnalyzeStringConcatenation(t *testing.T) {
-	input := `SUB Main()
-    DIM s AS STRING = "Hello" & " " & "World"
-    DIM t AS STRING = "A" + "B"
+func TestAnalyzeRangeErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"FOR EACH i IN 1 TO \"x\"\nNEXT", "range bounds and step must be integers, got STRING"},
+		{"DIM s AS []INTEGER = RANGE(1, 2.5)", "range bounds and step must be integers, got DOUBLE"},
+		{"DIM s AS []INTEGER = RANGE(1, 5, 0)", "range step cannot be 0"},
+		{"DIM s AS []INTEGER = RANGE(1)", "wrong number of arguments: expected 2 or 3, got 1"},
+		{"DIM s AS []STRING = RANGE(1, 2)", "cannot assign INTEGER() to STRING()"},
+	}
+
+	for _, tt := range tests {
+		program := parse(tt.input)
+		a := New()
+		_, errors := a.Analyze(program)
+
+		found := false
+		for _, err := range errors {
+			if strings.Contains(err, tt.expected) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			t.Errorf("expected error containing %q for %q, got %v", tt.expected, tt.input, errors)
+		}
+	}
+}
+
+func TestAnalyzeFieldListAssignment(t *testing.T) {
+	input := `TYPE Contact
+    DIM FirstName AS STRING
+    DIM Age AS INTEGER
+END TYPE
+
+SUB Main()
+    DIM c AS Contact
+    DIM p AS POINTER TO Contact = @c
+    DIM name AS STRING
+    DIM age AS INTEGER
+    name, age = c.{FirstName, Age}
+    name, age = p.{FirstName, Age}
 END SUB`
 
 	program := parse(input)
@@ -434,16 +640,47 @@ END SUB`
 	_, errors := a.Analyze(program)
 
 	if len(errors) > 0 {
-		t.Errorf("unexpected errors: %v", errors)
+		t.Fatalf("unexpected errors: %v", errors)
 	}
 }
 
-func TestAnalyzeLogicalOperators(t *testing.T) {
+func TestAnalyzeFieldListAssignmentErrors(t *testing.T) {
+	types := "TYPE Contact\nDIM FirstName AS STRING\nDIM Age AS INTEGER\nEND TYPE\nFUNCTION Make() AS Contact\nDIM c AS Contact\nRETURN c\nEND FUNCTION\n"
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"DIM c AS Contact\nDIM a AS STRING\nDIM b AS STRING\na, b = c.{FirstName, Age}", "position 2: cannot assign INTEGER to STRING"},
+		{"DIM c AS Contact\nDIM a AS STRING\nDIM b AS STRING\na, b = c.{FirstName}", "wrong number of values in multiple assignment: expected 1, got 2"},
+		{"DIM c AS Contact\nDIM a AS STRING\nDIM b AS STRING\na, b = c.{FirstName, Email}", "type Contact has no field Email"},
+		{"DIM a AS STRING\nDIM b AS STRING\na, b = Make().{FirstName, FirstName}", "field list requires a variable, not a function call"},
+		{"DIM c AS Contact\nDIM a AS STRING\na = c.{FirstName}", "field list c.{FirstName} can only be used on the right of a multiple assignment"},
+	}
+
+	for _, tt := range tests {
+		program := parse(types + tt.input)
+		a := New()
+		_, errors := a.Analyze(program)
+
+		found := false
+		for _, err := range errors {
+			if strings.Contains(err, tt.expected) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			t.Errorf("expected error containing %q for %q, got %v", tt.expected, tt.input, errors)
+		}
+	}
+}
+
+func TestAnalyzeWhileLoop(t *testing.T) {
 	input := `SUB Main()
-    DIM a AS BOOLEAN = TRUE AND FALSE
-    DIM b AS BOOLEAN = TRUE OR FALSE
-    DIM c AS BOOLEAN = NOT TRUE
-    DIM d AS BOOLEAN = TRUE XOR FALSE
+    DIM x AS INTEGER = 10
+    WHILE x > 0
+        x = x - 1
+    WEND
 END SUB`
 
 	program := parse(input)
@@ -455,15 +692,11 @@ END SUB`
 	}
 }
 
-func TestAnalyzeComparisonOperators(t *testing.T) {
-	input := `SUB Main()
-    DIM a AS BOOLEAN = 5 > 3
-    DIM b AS BOOLEAN = 5 < 10
-    DIM c AS BOOLEAN = 5 >= 5
-    DIM d AS BOOLEAN = 5 <= 5
-    DIM e AS BOOLEAN = 5 = 5
-    DIM f AS BOOLEAN = 5 <> 3
-END SUB`
+func TestAnalyzeChannelOperations(t *testing.T) {
+	input := `DIM ch AS CHAN OF INTEGER
+SEND 42 TO ch
+DIM x AS INTEGER
+RECEIVE x FROM ch`
 
 	program := parse(input)
 	a := New()
@@ -474,28 +707,32 @@ END SUB`
 	}
 }
 
-func TestAnalyzeArrayType(t *testing.T) {
-	input := `DIM arr(10) AS INTEGER`
+func TestAnalyzeChannelTypeMismatch(t *testing.T) {
+	input := `DIM ch AS CHAN OF INTEGER
+SEND "hello" TO ch`
 
 	program := parse(input)
 	a := New()
-	symbols, errors := a.Analyze(program)
-
-	if len(errors) > 0 {
-		t.Errorf("unexpected errors: %v", errors)
-	}
+	_, errors := a.Analyze(program)
 
-	sym := symbols.Resolve("arr")
-	if sym == nil {
-		t.Fatal("expected 'arr' to be defined")
+	if len(errors) == 0 {
+		t.Error("expected channel type mismatch error")
 	}
 }
 
-func TestAnalyzeJSONType(t *testing.T) {
-	input := `DIM data AS JSON = {"name": "John", "age": 30}
-
-SUB Main()
-    PRINT data.name
+func TestAnalyzeSelectChannel(t *testing.T) {
+	input := `SUB Main()
+    DIM results AS CHAN OF INTEGER = MAKE_CHAN(INTEGER)
+    DIM jobs AS CHAN OF STRING = MAKE_CHAN(STRING, 1)
+    DIM x AS INTEGER
+    SELECT CHANNEL
+        CASE RECEIVE x FROM results
+            PRINT x
+        CASE SEND "job" TO jobs
+            PRINT "queued"
+        CASE TIMEOUT Seconds(2)
+            PRINT "timed out"
+    END SELECT
 END SUB`
 
 	program := parse(input)
@@ -507,54 +744,3233 @@ END SUB`
 	}
 }
 
-func TestHasMain(t *testing.T) {
+func TestAnalyzeSelectChannelErrors(t *testing.T) {
+	decls := `DIM ch AS CHAN OF INTEGER
+DIM s AS STRING
+`
 	tests := []struct {
-		input   string
-		hasMain bool
+		input    string
+		expected string
 	}{
-		{`SUB Main()
-END SUB`, true},
-		{`SUB Other()
-END SUB`, false},
-		{`DIM x AS INTEGER`, false},
+		{decls + "SELECT CHANNEL\nCASE RECEIVE s FROM ch\nEND SELECT", "cannot receive INTEGER from channel of STRING"},
+		{decls + "SELECT CHANNEL\nCASE SEND s TO ch\nEND SELECT", "cannot send STRING to channel of INTEGER"},
+		{decls + "SELECT CHANNEL\nCASE RECEIVE s FROM s\nEND SELECT", "RECEIVE source must be a channel"},
+		{decls + "SELECT CHANNEL\nCASE TIMEOUT \"soon\"\nEND SELECT", "CASE TIMEOUT requires milliseconds or a DURATION, got STRING"},
+		{decls + "SELECT CHANNEL\nCASE TIMEOUT 1\nCASE TIMEOUT 2\nEND SELECT", "SELECT CHANNEL can have only one CASE TIMEOUT"},
+		{decls + "SELECT CHANNEL\nCASE TIMEOUT 1\nCASE ELSE\nEND SELECT", "SELECT CHANNEL cannot have both CASE TIMEOUT and CASE ELSE"},
 	}
 
-	for i, tt := range tests {
+	for _, tt := range tests {
 		program := parse(tt.input)
 		a := New()
-		a.Analyze(program)
+		_, errors := a.Analyze(program)
 
-		if a.HasMain() != tt.hasMain {
-			t.Errorf("test[%d]: expected HasMain()=%v, got %v", i, tt.hasMain, a.HasMain())
+		found := false
+		for _, err := range errors {
+			if strings.Contains(err, tt.expected) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			t.Errorf("%q: expected error containing %q, got %v", tt.input, tt.expected, errors)
 		}
 	}
 }
 
-func TestAnalyzeLabel(t *testing.T) {
-	input := `SUB Main()
-start:
-    PRINT "Hello"
-    GOTO start
+func TestAnalyzeLock(t *testing.T) {
+	input := `TYPE Counter
+    DIM mu AS MUTEX
+    DIM n AS INTEGER
+END TYPE
+
+DIM mu AS MUTEX
+DIM total AS INTEGER
+
+SUB Add(c AS POINTER TO Counter, m AS POINTER TO MUTEX)
+    DIM i AS INTEGER
+    LOCK m
+        total = total + 1
+    END LOCK
+    LOCK c.mu
+        FOR i = 1 TO 10
+            IF i > 5 THEN
+                EXIT FOR
+            END IF
+            c.n = c.n + i
+        NEXT i
+    END LOCK
+END SUB
+
+SUB AddAll(values AS []INTEGER)
+    LOCK mu
+        FOR EACH v IN values
+            IF v < 0 THEN
+                EXIT FOR
+            END IF
+            total = total + v
+        NEXT
+    END LOCK
 END SUB`
 
 	program := parse(input)
 	a := New()
-	_, errors := a.Analyze(program)
+	symbols, errors := a.Analyze(program)
 
 	if len(errors) > 0 {
-		t.Errorf("unexpected errors: %v", errors)
+		t.Fatalf("unexpected errors: %v", errors)
+	}
+
+	sym := symbols.Resolve("mu")
+	if sym == nil || sym.Type.Kind != TypeMutex {
+		t.Errorf("expected mu to be a MUTEX")
 	}
 }
 
-func TestAnalyzeSelectCase(t *testing.T) {
-	input := `SUB Main()
-    DIM x AS INTEGER = 2
-    SELECT CASE x
-    CASE 1
-        PRINT "one"
-    CASE 2
-        PRINT "two"
-    CASE ELSE
+func TestAnalyzeLockErrors(t *testing.T) {
+	decls := `DIM mu AS MUTEX
+DIM other AS MUTEX
+DIM n AS INTEGER
+`
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{decls + "LOCK n\nEND LOCK", "LOCK requires a MUTEX, got INTEGER"},
+		{decls + "LOCK mu\nLOCK mu\nEND LOCK\nEND LOCK", "mutex mu is already locked by an enclosing LOCK"},
+		{decls + "FUNCTION F() AS INTEGER\nLOCK mu\nRETURN n\nEND LOCK\nRETURN 0\nEND FUNCTION", "RETURN inside LOCK would leave mutex mu locked"},
+		{decls + "SUB S()\nLOCK mu\nEXIT SUB\nEND LOCK\nEND SUB", "EXIT SUB inside LOCK would leave mutex mu locked"},
+		{decls + "SUB S()\nDO\nLOCK mu\nEXIT DO\nEND LOCK\nLOOP\nEND SUB", "EXIT DO inside LOCK would leave mutex mu locked"},
+		{decls + "SUB S(a AS []INTEGER)\nFOR EACH x IN a\nLOCK mu\nEXIT FOR\nEND LOCK\nNEXT\nEND SUB", "EXIT FOR inside LOCK would leave mutex mu locked"},
+		{decls + "SUB S()\nLOCK mu\nGOTO done\nEND LOCK\ndone:\nEND SUB", "GOTO inside LOCK would leave mutex mu locked"},
+		{decls + "SUB S()\nmu = other\nEND SUB", "cannot assign to MUTEX mu"},
+		{decls + "SUB S(m AS MUTEX)\nEND SUB", "MUTEX parameter m cannot be passed by value"},
+	}
+
+	for _, tt := range tests {
+		program := parse(tt.input)
+		a := New()
+		_, errors := a.Analyze(program)
+
+		found := false
+		for _, err := range errors {
+			if strings.Contains(err, tt.expected) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			t.Errorf("%q: expected error containing %q, got %v", tt.input, tt.expected, errors)
+		}
+	}
+}
+
+func TestAnalyzeContext(t *testing.T) {
+	input := `SUB Worker(ctx AS CONTEXT)
+    WHILE SleepContext(ctx, 10)
+    WEND
+END SUB
+
+SUB Main()
+    DIM ctx AS CONTEXT
+    DIM cancel AS SUB()
+    ctx, cancel = WithTimeout(Background(), 100)
+    SPAWN Worker(ctx)
+    SELECT CHANNEL
+        CASE RECEIVE FROM Done(ctx)
+            PRINT Cancelled(ctx)
+    END SELECT
+    RECEIVE FROM Done(ctx)
+    cancel()
+END SUB`
+
+	program := parse(input)
+	a := New()
+	symbols, errors := a.Analyze(program)
+
+	if len(errors) > 0 {
+		t.Fatalf("unexpected errors: %v", errors)
+	}
+
+	sym := symbols.Resolve("Background")
+	if sym == nil || sym.Type.ReturnTypes[0].Kind != TypeContext {
+		t.Errorf("expected Background to return a CONTEXT")
+	}
+}
+
+func TestAnalyzeContextErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"DIM n AS INTEGER\nRECEIVE FROM n", "RECEIVE source must be a channel"},
+		{"DIM ctx AS CONTEXT\nDIM n AS INTEGER\nRECEIVE n FROM Done(ctx)", "cannot receive VOID"},
+		{"DIM b AS BOOLEAN = Cancelled(5)", "argument 1"},
+	}
+
+	for _, tt := range tests {
+		program := parse(tt.input)
+		a := New()
+		_, errors := a.Analyze(program)
+
+		found := false
+		for _, err := range errors {
+			if strings.Contains(err, tt.expected) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			t.Errorf("%q: expected error containing %q, got %v", tt.input, tt.expected, errors)
+		}
+	}
+}
+
+func TestAnalyzeOnError(t *testing.T) {
+	input := `FUNCTION SafeDivide(a AS INTEGER, b AS INTEGER) AS INTEGER
+    ON ERROR GOTO Failed
+    IF b = 1 THEN
+        ON ERROR GOTO 0
+    END IF
+    RETURN a / b
+Failed:
+    PRINT ERR; ERL; ErrorMessage()
+    RETURN -1
+END FUNCTION
+
+SUB Raise(code AS INTEGER)
+    DIM err AS ERROR
+    ERROR code
+    PRINT err
+END SUB`
+
+	program := parse(input)
+	a := New()
+	_, errors := a.Analyze(program)
+
+	if len(errors) > 0 {
+		t.Fatalf("unexpected errors: %v", errors)
+	}
+}
+
+func TestAnalyzeOptionErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"OPTION BASE", "unknown OPTION BASE"},
+		{"SUB Main()\nOPTION UNICODE\nEND SUB", "OPTION UNICODE must be at the top level of the program"},
+	}
+
+	for _, tt := range tests {
+		program := parse(tt.input)
+		a := New()
+		_, errors := a.Analyze(program)
+
+		found := false
+		for _, err := range errors {
+			if strings.Contains(err, tt.expected) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			t.Errorf("expected error containing %q for %q, got %v", tt.expected, tt.input, errors)
+		}
+	}
+}
+
+func TestAnalyzeEmbed(t *testing.T) {
+	input := `EMBEDFILE "logo.png" AS logo
+EMBEDDIR "assets" AS files
+
+SUB Main()
+    DIM b AS BYTES = logo
+    DIM page AS BYTES = files["index.html"]
+    PRINT Len(b), Len(page)
+END SUB`
+
+	program := parse(input)
+	a := New()
+	_, errors := a.Analyze(program)
+	if len(errors) > 0 {
+		t.Fatalf("unexpected errors: %v", errors)
+	}
+
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"EMBEDFILE \"a.txt\" AS a\nSUB Main()\nDIM s AS STRING = a\nEND SUB", "cannot assign"},
+		{"SUB Main()\nEMBEDFILE \"a.txt\" AS a\nEND SUB", "EMBEDFILE must be at the top level of the program"},
+		{"EMBEDDIR \"\" AS d", "EMBEDDIR needs a path"},
+	}
+	for _, tt := range tests {
+		_, errors := New().Analyze(parse(tt.input))
+		found := false
+		for _, err := range errors {
+			if strings.Contains(err, tt.expected) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			t.Errorf("expected error containing %q for %q, got %v", tt.expected, tt.input, errors)
+		}
+	}
+}
+
+func TestAnalyzeCheck(t *testing.T) {
+	input := `FUNCTION Validate(n AS INTEGER) AS ERROR
+    IF n < 0 THEN
+        RETURN NewError("negative")
+    END IF
+    RETURN NIL
+END FUNCTION
+
+FUNCTION Half(n AS INTEGER) AS (INTEGER, ERROR)
+    DIM err AS ERROR = Validate(n)
+    CHECK err
+    CHECK Validate(n - 1)
+    RETURN n / 2, NIL
+END FUNCTION`
+
+	program := parse(input)
+	a := New()
+	_, errors := a.Analyze(program)
+
+	if len(errors) > 0 {
+		t.Fatalf("unexpected errors: %v", errors)
+	}
+}
+
+func TestAnalyzeCheckErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"SUB Main()\nDIM err AS ERROR\nCHECK err\nEND SUB", "CHECK requires the enclosing FUNCTION to return ERROR as its last value"},
+		{"FUNCTION F() AS (ERROR, INTEGER)\nDIM err AS ERROR\nCHECK err\nRETURN NIL, 1\nEND FUNCTION", "CHECK requires the enclosing FUNCTION to return ERROR as its last value"},
+		{"FUNCTION F() AS ERROR\nDIM n AS INTEGER\nCHECK n\nRETURN NIL\nEND FUNCTION", "CHECK requires an ERROR, got INTEGER"},
+		{"FUNCTION F() AS ERROR\nDIM err AS ERROR\nGOSUB Cleanup\nRETURN NIL\nCleanup:\nCHECK err\nRETURN\nEND FUNCTION", "CHECK cannot leave the GOSUB subroutine Cleanup"},
+	}
+
+	for _, tt := range tests {
+		program := parse(tt.input)
+		a := New()
+		_, errors := a.Analyze(program)
+
+		found := false
+		for _, err := range errors {
+			if strings.Contains(err, tt.expected) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			t.Errorf("expected error containing %q for %q, got %v", tt.expected, tt.input, errors)
+		}
+	}
+}
+
+func TestAnalyzeInput(t *testing.T) {
+	input := `SUB Main()
+    DIM name AS STRING
+    DIM price AS DOUBLE
+    INPUT "Name: "; name
+    INPUT "Price: ", price
+    INPUT "Age: ", age AS INTEGER
+    PRINT name; price; age + 1
+END SUB`
+
+	program := parse(input)
+	a := New()
+	_, errors := a.Analyze(program)
+
+	if len(errors) > 0 {
+		t.Fatalf("unexpected errors: %v", errors)
+	}
+}
+
+func TestAnalyzeInputErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"SUB Main()\nINPUT missing\nEND SUB", "undefined variable: missing"},
+		{"SUB Main()\nDIM xs AS []INTEGER\nINPUT xs\nEND SUB", "INPUT cannot read into a variable of type INTEGER()"},
+		{"SUB Main()\nINPUT \"When? \", d AS DATETIME\nEND SUB", "INPUT cannot read into a variable of type DATETIME"},
+		{"SUB Main()\nDIM n AS INTEGER\nINPUT n AS INTEGER\nEND SUB", "symbol already defined: n"},
+	}
+
+	for _, tt := range tests {
+		program := parse(tt.input)
+		a := New()
+		_, errors := a.Analyze(program)
+
+		found := false
+		for _, err := range errors {
+			if strings.Contains(err, tt.expected) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			t.Errorf("expected error containing %q for %q, got %v", tt.expected, tt.input, errors)
+		}
+	}
+}
+
+func TestAnalyzeFileStatements(t *testing.T) {
+	input := `SUB Main()
+    DIM line AS STRING
+    DIM f AS LONG = 2
+    OPEN "in.txt" FOR INPUT AS #1
+    OPEN "out.txt" FOR OUTPUT AS #f
+    DO WHILE NOT EOF(1)
+        LINE INPUT #1, line
+        PRINT #f, line
+    LOOP
+    CLOSE #1, #f
+END SUB`
+
+	program := parse(input)
+	a := New()
+	_, errors := a.Analyze(program)
+
+	if len(errors) > 0 {
+		t.Fatalf("unexpected errors: %v", errors)
+	}
+}
+
+func TestAnalyzeFileStatementErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"SUB Main()\nDIM p AS INTEGER\nOPEN p FOR INPUT AS #1\nEND SUB", "OPEN requires a STRING path, got INTEGER"},
+		{"SUB Main()\nDIM n AS INTEGER\nLINE INPUT #1, n\nEND SUB", "LINE INPUT requires a STRING variable, got INTEGER"},
+		{"SUB Main()\nLINE INPUT #1, missing\nEND SUB", "undefined variable: missing"},
+		{"SUB Main()\nDIM s AS STRING\nCLOSE #s\nEND SUB", "file number must be an integer, got STRING"},
+		{"SUB Main()\nDIM d AS DOUBLE\nPRINT #d, \"x\"\nEND SUB", "file number must be an integer, got DOUBLE"},
+	}
+
+	for _, tt := range tests {
+		program := parse(tt.input)
+		a := New()
+		_, errors := a.Analyze(program)
+
+		found := false
+		for _, err := range errors {
+			if strings.Contains(err, tt.expected) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			t.Errorf("expected error containing %q for %q, got %v", tt.expected, tt.input, errors)
+		}
+	}
+}
+
+func TestAnalyzeRecordFiles(t *testing.T) {
+	input := `TYPE Person
+    DIM Name AS STRING * 20
+    DIM Age AS INTEGER
+    DIM Score AS DOUBLE
+END TYPE
+
+SUB Main()
+    DIM p AS Person
+    DIM people(3) AS Person
+    DIM header AS STRING = "DBAS"
+    DIM n AS LONG = 2
+    OPEN "people.dat" FOR RANDOM AS #1 LEN = 36
+    PUT #1, n, p
+    GET #1, 1, people[0]
+    GET #1, , p.Age
+    CLOSE #1
+    OPEN "people.bin" FOR BINARY AS #2
+    PUT #2, 1, header
+    PUT #2, , people[1]
+    CLOSE #2
+END SUB`
+
+	program := parse(input)
+	a := New()
+	_, errors := a.Analyze(program)
+
+	if len(errors) > 0 {
+		t.Fatalf("unexpected errors: %v", errors)
+	}
+}
+
+func TestAnalyzeRecordFileErrors(t *testing.T) {
+	types := "TYPE Note\nDIM Text AS STRING\nEND TYPE\n"
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"SUB Main()\nDIM n AS Note\nPUT #1, 1, n\nEND SUB", "PUT cannot store STRING: it has no fixed size"},
+		{"SUB Main()\nDIM xs AS []INTEGER\nGET #1, 1, xs\nEND SUB", "GET cannot store INTEGER(): it has no fixed size"},
+		{"SUB Main()\nGET #1, 1, 5\nEND SUB", "GET requires a variable, got 5"},
+		{"SUB Main()\nDIM x AS INTEGER\nGET #1, \"a\", x\nEND SUB", "GET position must be an integer, got STRING"},
+		{"SUB Main()\nOPEN \"a\" FOR BINARY AS #1 LEN = 8\nEND SUB", "LEN applies only to files opened FOR RANDOM"},
+	}
+
+	for _, tt := range tests {
+		program := parse(types + tt.input)
+		a := New()
+		_, errors := a.Analyze(program)
+
+		found := false
+		for _, err := range errors {
+			if strings.Contains(err, tt.expected) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			t.Errorf("expected error containing %q for %q, got %v", tt.expected, tt.input, errors)
+		}
+	}
+}
+
+func TestAnalyzeDirectoryFunctions(t *testing.T) {
+	input := `SUB Main()
+    DIM names AS []STRING = ListDir(".")
+    DIM logs AS []STRING = Glob("logs/*.txt")
+    DIM size AS LONG = FileSize("data.txt")
+    DIM changed AS DATETIME = FileModTime("data.txt")
+    IF IsDir("logs") AND size > 0 THEN
+        PRINT LEN(names) + LEN(logs); changed
+    END IF
+END SUB`
+
+	program := parse(input)
+	a := New()
+	_, errors := a.Analyze(program)
+
+	if len(errors) > 0 {
+		t.Fatalf("unexpected errors: %v", errors)
+	}
+
+	bad := parse("SUB Main()\nDIM s AS STRING = FileModTime(\"data.txt\")\nDIM b AS BOOLEAN = IsDir(1)\nEND SUB")
+	_, errors = New().Analyze(bad)
+	if len(errors) != 2 {
+		t.Errorf("expected 2 errors, got %v", errors)
+	}
+}
+
+func TestAnalyzeEnvironmentFunctions(t *testing.T) {
+	input := `SUB Main()
+    IF NOT EnvExists("APP_MODE") THEN SetEnv("APP_MODE", "dev")
+    DIM mode AS STRING = GetEnv("APP_MODE")
+    DIM env AS JSON = Environ()
+    PRINT mode; env.HOME
+END SUB`
+
+	program := parse(input)
+	a := New()
+	_, errors := a.Analyze(program)
+
+	if len(errors) > 0 {
+		t.Fatalf("unexpected errors: %v", errors)
+	}
+
+	bad := parse("SUB Main()\nDIM n AS INTEGER = GetEnv(\"N\")\nSetEnv(\"N\")\nEND SUB")
+	_, errors = New().Analyze(bad)
+	if len(errors) != 2 {
+		t.Errorf("expected 2 errors, got %v", errors)
+	}
+}
+
+func TestAnalyzeCommandLineFunctions(t *testing.T) {
+	input := `SUB Main()
+    DIM first$ AS STRING = Command$(1)
+    DIM count AS INTEGER = ArgCount()
+    DIM all AS []STRING = Args()
+    PRINT first$; count; LEN(all)
+END SUB`
+
+	program := parse(input)
+	a := New()
+	_, errors := a.Analyze(program)
+
+	if len(errors) > 0 {
+		t.Fatalf("unexpected errors: %v", errors)
+	}
+
+	bad := parse("SUB Main()\nDIM n AS INTEGER = Command$(1)\nPRINT Command$(\"1\")\nEND SUB")
+	_, errors = New().Analyze(bad)
+	if len(errors) != 2 {
+		t.Errorf("expected 2 errors, got %v", errors)
+	}
+}
+
+func TestAnalyzeYAMLFunctions(t *testing.T) {
+	input := `SUB Main()
+    DIM config AS JSON = YAMLParse(ReadFile("config.yaml"))
+    JSONSet(config, "server.port", 8080)
+    DIM text AS STRING = YAMLStringify(config)
+    PRINT config.name; text
+END SUB`
+
+	program := parse(input)
+	a := New()
+	_, errors := a.Analyze(program)
+
+	if len(errors) > 0 {
+		t.Fatalf("unexpected errors: %v", errors)
+	}
+
+	bad := parse("SUB Main()\nDIM n AS INTEGER = YAMLParse(\"a: 1\")\nPRINT YAMLStringify(\"a: 1\")\nEND SUB")
+	_, errors = New().Analyze(bad)
+	if len(errors) != 2 {
+		t.Errorf("expected 2 errors, got %v", errors)
+	}
+}
+
+func TestAnalyzeTOMLFunctions(t *testing.T) {
+	input := `SUB Main()
+    DIM project AS JSON = TOMLParse(ReadFile("project.toml"))
+    JSONSet(project, "build.output", "bin/app")
+    DIM text AS STRING = TOMLStringify(project)
+    PRINT project.name; text
+END SUB`
+
+	program := parse(input)
+	a := New()
+	_, errors := a.Analyze(program)
+
+	if len(errors) > 0 {
+		t.Fatalf("unexpected errors: %v", errors)
+	}
+
+	bad := parse("SUB Main()\nDIM s AS STRING = TOMLParse(\"a = 1\")\nPRINT TOMLStringify(1)\nEND SUB")
+	_, errors = New().Analyze(bad)
+	if len(errors) != 2 {
+		t.Errorf("expected 2 errors, got %v", errors)
+	}
+}
+
+func TestAnalyzeXMLFunctions(t *testing.T) {
+	input := `SUB Main()
+    DIM feed AS JSON = XMLParse(ReadFile("feed.xml"))
+    DIM title AS STRING = XMLGet(feed, "rss/channel/title")
+    DIM titles AS []STRING = XMLGetAll(feed, "rss/channel/item/title")
+    PRINT title; LEN(titles); XMLStringify(feed)
+END SUB`
+
+	program := parse(input)
+	a := New()
+	_, errors := a.Analyze(program)
+
+	if len(errors) > 0 {
+		t.Fatalf("unexpected errors: %v", errors)
+	}
+
+	bad := parse("SUB Main()\nPRINT XMLGet(\"<a/>\", \"a\")\nDIM s AS STRING = XMLGetAll(XMLParse(\"<a/>\"), \"a\")\nEND SUB")
+	_, errors = New().Analyze(bad)
+	if len(errors) != 2 {
+		t.Errorf("expected 2 errors, got %v", errors)
+	}
+}
+
+func TestAnalyzeINIFunctions(t *testing.T) {
+	input := `SUB Main()
+    DIM settings AS JSON = INILoad("app.ini")
+    DIM width AS STRING = INIGet(settings, "window", "width", "800")
+    JSONSet(settings, "window.width", 1024)
+    INISave("app.ini", settings)
+    PRINT width
+END SUB`
+
+	program := parse(input)
+	a := New()
+	_, errors := a.Analyze(program)
+
+	if len(errors) > 0 {
+		t.Fatalf("unexpected errors: %v", errors)
+	}
+
+	bad := parse("SUB Main()\nPRINT INIGet(\"window\", \"width\", \"800\")\nINISave(INILoad(\"a.ini\"), \"a.ini\")\nEND SUB")
+	_, errors = New().Analyze(bad)
+	if len(errors) < 2 {
+		t.Errorf("expected errors for INIGet and INISave, got %v", errors)
+	}
+}
+
+func TestAnalyzeCSVFunctions(t *testing.T) {
+	input := `SUB Main()
+    DIM rows AS [][]STRING = CSVRead("in.csv")
+    rows = APPEND(rows, CSVParse("a,b")[0])
+    CSVWrite("out.csv", rows)
+    DIM people AS []JSON = CSVReadRecords("people.csv")
+    FOR EACH p IN CSVParseRecords("name,age")
+        PRINT p.name
+    NEXT
+    PRINT rows[0][1]; LEN(people)
+END SUB`
+
+	program := parse(input)
+	a := New()
+	_, errors := a.Analyze(program)
+
+	if len(errors) > 0 {
+		t.Fatalf("unexpected errors: %v", errors)
+	}
+
+	bad := parse("SUB Main()\nDIM rows AS []STRING = CSVRead(\"in.csv\")\nCSVWrite(\"out.csv\", \"a,b\")\nEND SUB")
+	_, errors = New().Analyze(bad)
+	if len(errors) != 2 {
+		t.Errorf("expected 2 errors, got %v", errors)
+	}
+}
+
+func TestAnalyzeEncodingFunctions(t *testing.T) {
+	input := `SUB Main()
+    DIM raw AS BYTES = B"DB"
+    DIM token AS STRING = Base64Encode("user:secret")
+    DIM data AS BYTES = Base64Decode(token)
+    PRINT Decode(data); Base64Encode(raw); HexEncode(raw); HexEncode("hi")
+    data = HexDecode("4442")
+END SUB`
+
+	program := parse(input)
+	a := New()
+	_, errors := a.Analyze(program)
+
+	if len(errors) > 0 {
+		t.Fatalf("unexpected errors: %v", errors)
+	}
+
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"PRINT Base64Encode(42)", "Base64Encode requires a STRING or BYTES, got INTEGER"},
+		{"PRINT HexEncode(TRUE)", "HexEncode requires a STRING or BYTES, got BOOLEAN"},
+		{"DIM s AS STRING = Base64Decode(\"aGk=\")", "type mismatch"},
+		{"PRINT HexDecode(42)", "argument 1 type mismatch"},
+	}
+
+	for _, tt := range tests {
+		program := parse("SUB Main()\n" + tt.input + "\nEND SUB")
+		_, errors := New().Analyze(program)
+		found := false
+		for _, err := range errors {
+			if strings.Contains(err, tt.expected) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			t.Errorf("input %q: expected error containing %q, got %v", tt.input, tt.expected, errors)
+		}
+	}
+}
+
+func TestAnalyzeEncryptionFunctions(t *testing.T) {
+	input := `SUB Main()
+    DIM key AS BYTES = B"0123456789abcdef"
+    DIM sealed AS BYTES = AESEncrypt(key, "api-token-123")
+    DIM plain AS BYTES = AESDecrypt(key, sealed)
+    PRINT Decode(plain); Decode(AESDecrypt("passphrase", AESEncrypt("passphrase", B"data")))
+END SUB`
+
+	program := parse(input)
+	a := New()
+	_, errors := a.Analyze(program)
+
+	if len(errors) > 0 {
+		t.Fatalf("unexpected errors: %v", errors)
+	}
+
+	bad := parse("SUB Main()\nPRINT AESEncrypt(42, \"data\")\nDIM s AS STRING = AESDecrypt(\"key\", TRUE)\nEND SUB")
+	_, errors = New().Analyze(bad)
+	if len(errors) != 3 || !strings.Contains(errors[0], "AESEncrypt requires a STRING or BYTES, got INTEGER") {
+		t.Errorf("expected argument and type mismatch errors, got %v", errors)
+	}
+}
+
+func TestAnalyzeSliceHelpers(t *testing.T) {
+	input := `SUB Main()
+    DIM tags AS []STRING = ["go", "basic", "go"]
+    DIM scores(5) AS DOUBLE
+    DIM i AS INTEGER = IndexOf(tags, "basic")
+    DIM found AS BOOLEAN = Contains(scores, 1.5)
+    DIM rev AS []DOUBLE = Reverse(scores)
+    DIM once AS []STRING = Unique(tags)
+    PRINT i; found; rev; once
+END SUB`
+
+	program := parse(input)
+	a := New()
+	_, errors := a.Analyze(program)
+
+	if len(errors) > 0 {
+		t.Fatalf("unexpected errors: %v", errors)
+	}
+
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`PRINT IndexOf("abc", "b")`, "IndexOf requires a slice or array, got STRING"},
+		{`DIM tags AS []STRING = ["go"]
+PRINT Contains(tags, 1)`, "cannot search STRING() for INTEGER"},
+		{`DIM tags AS []STRING = ["go"]
+DIM n AS INTEGER = Unique(tags)`, "type mismatch"},
+		{`DIM tags AS []STRING = ["go"]
+PRINT Reverse(tags, 1)`, "wrong number of arguments: expected 1, got 2"},
+	}
+
+	for _, tt := range tests {
+		program := parse("SUB Main()\n" + tt.input + "\nEND SUB")
+		_, errors := New().Analyze(program)
+		found := false
+		for _, err := range errors {
+			if strings.Contains(err, tt.expected) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			t.Errorf("expected error containing %q for %q, got %v", tt.expected, tt.input, errors)
+		}
+	}
+}
+
+func TestAnalyzeCollectionFunctions(t *testing.T) {
+	input := `FUNCTION Half(n AS INTEGER) AS DOUBLE
+    RETURN Dbl(n) / 2
+END FUNCTION
+
+FUNCTION IsEven(n AS INTEGER) AS BOOLEAN
+    RETURN n MOD 2 = 0
+END FUNCTION
+
+FUNCTION Add(total AS LONG, n AS INTEGER) AS LONG
+    RETURN total + Lng(n)
+END FUNCTION
+
+SUB Main()
+    DIM nums AS []INTEGER = RANGE(1, 6)
+    DIM halves AS []DOUBLE = Map(nums, Half)
+    DIM evens AS []INTEGER = Filter(nums, IsEven)
+    DIM total AS LONG = Reduce(evens, Add, 0)
+    PRINT halves; total
+END SUB`
+
+	program := parse(input)
+	a := New()
+	_, errors := a.Analyze(program)
+
+	if len(errors) > 0 {
+		t.Fatalf("unexpected errors: %v", errors)
+	}
+
+	funcs := `FUNCTION Half(n AS INTEGER) AS DOUBLE
+    RETURN Dbl(n) / 2
+END FUNCTION
+
+FUNCTION Add(total AS LONG, n AS INTEGER) AS LONG
+    RETURN total + Lng(n)
+END FUNCTION
+
+`
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`DIM names AS []STRING = ["a"]
+PRINT Map(names, Half)`, "Map requires a FUNCTION(STRING), got FUNCTION(INTEGER) AS (DOUBLE)"},
+		{`DIM nums AS []INTEGER = [1]
+PRINT Filter(nums, Half)`, "Filter requires a FUNCTION(INTEGER) AS BOOLEAN"},
+		{`DIM nums AS []INTEGER = [1]
+PRINT Reduce(nums, Half, 0)`, "Reduce requires a FUNCTION(DOUBLE, INTEGER) AS DOUBLE"},
+		{`DIM nums AS []INTEGER = [1]
+PRINT Reduce(nums, Add, "0")`, "cannot use STRING as initial value of type LONG"},
+		{`DIM nums AS []INTEGER = [1]
+DIM s AS []STRING = Map(nums, Half)`, "type mismatch"},
+		{`PRINT Map(3, Half)`, "Map requires a slice or array, got INTEGER"},
+		{`DIM nums AS []INTEGER = [1]
+PRINT Map(nums, 3)`, "Map requires a FUNCTION with one result, got INTEGER"},
+	}
+
+	for _, tt := range tests {
+		program := parse(funcs + "SUB Main()\n" + tt.input + "\nEND SUB")
+		_, errors := New().Analyze(program)
+		found := false
+		for _, err := range errors {
+			if strings.Contains(err, tt.expected) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			t.Errorf("expected error containing %q for %q, got %v", tt.expected, tt.input, errors)
+		}
+	}
+}
+
+func TestAnalyzeContainerTypes(t *testing.T) {
+	input := `FUNCTION Add(a AS INTEGER, b AS INTEGER) AS INTEGER
+    RETURN a + b
+END FUNCTION
+
+SUB Main()
+    DIM undo AS STACK OF STRING
+    Push(undo, "edit")
+    DIM last AS STRING = Pop(undo) + Peek(undo)
+
+    DIM jobs AS QUEUE OF DOUBLE
+    Enqueue(jobs, 1)
+    DIM head AS DOUBLE = Dequeue(jobs)
+
+    DIM seen AS SET OF STRING
+    Add(seen, last)
+    Remove(seen, "x")
+    DIM found AS BOOLEAN = Has(seen, "edit")
+    FOR EACH i, word IN seen
+        PRINT i + Add(1, 2); word
+    NEXT
+    PRINT Len(undo) + Len(jobs) + Len(seen); head; found
+END SUB`
+
+	program := parse(input)
+	a := New()
+	_, errors := a.Analyze(program)
+
+	if len(errors) > 0 {
+		t.Fatalf("unexpected errors: %v", errors)
+	}
+
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`DIM q AS QUEUE OF INTEGER
+Push(q, 1)`, "Push cannot be used on QUEUE OF INTEGER"},
+		{`DIM s AS SET OF STRING
+PRINT Peek(s)`, "Peek cannot be used on SET OF STRING"},
+		{`DIM s AS STACK OF INTEGER
+Push(s, "x")`, "cannot use STRING as element of STACK OF INTEGER"},
+		{`DIM s AS STACK OF INTEGER
+DIM v AS STRING = Pop(s)`, "type mismatch"},
+		{`DIM s AS SET OF STRING
+Add(s)`, "wrong number of arguments: expected 2, got 1"},
+		{`DIM s AS SET OF []STRING`, "invalid set element type: STRING()"},
+	}
+
+	for _, tt := range tests {
+		program := parse("SUB Main()\n" + tt.input + "\nEND SUB")
+		_, errors := New().Analyze(program)
+		found := false
+		for _, err := range errors {
+			if strings.Contains(err, tt.expected) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			t.Errorf("expected error containing %q for %q, got %v", tt.expected, tt.input, errors)
+		}
+	}
+}
+
+func TestAnalyzeRoutinesHideBuiltins(t *testing.T) {
+	input := `DIM Done AS BOOLEAN
+
+FUNCTION PadRight(s AS STRING, width AS INTEGER, fill AS STRING) AS STRING
+    RETURN s + fill
+END FUNCTION
+
+FUNCTION Format(n AS INTEGER) AS STRING
+    RETURN Str(n)
+END FUNCTION
+
+SUB Text(s AS STRING)
+    PRINT s
+END SUB
+
+SUB Main()
+    Text(PadRight(Format(3), 5, "."))
+    PRINT PadLeft("x", 3)
+    Done = TRUE
+END SUB`
+
+	program := parse(input)
+	a := New()
+	_, errors := a.Analyze(program)
+
+	if len(errors) > 0 {
+		t.Fatalf("unexpected errors: %v", errors)
+	}
+}
+
+func TestAnalyzeDateFunctions(t *testing.T) {
+	input := `SUB Main()
+    DIM due AS DATETIME
+    DIM err AS ERROR
+    due, err = DateParse("2024-03-15", "yyyy-mm-dd")
+    DIM text AS STRING = DateFormat(DateAdd(due, "month", 1), "dddd d mmmm")
+    DIM years AS LONG = DateDiff(#1990-06-20#, due, "year")
+    DIM local AS DATETIME = ToLocal(ToUTC(due))
+    PRINT text; years; local; err; Year(due) + Month() + Day(due) + Hour() + Minute(due) + Second()
+END SUB`
+
+	program := parse(input)
+	a := New()
+	_, errors := a.Analyze(program)
+
+	if len(errors) > 0 {
+		t.Fatalf("unexpected errors: %v", errors)
+	}
+
+	bad := parse("SUB Main()\nPRINT Year(\"2024\")\nPRINT DateFormat(\"2024-03-15\", \"yyyy\")\nPRINT DateAdd(#2024-01-01#, 1, \"day\")\nEND SUB")
+	_, errors = New().Analyze(bad)
+	if len(errors) != 4 {
+		t.Errorf("expected 4 errors, got %v", errors)
+	}
+}
+
+func TestAnalyzeTimerChannels(t *testing.T) {
+	input := `SUB Main()
+    DIM tick AS CHAN OF LONG = Every(100)
+    DIM n AS LONG
+    RECEIVE n FROM tick
+    DIM done AS BOOLEAN
+    RECEIVE done FROM After(50)
+    PRINT n; done
+END SUB`
+
+	program := parse(input)
+	a := New()
+	_, errors := a.Analyze(program)
+
+	if len(errors) > 0 {
+		t.Fatalf("unexpected errors: %v", errors)
+	}
+
+	bad := parse("SUB Main()\nDIM c AS CHAN OF STRING = After(10)\nDIM d AS CHAN OF LONG = Every(\"1s\")\nEND SUB")
+	_, errors = New().Analyze(bad)
+	if len(errors) != 2 {
+		t.Errorf("expected 2 errors, got %v", errors)
+	}
+}
+
+func TestAnalyzeSoundFunctions(t *testing.T) {
+	input := `SUB Main()
+    Beep()
+    Tone(440, 200)
+    DIM err AS ERROR = PlayWav("ding.wav")
+    PRINT err
+END SUB`
+
+	program := parse(input)
+	a := New()
+	_, errors := a.Analyze(program)
+
+	if len(errors) > 0 {
+		t.Fatalf("unexpected errors: %v", errors)
+	}
+
+	bad := parse("SUB Main()\nTone(\"A\", 200)\nPlayWav(1)\nBeep(1)\nEND SUB")
+	_, errors = New().Analyze(bad)
+	if len(errors) != 3 {
+		t.Errorf("expected 3 errors, got %v", errors)
+	}
+}
+
+func TestAnalyzeGraphicsFunctions(t *testing.T) {
+	input := `SUB Main()
+    DIM orange AS INTEGER = RGB(255, 128, 0)
+    Screen(320, 200)
+    PSet(10, 10, 15)
+    Line(0, 0, 319, 199, 4)
+    Circle(160, 100, 50, orange)
+    Circle(160, 100, 20, 14, TRUE)
+    Rect(10, 10, 60, 40, 2)
+    Rect(10, 10, 60, 40, 2, TRUE)
+    Text(8, 180, "SCORE", 15)
+    Flip()
+END SUB`
+
+	program := parse(input)
+	a := New()
+	_, errors := a.Analyze(program)
+
+	if len(errors) > 0 {
+		t.Fatalf("unexpected errors: %v", errors)
+	}
+
+	bad := parse("SUB Main()\nScreen(320)\nCircle(1, 2, 3, 4, 5)\nText(1, 2, 3, 4)\nEND SUB")
+	_, errors = New().Analyze(bad)
+	if len(errors) != 3 {
+		t.Errorf("expected 3 errors, got %v", errors)
+	}
+}
+
+func TestAnalyzeImageFunctions(t *testing.T) {
+	input := `SUB Main()
+    DIM img AS IMAGE
+    DIM err AS ERROR
+    img, err = LoadImage("photo.png")
+    DIM thumb AS IMAGE = Resize(img, 160, 0)
+    SetPixel(thumb, 0, 0, GetPixel(img, 0, 0))
+    PRINT ImageWidth(thumb), ImageHeight(thumb), SaveImage(thumb, "thumb.png"), err
+    DrawImage(NewImage(8, 8), 0, 0)
+END SUB`
+
+	program := parse(input)
+	a := New()
+	_, errors := a.Analyze(program)
+
+	if len(errors) > 0 {
+		t.Fatalf("unexpected errors: %v", errors)
+	}
+
+	bad := parse("SUB Main()\nDIM img AS IMAGE = NewImage(8, 8)\nSetPixel(img, 0, 0)\nPRINT ImageWidth(\"a.png\")\nEND SUB")
+	_, errors = New().Analyze(bad)
+	if len(errors) != 2 {
+		t.Errorf("expected 2 errors, got %v", errors)
+	}
+}
+
+func TestAnalyzeTemplateFunctions(t *testing.T) {
+	input := `SUB Main()
+    DIM order AS JSON = {customer: "Ann"}
+    DIM text AS STRING = RenderTemplate("Hello {{.customer}}", order)
+    PRINT text; RenderTemplateFile("page.tmpl", order)
+END SUB`
+
+	program := parse(input)
+	a := New()
+	_, errors := a.Analyze(program)
+
+	if len(errors) > 0 {
+		t.Fatalf("unexpected errors: %v", errors)
+	}
+
+	bad := parse("SUB Main()\nPRINT RenderTemplate(1, 2)\nPRINT RenderTemplateFile(\"a.tmpl\")\nEND SUB")
+	_, errors = New().Analyze(bad)
+	if len(errors) != 2 {
+		t.Errorf("expected 2 errors, got %v", errors)
+	}
+}
+
+func TestAnalyzeUnicodeFunctions(t *testing.T) {
+	input := `SUB Main()
+    DIM s AS STRING = NormalizeNFC("héllo")
+    DIM codes AS []INTEGER = Runes(s)
+    PRINT RuneLen(s), RuneAt(s, 2), IsLetter(s), IsDigit(codes[0]), IsSpace(" ")
+END SUB`
+
+	program := parse(input)
+	a := New()
+	_, errors := a.Analyze(program)
+
+	if len(errors) > 0 {
+		t.Fatalf("unexpected errors: %v", errors)
+	}
+
+	bad := parse("SUB Main()\nPRINT IsLetter(1.5)\nPRINT IsDigit()\nPRINT RuneAt(\"a\")\nEND SUB")
+	_, errors = New().Analyze(bad)
+	if len(errors) != 3 {
+		t.Errorf("expected 3 errors, got %v", errors)
+	}
+}
+
+func TestAnalyzeTempFunctions(t *testing.T) {
+	input := `SUB Main()
+    DIM work AS STRING = TempDir("build-")
+    DIM f AS STRING = TempFile("report-*.csv")
+    DIM err AS ERROR = RemoveAll(work)
+    PRINT f, err
+END SUB`
+
+	program := parse(input)
+	a := New()
+	_, errors := a.Analyze(program)
+
+	if len(errors) > 0 {
+		t.Fatalf("unexpected errors: %v", errors)
+	}
+
+	bad := parse("SUB Main()\nDIM n AS INTEGER = TempFile(\"x\")\nRemoveAll()\nEND SUB")
+	_, errors = New().Analyze(bad)
+	if len(errors) != 2 {
+		t.Errorf("expected 2 errors, got %v", errors)
+	}
+}
+
+func TestAnalyzeWatchPath(t *testing.T) {
+	input := `SUB Main()
+    DIM changes AS CHAN OF STRING = WatchPath("src")
+    DIM change AS STRING
+    RECEIVE change FROM changes
+    PRINT change
+END SUB`
+
+	program := parse(input)
+	a := New()
+	_, errors := a.Analyze(program)
+
+	if len(errors) > 0 {
+		t.Fatalf("unexpected errors: %v", errors)
+	}
+
+	bad := parse("SUB Main()\nDIM c AS CHAN OF LONG = WatchPath(\"src\")\nEND SUB")
+	_, errors = New().Analyze(bad)
+	if len(errors) != 1 {
+		t.Errorf("expected 1 error, got %v", errors)
+	}
+}
+
+func TestAnalyzeReadLines(t *testing.T) {
+	input := `SUB Main()
+    DIM count AS INTEGER
+    FOR EACH line IN ReadLines("access.log")
+        IF Len(line) > 0 THEN
+            count = count + 1
+        END IF
+    NEXT
+    PRINT count
+END SUB`
+
+	program := parse(input)
+	a := New()
+	_, errors := a.Analyze(program)
+
+	if len(errors) > 0 {
+		t.Fatalf("unexpected errors: %v", errors)
+	}
+
+	bad := parse("SUB Main()\nDIM s AS STRING = ReadLines(\"a.log\")\nEND SUB")
+	_, errors = New().Analyze(bad)
+	if len(errors) != 1 {
+		t.Errorf("expected 1 error, got %v", errors)
+	}
+}
+
+func TestAnalyzeLocaleFunctions(t *testing.T) {
+	input := `SUB Main()
+    DIM price AS STRING = FormatCurrency(1234.5, "EUR", "de-DE")
+    DIM amount AS DOUBLE
+    DIM err AS ERROR
+    amount, err = ParseNumberLocale(price, "de-DE")
+    PRINT amount, err
+END SUB`
+
+	program := parse(input)
+	a := New()
+	_, errors := a.Analyze(program)
+
+	if len(errors) > 0 {
+		t.Fatalf("unexpected errors: %v", errors)
+	}
+
+	bad := parse("SUB Main()\nPRINT FormatCurrency(1, \"EUR\")\nPRINT ParseNumberLocale(1, \"en\")\nEND SUB")
+	_, errors = New().Analyze(bad)
+	if len(errors) != 2 {
+		t.Errorf("expected 2 errors, got %v", errors)
+	}
+}
+
+func TestAnalyzeReferences(t *testing.T) {
+	input := `DIM total AS INTEGER
+
+SUB Main()
+    DIM n AS INTEGER = 2
+    total = n + n
+END SUB`
+
+	program := parse(input)
+	a := New()
+	_, errors := a.Analyze(program)
+	if len(errors) > 0 {
+		t.Fatalf("unexpected errors: %v", errors)
+	}
+
+	counts := make(map[string]int)
+	for _, ref := range a.References() {
+		if ref.Symbol.Decl == nil {
+			t.Errorf("reference to %s has no declaration", ref.Symbol.Name)
+			continue
+		}
+		if ref.Ident.Value != ref.Symbol.Name {
+			t.Errorf("reference %s resolved to %s", ref.Ident.Value, ref.Symbol.Name)
+		}
+		counts[ref.Symbol.Name]++
+	}
+
+	// Each name is counted once where it is declared and once per use
+	expected := map[string]int{"total": 2, "Main": 1, "n": 3}
+	for name, want := range expected {
+		if counts[name] != want {
+			t.Errorf("expected %d references to %s, got %d", want, name, counts[name])
+		}
+	}
+}
+
+func TestAnalyzeTestBlocks(t *testing.T) {
+	input := `FUNCTION Add(a AS INTEGER, b AS INTEGER) AS INTEGER
+    RETURN a + b
+END FUNCTION
+
+TEST "adds numbers"
+    DIM total AS LONG = Add(1, 2)
+    AssertEqual(total, 3)
+    AssertEqual(Str(total), "3", "as text")
+    AssertTrue(total > 0)
+    AssertTrue(total < 10, "small")
+END TEST`
+
+	program := parse(input)
+	_, errors := New().Analyze(program)
+	if len(errors) > 0 {
+		t.Fatalf("unexpected errors: %v", errors)
+	}
+
+	bad := `TEST "one"
+    AssertEqual(1, "one")
+    AssertEqual(1)
+    AssertTrue(1)
+    AssertTrue(TRUE, "a", "b")
+    PRINT missing
+END TEST
+
+TEST "One"
+END TEST
+
+SUB Main()
+END SUB`
+
+	program = parse(bad)
+	_, errors = New().Analyze(program)
+	if len(errors) != 6 {
+		t.Errorf("expected 6 errors, got %d: %v", len(errors), errors)
+	}
+}
+
+func TestAnalyzeBenchmarkBlocks(t *testing.T) {
+	input := `FUNCTION Add(a AS INTEGER, b AS INTEGER) AS INTEGER
+    RETURN a + b
+END FUNCTION
+
+DIM total AS INTEGER
+
+BENCHMARK "adds numbers"
+    total = Add(total, 1)
+    AssertTrue(total > 0)
+END BENCHMARK
+
+TEST "adds numbers"
+    AssertEqual(Add(1, 2), 3)
+END TEST`
+
+	program := parse(input)
+	_, errors := New().Analyze(program)
+	if len(errors) > 0 {
+		t.Fatalf("unexpected errors: %v", errors)
+	}
+
+	bad := `BENCHMARK "one"
+    PRINT missing
+END BENCHMARK
+
+BENCHMARK "One"
+END BENCHMARK
+
+SUB Main()
+END SUB`
+
+	program = parse(bad)
+	_, errors = New().Analyze(program)
+	if len(errors) != 2 {
+		t.Errorf("expected 2 errors, got %d: %v", len(errors), errors)
+	}
+}
+
+func TestVet(t *testing.T) {
+	input := `FUNCTION Ready() AS BOOLEAN
+    RETURN TRUE
+END FUNCTION
+
+SUB Fill(BYREF s AS STRING)
+    s = "x"
+END SUB
+
+SUB Main()
+    DIM a AS INTEGER = 1
+    DIM done AS BOOLEAN
+    DIM total AS INTEGER
+    DIM filled AS STRING
+    DIM age AS INTEGER
+    IF done = a = 1 THEN
+        PRINT "same"
+    ELSEIF done = Ready() THEN
+        PRINT "ready"
+    END IF
+    IF done = FALSE THEN
+        PRINT total
+    END IF
+    Fill(filled)
+    PRINT filled, total
+    INPUT "Age? "; age
+    INPUT "Count? "; n AS INTEGER
+    PRINT n
+    SPAWN Ready()
+    SPAWN Fill(filled)
+END SUB`
+
+	a := New()
+	if _, errors := a.Analyze(parse(input)); len(errors) > 0 {
+		t.Fatalf("unexpected errors: %v", errors)
+	}
+	if len(a.Warnings()) != 0 {
+		t.Errorf("expected no warnings without vet, got %v", a.Warnings())
+	}
+
+	a = New()
+	a.SetVet(true)
+	if _, errors := a.Analyze(parse(input)); len(errors) > 0 {
+		t.Fatalf("unexpected errors: %v", errors)
+	}
+	expected := []struct {
+		line int
+		msg  string
+	}{
+		{15, "condition compares (done = a) with 1; = in a condition never assigns"},
+		{17, "condition compares done with Ready(); = in a condition never assigns"},
+		{21, "PRINT of total, which is never assigned"},
+		{28, "SPAWN discards the result of Ready"},
+	}
+	warnings := a.Warnings()
+	if len(warnings) != len(expected) {
+		t.Fatalf("expected %d warnings, got %d: %v", len(expected), len(warnings), warnings)
+	}
+	for i, exp := range expected {
+		if warnings[i].Line != exp.line || warnings[i].Message != exp.msg {
+			t.Errorf("warning %d: expected line %d %q, got line %d %q", i, exp.line, exp.msg, warnings[i].Line, warnings[i].Message)
+		}
+		if warnings[i].Hint == "" {
+			t.Errorf("warning %d has no hint", i)
+		}
+	}
+}
+
+func TestVetMaybeUnassigned(t *testing.T) {
+	input := `FUNCTION Grade(score AS INTEGER) AS STRING
+    DIM letter AS STRING
+    IF score >= 90 THEN
+        letter = "A"
+    ELSEIF score >= 80 THEN
+        letter = "B"
+    END IF
+    RETURN letter
+END FUNCTION
+
+FUNCTION Sign(n AS INTEGER) AS INTEGER
+    DIM s AS INTEGER
+    SELECT CASE n
+    CASE IS < 0
+        s = -1
+    CASE 0
+        RETURN 0
+    CASE ELSE
+        s = 1
+    END SELECT
+    RETURN s
+END FUNCTION
+
+SUB Main()
+    DIM total AS INTEGER
+    DIM found AS BOOLEAN
+    DIM last AS INTEGER
+    DIM name AS STRING
+    FOR i = 1 TO 10
+        total = total + i
+        IF i = last + 1 THEN found = TRUE
+        last = i
+    NEXT i
+    DO
+        INPUT "Name? "; name
+    LOOP UNTIL name <> ""
+    PRINT total, found, name, Grade(85), Sign(2)
+END SUB`
+
+	a := New()
+	a.SetVet(true)
+	if _, errors := a.Analyze(parse(input)); len(errors) > 0 {
+		t.Fatalf("unexpected errors: %v", errors)
+	}
+	expected := []struct {
+		line int
+		msg  string
+	}{
+		{8, "letter may be read before it is assigned"},
+		{31, "last may be read before it is assigned"},
+		{37, "found may be read before it is assigned"},
+	}
+	warnings := a.Warnings()
+	if len(warnings) != len(expected) {
+		t.Fatalf("expected %d warnings, got %d: %v", len(expected), len(warnings), warnings)
+	}
+	for i, exp := range expected {
+		if warnings[i].Line != exp.line || warnings[i].Message != exp.msg {
+			t.Errorf("warning %d: expected line %d %q, got line %d %q", i, exp.line, exp.msg, warnings[i].Line, warnings[i].Message)
+		}
+	}
+}
+
+func TestAnalyzeRandomFunctions(t *testing.T) {
+	input := `SUB Main()
+    DIM seed AS INTEGER = 42
+    Randomize()
+    Randomize(seed)
+    DIM roll AS INTEGER = RndRange(1, 6) + RndInt(6)
+    DIM token AS BYTES = RndBytes(16)
+    DIM pin AS INTEGER = RndSecureInt(10000)
+    PRINT Rnd(); roll; HexEncode(token); pin
+END SUB`
+
+	program := parse(input)
+	a := New()
+	_, errors := a.Analyze(program)
+
+	if len(errors) > 0 {
+		t.Fatalf("unexpected errors: %v", errors)
+	}
+
+	bad := parse("SUB Main()\nDIM s AS STRING = RndBytes(16)\nPRINT RndSecureInt(\"10\")\nEND SUB")
+	_, errors = New().Analyze(bad)
+	if len(errors) != 2 {
+		t.Errorf("expected 2 errors, got %v", errors)
+	}
+}
+
+func TestAnalyzeFormattingFunctions(t *testing.T) {
+	input := `SUB Main()
+    DIM total AS DOUBLE = 1234.5
+    DIM count AS INTEGER = 42
+    DIM line AS STRING = PadRight("Total", 10) + PadLeft(FormatNumber(total, 2, ","), 12)
+    PRINT line; Format(count, "000"); Format(0.25, "0%")
+END SUB`
+
+	program := parse(input)
+	a := New()
+	_, errors := a.Analyze(program)
+
+	if len(errors) > 0 {
+		t.Fatalf("unexpected errors: %v", errors)
+	}
+
+	bad := parse("SUB Main()\nPRINT PadLeft(\"a\", \"5\")\nPRINT Format(1, 2)\nPRINT FormatNumber(1, 2)\nEND SUB")
+	_, errors = New().Analyze(bad)
+	if len(errors) != 3 {
+		t.Errorf("expected 3 errors, got %v", errors)
+	}
+}
+
+func TestAnalyzeProcessFunctions(t *testing.T) {
+	input := `SUB Main()
+    DIM out AS STRING
+    DIM code AS INTEGER
+    DIM err AS ERROR
+    out, code, err = Shell("go version")
+    out, code, err = Exec("git", ["status", "--short"])
+    FOR EACH line IN ExecAsync("go", ["test", "./..."])
+        PRINT line
+    NEXT
+    PRINT out; code
+END SUB`
+
+	program := parse(input)
+	a := New()
+	_, errors := a.Analyze(program)
+
+	if len(errors) > 0 {
+		t.Fatalf("unexpected errors: %v", errors)
+	}
+
+	bad := parse("SUB Main()\nPRINT Shell(42)\nPRINT Exec(\"ls\", \"-l\")\nEND SUB")
+	_, errors = New().Analyze(bad)
+	if len(errors) != 2 {
+		t.Errorf("expected 2 errors, got %v", errors)
+	}
+}
+
+func TestAnalyzeTcpFunctions(t *testing.T) {
+	input := `SUB Serve(conn AS CONNECTION)
+    FOR EACH line IN TcpReceiveAsync(conn)
+        TcpSend(conn, line + Chr(10))
+    NEXT
+    TcpClose(conn)
+END SUB
+
+SUB Main()
+    DIM server AS LISTENER
+    DIM conn AS CONNECTION
+    DIM reply AS STRING
+    DIM err AS ERROR
+    server, err = TcpListen(":9000")
+    conn, err = TcpAccept(server)
+    SPAWN Serve(conn)
+    conn, err = TcpConnect("localhost:9000")
+    err = TcpSend(conn, "hi" + Chr(10))
+    reply, err = TcpReceive(conn)
+    TcpClose(conn)
+    TcpClose(server)
+END SUB`
+
+	program := parse(input)
+	a := New()
+	_, errors := a.Analyze(program)
+
+	if len(errors) > 0 {
+		t.Fatalf("unexpected errors: %v", errors)
+	}
+
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"DIM server AS LISTENER\nDIM n AS INTEGER\nn = TcpAccept(server)", "type mismatch"},
+		{"DIM server AS LISTENER\nPRINT TcpReceive(server)", "argument 1 type mismatch"},
+		{"TcpClose(\"localhost:9000\")", "TcpClose requires a CONNECTION or LISTENER, got STRING"},
+		{"DIM conn AS CONNECTION\nTcpClose(conn, conn)", "wrong number of arguments"},
+	}
+
+	for _, tt := range tests {
+		program := parse("SUB Main()\n" + tt.input + "\nEND SUB")
+		_, errors := New().Analyze(program)
+		found := false
+		for _, err := range errors {
+			if strings.Contains(err, tt.expected) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			t.Errorf("input %q: expected error containing %q, got %v", tt.input, tt.expected, errors)
+		}
+	}
+}
+
+func TestAnalyzeUdpFunctions(t *testing.T) {
+	input := `SUB Main()
+    DIM sock AS CONNECTION
+    DIM msg AS STRING
+    DIM sender AS STRING
+    DIM err AS ERROR
+    sock, err = UdpBind(":9999")
+    err = UdpSendTo(sock, "255.255.255.255:9999", "HELLO")
+    msg, sender, err = UdpReceiveFrom(sock)
+    UdpClose(sock)
+    PRINT msg; sender
+END SUB`
+
+	program := parse(input)
+	a := New()
+	_, errors := a.Analyze(program)
+
+	if len(errors) > 0 {
+		t.Fatalf("unexpected errors: %v", errors)
+	}
+
+	bad := parse("SUB Main()\nDIM l AS LISTENER\nUdpSendTo(l, \":9999\", \"x\")\nUdpSendTo(\":9999\", \"x\", \"y\")\nEND SUB")
+	_, errors = New().Analyze(bad)
+	if len(errors) != 2 {
+		t.Errorf("expected 2 errors, got %v", errors)
+	}
+}
+
+func TestAnalyzeWebSocketFunctions(t *testing.T) {
+	input := `SUB Chat(conn AS CONNECTION)
+    FOR EACH msg IN WsReceiveAsync(conn)
+        WsSend(conn, "you said: " + msg)
+    NEXT
+    WsClose(conn)
+END SUB
+
+SUB Main()
+    DIM server AS LISTENER
+    DIM conn AS CONNECTION
+    DIM reply AS STRING
+    DIM err AS ERROR
+    server, err = WsListen(":8080")
+    conn, err = WsAccept(server)
+    SPAWN Chat(conn)
+    conn, err = WsConnect("ws://localhost:8080/chat")
+    err = WsSend(conn, "hi")
+    reply, err = WsReceive(conn)
+    WsClose(conn)
+    WsClose(server)
+END SUB`
+
+	program := parse(input)
+	a := New()
+	_, errors := a.Analyze(program)
+
+	if len(errors) > 0 {
+		t.Fatalf("unexpected errors: %v", errors)
+	}
+
+	bad := parse("SUB Main()\nWsClose(\"ws://localhost\")\nDIM s AS STRING = WsConnect(\"ws://localhost\")\nEND SUB")
+	_, errors = New().Analyze(bad)
+	if len(errors) != 2 || !strings.Contains(errors[0], "WsClose requires a CONNECTION or LISTENER") {
+		t.Errorf("expected WsClose and type mismatch errors, got %v", errors)
+	}
+}
+
+func TestAnalyzeSendMail(t *testing.T) {
+	input := `SUB Main()
+    DIM err AS ERROR = SendMail("smtp.example.com", 587, "bot", "secret", "bot@example.com", "dev@example.com", "Build", "Passed")
+    IF err <> NIL THEN PRINT "mail failed"
+END SUB`
+
+	program := parse(input)
+	a := New()
+	_, errors := a.Analyze(program)
+
+	if len(errors) > 0 {
+		t.Fatalf("unexpected errors: %v", errors)
+	}
+
+	bad := parse("SUB Main()\nSendMail(\"smtp.example.com\", \"587\", \"\", \"\", \"a@b.c\", \"d@e.f\", \"s\", \"b\")\nSendMail(\"smtp.example.com\", 587, \"a@b.c\", \"d@e.f\")\nEND SUB")
+	_, errors = New().Analyze(bad)
+	if len(errors) != 2 {
+		t.Errorf("expected 2 errors, got %v", errors)
+	}
+}
+
+func TestAnalyzeDatabaseFunctions(t *testing.T) {
+	input := `SUB Main()
+    DIM db AS DATABASE
+    DIM tx AS TRANSACTION
+    DIM people AS []JSON
+    DIM person AS JSON
+    DIM n AS LONG
+    DIM err AS ERROR
+    db, err = DBOpen("sqlite", ":memory:")
+    n, err = DBExec(db, "CREATE TABLE people (name TEXT, age INTEGER)")
+    tx, err = DBBegin(db)
+    n, err = DBInsert(tx, "INSERT INTO people VALUES (?, ?)", "Ada", 36)
+    err = DBCommit(tx)
+    people, err = DBQuery(db, "SELECT * FROM people WHERE age > ?", 30)
+    person, err = DBQueryRow(db, "SELECT * FROM people")
+    PRINT LEN(DBQuery(db, "SELECT * FROM people")); person.name
+    DBClose(db)
+END SUB`
+
+	program := parse(input)
+	a := New()
+	_, errors := a.Analyze(program)
+
+	if len(errors) > 0 {
+		t.Fatalf("unexpected errors: %v", errors)
+	}
+
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"PRINT DBQuery(\"people.db\", \"SELECT 1\")", "DBQuery requires a DATABASE or TRANSACTION, got STRING"},
+		{"DIM db AS DATABASE\nPRINT DBQuery(db)", "wrong number of arguments"},
+		{"DIM db AS DATABASE\nPRINT DBCommit(db)", "argument 1 type mismatch"},
+		{"DIM db AS DATABASE\nDIM tx AS TRANSACTION = db", "type mismatch"},
+	}
+
+	for _, tt := range tests {
+		program := parse("SUB Main()\n" + tt.input + "\nEND SUB")
+		_, errors := New().Analyze(program)
+		found := false
+		for _, err := range errors {
+			if strings.Contains(err, tt.expected) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			t.Errorf("input %q: expected error containing %q, got %v", tt.input, tt.expected, errors)
+		}
+	}
+}
+
+func TestAnalyzeKVStoreFunctions(t *testing.T) {
+	input := `SUB Main()
+    DIM store AS KVSTORE
+    DIM err AS ERROR
+    store, err = KVOpen("app.kv")
+    IF NOT KVHas(store, "runs") THEN err = KVSet(store, "runs", "0")
+    err = KVDelete(store, "old")
+    FOR EACH key IN KVKeys(store)
+        PRINT key; KVGet(store, key)
+    NEXT
+END SUB`
+
+	program := parse(input)
+	a := New()
+	_, errors := a.Analyze(program)
+
+	if len(errors) > 0 {
+		t.Fatalf("unexpected errors: %v", errors)
+	}
+
+	bad := parse("SUB Main()\nPRINT KVGet(\"app.kv\", \"runs\")\nDIM store AS KVSTORE\nPRINT KVSet(store, \"runs\", 1)\nEND SUB")
+	_, errors = New().Analyze(bad)
+	if len(errors) != 2 {
+		t.Errorf("expected 2 errors, got %v", errors)
+	}
+}
+
+func TestAnalyzeFixedLengthString(t *testing.T) {
+	input := `TYPE Record
+    DIM Code AS STRING * 4
+END TYPE
+
+SUB Main()
+    DIM r AS Record
+    DIM name AS STRING * 10 = "Widget"
+    r.Code = "X1"
+    name = name + r.Code
+    PRINT Len(name)
+END SUB`
+
+	program := parse(input)
+	a := New()
+	_, errors := a.Analyze(program)
+
+	if len(errors) > 0 {
+		t.Fatalf("unexpected errors: %v", errors)
+	}
+}
+
+func TestAnalyzeFixedLengthStringErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"DIM a AS STRING * 0", "invalid width in STRING * 0"},
+		{"DIM n AS INTEGER = 3\nDIM b AS STRING * n", "invalid width in STRING * n"},
+		{"DIM c AS STRING * 4 = 5", "cannot assign INTEGER to STRING * 4"},
+	}
+
+	for _, tt := range tests {
+		program := parse(tt.input)
+		a := New()
+		_, errors := a.Analyze(program)
+
+		found := false
+		for _, err := range errors {
+			if strings.Contains(err, tt.expected) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			t.Errorf("expected error containing %q for %q, got %v", tt.expected, tt.input, errors)
+		}
+	}
+}
+
+func TestAnalyzeEmbeddedMembers(t *testing.T) {
+	input := `IMPORT "github.com/lxn/walk"
+
+INTERFACE Named
+    FUNCTION Describe() AS STRING
+END INTERFACE
+
+TYPE Entity
+    DIM ID AS INTEGER
+    PROPERTY GET Code() AS STRING
+        RETURN "E"
+    END PROPERTY
+END TYPE
+
+FUNCTION (e AS Entity) Describe() AS STRING
+    RETURN "entity"
+END FUNCTION
+
+TYPE Customer
+    EMBED Entity
+    DIM Name AS STRING
+END TYPE
+
+TYPE Vip IMPLEMENTS Named
+    EMBED Customer
+    EMBED walk.TableModelBase
+END TYPE
+
+SUB Main()
+    DIM v AS Vip
+    DIM p AS POINTER TO Vip = @v
+    v.ID = 7
+    v.Name = "Ann"
+    DIM id AS INTEGER = p.Customer.Entity.ID + v.ID
+    DIM code AS STRING = v.Code
+    DIM n AS Named = v
+    PRINT id; code; n.Describe(); v.PublishRowsReset
+END SUB`
+
+	program := parse(input)
+	a := New()
+	_, errors := a.Analyze(program)
+
+	if len(errors) > 0 {
+		t.Fatalf("unexpected errors: %v", errors)
+	}
+}
+
+func TestAnalyzeEmbeddedMemberErrors(t *testing.T) {
+	types := "TYPE Entity\nDIM ID AS INTEGER\nEND TYPE\nTYPE Customer\nEMBED Entity\nDIM Name AS STRING\nEND TYPE\n"
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"DIM c AS Customer\nPRINT c.Missing", "type Customer has no field Missing"},
+		{"DIM c AS Customer\nDIM s AS STRING = c.ID", "cannot assign INTEGER to STRING"},
+		{"INTERFACE Named\nFUNCTION Describe() AS STRING\nEND INTERFACE\nDIM c AS Customer\nDIM n AS Named = c", "missing method Describe"},
+	}
+
+	for _, tt := range tests {
+		program := parse(types + tt.input)
+		a := New()
+		_, errors := a.Analyze(program)
+
+		found := false
+		for _, err := range errors {
+			if strings.Contains(err, tt.expected) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			t.Errorf("expected error containing %q for %q, got %v", tt.expected, tt.input, errors)
+		}
+	}
+}
+
+func TestAnalyzeReceiverChanges(t *testing.T) {
+	input := `TYPE Counter
+    DIM N AS INTEGER
+    DIM Items AS []STRING
+END TYPE
+
+SUB (BYREF c AS Counter) Bump()
+    c.N = c.N + 1
+END SUB
+
+SUB (c AS POINTER TO Counter) Reset()
+    c.N = 0
+END SUB
+
+SUB (c AS Counter) Fill()
+    c.Items[0] = "shared"
+END SUB
+
+FUNCTION (c AS Counter) Bumped() AS Counter
+    c.N = c.N + 1
+    RETURN c
+END FUNCTION
+
+FUNCTION (c AS Counter) Advance() AS INTEGER
+    c.N = c.N + 1
+    c.N = c.N * 2
+    RETURN c.N
+END FUNCTION
+
+SUB Main()
+    DIM c AS Counter
+    c.Bump()
+    c = c.Bumped()
+    PRINT c.N; c.Advance()
+END SUB`
+
+	program := parse(input)
+	a := New()
+	_, errors := a.Analyze(program)
+
+	if len(errors) > 0 {
+		t.Fatalf("unexpected errors: %v", errors)
+	}
+}
+
+func TestAnalyzeReceiverChangeErrors(t *testing.T) {
+	types := "TYPE Counter\nDIM N AS INTEGER\nEND TYPE\n"
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"SUB (c AS Counter) Bump()\nc.N = c.N + 1\nEND SUB", "Bump changes a copy of its receiver c"},
+		{"FUNCTION (c AS Counter) Bump() AS INTEGER\nDIM old AS INTEGER = c.N\nc.N = c.N + 1\nc.N = c.N * 2\nRETURN old\nEND FUNCTION", "Bump changes a copy of its receiver c"},
+		{"SUB (BYREF c AS POINTER TO Counter) Bump()\nEND SUB", "BYREF receiver c is already a pointer"},
+	}
+
+	for _, tt := range tests {
+		program := parse(types + tt.input)
+		a := New()
+		_, errors := a.Analyze(program)
+
+		found := false
+		for _, err := range errors {
+			if strings.Contains(err, tt.expected) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			t.Errorf("expected error containing %q for %q, got %v", tt.expected, tt.input, errors)
+		}
+	}
+}
+
+func TestAnalyzeConstGroup(t *testing.T) {
+	input := `CONST
+    Idle
+    Running
+    Stopped = 10
+END CONST
+
+MODULE Color
+    CONST
+        Red
+        Green
+    END CONST
+END MODULE
+
+SUB Main()
+    CONST
+        Low = -1
+        High
+    END CONST
+    DIM state AS INTEGER = Running
+    SELECT CASE state
+        CASE Idle, Stopped
+            PRINT Color.Red
+        CASE Running
+            PRINT Low + High
+    END SELECT
+END SUB`
+
+	program := parse(input)
+	a := New()
+	_, errors := a.Analyze(program)
+
+	if len(errors) > 0 {
+		t.Fatalf("unexpected errors: %v", errors)
+	}
+}
+
+func TestAnalyzeConstGroupErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"CONST\nRed = \"red\"\nEND CONST", "CONST Red must be an integer, got STRING"},
+		{"CONST\nRed\nRed\nEND CONST", "symbol already defined: Red"},
+	}
+
+	for _, tt := range tests {
+		program := parse(tt.input)
+		a := New()
+		_, errors := a.Analyze(program)
+
+		found := false
+		for _, err := range errors {
+			if strings.Contains(err, tt.expected) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			t.Errorf("expected error containing %q for %q, got %v", tt.expected, tt.input, errors)
+		}
+	}
+}
+
+func TestAnalyzeConstantExpressions(t *testing.T) {
+	input := `CONST W AS INTEGER = 8
+CONST H AS INTEGER = W * 2
+CONST HALF AS DOUBLE = H / 4
+CONST GREETING AS STRING = "Hello, " + "world"
+CONST WIDE AS BOOLEAN = W > 4 AND NOT (H < 10)
+CONST KILO AS INTEGER = 2 ^ 10
+CONST
+    Idle
+    Running
+    Stopped = W + 2
+    Failed
+END CONST
+
+TYPE Record
+    DIM Code AS STRING * (W - 4)
+END TYPE
+
+DIM grid(W * H) AS INTEGER
+
+SUB Main()
+    DIM rows(Running TO H) AS INTEGER
+    DIM tag AS STRING * (Failed - Idle)
+    PRINT GREETING, HALF, WIDE, grid[0], rows[H], tag
+END SUB`
+
+	program := parse(input)
+	a := New()
+	symbols, errors := a.Analyze(program)
+
+	if len(errors) > 0 {
+		t.Fatalf("unexpected errors: %v", errors)
+	}
+
+	values := map[string]string{
+		"H":        "16",
+		"KILO":     "1024",
+		"HALF":     "4",
+		"GREETING": `"Hello, world"`,
+		"WIDE":     "true",
+		"Running":  "1",
+		"Failed":   "11",
+	}
+	for name, want := range values {
+		sym := symbols.Resolve(name)
+		if sym == nil || sym.Value == nil {
+			t.Errorf("expected a value for %s", name)
+			continue
+		}
+		if got := sym.Value.String(); got != want {
+			t.Errorf("%s: expected %s, got %s", name, want, got)
+		}
+	}
+
+	ts := program.Statements[7].(*parser.TypeStatement)
+	if width, ok := symbols.FixedStringWidth(ts.Fields[0].Type); !ok || width != 4 {
+		t.Errorf("expected width 4, got %d, %v", width, ok)
+	}
+	dim := program.Statements[8].(*parser.DimStatement)
+	if size, ok := symbols.IntConstant(dim.ArraySize); !ok || size != 128 {
+		t.Errorf("expected size 128, got %d, %v", size, ok)
+	}
+}
+
+func TestAnalyzeConstantExpressionErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"CONST N AS INTEGER = 4\nCONST BAD AS INTEGER = N / (N - 4)", "division by zero in (N / (N - 4))"},
+		{"DIM x AS DOUBLE = 2.5\nPRINT x / 0", "division by zero in (x / 0)"},
+		{"CONST ZERO AS INTEGER = 0\nDIM x AS INTEGER = 7\nPRINT x \\ ZERO", "division by zero in (x \\ ZERO)"},
+		{"DIM x AS INTEGER = 7\nPRINT x MOD (3 - 3)", "division by zero in (x MOD (3 - 3))"},
+		{"CONST N AS INTEGER = 4\nDIM a(N - 10) AS INTEGER", "negative array size in DIM a"},
+		{"CONST N AS INTEGER = 4\nDIM a(N TO 2) AS INTEGER", "negative array size in DIM a"},
+		{"CONST N AS INTEGER = 4\nDIM s AS STRING * (N - 4)", "invalid width in STRING * (N - 4)"},
+		{"CONST N AS DOUBLE = 4\nDIM s AS STRING * N", "invalid width in STRING * N"},
+		{"FUNCTION Two() AS INTEGER\nRETURN 2\nEND FUNCTION\nCONST A AS INTEGER = Two() * 2", "CONST A is not a constant: Two()"},
+		{"DIM g AS DOUBLE = 3\nCONST C AS DOUBLE = g ^ 2", "CONST C is not a constant: g"},
+		{"CONST\nP = 2 ^ 0.5\nEND CONST", "CONST P must be an integer"},
+		{"CONST B AS INTEGER = 2 ^ 0.5", "CONST B AS INTEGER cannot hold 1.41421"},
+	}
+
+	for _, tt := range tests {
+		program := parse(tt.input)
+		a := New()
+		_, errors := a.Analyze(program)
+
+		found := false
+		for _, err := range errors {
+			if strings.Contains(err, tt.expected) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			t.Errorf("expected error containing %q for %q, got %v", tt.expected, tt.input, errors)
+		}
+	}
+}
+
+func TestAnalyzeGosub(t *testing.T) {
+	input := `FUNCTION Total(n AS INTEGER) AS INTEGER
+    ON ERROR GOTO Failed
+    DIM sum AS INTEGER
+    DIM i AS INTEGER
+    FOR i = 1 TO n
+        IF i MOD 2 = 0 THEN
+            GOSUB AddTwo
+        ELSE
+            GOSUB AddOne
+        END IF
+    NEXT i
+    RETURN sum
+AddTwo:
+    sum = sum + 1
+AddOne:
+    sum = sum + 1
+    IF sum > 100 THEN
+        GOTO Done
+    END IF
+    sum = sum + 0
+Done:
+    RETURN
+Failed:
+    RETURN -1
+END FUNCTION`
+
+	program := parse(input)
+	a := New()
+	symbols, errors := a.Analyze(program)
+
+	if len(errors) > 0 {
+		t.Fatalf("unexpected errors: %v", errors)
+	}
+
+	fn := program.Statements[0].(*parser.FunctionStatement)
+	subs := symbols.Subroutines(fn.Body)
+	if len(subs) != 2 {
+		t.Fatalf("expected 2 subroutines, got %d", len(subs))
+	}
+	if subs[0].Label != "AddTwo" || subs[1].Label != "AddOne" || subs[0].End != subs[1].End {
+		t.Errorf("expected AddTwo and AddOne to share a RETURN, got %+v %+v", subs[0], subs[1])
+	}
+}
+
+func TestAnalyzeGosubErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"GOSUB Greet\nGreet:\nRETURN", "GOSUB is only allowed inside a SUB, FUNCTION or METHOD"},
+		{"SUB S()\nGOSUB Greet\nEND SUB", "GOSUB Greet: no label Greet at the top level of this routine"},
+		{"SUB S()\nGOSUB Greet\nEXIT SUB\nGreet:\nPRINT 1\nEND SUB", "GOSUB Greet: the subroutine has no RETURN at the top level"},
+		{"SUB S()\nGOSUB Greet\nEXIT SUB\nGreet:\nEXIT SUB\nRETURN\nEND SUB", "EXIT SUB cannot leave the GOSUB subroutine Greet"},
+		{"FUNCTION F() AS INTEGER\nGOSUB Greet\nRETURN 1\nGreet:\nRETURN 2\nRETURN\nEND FUNCTION", "RETURN with a value cannot leave the GOSUB subroutine Greet"},
+		{"SUB S()\nGOSUB Greet\nGOTO Greet\nGreet:\nRETURN\nEND SUB", "GOTO Greet jumps into the GOSUB subroutine Greet"},
+		{"SUB S()\nGOSUB Greet\nDone:\nEXIT SUB\nGreet:\nGOTO Done\nRETURN\nEND SUB", "GOTO Done leaves the GOSUB subroutine Greet"},
+		{"SUB S()\nON ERROR GOTO Oops\nGOSUB Greet\nEXIT SUB\nGreet:\nOops:\nRETURN\nEND SUB", "the ON ERROR handler cannot be inside the GOSUB subroutine Greet"},
+	}
+
+	for _, tt := range tests {
+		program := parse(tt.input)
+		a := New()
+		_, errors := a.Analyze(program)
+
+		found := false
+		for _, err := range errors {
+			if strings.Contains(err, tt.expected) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			t.Errorf("expected error containing %q for %q, got %v", tt.expected, tt.input, errors)
+		}
+	}
+}
+
+func TestAnalyzeOnErrorErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"ON ERROR GOTO Oops\nOops:", "ON ERROR is only allowed inside a SUB, FUNCTION or METHOD"},
+		{"SUB S()\nON ERROR GOTO Oops\nEND SUB", "no label Oops at the top level of this routine"},
+		{"SUB S()\nON ERROR GOTO Oops\nIF TRUE THEN\nOops:\nEND IF\nEND SUB", "no label Oops at the top level of this routine"},
+		{"SUB S()\nIF TRUE THEN\nON ERROR GOTO Oops\nEND IF\nOops:\nEND SUB", "ON ERROR GOTO Oops must be at the top level of its routine"},
+		{"SUB S()\nON ERROR GOTO A\nON ERROR GOTO B\nA:\nB:\nEND SUB", "only one ON ERROR GOTO handler, and this one uses A"},
+		{"SUB S()\nON ERROR GOTO Oops\nGOTO Oops\nOops:\nEND SUB", "GOTO Oops jumps to the ON ERROR handler"},
+		{"SUB S()\nON ERROR GOTO Oops\nretry:\nPRINT 1\nOops:\nGOTO retry\nEND SUB", "GOTO retry crosses the ON ERROR handler label Oops"},
+		{"SUB S()\nERROR \"bad\"\nEND SUB", "ERROR requires an error number, got STRING"},
+		{"DIM n AS INTEGER\nASSERT n", "ASSERT condition must be boolean, got INTEGER"},
+		{"DIM n AS INTEGER\nASSERT n > 0, n", "ASSERT message must be a STRING, got INTEGER"},
+	}
+
+	for _, tt := range tests {
+		program := parse(tt.input)
+		a := New()
+		_, errors := a.Analyze(program)
+
+		found := false
+		for _, err := range errors {
+			if strings.Contains(err, tt.expected) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			t.Errorf("%q: expected error containing %q, got %v", tt.input, tt.expected, errors)
+		}
+	}
+}
+
+func TestAnalyzePointerType(t *testing.T) {
+	input := `DIM x AS INTEGER = 42
+DIM ptr AS POINTER TO INTEGER`
+
+	program := parse(input)
+	a := New()
+	symbols, errors := a.Analyze(program)
+
+	if len(errors) > 0 {
+		t.Errorf("unexpected errors: %v", errors)
+	}
+
+	sym := symbols.Resolve("ptr")
+	if sym == nil {
+		t.Fatal("expected 'ptr' to be defined")
+	}
+
+	if sym.Type.Kind != TypePointer {
+		t.Errorf("expected pointer type, got %s", sym.Type.String())
+	}
+}
+
+func TestAnalyzeImport(t *testing.T) {
+	input := `IMPORT "fmt"
+IMPORT "net/http" AS http`
+
+	program := parse(input)
+	a := New()
+	symbols, errors := a.Analyze(program)
+
+	if len(errors) > 0 {
+		t.Errorf("unexpected errors: %v", errors)
+	}
+
+	// Check that imports are registered
+	if symbols.GetImport("fmt") == nil {
+		t.Error("expected 'fmt' import to be registered")
+	}
+
+	if symbols.GetImport("http") == nil {
+		t.Error("expected 'http' import alias to be registered")
+	}
+}
+
+func TestAnalyzeMultipleReturnValues(t *testing.T) {
+	input := `FUNCTION Divide(a AS INTEGER, b AS INTEGER) AS (INTEGER, BOOLEAN)
+    IF b = 0 THEN
+        RETURN 0, FALSE
+    ENDIF
+    RETURN a / b, TRUE
+END FUNCTION
+
+SUB Main()
+    DIM result AS INTEGER
+    DIM ok AS BOOLEAN
+    result, ok = Divide(10, 2)
+END SUB`
+
+	program := parse(input)
+	a := New()
+	_, errors := a.Analyze(program)
+
+	if len(errors) > 0 {
+		t.Errorf("unexpected errors: %v", errors)
+	}
+}
+
+func TestAnalyzeArithmeticOperators(t *testing.T) {
+	input := `SUB Main()
+    DIM a AS INTEGER = 5 + 3
+    DIM b AS INTEGER = 10 - 2
+    DIM c AS INTEGER = 4 * 2
+    DIM d AS INTEGER = 8 / 2
+    DIM e AS INTEGER = 10 MOD 3
+    DIM f AS DOUBLE = 2 ^ 3
+END SUB`
+
+	program := parse(input)
+	a := New()
+	_, errors := a.Analyze(program)
+
+	if len(errors) > 0 {
+		t.Errorf("unexpected errors: %v", errors)
+	}
+}
+
+func TestAnalyzeStringConcatenation(t *testing.T) {
+	input := `SUB Main()
+    DIM s AS STRING = "Hello" & " " & "World"
+    DIM t AS STRING = "A" + "B"
+END SUB`
+
+	program := parse(input)
+	a := New()
+	_, errors := a.Analyze(program)
+
+	if len(errors) > 0 {
+		t.Errorf("unexpected errors: %v", errors)
+	}
+}
+
+func TestAnalyzeLogicalOperators(t *testing.T) {
+	input := `SUB Main()
+    DIM a AS BOOLEAN = TRUE AND FALSE
+    DIM b AS BOOLEAN = TRUE OR FALSE
+    DIM c AS BOOLEAN = NOT TRUE
+    DIM d AS BOOLEAN = TRUE XOR FALSE
+END SUB`
+
+	program := parse(input)
+	a := New()
+	_, errors := a.Analyze(program)
+
+	if len(errors) > 0 {
+		t.Errorf("unexpected errors: %v", errors)
+	}
+}
+
+func TestAnalyzeComparisonOperators(t *testing.T) {
+	input := `SUB Main()
+    DIM a AS BOOLEAN = 5 > 3
+    DIM b AS BOOLEAN = 5 < 10
+    DIM c AS BOOLEAN = 5 >= 5
+    DIM d AS BOOLEAN = 5 <= 5
+    DIM e AS BOOLEAN = 5 = 5
+    DIM f AS BOOLEAN = 5 <> 3
+END SUB`
+
+	program := parse(input)
+	a := New()
+	_, errors := a.Analyze(program)
+
+	if len(errors) > 0 {
+		t.Errorf("unexpected errors: %v", errors)
+	}
+}
+
+func TestAnalyzeArrayType(t *testing.T) {
+	input := `DIM arr(10) AS INTEGER`
+
+	program := parse(input)
+	a := New()
+	symbols, errors := a.Analyze(program)
+
+	if len(errors) > 0 {
+		t.Errorf("unexpected errors: %v", errors)
+	}
+
+	sym := symbols.Resolve("arr")
+	if sym == nil {
+		t.Fatal("expected 'arr' to be defined")
+	}
+}
+
+func TestAnalyzeArrayBounds(t *testing.T) {
+	input := `DIM scores(1 TO 10) AS INTEGER
+
+SUB Main()
+    scores[10] = 5
+END SUB`
+
+	program := parse(input)
+	a := New()
+	symbols, errors := a.Analyze(program)
+
+	if len(errors) > 0 {
+		t.Fatalf("unexpected errors: %v", errors)
+	}
+
+	sym := symbols.Resolve("scores")
+	if sym == nil {
+		t.Fatal("expected 'scores' to be defined")
+	}
+	if sym.Type.Kind != TypeSlice || sym.Type.ElementType.Kind != TypeInteger {
+		t.Errorf("expected INTEGER(), got %s", sym.Type.String())
+	}
+	if sym.Type.LowerBound != 1 {
+		t.Errorf("expected lower bound 1, got %d", sym.Type.LowerBound)
+	}
+}
+
+func TestAnalyzeArrayBoundsErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"DIM n AS INTEGER = 1\nDIM a(n TO 10) AS INTEGER", "array lower bound must be an integer constant"},
+		{`DIM a("x") AS INTEGER`, "array size must be integer"},
+		{`DIM a(1 TO 2.5) AS INTEGER`, "array size must be integer"},
+	}
+
+	for _, tt := range tests {
+		program := parse(tt.input)
+		a := New()
+		_, errors := a.Analyze(program)
+
+		found := false
+		for _, err := range errors {
+			if strings.Contains(err, tt.expected) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			t.Errorf("%q: expected error containing %q, got %v", tt.input, tt.expected, errors)
+		}
+	}
+}
+
+func TestAnalyzeStaticErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"STATIC count AS INTEGER", "STATIC can only be used inside a SUB, FUNCTION or METHOD"},
+		{`SUB Visit()
+    STATIC count AS INTEGER
+    count = count + 1
+END SUB
+
+SUB Main()
+    PRINT count
+END SUB`, "undefined: count"},
+		{`SUB Visit()
+    STATIC count AS INTEGER = "x"
+END SUB`, "type mismatch"},
+	}
+
+	for _, tt := range tests {
+		program := parse(tt.input)
+		a := New()
+		_, errors := a.Analyze(program)
+
+		found := false
+		for _, err := range errors {
+			if strings.Contains(err, tt.expected) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			t.Errorf("%q: expected error containing %q, got %v", tt.input, tt.expected, errors)
+		}
+	}
+}
+
+func TestAnalyzeModule(t *testing.T) {
+	input := `MODULE Math
+    CONST PI AS DOUBLE = 3.14159
+    DIM calls AS INTEGER
+
+    FUNCTION Area(r AS DOUBLE) AS DOUBLE
+        calls = calls + 1
+        RETURN PI * Square(r)
+    END FUNCTION
+
+    FUNCTION Square(x AS DOUBLE) AS DOUBLE
+        RETURN x * x
+    END FUNCTION
+END MODULE
+
+MODULE Text
+    FUNCTION Square(s AS STRING) AS STRING
+        RETURN "[" & s & "]"
+    END FUNCTION
+END MODULE
+
+SUB Main()
+    DIM a AS DOUBLE = Math.Area(2.0)
+    DIM s AS STRING = Text.Square("x")
+    Math.calls = 0
+    PRINT a, s, Math.PI
+END SUB`
+
+	program := parse(input)
+	a := New()
+	symbols, errors := a.Analyze(program)
+
+	if len(errors) > 0 {
+		t.Fatalf("unexpected errors: %v", errors)
+	}
+
+	mod := symbols.Resolve("Math")
+	if mod == nil || mod.Kind != SymModule {
+		t.Fatal("expected 'Math' to be a module")
+	}
+	if symbols.Resolve("Area") != nil {
+		t.Error("module members should not be defined globally")
+	}
+
+	area := mod.Members.ResolveLocal("Area")
+	if area == nil {
+		t.Fatal("expected 'Area' in module Math")
+	}
+	if area.GoName != "Math_Area" {
+		t.Errorf("expected Go name Math_Area, got %q", area.GoName)
+	}
+}
+
+func TestAnalyzeModuleErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`MODULE Math
+    FUNCTION Square(x AS DOUBLE) AS DOUBLE
+        RETURN x * x
+    END FUNCTION
+END MODULE
+PRINT Math.Cube(2.0)`, "module Math has no member Cube"},
+		{`MODULE Math
+    FUNCTION Square(x AS DOUBLE) AS DOUBLE
+        RETURN x * x
+    END FUNCTION
+END MODULE
+PRINT Square(2.0)`, "undefined function: Square"},
+		{`MODULE Math
+    FUNCTION Square(x AS DOUBLE) AS DOUBLE
+        RETURN x * x
+    END FUNCTION
+END MODULE
+DIM s AS STRING = Math.Square(2.0)`, "type mismatch"},
+		{`MODULE Math
+    DIM calls AS INTEGER
+END MODULE
+LET m = Math`, "module Math cannot be used as a value"},
+		{`MODULE Math
+    FUNCTION Clamp(x AS INTEGER) AS INTEGER
+        RETURN x
+    END FUNCTION
+END MODULE
+FUNCTION Math_Clamp(x AS INTEGER) AS INTEGER
+    RETURN x
+END FUNCTION`, "duplicate Go name Math_Clamp for Math.Clamp and Math_Clamp"},
+		{`MODULE A_B
+    DIM C AS INTEGER
+END MODULE
+MODULE A
+    DIM B_C AS INTEGER
+END MODULE`, "duplicate Go name A_B_C for A.B_C and A_B.C"},
+	}
+
+	for _, tt := range tests {
+		program := parse(tt.input)
+		a := New()
+		_, errors := a.Analyze(program)
+
+		found := false
+		for _, err := range errors {
+			if strings.Contains(err, tt.expected) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			t.Errorf("%q: expected error containing %q, got %v", tt.input, tt.expected, errors)
+		}
+	}
+}
+
+func TestAnalyzeConstructor(t *testing.T) {
+	input := `TYPE Person
+    DIM Name AS STRING
+    DIM Age AS INTEGER
+
+    SUB New(name AS STRING, age AS INTEGER)
+        .Name = name
+        .Age = age
+    END SUB
+END TYPE
+
+SUB Main()
+    DIM p AS Person = NEW Person("Ann", 30)
+    PRINT NEW Person("Bob", 40).Age
+END SUB`
+
+	program := parse(input)
+	a := New()
+	symbols, errors := a.Analyze(program)
+
+	if len(errors) > 0 {
+		t.Fatalf("unexpected errors: %v", errors)
+	}
+
+	ctor := symbols.Resolve("Person.New")
+	if ctor == nil {
+		t.Fatal("expected constructor Person.New to be defined")
+	}
+	if len(ctor.Type.ParamTypes) != 2 || ctor.Type.ReturnTypes[0].Name != "Person" {
+		t.Errorf("unexpected constructor type %s", ctor.Type.String())
+	}
+}
+
+func TestAnalyzeConstructorErrors(t *testing.T) {
+	typeDef := `TYPE Person
+    DIM Name AS STRING
+
+    SUB New(name AS STRING)
+        .Name = name
+        .Age = 1
+    END SUB
+END TYPE
+
+TYPE Point
+    DIM X AS INTEGER
+END TYPE
+`
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{typeDef, "type Person has no field Age"},
+		{typeDef + `DIM p AS Person = NEW Person()`, "wrong number of arguments: expected 1, got 0"},
+		{typeDef + `DIM p AS Person = NEW Person(42)`, "argument 1 type mismatch"},
+		{typeDef + `DIM p AS Point = NEW Point(1)`, "type Point has no constructor"},
+		{typeDef + `DIM p AS Point = NEW Shape(1)`, "NEW requires a TYPE name, got Shape"},
+		{typeDef + `DIM n AS INTEGER = NEW Person("Ann")`, "type mismatch"},
+	}
+
+	for _, tt := range tests {
+		program := parse(tt.input)
+		a := New()
+		_, errors := a.Analyze(program)
+
+		found := false
+		for _, err := range errors {
+			if strings.Contains(err, tt.expected) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			t.Errorf("%q: expected error containing %q, got %v", tt.input, tt.expected, errors)
+		}
+	}
+}
+
+func TestAnalyzeProperties(t *testing.T) {
+	input := `TYPE Person
+    DIM First AS STRING
+    DIM Last AS STRING
+
+    PROPERTY GET FullName() AS STRING
+        RETURN .First & " " & .Last
+    END PROPERTY
+
+    PROPERTY SET FullName(value AS STRING)
+        .First = value
+    END PROPERTY
+END TYPE
+
+SUB Rename(p AS POINTER TO Person)
+    p.FullName = "Grace"
+END SUB
+
+SUB Main()
+    DIM p AS Person
+    p.FullName = "Ada"
+    DIM name AS STRING = p.FullName
+    WITH p
+        PRINT .FullName
+    END WITH
+END SUB`
+
+	program := parse(input)
+	a := New()
+	_, errors := a.Analyze(program)
+
+	if len(errors) > 0 {
+		t.Fatalf("unexpected errors: %v", errors)
+	}
+
+	prop := a.types.Lookup("Person").Property("fullname")
+	if prop == nil {
+		t.Fatal("expected property FullName on Person")
+	}
+	if !prop.HasGet || !prop.HasSet || prop.Type.Kind != TypeString {
+		t.Errorf("unexpected property %+v", prop)
+	}
+}
+
+func TestAnalyzePropertyErrors(t *testing.T) {
+	typeDef := `TYPE Person
+    DIM Age AS INTEGER
+
+    PROPERTY GET IsAdult() AS BOOLEAN
+        RETURN .Age >= 18
+    END PROPERTY
+
+    PROPERTY SET Years(v AS INTEGER)
+        .Age = v
+    END PROPERTY
+END TYPE
+`
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{typeDef + "DIM p AS Person\nSUB Main()\np.IsAdult = TRUE\nEND SUB", "property IsAdult of type Person is read-only"},
+		{typeDef + "DIM p AS Person\nSUB Main()\nPRINT p.Years\nEND SUB", "property Years of type Person is write-only"},
+		{typeDef + "DIM p AS Person\nSUB Main()\np.Years = \"ten\"\nEND SUB", "type mismatch in assignment"},
+		{"TYPE T\nDIM X AS INTEGER\nPROPERTY GET X() AS INTEGER\nRETURN 1\nEND PROPERTY\nEND TYPE", "property X conflicts with field X of type T"},
+		{"TYPE T\nPROPERTY GET X() AS INTEGER\nRETURN 1\nEND PROPERTY\nPROPERTY GET X() AS INTEGER\nRETURN 2\nEND PROPERTY\nEND TYPE", "duplicate PROPERTY GET X in TYPE T"},
+		{"TYPE T\nPROPERTY GET X() AS INTEGER\nRETURN 1\nEND PROPERTY\nPROPERTY SET X(v AS STRING)\nEND PROPERTY\nEND TYPE", "PROPERTY SET X uses type STRING, but the other accessor uses INTEGER"},
+		{"TYPE T\nPROPERTY GET X() AS INTEGER\nRETURN .Y\nEND PROPERTY\nEND TYPE", "type T has no field Y"},
+	}
+
+	for _, tt := range tests {
+		program := parse(tt.input)
+		a := New()
+		_, errors := a.Analyze(program)
+
+		found := false
+		for _, err := range errors {
+			if strings.Contains(err, tt.expected) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			t.Errorf("%q: expected error containing %q, got %v", tt.input, tt.expected, errors)
+		}
+	}
+}
+
+func TestAnalyzeJSONType(t *testing.T) {
+	input := `DIM data AS JSON = {"name": "John", "age": 30}
+
+SUB Main()
+    PRINT data.name
+END SUB`
+
+	program := parse(input)
+	a := New()
+	_, errors := a.Analyze(program)
+
+	if len(errors) > 0 {
+		t.Errorf("unexpected errors: %v", errors)
+	}
+}
+
+func TestAnalyzeCoalesce(t *testing.T) {
+	input := `DIM data AS JSON = {"name": "John"}
+
+SUB Main()
+    DIM name AS STRING = data.name ?? "anonymous"
+    DIM age AS INTEGER = IFNULL(data.age, 0)
+    DIM total AS DOUBLE
+    total = total ?? age + 1
+END SUB`
+
+	program := parse(input)
+	a := New()
+	_, errors := a.Analyze(program)
+
+	if len(errors) > 0 {
+		t.Errorf("unexpected errors: %v", errors)
+	}
+}
+
+func TestAnalyzeCoalesceErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`SUB Main()
+    DIM count AS INTEGER
+    PRINT count ?? "none"
+END SUB`, "cannot use STRING as fallback for INTEGER"},
+		{`SUB Main()
+    DIM name AS STRING
+    DIM n AS INTEGER = IFNULL(name, "x")
+END SUB`, "type mismatch"},
+		{`SUB Main()
+    PRINT IFNULL(1)
+END SUB`, "wrong number of arguments: expected 2, got 1"},
+	}
+
+	for _, tt := range tests {
+		program := parse(tt.input)
+		a := New()
+		_, errors := a.Analyze(program)
+
+		found := false
+		for _, e := range errors {
+			if strings.Contains(e, tt.expected) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			t.Errorf("input %q: expected error containing %q, got: %v", tt.input, tt.expected, errors)
+		}
+	}
+}
+
+func TestAnalyzeInterpolatedString(t *testing.T) {
+	input := `SUB Main()
+    DIM name AS STRING = "Ann"
+    DIM greeting AS STRING = $"Hello {name}, {missing}"
+END SUB`
+
+	program := parse(input)
+	a := New()
+	_, errors := a.Analyze(program)
+
+	if len(errors) != 1 || !strings.Contains(errors[0], "undefined: missing") {
+		t.Errorf("expected only an undefined error for the embedded expression, got: %v", errors)
+	}
+}
+
+func TestAnalyzeDateTime(t *testing.T) {
+	input := `SUB Main()
+    DIM start AS DATETIME = #2024-03-15 09:00#
+    DIM finish AS DATETIME = start + Hours(2) - Minutes(15)
+    DIM elapsed AS DURATION = finish - start
+    DIM half AS DURATION = elapsed / 2
+    DIM ratio AS DOUBLE = elapsed / half
+    DIM early AS BOOLEAN = start < finish
+    PRINT DateSerial(2024, 1, 1) + TimeSerial(8, 0, 0), early, ratio
+END SUB`
+
+	program := parse(input)
+	a := New()
+	_, errors := a.Analyze(program)
+
+	if len(errors) > 0 {
+		t.Errorf("unexpected errors: %v", errors)
+	}
+}
+
+func TestAnalyzeDateTimeErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`SUB Main()
+    DIM t AS DATETIME
+    PRINT t + 1
+END SUB`, "operator + cannot be used with DATETIME and INTEGER"},
+		{`SUB Main()
+    DIM a AS DATETIME
+    DIM b AS DATETIME
+    PRINT a + b
+END SUB`, "operator + cannot be used with DATETIME and DATETIME"},
+		{`SUB Main()
+    DIM d AS DURATION = 30
+END SUB`, "cannot assign INTEGER to DURATION"},
+	}
+
+	for _, tt := range tests {
+		program := parse(tt.input)
+		a := New()
+		_, errors := a.Analyze(program)
+
+		found := false
+		for _, e := range errors {
+			if strings.Contains(e, tt.expected) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			t.Errorf("input %q: expected error containing %q, got: %v", tt.input, tt.expected, errors)
+		}
+	}
+}
+
+func TestAnalyzeMapType(t *testing.T) {
+	input := `SUB Main()
+    DIM ages AS MAP OF STRING TO INTEGER = {"alice": 30}
+    DIM age AS INTEGER
+    DIM found AS BOOLEAN
+    ages["bob"] = 25
+    age = ages["alice"]
+    age, found = ages["carol"]
+    DELETE(ages, "bob")
+    FOR EACH name, n IN ages
+        PRINT name; n + LEN(ages)
+    NEXT
+END SUB`
+
+	program := parse(input)
+	a := New()
+	_, errors := a.Analyze(program)
+
+	if len(errors) > 0 {
+		t.Errorf("unexpected errors: %v", errors)
+	}
+}
+
+func TestAnalyzeMapTypeErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`DIM m AS MAP OF STRING TO INTEGER
+DIM s AS STRING = m[1]`, "map key"},
+		{`DIM m AS MAP OF STRING TO INTEGER
+DIM s AS STRING = m["a"]`, "type mismatch"},
+		{`DIM m AS MAP OF []INTEGER TO INTEGER`, "invalid map key type"},
+		{`DIM m AS MAP OF STRING TO INTEGER = {"a": "x"}`, "type mismatch"},
+		{`DIM x AS INTEGER
+SUB Main()
+    DELETE(x, 1)
+END SUB`, "DELETE requires a map"},
+	}
+
+	for _, tt := range tests {
+		program := parse(tt.input)
+		a := New()
+		_, errors := a.Analyze(program)
+
+		found := false
+		for _, e := range errors {
+			if strings.Contains(e, tt.expected) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			t.Errorf("input %q: expected error containing %q, got: %v", tt.input, tt.expected, errors)
+		}
+	}
+}
+
+func TestAnalyzeFunctionType(t *testing.T) {
+	input := `FUNCTION Twice(n AS INTEGER) AS INTEGER
+    RETURN n * 2
+END FUNCTION
+
+FUNCTION Apply(f AS FUNCTION(INTEGER) AS INTEGER, x AS INTEGER) AS INTEGER
+    RETURN f(x)
+END FUNCTION
+
+SUB Main()
+    DIM op AS FUNCTION(INTEGER) AS INTEGER = Twice
+    DIM result AS INTEGER = op(5)
+    result = Apply(Twice, 21)
+END SUB`
+
+	program := parse(input)
+	a := New()
+	_, errors := a.Analyze(program)
+
+	if len(errors) > 0 {
+		t.Errorf("unexpected errors: %v", errors)
+	}
+}
+
+func TestAnalyzeFunctionTypeMismatch(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`SUB Greet(name AS STRING)
+END SUB
+DIM op AS FUNCTION(INTEGER) AS INTEGER = Greet`, "type mismatch"},
+		{`FUNCTION Join(a AS STRING, b AS STRING) AS STRING
+    RETURN a & b
+END FUNCTION
+DIM op AS FUNCTION(STRING) AS STRING = Join`, "type mismatch"},
+		{`DIM op AS FUNCTION(INTEGER) AS INTEGER
+SUB Main()
+    PRINT op("a")
+END SUB`, "argument 1 type mismatch"},
+		{`DIM x AS INTEGER
+SUB Main()
+    x(1)
+END SUB`, "not a function"},
+	}
+
+	for _, tt := range tests {
+		program := parse(tt.input)
+		a := New()
+		_, errors := a.Analyze(program)
+
+		found := false
+		for _, e := range errors {
+			if strings.Contains(e, tt.expected) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			t.Errorf("input %q: expected error containing %q, got: %v", tt.input, tt.expected, errors)
+		}
+	}
+}
+
+const shapeSource = `INTERFACE Shape
+    FUNCTION Area() AS DOUBLE
+END INTERFACE
+
+TYPE Circle IMPLEMENTS Shape
+    DIM Radius AS DOUBLE
+END TYPE
+
+FUNCTION (c AS POINTER TO Circle) Area() AS DOUBLE
+    RETURN c.Radius * c.Radius * 3.14
+END FUNCTION
+`
+
+func TestAnalyzeInterface(t *testing.T) {
+	input := shapeSource + `
+SUB Describe(s AS Shape)
+    DIM a AS DOUBLE = s.Area()
+END SUB
+
+SUB Main()
+    DIM c AS Circle
+    DIM s AS Shape = @c
+    Describe(@c)
+    Describe(s)
+END SUB`
+
+	program := parse(input)
+	a := New()
+	_, errors := a.Analyze(program)
+
+	if len(errors) > 0 {
+		t.Errorf("unexpected errors: %v", errors)
+	}
+}
+
+func TestAnalyzeInterfaceErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`INTERFACE Shape
+    FUNCTION Area() AS DOUBLE
+END INTERFACE
+TYPE Square IMPLEMENTS Shape
+    DIM Side AS DOUBLE
+END TYPE`, "Square does not implement Shape: missing method Area"},
+		{`INTERFACE Shape
+    FUNCTION Area() AS DOUBLE
+END INTERFACE
+TYPE Square IMPLEMENTS Shape
+    DIM Side AS DOUBLE
+END TYPE
+FUNCTION (q AS POINTER TO Square) Area() AS INTEGER
+    RETURN 1
+END FUNCTION`, "method Area has the wrong signature"},
+		{shapeSource + `
+SUB Main()
+    DIM c AS Circle
+    DIM s AS Shape = c
+END SUB`, "method Area has a pointer receiver"},
+		{shapeSource + `
+SUB Main()
+    DIM s AS Shape
+    PRINT s.Perimeter()
+END SUB`, "interface Shape has no method Perimeter"},
+		{`TYPE Square IMPLEMENTS Drawable
+    DIM Side AS DOUBLE
+END TYPE`, "unknown interface: Drawable"},
+	}
+
+	for _, tt := range tests {
+		program := parse(tt.input)
+		a := New()
+		_, errors := a.Analyze(program)
+
+		found := false
+		for _, e := range errors {
+			if strings.Contains(e, tt.expected) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			t.Errorf("input %q: expected error containing %q, got: %v", tt.input, tt.expected, errors)
+		}
+	}
+}
+
+func TestHasMain(t *testing.T) {
+	tests := []struct {
+		input   string
+		hasMain bool
+	}{
+		{`SUB Main()
+END SUB`, true},
+		{`SUB Other()
+END SUB`, false},
+		{`DIM x AS INTEGER`, false},
+	}
+
+	for i, tt := range tests {
+		program := parse(tt.input)
+		a := New()
+		a.Analyze(program)
+
+		if a.HasMain() != tt.hasMain {
+			t.Errorf("test[%d]: expected HasMain()=%v, got %v", i, tt.hasMain, a.HasMain())
+		}
+	}
+}
+
+func TestAnalyzeLabel(t *testing.T) {
+	input := `SUB Main()
+start:
+    PRINT "Hello"
+    GOTO start
+END SUB`
+
+	program := parse(input)
+	a := New()
+	_, errors := a.Analyze(program)
+
+	if len(errors) > 0 {
+		t.Errorf("unexpected errors: %v", errors)
+	}
+}
+
+func TestAnalyzeSelectCaseRangeErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`SUB Main()
+    DIM x AS INTEGER
+    SELECT CASE x
+    CASE 1 TO "ten"
+        PRINT "low"
+    END SELECT
+END SUB`, "case value type mismatch"},
+		{`SUB Main()
+    DIM ok AS BOOLEAN
+    SELECT CASE ok
+    CASE IS > TRUE
+        PRINT "?"
+    END SELECT
+END SUB`, "CASE IS > requires a numeric or STRING"},
+	}
+
+	for _, tt := range tests {
+		program := parse(tt.input)
+		a := New()
+		_, errors := a.Analyze(program)
+
+		found := false
+		for _, e := range errors {
+			if strings.Contains(e, tt.expected) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			t.Errorf("input %q: expected error containing %q, got: %v", tt.input, tt.expected, errors)
+		}
+	}
+}
+
+func TestAnalyzeSelectCase(t *testing.T) {
+	input := `SUB Main()
+    DIM x AS INTEGER = 2
+    SELECT CASE x
+    CASE 1
+        PRINT "one"
+    CASE 2
+        PRINT "two"
+    CASE ELSE
         PRINT "other"
     END SELECT
 END SUB`
@@ -567,3 +3983,329 @@ END SUB`
 		t.Errorf("unexpected errors: %v", errors)
 	}
 }
+
+func TestDeclaredPackage(t *testing.T) {
+	decls := `' Declarations for the Go package strings
+DECLARE PACKAGE "strings"
+DECLARE TYPE Builder
+DECLARE CONST MaxLen AS INTEGER
+DECLARE FUNCTION ToUpper(s AS STRING) AS STRING
+DECLARE FUNCTION Cut(s AS STRING, sep AS STRING) AS (STRING, STRING, BOOLEAN)
+DECLARE FUNCTION NewReplacer(PARAMARRAY oldnew AS []STRING) AS ANY
+DECLARE FUNCTION NewBuilder() AS POINTER TO Builder`
+
+	input := `IMPORT "strings" AS str
+
+SUB Main()
+    DIM s AS STRING = str.ToUpper("a")
+    DIM before AS STRING
+    DIM after AS STRING
+    DIM found AS BOOLEAN
+    before, after, found = str.Cut(s, "=")
+    DIM r AS ANY = str.NewReplacer("a", "b", "c", "d")
+    DIM b AS POINTER TO str.Builder = str.NewBuilder()
+    PRINT str.MaxLen + 1
+END SUB`
+
+	a := New()
+	if errs := a.Declare("strings.dbasi", decls); len(errs) > 0 {
+		t.Fatalf("unexpected errors in declarations: %v", errs)
+	}
+	if _, errors := a.Analyze(parse(input)); len(errors) > 0 {
+		t.Fatalf("unexpected errors: %v", errors)
+	}
+
+	bad := `IMPORT "strings"
+
+SUB Main()
+    DIM n AS INTEGER = strings.ToUpper("a")
+    PRINT strings.toUpper("a")
+    PRINT strings.ToUpper("a", "b")
+    DIM a AS STRING
+    DIM b AS STRING
+    a, b = strings.Cut("a=b", "=")
+    PRINT strings.Title("a")
+    DIM q AS strings.Reader
+    PRINT strings.Builder
+END SUB`
+
+	a = New()
+	a.Declare("strings.dbasi", decls)
+	_, errors := a.Analyze(parse(bad))
+	expected := []string{
+		"cannot assign STRING to INTEGER",
+		"package strings has no member toUpper",
+		"wrong number of arguments: expected 1, got 2",
+		"wrong number of values in multiple assignment: expected 3, got 2",
+		"package strings has no member Title",
+		"package strings has no type Reader",
+		"strings.Builder is a type, not a value",
+	}
+	if len(errors) != len(expected) {
+		t.Fatalf("expected %d errors, got %d: %v", len(expected), len(errors), errors)
+	}
+	for i, msg := range expected {
+		if !strings.Contains(errors[i], msg) {
+			t.Errorf("error %d: expected %q, got %q", i, msg, errors[i])
+		}
+	}
+
+	// Packages without declarations stay unchecked
+	unchecked := `IMPORT "fmt"
+
+SUB Main()
+    fmt.Anything(1, 2, 3)
+END SUB`
+	a = New()
+	a.Declare("strings.dbasi", decls)
+	if _, errors := a.Analyze(parse(unchecked)); len(errors) > 0 {
+		t.Errorf("unexpected errors: %v", errors)
+	}
+
+	if errs := New().Declare("bad.dbasi", "DECLARE TYPE Builder"); len(errs) != 1 {
+		t.Errorf("expected an error for a missing DECLARE PACKAGE, got %v", errs)
+	}
+}
+
+func TestPluginBuiltins(t *testing.T) {
+	builtin := func(name, decl string) *plugin.Builtin {
+		return &plugin.Builtin{Name: name, File: "textutil/text.go", Decl: parse(decl).Statements[0].(*parser.DeclareStatement)}
+	}
+	p := &plugin.Plugin{Name: "textutil", Builtins: []*plugin.Builtin{
+		builtin("Slugify", "DECLARE FUNCTION Slugify(s AS STRING) AS STRING"),
+		builtin("JoinAll", "DECLARE FUNCTION JoinAll(sep AS STRING, PARAMARRAY parts AS []STRING) AS STRING"),
+		builtin("Chime", "DECLARE SUB Chime()"),
+	}}
+
+	input := `SUB Main()
+    DIM s AS STRING = slugify("Hello World")
+    PRINT JoinAll(",", s, "b")
+    Chime()
+END SUB`
+	a := New()
+	a.AddPlugin(p)
+	symbols, errors := a.Analyze(parse(input))
+	if len(errors) > 0 {
+		t.Fatalf("unexpected errors: %v", errors)
+	}
+	if sym := symbols.GlobalScope.Resolve("SLUGIFY"); sym == nil || sym.GoName != "Slugify" {
+		t.Errorf("expected Slugify to call the Go function Slugify, got %+v", sym)
+	}
+
+	bad := `SUB Main()
+    DIM n AS INTEGER = Slugify("a")
+    PRINT Slugify()
+END SUB`
+	a = New()
+	a.AddPlugin(p)
+	a.AddPlugin(&plugin.Plugin{Name: "clash", Builtins: []*plugin.Builtin{
+		builtin("Left", "DECLARE FUNCTION Left(s AS STRING, n AS INTEGER) AS STRING"),
+		builtin("Odd", "DECLARE SUB Odd(v AS Widget)"),
+	}})
+	_, errors = a.Analyze(parse(bad))
+	expected := []string{
+		"builtin Left is already defined (in textutil/text.go, line 1)",
+		"unknown type: WIDGET (in textutil/text.go, line 1)",
+		"cannot assign STRING to INTEGER",
+		"wrong number of arguments: expected 1, got 0",
+	}
+	if len(errors) != len(expected) {
+		t.Fatalf("expected %d errors, got %d: %v", len(expected), len(errors), errors)
+	}
+	for i, msg := range expected {
+		if !strings.Contains(errors[i], msg) {
+			t.Errorf("error %d: expected %q, got %q", i, msg, errors[i])
+		}
+	}
+}
+
+func TestAnalyzeReturnPaths(t *testing.T) {
+	input := `FUNCTION Sign(n AS INTEGER) AS INTEGER
+    IF n > 0 THEN
+        RETURN 1
+    ELSEIF n < 0 THEN
+        RETURN -1
+    ELSE
+        RETURN 0
+    END IF
+END FUNCTION
+
+FUNCTION Name(n AS INTEGER) AS STRING
+    SELECT CASE n
+    CASE 1
+        RETURN "one"
+    CASE ELSE
+        ERROR 5
+    END SELECT
+END FUNCTION
+
+FUNCTION Forever(c AS CHAN OF INTEGER) AS INTEGER
+    DO
+        DIM v AS INTEGER
+        RECEIVE v FROM c
+        FOR i = 1 TO v
+            IF i = 3 THEN EXIT FOR
+        NEXT i
+        IF v > 10 THEN RETURN v
+    LOOP
+END FUNCTION
+
+FUNCTION Safe(s AS STRING) AS INTEGER
+    ON ERROR GOTO Failed
+    RETURN Val(s)
+Failed:
+    PRINT "bad number"
+END FUNCTION
+
+FUNCTION Checked(n AS INTEGER) AS INTEGER
+    IF n >= 0 THEN
+        RETURN n
+    END IF
+    PANIC("negative: " + Str(n))
+END FUNCTION
+
+FUNCTION Pair() AS (INTEGER, STRING)
+    RETURN 1, "a"
+END FUNCTION
+
+FUNCTION PassOn() AS (INTEGER, STRING)
+    RETURN Pair()
+END FUNCTION
+
+SUB Main()
+    IF Sign(2) > 0 THEN RETURN
+    PRINT Name(1); Checked(3)
+END SUB`
+
+	program := parse(input)
+	a := New()
+	_, errors := a.Analyze(program)
+
+	if len(errors) > 0 {
+		t.Fatalf("unexpected errors: %v", errors)
+	}
+}
+
+func TestAnalyzeReturnErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"SUB S()\nRETURN 1\nEND SUB", "RETURN with a value outside a FUNCTION"},
+		{"TEST \"t\"\nRETURN 1\nEND TEST", "RETURN with a value outside a FUNCTION"},
+		{"FUNCTION F() AS INTEGER\nRETURN 1, 2\nEND FUNCTION", "RETURN has 2 value(s), but the FUNCTION returns 1"},
+		{"FUNCTION F() AS (INTEGER, STRING)\nRETURN 1\nEND FUNCTION", "RETURN has 1 value(s), but the FUNCTION returns 2"},
+		{"FUNCTION F() AS INTEGER\nRETURN \"one\"\nEND FUNCTION", "type mismatch in RETURN: cannot return STRING as INTEGER"},
+		{"FUNCTION F() AS (INTEGER, STRING)\nRETURN 1, 2\nEND FUNCTION", "type mismatch in RETURN at position 2: cannot return INTEGER as STRING"},
+		{"FUNCTION F() AS INTEGER\nPRINT 1\nEND FUNCTION", "FUNCTION F can reach its end without a RETURN"},
+		{"FUNCTION F(n AS INTEGER) AS INTEGER\nIF n > 0 THEN\nRETURN 1\nEND IF\nEND FUNCTION", "FUNCTION F can reach its end without a RETURN"},
+		{"FUNCTION F(n AS INTEGER) AS INTEGER\nIF n > 0 THEN\nRETURN 1\nELSEIF n < 0 THEN\nPRINT n\nELSE\nRETURN 0\nEND IF\nEND FUNCTION", "FUNCTION F can reach its end"},
+		{"FUNCTION F(n AS INTEGER) AS INTEGER\nSELECT CASE n\nCASE 1\nRETURN 1\nEND SELECT\nEND FUNCTION", "FUNCTION F can reach its end"},
+		{"FUNCTION F(n AS INTEGER) AS INTEGER\nDO\nIF n > 0 THEN EXIT DO\nRETURN 1\nLOOP\nEND FUNCTION", "FUNCTION F can reach its end"},
+		{"FUNCTION F(n AS INTEGER) AS INTEGER\nWHILE TRUE\nRETURN n\nWEND\nEND FUNCTION", "FUNCTION F can reach its end"},
+		{"TYPE T\nDIM x AS INTEGER\nEND TYPE\nFUNCTION (t AS T) Get() AS INTEGER\nPRINT t.x\nEND FUNCTION", "FUNCTION Get can reach its end"},
+		{"TYPE T\nDIM x AS INTEGER\nPROPERTY GET Twice() AS INTEGER\nIF .x > 0 THEN RETURN .x * 2\nEND PROPERTY\nEND TYPE", "PROPERTY GET Twice can reach its end"},
+		{"TYPE T\nDIM x AS INTEGER\nPROPERTY GET Caption() AS STRING\nRETURN .x\nEND PROPERTY\nEND TYPE", "cannot return INTEGER as STRING"},
+	}
+
+	for _, tt := range tests {
+		program := parse(tt.input)
+		a := New()
+		_, errors := a.Analyze(program)
+
+		found := false
+		for _, err := range errors {
+			if strings.Contains(err, tt.expected) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			t.Errorf("expected error containing %q for %q, got %v", tt.expected, tt.input, errors)
+		}
+	}
+}
+
+func TestAnalyzeErrorCodes(t *testing.T) {
+	tests := []struct {
+		input string
+		code  string
+	}{
+		{"SUB Main()\nPRINT y\nEND SUB", "DB2001"},
+		{"DIM x AS INTEGER\nSUB Main()\nx()\nEND SUB", "DB2004"},
+		{"SUB Main()\nDIM s AS STRING\nFOR i = s TO 10\nNEXT i\nEND SUB", "DB2004"},
+		{"DIM s AS []INTEGER = RANGE(1, 5, 0)", "DB2016"},
+		{"CONST\nRed = \"red\"\nEND CONST", "DB2016"},
+		{"SUB Main()\nINPUT \"When? \", d AS DATETIME\nEND SUB", "DB2004"},
+		{"TYPE Counter\nDIM N AS INTEGER\nEND TYPE\nSUB (c AS Counter) Bump()\nc.N = c.N + 1\nEND SUB", "DB2020"},
+		{"DIM mu AS MUTEX\nDIM other AS MUTEX\nSUB S()\nmu = other\nEND SUB", "DB2013"},
+		{"MODULE Math\nDIM calls AS INTEGER\nEND MODULE\nLET m = Math", "DB2018"},
+		{"OPTION Nonsense", "DB2021"},
+	}
+
+	for _, tt := range tests {
+		a := New()
+		_, errors := a.Analyze(parse(tt.input))
+		if len(errors) == 0 {
+			t.Errorf("%q: expected an error", tt.input)
+			continue
+		}
+		if want := "semantic error[" + tt.code + "]"; !strings.HasPrefix(errors[0], want) {
+			t.Errorf("%q: expected %s, got %q", tt.input, want, errors[0])
+		}
+	}
+}
+
+// TestErrorCallsHaveCodes checks that every error and warning the analyzer
+// reports is given a code of its own, not the general DB2000 or DB3000
+func TestErrorCallsHaveCodes(t *testing.T) {
+	files, err := filepath.Glob("*.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fset := token.NewFileSet()
+	calls := 0
+	for _, file := range files {
+		if strings.HasSuffix(file, "_test.go") {
+			continue
+		}
+		f, err := goparser.ParseFile(fset, file, nil, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ast.Inspect(f, func(n ast.Node) bool {
+			call, ok := n.(*ast.CallExpr)
+			if !ok {
+				return true
+			}
+			method, ok := call.Fun.(*ast.SelectorExpr)
+			if !ok || len(call.Args) < 2 {
+				return true
+			}
+			switch method.Sel.Name {
+			case "error", "errorWithHint", "warn":
+			default:
+				return true
+			}
+			calls++
+			code, ok := call.Args[1].(*ast.SelectorExpr)
+			if !ok {
+				// The wrappers pass on the code they were given
+				if ident, ok := call.Args[1].(*ast.Ident); ok && ident.Name == "code" {
+					return true
+				}
+				t.Errorf("%s: the code is not a constant of package errors", fset.Position(call.Pos()))
+				return true
+			}
+			if pkg, ok := code.X.(*ast.Ident); !ok || pkg.Name != "dberrors" {
+				t.Errorf("%s: the code is not a constant of package errors", fset.Position(call.Pos()))
+			} else if code.Sel.Name == "SemanticError" || code.Sel.Name == "Warning" {
+				t.Errorf("%s: message has the general code %s", fset.Position(call.Pos()), code.Sel.Name)
+			}
+			return true
+		})
+	}
+	if calls == 0 {
+		t.Error("found no calls of error, errorWithHint or warn")
+	}
+}
@@ -0,0 +1,532 @@
+package analyzer
+
+import (
+	"fmt"
+
+	dberrors "github.com/zditech/dbasic/pkg/errors"
+	"github.com/zditech/dbasic/pkg/lexer"
+	"github.com/zditech/dbasic/pkg/parser"
+)
+
+// assignChecker follows the paths through a routine to find reads of local
+// variables that may not have been assigned yet. DIM gives a variable its
+// zero value, so such reads are not errors, but a variable set in only some
+// branches of an IF is often a mistake.
+type assignChecker struct {
+	syms     map[*parser.Identifier]*Symbol
+	locals   map[*Symbol]bool               // Variables DIMmed without a value in the routine
+	set      map[*Symbol]bool               // Variables assigned other than by updating themselves
+	reads    map[*Symbol]*parser.Identifier // First read of each variable that may be unassigned
+	order    []*Symbol                      // Variables of reads, in order
+	assigned map[*Symbol]bool               // Variables assigned on every path to this point
+	dead     bool                           // This point cannot be reached
+	loops    [][]map[*Symbol]bool           // What is assigned at each EXIT of the enclosing loops
+	skip     *Symbol                        // Variable whose reads are ignored, in x = x + ...
+	jumps    bool                           // The routine has labels, so flow cannot be followed
+}
+
+// vetMaybeUnassigned flags reads of local variables that some path reaches
+// before assigning them. Variables that are only ever updated from their own
+// value, such as counters, rely on starting at zero and are left alone, as
+// are routines with labels, whose GOTOs cannot be followed.
+func (a *Analyzer) vetMaybeUnassigned(program *parser.Program) {
+	if !a.vet {
+		return
+	}
+	syms := make(map[*parser.Identifier]*Symbol)
+	for _, ref := range a.refs {
+		syms[ref.Ident] = ref.Symbol
+	}
+	for _, body := range routineBodies(program.Statements) {
+		c := &assignChecker{
+			syms:     syms,
+			locals:   make(map[*Symbol]bool),
+			set:      make(map[*Symbol]bool),
+			reads:    make(map[*Symbol]*parser.Identifier),
+			assigned: make(map[*Symbol]bool),
+		}
+		c.block(body)
+		if c.jumps {
+			continue
+		}
+		for _, sym := range c.order {
+			if !c.set[sym] {
+				continue
+			}
+			a.warn(c.reads[sym].Token.Line,
+				dberrors.MaybeUnassigned, fmt.Sprintf("give %s a value with DIM %s AS %s = ..., or assign it on every path before this", sym.Name, sym.Name, sym.Type.String()),
+				"%s may be read before it is assigned", sym.Name)
+		}
+	}
+}
+
+// routineBodies returns the bodies of the SUBs, FUNCTIONs, methods, TESTs,
+// BENCHMARKs, constructors and properties among stmts
+func routineBodies(stmts []parser.Statement) []*parser.BlockStatement {
+	var bodies []*parser.BlockStatement
+	for _, stmt := range stmts {
+		switch s := stmt.(type) {
+		case *parser.SubStatement:
+			bodies = append(bodies, s.Body)
+		case *parser.FunctionStatement:
+			bodies = append(bodies, s.Body)
+		case *parser.MethodStatement:
+			bodies = append(bodies, s.Body)
+		case *parser.TestStatement:
+			bodies = append(bodies, s.Body)
+		case *parser.BenchmarkStatement:
+			bodies = append(bodies, s.Body)
+		case *parser.ModuleStatement:
+			bodies = append(bodies, routineBodies(s.Body)...)
+		case *parser.TypeStatement:
+			if s.Constructor != nil {
+				bodies = append(bodies, s.Constructor.Body)
+			}
+			for _, prop := range s.Properties {
+				bodies = append(bodies, prop.Body)
+			}
+		}
+	}
+	return bodies
+}
+
+// branch is what is known at the end of one way through a statement
+type branch struct {
+	assigned map[*Symbol]bool
+	dead     bool
+}
+
+// save returns the current state, to start a branch from or join later
+func (c *assignChecker) save() branch {
+	return branch{assigned: copySet(c.assigned), dead: c.dead}
+}
+
+// restore makes b the current state
+func (c *assignChecker) restore(b branch) {
+	c.assigned, c.dead = copySet(b.assigned), b.dead
+}
+
+// join makes the current state the meeting of branches: a variable is
+// assigned if every branch that reaches the join assigns it
+func (c *assignChecker) join(branches []branch) {
+	var live []branch
+	for _, b := range branches {
+		if !b.dead {
+			live = append(live, b)
+		}
+	}
+	if len(live) == 0 {
+		c.assigned, c.dead = make(map[*Symbol]bool), true
+		return
+	}
+	c.assigned, c.dead = make(map[*Symbol]bool), false
+	for sym := range live[0].assigned {
+		everywhere := true
+		for _, b := range live[1:] {
+			everywhere = everywhere && b.assigned[sym]
+		}
+		if everywhere {
+			c.assigned[sym] = true
+		}
+	}
+}
+
+// branches runs each block from the current state and joins the results,
+// along with the current state itself when no block may run
+func (c *assignChecker) branches(blocks []*parser.BlockStatement, mayRunNone bool) {
+	start := c.save()
+	var ends []branch
+	if mayRunNone {
+		ends = append(ends, start)
+	}
+	for _, b := range blocks {
+		c.restore(start)
+		c.block(b)
+		ends = append(ends, c.save())
+	}
+	c.join(ends)
+}
+
+// loop runs a loop body from the current state. Afterwards the variables
+// assigned are those assigned before it, or when the body always runs, those
+// assigned at its end and at each EXIT.
+func (c *assignChecker) loop(body *parser.BlockStatement, always bool, cond parser.Expression) {
+	start := c.save()
+	c.loops = append(c.loops, nil)
+	c.block(body)
+	if !c.dead {
+		c.expr(cond)
+	}
+	exits := c.loops[len(c.loops)-1]
+	c.loops = c.loops[:len(c.loops)-1]
+	if !always {
+		c.restore(start)
+		return
+	}
+	var ends []branch
+	if cond != nil {
+		ends = append(ends, c.save())
+	}
+	for _, exit := range exits {
+		ends = append(ends, branch{assigned: exit})
+	}
+	c.join(ends)
+}
+
+func (c *assignChecker) block(block *parser.BlockStatement) {
+	if block == nil {
+		return
+	}
+	for _, stmt := range block.Statements {
+		if c.dead {
+			// The rest cannot run
+			return
+		}
+		c.stmt(stmt)
+	}
+}
+
+func (c *assignChecker) stmt(stmt parser.Statement) {
+	switch s := stmt.(type) {
+	case *parser.DimStatement:
+		c.expr(s.Value)
+		c.expr(s.ArraySize)
+		c.expr(s.LowerBound)
+		sym := c.syms[s.Name]
+		if sym == nil {
+			return
+		}
+		if s.Value != nil || s.Token.Type == lexer.TOKEN_INPUT {
+			c.assigned[sym] = true
+			return
+		}
+		if s.ArraySize == nil && !s.Static && isScalar(sym.Type) {
+			// A DIM in a loop starts the variable over each time around
+			c.locals[sym] = true
+			delete(c.assigned, sym)
+		}
+	case *parser.LetStatement:
+		c.expr(s.Value)
+		c.assign(s.Name, true)
+	case *parser.AssignmentStatement:
+		ident, ok := s.Left.(*parser.Identifier)
+		if !ok {
+			c.expr(s.Left)
+			c.expr(s.Value)
+			return
+		}
+		sym := c.syms[ident]
+		self := sym != nil && c.mentions(s.Value, sym)
+		if self {
+			c.skip = sym
+		}
+		c.expr(s.Value)
+		c.skip = nil
+		c.assign(ident, !self)
+	case *parser.MultiAssignmentStatement:
+		c.expr(s.Value)
+		for _, target := range s.Targets {
+			c.target(target)
+		}
+	case *parser.PrintStatement:
+		c.expr(s.FileNumber)
+		c.exprs(s.Values)
+	case *parser.InputStatement:
+		c.expr(s.Prompt)
+		c.assign(s.Variable, true)
+	case *parser.LineInputStatement:
+		c.expr(s.FileNumber)
+		c.assign(s.Variable, true)
+	case *parser.OpenStatement:
+		c.expr(s.Path)
+		c.expr(s.FileNumber)
+		c.expr(s.RecordLen)
+	case *parser.CloseStatement:
+		c.exprs(s.FileNumbers)
+	case *parser.GetPutStatement:
+		c.expr(s.FileNumber)
+		c.expr(s.Position)
+		if s.Name() == "GET" {
+			c.target(s.Variable)
+		} else {
+			c.expr(s.Variable)
+		}
+	case *parser.IfStatement:
+		c.expr(s.Condition)
+		start := c.save()
+		c.block(s.Consequence)
+		ends := []branch{c.save()}
+		for _, elseIf := range s.ElseIfs {
+			// Each ELSEIF condition is read only when the ones before are false
+			c.restore(start)
+			c.expr(elseIf.Condition)
+			start = c.save()
+			c.block(elseIf.Consequence)
+			ends = append(ends, c.save())
+		}
+		c.restore(start)
+		c.block(s.Alternative)
+		c.join(append(ends, c.save()))
+	case *parser.SelectStatement:
+		c.expr(s.TestExpr)
+		var blocks []*parser.BlockStatement
+		for _, clause := range s.Cases {
+			c.exprs(clause.Values)
+			blocks = append(blocks, clause.Body)
+		}
+		if s.Default != nil {
+			blocks = append(blocks, s.Default)
+		}
+		c.branches(blocks, s.Default == nil)
+	case *parser.SelectChannelStatement:
+		start := c.save()
+		var ends []branch
+		for _, arm := range s.Cases {
+			c.restore(start)
+			switch {
+			case arm.Receive != nil:
+				c.expr(arm.Receive.Channel)
+				if arm.Receive.Variable != nil {
+					c.target(arm.Receive.Variable)
+				}
+			case arm.Send != nil:
+				c.expr(arm.Send.Value)
+				c.expr(arm.Send.Channel)
+			default:
+				c.expr(arm.Timeout)
+			}
+			c.block(arm.Body)
+			ends = append(ends, c.save())
+		}
+		if s.Default != nil {
+			c.restore(start)
+			c.block(s.Default)
+			ends = append(ends, c.save())
+		}
+		c.join(ends)
+	case *parser.ForStatement:
+		c.expr(s.Start)
+		c.expr(s.End)
+		c.expr(s.Step)
+		c.assign(s.Variable, true)
+		c.loop(s.Body, false, nil)
+	case *parser.ForEachStatement:
+		c.expr(s.Collection)
+		start := c.save()
+		if s.Key != nil {
+			c.assign(s.Key, true)
+		}
+		c.assign(s.Value, true)
+		c.loop(s.Body, false, nil)
+		c.restore(start)
+	case *parser.WhileStatement:
+		c.expr(s.Condition)
+		c.loop(s.Body, false, nil)
+	case *parser.DoLoopStatement:
+		if s.IsPreCondition {
+			c.expr(s.Condition)
+			c.loop(s.Body, false, nil)
+		} else {
+			c.loop(s.Body, true, s.Condition)
+		}
+	case *parser.WithStatement:
+		c.expr(s.Target)
+		c.block(s.Body)
+	case *parser.LockStatement:
+		c.expr(s.Mutex)
+		c.block(s.Body)
+	case *parser.ReturnStatement:
+		c.exprs(s.Values)
+		c.dead = true
+	case *parser.ExitStatement:
+		switch s.ExitType {
+		case "FOR", "WHILE", "DO":
+			if n := len(c.loops); n > 0 {
+				c.loops[n-1] = append(c.loops[n-1], copySet(c.assigned))
+			}
+		}
+		c.dead = true
+	case *parser.ErrorStatement:
+		c.expr(s.Code)
+		c.dead = true
+	case *parser.CheckStatement:
+		c.expr(s.Err)
+	case *parser.AssertStatement:
+		c.expr(s.Condition)
+		c.expr(s.Message)
+	case *parser.SpawnStatement:
+		c.expr(s.Call)
+	case *parser.SendStatement:
+		c.expr(s.Value)
+		c.expr(s.Channel)
+	case *parser.ReceiveStatement:
+		c.expr(s.Channel)
+		if s.Variable != nil {
+			c.target(s.Variable)
+		}
+	case *parser.ExpressionStatement:
+		c.expr(s.Expression)
+	case *parser.LabelStatement, *parser.GotoStatement, *parser.GosubStatement:
+		c.jumps = true
+	}
+}
+
+// target handles an expression that is assigned to
+func (c *assignChecker) target(expr parser.Expression) {
+	if ident, ok := expr.(*parser.Identifier); ok {
+		c.assign(ident, true)
+		return
+	}
+	c.expr(expr)
+}
+
+// assign records that ident is assigned; plain is false when the new value
+// is worked out from the old one
+func (c *assignChecker) assign(ident *parser.Identifier, plain bool) {
+	sym := c.syms[ident]
+	if sym == nil {
+		return
+	}
+	c.assigned[sym] = true
+	if plain {
+		c.set[sym] = true
+	}
+}
+
+// read records a read of ident
+func (c *assignChecker) read(ident *parser.Identifier) {
+	sym := c.syms[ident]
+	if sym == nil || !c.locals[sym] || sym == c.skip || c.assigned[sym] || c.reads[sym] != nil {
+		return
+	}
+	c.reads[sym] = ident
+	c.order = append(c.order, sym)
+}
+
+func (c *assignChecker) exprs(exprs []parser.Expression) {
+	for _, e := range exprs {
+		c.expr(e)
+	}
+}
+
+func (c *assignChecker) expr(expr parser.Expression) {
+	switch e := expr.(type) {
+	case *parser.Identifier:
+		c.read(e)
+	case *parser.InterpolatedString:
+		c.exprs(e.Parts)
+	case *parser.JSONLiteral:
+		for _, v := range e.Pairs {
+			c.expr(v)
+		}
+	case *parser.StructLiteral:
+		for _, v := range e.Fields {
+			c.expr(v)
+		}
+	case *parser.ArrayLiteral:
+		c.exprs(e.Elements)
+	case *parser.SliceLiteral:
+		c.exprs(e.Elements)
+	case *parser.RangeExpression:
+		c.expr(e.Start)
+		c.expr(e.End)
+		c.expr(e.Step)
+	case *parser.PrefixExpression:
+		if e.Operator == "@" {
+			c.target(e.Right)
+			return
+		}
+		c.expr(e.Right)
+	case *parser.AddressOfExpression:
+		// The variable may be set through the pointer
+		c.target(e.Value)
+	case *parser.InfixExpression:
+		c.expr(e.Left)
+		c.expr(e.Right)
+	case *parser.CallExpression:
+		if _, ok := e.Function.(*parser.Identifier); !ok {
+			c.expr(e.Function)
+		}
+		params := c.params(e.Function)
+		for i, arg := range e.Arguments {
+			if i < len(params) && params[i].ByRef {
+				c.target(arg)
+			} else {
+				c.expr(arg)
+			}
+		}
+	case *parser.NewExpression:
+		c.exprs(e.Arguments)
+	case *parser.IndexExpression:
+		c.expr(e.Left)
+		c.expr(e.Index)
+		c.expr(e.End)
+	case *parser.MemberExpression:
+		c.expr(e.Object)
+	case *parser.FieldListExpression:
+		c.expr(e.Object)
+	case *parser.TypeAssertionExpression:
+		c.expr(e.Value)
+	case *parser.MakeChanExpression:
+		c.expr(e.Size)
+	case *parser.ReceiveExpression:
+		c.expr(e.Channel)
+	case *parser.DereferenceExpression:
+		c.expr(e.Value)
+	case *parser.CaseRange:
+		c.expr(e.Low)
+		c.expr(e.High)
+	case *parser.CaseIs:
+		c.expr(e.Value)
+	}
+}
+
+// params returns the parameters of the SUB or FUNCTION a call names, or
+// nil if it is not one of the program's
+func (c *assignChecker) params(fn parser.Expression) []*parser.Parameter {
+	ident, ok := fn.(*parser.Identifier)
+	if !ok || c.syms[ident] == nil {
+		return nil
+	}
+	switch node := c.syms[ident].Node.(type) {
+	case *parser.SubStatement:
+		return node.Params
+	case *parser.FunctionStatement:
+		return node.Params
+	}
+	return nil
+}
+
+// mentions reports whether expr reads sym
+func (c *assignChecker) mentions(expr parser.Expression, sym *Symbol) bool {
+	probe := &assignChecker{
+		syms:     c.syms,
+		locals:   map[*Symbol]bool{sym: true},
+		set:      make(map[*Symbol]bool),
+		reads:    make(map[*Symbol]*parser.Identifier),
+		assigned: make(map[*Symbol]bool),
+	}
+	probe.expr(expr)
+	return probe.reads[sym] != nil
+}
+
+// isScalar reports whether t is a type whose zero value reads like a real
+// value: a number, STRING or BOOLEAN
+func isScalar(t *Type) bool {
+	if t == nil {
+		return false
+	}
+	switch t.Kind {
+	case TypeString, TypeInteger, TypeLong, TypeSingle, TypeDouble, TypeBoolean:
+		return true
+	}
+	return false
+}
+
+func copySet(set map[*Symbol]bool) map[*Symbol]bool {
+	copied := make(map[*Symbol]bool, len(set))
+	for sym := range set {
+		copied[sym] = true
+	}
+	return copied
+}
@@ -0,0 +1,322 @@
+package analyzer
+
+import (
+	"go/constant"
+	"go/token"
+	"math"
+	"strings"
+
+	dberrors "github.com/zditech/dbasic/pkg/errors"
+	"github.com/zditech/dbasic/pkg/parser"
+)
+
+// constValue works out the value of a constant expression: literals, CONSTs
+// and the operators that combine them. It reports false for anything else,
+// and for a division by zero, which analyzeInfixExpression reports.
+func (a *Analyzer) constValue(expr parser.Expression) (constant.Value, bool) {
+	switch e := expr.(type) {
+	case *parser.IntegerLiteral:
+		return constant.MakeInt64(e.Value), true
+	case *parser.FloatLiteral:
+		return constant.MakeFloat64(e.Value), true
+	case *parser.StringLiteral:
+		return constant.MakeString(e.Value), true
+	case *parser.BooleanLiteral:
+		return constant.MakeBool(e.Value), true
+	case *parser.Identifier:
+		sym := a.symbols.Resolve(e.Value)
+		if sym == nil {
+			return a.globalConstValue(e.Value)
+		}
+		if sym.Kind != SymConstant || sym.Value == nil {
+			return nil, false
+		}
+		return sym.Value, true
+	case *parser.PrefixExpression:
+		x, ok := a.constValue(e.Right)
+		if !ok {
+			return nil, false
+		}
+		switch {
+		case e.Operator == "-" && isNumericConst(x):
+			return constant.UnaryOp(token.SUB, x, 0), true
+		case e.Operator == "NOT" && x.Kind() == constant.Bool:
+			return constant.UnaryOp(token.NOT, x, 0), true
+		}
+	case *parser.InfixExpression:
+		x, ok := a.constValue(e.Left)
+		if !ok {
+			return nil, false
+		}
+		y, ok := a.constValue(e.Right)
+		if !ok {
+			return nil, false
+		}
+		v, ok := constBinaryOp(e.Operator, x, y)
+		if ok && e.Operator == "^" {
+			// Go has no constant power, so the code generator writes the value
+			a.symbols.constants[e] = v
+		}
+		return v, ok
+	}
+	return nil, false
+}
+
+// collectGlobalConsts finds the CONSTs at the top level of the program
+func (a *Analyzer) collectGlobalConsts(program *parser.Program) {
+	a.globalConsts = make(map[string]parser.Statement)
+	a.folding = make(map[string]bool)
+	for _, stmt := range program.Statements {
+		switch s := stmt.(type) {
+		case *parser.ConstStatement:
+			a.globalConsts[strings.ToUpper(s.Name.Value)] = s
+		case *parser.ConstGroupStatement:
+			for _, name := range s.Names {
+				a.globalConsts[strings.ToUpper(name.Value)] = s
+			}
+		}
+	}
+}
+
+// globalConstValue works out the value of a top-level CONST that is not
+// declared yet, as when a TYPE declared before it uses it, or a SUB that
+// comes before it in the program
+func (a *Analyzer) globalConstValue(name string) (constant.Value, bool) {
+	key := strings.ToUpper(name)
+	stmt := a.globalConsts[key]
+	if stmt == nil || a.folding[key] {
+		return nil, false
+	}
+	a.folding[key] = true
+	defer delete(a.folding, key)
+
+	switch s := stmt.(type) {
+	case *parser.ConstStatement:
+		v, ok := a.constValue(s.Value)
+		if !ok {
+			return nil, false
+		}
+		if v = convertConst(v, a.resolveTypeSpec(s.Type)); v == nil {
+			return nil, false
+		}
+		return v, true
+	case *parser.ConstGroupStatement:
+		next := constant.MakeInt64(0)
+		for i, n := range s.Names {
+			if value := s.Values[i]; value != nil {
+				v, ok := a.constValue(value)
+				if !ok || v.Kind() != constant.Int {
+					next = nil
+				} else {
+					next = v
+				}
+			}
+			if strings.EqualFold(n.Value, name) {
+				return next, next != nil
+			}
+			if next != nil {
+				next = nextConst(next)
+			}
+		}
+	}
+	return nil, false
+}
+
+// constComparisons are the Go tokens of the comparison operators
+var constComparisons = map[string]token.Token{
+	"=": token.EQL, "<>": token.NEQ,
+	"<": token.LSS, "<=": token.LEQ, ">": token.GTR, ">=": token.GEQ,
+}
+
+// constBinaryOp applies an operator to two constants the way the Go code
+// it is translated to does, so / of two integers divides them as integers
+func constBinaryOp(op string, x, y constant.Value) (constant.Value, bool) {
+	numeric := isNumericConst(x) && isNumericConst(y)
+	texts := x.Kind() == constant.String && y.Kind() == constant.String
+	bools := x.Kind() == constant.Bool && y.Kind() == constant.Bool
+	switch op {
+	case "+":
+		if numeric || texts {
+			return constant.BinaryOp(x, token.ADD, y), true
+		}
+	case "&":
+		if texts {
+			return constant.BinaryOp(x, token.ADD, y), true
+		}
+	case "-":
+		if numeric {
+			return constant.BinaryOp(x, token.SUB, y), true
+		}
+	case "*":
+		if numeric {
+			return constant.BinaryOp(x, token.MUL, y), true
+		}
+	case "/", "\\":
+		if !numeric || constant.Sign(y) == 0 {
+			return nil, false
+		}
+		if x.Kind() == constant.Int && y.Kind() == constant.Int {
+			return constant.BinaryOp(x, token.QUO_ASSIGN, y), true
+		}
+		return constant.BinaryOp(x, token.QUO, y), true
+	case "^":
+		// Like math.Pow, ^ always gives a DOUBLE
+		if numeric {
+			xf, _ := constant.Float64Val(x)
+			yf, _ := constant.Float64Val(y)
+			if p := math.Pow(xf, yf); !math.IsInf(p, 0) && !math.IsNaN(p) {
+				return constant.MakeFloat64(p), true
+			}
+		}
+	case "MOD":
+		if x.Kind() == constant.Int && y.Kind() == constant.Int && constant.Sign(y) != 0 {
+			return constant.BinaryOp(x, token.REM, y), true
+		}
+	case "=", "<>", "<", "<=", ">", ">=":
+		if numeric || texts || bools && (op == "=" || op == "<>") {
+			return constant.MakeBool(constant.Compare(x, constComparisons[op], y)), true
+		}
+	case "AND":
+		if bools {
+			return constant.BinaryOp(x, token.LAND, y), true
+		}
+	case "OR":
+		if bools {
+			return constant.BinaryOp(x, token.LOR, y), true
+		}
+	case "XOR":
+		if bools {
+			return constant.MakeBool(constant.BoolVal(x) != constant.BoolVal(y)), true
+		}
+	}
+	return nil, false
+}
+
+// convertConst gives the value of a CONST the kind of its declared type, as
+// Go does for a typed constant. A value the type cannot hold is dropped.
+func convertConst(v constant.Value, t *Type) constant.Value {
+	switch {
+	case t.IsInteger():
+		v = constant.ToInt(v)
+	case t.IsNumeric():
+		v = constant.ToFloat(v)
+	}
+	if v.Kind() == constant.Unknown {
+		return nil
+	}
+	return v
+}
+
+// nextConst returns the value of a constant in a CONST block that has none:
+// one more than the constant before it
+func nextConst(v constant.Value) constant.Value {
+	return constant.BinaryOp(v, token.ADD, constant.MakeInt64(1))
+}
+
+// isNumericConst reports whether a constant is a number
+func isNumericConst(x constant.Value) bool {
+	return x.Kind() == constant.Int || x.Kind() == constant.Float
+}
+
+// checkDivisor reports x / 0, x \ 0 and x MOD 0 where the divisor is a
+// constant, which Go rejects
+func (a *Analyzer) checkDivisor(expr *parser.InfixExpression) {
+	switch expr.Operator {
+	case "/", "\\", "MOD":
+	default:
+		return
+	}
+	if y, ok := a.constValue(expr.Right); ok && isNumericConst(y) && constant.Sign(y) == 0 {
+		a.errorWithHint(expr.Token.Line, dberrors.InvalidConstant, "division by zero in %s",
+			"the divisor is a constant that is always 0", expr.String())
+	}
+}
+
+// intConstant works out an integer constant expression, such as the width
+// of a STRING * n or a bound of an array, and records its value for the
+// code generator
+func (a *Analyzer) intConstant(expr parser.Expression) (int, bool) {
+	v, ok := a.constValue(expr)
+	if !ok || v.Kind() != constant.Int {
+		return 0, false
+	}
+	n, exact := constant.Int64Val(v)
+	if !exact {
+		return 0, false
+	}
+	a.symbols.constants[expr] = v
+	return int(n), true
+}
+
+// nonConstant returns the part of a CONST initializer that cannot be worked
+// out at compile time, such as a call or a variable, or nil if there is none.
+// Names the analyzer cannot resolve, such as the constants of Go packages,
+// are left for Go to check.
+func (a *Analyzer) nonConstant(expr parser.Expression) parser.Expression {
+	switch e := expr.(type) {
+	case *parser.CallExpression:
+		return e
+	case *parser.Identifier:
+		if sym := a.symbols.Resolve(e.Value); sym != nil && sym.Kind != SymConstant {
+			return e
+		}
+	case *parser.PrefixExpression:
+		return a.nonConstant(e.Right)
+	case *parser.InfixExpression:
+		if part := a.nonConstant(e.Left); part != nil {
+			return part
+		}
+		if part := a.nonConstant(e.Right); part != nil {
+			return part
+		}
+		if _, ok := a.constValue(e); !ok && e.Operator == "^" {
+			return e
+		}
+	}
+	return nil
+}
+
+// checkConstValue reports a CONST initializer that is not a constant
+// expression, which Go would reject
+func (a *Analyzer) checkConstValue(name *parser.Identifier, value parser.Expression) {
+	if part := a.nonConstant(value); part != nil {
+		a.errorWithHint(name.Token.Line, dberrors.InvalidConstant, "CONST %s is not a constant: %s",
+			"a CONST can use only literals, other CONSTs and operators; use DIM for a value worked out at run time",
+			name.Value, part.String())
+	}
+}
+
+// Constant returns the value the analyzer worked out for an expression, if
+// it recorded one: bounds and widths, and the results of ^
+func (st *SymbolTable) Constant(expr parser.Expression) (constant.Value, bool) {
+	v, ok := st.constants[expr]
+	return v, ok
+}
+
+// IntConstant returns the value of an integer constant expression: an
+// integer literal, or an expression of literals and CONSTs that the
+// analyzer worked out
+func (st *SymbolTable) IntConstant(expr parser.Expression) (int, bool) {
+	if n, ok := ArrayLowerBound(expr); ok {
+		return n, true
+	}
+	v, ok := st.constants[expr]
+	if !ok || v.Kind() != constant.Int {
+		return 0, false
+	}
+	n, _ := constant.Int64Val(v)
+	return int(n), true
+}
+
+// FixedStringWidth returns the width of a STRING * n type spec, which must
+// be a positive integer constant
+func (st *SymbolTable) FixedStringWidth(spec *parser.TypeSpec) (int, bool) {
+	if spec.Width == nil {
+		return 0, false
+	}
+	width, ok := st.IntConstant(spec.Width)
+	if !ok || width <= 0 {
+		return 0, false
+	}
+	return width, true
+}
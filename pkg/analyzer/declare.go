@@ -0,0 +1,163 @@
+package analyzer
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	dberrors "github.com/zditech/dbasic/pkg/errors"
+	"github.com/zditech/dbasic/pkg/lexer"
+	"github.com/zditech/dbasic/pkg/parser"
+)
+
+// DeclarationExt is the extension of a file of DECLARE lines that gives the
+// types of a Go package's members
+const DeclarationExt = ".dbasi"
+
+// declarationFile is a parsed .dbasi file
+type declarationFile struct {
+	name  string
+	decls []*parser.DeclareStatement
+}
+
+// declaringPackage is the imported package whose declarations are being
+// resolved, under the name the program imports it by
+type declaringPackage struct {
+	file    string
+	alias   string
+	path    string
+	members map[string]*Symbol
+}
+
+// DeclarationName returns the name of the .dbasi file for the Go package
+// with the given import path: the path with each / replaced by _, as in
+// net_http.dbasi
+func DeclarationName(importPath string) string {
+	return strings.ReplaceAll(importPath, "/", "_") + DeclarationExt
+}
+
+// Declare reads a .dbasi file, whose first DECLARE names the Go package it
+// declares. Once a program imports the package, calls into it are checked
+// against the declarations instead of accepting anything. It returns the
+// errors in the file; call it before Analyze, which reports errors in the
+// declarations of imported packages under the file's name.
+func (a *Analyzer) Declare(filename, source string) []string {
+	p := parser.New(lexer.New(source))
+	program := p.ParseProgram()
+	if len(p.Errors()) > 0 {
+		return p.Errors()
+	}
+
+	var path string
+	file := &declarationFile{name: filename}
+	for _, stmt := range program.Statements {
+		decl, ok := stmt.(*parser.DeclareStatement)
+		if !ok {
+			return []string{"only DECLARE lines belong in a declaration file, not " + stmt.TokenLiteral()}
+		}
+		if decl.Kind != "PACKAGE" {
+			file.decls = append(file.decls, decl)
+			continue
+		}
+		if path != "" {
+			return []string{"a declaration file declares one package"}
+		}
+		path = decl.Path
+	}
+	if path == "" {
+		return []string{`missing DECLARE PACKAGE "import/path"`}
+	}
+
+	if a.declarations == nil {
+		a.declarations = make(map[string]*declarationFile)
+	}
+	a.declarations[path] = file
+	return nil
+}
+
+// declarePackages resolves the declarations of the imported packages that
+// have them. Types come first so that signatures can use them.
+func (a *Analyzer) declarePackages() {
+	imports := a.symbols.AllImports()
+	var names []string
+	for name := range imports {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		info := imports[name]
+		file := a.declarations[info.Path]
+		if file == nil {
+			continue
+		}
+		a.declaring = &declaringPackage{file: file.name, alias: name, path: info.Path, members: make(map[string]*Symbol)}
+		for _, decl := range file.decls {
+			if decl.Kind == "TYPE" {
+				a.declareMember(decl, SymType, NewExternalType(name, decl.Name.Value, info.Path))
+			}
+		}
+		for _, decl := range file.decls {
+			switch decl.Kind {
+			case "CONST":
+				a.declareMember(decl, SymConstant, a.resolveTypeSpec(decl.Type))
+			case "DIM":
+				a.declareMember(decl, SymVariable, a.resolveTypeSpec(decl.Type))
+			case "SUB", "FUNCTION":
+				paramTypes, variadicType := a.resolveParamTypes(decl.Params)
+				kind, t := SymSub, NewSubType(paramTypes)
+				if decl.Kind == "FUNCTION" {
+					kind, t = SymFunction, NewFunctionType(paramTypes, a.resolveReturnTypes(decl.ReturnTypes))
+				}
+				if variadicType != nil {
+					t.Variadic = true
+					t.VariadicType = variadicType
+				}
+				a.declareMember(decl, kind, t)
+			}
+		}
+		info.Members = a.declaring.members
+		a.declaring = nil
+	}
+}
+
+// declareMember adds a member to the package being declared
+func (a *Analyzer) declareMember(decl *parser.DeclareStatement, kind SymbolKind, t *Type) {
+	name := decl.Name.Value
+	if a.declaring.members[name] != nil {
+		a.error(decl.Token.Line, dberrors.DuplicateDefinition, "duplicate declaration: %s", name)
+		return
+	}
+	a.declaring.members[name] = &Symbol{Name: name, Kind: kind, Type: t, Node: decl, GoName: a.declaring.alias + "." + name}
+}
+
+// packageOf returns the imported Go package named by expr, or nil if expr
+// is not a package or the package has no declarations
+func (a *Analyzer) packageOf(expr parser.Expression) *ImportInfo {
+	ident, ok := expr.(*parser.Identifier)
+	if !ok || a.symbols.Resolve(ident.Value) != nil {
+		return nil
+	}
+	if info := a.symbols.GetImport(ident.Value); info != nil && info.Members != nil {
+		return info
+	}
+	return nil
+}
+
+// packageMember resolves a qualified reference to a member of a declared
+// Go package, which may be a type when it is called to convert a value.
+// Unlike DBasic names, Go names are case sensitive.
+func (a *Analyzer) packageMember(pkgName string, pkg *ImportInfo, member *parser.Identifier) *Symbol {
+	if sym := pkg.Members[member.Value]; sym != nil {
+		return sym
+	}
+	for name := range pkg.Members {
+		if strings.EqualFold(name, member.Value) {
+			a.errorWithHint(member.Token.Line, dberrors.UnknownMember, "package %s has no member %s",
+				fmt.Sprintf("Go names are case sensitive; did you mean %s.%s?", pkgName, name), pkgName, member.Value)
+			return nil
+		}
+	}
+	a.error(member.Token.Line, dberrors.UnknownMember, "package %s has no member %s", pkgName, member.Value)
+	return nil
+}
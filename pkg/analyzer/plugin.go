@@ -0,0 +1,38 @@
+package analyzer
+
+import (
+	dberrors "github.com/zditech/dbasic/pkg/errors"
+	"github.com/zditech/dbasic/pkg/plugin"
+)
+
+// AddPlugin adds the builtins of a plugin. Call it before Analyze, which
+// resolves their signatures and reports errors in them under the plugin's
+// file names. Only builtin types may appear in the signatures.
+func (a *Analyzer) AddPlugin(p *plugin.Plugin) {
+	a.plugins = append(a.plugins, p)
+}
+
+// declareBuiltins defines the builtins of the plugins as global functions
+// that generate calls to the plugins' Go functions
+func (a *Analyzer) declareBuiltins() {
+	for _, p := range a.plugins {
+		for _, b := range p.Builtins {
+			a.declaring = &declaringPackage{file: b.File}
+			decl := b.Decl
+			paramTypes, variadicType := a.resolveParamTypes(decl.Params)
+			t := NewSubType(paramTypes)
+			if decl.Kind == "FUNCTION" {
+				t = NewFunctionType(paramTypes, a.resolveReturnTypes(decl.ReturnTypes))
+			}
+			if variadicType != nil {
+				t.Variadic = true
+				t.VariadicType = variadicType
+			}
+			sym := &Symbol{Name: decl.Name.Value, Kind: SymFunction, Type: t, Node: decl, GoName: b.Name}
+			if a.symbols.DefineGlobal(sym) != nil {
+				a.error(decl.Token.Line, dberrors.DuplicateDefinition, "builtin %s is already defined", decl.Name.Value)
+			}
+			a.declaring = nil
+		}
+	}
+}
@@ -0,0 +1,169 @@
+package analyzer
+
+import (
+	"strings"
+
+	dberrors "github.com/zditech/dbasic/pkg/errors"
+	"github.com/zditech/dbasic/pkg/parser"
+)
+
+// checkReturnValues checks the values of a RETURN against the results of
+// the enclosing FUNCTION, METHOD or PROPERTY GET. A RETURN without values
+// leaves a SUB or returns from a GOSUB, so only RETURNs with values are
+// checked.
+func (a *Analyzer) checkReturnValues(stmt *parser.ReturnStatement, types []*Type) {
+	if len(stmt.Values) == 0 {
+		return
+	}
+	if a.results == nil {
+		a.errorWithHint(stmt.Token.Line, dberrors.InvalidReturn, "RETURN with a value outside a FUNCTION",
+			"a SUB returns nothing: use RETURN alone to leave it, or make it a FUNCTION with AS type")
+		return
+	}
+	if len(stmt.Values) == 1 && len(a.results) > 1 {
+		// RETURN F(x) passes on all the results of F, which Go checks
+		if _, ok := stmt.Values[0].(*parser.CallExpression); ok {
+			return
+		}
+	}
+	if len(stmt.Values) != len(a.results) {
+		a.error(stmt.Token.Line, dberrors.InvalidReturn, "RETURN has %d value(s), but the FUNCTION returns %d",
+			len(stmt.Values), len(a.results))
+		return
+	}
+	for i, result := range a.results {
+		switch {
+		case result.Kind == TypeExternal || types[i].Kind == TypeExternal:
+			// Go checks values of the types of Go packages
+		case result.IsCompatibleWith(types[i]):
+			a.checkInterfaceValue(stmt.Token.Line, result, types[i])
+		case len(a.results) == 1:
+			a.error(stmt.Token.Line, dberrors.TypeMismatch, "type mismatch in RETURN: cannot return %s as %s",
+				types[i].String(), result.String())
+		default:
+			a.error(stmt.Token.Line, dberrors.TypeMismatch, "type mismatch in RETURN at position %d: cannot return %s as %s",
+				i+1, types[i].String(), result.String())
+		}
+	}
+}
+
+// checkReturnPaths reports a routine with results whose body can run to
+// its end without a RETURN. A routine that traps errors needs no RETURN at
+// the end: its handler returns zero values.
+func (a *Analyzer) checkReturnPaths(line int, what string, body *parser.BlockStatement) {
+	if body == nil || ErrorHandlerIndex(body) >= 0 {
+		return
+	}
+	n := len(body.Statements)
+	// Running into a GOSUB subroutine is an error, so it ends the main flow
+	if n > 0 && SubroutineAt(a.symbols.Subroutines(body), n-1) != nil {
+		return
+	}
+	if !terminates(body) {
+		a.errorWithHint(line, dberrors.InvalidReturn, "%s can reach its end without a RETURN",
+			"end every path with RETURN: give each IF an ELSE and each SELECT CASE a CASE ELSE that returns", what)
+	}
+}
+
+// terminates reports whether a block never runs past its end, because its
+// last statement returns, jumps away, raises an error or panics. The rules
+// are those of Go for terminating statements, which the generated code must
+// satisfy.
+func terminates(block *parser.BlockStatement) bool {
+	if block == nil || len(block.Statements) == 0 {
+		return false
+	}
+	switch s := block.Statements[len(block.Statements)-1].(type) {
+	case *parser.ReturnStatement:
+		return len(s.Values) > 0
+	case *parser.GotoStatement, *parser.ErrorStatement:
+		return true
+	case *parser.ExpressionStatement:
+		// Go counts a call of panic as terminating
+		call, ok := s.Expression.(*parser.CallExpression)
+		if !ok {
+			return false
+		}
+		ident, ok := call.Function.(*parser.Identifier)
+		return ok && strings.EqualFold(ident.Value, "PANIC")
+	case *parser.IfStatement:
+		if s.Alternative == nil || !terminates(s.Consequence) {
+			return false
+		}
+		for _, elseIf := range s.ElseIfs {
+			if !terminates(elseIf.Consequence) {
+				return false
+			}
+		}
+		return terminates(s.Alternative)
+	case *parser.SelectStatement:
+		if s.Default == nil || !terminates(s.Default) {
+			return false
+		}
+		for _, c := range s.Cases {
+			if !terminates(c.Body) {
+				return false
+			}
+		}
+		return true
+	case *parser.SelectChannelStatement:
+		if s.Default != nil && !terminates(s.Default) {
+			return false
+		}
+		for _, c := range s.Cases {
+			if !terminates(c.Body) {
+				return false
+			}
+		}
+		return true
+	case *parser.DoLoopStatement:
+		// Only a DO without a condition can run forever
+		return s.Condition == nil && !exitsLoop(s.Body)
+	case *parser.WithStatement:
+		return terminates(s.Body)
+	}
+	return false
+}
+
+// exitsLoop reports whether a loop body has an EXIT FOR, WHILE or DO that
+// leaves the loop rather than one nested in it
+func exitsLoop(block *parser.BlockStatement) bool {
+	if block == nil {
+		return false
+	}
+	for _, stmt := range block.Statements {
+		var inner []*parser.BlockStatement
+		switch s := stmt.(type) {
+		case *parser.ExitStatement:
+			switch s.ExitType {
+			case "FOR", "WHILE", "DO":
+				return true
+			}
+		case *parser.IfStatement:
+			inner = append(inner, s.Consequence, s.Alternative)
+			for _, elseIf := range s.ElseIfs {
+				inner = append(inner, elseIf.Consequence)
+			}
+		case *parser.SelectStatement:
+			inner = append(inner, s.Default)
+			for _, c := range s.Cases {
+				inner = append(inner, c.Body)
+			}
+		case *parser.SelectChannelStatement:
+			inner = append(inner, s.Default)
+			for _, c := range s.Cases {
+				inner = append(inner, c.Body)
+			}
+		case *parser.WithStatement:
+			inner = append(inner, s.Body)
+		case *parser.LockStatement:
+			inner = append(inner, s.Body)
+		}
+		for _, b := range inner {
+			if exitsLoop(b) {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -1,6 +1,7 @@
 package analyzer
 
 import (
+	"go/constant"
 	"strings"
 
 	"github.com/zditech/dbasic/pkg/parser"
@@ -17,6 +18,8 @@ const (
 	SymParameter
 	SymLabel
 	SymImport
+	SymModule
+	SymType // A type declared by a Go package's .dbasi file
 )
 
 // Symbol represents a symbol in the symbol table
@@ -28,7 +31,20 @@ type Symbol struct {
 	Scope      *Scope
 	IsByRef    bool          // For parameters passed by reference
 	IsExported bool          // For Go package interop
-	GoName     string        // The Go identifier name (for imports)
+	GoName     string        // The Go identifier name (for imports, STATIC locals and module members)
+	Members    *Scope        // For modules: the module's own scope
+
+	// Decl is the name where the symbol is declared; nil for builtins
+	Decl *parser.Identifier
+
+	// Value is the value of a constant, if it is known at compile time
+	Value constant.Value
+}
+
+// Reference is an identifier in the program and the symbol it names
+type Reference struct {
+	Ident  *parser.Identifier
+	Symbol *Symbol
 }
 
 // Scope represents a scope in the symbol table
@@ -115,12 +131,26 @@ type SymbolTable struct {
 	GlobalScope  *Scope
 	CurrentScope *Scope
 	imports      map[string]*ImportInfo
+	subroutines  map[*parser.BlockStatement][]*Subroutine
+	constants    map[parser.Expression]constant.Value
+}
+
+// Subroutine is the part of a routine body that GOSUB runs: the top-level
+// statements from its label to the first top-level RETURN after it
+type Subroutine struct {
+	Label string // Name of the label, as declared
+	Start int    // Position of the label in the routine body
+	End   int    // Position of the RETURN
 }
 
 // ImportInfo stores information about an imported package
 type ImportInfo struct {
 	Path  string
 	Alias string
+
+	// Members are the package's members by Go name, when a .dbasi file
+	// declares them; nil leaves uses of the package unchecked
+	Members map[string]*Symbol
 }
 
 // NewSymbolTable creates a new symbol table
@@ -130,6 +160,8 @@ func NewSymbolTable() *SymbolTable {
 		GlobalScope:  global,
 		CurrentScope: global,
 		imports:      make(map[string]*ImportInfo),
+		subroutines:  make(map[*parser.BlockStatement][]*Subroutine),
+		constants:    make(map[parser.Expression]constant.Value),
 	}
 }
 
@@ -185,6 +217,12 @@ func (st *SymbolTable) AllImports() map[string]*ImportInfo {
 	return st.imports
 }
 
+// Subroutines returns the GOSUB subroutines of a SUB, FUNCTION or METHOD
+// body, ordered by position
+func (st *SymbolTable) Subroutines(body *parser.BlockStatement) []*Subroutine {
+	return st.subroutines[body]
+}
+
 // IsGlobalScope returns true if currently in global scope
 func (st *SymbolTable) IsGlobalScope() bool {
 	return st.CurrentScope == st.GlobalScope
@@ -27,8 +27,23 @@ const (
 	TypeError     // For Go error type
 	TypeFunction
 	TypeSub
-	TypeStruct    // User-defined struct type
-	TypeExternal  // External Go type (e.g., tea.Cmd)
+	TypeStruct      // User-defined struct type
+	TypeExternal    // External Go type (e.g., tea.Cmd)
+	TypeMap         // Typed dictionary (MAP OF K TO V)
+	TypeInterface   // User-defined interface type
+	TypeDateTime    // Point in time (Go time.Time)
+	TypeDuration    // Span of time (Go time.Duration)
+	TypeMutex       // Mutual exclusion lock (Go sync.Mutex)
+	TypeContext     // Cancellation scope (Go context.Context)
+	TypeConnection  // Network connection (Go net.Conn)
+	TypeListener    // Server socket accepting connections (Go net.Listener)
+	TypeDatabase    // SQL database (Go *sql.DB)
+	TypeTransaction // SQL transaction (Go *sql.Tx)
+	TypeKVStore     // File-backed key-value store
+	TypeImage       // Picture held in memory (Go *image.RGBA)
+	TypeStack       // Last-in, first-out container (STACK OF T)
+	TypeQueue       // First-in, first-out container (QUEUE OF T)
+	TypeSet         // Container of distinct values (SET OF T)
 )
 
 // StructField represents a field in a struct type
@@ -37,19 +52,41 @@ type StructField struct {
 	Type *Type
 }
 
+// StructProperty represents a PROPERTY declared on a struct type. Reading it
+// calls the GET accessor and assigning it calls the SET accessor.
+type StructProperty struct {
+	Name   string
+	Type   *Type
+	HasGet bool
+	HasSet bool
+}
+
+// InterfaceMethod represents a method signature in an interface type
+type InterfaceMethod struct {
+	Name string
+	Type *Type // Function type describing parameters and returns
+}
+
 // Type represents a DBasic type
 type Type struct {
 	Kind         TypeKind
 	Name         string         // Original type name
-	ElementType  *Type          // For pointers, channels, arrays
+	ElementType  *Type          // For pointers, channels, arrays, map values
+	KeyType      *Type          // For map keys
 	ArraySize    int            // For fixed-size arrays (-1 for dynamic)
+	LowerBound   int            // First index of arrays declared DIM a(lower TO upper)
 	ParamTypes   []*Type        // For function/sub types
 	ReturnTypes  []*Type        // For function types
 	Fields       []*StructField // For struct types
+	Properties   []*StructProperty // For struct types: PROPERTY GET/SET accessors
+	Methods      []*InterfaceMethod // For interface types
 	Implements   string         // Go interface this type implements (e.g., "tea.Model")
 	PackagePath  string         // For external types: the full import path
 	PackageAlias string         // For external types: the alias used in code (e.g., "tea")
 	Variadic     bool           // True if function accepts variable arguments
+	VariadicType *Type          // Element type of extra arguments (nil if unchecked)
+	Width        int            // For STRING * n: the fixed length (0 for other strings)
+	Embedded     []string       // For struct types: names of EMBEDded types
 }
 
 // Predefined types
@@ -65,6 +102,18 @@ var (
 	VoidType    = &Type{Kind: TypeVoid, Name: "VOID"}
 	AnyType     = &Type{Kind: TypeAny, Name: "ANY"}
 	ErrorType   = &Type{Kind: TypeError, Name: "ERROR"}
+
+	DateTimeType = &Type{Kind: TypeDateTime, Name: "DATETIME"}
+	DurationType = &Type{Kind: TypeDuration, Name: "DURATION"}
+	MutexType    = &Type{Kind: TypeMutex, Name: "MUTEX"}
+	ContextType  = &Type{Kind: TypeContext, Name: "CONTEXT"}
+
+	ConnectionType  = &Type{Kind: TypeConnection, Name: "CONNECTION"}
+	ListenerType    = &Type{Kind: TypeListener, Name: "LISTENER"}
+	DatabaseType    = &Type{Kind: TypeDatabase, Name: "DATABASE"}
+	TransactionType = &Type{Kind: TypeTransaction, Name: "TRANSACTION"}
+	KVStoreType     = &Type{Kind: TypeKVStore, Name: "KVSTORE"}
+	ImageType       = &Type{Kind: TypeImage, Name: "IMAGE"}
 )
 
 // TypeFromName returns a Type for the given type name
@@ -92,6 +141,26 @@ func TypeFromName(name string) *Type {
 		return AnyType
 	case "ERROR":
 		return ErrorType
+	case "DATETIME":
+		return DateTimeType
+	case "DURATION":
+		return DurationType
+	case "MUTEX":
+		return MutexType
+	case "CONTEXT":
+		return ContextType
+	case "CONNECTION":
+		return ConnectionType
+	case "LISTENER":
+		return ListenerType
+	case "DATABASE":
+		return DatabaseType
+	case "TRANSACTION":
+		return TransactionType
+	case "KVSTORE":
+		return KVStoreType
+	case "IMAGE":
+		return ImageType
 	default:
 		return nil
 	}
@@ -125,6 +194,12 @@ func NewArrayType(elem *Type, size int) *Type {
 	}
 }
 
+// NewFixedStringType creates a STRING * width type, whose values are padded
+// with spaces or truncated to width bytes when assigned
+func NewFixedStringType(width int) *Type {
+	return &Type{Kind: TypeString, Name: fmt.Sprintf("STRING * %d", width), Width: width}
+}
+
 // NewSliceType creates a new slice type (dynamic array)
 func NewSliceType(elem *Type) *Type {
 	return &Type{
@@ -135,6 +210,37 @@ func NewSliceType(elem *Type) *Type {
 	}
 }
 
+// containerKinds maps the names of the container types to their kinds
+var containerKinds = map[string]TypeKind{
+	"STACK": TypeStack,
+	"QUEUE": TypeQueue,
+	"SET":   TypeSet,
+}
+
+// NewContainerType creates a STACK, QUEUE or SET type of elem
+func NewContainerType(name string, elem *Type) *Type {
+	return &Type{
+		Kind:        containerKinds[name],
+		Name:        name,
+		ElementType: elem,
+	}
+}
+
+// IsContainer reports whether t is a STACK, QUEUE or SET
+func (t *Type) IsContainer() bool {
+	return t.Kind == TypeStack || t.Kind == TypeQueue || t.Kind == TypeSet
+}
+
+// NewMapType creates a new map type
+func NewMapType(key, value *Type) *Type {
+	return &Type{
+		Kind:        TypeMap,
+		Name:        "MAP OF " + key.String() + " TO " + value.String(),
+		KeyType:     key,
+		ElementType: value,
+	}
+}
+
 // NewFunctionType creates a new function type
 func NewFunctionType(params []*Type, returns []*Type) *Type {
 	return &Type{
@@ -181,6 +287,15 @@ func NewStructType(name string, fields []*StructField) *Type {
 	}
 }
 
+// NewInterfaceType creates a new interface type
+func NewInterfaceType(name string, methods []*InterfaceMethod) *Type {
+	return &Type{
+		Kind:    TypeInterface,
+		Name:    name,
+		Methods: methods,
+	}
+}
+
 // NewExternalType creates a new external Go type
 func NewExternalType(alias, typeName, packagePath string) *Type {
 	return &Type{
@@ -218,6 +333,70 @@ func (r *TypeRegistry) All() map[string]*Type {
 	return r.types
 }
 
+// StructMember is a field or property found by TypeRegistry.Member
+type StructMember struct {
+	Type     *Type           // Field or property type
+	Property *StructProperty // Set for a property
+	Owner    *Type           // Struct that declares the member
+}
+
+// Member looks up field or property name of struct type t. Like Go, the
+// members of EMBEDded types are promoted: an embedded type is a field named
+// after it, and its members are searched when t lacks the name. When the
+// name is not found, external reports whether t embeds a Go type that may
+// provide it.
+func (r *TypeRegistry) Member(t *Type, name string) (member *StructMember, external bool) {
+	return r.member(t, name, make(map[*Type]bool))
+}
+
+func (r *TypeRegistry) member(t *Type, name string, seen map[*Type]bool) (*StructMember, bool) {
+	seen[t] = true
+	for _, f := range t.Fields {
+		if strings.EqualFold(f.Name, name) {
+			return &StructMember{Type: f.Type, Owner: t}, false
+		}
+	}
+	if prop := t.Property(name); prop != nil {
+		return &StructMember{Type: prop.Type, Property: prop, Owner: t}, false
+	}
+
+	external := false
+	for _, embed := range t.Embedded {
+		inner := r.Lookup(embed)
+		if strings.EqualFold(embed[strings.LastIndex(embed, ".")+1:], name) {
+			if inner == nil {
+				// An embedded Go type
+				return &StructMember{Type: AnyType, Owner: t}, false
+			}
+			return &StructMember{Type: inner, Owner: t}, false
+		}
+		if inner == nil || inner.Kind != TypeStruct {
+			external = true
+			continue
+		}
+		if seen[inner] {
+			continue
+		}
+		member, ext := r.member(inner, name, seen)
+		if member != nil {
+			return member, false
+		}
+		external = external || ext
+	}
+	return nil, external
+}
+
+// EmbeddedStructs returns the DBasic struct types that t EMBEDs directly
+func (r *TypeRegistry) EmbeddedStructs(t *Type) []*Type {
+	var structs []*Type
+	for _, embed := range t.Embedded {
+		if inner := r.Lookup(embed); inner != nil && inner.Kind == TypeStruct {
+			structs = append(structs, inner)
+		}
+	}
+	return structs
+}
+
 // String returns the string representation of the type
 func (t *Type) String() string {
 	if t == nil {
@@ -233,6 +412,10 @@ func (t *Type) String() string {
 		return fmt.Sprintf("%s(%d)", t.ElementType.String(), t.ArraySize)
 	case TypeSlice:
 		return t.ElementType.String() + "()"
+	case TypeMap:
+		return "MAP OF " + t.KeyType.String() + " TO " + t.ElementType.String()
+	case TypeStack, TypeQueue, TypeSet:
+		return t.Name + " OF " + t.ElementType.String()
 	case TypeFunction:
 		var params, rets []string
 		for _, p := range t.ParamTypes {
@@ -286,13 +469,61 @@ func (t *Type) GoType() string {
 		return fmt.Sprintf("[%d]%s", t.ArraySize, t.ElementType.GoType())
 	case TypeSlice:
 		return "[]" + t.ElementType.GoType()
+	case TypeMap:
+		return "map[" + t.KeyType.GoType() + "]" + t.ElementType.GoType()
+	case TypeStack:
+		return "*Stack[" + t.ElementType.GoType() + "]"
+	case TypeQueue:
+		return "*Queue[" + t.ElementType.GoType() + "]"
+	case TypeSet:
+		return "*Set[" + t.ElementType.GoType() + "]"
+	case TypeFunction, TypeSub:
+		var params, rets []string
+		for _, p := range t.ParamTypes {
+			params = append(params, p.GoType())
+		}
+		if t.Variadic {
+			params = append(params, "..."+t.VariadicType.GoType())
+		}
+		for _, r := range t.ReturnTypes {
+			rets = append(rets, r.GoType())
+		}
+		sig := "func(" + strings.Join(params, ", ") + ")"
+		switch len(rets) {
+		case 0:
+			return sig
+		case 1:
+			return sig + " " + rets[0]
+		default:
+			return sig + " (" + strings.Join(rets, ", ") + ")"
+		}
 	case TypeVoid:
 		return ""
 	case TypeAny:
 		return "interface{}"
 	case TypeError:
 		return "error"
-	case TypeStruct:
+	case TypeDateTime:
+		return "time.Time"
+	case TypeDuration:
+		return "time.Duration"
+	case TypeMutex:
+		return "sync.Mutex"
+	case TypeContext:
+		return "context.Context"
+	case TypeConnection:
+		return "net.Conn"
+	case TypeListener:
+		return "net.Listener"
+	case TypeDatabase:
+		return "*sql.DB"
+	case TypeTransaction:
+		return "*sql.Tx"
+	case TypeKVStore:
+		return "*kvStore"
+	case TypeImage:
+		return "*image.RGBA"
+	case TypeStruct, TypeInterface:
 		return t.Name
 	case TypeExternal:
 		return t.Name  // e.g., "tea.Cmd"
@@ -331,9 +562,24 @@ func (t *Type) IsCompatibleWith(other *Type) bool {
 		if t.Kind == TypePointer || t.Kind == TypeChannel {
 			return t.ElementType.IsCompatibleWith(other.ElementType)
 		}
-		if t.Kind == TypeArray || t.Kind == TypeSlice {
+		if t.Kind == TypeArray || t.Kind == TypeSlice || t.IsContainer() {
 			return t.ElementType.IsCompatibleWith(other.ElementType)
 		}
+		if t.Kind == TypeMap {
+			return t.KeyType.IsCompatibleWith(other.KeyType) &&
+				t.ElementType.IsCompatibleWith(other.ElementType)
+		}
+		if t.Kind == TypeFunction || t.Kind == TypeSub {
+			return t.signatureMatches(other)
+		}
+		if t.Kind == TypeInterface {
+			return t.MissingMethod(other) == "" || other.MissingMethod(t) == ""
+		}
+		return true
+	}
+
+	// Structs can be stored in interfaces; the analyzer checks their method sets
+	if t.Kind == TypeInterface && other.isStructValue() || other.Kind == TypeInterface && t.isStructValue() {
 		return true
 	}
 
@@ -350,6 +596,103 @@ func (t *Type) IsCompatibleWith(other *Type) bool {
 	return false
 }
 
+// TimeResultType returns the type of "left op right" when an operand is a
+// DATETIME or DURATION, or nil if the operator does not apply to them.
+// DATETIME +/- DURATION moves a point in time, DATETIME - DATETIME gives the
+// DURATION between them, and DURATIONs can be scaled by numbers.
+func TimeResultType(op string, left, right *Type) *Type {
+	lk, rk := left.Kind, right.Kind
+	switch op {
+	case "=", "<>", "<", ">", "<=", ">=":
+		if lk == rk && (lk == TypeDateTime || lk == TypeDuration) {
+			return BooleanType
+		}
+	case "+":
+		if lk == TypeDateTime && rk == TypeDuration || lk == TypeDuration && rk == TypeDateTime {
+			return DateTimeType
+		}
+		if lk == TypeDuration && rk == TypeDuration {
+			return DurationType
+		}
+	case "-":
+		switch {
+		case lk == TypeDateTime && rk == TypeDuration:
+			return DateTimeType
+		case lk == TypeDateTime && rk == TypeDateTime, lk == TypeDuration && rk == TypeDuration:
+			return DurationType
+		}
+	case "*":
+		if lk == TypeDuration && right.IsNumeric() || left.IsNumeric() && rk == TypeDuration {
+			return DurationType
+		}
+	case "/":
+		if lk == TypeDuration && right.IsNumeric() {
+			return DurationType
+		}
+		if lk == TypeDuration && rk == TypeDuration {
+			return DoubleType
+		}
+	}
+	return nil
+}
+
+// isStructValue returns true for struct types and pointers to structs
+func (t *Type) isStructValue() bool {
+	if t.Kind == TypePointer && t.ElementType != nil {
+		return t.ElementType.Kind == TypeStruct
+	}
+	return t.Kind == TypeStruct
+}
+
+// Property returns the property of a struct type with the given name, or nil
+func (t *Type) Property(name string) *StructProperty {
+	for _, prop := range t.Properties {
+		if strings.EqualFold(prop.Name, name) {
+			return prop
+		}
+	}
+	return nil
+}
+
+// MissingMethod returns the name of the first method of interface t that
+// interface other lacks (or has with a different signature), or "" if none.
+// Signatures must match exactly, as Go requires for interface satisfaction.
+func (t *Type) MissingMethod(other *Type) string {
+	for _, m := range t.Methods {
+		found := false
+		for _, om := range other.Methods {
+			if strings.EqualFold(m.Name, om.Name) && m.Type.GoType() == om.Type.GoType() {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return m.Name
+		}
+	}
+	return ""
+}
+
+// signatureMatches checks that two function/sub types take and return the same types
+func (t *Type) signatureMatches(other *Type) bool {
+	if t.Variadic != other.Variadic ||
+		len(t.ParamTypes) != len(other.ParamTypes) ||
+		len(t.ReturnTypes) != len(other.ReturnTypes) {
+		return false
+	}
+	for i, p := range t.ParamTypes {
+		if !p.IsCompatibleWith(other.ParamTypes[i]) {
+			return false
+		}
+	}
+	for i, r := range t.ReturnTypes {
+		if !r.IsCompatibleWith(other.ReturnTypes[i]) {
+			return false
+		}
+	}
+	return true
+}
+
 // PromoteNumeric returns the promoted type for numeric operations
 func PromoteNumeric(t1, t2 *Type) *Type {
 	if !t1.IsNumeric() || !t2.IsNumeric() {
@@ -396,6 +739,16 @@ func (t *Type) DefaultValue() string {
 		return "nil"
 	case TypeSlice:
 		return "nil"
+	case TypeMap:
+		return "make(" + t.GoType() + ")"
+	case TypeStack, TypeQueue, TypeSet:
+		return "&" + strings.TrimPrefix(t.GoType(), "*") + "{}"
+	case TypeDateTime:
+		return "time.Time{}"
+	case TypeDuration:
+		return "0"
+	case TypeMutex:
+		return "sync.Mutex{}"
 	default:
 		return "nil"
 	}
@@ -0,0 +1,126 @@
+package analyzer
+
+import (
+	"fmt"
+	"sort"
+
+	dberrors "github.com/zditech/dbasic/pkg/errors"
+	"github.com/zditech/dbasic/pkg/lexer"
+	"github.com/zditech/dbasic/pkg/parser"
+)
+
+// Warning is something vet found that compiles but is probably a mistake
+type Warning struct {
+	Line    int
+	Code    string // One of the warning codes in package errors
+	Message string
+	Hint    string
+}
+
+// SetVet enables or disables vet checks, which Analyze reports as Warnings
+// instead of errors
+func (a *Analyzer) SetVet(enabled bool) {
+	a.vet = enabled
+}
+
+// Warnings returns what vet found, in order of line
+func (a *Analyzer) Warnings() []Warning {
+	sort.SliceStable(a.warnings, func(i, j int) bool {
+		return a.warnings[i].Line < a.warnings[j].Line
+	})
+	return a.warnings
+}
+
+func (a *Analyzer) warn(line int, code string, hint string, format string, args ...interface{}) {
+	a.warnings = append(a.warnings, Warning{Line: line, Code: code, Message: fmt.Sprintf(format, args...), Hint: hint})
+}
+
+// vetCondition looks for = in an IF condition that reads like an
+// assignment: a = b = c, which compares a = b with c, and flag = F(x) for
+// a BOOLEAN flag, which compares the flag with the result instead of
+// storing it
+func (a *Analyzer) vetCondition(line int, cond parser.Expression) {
+	eq, ok := cond.(*parser.InfixExpression)
+	if !a.vet || !ok || eq.Operator != "=" {
+		return
+	}
+	if left, ok := eq.Left.(*parser.InfixExpression); ok && left.Operator == "=" {
+		a.warn(line, dberrors.ConditionAssigns, "to assign, do it on a line of its own before the IF",
+			"condition compares %s with %s; = in a condition never assigns", left.String(), eq.Right.String())
+		return
+	}
+	ident, ok := eq.Left.(*parser.Identifier)
+	if _, isCall := eq.Right.(*parser.CallExpression); !ok || !isCall {
+		return
+	}
+	if sym := a.symbols.Resolve(ident.Value); sym != nil && sym.Kind == SymVariable && sym.Type != nil && sym.Type.Kind == TypeBoolean {
+		a.warn(line, dberrors.ConditionAssigns, fmt.Sprintf("to keep the result, assign it before the IF: %s = %s", ident.Value, eq.Right.String()),
+			"condition compares %s with %s; = in a condition never assigns", ident.Value, eq.Right.String())
+	}
+}
+
+// vetSpawn flags SPAWN of a FUNCTION, whose results are thrown away
+func (a *Analyzer) vetSpawn(stmt *parser.SpawnStatement, result *Type) {
+	if !a.vet || result == nil || result.Kind == TypeVoid || result.Kind == TypeAny {
+		return
+	}
+	a.warn(stmt.Token.Line, dberrors.SpawnDiscardsResult, "SPAWN a SUB that sends the result on a channel, or call the FUNCTION directly",
+		"SPAWN discards the result of %s", stmt.Call.Function.String())
+}
+
+// vetPrinted records the variables a PRINT prints directly, for
+// vetUnassigned
+func (a *Analyzer) vetPrinted(stmt *parser.PrintStatement) {
+	if !a.vet {
+		return
+	}
+	for _, val := range stmt.Values {
+		if ident, ok := val.(*parser.Identifier); ok {
+			if a.printed == nil {
+				a.printed = make(map[*parser.Identifier]bool)
+			}
+			a.printed[ident] = true
+		}
+	}
+}
+
+// vetUnassigned flags variables declared by DIM without a value that are
+// printed but never used otherwise, so always print their zero value. Any
+// other use, such as an assignment or an argument that may be BYREF,
+// counts as setting the variable.
+func (a *Analyzer) vetUnassigned() {
+	if !a.vet {
+		return
+	}
+	firstPrint := make(map[*Symbol]*parser.Identifier)
+	used := make(map[*Symbol]bool)
+	var order []*Symbol
+	for _, ref := range a.refs {
+		sym := ref.Symbol
+		if ref.Ident == sym.Decl || sym.Kind != SymVariable {
+			continue
+		}
+		if !a.printed[ref.Ident] {
+			used[sym] = true
+			continue
+		}
+		if firstPrint[sym] == nil {
+			firstPrint[sym] = ref.Ident
+			order = append(order, sym)
+		}
+	}
+	for _, sym := range order {
+		// INPUT name AS TYPE declares its variable with a DIM of its own
+		dim, ok := sym.Node.(*parser.DimStatement)
+		if used[sym] || !ok || dim.Token.Type == lexer.TOKEN_INPUT || dim.Value != nil || dim.ArraySize != nil || sym.Type == nil {
+			continue
+		}
+		switch sym.Type.Kind {
+		case TypeString, TypeInteger, TypeLong, TypeSingle, TypeDouble, TypeBoolean:
+		default:
+			continue
+		}
+		a.warn(firstPrint[sym].Token.Line, dberrors.PrintNeverAssigned, fmt.Sprintf("give %s a value with DIM %s AS %s = ..., or assign it before the PRINT", sym.Name, sym.Name, sym.Type.String()),
+			"PRINT of %s, which is never assigned", sym.Name)
+	}
+}
@@ -2,10 +2,18 @@ package codegen
 
 import (
 	"fmt"
+	"go/constant"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
 	"strings"
+	"unicode"
+	"unicode/utf8"
 
 	"github.com/zditech/dbasic/pkg/analyzer"
 	"github.com/zditech/dbasic/pkg/parser"
+	"github.com/zditech/dbasic/pkg/plugin"
 )
 
 // Generator generates Go code from a DBasic AST
@@ -21,8 +29,79 @@ type Generator struct {
 	hasMain         bool
 	labelCount      int
 	debugMode       bool
+	releaseMode     bool              // Leave out ASSERT checks
 	sourceFile      string
 	currentFunc     string            // Current function/sub name for error context
+	withTargets     []*withTarget     // Enclosing WITH targets, innermost last
+	withCount       int
+	selectCount     int
+	staticVars      []string          // Package-level declarations of STATIC locals
+	loops           []*loopContext    // Enclosing loops, innermost last
+	selectDepth     int               // Number of enclosing SELECT CHANNEL blocks
+	trap            *errorTrap        // ON ERROR GOTO state of the routine being generated
+	unicode         bool              // OPTION UNICODE: string functions count runes, not bytes
+	hoisted         map[*parser.DimStatement]bool // DIMs declared at the start of their routine
+	subroutines     []*analyzer.Subroutine // GOSUB subroutines of the routine being generated
+	gosub           bool              // Generating a GOSUB subroutine, where RETURN goes back
+	results         []*parser.TypeSpec // Return types of the routine being generated
+	testMode        bool              // Generate TEST blocks, for dbasic test
+	library         string            // Package name, when generating a library
+	tests           []TestCase        // TEST blocks generated so far
+	benchmarks      []TestCase        // BENCHMARK blocks generated so far
+	embeds          []Embed           // EMBEDFILE and EMBEDDIR statements generated so far
+	sourceMap       func(line int) (string, int) // Maps a compiled line to its file and line
+	lines           []LineMapping                // Source line of each statement line, once generated
+	plugins         []*plugin.Plugin             // Plugins whose builtins the program may call
+	usedPlugins     map[*plugin.Plugin]bool      // Plugins whose source to embed
+}
+
+// LineMapping ties a line of the generated Go code to the line of DBasic
+// source it was generated from
+type LineMapping struct {
+	GoLine int
+	File   string
+	Line   int
+}
+
+// TestCase is a TEST or BENCHMARK block generated in test mode, and the Go
+// test or benchmark that runs it
+type TestCase struct {
+	Name   string // Name given after TEST or BENCHMARK
+	GoName string // Name of the Go test or benchmark function
+	File   string // File and line of the TEST block
+	Line   int
+}
+
+// Embed is a file or directory that the generated code embeds with
+// go:embed. It must be copied to Target, in the directory the code is
+// built in, first.
+type Embed struct {
+	Path   string // As written in the EMBEDFILE or EMBEDDIR statement
+	Target string // Slash-separated path in the build directory
+	IsDir  bool
+	Line   int // Line of the statement
+}
+
+// errorTrap tracks a routine with an ON ERROR GOTO handler. The statements
+// before the handler label run in a closure whose deferred recover records
+// the error; the routine then continues with the handler.
+type errorTrap struct {
+	protected bool     // Generating the statements inside the closure
+	results   []string // Named results of the closure, before _exit
+}
+
+// loopContext tracks a loop being generated, so EXIT inside a Go select can
+// break out of it with a label
+type loopContext struct {
+	selectDepth int    // selectDepth when the loop started
+	label       string // Set once an EXIT needs a labeled break
+}
+
+// withTarget is the Go expression that leading-dot members inside WITH refer to
+type withTarget struct {
+	expr string
+	typ  *analyzer.Type
+	used bool
 }
 
 // New creates a new code generator
@@ -41,11 +120,43 @@ func (g *Generator) SetDebugMode(enabled bool) {
 	g.debugMode = enabled
 }
 
+// SetReleaseMode enables or disables release mode, which strips ASSERT
+// statements from the generated code
+func (g *Generator) SetReleaseMode(enabled bool) {
+	g.releaseMode = enabled
+}
+
 // SetSourceFile sets the source file name for debug comments
 func (g *Generator) SetSourceFile(filename string) {
 	g.sourceFile = filename
 }
 
+// SetTestMode enables or disables test mode, which generates TEST and
+// BENCHMARK blocks for GenerateTests to run; otherwise they are left out
+func (g *Generator) SetTestMode(enabled bool) {
+	g.testMode = enabled
+}
+
+// SetLibrary makes Generate write a package with the given name instead of
+// a program. It has no main function, and exports its routines, types and
+// constants.
+func (g *Generator) SetLibrary(pkg string) {
+	g.library = pkg
+}
+
+// SetSourceMap sets how lines of the compiled source, after INCLUDE
+// expansion, map back to the file and line they came from. Error locations
+// use it; without it they name the source file and the compiled line.
+func (g *Generator) SetSourceMap(fn func(line int) (string, int)) {
+	g.sourceMap = fn
+}
+
+// AddPlugin lets the program call the builtins of a plugin, whose source
+// is embedded in the generated code once a call uses one of them
+func (g *Generator) AddPlugin(p *plugin.Plugin) {
+	g.plugins = append(g.plugins, p)
+}
+
 // SetTypeRegistry sets the type registry for custom types
 func (g *Generator) SetTypeRegistry(types *analyzer.TypeRegistry) {
 	g.types = types
@@ -56,6 +167,12 @@ func (g *Generator) Generate() string {
 	// Collect imports from explicit IMPORT statements
 	g.collectImports()
 
+	for _, stmt := range g.program.Statements {
+		if opt, ok := stmt.(*parser.OptionStatement); ok && opt.Name == "UNICODE" {
+			g.unicode = true
+		}
+	}
+
 	// Pre-scan for additional required imports and runtime functions
 	g.scanForRequiredImports()
 	g.scanForRuntimeFunctions()
@@ -64,36 +181,91 @@ func (g *Generator) Generate() string {
 	mainSym := g.symbols.GlobalScope.Resolve("Main")
 	g.hasMain = mainSym != nil
 
+	// Generate the program body first, so imports and runtime functions
+	// discovered while generating code are emitted above it
+	body := g.captureOutput(func() {
+		// Generate type definitions (structs)
+		g.generateTypeDefinitions()
+
+		// Generate global variables
+		g.generateGlobalVariables()
+		g.generateEmbeds()
+
+		// Generate functions, subs, and methods, preceded by the
+		// package-level variables their STATIC locals are hoisted to
+		functions := g.captureOutput(g.generateFunctions)
+		g.generateStaticVariables()
+		g.output.WriteString(functions)
+
+		// Generate main function if needed. Test files without a Main
+		// still need one for the package to build.
+		if !g.hasMain && g.testMode {
+			g.writeLine("")
+			g.writeLine("func main() {}")
+		}
+		if g.library != "" {
+			g.generateExports()
+		} else if g.hasMain {
+			g.writeLine("")
+			g.writeLine("func main() {")
+			g.indent++
+			if g.runtimeFuncs["Screen"] {
+				// The graphics window needs the main thread
+				g.writeLine("runGraphics(Main)")
+			} else {
+				g.writeLine("Main()")
+			}
+			g.indent--
+			g.writeLine("}")
+		}
+	})
+	for funcName := range g.runtimeFuncs {
+		for _, imp := range runtimeFuncImports[funcName] {
+			g.addRuntimeImport(imp)
+		}
+	}
+	for p := range g.usedPlugins {
+		for _, imp := range p.Imports {
+			g.addRuntimeImport(imp)
+		}
+	}
+
+	// fmt is imported for PRINT, and left out if nothing uses it
+	helpers := g.captureOutput(func() {
+		g.generateRuntimeFunctions()
+		g.generatePlugins()
+	})
+	if !strings.Contains(body, "fmt.") && !strings.Contains(helpers, "fmt.") && g.symbols.GetImport("fmt") == nil {
+		delete(g.imports, "fmt")
+	}
+
 	// Generate package declaration
-	g.writeLine("package main")
+	if g.library != "" {
+		g.writeLine("package " + g.library)
+	} else {
+		g.writeLine("package main")
+	}
 	g.writeLine("")
 
 	// Generate imports
 	g.generateImports()
 
 	// Generate runtime helper functions
-	g.generateRuntimeFunctions()
-
-	// Generate type definitions (structs)
-	g.generateTypeDefinitions()
+	g.output.WriteString(helpers)
 
-	// Generate global variables
-	g.generateGlobalVariables()
+	g.output.WriteString(body)
 
-	// Generate functions, subs, and methods
-	g.generateFunctions()
-
-	// Generate main function if needed
-	if g.hasMain {
-		g.writeLine("")
-		g.writeLine("func main() {")
-		g.indent++
-		g.writeLine("Main()")
-		g.indent--
-		g.writeLine("}")
-	}
+	code, lines := g.extractLineMap(g.output.String())
+	g.lines = lines
+	return code
+}
 
-	return g.output.String()
+// LineMap returns the line of DBasic source each line of the code from
+// Generate came from, in order of Go line. Lines that no statement
+// generated, such as those of runtime helpers and function headers, are
+// left out.
+func (g *Generator) LineMap() []LineMapping {
+	return g.lines
 }
 
 // scanForRequiredImports pre-scans the AST to find required imports
@@ -107,12 +279,27 @@ func (g *Generator) scanStatementForImports(stmt parser.Statement) {
 	switch s := stmt.(type) {
 	case *parser.SubStatement:
 		g.scanBlockForImports(s.Body)
+	case *parser.TestStatement:
+		if g.testMode {
+			g.scanBlockForImports(s.Body)
+		}
+	case *parser.BenchmarkStatement:
+		if g.testMode {
+			g.scanBlockForImports(s.Body)
+		}
 	case *parser.FunctionStatement:
 		g.scanBlockForImports(s.Body)
-	case *parser.InputStatement:
-		g.imports["bufio"] = ""
-		g.imports["os"] = ""
-		g.imports["strings"] = ""
+	case *parser.ModuleStatement:
+		for _, member := range s.Body {
+			g.scanStatementForImports(member)
+		}
+	case *parser.TypeStatement:
+		if s.Constructor != nil {
+			g.scanBlockForImports(s.Constructor.Body)
+		}
+		for _, prop := range s.Properties {
+			g.scanBlockForImports(prop.Body)
+		}
 	}
 }
 
@@ -122,10 +309,6 @@ func (g *Generator) scanBlockForImports(block *parser.BlockStatement) {
 	}
 	for _, stmt := range block.Statements {
 		switch s := stmt.(type) {
-		case *parser.InputStatement:
-			g.imports["bufio"] = ""
-			g.imports["os"] = ""
-			g.imports["strings"] = ""
 		case *parser.IfStatement:
 			g.scanBlockForImports(s.Consequence)
 			for _, elseif := range s.ElseIfs {
@@ -134,6 +317,12 @@ func (g *Generator) scanBlockForImports(block *parser.BlockStatement) {
 			g.scanBlockForImports(s.Alternative)
 		case *parser.ForStatement:
 			g.scanBlockForImports(s.Body)
+		case *parser.ForEachStatement:
+			g.scanBlockForImports(s.Body)
+		case *parser.WithStatement:
+			g.scanBlockForImports(s.Body)
+		case *parser.LockStatement:
+			g.scanBlockForImports(s.Body)
 		case *parser.WhileStatement:
 			g.scanBlockForImports(s.Body)
 		case *parser.DoLoopStatement:
@@ -143,6 +332,14 @@ func (g *Generator) scanBlockForImports(block *parser.BlockStatement) {
 				g.scanBlockForImports(c.Body)
 			}
 			g.scanBlockForImports(s.Default)
+		case *parser.SelectChannelStatement:
+			for _, c := range s.Cases {
+				if c.Timeout != nil {
+					g.imports["time"] = ""
+				}
+				g.scanBlockForImports(c.Body)
+			}
+			g.scanBlockForImports(s.Default)
 		}
 		// Check for math.Pow usage in expressions
 		g.scanExpressionForImports(stmt)
@@ -348,129 +545,2780 @@ func Mid(s string, start, ln int) string {
 	}
 	return s[startIdx:endIdx]
 }`,
-	"Str": `// Str converts a number to string
-func Str(val interface{}) string {
-	return fmt.Sprintf("%v", val)
+	"Range": `// Range returns the integers from start to end inclusive, counting by step
+// (1 if omitted; negative to count down)
+func Range(start, end int, step ...int) []int {
+	by := 1
+	if len(step) > 0 {
+		by = step[0]
+	}
+	if by == 0 {
+		panic("RANGE step cannot be 0")
+	}
+	var values []int
+	for i := start; (by > 0 && i <= end) || (by < 0 && i >= end); i += by {
+		values = append(values, i)
+	}
+	return values
 }`,
-	"Val": `// Val converts a string to float64
-func Val(s string) float64 {
-	v, _ := strconv.ParseFloat(strings.TrimSpace(s), 64)
-	return v
+	"FixedString": `// FixedString pads s with spaces, or truncates it, to width bytes
+func FixedString(s string, width int) string {
+	if len(s) >= width {
+		return s[:width]
+	}
+	return s + strings.Repeat(" ", width-len(s))
 }`,
-	"UCase": `// UCase converts to uppercase
-func UCase(s string) string {
-	return strings.ToUpper(s)
+	"InputLine": `var stdinReader = bufio.NewReader(os.Stdin)
+
+// InputLine prints prompt and reads a line from standard input
+func InputLine(prompt string) string {
+	line, _ := readInputLine(prompt)
+	return line
+}
+
+// readInputLine prints prompt and reads a line without its line ending
+func readInputLine(prompt string) (string, error) {
+	fmt.Print(prompt)
+	line, err := stdinReader.ReadString('\n')
+	return strings.TrimRight(line, "\r\n"), err
 }`,
-	"LCase": `// LCase converts to lowercase
-func LCase(s string) string {
-	return strings.ToLower(s)
+	"InputValue": `// InputValue reads a line and parses it as a T, printing "?Redo from start"
+// and asking again until the line is valid
+func InputValue[T int | int64 | float32 | float64 | bool](prompt string) T {
+	for {
+		line, readErr := readInputLine(prompt)
+		line = strings.TrimSpace(line)
+		var value any
+		var err error
+		switch any(*new(T)).(type) {
+		case int:
+			value, err = strconv.Atoi(line)
+		case int64:
+			value, err = strconv.ParseInt(line, 10, 64)
+		case float32:
+			var f float64
+			f, err = strconv.ParseFloat(line, 32)
+			value = float32(f)
+		case float64:
+			value, err = strconv.ParseFloat(line, 64)
+		case bool:
+			value, err = strconv.ParseBool(line)
+		}
+		if err == nil {
+			return value.(T)
+		}
+		if readErr != nil {
+			panic("INPUT: end of input")
+		}
+		fmt.Println("?Redo from start")
+	}
 }`,
-	"Trim": `// Trim removes leading and trailing whitespace
-func Trim(s string) string {
-	return strings.TrimSpace(s)
+	"InSlice": `// InSlice reports whether x is one of values
+func InSlice[T comparable](x T, values ...T) bool {
+	for _, v := range values {
+		if v == x {
+			return true
+		}
+	}
+	return false
 }`,
-	"Rnd": `// Rnd returns a random float64 between 0 and 1
-func Rnd() float64 {
-	return rand.Float64()
+	"IndexOf": `// IndexOf returns the position of the first value in s, counted from 0,
+// or -1 when it is not there
+func IndexOf[T comparable](s []T, value T) int {
+	for i, v := range s {
+		if v == value {
+			return i
+		}
+	}
+	return -1
 }`,
-	"RndInt": `// RndInt returns a random integer between 0 and max-1
-func RndInt(max int) int {
-	return rand.Intn(max)
+	"Contains": `// Contains reports whether value is in s
+func Contains[T comparable](s []T, value T) bool {
+	for _, v := range s {
+		if v == value {
+			return true
+		}
+	}
+	return false
 }`,
-	"Instr": `// Instr finds the position of substring in string (1-based)
-func Instr(s, substr string) int {
-	idx := strings.Index(s, substr)
-	if idx == -1 {
-		return 0
+	"Reverse": `// Reverse returns a copy of s in reverse order
+func Reverse[T any](s []T) []T {
+	r := make([]T, len(s))
+	for i, v := range s {
+		r[len(s)-1-i] = v
 	}
-	return idx + 1
+	return r
 }`,
-	"Chr": `// Chr returns the character for an ASCII code
-func Chr(code int) string {
-	return string(rune(code))
+	"Unique": `// Unique returns s without repeated values, keeping the first of each
+func Unique[T comparable](s []T) []T {
+	seen := make(map[T]bool, len(s))
+	r := make([]T, 0, len(s))
+	for _, v := range s {
+		if !seen[v] {
+			seen[v] = true
+			r = append(r, v)
+		}
+	}
+	return r
 }`,
-	"Asc": `// Asc returns the ASCII code of the first character
-func Asc(s string) int {
-	if len(s) == 0 {
-		return 0
+	"Map": `// Map returns fn applied to each element of s
+func Map[T, R any](s []T, fn func(T) R) []R {
+	r := make([]R, len(s))
+	for i, v := range s {
+		r[i] = fn(v)
 	}
-	return int(s[0])
+	return r
 }`,
-	"FileExists": `// FileExists checks if a file exists
-func FileExists(path string) bool {
-	_, err := os.Stat(path)
-	return err == nil
+	"Filter": `// Filter returns the elements of s for which fn is true
+func Filter[T any](s []T, fn func(T) bool) []T {
+	r := make([]T, 0, len(s))
+	for _, v := range s {
+		if fn(v) {
+			r = append(r, v)
+		}
+	}
+	return r
 }`,
-	"ReadFile": `// ReadFile reads entire file contents
-func ReadFile(path string) string {
-	data, err := os.ReadFile(path)
-	if err != nil {
-		return ""
+	"Reduce": `// Reduce folds the elements of s into initial with fn, from first to last
+func Reduce[T, A any](s []T, fn func(A, T) A, initial A) A {
+	total := initial
+	for _, v := range s {
+		total = fn(total, v)
 	}
-	return string(data)
+	return total
 }`,
-	"WriteFile": `// WriteFile writes string to file
-func WriteFile(path, content string) {
-	os.WriteFile(path, []byte(content), 0644)
+	"Stack": `// Stack is a STACK OF T: the last value pushed is the first popped
+type Stack[T any] struct {
+	items []T
+}
+
+// Push puts value on top of the stack
+func (s *Stack[T]) Push(value T) {
+	s.items = append(s.items, value)
+}
+
+// Pop removes and returns the value on top of the stack
+func (s *Stack[T]) Pop() T {
+	value := s.Peek()
+	s.items = s.items[:len(s.items)-1]
+	return value
+}
+
+// Peek returns the value on top of the stack without removing it
+func (s *Stack[T]) Peek() T {
+	if len(s.items) == 0 {
+		panic("stack is empty")
+	}
+	return s.items[len(s.items)-1]
+}
+
+// Len returns the number of values on the stack
+func (s *Stack[T]) Len() int {
+	return len(s.items)
+}
+
+// Items returns the values from the bottom of the stack to the top
+func (s *Stack[T]) Items() []T {
+	return append([]T(nil), s.items...)
+}
+
+func (s *Stack[T]) String() string {
+	return fmt.Sprint(s.items)
 }`,
-	"JSONParse": `// JSONParse parses a JSON string into a map
-func JSONParse(s string) map[string]interface{} {
-	var result map[string]interface{}
-	json.Unmarshal([]byte(s), &result)
-	return result
+	"Queue": `// Queue is a QUEUE OF T: values are dequeued in the order they were enqueued
+type Queue[T any] struct {
+	items []T
+}
+
+// Enqueue adds value to the back of the queue
+func (q *Queue[T]) Enqueue(value T) {
+	q.items = append(q.items, value)
+}
+
+// Dequeue removes and returns the value at the front of the queue
+func (q *Queue[T]) Dequeue() T {
+	value := q.Peek()
+	var zero T
+	q.items[0] = zero
+	q.items = q.items[1:]
+	return value
+}
+
+// Peek returns the value at the front of the queue without removing it
+func (q *Queue[T]) Peek() T {
+	if len(q.items) == 0 {
+		panic("queue is empty")
+	}
+	return q.items[0]
+}
+
+// Len returns the number of values in the queue
+func (q *Queue[T]) Len() int {
+	return len(q.items)
+}
+
+// Items returns the values from the front of the queue to the back
+func (q *Queue[T]) Items() []T {
+	return append([]T(nil), q.items...)
+}
+
+func (q *Queue[T]) String() string {
+	return fmt.Sprint(q.items)
 }`,
-	"JSONStringify": `// JSONStringify converts a map to JSON string
-func JSONStringify(data map[string]interface{}) string {
-	b, _ := json.Marshal(data)
-	return string(b)
+	"Set": `// Set is a SET OF T: distinct values, kept in the order they were added
+type Set[T comparable] struct {
+	index map[T]int
+	items []T
+}
+
+// Add puts value in the set; adding a value twice keeps one copy
+func (s *Set[T]) Add(value T) {
+	if s.index == nil {
+		s.index = make(map[T]int)
+	}
+	if _, ok := s.index[value]; !ok {
+		s.index[value] = len(s.items)
+		s.items = append(s.items, value)
+	}
+}
+
+// Has reports whether value is in the set
+func (s *Set[T]) Has(value T) bool {
+	_, ok := s.index[value]
+	return ok
+}
+
+// Remove takes value out of the set, if it is there
+func (s *Set[T]) Remove(value T) {
+	i, ok := s.index[value]
+	if !ok {
+		return
+	}
+	delete(s.index, value)
+	s.items = append(s.items[:i], s.items[i+1:]...)
+	for _, v := range s.items[i:] {
+		s.index[v]--
+	}
+}
+
+// Len returns the number of values in the set
+func (s *Set[T]) Len() int {
+	return len(s.items)
+}
+
+// Items returns the values in the order they were added
+func (s *Set[T]) Items() []T {
+	return append([]T(nil), s.items...)
+}
+
+func (s *Set[T]) String() string {
+	return fmt.Sprint(s.items)
 }`,
-	"JSONPretty": `// JSONPretty converts a map to pretty-printed JSON string
-func JSONPretty(data map[string]interface{}) string {
-	b, _ := json.MarshalIndent(data, "", "  ")
-	return string(b)
+	"InMap": `// InMap reports whether key is a key of m
+func InMap[K comparable, V any](key K, m map[K]V) bool {
+	_, ok := m[key]
+	return ok
 }`,
-	"JSONGet": `// JSONGet retrieves a value from a JSON map by path
-func JSONGet(data map[string]interface{}, path string) interface{} {
-	parts := strings.Split(path, ".")
-	var current interface{} = data
-	for _, part := range parts {
-		switch v := current.(type) {
-		case map[string]interface{}:
-			current = v[part]
+	"Like": `// Like reports whether s matches a wildcard pattern: * matches any run of
+// characters, ? any one character, # any digit, and [abc], [a-z] or [!a-z]
+// one character from (or not from) a list
+func Like(s, pattern string) bool {
+	return likeMatch([]rune(s), []rune(pattern))
+}
+
+func likeMatch(s, p []rune) bool {
+	for len(p) > 0 {
+		switch p[0] {
+		case '*':
+			for len(p) > 0 && p[0] == '*' {
+				p = p[1:]
+			}
+			if len(p) == 0 {
+				return true
+			}
+			for i := 0; i <= len(s); i++ {
+				if likeMatch(s[i:], p) {
+					return true
+				}
+			}
+			return false
+		case '?':
+			if len(s) == 0 {
+				return false
+			}
+		case '#':
+			if len(s) == 0 || s[0] < '0' || s[0] > '9' {
+				return false
+			}
+		case '[':
+			end := 1
+			for end < len(p) && p[end] != ']' {
+				end++
+			}
+			if end == len(p) {
+				// No closing bracket: match [ itself
+				if len(s) == 0 || s[0] != '[' {
+					return false
+				}
+				break
+			}
+			if len(s) == 0 || !likeClass(s[0], p[1:end]) {
+				return false
+			}
+			p = p[end:]
 		default:
-			return nil
+			if len(s) == 0 || s[0] != p[0] {
+				return false
+			}
 		}
+		s, p = s[1:], p[1:]
 	}
-	return current
-}`,
-	"JSONSet": `// JSONSet sets a value in a JSON map by path
-func JSONSet(data map[string]interface{}, path string, value interface{}) {
-	parts := strings.Split(path, ".")
-	current := data
-	for i := 0; i < len(parts)-1; i++ {
-		part := parts[i]
-		if _, ok := current[part]; !ok {
-			current[part] = make(map[string]interface{})
+	return len(s) == 0
+}
+
+func likeClass(c rune, class []rune) bool {
+	negate := len(class) > 0 && class[0] == '!'
+	if negate {
+		class = class[1:]
+	}
+	for i := 0; i < len(class); i++ {
+		if i+2 < len(class) && class[i+1] == '-' {
+			if class[i] <= c && c <= class[i+2] {
+				return !negate
+			}
+			i += 2
+			continue
+		}
+		if class[i] == c {
+			return !negate
 		}
-		current = current[part].(map[string]interface{})
 	}
-	current[parts[len(parts)-1]] = value
+	return negate
 }`,
-	"StructToJSON": `// StructToJSON converts a struct to a JSON map
-func StructToJSON(v interface{}) map[string]interface{} {
-	result := make(map[string]interface{})
-	val := reflect.ValueOf(v)
-	if val.Kind() == reflect.Ptr {
-		val = val.Elem()
+	"LenR": `// LenR returns the number of characters (runes) in a string
+func LenR(s string) int {
+	return utf8.RuneCountInString(s)
+}`,
+	"LeftR": `// LeftR returns the leftmost n characters (runes)
+func LeftR(s string, n int) string {
+	r := []rune(s)
+	if n <= 0 {
+		return ""
 	}
-	if val.Kind() != reflect.Struct {
-		return result
+	if n >= len(r) {
+		return s
 	}
-	typ := val.Type()
-	for i := 0; i < val.NumField(); i++ {
-		field := typ.Field(i)
-		fieldVal := val.Field(i)
-		if field.PkgPath != "" {
+	return string(r[:n])
+}`,
+	"RightR": `// RightR returns the rightmost n characters (runes)
+func RightR(s string, n int) string {
+	r := []rune(s)
+	if n <= 0 {
+		return ""
+	}
+	if n >= len(r) {
+		return s
+	}
+	return string(r[len(r)-n:])
+}`,
+	"MidR": `// MidR returns ln characters (runes) starting at character position start
+func MidR(s string, start, ln int) string {
+	r := []rune(s)
+	if start < 1 {
+		start = 1
+	}
+	startIdx := start - 1
+	if startIdx >= len(r) || ln <= 0 {
+		return ""
+	}
+	endIdx := startIdx + ln
+	if endIdx > len(r) {
+		endIdx = len(r)
+	}
+	return string(r[startIdx:endIdx])
+}`,
+	"InstrR": `// InstrR finds the character (rune) position of substring in string (1-based)
+func InstrR(s, substr string) int {
+	idx := strings.Index(s, substr)
+	if idx == -1 {
+		return 0
+	}
+	return utf8.RuneCountInString(s[:idx]) + 1
+}`,
+	"AscR": `// AscR returns the Unicode code point of the first character
+func AscR(s string) int {
+	r, size := utf8.DecodeRuneInString(s)
+	if size == 0 {
+		return 0
+	}
+	return int(r)
+}`,
+	"RuneLen": `// RuneLen returns the number of characters (runes) in a string
+func RuneLen(s string) int {
+	return utf8.RuneCountInString(s)
+}`,
+	"RuneAt": `// RuneAt returns the code point of the character at position i, counting
+// from 1, or 0 if there is none
+func RuneAt(s string, i int) int {
+	for _, r := range s {
+		if i--; i == 0 {
+			return int(r)
+		}
+	}
+	return 0
+}`,
+	"Runes": `// Runes returns the code points of a string's characters
+func Runes(s string) []int {
+	result := make([]int, 0, len(s))
+	for _, r := range s {
+		result = append(result, int(r))
+	}
+	return result
+}`,
+	"IsLetter": `// IsLetter reports whether a code point, or every character of a non-empty
+// string, is a letter
+func IsLetter(c interface{}) bool {
+	return isRunes(c, unicode.IsLetter)
+}
+
+// isRunes reports whether test holds for a code point, or for every
+// character of a non-empty string
+func isRunes(c interface{}, test func(rune) bool) bool {
+	switch v := c.(type) {
+	case string:
+		for _, r := range v {
+			if !test(r) {
+				return false
+			}
+		}
+		return v != ""
+	case int:
+		return test(rune(v))
+	case int64:
+		return test(rune(v))
+	}
+	return false
+}`,
+	"IsDigit": `// IsDigit reports whether a code point, or every character of a non-empty
+// string, is a decimal digit
+func IsDigit(c interface{}) bool {
+	return isRunes(c, unicode.IsDigit)
+}`,
+	"IsSpace": `// IsSpace reports whether a code point, or every character of a non-empty
+// string, is white space
+func IsSpace(c interface{}) bool {
+	return isRunes(c, unicode.IsSpace)
+}`,
+	"NormalizeNFC": `// NormalizeNFC returns s in Unicode normal form C, so that characters
+// written with combining marks compare equal to their precomposed forms
+func NormalizeNFC(s string) string {
+	return norm.NFC.String(s)
+}`,
+	"Str": `// Str converts a number to string
+func Str(val interface{}) string {
+	return fmt.Sprintf("%v", val)
+}`,
+	"Val": `// Val converts a string to float64
+func Val(s string) float64 {
+	v, _ := strconv.ParseFloat(strings.TrimSpace(s), 64)
+	return v
+}`,
+	"FormatCurrency": `// FormatCurrency formats an amount of money in a currency such as "EUR"
+// the way a locale such as "de-DE" writes it: "1.234,56 €"
+func FormatCurrency(value interface{}, code, locale string) string {
+	loc, region := lookupLocale(locale)
+	code = strings.ToUpper(code)
+	cur, ok := currencies[code]
+	if !ok {
+		cur = currency{code, code, "", 2}
+	}
+	symbol := cur.intl
+	if cur.region == "" || cur.region == region {
+		symbol = cur.symbol
+	}
+	n := Dbl(value)
+	s := strconv.FormatFloat(math.Abs(n), 'f', cur.digits, 64)
+	whole, frac, hasFrac := strings.Cut(s, ".")
+	num := localeGroup(whole, loc)
+	if hasFrac {
+		num += loc.decimal + frac
+	}
+	pattern := loc.pos
+	if n < 0 && strings.ContainsAny(s, "123456789") {
+		pattern = loc.neg
+	}
+	// A symbol written in letters is kept apart from the digits: "CHF 12.00"
+	if last, _ := utf8.DecodeLastRuneInString(symbol); unicode.IsLetter(last) {
+		pattern = strings.Replace(pattern, "¤#", "¤\u00a0#", 1)
+	}
+	if first, _ := utf8.DecodeRuneInString(symbol); unicode.IsLetter(first) {
+		pattern = strings.Replace(pattern, "#¤", "#\u00a0¤", 1)
+	}
+	return strings.NewReplacer("¤", symbol, "#", num).Replace(pattern)
+}
+
+// numLocale is how a locale writes numbers. In pos and neg, the patterns
+// for amounts of money, ¤ stands for the currency symbol and # the number.
+type numLocale struct {
+	decimal, group string
+	minGroup       int  // Digits needed before the first group separator
+	indian         bool // Groups of two above the thousands: 12,34,567
+	pos, neg       string
+	region         string // Region assumed when the locale names none
+}
+
+var numLocales = map[string]numLocale{
+	"en":    {".", ",", 1, false, "¤#", "-¤#", "US"},
+	"en-IN": {".", ",", 1, true, "¤#", "-¤#", "IN"},
+	"hi":    {".", ",", 1, true, "¤#", "-¤#", "IN"},
+	"de":    {",", ".", 1, false, "#\u00a0¤", "-#\u00a0¤", "DE"},
+	"de-AT": {",", "\u00a0", 1, false, "¤\u00a0#", "-¤\u00a0#", "AT"},
+	"de-CH": {".", "’", 1, false, "¤\u00a0#", "¤-#", "CH"},
+	"fr":    {",", "\u202f", 1, false, "#\u00a0¤", "-#\u00a0¤", "FR"},
+	"fr-CA": {",", "\u00a0", 1, false, "#\u00a0¤", "-#\u00a0¤", "CA"},
+	"es":    {",", ".", 2, false, "#\u00a0¤", "-#\u00a0¤", "ES"},
+	"es-MX": {".", ",", 1, false, "¤#", "-¤#", "MX"},
+	"it":    {",", ".", 1, false, "#\u00a0¤", "-#\u00a0¤", "IT"},
+	"nl":    {",", ".", 1, false, "¤\u00a0#", "¤\u00a0-#", "NL"},
+	"pt":    {",", "\u00a0", 2, false, "#\u00a0¤", "-#\u00a0¤", "PT"},
+	"pt-BR": {",", ".", 1, false, "¤\u00a0#", "-¤\u00a0#", "BR"},
+	"sv":    {",", "\u00a0", 1, false, "#\u00a0¤", "-#\u00a0¤", "SE"},
+	"da":    {",", ".", 1, false, "#\u00a0¤", "-#\u00a0¤", "DK"},
+	"nb":    {",", "\u00a0", 1, false, "#\u00a0¤", "-#\u00a0¤", "NO"},
+	"pl":    {",", "\u00a0", 2, false, "#\u00a0¤", "-#\u00a0¤", "PL"},
+	"ru":    {",", "\u00a0", 1, false, "#\u00a0¤", "-#\u00a0¤", "RU"},
+	"ja":    {".", ",", 1, false, "¤#", "-¤#", "JP"},
+	"zh":    {".", ",", 1, false, "¤#", "-¤#", "CN"},
+	"ko":    {".", ",", 1, false, "¤#", "-¤#", "KR"},
+}
+
+// currency is a currency's symbol, which is used in its home region or
+// everywhere if region is "", the symbol used elsewhere and its decimals
+type currency struct {
+	symbol, intl string
+	region       string
+	digits       int
+}
+
+var currencies = map[string]currency{
+	"USD": {"$", "US$", "US", 2},
+	"EUR": {"€", "€", "", 2},
+	"GBP": {"£", "£", "", 2},
+	"JPY": {"￥", "¥", "JP", 0},
+	"CNY": {"¥", "CN¥", "CN", 2},
+	"INR": {"₹", "₹", "", 2},
+	"KRW": {"₩", "₩", "", 0},
+	"BRL": {"R$", "R$", "", 2},
+	"CAD": {"$", "CA$", "CA", 2},
+	"AUD": {"$", "A$", "AU", 2},
+	"NZD": {"$", "NZ$", "NZ", 2},
+	"MXN": {"$", "MX$", "MX", 2},
+	"HKD": {"$", "HK$", "HK", 2},
+	"SGD": {"$", "S$", "SG", 2},
+	"CHF": {"CHF", "CHF", "", 2},
+	"SEK": {"kr", "SEK", "SE", 2},
+	"NOK": {"kr", "NOK", "NO", 2},
+	"DKK": {"kr.", "DKK", "DK", 2},
+	"PLN": {"zł", "PLN", "PL", 2},
+	"RUB": {"₽", "RUB", "RU", 2},
+	"TRY": {"₺", "TRY", "TR", 2},
+	"ZAR": {"R", "ZAR", "ZA", 2},
+	"BHD": {"BHD", "BHD", "", 3},
+	"KWD": {"KWD", "KWD", "", 3},
+}
+
+// lookupLocale returns the conventions of a locale such as "fr-CA" or
+// "fr_CA", falling back to its language and then to English, and its region
+func lookupLocale(locale string) (numLocale, string) {
+	lang, region, _ := strings.Cut(strings.ReplaceAll(locale, "_", "-"), "-")
+	lang, region = strings.ToLower(lang), strings.ToUpper(region)
+	loc, ok := numLocales[lang+"-"+region]
+	if !ok {
+		loc, ok = numLocales[lang]
+	}
+	if !ok {
+		loc = numLocales["en"]
+	}
+	if region == "" {
+		region = loc.region
+	}
+	return loc, region
+}
+
+// localeGroup inserts a locale's group separator into a string of digits
+func localeGroup(digits string, loc numLocale) string {
+	if len(digits) < 3+loc.minGroup {
+		return digits
+	}
+	var groups []string
+	size := 3
+	for len(digits) > size {
+		groups = append([]string{digits[len(digits)-size:]}, groups...)
+		digits = digits[:len(digits)-size]
+		if loc.indian {
+			size = 2
+		}
+	}
+	return strings.Join(append([]string{digits}, groups...), loc.group)
+}`,
+	"ParseNumberLocale": `// ParseNumberLocale reads a number written the way a locale writes it, such
+// as "1.234,5" in "de-DE". A currency symbol or code around it is ignored.
+func ParseNumberLocale(s, locale string) (float64, error) {
+	loc, _ := lookupLocale(locale)
+	t := strings.TrimFunc(s, func(r rune) bool {
+		return !unicode.IsDigit(r) && r != '-' && r != '+' && !strings.ContainsRune(loc.decimal, r)
+	})
+	t = strings.ReplaceAll(t, loc.group, "")
+	t = strings.Join(strings.FieldsFunc(t, unicode.IsSpace), "")
+	t = strings.Replace(t, loc.decimal, ".", 1)
+	n, err := strconv.ParseFloat(t, 64)
+	if err != nil {
+		return 0, fmt.Errorf("ParseNumberLocale: invalid number %q", s)
+	}
+	return n, nil
+}`,
+	"FormatNumber": `// FormatNumber formats a number with the given decimals (as many as needed
+// if negative) and sep between each group of three digits
+func FormatNumber(value interface{}, decimals int, sep string) string {
+	n := Dbl(value)
+	s := strconv.FormatFloat(math.Abs(n), 'f', decimals, 64)
+	whole, frac, hasFrac := strings.Cut(s, ".")
+	s = groupDigits(whole, sep)
+	if hasFrac {
+		s += "." + frac
+	}
+	if n < 0 && strings.ContainsAny(s, "123456789") {
+		s = "-" + s
+	}
+	return s
+}
+
+// groupDigits inserts sep between each group of three digits
+func groupDigits(digits, sep string) string {
+	if sep == "" || len(digits) <= 3 {
+		return digits
+	}
+	var b strings.Builder
+	for i, c := range digits {
+		if i > 0 && (len(digits)-i)%3 == 0 {
+			b.WriteString(sep)
+		}
+		b.WriteRune(c)
+	}
+	return b.String()
+}`,
+	"Format": `// Format formats a number with a mask such as "#,##0.00": 0 is a digit
+// that is always shown, # a digit shown only when needed, a comma groups
+// thousands and a % anywhere multiplies by 100. Text around the digits is
+// kept as it is.
+func Format(value interface{}, mask string) string {
+	start := strings.IndexAny(mask, "#0")
+	if start < 0 {
+		return mask
+	}
+	if start > 0 && mask[start-1] == '.' {
+		start--
+	}
+	end := strings.LastIndexAny(mask, "#0") + 1
+	prefix, pattern, suffix := mask[:start], mask[start:end], mask[end:]
+	n := Dbl(value)
+	if strings.Contains(prefix+suffix, "%") {
+		n *= 100
+	}
+	intPart, fracPart, _ := strings.Cut(pattern, ".")
+	minDigits := strings.Count(intPart, "0")
+	minDecimals := strings.Count(fracPart, "0")
+	decimals := minDecimals + strings.Count(fracPart, "#")
+	sep := ""
+	if strings.Contains(intPart, ",") {
+		sep = ","
+	}
+	whole, frac, _ := strings.Cut(strconv.FormatFloat(math.Abs(n), 'f', decimals, 64), ".")
+	for len(frac) > minDecimals && strings.HasSuffix(frac, "0") {
+		frac = frac[:len(frac)-1]
+	}
+	if len(whole) < minDigits {
+		whole = strings.Repeat("0", minDigits-len(whole)) + whole
+	}
+	if whole == "0" && minDigits == 0 && frac != "" {
+		whole = ""
+	}
+	s := groupDigits(whole, sep)
+	if frac != "" {
+		s += "." + frac
+	}
+	sign := ""
+	if n < 0 && strings.ContainsAny(s, "123456789") {
+		sign = "-"
+	}
+	return sign + prefix + s + suffix
+}`,
+	"PadLeft": `// PadLeft right-aligns s in a field of width characters
+func PadLeft(s string, width int) string {
+	if n := utf8.RuneCountInString(s); n < width {
+		return strings.Repeat(" ", width-n) + s
+	}
+	return s
+}`,
+	"PadRight": `// PadRight left-aligns s in a field of width characters
+func PadRight(s string, width int) string {
+	if n := utf8.RuneCountInString(s); n < width {
+		return s + strings.Repeat(" ", width-n)
+	}
+	return s
+}`,
+	"Encode": `// Encode converts a string to its UTF-8 bytes
+func Encode(s string) []byte {
+	return []byte(s)
+}`,
+	"Decode": `// Decode converts UTF-8 bytes to a string
+func Decode(b []byte) string {
+	return string(b)
+}`,
+	"MakeBytes": `// MakeBytes returns n zero bytes
+func MakeBytes(n int) []byte {
+	return make([]byte, n)
+}`,
+	"LenBytes": `// LenBytes returns the number of bytes
+func LenBytes(b []byte) int {
+	return len(b)
+}`,
+	"Base64Encode": `// Base64Encode encodes a string or bytes as standard base64
+func Base64Encode(data interface{}) string {
+	switch v := data.(type) {
+	case string:
+		return base64.StdEncoding.EncodeToString([]byte(v))
+	case []byte:
+		return base64.StdEncoding.EncodeToString(v)
+	}
+	return ""
+}`,
+	"Base64Decode": `// Base64Decode decodes standard base64, with or without padding, or
+// returns nil if s is not base64
+func Base64Decode(s string) []byte {
+	s = strings.TrimRight(strings.TrimSpace(s), "=")
+	b, err := base64.RawStdEncoding.DecodeString(s)
+	if err != nil {
+		return nil
+	}
+	return b
+}`,
+	"HexEncode": `// HexEncode encodes a string or bytes as lowercase hex
+func HexEncode(data interface{}) string {
+	switch v := data.(type) {
+	case string:
+		return hex.EncodeToString([]byte(v))
+	case []byte:
+		return hex.EncodeToString(v)
+	}
+	return ""
+}`,
+	"HexDecode": `// HexDecode decodes hex in either case, or returns nil if s is not hex
+func HexDecode(s string) []byte {
+	b, err := hex.DecodeString(strings.TrimSpace(s))
+	if err != nil {
+		return nil
+	}
+	return b
+}`,
+	"AESEncrypt": `// AESEncrypt encrypts data with AES-256-GCM under a key of any length,
+// which is hashed to 256 bits. The result starts with the random nonce, so
+// encrypting the same data twice gives different bytes.
+func AESEncrypt(key, data interface{}) []byte {
+	gcm := aesGCM(key)
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := crand.Read(nonce); err != nil {
+		panic("AESEncrypt: " + err.Error())
+	}
+	return gcm.Seal(nonce, nonce, cryptoBytes(data), nil)
+}
+
+// AESDecrypt decrypts the result of AESEncrypt, or returns nil if the key
+// is wrong or the data has been changed
+func AESDecrypt(key, data interface{}) []byte {
+	gcm := aesGCM(key)
+	sealed := cryptoBytes(data)
+	if len(sealed) < gcm.NonceSize() {
+		return nil
+	}
+	plain, err := gcm.Open(nil, sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():], nil)
+	if err != nil {
+		return nil
+	}
+	if plain == nil {
+		plain = []byte{}
+	}
+	return plain
+}
+
+// aesGCM returns AES-256-GCM keyed by the SHA-256 hash of key
+func aesGCM(key interface{}) cipher.AEAD {
+	sum := sha256.Sum256(cryptoBytes(key))
+	block, err := aes.NewCipher(sum[:])
+	if err != nil {
+		panic("AES: " + err.Error())
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		panic("AES: " + err.Error())
+	}
+	return gcm
+}
+
+// cryptoBytes returns the bytes of a STRING or BYTES value
+func cryptoBytes(data interface{}) []byte {
+	switch v := data.(type) {
+	case string:
+		return []byte(v)
+	case []byte:
+		return v
+	}
+	return nil
+}`,
+	"UCase": `// UCase converts to uppercase
+func UCase(s string) string {
+	return strings.ToUpper(s)
+}`,
+	"LCase": `// LCase converts to lowercase
+func LCase(s string) string {
+	return strings.ToLower(s)
+}`,
+	"Trim": `// Trim removes leading and trailing whitespace
+func Trim(s string) string {
+	return strings.TrimSpace(s)
+}`,
+	"Rnd": `// rng is the generator behind Rnd, RndInt and RndRange. It starts from a
+// random seed, so Randomize is only needed to repeat a sequence.
+var (
+	rng   = rand.New(rand.NewSource(time.Now().UnixNano()))
+	rngMu sync.Mutex
+)
+
+// Rnd returns a random float64 between 0 and 1
+func Rnd() float64 {
+	rngMu.Lock()
+	defer rngMu.Unlock()
+	return rng.Float64()
+}`,
+	"RndInt": `// RndInt returns a random integer between 0 and max-1
+func RndInt(max int) int {
+	rngMu.Lock()
+	defer rngMu.Unlock()
+	return rng.Intn(max)
+}`,
+	"RndRange": `// RndRange returns a random integer between min and max inclusive
+func RndRange(min, max int) int {
+	rngMu.Lock()
+	defer rngMu.Unlock()
+	return min + rng.Intn(max-min+1)
+}`,
+	"Randomize": `// Randomize restarts the generator from seed, or from a new random seed
+// when called without one
+func Randomize(seed ...interface{}) {
+	s := time.Now().UnixNano()
+	if len(seed) > 0 {
+		s = Lng(seed[0])
+	}
+	rngMu.Lock()
+	defer rngMu.Unlock()
+	rng = rand.New(rand.NewSource(s))
+}`,
+	"embedDir": `// embedDir reads the files of an EMBEDDIR into a map, keyed by their
+// slash-separated paths inside the directory
+func embedDir(fsys embed.FS, root string) map[string][]byte {
+	files := make(map[string][]byte)
+	fs.WalkDir(fsys, root, func(name string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		data, err := fsys.ReadFile(name)
+		if err != nil {
+			return err
+		}
+		files[strings.TrimPrefix(name, root+"/")] = data
+		return nil
+	})
+	return files
+}`,
+	"RndBytes": `// RndBytes returns n bytes from the operating system's secure generator,
+// for tokens and keys
+func RndBytes(n int) []byte {
+	b := make([]byte, n)
+	if _, err := crand.Read(b); err != nil {
+		panic("RndBytes: " + err.Error())
+	}
+	return b
+}`,
+	"RndSecureInt": `// RndSecureInt returns a random integer between 0 and max-1 from the
+// operating system's secure generator
+func RndSecureInt(max int) int {
+	n, err := crand.Int(crand.Reader, big.NewInt(int64(max)))
+	if err != nil {
+		panic("RndSecureInt: " + err.Error())
+	}
+	return int(n.Int64())
+}`,
+	"Instr": `// Instr finds the position of substring in string (1-based)
+func Instr(s, substr string) int {
+	idx := strings.Index(s, substr)
+	if idx == -1 {
+		return 0
+	}
+	return idx + 1
+}`,
+	"Chr": `// Chr returns the character for an ASCII code
+func Chr(code int) string {
+	return string(rune(code))
+}`,
+	"Asc": `// Asc returns the ASCII code of the first character
+func Asc(s string) int {
+	if len(s) == 0 {
+		return 0
+	}
+	return int(s[0])
+}`,
+	"FileExists": `// FileExists checks if a file exists
+func FileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}`,
+	"ReadFile": `// ReadFile reads entire file contents
+func ReadFile(path string) string {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+	return string(data)
+}`,
+	"WriteFile": `// WriteFile writes string to file
+func WriteFile(path, content string) {
+	os.WriteFile(path, []byte(content), 0644)
+}`,
+	"ListDir": `// ListDir returns the sorted names of the entries in a directory
+func ListDir(path string) []string {
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		return nil
+	}
+	names := make([]string, len(entries))
+	for i, entry := range entries {
+		names[i] = entry.Name()
+	}
+	return names
+}`,
+	"Glob": `// Glob returns the sorted paths matching a pattern such as "logs/*.txt"
+func Glob(pattern string) []string {
+	matches, _ := filepath.Glob(pattern)
+	return matches
+}`,
+	"IsDir": `// IsDir reports whether path is a directory
+func IsDir(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && info.IsDir()
+}`,
+	"FileSize": `// FileSize returns the size of a file in bytes, or -1 if it does not exist
+func FileSize(path string) int64 {
+	info, err := os.Stat(path)
+	if err != nil {
+		return -1
+	}
+	return info.Size()
+}`,
+	"ReadLines": `// ReadLines sends each line of a file, without its line ending, to the
+// returned channel, which is closed at the end of the file. Only a little
+// of the file is in memory at a time.
+func ReadLines(path string) chan string {
+	f, err := os.Open(path)
+	if err != nil {
+		panic("ReadLines: " + err.Error())
+	}
+	lines := make(chan string, 256)
+	go func() {
+		defer close(lines)
+		defer f.Close()
+		r := bufio.NewReader(f)
+		for {
+			line, err := r.ReadString('\n')
+			if line != "" {
+				lines <- strings.TrimRight(line, "\r\n")
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+	return lines
+}`,
+	"TempFile": `// TempFile creates an empty file in the system's temporary directory and
+// returns its path. Its name is prefix followed by random characters, or
+// the random characters replace a "*" in prefix.
+func TempFile(prefix string) string {
+	f, err := os.CreateTemp("", tempPattern(prefix))
+	if err != nil {
+		panic("TempFile: " + err.Error())
+	}
+	f.Close()
+	return f.Name()
+}
+
+// tempPattern adds the "*" that os.CreateTemp and os.MkdirTemp replace
+// with random characters, unless prefix already has one
+func tempPattern(prefix string) string {
+	if strings.Contains(prefix, "*") {
+		return prefix
+	}
+	return prefix + "*"
+}`,
+	"TempDir": `// TempDir creates a new directory in the system's temporary directory and
+// returns its path, named as for TempFile
+func TempDir(prefix string) string {
+	dir, err := os.MkdirTemp("", tempPattern(prefix))
+	if err != nil {
+		panic("TempDir: " + err.Error())
+	}
+	return dir
+}`,
+	"RemoveAll": `// RemoveAll deletes a file, or a directory and everything in it. A path
+// that does not exist is not an error.
+func RemoveAll(path string) error {
+	return os.RemoveAll(path)
+}`,
+	"WatchPath": `// WatchPath returns a channel of the changes to a file, or to the files in a
+// directory tree, as "CREATE path", "WRITE path" or "REMOVE path". It
+// checks twice a second, comparing each file's size and modification time.
+func WatchPath(path string) chan string {
+	events := make(chan string, 64)
+	go func() {
+		before := watchSnapshot(path)
+		for {
+			time.Sleep(500 * time.Millisecond)
+			after := watchSnapshot(path)
+			var changes []string
+			for p, stamp := range after {
+				if old, ok := before[p]; !ok {
+					changes = append(changes, "CREATE "+p)
+				} else if stamp != old {
+					changes = append(changes, "WRITE "+p)
+				}
+			}
+			for p := range before {
+				if _, ok := after[p]; !ok {
+					changes = append(changes, "REMOVE "+p)
+				}
+			}
+			sort.Strings(changes)
+			for _, change := range changes {
+				events <- change
+			}
+			before = after
+		}
+	}()
+	return events
+}
+
+// watchStamp is what WatchPath compares to see that a file has changed
+type watchStamp struct {
+	modTime int64
+	size    int64
+}
+
+// watchSnapshot returns the stamps of the files under root; a root that
+// does not exist has none
+func watchSnapshot(root string) map[string]watchStamp {
+	files := map[string]watchStamp{}
+	filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return nil
+		}
+		if info, err := d.Info(); err == nil {
+			files[p] = watchStamp{info.ModTime().UnixNano(), info.Size()}
+		}
+		return nil
+	})
+	return files
+}`,
+	"FileModTime": `// FileModTime returns when a file was last changed, or the zero DATETIME if
+// it does not exist
+func FileModTime(path string) time.Time {
+	info, err := os.Stat(path)
+	if err != nil {
+		return time.Time{}
+	}
+	return info.ModTime()
+}`,
+	"FileOpen": `// fileHandle is a file opened with OPEN ... AS #n
+type fileHandle struct {
+	file      *os.File
+	mode      string
+	reader    *bufio.Reader // Set for files opened FOR INPUT
+	recordLen int           // LEN = size of a RANDOM file's records, or 0
+}
+
+// fileHandles maps file numbers to their open files
+var (
+	fileHandles   = map[int]*fileHandle{}
+	fileHandlesMu sync.Mutex
+)
+
+// FileOpen opens path as file number n; mode is INPUT, OUTPUT, APPEND,
+// BINARY or RANDOM. recordLen is the LEN = size of a RANDOM file.
+func FileOpen(path, mode string, n int, recordLen ...int) {
+	fileHandlesMu.Lock()
+	defer fileHandlesMu.Unlock()
+	if _, open := fileHandles[n]; open {
+		panic(fmt.Sprintf("OPEN: file #%d is already open", n))
+	}
+	flags := os.O_RDONLY
+	switch mode {
+	case "OUTPUT":
+		flags = os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	case "APPEND":
+		flags = os.O_WRONLY | os.O_CREATE | os.O_APPEND
+	case "BINARY", "RANDOM":
+		flags = os.O_RDWR | os.O_CREATE
+	}
+	f, err := os.OpenFile(path, flags, 0644)
+	if err != nil {
+		panic("OPEN: " + err.Error())
+	}
+	h := &fileHandle{file: f, mode: mode}
+	if mode == "INPUT" {
+		h.reader = bufio.NewReader(f)
+	}
+	if len(recordLen) > 0 {
+		h.recordLen = recordLen[0]
+	}
+	fileHandles[n] = h
+}
+
+// openFile returns file number n, panicking when it is not open
+func openFile(stmt string, n int) *fileHandle {
+	fileHandlesMu.Lock()
+	defer fileHandlesMu.Unlock()
+	h, ok := fileHandles[n]
+	if !ok {
+		panic(fmt.Sprintf("%s: file #%d is not open", stmt, n))
+	}
+	return h
+}`,
+	"FileClose": `// FileClose closes the given file numbers, or every open file when none
+// are given
+func FileClose(numbers ...int) {
+	fileHandlesMu.Lock()
+	defer fileHandlesMu.Unlock()
+	if len(numbers) == 0 {
+		for n := range fileHandles {
+			numbers = append(numbers, n)
+		}
+	}
+	for _, n := range numbers {
+		if h, ok := fileHandles[n]; ok {
+			h.file.Close()
+			delete(fileHandles, n)
+		}
+	}
+}`,
+	"FileLineInput": `// FileLineInput reads the next line of file number n without its line ending
+func FileLineInput(n int) string {
+	h := openFile("LINE INPUT", n)
+	if h.reader == nil {
+		panic(fmt.Sprintf("LINE INPUT: file #%d is not open FOR INPUT", n))
+	}
+	line, err := h.reader.ReadString('\n')
+	if err != nil && line == "" {
+		panic(fmt.Sprintf("LINE INPUT: end of file #%d", n))
+	}
+	return strings.TrimRight(line, "\r\n")
+}`,
+	"FilePrint": `// FilePrint writes values to file number n like PRINT ...;
+func FilePrint(n int, values ...interface{}) {
+	if _, err := fmt.Fprint(openFile("PRINT", n).file, values...); err != nil {
+		panic("PRINT: " + err.Error())
+	}
+}
+
+// FilePrintln writes values and a newline to file number n like PRINT
+func FilePrintln(n int, values ...interface{}) {
+	if _, err := fmt.Fprintln(openFile("PRINT", n).file, values...); err != nil {
+		panic("PRINT: " + err.Error())
+	}
+}`,
+	"FileEOF": `// FileEOF reports whether file number n has nothing more to read
+func FileEOF(n int) bool {
+	h := openFile("EOF", n)
+	switch h.mode {
+	case "INPUT":
+		_, err := h.reader.Peek(1)
+		return err != nil
+	case "BINARY", "RANDOM":
+		pos, err := h.file.Seek(0, io.SeekCurrent)
+		info, statErr := h.file.Stat()
+		return err != nil || statErr != nil || pos >= info.Size()
+	}
+	return true
+}`,
+	"FileGet": `// FileGet reads a record of a BINARY or RANDOM file into the variable v
+// points to. pos is the record number (RANDOM) or byte position (BINARY)
+// counting from 1, or 0 for the current position. width is the length of a
+// STRING * n variable.
+func FileGet(n int, pos int64, v interface{}, width int) {
+	target := reflect.ValueOf(v).Elem()
+	var size bytes.Buffer
+	encodeRecord(&size, target, width)
+	h := seekRecord("GET", n, pos, size.Len())
+	buf := make([]byte, size.Len())
+	if _, err := io.ReadFull(h.file, buf); err != nil {
+		panic(fmt.Sprintf("GET: end of file #%d", n))
+	}
+	decodeRecord(bytes.NewReader(buf), target, width)
+}
+
+// FilePut writes v as a record of a BINARY or RANDOM file; pos and width
+// are as for FileGet
+func FilePut(n int, pos int64, v interface{}, width int) {
+	var buf bytes.Buffer
+	encodeRecord(&buf, reflect.ValueOf(v), width)
+	h := seekRecord("PUT", n, pos, buf.Len())
+	if _, err := h.file.Write(buf.Bytes()); err != nil {
+		panic("PUT: " + err.Error())
+	}
+}
+
+// seekRecord moves file number n to record or byte pos for a record of size bytes
+func seekRecord(stmt string, n int, pos int64, size int) *fileHandle {
+	h := openFile(stmt, n)
+	if h.mode != "BINARY" && h.mode != "RANDOM" {
+		panic(fmt.Sprintf("%s: file #%d is not open FOR BINARY or RANDOM", stmt, n))
+	}
+	if pos > 0 {
+		offset := pos - 1
+		if h.mode == "RANDOM" {
+			recordLen := int64(size)
+			if h.recordLen > 0 {
+				recordLen = int64(h.recordLen)
+			}
+			offset *= recordLen
+		}
+		if _, err := h.file.Seek(offset, io.SeekStart); err != nil {
+			panic(stmt + ": " + err.Error())
+		}
+	}
+	return h
+}
+
+// encodeRecord writes v in little-endian order: INTEGER, LONG and DOUBLE
+// take 8 bytes, SINGLE 4 and BOOLEAN 1. A STRING takes width bytes, from its
+// STRING * n length or, when width is 0, its current length.
+func encodeRecord(buf *bytes.Buffer, v reflect.Value, width int) {
+	switch v.Kind() {
+	case reflect.Int, reflect.Int64:
+		binary.Write(buf, binary.LittleEndian, v.Int())
+	case reflect.Float32:
+		binary.Write(buf, binary.LittleEndian, float32(v.Float()))
+	case reflect.Float64:
+		binary.Write(buf, binary.LittleEndian, v.Float())
+	case reflect.Bool:
+		binary.Write(buf, binary.LittleEndian, v.Bool())
+	case reflect.String:
+		s := v.String()
+		if width > 0 {
+			if len(s) > width {
+				s = s[:width]
+			}
+			s += strings.Repeat(" ", width-len(s))
+		}
+		buf.WriteString(s)
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			encodeRecord(buf, v.Field(i), recordFieldWidth(v.Type().Field(i)))
+		}
+	default:
+		panic(fmt.Sprintf("GET/PUT: cannot store %s in a record", v.Type()))
+	}
+}
+
+// decodeRecord reads v as encodeRecord wrote it
+func decodeRecord(r *bytes.Reader, v reflect.Value, width int) {
+	if !v.CanSet() {
+		// A field with a lowercase name is unexported in Go
+		v = reflect.NewAt(v.Type(), unsafe.Pointer(v.UnsafeAddr())).Elem()
+	}
+	switch v.Kind() {
+	case reflect.Int, reflect.Int64:
+		var x int64
+		binary.Read(r, binary.LittleEndian, &x)
+		v.SetInt(x)
+	case reflect.Float32:
+		var x float32
+		binary.Read(r, binary.LittleEndian, &x)
+		v.SetFloat(float64(x))
+	case reflect.Float64:
+		var x float64
+		binary.Read(r, binary.LittleEndian, &x)
+		v.SetFloat(x)
+	case reflect.Bool:
+		var x bool
+		binary.Read(r, binary.LittleEndian, &x)
+		v.SetBool(x)
+	case reflect.String:
+		if width == 0 {
+			width = v.Len()
+		}
+		b := make([]byte, width)
+		r.Read(b)
+		v.SetString(string(b))
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			decodeRecord(r, v.Field(i), recordFieldWidth(v.Type().Field(i)))
+		}
+	}
+}
+
+// recordFieldWidth returns the STRING * n length of a TYPE field, or 0
+func recordFieldWidth(f reflect.StructField) int {
+	width, _ := strconv.Atoi(f.Tag.Get("width"))
+	return width
+}`,
+	"GetEnv": `// GetEnv returns the value of an environment variable, or "" if it is not set
+func GetEnv(name string) string {
+	return os.Getenv(name)
+}`,
+	"SetEnv": `// SetEnv sets an environment variable for this program and the programs it starts
+func SetEnv(name, value string) {
+	os.Setenv(name, value)
+}`,
+	"EnvExists": `// EnvExists reports whether an environment variable is set, even to ""
+func EnvExists(name string) bool {
+	_, ok := os.LookupEnv(name)
+	return ok
+}`,
+	"Environ": `// Environ returns all environment variables as a JSON map of name to value
+func Environ() map[string]interface{} {
+	vars := make(map[string]interface{})
+	for _, kv := range os.Environ() {
+		if name, value, ok := strings.Cut(kv, "="); ok {
+			vars[name] = value
+		}
+	}
+	return vars
+}`,
+	"Command": `// Command returns command-line argument i counting from 1, the program's
+// own name for 0, or "" when there is no such argument
+func Command(i int) string {
+	if i < 0 || i >= len(os.Args) {
+		return ""
+	}
+	return os.Args[i]
+}`,
+	"ArgCount": `// ArgCount returns the number of command-line arguments
+func ArgCount() int {
+	return len(os.Args) - 1
+}`,
+	"Args": `// Args returns the command-line arguments, without the program's name
+func Args() []string {
+	return append([]string{}, os.Args[1:]...)
+}`,
+	"Exec": `// Exec runs program with args and returns its standard output and exit
+// code. The error is set only when the program could not be run.
+func Exec(program string, args []string) (string, int, error) {
+	return runCommand(exec.Command(program, args...))
+}
+
+// runCommand runs cmd with the console's input and error output
+func runCommand(cmd *exec.Cmd) (string, int, error) {
+	var out bytes.Buffer
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = &out
+	cmd.Stderr = os.Stderr
+	err := cmd.Run()
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		return out.String(), exitErr.ExitCode(), nil
+	}
+	if err != nil {
+		return out.String(), -1, err
+	}
+	return out.String(), 0, nil
+}`,
+	"Beep": `// Beep rings the terminal bell
+func Beep() {
+	fmt.Print("\a")
+}`,
+	"Tone": `// Tone plays a sine wave of freq hertz for ms milliseconds; a freq of 0
+// or less is a rest
+func Tone(freq, ms int) error {
+	if freq <= 0 {
+		time.Sleep(time.Duration(ms) * time.Millisecond)
+		return nil
+	}
+	f, err := os.CreateTemp("", "dbasic-tone-*.wav")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	_, err = f.Write(toneWav(freq, ms))
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return PlayWav(f.Name())
+}
+
+// toneWav returns a 16-bit mono WAV file of a sine wave, faded in and out
+// over 5ms so that it does not click
+func toneWav(freq, ms int) []byte {
+	const rate = 44100
+	n := rate * ms / 1000
+	var buf bytes.Buffer
+	write := func(v interface{}) { binary.Write(&buf, binary.LittleEndian, v) }
+	buf.WriteString("RIFF")
+	write(uint32(36 + 2*n))
+	buf.WriteString("WAVEfmt ")
+	// PCM format: 1 channel at rate samples per second, 2 bytes per sample
+	for _, v := range []interface{}{uint32(16), uint16(1), uint16(1), uint32(rate), uint32(2 * rate), uint16(2), uint16(16)} {
+		write(v)
+	}
+	buf.WriteString("data")
+	write(uint32(2 * n))
+	fade := rate / 200
+	for i := 0; i < n; i++ {
+		amp := 0.5
+		if i < fade {
+			amp *= float64(i) / float64(fade)
+		} else if n-i < fade {
+			amp *= float64(n-i) / float64(fade)
+		}
+		write(int16(amp * 32767 * math.Sin(2*math.Pi*float64(freq)*float64(i)/rate)))
+	}
+	return buf.Bytes()
+}`,
+	"PlayWav": `// PlayWav plays a WAV file and waits until it has finished, using afplay
+// on macOS, PowerShell on Windows and paplay, pw-play, aplay or ffplay on
+// other systems
+func PlayWav(path string) error {
+	if _, err := os.Stat(path); err != nil {
+		return err
+	}
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "windows":
+		script := "(New-Object Media.SoundPlayer '" + strings.ReplaceAll(path, "'", "''") + "').PlaySync()"
+		cmd = exec.Command("powershell", "-NoProfile", "-Command", script)
+	case "darwin":
+		cmd = exec.Command("afplay", path)
+	default:
+		for _, player := range [][]string{{"paplay"}, {"pw-play"}, {"aplay", "-q"}, {"ffplay", "-nodisp", "-autoexit", "-loglevel", "quiet"}} {
+			if _, err := exec.LookPath(player[0]); err == nil {
+				cmd = exec.Command(player[0], append(player[1:], path)...)
+				break
+			}
+		}
+		if cmd == nil {
+			return fmt.Errorf("PlayWav: no audio player found; install paplay, aplay or ffplay")
+		}
+	}
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("PlayWav: %v %s", err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}`,
+	"Screen": `// Screen opens a graphics window of w by h pixels, or clears and resizes
+// it. The window is scaled up by a power of two to be easy to see.
+func Screen(w, h int) {
+	gfx.Lock()
+	first := gfx.back == nil
+	gfx.back = image.NewRGBA(image.Rect(0, 0, w, h))
+	gfx.front = image.NewRGBA(image.Rect(0, 0, w, h))
+	draw.Draw(gfx.back, gfx.back.Bounds(), image.Black, image.Point{}, draw.Src)
+	draw.Draw(gfx.front, gfx.front.Bounds(), image.Black, image.Point{}, draw.Src)
+	gfx.flipped = false
+	gfx.Unlock()
+	scale := 1
+	for w*scale*2 <= 1280 && h*scale*2 <= 960 {
+		scale *= 2
+	}
+	ebiten.SetWindowSize(w*scale, h*scale)
+	if first {
+		gfxOpen <- struct{}{}
+	}
+}
+
+// gfx is the graphics window: the program draws on back, and the window
+// shows back until the first Flip and front after it
+var gfx struct {
+	sync.Mutex
+	back, front *image.RGBA
+	flipped     bool
+}
+
+var (
+	gfxOpen  = make(chan struct{}, 1) // Screen has been called
+	gfxFrame = make(chan struct{}, 1) // The window has drawn a frame
+)
+
+// runGraphics runs main, and once Screen is called runs the window on the
+// main thread as ebiten requires. Closing the window ends the program; if
+// main finishes first, the window stays open until it is closed.
+func runGraphics(main func()) {
+	done := make(chan struct{})
+	go func() {
+		main()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-gfxOpen:
+		ebiten.SetWindowTitle(filepath.Base(os.Args[0]))
+		if err := ebiten.RunGame(gfxGame{}); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	}
+}
+
+// gfxGame shows the graphics window's image through ebiten
+type gfxGame struct{}
+
+func (gfxGame) Update() error { return nil }
+
+func (gfxGame) Draw(screen *ebiten.Image) {
+	gfx.Lock()
+	img := gfx.back
+	if gfx.flipped {
+		img = gfx.front
+	}
+	if img.Bounds().Size() == screen.Bounds().Size() {
+		screen.WritePixels(img.Pix)
+	}
+	gfx.Unlock()
+	select {
+	case gfxFrame <- struct{}{}:
+	default:
+	}
+}
+
+func (gfxGame) Layout(int, int) (int, int) {
+	gfx.Lock()
+	defer gfx.Unlock()
+	return gfx.back.Bounds().Dx(), gfx.back.Bounds().Dy()
+}
+
+// gfxCanvas returns the image to draw on; the caller holds gfx's lock
+func gfxCanvas(fn string) *image.RGBA {
+	if gfx.back == nil {
+		panic(fn + ": no graphics window; call Screen first")
+	}
+	return gfx.back
+}
+
+// gfxLine draws a line with Bresenham's algorithm
+func gfxLine(img *image.RGBA, x1, y1, x2, y2 int, c color.RGBA) {
+	dx, dy := x2-x1, y2-y1
+	sx, sy := 1, 1
+	if dx < 0 {
+		dx, sx = -dx, -1
+	}
+	if dy < 0 {
+		dy, sy = -dy, -1
+	}
+	err := dx - dy
+	for {
+		img.SetRGBA(x1, y1, c)
+		if x1 == x2 && y1 == y2 {
+			return
+		}
+		if e2 := 2 * err; e2 > -dy {
+			err -= dy
+			x1 += sx
+		} else {
+			err += dx
+			y1 += sy
+		}
+	}
+}`,
+	"PSet": `// PSet sets the pixel at x, y to color c
+func PSet(x, y, c int) {
+	gfx.Lock()
+	defer gfx.Unlock()
+	gfxCanvas("PSet").SetRGBA(x, y, gfxColor(c))
+}`,
+	"Line": `// Line draws a line from x1, y1 to x2, y2 in color c
+func Line(x1, y1, x2, y2, c int) {
+	gfx.Lock()
+	defer gfx.Unlock()
+	gfxLine(gfxCanvas("Line"), x1, y1, x2, y2, gfxColor(c))
+}`,
+	"Circle": `// Circle draws a circle of radius r around x, y in color c, filled when
+// fill is TRUE
+func Circle(x, y, r, c int, fill ...bool) {
+	gfx.Lock()
+	defer gfx.Unlock()
+	img, col := gfxCanvas("Circle"), gfxColor(c)
+	filled := len(fill) > 0 && fill[0]
+	plot := func(dx, dy int) {
+		if filled {
+			gfxLine(img, x-dx, y+dy, x+dx, y+dy, col)
+			gfxLine(img, x-dx, y-dy, x+dx, y-dy, col)
+			return
+		}
+		for _, p := range [][2]int{{dx, dy}, {-dx, dy}, {dx, -dy}, {-dx, -dy}} {
+			img.SetRGBA(x+p[0], y+p[1], col)
+		}
+	}
+	// Midpoint circle algorithm, one octant mirrored into the others
+	px, py, err := r, 0, 1-r
+	for px >= py {
+		plot(px, py)
+		plot(py, px)
+		py++
+		if err < 0 {
+			err += 2*py + 1
+		} else {
+			px--
+			err += 2*(py-px) + 1
+		}
+	}
+}`,
+	"Rect": `// Rect draws the rectangle with corners x1, y1 and x2, y2 in color c,
+// filled when fill is TRUE
+func Rect(x1, y1, x2, y2, c int, fill ...bool) {
+	gfx.Lock()
+	defer gfx.Unlock()
+	img, col := gfxCanvas("Rect"), gfxColor(c)
+	if len(fill) > 0 && fill[0] {
+		r := image.Rect(x1, y1, x2, y2)
+		r.Max = r.Max.Add(image.Pt(1, 1))
+		draw.Draw(img, r, &image.Uniform{col}, image.Point{}, draw.Src)
+		return
+	}
+	gfxLine(img, x1, y1, x2, y1, col)
+	gfxLine(img, x2, y1, x2, y2, col)
+	gfxLine(img, x2, y2, x1, y2, col)
+	gfxLine(img, x1, y2, x1, y1, col)
+}`,
+	"Text": `// Text draws s in color c with the top left of its first character at x, y,
+// in a 7x13 pixel font
+func Text(x, y int, s string, c int) {
+	gfx.Lock()
+	defer gfx.Unlock()
+	d := &font.Drawer{
+		Dst:  gfxCanvas("Text"),
+		Src:  image.NewUniform(gfxColor(c)),
+		Face: basicfont.Face7x13,
+		Dot:  fixed.P(x, y+basicfont.Face7x13.Ascent),
+	}
+	d.DrawString(s)
+}`,
+	"Flip": `// Flip shows everything drawn since the last Flip, then waits for the
+// window's next frame, which paces an animation loop to the display
+func Flip() {
+	gfx.Lock()
+	copy(gfx.front.Pix, gfxCanvas("Flip").Pix)
+	gfx.flipped = true
+	gfx.Unlock()
+	select {
+	case <-gfxFrame:
+	default:
+	}
+	<-gfxFrame
+}`,
+	"RGB": `// RGB returns a color from red, green and blue levels of 0-255, for the
+// graphics functions
+func RGB(r, g, b int) int {
+	return 1<<24 | (r&255)<<16 | (g&255)<<8 | b&255
+}
+
+// gfxPalette holds the 16 QBasic colors
+var gfxPalette = [16]color.RGBA{
+	{0, 0, 0, 255}, {0, 0, 170, 255}, {0, 170, 0, 255}, {0, 170, 170, 255},
+	{170, 0, 0, 255}, {170, 0, 170, 255}, {170, 85, 0, 255}, {170, 170, 170, 255},
+	{85, 85, 85, 255}, {85, 85, 255, 255}, {85, 255, 85, 255}, {85, 255, 255, 255},
+	{255, 85, 85, 255}, {255, 85, 255, 255}, {255, 255, 85, 255}, {255, 255, 255, 255},
+}
+
+// gfxColor returns color c: 0-15 from the palette, or a value made by RGB
+func gfxColor(c int) color.RGBA {
+	if c >= 0 && c < len(gfxPalette) {
+		return gfxPalette[c]
+	}
+	return color.RGBA{uint8(c >> 16), uint8(c >> 8), uint8(c), 255}
+}`,
+	"DrawImage": `// DrawImage draws img with its top left corner at x, y
+func DrawImage(img *image.RGBA, x, y int) {
+	gfx.Lock()
+	defer gfx.Unlock()
+	draw.Draw(gfxCanvas("DrawImage"), img.Bounds().Add(image.Pt(x, y)), img, image.Point{}, draw.Over)
+}`,
+	"LoadImage": `// LoadImage reads a PNG, JPEG or GIF file
+func LoadImage(path string) (*image.RGBA, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	src, _, err := image.Decode(f)
+	if err != nil {
+		return nil, fmt.Errorf("LoadImage: %s: %w", path, err)
+	}
+	if img, ok := src.(*image.RGBA); ok && img.Rect.Min == (image.Point{}) {
+		return img, nil
+	}
+	b := src.Bounds()
+	img := image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(img, img.Bounds(), src, b.Min, draw.Src)
+	return img, nil
+}`,
+	"SaveImage": `// SaveImage writes img in the format its extension names: .png, .jpg,
+// .jpeg or .gif
+func SaveImage(img *image.RGBA, path string) error {
+	var encode func(io.Writer, image.Image) error
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".png":
+		encode = png.Encode
+	case ".jpg", ".jpeg":
+		encode = func(w io.Writer, m image.Image) error {
+			return jpeg.Encode(w, m, &jpeg.Options{Quality: 90})
+		}
+	case ".gif":
+		encode = func(w io.Writer, m image.Image) error {
+			return gif.Encode(w, m, nil)
+		}
+	default:
+		return fmt.Errorf("SaveImage: unknown image format %q", ext)
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	err = encode(f, img)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}`,
+	"NewImage": `// NewImage returns a black image of w by h pixels
+func NewImage(w, h int) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	draw.Draw(img, img.Bounds(), image.Black, image.Point{}, draw.Src)
+	return img
+}`,
+	"ImageWidth": `// ImageWidth returns the width of img in pixels
+func ImageWidth(img *image.RGBA) int {
+	return img.Bounds().Dx()
+}`,
+	"ImageHeight": `// ImageHeight returns the height of img in pixels
+func ImageHeight(img *image.RGBA) int {
+	return img.Bounds().Dy()
+}`,
+	"GetPixel": `// GetPixel returns the color at x, y as RGB would make it; outside the
+// image it is black
+func GetPixel(img *image.RGBA, x, y int) int {
+	c := img.RGBAAt(x, y)
+	return 1<<24 | int(c.R)<<16 | int(c.G)<<8 | int(c.B)
+}`,
+	"SetPixel": `// SetPixel sets the pixel at x, y to color c; outside the image it does
+// nothing
+func SetPixel(img *image.RGBA, x, y, c int) {
+	img.SetRGBA(x, y, gfxColor(c))
+}`,
+	"Resize": `// Resize returns a copy of img scaled to w by h pixels. A w or h of 0
+// keeps the aspect ratio, so Resize(img, 200, 0) makes a thumbnail 200
+// pixels wide.
+func Resize(img *image.RGBA, w, h int) *image.RGBA {
+	b := img.Bounds()
+	switch {
+	case w <= 0 && h <= 0:
+		w, h = b.Dx(), b.Dy()
+	case w <= 0:
+		w = max(1, b.Dx()*h/max(1, b.Dy()))
+	case h <= 0:
+		h = max(1, b.Dy()*w/max(1, b.Dx()))
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	xdraw.CatmullRom.Scale(dst, dst.Bounds(), img, b, xdraw.Src, nil)
+	return dst
+}`,
+	"Shell": `// Shell runs command with the system shell (sh, or cmd on Windows); the
+// results are as for Exec
+func Shell(command string) (string, int, error) {
+	if runtime.GOOS == "windows" {
+		return runCommand(exec.Command("cmd", "/C", command))
+	}
+	return runCommand(exec.Command("sh", "-c", command))
+}`,
+	"ExecAsync": `// ExecAsync starts program with args and sends each line of its standard
+// output on the returned channel, which is closed when the program exits or
+// could not be started
+func ExecAsync(program string, args []string) chan string {
+	lines := make(chan string)
+	cmd := exec.Command(program, args...)
+	cmd.Stderr = os.Stderr
+	out, err := cmd.StdoutPipe()
+	if err == nil {
+		err = cmd.Start()
+	}
+	if err != nil {
+		close(lines)
+		return lines
+	}
+	go func() {
+		defer close(lines)
+		scanner := bufio.NewScanner(out)
+		for scanner.Scan() {
+			lines <- scanner.Text()
+		}
+		cmd.Wait()
+	}()
+	return lines
+}`,
+	"CSVParse": `// CSVParse parses CSV text into rows of fields, or nil if it is malformed
+func CSVParse(text string) [][]string {
+	r := csv.NewReader(strings.NewReader(text))
+	r.FieldsPerRecord = -1
+	rows, err := r.ReadAll()
+	if err != nil {
+		return nil
+	}
+	return rows
+}`,
+	"CSVRead": `// CSVRead reads a CSV file into rows of fields
+func CSVRead(path string) [][]string {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil
+	}
+	return CSVParse(string(data))
+}`,
+	"CSVWrite": `// CSVWrite writes rows of fields to a CSV file
+func CSVWrite(path string, rows [][]string) {
+	f, err := os.Create(path)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	w := csv.NewWriter(f)
+	w.WriteAll(rows)
+}`,
+	"CSVParseRecords": `// CSVParseRecords parses CSV text whose first row names the columns into
+// one JSON object per row
+func CSVParseRecords(text string) []map[string]interface{} {
+	rows := CSVParse(text)
+	if len(rows) == 0 {
+		return nil
+	}
+	header := rows[0]
+	records := make([]map[string]interface{}, 0, len(rows)-1)
+	for _, row := range rows[1:] {
+		record := make(map[string]interface{}, len(header))
+		for i, name := range header {
+			if i < len(row) {
+				record[name] = row[i]
+			} else {
+				record[name] = ""
+			}
+		}
+		records = append(records, record)
+	}
+	return records
+}`,
+	"CSVReadRecords": `// CSVReadRecords reads a CSV file whose first row names the columns into
+// one JSON object per row
+func CSVReadRecords(path string) []map[string]interface{} {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil
+	}
+	return CSVParseRecords(string(data))
+}`,
+	"INILoad": `// INILoad reads an INI file into a map of sections, each a map of keys to
+// values. Keys before the first section are kept at the top level. A file
+// that cannot be read gives an empty map.
+func INILoad(path string) map[string]interface{} {
+	result := map[string]interface{}{}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return result
+	}
+	section := result
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || line[0] == ';' || line[0] == '#' {
+			continue
+		}
+		if line[0] == '[' && line[len(line)-1] == ']' {
+			name := strings.TrimSpace(line[1 : len(line)-1])
+			if existing, ok := result[name].(map[string]interface{}); ok {
+				section = existing
+			} else {
+				section = map[string]interface{}{}
+				result[name] = section
+			}
+			continue
+		}
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			continue
+		}
+		value = strings.TrimSpace(value)
+		if len(value) >= 2 && value[0] == '"' && value[len(value)-1] == '"' {
+			value = value[1 : len(value)-1]
+		}
+		section[strings.TrimSpace(key)] = value
+	}
+	return result
+}`,
+	"INIGet": `// INIGet returns a key from a section of a loaded INI file ("" for the
+// top level), or def if it is not there
+func INIGet(ini map[string]interface{}, section, key, def string) string {
+	values := ini
+	if section != "" {
+		var ok bool
+		if values, ok = ini[section].(map[string]interface{}); !ok {
+			return def
+		}
+	}
+	value, ok := values[key]
+	if !ok || value == nil {
+		return def
+	}
+	if _, isSection := value.(map[string]interface{}); isSection {
+		return def
+	}
+	return fmt.Sprint(value)
+}`,
+	"INISave": `// INISave writes a map of sections to an INI file, with sections and keys
+// in sorted order
+func INISave(path string, ini map[string]interface{}) {
+	var b strings.Builder
+	writeKeys := func(values map[string]interface{}) {
+		keys := make([]string, 0, len(values))
+		for key, value := range values {
+			if _, isSection := value.(map[string]interface{}); !isSection {
+				keys = append(keys, key)
+			}
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			fmt.Fprintf(&b, "%s = %v\n", key, values[key])
+		}
+	}
+	writeKeys(ini)
+	sections := make([]string, 0, len(ini))
+	for name, value := range ini {
+		if _, isSection := value.(map[string]interface{}); isSection {
+			sections = append(sections, name)
+		}
+	}
+	sort.Strings(sections)
+	for _, name := range sections {
+		if b.Len() > 0 {
+			b.WriteString("\n")
+		}
+		fmt.Fprintf(&b, "[%s]\n", name)
+		writeKeys(ini[name].(map[string]interface{}))
+	}
+	os.WriteFile(path, []byte(b.String()), 0644)
+}`,
+	"JSONParse": `// JSONParse parses a JSON string into a map
+func JSONParse(s string) map[string]interface{} {
+	var result map[string]interface{}
+	json.Unmarshal([]byte(s), &result)
+	return result
+}`,
+	"JSONStringify": `// JSONStringify converts a map to JSON string
+func JSONStringify(data map[string]interface{}) string {
+	b, _ := json.Marshal(data)
+	return string(b)
+}`,
+	"JSONPretty": `// JSONPretty converts a map to pretty-printed JSON string
+func JSONPretty(data map[string]interface{}) string {
+	b, _ := json.MarshalIndent(data, "", "  ")
+	return string(b)
+}`,
+	"RenderTemplate": `// RenderTemplate fills in a text/template with data, usually a JSON object
+func RenderTemplate(text string, data interface{}) string {
+	t, err := template.New("template").Parse(text)
+	return execTemplate("RenderTemplate", t, err, data)
+}
+
+// execTemplate runs a parsed template, raising a runtime error if it could
+// not be parsed or run
+func execTemplate(fn string, t *template.Template, err error, data interface{}) string {
+	if err != nil {
+		panic(fn + ": " + err.Error())
+	}
+	var b strings.Builder
+	if err := t.Execute(&b, data); err != nil {
+		panic(fn + ": " + err.Error())
+	}
+	return b.String()
+}`,
+	"RenderTemplateFile": `// RenderTemplateFile fills in the text/template in a file with data
+func RenderTemplateFile(path string, data interface{}) string {
+	t, err := template.ParseFiles(path)
+	return execTemplate("RenderTemplateFile", t, err, data)
+}`,
+	"YAMLParse": `// YAMLParse parses a YAML document into a map
+func YAMLParse(s string) map[string]interface{} {
+	var result map[string]interface{}
+	yaml.Unmarshal([]byte(s), &result)
+	return result
+}`,
+	"YAMLStringify": `// YAMLStringify converts a map to a YAML document
+func YAMLStringify(data map[string]interface{}) string {
+	var b bytes.Buffer
+	enc := yaml.NewEncoder(&b)
+	enc.SetIndent(2)
+	enc.Encode(data)
+	enc.Close()
+	return b.String()
+}`,
+	"TOMLParse": `// TOMLParse parses a TOML document into a map
+func TOMLParse(s string) map[string]interface{} {
+	var result map[string]interface{}
+	toml.Unmarshal([]byte(s), &result)
+	return result
+}`,
+	"TOMLStringify": `// TOMLStringify converts a map to a TOML document
+func TOMLStringify(data map[string]interface{}) string {
+	var b bytes.Buffer
+	enc := toml.NewEncoder(&b)
+	enc.Indent = ""
+	enc.Encode(data)
+	return b.String()
+}`,
+	"TcpListen": `// TcpListen starts a server listening on address, such as ":8080"
+func TcpListen(address string) (net.Listener, error) {
+	return net.Listen("tcp", address)
+}`,
+	"TcpAccept": `// TcpAccept waits for the next connection to a listener
+func TcpAccept(l net.Listener) (net.Conn, error) {
+	return l.Accept()
+}`,
+	"TcpConnect": `// TcpConnect connects to address, such as "example.com:80"
+func TcpConnect(address string) (net.Conn, error) {
+	return net.Dial("tcp", address)
+}`,
+	"TcpSend": `// TcpSend writes data to a connection exactly as given
+func TcpSend(conn net.Conn, data string) error {
+	_, err := io.WriteString(conn, data)
+	return err
+}`,
+	"TcpReceive": `// tcpReaders buffers what each connection has received past the last line
+var (
+	tcpReaders   = map[net.Conn]*bufio.Reader{}
+	tcpReadersMu sync.Mutex
+)
+
+// TcpReceive reads the next line from a connection, without its line ending.
+// The error is io.EOF once the other side has closed the connection.
+func TcpReceive(conn net.Conn) (string, error) {
+	tcpReadersMu.Lock()
+	r, ok := tcpReaders[conn]
+	if !ok {
+		r = bufio.NewReader(conn)
+		tcpReaders[conn] = r
+	}
+	tcpReadersMu.Unlock()
+	line, err := r.ReadString('\n')
+	if line != "" {
+		err = nil
+	}
+	return strings.TrimRight(line, "\r\n"), err
+}`,
+	"TcpReceiveAsync": `// TcpReceiveAsync sends each line received on a connection to the returned
+// channel, which is closed when the connection ends
+func TcpReceiveAsync(conn net.Conn) chan string {
+	lines := make(chan string)
+	go func() {
+		defer close(lines)
+		for {
+			line, err := TcpReceive(conn)
+			if err != nil {
+				return
+			}
+			lines <- line
+		}
+	}()
+	return lines
+}`,
+	"TcpClose": `// TcpClose closes a connection or a listener
+func TcpClose(c io.Closer) {
+	if c == nil {
+		return
+	}
+	if conn, ok := c.(net.Conn); ok {
+		tcpReadersMu.Lock()
+		delete(tcpReaders, conn)
+		tcpReadersMu.Unlock()
+	}
+	c.Close()
+}`,
+	"UdpBind": `// UdpBind opens a UDP socket on address, such as ":9999", or ":0" for any
+// free port
+func UdpBind(address string) (net.Conn, error) {
+	addr, err := net.ResolveUDPAddr("udp", address)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.ListenUDP("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	return conn, nil
+}`,
+	"UdpSendTo": `// UdpSendTo sends data as one datagram to address, such as
+// "255.255.255.255:9999" to broadcast
+func UdpSendTo(conn net.Conn, address, data string) error {
+	pc, ok := conn.(net.PacketConn)
+	if !ok {
+		return errors.New("UdpSendTo: not a UDP socket")
+	}
+	addr, err := net.ResolveUDPAddr("udp", address)
+	if err != nil {
+		return err
+	}
+	_, err = pc.WriteTo([]byte(data), addr)
+	return err
+}`,
+	"UdpReceiveFrom": `// UdpReceiveFrom waits for the next datagram and returns it with the
+// address of its sender
+func UdpReceiveFrom(conn net.Conn) (string, string, error) {
+	pc, ok := conn.(net.PacketConn)
+	if !ok {
+		return "", "", errors.New("UdpReceiveFrom: not a UDP socket")
+	}
+	buf := make([]byte, 65535)
+	n, addr, err := pc.ReadFrom(buf)
+	if err != nil {
+		return "", "", err
+	}
+	return string(buf[:n]), addr.String(), nil
+}`,
+	"UdpClose": `// UdpClose closes a UDP socket
+func UdpClose(conn net.Conn) {
+	if conn != nil {
+		conn.Close()
+	}
+}`,
+	"WsConnect": `// WsConnect opens a WebSocket to url, such as "ws://localhost:8080/chat"
+func WsConnect(url string) (net.Conn, error) {
+	origin := strings.Replace(url, "ws", "http", 1)
+	if i := strings.Index(origin, "://"); i >= 0 {
+		if j := strings.Index(origin[i+3:], "/"); j >= 0 {
+			origin = origin[:i+3+j]
+		}
+	}
+	return websocket.Dial(url, "", origin)
+}`,
+	"wsOf": `// wsConn is a WebSocket accepted by WsListen; the server keeps it open
+// until the program closes it
+type wsConn struct {
+	*websocket.Conn
+	done chan struct{}
+	once sync.Once
+}
+
+func (c *wsConn) Close() error {
+	c.once.Do(func() { close(c.done) })
+	return c.Conn.Close()
+}
+
+// wsOf returns the WebSocket behind a connection, or nil if it is not one
+func wsOf(conn net.Conn) *websocket.Conn {
+	switch c := conn.(type) {
+	case *websocket.Conn:
+		return c
+	case *wsConn:
+		return c.Conn
+	}
+	return nil
+}`,
+	"WsListen": `// wsListener hands out the WebSockets opened to a WsListen server
+type wsListener struct {
+	net.Listener
+	conns  chan net.Conn
+	closed chan struct{}
+	once   sync.Once
+}
+
+func (l *wsListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.closed:
+		return nil, net.ErrClosed
+	}
+}
+
+func (l *wsListener) Close() error {
+	l.once.Do(func() { close(l.closed) })
+	return l.Listener.Close()
+}
+
+// WsListen starts a server on address, such as ":8080", that accepts
+// WebSockets on any path
+func WsListen(address string) (net.Listener, error) {
+	tcp, err := net.Listen("tcp", address)
+	if err != nil {
+		return nil, err
+	}
+	l := &wsListener{Listener: tcp, conns: make(chan net.Conn), closed: make(chan struct{})}
+	go http.Serve(tcp, websocket.Server{Handler: func(ws *websocket.Conn) {
+		c := &wsConn{Conn: ws, done: make(chan struct{})}
+		select {
+		case l.conns <- c:
+			<-c.done
+		case <-l.closed:
+		}
+	}})
+	return l, nil
+}`,
+	"WsAccept": `// WsAccept waits for the next WebSocket opened to a WsListen server
+func WsAccept(l net.Listener) (net.Conn, error) {
+	return l.Accept()
+}`,
+	"WsSend": `// WsSend sends one text message
+func WsSend(conn net.Conn, msg string) error {
+	ws := wsOf(conn)
+	if ws == nil {
+		return errors.New("WsSend: not a WebSocket")
+	}
+	return websocket.Message.Send(ws, msg)
+}`,
+	"WsReceive": `// WsReceive waits for the next message. The error is io.EOF once the other
+// side has closed the WebSocket.
+func WsReceive(conn net.Conn) (string, error) {
+	ws := wsOf(conn)
+	if ws == nil {
+		return "", errors.New("WsReceive: not a WebSocket")
+	}
+	var msg string
+	err := websocket.Message.Receive(ws, &msg)
+	return msg, err
+}`,
+	"WsReceiveAsync": `// WsReceiveAsync sends each message received to the returned channel,
+// which is closed when the WebSocket ends
+func WsReceiveAsync(conn net.Conn) chan string {
+	msgs := make(chan string)
+	go func() {
+		defer close(msgs)
+		for {
+			msg, err := WsReceive(conn)
+			if err != nil {
+				return
+			}
+			msgs <- msg
+		}
+	}()
+	return msgs
+}`,
+	"WsClose": `// WsClose closes a WebSocket or a WsListen server
+func WsClose(c io.Closer) {
+	if c != nil {
+		c.Close()
+	}
+}`,
+	"KVOpen": `// kvStore is a key-value store kept in a JSON file, which is rewritten on
+// each change
+type kvStore struct {
+	path   string
+	values map[string]string
+	mu     sync.Mutex
+}
+
+// KVOpen opens a store, creating it on the first KVSet if the file does
+// not exist yet
+func KVOpen(path string) (*kvStore, error) {
+	s := &kvStore{path: path, values: map[string]string{}}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &s.values); err != nil {
+		return nil, fmt.Errorf("KVOpen %s: %v", path, err)
+	}
+	return s, nil
+}
+
+// save writes the store to a temporary file and renames it over the old
+// one, so a crash never leaves half a file
+func (s *kvStore) save() error {
+	data, err := json.MarshalIndent(s.values, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.path)
+}
+
+// KVGet returns the value stored under key, or "" if there is none
+func KVGet(s *kvStore, key string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.values[key]
+}
+
+// KVHas reports whether a value is stored under key
+func KVHas(s *kvStore, key string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, ok := s.values[key]
+	return ok
+}
+
+// KVSet stores value under key
+func KVSet(s *kvStore, key, value string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.values[key] = value
+	return s.save()
+}
+
+// KVDelete removes key from the store
+func KVDelete(s *kvStore, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.values[key]; !ok {
+		return nil
+	}
+	delete(s.values, key)
+	return s.save()
+}
+
+// KVKeys returns the keys in sorted order
+func KVKeys(s *kvStore) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	keys := make([]string, 0, len(s.values))
+	for key := range s.values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}`,
+	"SendMail": `// SendMail sends a plain text email through an SMTP server. Port 465 uses
+// TLS from the start; other ports switch to TLS when the server offers it.
+// to may list several addresses separated by commas, and user may be ""
+// for a server that needs no login.
+func SendMail(server string, port int, user, pass, from, to, subject, body string) error {
+	sender, err := mail.ParseAddress(from)
+	if err != nil {
+		return err
+	}
+	recipients, err := mail.ParseAddressList(to)
+	if err != nil {
+		return err
+	}
+	addr := net.JoinHostPort(server, strconv.Itoa(port))
+	tlsConfig := &tls.Config{ServerName: server}
+	var c *smtp.Client
+	if port == 465 {
+		conn, err := tls.Dial("tcp", addr, tlsConfig)
+		if err != nil {
+			return err
+		}
+		if c, err = smtp.NewClient(conn, server); err != nil {
+			conn.Close()
+			return err
+		}
+	} else {
+		if c, err = smtp.Dial(addr); err != nil {
+			return err
+		}
+		if ok, _ := c.Extension("STARTTLS"); ok {
+			if err := c.StartTLS(tlsConfig); err != nil {
+				c.Close()
+				return err
+			}
+		}
+	}
+	defer c.Close()
+	if user != "" {
+		if err := c.Auth(smtp.PlainAuth("", user, pass, server)); err != nil {
+			return err
+		}
+	}
+	if err := c.Mail(sender.Address); err != nil {
+		return err
+	}
+	names := make([]string, len(recipients))
+	for i, r := range recipients {
+		if err := c.Rcpt(r.Address); err != nil {
+			return err
+		}
+		names[i] = r.String()
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	body = strings.ReplaceAll(strings.ReplaceAll(body, "\r\n", "\n"), "\n", "\r\n")
+	fmt.Fprintf(w, "From: %s\r\nTo: %s\r\nSubject: %s\r\nDate: %s\r\n"+
+		"MIME-Version: 1.0\r\nContent-Type: text/plain; charset=UTF-8\r\n\r\n%s\r\n",
+		sender, strings.Join(names, ", "), mime.QEncoding.Encode("utf-8", subject),
+		time.Now().Format(time.RFC1123Z), body)
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}`,
+	"DBOpen": `// DBOpen opens a database and checks that it can be reached. The sqlite
+// driver is always available; other drivers are added with IMPORT _.
+func DBOpen(driver, dsn string) (*sql.DB, error) {
+	db, err := sql.Open(driver, dsn)
+	if err != nil {
+		return nil, err
+	}
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+// DBClose closes a database
+func DBClose(db *sql.DB) {
+	if db != nil {
+		db.Close()
+	}
+}
+
+// dbConn runs statements on a database or inside a transaction
+type dbConn interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+}
+
+// DBExec runs a statement and returns the number of rows it changed
+func DBExec(db dbConn, query string, args ...interface{}) (int64, error) {
+	result, err := db.Exec(query, args...)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// DBInsert runs an INSERT and returns the id of the new row
+func DBInsert(db dbConn, query string, args ...interface{}) (int64, error) {
+	result, err := db.Exec(query, args...)
+	if err != nil {
+		return 0, err
+	}
+	return result.LastInsertId()
+}
+
+// DBQuery runs a query and returns each row as a JSON object keyed by
+// column name
+func DBQuery(db dbConn, query string, args ...interface{}) ([]map[string]interface{}, error) {
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+	var result []map[string]interface{}
+	for rows.Next() {
+		values := make([]interface{}, len(columns))
+		ptrs := make([]interface{}, len(columns))
+		for i := range values {
+			ptrs[i] = &values[i]
+		}
+		if err := rows.Scan(ptrs...); err != nil {
+			return nil, err
+		}
+		row := make(map[string]interface{}, len(columns))
+		for i, column := range columns {
+			if b, ok := values[i].([]byte); ok {
+				values[i] = string(b)
+			}
+			row[column] = values[i]
+		}
+		result = append(result, row)
+	}
+	return result, rows.Err()
+}
+
+// DBQueryRow runs a query and returns its first row; the error is
+// sql.ErrNoRows if there is none
+func DBQueryRow(db dbConn, query string, args ...interface{}) (map[string]interface{}, error) {
+	rows, err := DBQuery(db, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	if len(rows) == 0 {
+		return nil, sql.ErrNoRows
+	}
+	return rows[0], nil
+}
+
+// DBBegin starts a transaction
+func DBBegin(db *sql.DB) (*sql.Tx, error) {
+	return db.Begin()
+}
+
+// DBCommit saves the changes made in a transaction
+func DBCommit(tx *sql.Tx) error {
+	return tx.Commit()
+}
+
+// DBRollback discards the changes made in a transaction
+func DBRollback(tx *sql.Tx) error {
+	return tx.Rollback()
+}`,
+	"XMLParse": `// XMLParse parses an XML document into a map keyed by the root element.
+// An element with only text becomes a string; otherwise it is a map with
+// "@name" keys for attributes, "#text" for its text and a key per child
+// element, holding a slice when the child repeats. Parsing is lenient about
+// HTML entities and unclosed tags; input it cannot read gives nil.
+func XMLParse(s string) map[string]interface{} {
+	type xmlNode struct {
+		fields map[string]interface{}
+		text   strings.Builder
+	}
+	add := func(fields map[string]interface{}, name string, value interface{}) {
+		switch existing := fields[name].(type) {
+		case nil:
+			fields[name] = value
+		case []interface{}:
+			fields[name] = append(existing, value)
+		default:
+			fields[name] = []interface{}{existing, value}
+		}
+	}
+	d := xml.NewDecoder(strings.NewReader(s))
+	d.Strict = false
+	d.Entity = xml.HTMLEntity
+	result := map[string]interface{}{}
+	var stack []*xmlNode
+	for {
+		tok, err := d.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			n := &xmlNode{fields: map[string]interface{}{}}
+			for _, attr := range t.Attr {
+				n.fields["@"+attr.Name.Local] = attr.Value
+			}
+			stack = append(stack, n)
+		case xml.CharData:
+			if len(stack) > 0 {
+				stack[len(stack)-1].text.Write(t)
+			}
+		case xml.EndElement:
+			n := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			text := strings.TrimSpace(n.text.String())
+			var value interface{} = text
+			if len(n.fields) > 0 {
+				if text != "" {
+					n.fields["#text"] = text
+				}
+				value = n.fields
+			}
+			if len(stack) == 0 {
+				result[t.Name.Local] = value
+			} else {
+				add(stack[len(stack)-1].fields, t.Name.Local, value)
+			}
+		}
+	}
+	return result
+}`,
+	"XMLGetAll": `// XMLGetAll returns the text of every element matching a path such as
+// "rss/channel/item/title", or an attribute with "feed/entry@id". A step
+// may pick one of its repeated elements with an index from 1: "item[2]".
+func XMLGetAll(doc map[string]interface{}, path string) []string {
+	path, attr, _ := strings.Cut(path, "@")
+	nodes := []interface{}{doc}
+	for _, step := range strings.Split(strings.Trim(path, "/"), "/") {
+		if step == "" {
+			continue
+		}
+		index := 0
+		if open := strings.Index(step, "["); open >= 0 && strings.HasSuffix(step, "]") {
+			index, _ = strconv.Atoi(step[open+1 : len(step)-1])
+			step = step[:open]
+		}
+		var next []interface{}
+		for _, node := range nodes {
+			fields, ok := node.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			matches := xmlElements(fields[step])
+			if index == 0 {
+				next = append(next, matches...)
+			} else if index <= len(matches) {
+				next = append(next, matches[index-1])
+			}
+		}
+		nodes = next
+	}
+	values := []string{}
+	for _, node := range nodes {
+		fields, isElement := node.(map[string]interface{})
+		switch {
+		case attr != "":
+			if value, ok := fields["@"+attr]; ok {
+				values = append(values, fmt.Sprint(value))
+			}
+		case isElement:
+			text, _ := fields["#text"].(string)
+			values = append(values, text)
+		default:
+			values = append(values, fmt.Sprint(node))
+		}
+	}
+	return values
+}
+
+// xmlElements returns the elements stored under one name
+func xmlElements(value interface{}) []interface{} {
+	switch v := value.(type) {
+	case nil:
+		return nil
+	case []interface{}:
+		return v
+	default:
+		return []interface{}{v}
+	}
+}`,
+	"XMLGet": `// XMLGet returns the text of the first element or attribute matching a
+// path, or "" if there is none
+func XMLGet(doc map[string]interface{}, path string) string {
+	values := XMLGetAll(doc, path)
+	if len(values) == 0 {
+		return ""
+	}
+	return values[0]
+}`,
+	"XMLStringify": `// XMLStringify converts a map in the form XMLParse returns to an indented
+// XML document, with attributes and elements in sorted order
+func XMLStringify(doc map[string]interface{}) string {
+	var b strings.Builder
+	names := make([]string, 0, len(doc))
+	for name := range doc {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		writeXMLElement(&b, name, doc[name], 0)
+	}
+	return b.String()
+}
+
+// writeXMLElement writes one element, or one per item of a slice
+func writeXMLElement(b *strings.Builder, name string, value interface{}, depth int) {
+	if items, ok := value.([]interface{}); ok {
+		for _, item := range items {
+			writeXMLElement(b, name, item, depth)
+		}
+		return
+	}
+	indent := strings.Repeat("  ", depth)
+	b.WriteString(indent + "<" + name)
+	fields, isMap := value.(map[string]interface{})
+	if !isMap {
+		if value == nil {
+			b.WriteString("/>\n")
+			return
+		}
+		b.WriteString(">")
+		xml.EscapeText(b, []byte(fmt.Sprint(value)))
+		b.WriteString("</" + name + ">\n")
+		return
+	}
+	keys := make([]string, 0, len(fields))
+	for key := range fields {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	var children []string
+	for _, key := range keys {
+		if strings.HasPrefix(key, "@") {
+			b.WriteString(" " + key[1:] + "=\"")
+			xml.EscapeText(b, []byte(fmt.Sprint(fields[key])))
+			b.WriteString("\"")
+		} else if key != "#text" {
+			children = append(children, key)
+		}
+	}
+	text, hasText := fields["#text"]
+	if len(children) == 0 && !hasText {
+		b.WriteString("/>\n")
+		return
+	}
+	b.WriteString(">")
+	if hasText {
+		xml.EscapeText(b, []byte(fmt.Sprint(text)))
+	}
+	if len(children) > 0 {
+		b.WriteString("\n")
+		for _, child := range children {
+			writeXMLElement(b, child, fields[child], depth+1)
+		}
+		b.WriteString(indent)
+	}
+	b.WriteString("</" + name + ">\n")
+}`,
+	"JSONGet": `// JSONGet retrieves a value from a JSON map by path
+func JSONGet(data map[string]interface{}, path string) interface{} {
+	parts := strings.Split(path, ".")
+	var current interface{} = data
+	for _, part := range parts {
+		switch v := current.(type) {
+		case map[string]interface{}:
+			current = v[part]
+		default:
+			return nil
+		}
+	}
+	return current
+}`,
+	"JSONSet": `// JSONSet sets a value in a JSON map by path
+func JSONSet(data map[string]interface{}, path string, value interface{}) {
+	parts := strings.Split(path, ".")
+	current := data
+	for i := 0; i < len(parts)-1; i++ {
+		part := parts[i]
+		if _, ok := current[part]; !ok {
+			current[part] = make(map[string]interface{})
+		}
+		current = current[part].(map[string]interface{})
+	}
+	current[parts[len(parts)-1]] = value
+}`,
+	"StructToJSON": `// StructToJSON converts a struct to a JSON map
+func StructToJSON(v interface{}) map[string]interface{} {
+	result := make(map[string]interface{})
+	val := reflect.ValueOf(v)
+	if val.Kind() == reflect.Ptr {
+		val = val.Elem()
+	}
+	if val.Kind() != reflect.Struct {
+		return result
+	}
+	typ := val.Type()
+	for i := 0; i < val.NumField(); i++ {
+		field := typ.Field(i)
+		fieldVal := val.Field(i)
+		if field.PkgPath != "" {
 			continue
 		}
 		name := field.Tag.Get("json")
@@ -605,6 +3453,507 @@ func WrapError(err error, file string, line int, function string, message string
 		Function: function,
 		Wrapped:  err,
 	}
+}`,
+	"AssertEqual": `// testFail reports a failed assertion. dbasic test points it at the running
+// test; elsewhere a failed assertion panics.
+var testFail func(msg string)
+
+// AssertEqual checks that got equals want. Numbers are compared by value,
+// whatever their types, and other values with reflect.DeepEqual.
+func AssertEqual(got, want interface{}, where string, message ...string) {
+	if !testEqual(got, want) {
+		assertFailed(where, fmt.Sprintf("AssertEqual failed: got %s, want %s", testShow(got), testShow(want)), message)
+	}
+}
+
+// AssertTrue checks that a condition holds
+func AssertTrue(cond bool, where string, message ...string) {
+	if !cond {
+		assertFailed(where, "AssertTrue failed", message)
+	}
+}
+
+func assertFailed(where, msg string, message []string) {
+	if len(message) > 0 && message[0] != "" {
+		msg += ": " + message[0]
+	}
+	msg = where + ": " + msg
+	if testFail != nil {
+		testFail(msg)
+		return
+	}
+	panic(msg)
+}
+
+func testEqual(a, b interface{}) bool {
+	x, y := reflect.ValueOf(a), reflect.ValueOf(b)
+	if testInt(x) && testInt(y) {
+		return testInt64(x) == testInt64(y)
+	}
+	if testNumeric(x) && testNumeric(y) {
+		if x.Kind() == reflect.Float32 || y.Kind() == reflect.Float32 {
+			// A SINGLE only matches a literal at SINGLE precision
+			return float32(testFloat(x)) == float32(testFloat(y))
+		}
+		return testFloat(x) == testFloat(y)
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+func testInt(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return true
+	}
+	return false
+}
+
+func testNumeric(v reflect.Value) bool {
+	return testInt(v) || v.Kind() == reflect.Float32 || v.Kind() == reflect.Float64
+}
+
+func testInt64(v reflect.Value) int64 {
+	if v.CanInt() {
+		return v.Int()
+	}
+	return int64(v.Uint())
+}
+
+func testFloat(v reflect.Value) float64 {
+	switch {
+	case v.CanInt():
+		return float64(v.Int())
+	case v.CanUint():
+		return float64(v.Uint())
+	}
+	return v.Float()
+}
+
+// testShow formats a value for an assertion message, quoting strings
+func testShow(v interface{}) string {
+	if s, ok := v.(string); ok {
+		return fmt.Sprintf("%q", s)
+	}
+	return fmt.Sprintf("%v", v)
+}`,
+	"Timer": `// Timer returns the number of seconds since midnight
+func Timer() float64 {
+	now := time.Now()
+	midnight := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	return now.Sub(midnight).Seconds()
+}`,
+	"Now": `// Now returns the current Unix timestamp in seconds
+func Now() int64 {
+	return time.Now().Unix()
+}`,
+	"Date": `// Date returns the current date as YYYY-MM-DD
+func Date() string {
+	return time.Now().Format("2006-01-02")
+}`,
+	"Year": `// Year returns the year of t, or of the current date
+func Year(t ...time.Time) int {
+	return timeOrNow(t).Year()
+}
+
+// timeOrNow returns the optional time argument, or the current time
+func timeOrNow(t []time.Time) time.Time {
+	if len(t) > 0 {
+		return t[0]
+	}
+	return time.Now()
+}`,
+	"Month": `// Month returns the month (1-12) of t, or of the current date
+func Month(t ...time.Time) int {
+	return int(timeOrNow(t).Month())
+}`,
+	"Day": `// Day returns the day of the month of t, or of the current date
+func Day(t ...time.Time) int {
+	return timeOrNow(t).Day()
+}`,
+	"Hour": `// Hour returns the hour (0-23) of t, or of the current time
+func Hour(t ...time.Time) int {
+	return timeOrNow(t).Hour()
+}`,
+	"Minute": `// Minute returns the minute of t, or of the current time
+func Minute(t ...time.Time) int {
+	return timeOrNow(t).Minute()
+}`,
+	"Second": `// Second returns the second of t, or of the current time
+func Second(t ...time.Time) int {
+	return timeOrNow(t).Second()
+}`,
+	"DateFormat": `// DateFormat formats t with a layout such as "yyyy-mm-dd hh:nn:ss"
+func DateFormat(t time.Time, layout string) string {
+	return t.Format(dateLayout(layout))
+}
+
+// dateLayouts are the layouts DateParse tries when it is given none
+var dateLayouts = []string{time.RFC3339Nano, "2006-01-02 15:04:05", "2006-01-02T15:04:05", "2006-01-02 15:04", "2006-01-02"}
+
+// dateLayout translates a layout in date parts (yyyy, yy, mmmm, mmm, mm, m,
+// dddd, ddd, dd, d, hh, h, nn, n, ss, s, fff, AM/PM, zzz) to a Go layout.
+// As in Format$, mm or m is minutes after an hour or before seconds.
+func dateLayout(layout string) string {
+	parts := []string{"yyyy", "yy", "mmmm", "mmm", "mm", "m", "dddd", "ddd", "dd", "d",
+		"hh", "h", "nn", "n", "ss", "s", "fff", "am/pm", "zzz"}
+	twelveHour := strings.Contains(strings.ToLower(layout), "am/pm")
+	var b strings.Builder
+	afterHour := false
+	for i := 0; i < len(layout); {
+		part := ""
+		for _, p := range parts {
+			if len(layout)-i >= len(p) && strings.EqualFold(layout[i:i+len(p)], p) {
+				part = p
+				break
+			}
+		}
+		if part == "" {
+			b.WriteByte(layout[i])
+			i++
+			continue
+		}
+		text := layout[i : i+len(part)]
+		i += len(part)
+		if part == "mm" || part == "m" {
+			rest := strings.TrimLeft(layout[i:], " :.")
+			if afterHour || strings.HasPrefix(strings.ToLower(rest), "s") {
+				part = map[string]string{"mm": "nn", "m": "n"}[part]
+			}
+		}
+		afterHour = part == "hh" || part == "h"
+		switch part {
+		case "hh", "h":
+			switch {
+			case !twelveHour:
+				b.WriteString("15")
+			case part == "hh":
+				b.WriteString("03")
+			default:
+				b.WriteString("3")
+			}
+		case "am/pm":
+			if text == strings.ToLower(text) {
+				b.WriteString("pm")
+			} else {
+				b.WriteString("PM")
+			}
+		default:
+			b.WriteString(map[string]string{
+				"yyyy": "2006", "yy": "06", "mmmm": "January", "mmm": "Jan", "mm": "01", "m": "1",
+				"dddd": "Monday", "ddd": "Mon", "dd": "02", "d": "2", "nn": "04", "n": "4",
+				"ss": "05", "s": "5", "fff": "000", "zzz": "-07:00",
+			}[part])
+		}
+	}
+	return b.String()
+}`,
+	"DateParse": `// DateParse reads a date and time written in layout, in local time unless
+// the layout has a zzz offset. An empty layout accepts ISO 8601 forms.
+func DateParse(s, layout string) (time.Time, error) {
+	layouts := dateLayouts
+	if layout != "" {
+		layouts = []string{dateLayout(layout)}
+	}
+	s = strings.TrimSpace(s)
+	for _, l := range layouts {
+		if t, err := time.ParseInLocation(l, s, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	if layout == "" {
+		return time.Time{}, fmt.Errorf("DateParse: %q is not a date", s)
+	}
+	return time.Time{}, fmt.Errorf("DateParse: %q does not match %q", s, layout)
+}`,
+	"DateAdd": `// DateAdd returns t moved by n of part: "year", "quarter", "month",
+// "week", "day", "hour", "minute" or "second" (or yyyy, q, m, ww, d, h, n,
+// s). Adding months keeps the day, or uses the last day of a shorter month.
+func DateAdd(t time.Time, part string, n int) time.Time {
+	switch datePart("DateAdd", part) {
+	case "year":
+		return addMonths(t, 12*n)
+	case "quarter":
+		return addMonths(t, 3*n)
+	case "month":
+		return addMonths(t, n)
+	case "week":
+		return t.AddDate(0, 0, 7*n)
+	case "day":
+		return t.AddDate(0, 0, n)
+	case "hour":
+		return t.Add(time.Duration(n) * time.Hour)
+	case "minute":
+		return t.Add(time.Duration(n) * time.Minute)
+	}
+	return t.Add(time.Duration(n) * time.Second)
+}
+
+// datePart returns the name of a DateAdd or DateDiff part, panicking for
+// an unknown one
+func datePart(fn, part string) string {
+	p := strings.ToLower(part)
+	if len(p) > 1 {
+		p = strings.TrimSuffix(p, "s") // "days" is "day"
+	}
+	switch p {
+	case "year", "yyyy":
+		return "year"
+	case "quarter", "q":
+		return "quarter"
+	case "month", "m":
+		return "month"
+	case "week", "ww":
+		return "week"
+	case "day", "d":
+		return "day"
+	case "hour", "h":
+		return "hour"
+	case "minute", "n":
+		return "minute"
+	case "second", "s":
+		return "second"
+	}
+	panic(fmt.Sprintf("%s: unknown date part %q", fn, part))
+}
+
+// addMonths adds n months to t, clamping the day to the end of the month
+func addMonths(t time.Time, n int) time.Time {
+	first := time.Date(t.Year(), t.Month()+time.Month(n), 1, t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), t.Location())
+	day := t.Day()
+	if last := first.AddDate(0, 1, -1).Day(); day > last {
+		day = last
+	}
+	return first.AddDate(0, 0, day-1)
+}`,
+	"DateDiff": `// DateDiff returns the number of whole parts (see DateAdd) from a to b,
+// negative when b is before a. Days and weeks count calendar days, so a
+// daylight saving change does not shorten them.
+func DateDiff(a, b time.Time, part string) int64 {
+	b = b.In(a.Location())
+	switch p := datePart("DateDiff", part); p {
+	case "year", "quarter", "month":
+		months := (b.Year()-a.Year())*12 + int(b.Month()-a.Month())
+		if months > 0 && addMonths(a, months).After(b) {
+			months--
+		} else if months < 0 && addMonths(a, months).Before(b) {
+			months++
+		}
+		return int64(months / map[string]int{"year": 12, "quarter": 3, "month": 1}[p])
+	case "week", "day":
+		wall := func(t time.Time) time.Time {
+			return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), time.UTC)
+		}
+		days := int64(wall(b).Sub(wall(a)) / (24 * time.Hour))
+		if p == "week" {
+			return days / 7
+		}
+		return days
+	case "hour":
+		return int64(b.Sub(a) / time.Hour)
+	case "minute":
+		return int64(b.Sub(a) / time.Minute)
+	}
+	return int64(b.Sub(a) / time.Second)
+}`,
+	"ToTimeZone": `// ToTimeZone returns t as the wall clock time in an IANA time zone such as
+// "Europe/Paris"; "UTC" and "Local" are also accepted
+func ToTimeZone(t time.Time, zone string) (time.Time, error) {
+	loc, err := time.LoadLocation(zone)
+	if err != nil {
+		return t, fmt.Errorf("ToTimeZone: unknown time zone %q", zone)
+	}
+	return t.In(loc), nil
+}`,
+	"ToUTC": `// ToUTC returns t in UTC
+func ToUTC(t time.Time) time.Time {
+	return t.UTC()
+}`,
+	"ToLocal": `// ToLocal returns t in the local time zone
+func ToLocal(t time.Time) time.Time {
+	return t.Local()
+}`,
+	"After": `// After returns a channel that receives TRUE once, ms milliseconds from now
+func After(ms int) chan bool {
+	ch := make(chan bool, 1)
+	time.AfterFunc(time.Duration(ms)*time.Millisecond, func() { ch <- true })
+	return ch
+}`,
+	"Every": `// Every returns a channel that receives the tick number 1, 2, 3, ... every
+// ms milliseconds. A tick is dropped when the last one has not been
+// received yet, so a slow receiver sees a gap rather than a backlog.
+func Every(ms int) chan int64 {
+	ch := make(chan int64, 1)
+	go func() {
+		ticker := time.NewTicker(time.Duration(ms) * time.Millisecond)
+		var n int64
+		for range ticker.C {
+			n++
+			select {
+			case ch <- n:
+			default:
+			}
+		}
+	}()
+	return ch
+}`,
+	"DateTimeNow": `// DateTimeNow returns the current local date and time
+func DateTimeNow() time.Time {
+	return time.Now()
+}`,
+	"Today": `// Today returns the current local date at midnight
+func Today() time.Time {
+	now := time.Now()
+	return time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.Local)
+}`,
+	"DateSerial": `// DateSerial returns midnight local time on the given date
+func DateSerial(year, month, day int) time.Time {
+	return time.Date(year, time.Month(month), day, 0, 0, 0, 0, time.Local)
+}`,
+	"TimeSerial": `// TimeSerial returns a time of day as a duration since midnight
+func TimeSerial(hour, minute, second int) time.Duration {
+	return time.Duration(hour)*time.Hour + time.Duration(minute)*time.Minute + time.Duration(second)*time.Second
+}`,
+	"Days": `// Days returns a duration of n days
+func Days(n float64) time.Duration {
+	return time.Duration(n * float64(24*time.Hour))
+}`,
+	"Hours": `// Hours returns a duration of n hours
+func Hours(n float64) time.Duration {
+	return time.Duration(n * float64(time.Hour))
+}`,
+	"Minutes": `// Minutes returns a duration of n minutes
+func Minutes(n float64) time.Duration {
+	return time.Duration(n * float64(time.Minute))
+}`,
+	"Seconds": `// Seconds returns a duration of n seconds
+func Seconds(n float64) time.Duration {
+	return time.Duration(n * float64(time.Second))
+}`,
+	"Milliseconds": `// Milliseconds returns a duration of n milliseconds
+func Milliseconds(n float64) time.Duration {
+	return time.Duration(n * float64(time.Millisecond))
+}`,
+	"TotalSeconds": `// TotalSeconds returns a duration in seconds
+func TotalSeconds(d time.Duration) float64 {
+	return d.Seconds()
+}`,
+	"trapError": `// Error trapping state, read through ERR, ERL and ErrorMessage()
+var (
+	_errNumber  int
+	_errLine    int
+	_errMessage string
+)
+
+// errorNumber is raised by ERROR n and carries a BASIC error number
+type errorNumber int
+
+func (e errorNumber) Error() string {
+	switch e {
+	case 3:
+		return "RETURN without GOSUB"
+	case 5:
+		return "Illegal function call"
+	case 9:
+		return "Subscript out of range"
+	case 11:
+		return "Division by zero"
+	case 13:
+		return "Type mismatch"
+	case 53:
+		return "File not found"
+	case 91:
+		return "Object variable not set"
+	}
+	return fmt.Sprintf("Error %d", int(e))
+}
+
+// trapError records an error recovered by ON ERROR GOTO. Go runtime errors
+// are given the numbers of their classic BASIC equivalents.
+func trapError(r interface{}, line int) {
+	_errLine = line
+	if e, ok := r.(errorNumber); ok {
+		_errNumber, _errMessage = int(e), e.Error()
+		return
+	}
+	if e, ok := r.(error); ok {
+		_errMessage = e.Error()
+	} else {
+		_errMessage = fmt.Sprint(r)
+	}
+	switch {
+	case strings.Contains(_errMessage, "out of range"):
+		_errNumber = 9
+	case strings.Contains(_errMessage, "divide by zero"):
+		_errNumber = 11
+	case strings.Contains(_errMessage, "interface conversion"):
+		_errNumber = 13
+	case strings.Contains(_errMessage, "nil pointer"), strings.Contains(_errMessage, "nil map"):
+		_errNumber = 91
+	default:
+		_errNumber = 5
+	}
+}`,
+	"ErrorMessage": `// ErrorMessage returns the message of the last error trapped by ON ERROR GOTO
+func ErrorMessage() string {
+	return _errMessage
+}`,
+	"Background": `// Background returns a context that is never cancelled
+func Background() context.Context {
+	return context.Background()
+}`,
+	"WithCancel": `// WithCancel returns a child of parent and a SUB that cancels it
+func WithCancel(parent context.Context) (context.Context, func()) {
+	return context.WithCancel(parent)
+}`,
+	"WithTimeout": `// WithTimeout returns a child of parent that is cancelled after ms
+// milliseconds, and a SUB that cancels it sooner
+func WithTimeout(parent context.Context, ms int) (context.Context, func()) {
+	return context.WithTimeout(parent, time.Duration(ms)*time.Millisecond)
+}`,
+	"Done": `// Done returns a channel that is closed when ctx is cancelled
+func Done(ctx context.Context) <-chan struct{} {
+	return ctx.Done()
+}`,
+	"Cancelled": `// Cancelled reports whether ctx has been cancelled or has timed out
+func Cancelled(ctx context.Context) bool {
+	return ctx.Err() != nil
+}`,
+	"SleepContext": `// SleepContext pauses for ms milliseconds, returning false early if ctx
+// is cancelled first
+func SleepContext(ctx context.Context, ms int) bool {
+	t := time.NewTimer(time.Duration(ms) * time.Millisecond)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}`,
+	"IfNull": `// IfNull returns fallback when value is nil or the zero value of its type
+func IfNull[T any](value T, fallback T) T {
+	if reflect.ValueOf(&value).Elem().IsZero() {
+		return fallback
+	}
+	return value
+}`,
+	"IfNullAs": `// IfNullAs returns an untyped value as fallback's type, or fallback when the
+// value is nil, zero, or of an unrelated type (numbers are converted)
+func IfNullAs[T any](value interface{}, fallback T) T {
+	if value == nil {
+		return fallback
+	}
+	if v, ok := value.(T); ok {
+		return IfNull(v, fallback)
+	}
+	isNumeric := func(k reflect.Kind) bool { return k >= reflect.Int && k <= reflect.Float64 }
+	rv := reflect.ValueOf(value)
+	target := reflect.TypeOf(&fallback).Elem()
+	if isNumeric(rv.Kind()) && isNumeric(target.Kind()) {
+		return IfNull(rv.Convert(target).Interface().(T), fallback)
+	}
+	return fallback
 }`,
 }
 
@@ -619,15 +3968,112 @@ var runtimeFuncImports = map[string][]string{
 	"UCase":          {"strings"},
 	"LCase":          {"strings"},
 	"Trim":           {"strings"},
-	"Rnd":            {"math/rand"},
+	"Rnd":            {"math/rand", "sync", "time"},
 	"RndInt":         {"math/rand"},
+	"RndRange":       {"math/rand"},
+	"Randomize":      {"math/rand", "time"},
+	"RndBytes":       {"crand crypto/rand"},
+	"embedDir":       {"embed", "io/fs", "strings"},
+	"RndSecureInt":   {"crand crypto/rand", "math/big"},
 	"Instr":          {"strings"},
+	"LenR":           {"unicode/utf8"},
+	"InstrR":         {"strings", "unicode/utf8"},
+	"AscR":           {"unicode/utf8"},
+	"RuneLen":        {"unicode/utf8"},
+	"IsLetter":       {"unicode"},
+	"NormalizeNFC":   {"golang.org/x/text/unicode/norm"},
 	"FileExists":     {"os"},
 	"ReadFile":       {"os"},
 	"WriteFile":      {"os"},
+	"ListDir":        {"os"},
+	"Glob":           {"path/filepath"},
+	"IsDir":          {"os"},
+	"FileSize":       {"os"},
+	"FileModTime":    {"os", "time"},
+	"GetEnv":         {"os"},
+	"SetEnv":         {"os"},
+	"EnvExists":      {"os"},
+	"Environ":        {"os", "strings"},
+	"Command":        {"os"},
+	"ArgCount":       {"os"},
+	"Args":           {"os"},
+	"Exec":           {"bytes", "os", "os/exec"},
+	"FormatNumber":   {"math", "strconv", "strings"},
+	"Format":         {"math", "strconv", "strings"},
+	"PadLeft":        {"strings", "unicode/utf8"},
+	"Base64Encode":   {"encoding/base64"},
+	"Base64Decode":   {"encoding/base64", "strings"},
+	"HexEncode":      {"encoding/hex"},
+	"HexDecode":      {"encoding/hex", "strings"},
+	"AESEncrypt":     {"crypto/aes", "crypto/cipher", "crand crypto/rand", "crypto/sha256"},
+	"PadRight":       {"strings", "unicode/utf8"},
+	"Shell":          {"os/exec", "runtime"},
+	"Beep":           {"fmt"},
+	"Screen":         {"fmt", "image", "image/draw", "os", "path/filepath", "sync", "github.com/hajimehoshi/ebiten/v2"},
+	"RGB":            {"image/color"},
+	"DrawImage":      {"image", "image/draw"},
+	"LoadImage":      {"fmt", "image", "image/draw", "os"},
+	"SaveImage":      {"fmt", "image", "image/gif", "image/jpeg", "image/png", "io", "os", "path/filepath", "strings"},
+	"NewImage":       {"image", "image/draw"},
+	"ImageWidth":     {"image"},
+	"ImageHeight":    {"image"},
+	"GetPixel":       {"image"},
+	"SetPixel":       {"image"},
+	"Resize":         {"image", "xdraw golang.org/x/image/draw"},
+	"Rect":           {"image", "image/draw"},
+	"Text":           {"image", "golang.org/x/image/font", "golang.org/x/image/font/basicfont", "golang.org/x/image/math/fixed"},
+	"Tone":           {"bytes", "encoding/binary", "math", "os", "time"},
+	"PlayWav":        {"fmt", "os", "os/exec", "runtime", "strings"},
+	"ExecAsync":      {"bufio", "os", "os/exec"},
+	"TcpListen":      {"net"},
+	"TcpAccept":      {"net"},
+	"TcpConnect":     {"net"},
+	"TcpSend":        {"io", "net"},
+	"TcpReceive":     {"bufio", "net", "strings", "sync"},
+	"TcpClose":       {"io", "net"},
+	"UdpBind":        {"net"},
+	"UdpSendTo":      {"errors", "net"},
+	"UdpReceiveFrom": {"errors", "net"},
+	"UdpClose":       {"net"},
+	"WsConnect":      {"net", "strings", "golang.org/x/net/websocket"},
+	"wsOf":           {"net", "sync", "golang.org/x/net/websocket"},
+	"WsListen":       {"net", "net/http", "sync", "golang.org/x/net/websocket"},
+	"WsAccept":       {"net"},
+	"WsSend":         {"errors", "net", "golang.org/x/net/websocket"},
+	"WsReceive":      {"errors", "net", "golang.org/x/net/websocket"},
+	"WsReceiveAsync": {"net"},
+	"WsClose":        {"io"},
+	"DBOpen":         {"database/sql", "_ modernc.org/sqlite"},
+	"KVOpen":         {"encoding/json", "fmt", "os", "sort", "sync"},
+	"SendMail":       {"crypto/tls", "fmt", "mime", "net", "net/mail", "net/smtp", "strconv", "strings", "time"},
+	"FileOpen":       {"bufio", "fmt", "os", "sync"},
+	"FileEOF":        {"io"},
+	"FileGet":        {"bytes", "encoding/binary", "fmt", "io", "reflect", "strconv", "strings", "unsafe"},
+	"FileLineInput":  {"fmt", "strings"},
+	"FilePrint":      {"fmt"},
+	"CSVParse":       {"encoding/csv", "strings"},
+	"CSVRead":        {"os"},
+	"CSVWrite":       {"encoding/csv", "os"},
+	"CSVReadRecords": {"os"},
+	"INILoad":        {"os", "strings"},
+	"INIGet":         {"fmt"},
+	"INISave":        {"fmt", "os", "sort", "strings"},
 	"JSONParse":      {"encoding/json"},
+	"ReadLines":      {"bufio", "os", "strings"},
+	"TempFile":       {"os", "strings"},
+	"TempDir":        {"os"},
+	"RemoveAll":      {"os"},
+	"WatchPath":      {"io/fs", "path/filepath", "sort", "time"},
+	"RenderTemplate": {"strings", "text/template"},
 	"JSONStringify":  {"encoding/json"},
 	"JSONPretty":     {"encoding/json"},
+	"YAMLParse":      {"gopkg.in/yaml.v3"},
+	"YAMLStringify":  {"bytes", "gopkg.in/yaml.v3"},
+	"TOMLParse":      {"github.com/BurntSushi/toml"},
+	"TOMLStringify":  {"bytes", "github.com/BurntSushi/toml"},
+	"XMLParse":       {"encoding/xml", "io", "strings"},
+	"XMLGetAll":      {"fmt", "strconv", "strings"},
+	"XMLStringify":   {"encoding/xml", "fmt", "sort", "strings"},
 	"JSONGet":        {"strings"},
 	"JSONSet":        {"strings"},
 	"StructToJSON":   {"reflect"},
@@ -635,6 +4081,53 @@ var runtimeFuncImports = map[string][]string{
 	"NewErrorAtFunc": {"fmt"},
 	"ErrorfFunc":     {"fmt"},
 	"WrapError":      {"fmt"},
+	"DateTimeNow":    {"time"},
+	"Today":          {"time"},
+	"Timer":          {"time"},
+	"After":          {"time"},
+	"Every":          {"time"},
+	"Now":            {"time"},
+	"Date":           {"time"},
+	"Year":           {"time"},
+	"Month":          {"time"},
+	"Day":            {"time"},
+	"Hour":           {"time"},
+	"Minute":         {"time"},
+	"Second":         {"time"},
+	"DateFormat":     {"strings", "time"},
+	"DateParse":      {"fmt", "strings", "time"},
+	"DateAdd":        {"fmt", "strings", "time"},
+	"DateDiff":       {"time"},
+	"ToTimeZone":     {"fmt", "time", "_ time/tzdata"},
+	"ToUTC":          {"time"},
+	"ToLocal":        {"time"},
+	"DateSerial":     {"time"},
+	"TimeSerial":     {"time"},
+	"Days":           {"time"},
+	"Hours":          {"time"},
+	"Minutes":        {"time"},
+	"Seconds":        {"time"},
+	"Milliseconds":   {"time"},
+	"TotalSeconds":   {"time"},
+	"trapError":      {"fmt", "strings"},
+	"FixedString":    {"strings"},
+	"InputLine":      {"bufio", "fmt", "os", "strings"},
+	"InputValue":     {"strconv", "strings"},
+	"Background":     {"context"},
+	"WithCancel":     {"context"},
+	"WithTimeout":    {"context", "time"},
+	"Done":           {"context"},
+	"Cancelled":      {"context"},
+	"SleepContext":   {"context", "time"},
+	"IfNull":         {"reflect"},
+	"IfNullAs":       {"reflect"},
+	"Stack":          {"fmt"},
+	"Queue":          {"fmt"},
+	"Set":            {"fmt"},
+	"AssertEqual":    {"fmt", "reflect"},
+
+	"FormatCurrency":    {"math", "strconv", "strings", "unicode", "unicode/utf8"},
+	"ParseNumberLocale": {"fmt", "strconv", "strings", "unicode"},
 }
 
 // scanForRuntimeFunctions scans the AST for calls to runtime functions
@@ -648,10 +4141,31 @@ func (g *Generator) scanStatementForRuntimeFuncs(stmt parser.Statement) {
 	switch s := stmt.(type) {
 	case *parser.SubStatement:
 		g.scanBlockForRuntimeFuncs(s.Body)
+	case *parser.TestStatement:
+		if g.testMode {
+			g.runtimeFuncs["AssertEqual"] = true // AssertEqual defines testFail
+			g.scanBlockForRuntimeFuncs(s.Body)
+		}
+	case *parser.BenchmarkStatement:
+		if g.testMode {
+			g.runtimeFuncs["AssertEqual"] = true
+			g.scanBlockForRuntimeFuncs(s.Body)
+		}
 	case *parser.FunctionStatement:
 		g.scanBlockForRuntimeFuncs(s.Body)
 	case *parser.MethodStatement:
 		g.scanBlockForRuntimeFuncs(s.Body)
+	case *parser.ModuleStatement:
+		for _, member := range s.Body {
+			g.scanStatementForRuntimeFuncs(member)
+		}
+	case *parser.TypeStatement:
+		if s.Constructor != nil {
+			g.scanBlockForRuntimeFuncs(s.Constructor.Body)
+		}
+		for _, prop := range s.Properties {
+			g.scanBlockForRuntimeFuncs(prop.Body)
+		}
 	}
 }
 
@@ -671,6 +4185,12 @@ func (g *Generator) scanBlockForRuntimeFuncs(block *parser.BlockStatement) {
 			g.scanBlockForRuntimeFuncs(s.Alternative)
 		case *parser.ForStatement:
 			g.scanBlockForRuntimeFuncs(s.Body)
+		case *parser.ForEachStatement:
+			g.scanBlockForRuntimeFuncs(s.Body)
+		case *parser.WithStatement:
+			g.scanBlockForRuntimeFuncs(s.Body)
+		case *parser.LockStatement:
+			g.scanBlockForRuntimeFuncs(s.Body)
 		case *parser.WhileStatement:
 			g.scanBlockForRuntimeFuncs(s.Body)
 		case *parser.DoLoopStatement:
@@ -680,6 +4200,11 @@ func (g *Generator) scanBlockForRuntimeFuncs(block *parser.BlockStatement) {
 				g.scanBlockForRuntimeFuncs(c.Body)
 			}
 			g.scanBlockForRuntimeFuncs(s.Default)
+		case *parser.SelectChannelStatement:
+			for _, c := range s.Cases {
+				g.scanBlockForRuntimeFuncs(c.Body)
+			}
+			g.scanBlockForRuntimeFuncs(s.Default)
 		}
 	}
 }
@@ -689,14 +4214,30 @@ func (g *Generator) scanStmtExprForRuntimeFuncs(stmt parser.Statement) {
 	case *parser.AssignmentStatement:
 		g.scanExprForRuntimeFuncs(s.Value)
 		g.scanExprForRuntimeFuncs(s.Left)
+	case *parser.MultiAssignmentStatement:
+		g.scanExprForRuntimeFuncs(s.Value)
 	case *parser.DimStatement:
 		if s.Value != nil {
 			g.scanExprForRuntimeFuncs(s.Value)
 		}
 	case *parser.PrintStatement:
+		g.scanExprForRuntimeFuncs(s.FileNumber)
 		for _, v := range s.Values {
 			g.scanExprForRuntimeFuncs(v)
 		}
+	case *parser.OpenStatement:
+		g.scanExprForRuntimeFuncs(s.Path)
+		g.scanExprForRuntimeFuncs(s.FileNumber)
+	case *parser.CloseStatement:
+		for _, n := range s.FileNumbers {
+			g.scanExprForRuntimeFuncs(n)
+		}
+	case *parser.LineInputStatement:
+		g.scanExprForRuntimeFuncs(s.FileNumber)
+	case *parser.GetPutStatement:
+		g.scanExprForRuntimeFuncs(s.FileNumber)
+		g.scanExprForRuntimeFuncs(s.Position)
+		g.scanExprForRuntimeFuncs(s.Variable)
 	case *parser.ExpressionStatement:
 		if s.Expression != nil {
 			g.scanExprForRuntimeFuncs(s.Expression)
@@ -705,6 +4246,16 @@ func (g *Generator) scanStmtExprForRuntimeFuncs(stmt parser.Statement) {
 		for _, v := range s.Values {
 			g.scanExprForRuntimeFuncs(v)
 		}
+	case *parser.SendStatement:
+		g.scanExprForRuntimeFuncs(s.Value)
+		g.scanExprForRuntimeFuncs(s.Channel)
+	case *parser.ErrorStatement:
+		g.scanExprForRuntimeFuncs(s.Code)
+	case *parser.AssertStatement:
+		g.scanExprForRuntimeFuncs(s.Condition)
+		g.scanExprForRuntimeFuncs(s.Message)
+	case *parser.ReceiveStatement:
+		g.scanExprForRuntimeFuncs(s.Channel)
 	case *parser.IfStatement:
 		g.scanExprForRuntimeFuncs(s.Condition)
 	case *parser.WhileStatement:
@@ -715,6 +4266,29 @@ func (g *Generator) scanStmtExprForRuntimeFuncs(stmt parser.Statement) {
 		if s.Step != nil {
 			g.scanExprForRuntimeFuncs(s.Step)
 		}
+	case *parser.ForEachStatement:
+		g.scanExprForRuntimeFuncs(s.Collection)
+	case *parser.WithStatement:
+		g.scanExprForRuntimeFuncs(s.Target)
+	case *parser.SelectStatement:
+		g.scanExprForRuntimeFuncs(s.TestExpr)
+		for _, c := range s.Cases {
+			for _, v := range c.Values {
+				g.scanExprForRuntimeFuncs(v)
+			}
+		}
+	case *parser.SelectChannelStatement:
+		for _, c := range s.Cases {
+			switch {
+			case c.Receive != nil:
+				g.scanExprForRuntimeFuncs(c.Receive.Channel)
+			case c.Send != nil:
+				g.scanExprForRuntimeFuncs(c.Send.Value)
+				g.scanExprForRuntimeFuncs(c.Send.Channel)
+			default:
+				g.scanExprForRuntimeFuncs(c.Timeout)
+			}
+		}
 	}
 }
 
@@ -731,14 +4305,15 @@ func (g *Generator) scanExprForRuntimeFuncs(expr parser.Expression) {
 	}
 	switch e := expr.(type) {
 	case *parser.CallExpression:
-		// Check if this is a runtime function call
-		if ident, ok := e.Function.(*parser.Identifier); ok {
+		// Check if this is a runtime function call; a SUB or FUNCTION of the
+		// program with the same name hides it
+		if ident, ok := e.Function.(*parser.Identifier); ok && g.isBuiltinCall(ident) {
 			if _, isRuntime := runtimeFuncDefs[ident.Value]; isRuntime {
 				g.runtimeFuncs[ident.Value] = true
 				// Add required imports for this runtime function
 				if imports, ok := runtimeFuncImports[ident.Value]; ok {
 					for _, imp := range imports {
-						g.imports[imp] = ""
+						g.addRuntimeImport(imp)
 					}
 				}
 			}
@@ -758,17 +4333,93 @@ func (g *Generator) scanExprForRuntimeFuncs(expr parser.Expression) {
 			case "WRAPERROR":
 				g.runtimeFuncs["WrapError"] = true
 				g.runtimeFuncs["NewErrorAtFunc"] = true // WrapError depends on DBasicError type
+			case "ERRORMESSAGE":
+				g.runtimeFuncs["trapError"] = true // ErrorMessage reads the trapped error
+			case "SHELL":
+				g.runtimeFuncs["Exec"] = true // Shell depends on runCommand
+			case "FORMATNUMBER":
+				g.runtimeFuncs["Dbl"] = true
+			case "FORMATCURRENCY":
+				g.runtimeFuncs["Dbl"] = true
+			case "PARSENUMBERLOCALE":
+				g.runtimeFuncs["FormatCurrency"] = true // FormatCurrency defines lookupLocale
+				g.runtimeFuncs["Dbl"] = true
+			case "FORMAT":
+				g.runtimeFuncs["Dbl"] = true
+				g.runtimeFuncs["FormatNumber"] = true // Format depends on groupDigits
+			case "CSVREAD", "CSVPARSERECORDS":
+				g.runtimeFuncs["CSVParse"] = true
+			case "TCPRECEIVEASYNC", "TCPCLOSE":
+				g.runtimeFuncs["TcpReceive"] = true // both use the connection's reader
+			case "WSLISTEN", "WSSEND", "WSRECEIVE":
+				g.runtimeFuncs["wsOf"] = true
+			case "WSRECEIVEASYNC":
+				g.runtimeFuncs["WsReceive"] = true
+				g.runtimeFuncs["wsOf"] = true
+			case "DBCLOSE", "DBEXEC", "DBINSERT", "DBQUERY", "DBQUERYROW", "DBBEGIN", "DBCOMMIT", "DBROLLBACK":
+				g.runtimeFuncs["DBOpen"] = true // the database functions are defined together
+			case "KVGET", "KVHAS", "KVSET", "KVDELETE", "KVKEYS":
+				g.runtimeFuncs["KVOpen"] = true // the store functions are defined together
+			case "MONTH", "DAY", "HOUR", "MINUTE", "SECOND":
+				g.runtimeFuncs["Year"] = true // Year defines timeOrNow
+			case "DATEPARSE":
+				g.runtimeFuncs["DateFormat"] = true // DateFormat defines dateLayout
+			case "DATEDIFF":
+				g.runtimeFuncs["DateAdd"] = true // DateAdd defines datePart and addMonths
+			case "TONE":
+				g.runtimeFuncs["PlayWav"] = true
+			case "SCREEN", "PSET", "LINE", "CIRCLE", "RECT", "TEXT", "FLIP", "DRAWIMAGE":
+				g.runtimeFuncs["Screen"] = true // Screen defines the window they draw on
+				g.runtimeFuncs["RGB"] = true    // RGB defines gfxColor
+			case "SETPIXEL":
+				g.runtimeFuncs["RGB"] = true
+			case "TEMPDIR":
+				g.runtimeFuncs["TempFile"] = true // TempFile defines tempPattern
+			case "ISDIGIT", "ISSPACE":
+				g.runtimeFuncs["IsLetter"] = true // IsLetter defines isRunes
+			case "ASSERTTRUE":
+				g.runtimeFuncs["AssertEqual"] = true // AssertEqual defines AssertTrue
+			case "RENDERTEMPLATEFILE":
+				g.runtimeFuncs["RenderTemplate"] = true // RenderTemplate defines execTemplate
+			case "LOADIMAGE":
+				g.runtimeFuncs["SaveImage"] = true // Its imports register the decoders
+			case "RNDINT", "RNDRANGE":
+				g.runtimeFuncs["Rnd"] = true // they share Rnd's generator
+			case "RANDOMIZE":
+				g.runtimeFuncs["Rnd"] = true
+				g.runtimeFuncs["Lng"] = true
+			case "AESDECRYPT":
+				g.runtimeFuncs["AESEncrypt"] = true // both are defined together
+			case "XMLGET":
+				g.runtimeFuncs["XMLGetAll"] = true
+			case "CSVREADRECORDS":
+				g.runtimeFuncs["CSVParse"] = true
+				g.runtimeFuncs["CSVParseRecords"] = true
+			case "IFNULL":
+				g.markCoalesce()
 			}
 		}
 		// Scan arguments
 		for _, arg := range e.Arguments {
 			g.scanExprForRuntimeFuncs(arg)
 		}
+	case *parser.InterpolatedString:
+		g.imports["fmt"] = ""
+		for _, part := range e.Parts {
+			g.scanExprForRuntimeFuncs(part)
+		}
 	case *parser.InfixExpression:
+		if e.Operator == "??" {
+			g.markCoalesce()
+		}
 		g.scanExprForRuntimeFuncs(e.Left)
 		g.scanExprForRuntimeFuncs(e.Right)
 	case *parser.PrefixExpression:
 		g.scanExprForRuntimeFuncs(e.Right)
+	case *parser.NewExpression:
+		for _, arg := range e.Arguments {
+			g.scanExprForRuntimeFuncs(arg)
+		}
 	case *parser.IndexExpression:
 		g.scanExprForRuntimeFuncs(e.Left)
 		if e.Index != nil {
@@ -779,6 +4430,17 @@ func (g *Generator) scanExprForRuntimeFuncs(expr parser.Expression) {
 		}
 	case *parser.MemberExpression:
 		g.scanExprForRuntimeFuncs(e.Object)
+	case *parser.FieldListExpression:
+		g.scanExprForRuntimeFuncs(e.Object)
+	case *parser.RangeExpression:
+		g.scanExprForRuntimeFuncs(e.Start)
+		g.scanExprForRuntimeFuncs(e.End)
+		g.scanExprForRuntimeFuncs(e.Step)
+	case *parser.CaseRange:
+		g.scanExprForRuntimeFuncs(e.Low)
+		g.scanExprForRuntimeFuncs(e.High)
+	case *parser.CaseIs:
+		g.scanExprForRuntimeFuncs(e.Value)
 	case *parser.DereferenceExpression:
 		g.scanExprForRuntimeFuncs(e.Value)
 	case *parser.AddressOfExpression:
@@ -786,13 +4448,27 @@ func (g *Generator) scanExprForRuntimeFuncs(expr parser.Expression) {
 	}
 }
 
+// markCoalesce embeds the helpers behind ?? and IFNULL. Which one is used
+// depends on operand types that are only known during generation.
+func (g *Generator) markCoalesce() {
+	g.runtimeFuncs["IfNull"] = true
+	g.runtimeFuncs["IfNullAs"] = true
+	g.imports["reflect"] = ""
+}
+
 func (g *Generator) generateRuntimeFunctions() {
 	if len(g.runtimeFuncs) == 0 {
 		return
 	}
 
 	g.writeLine("// Runtime helper functions")
+	// In order of name, so that the same program always gives the same code
+	names := make([]string, 0, len(g.runtimeFuncs))
 	for funcName := range g.runtimeFuncs {
+		names = append(names, funcName)
+	}
+	sort.Strings(names)
+	for _, funcName := range names {
 		if def, ok := runtimeFuncDefs[funcName]; ok {
 			g.writeLine("")
 			// Write each line of the function definition
@@ -805,6 +4481,42 @@ func (g *Generator) generateRuntimeFunctions() {
 	g.writeLine("")
 }
 
+// generatePlugins writes the source of the plugins the program calls into
+func (g *Generator) generatePlugins() {
+	for _, p := range g.plugins {
+		if g.usedPlugins[p] {
+			g.output.WriteString(p.Source)
+			g.writeLine("")
+		}
+	}
+}
+
+// usePlugin records a reference to sym, which embeds the plugin that
+// implements it if it is a plugin's builtin
+func (g *Generator) usePlugin(sym *analyzer.Symbol) {
+	for _, p := range g.plugins {
+		for _, b := range p.Builtins {
+			if sym.Node == b.Decl {
+				if g.usedPlugins == nil {
+					g.usedPlugins = make(map[*plugin.Plugin]bool)
+				}
+				g.usedPlugins[p] = true
+				return
+			}
+		}
+	}
+}
+
+// addRuntimeImport adds an import needed by a runtime function; "_ path"
+// is a blank import, such as a database driver
+func (g *Generator) addRuntimeImport(imp string) {
+	if alias, path, ok := strings.Cut(imp, " "); ok {
+		g.imports[path] = alias
+	} else {
+		g.imports[imp] = ""
+	}
+}
+
 func (g *Generator) generateImports() {
 	if len(g.imports) == 0 {
 		return
@@ -812,7 +4524,13 @@ func (g *Generator) generateImports() {
 
 	g.writeLine("import (")
 	g.indent++
-	for path, alias := range g.imports {
+	paths := make([]string, 0, len(g.imports))
+	for path := range g.imports {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	for _, path := range paths {
+		alias := g.imports[path]
 		if alias != "" {
 			g.writeLine(fmt.Sprintf(`%s "%s"`, alias, path))
 		} else {
@@ -826,6 +4544,12 @@ func (g *Generator) generateImports() {
 
 func (g *Generator) generateTypeDefinitions() {
 	hasTypes := false
+	for _, stmt := range g.program.Statements {
+		if is, ok := stmt.(*parser.InterfaceStatement); ok {
+			hasTypes = true
+			g.generateInterfaceStatement(is)
+		}
+	}
 	for _, stmt := range g.program.Statements {
 		if ts, ok := stmt.(*parser.TypeStatement); ok {
 			if !hasTypes {
@@ -839,6 +4563,18 @@ func (g *Generator) generateTypeDefinitions() {
 	}
 }
 
+func (g *Generator) generateInterfaceStatement(stmt *parser.InterfaceStatement) {
+	g.writeLine(fmt.Sprintf("type %s interface {", g.toGoIdent(stmt.Name.Value)))
+	g.indent++
+	for _, m := range stmt.Methods {
+		sig := fmt.Sprintf("%s(%s) %s", g.toGoIdent(m.Name.Value), g.generateParams(m.Params), g.generateReturnTypes(m.ReturnTypes))
+		g.writeLine(strings.TrimSpace(sig))
+	}
+	g.indent--
+	g.writeLine("}")
+	g.writeLine("")
+}
+
 func (g *Generator) generateTypeStatement(stmt *parser.TypeStatement) {
 	typeName := g.toGoIdent(stmt.Name.Value)
 	g.writeLine(fmt.Sprintf("type %s struct {", typeName))
@@ -853,6 +4589,11 @@ func (g *Generator) generateTypeStatement(stmt *parser.TypeStatement) {
 	for _, field := range stmt.Fields {
 		fieldName := g.toGoIdent(field.Name.Value)
 		fieldType := g.typeSpecToGo(field.Type)
+		if width, ok := g.symbols.FixedStringWidth(field.Type); ok {
+			// GET and PUT read the length from the tag
+			g.writeLine(fmt.Sprintf("%s %s `width:\"%d\"`", fieldName, fieldType, width))
+			continue
+		}
 		g.writeLine(fmt.Sprintf("%s %s", fieldName, fieldType))
 	}
 	g.indent--
@@ -863,7 +4604,8 @@ func (g *Generator) generateTypeStatement(stmt *parser.TypeStatement) {
 func (g *Generator) generateGlobalVariables() {
 	hasGlobals := false
 
-	for _, stmt := range g.program.Statements {
+	var generate func(stmt parser.Statement)
+	generate = func(stmt parser.Statement) {
 		switch s := stmt.(type) {
 		case *parser.DimStatement:
 			if !hasGlobals {
@@ -879,8 +4621,24 @@ func (g *Generator) generateGlobalVariables() {
 				hasGlobals = false
 			}
 			g.generateConstStatement(s)
+		case *parser.ConstGroupStatement:
+			if hasGlobals {
+				g.indent--
+				g.writeLine(")")
+				hasGlobals = false
+			}
+			g.generateConstGroup(s)
+		case *parser.ModuleStatement:
+			g.inModule(s, func() {
+				for _, member := range s.Body {
+					generate(member)
+				}
+			})
 		}
 	}
+	for _, stmt := range g.program.Statements {
+		generate(stmt)
+	}
 
 	if hasGlobals {
 		g.indent--
@@ -889,48 +4647,261 @@ func (g *Generator) generateGlobalVariables() {
 	}
 }
 
+func (g *Generator) generateStaticVariables() {
+	if len(g.staticVars) == 0 {
+		return
+	}
+	g.writeLine("var (")
+	g.indent++
+	for _, decl := range g.staticVars {
+		g.writeLine(decl)
+	}
+	g.indent--
+	g.writeLine(")")
+	g.writeLine("")
+}
+
 func (g *Generator) generateFunctions() {
 	for _, stmt := range g.program.Statements {
 		switch s := stmt.(type) {
 		case *parser.SubStatement:
 			g.generateSubStatement(s)
+		case *parser.TestStatement:
+			if g.testMode {
+				g.generateTestStatement(s)
+			}
+		case *parser.BenchmarkStatement:
+			if g.testMode {
+				g.generateBenchmarkStatement(s)
+			}
 		case *parser.FunctionStatement:
 			g.generateFunctionStatement(s)
 		case *parser.MethodStatement:
 			g.generateMethodStatement(s)
+		case *parser.TypeStatement:
+			if s.Constructor != nil {
+				g.generateConstructor(s)
+			}
+			for _, prop := range s.Properties {
+				g.generateProperty(s, prop)
+			}
+		case *parser.ModuleStatement:
+			g.inModule(s, func() {
+				for _, member := range s.Body {
+					switch m := member.(type) {
+					case *parser.SubStatement:
+						g.generateSubStatement(m)
+					case *parser.FunctionStatement:
+						g.generateFunctionStatement(m)
+					}
+				}
+			})
+		}
+	}
+}
+
+// generateExports gives a library's routines, types and constants Go names
+// that other packages can use, as wrappers and aliases of the generated
+// ones. Constructors and properties are exported as NewType, Prop and
+// SetProp methods.
+func (g *Generator) generateExports() {
+	for _, stmt := range g.program.Statements {
+		g.exportStatement(stmt)
+	}
+}
+
+func (g *Generator) exportStatement(stmt parser.Statement) {
+	switch s := stmt.(type) {
+	case *parser.SubStatement:
+		g.exportRoutine("", g.varToGo(s.Name.Value), s.Params, nil)
+	case *parser.FunctionStatement:
+		g.exportRoutine("", g.varToGo(s.Name.Value), s.Params, s.ReturnTypes)
+	case *parser.MethodStatement:
+		receiver := fmt.Sprintf("%s %s", g.toGoIdent(s.ReceiverName.Value), g.typeSpecToGo(s.ReceiverType))
+		g.exportRoutine(receiver, g.toGoIdent(s.Name.Value), s.Params, s.ReturnTypes)
+	case *parser.TypeStatement:
+		typeName := g.toGoIdent(s.Name.Value)
+		g.exportName("type", typeName)
+		if s.Constructor != nil {
+			g.writeLine("")
+			g.writeLine(fmt.Sprintf("func New%s(%s) %s {", exportedName(typeName), g.generateParams(s.Constructor.Params), typeName))
+			g.indent++
+			g.writeLine(fmt.Sprintf("return new_%s(%s)", typeName, g.callArgs(s.Constructor.Params)))
+			g.indent--
+			g.writeLine("}")
+		}
+		structType := g.types.Lookup(s.Name.Value)
+		if structType == nil {
+			return
+		}
+		for _, prop := range s.Properties {
+			propName := prop.Name.Value
+			if p := structType.Property(propName); p != nil {
+				propName = p.Name
+			}
+			g.writeLine("")
+			if prop.IsSet {
+				g.writeLine(fmt.Sprintf("func (_self *%s) Set%s(%s) {", typeName, exportedName(propName), g.generateParams([]*parser.Parameter{prop.Param})))
+				g.indent++
+				g.writeLine(fmt.Sprintf("_self.set_%s(%s)", propName, g.toGoIdent(prop.Param.Name.Value)))
+			} else {
+				g.writeLine(fmt.Sprintf("func (_self %s) %s() %s {", typeName, exportedName(propName), g.typeSpecToGo(prop.ReturnType)))
+				g.indent++
+				g.writeLine(fmt.Sprintf("return _self.get_%s()", propName))
+			}
+			g.indent--
+			g.writeLine("}")
+		}
+	case *parser.InterfaceStatement:
+		g.exportName("type", g.toGoIdent(s.Name.Value))
+	case *parser.ConstStatement:
+		g.exportName("const", g.varToGo(s.Name.Value))
+	case *parser.ConstGroupStatement:
+		for _, name := range s.Names {
+			g.exportName("const", g.varToGo(name.Value))
+		}
+	case *parser.ModuleStatement:
+		g.inModule(s, func() {
+			for _, member := range s.Body {
+				g.exportStatement(member)
+			}
+		})
+	}
+}
+
+// exportRoutine writes an exported wrapper for a function, or a method when
+// receiver is set, whose Go name is not exported
+func (g *Generator) exportRoutine(receiver, goName string, params []*parser.Parameter, results []*parser.TypeSpec) {
+	if exportedName(goName) == goName {
+		return
+	}
+	call := fmt.Sprintf("%s(%s)", goName, g.callArgs(params))
+	signature := strings.TrimSpace(fmt.Sprintf("%s(%s) %s", exportedName(goName), g.generateParams(params), g.generateReturnTypes(results)))
+	g.writeLine("")
+	if receiver != "" {
+		call = strings.Fields(receiver)[0] + "." + call
+		g.writeLine(fmt.Sprintf("func (%s) %s {", receiver, signature))
+	} else {
+		g.writeLine(fmt.Sprintf("func %s {", signature))
+	}
+	if len(results) > 0 {
+		call = "return " + call
+	}
+	g.indent++
+	g.writeLine(call)
+	g.indent--
+	g.writeLine("}")
+}
+
+// exportName writes an exported alias for a type or constant whose Go name
+// is not exported
+func (g *Generator) exportName(kind, goName string) {
+	if exportedName(goName) != goName {
+		g.writeLine("")
+		g.writeLine(fmt.Sprintf("%s %s = %s", kind, exportedName(goName), goName))
+	}
+}
+
+// exportedName returns a Go name with its first letter in upper case
+func exportedName(name string) string {
+	r, size := utf8.DecodeRuneInString(name)
+	return string(unicode.ToUpper(r)) + name[size:]
+}
+
+// callArgs passes a routine's parameters on to another call
+func (g *Generator) callArgs(params []*parser.Parameter) string {
+	var args []string
+	for _, p := range params {
+		arg := g.toGoIdent(p.Name.Value)
+		if p.ParamArray && p.Type.IsArray {
+			arg += "..."
 		}
+		args = append(args, arg)
+	}
+	return strings.Join(args, ", ")
+}
+
+// inModule runs fn with a MODULE's scope as the current scope, so its
+// members resolve to their module-prefixed Go names
+func (g *Generator) inModule(stmt *parser.ModuleStatement, fn func()) {
+	mod := g.symbols.GlobalScope.ResolveLocal(stmt.Name.Value)
+	if mod == nil || mod.Members == nil {
+		return
+	}
+	oldScope := g.currentScope
+	g.currentScope = mod.Members
+	fn()
+	g.currentScope = oldScope
+}
+
+// moduleMember returns the symbol referenced by a qualified Module.Member
+// expression, or nil if expr is not a module access
+func (g *Generator) moduleMember(expr *parser.MemberExpression) *analyzer.Symbol {
+	ident, ok := expr.Object.(*parser.Identifier)
+	if !ok {
+		return nil
+	}
+	mod := g.currentScope.Resolve(ident.Value)
+	if mod == nil || mod.Kind != analyzer.SymModule {
+		return nil
 	}
+	return mod.Members.ResolveLocal(expr.Member.Value)
 }
 
 func (g *Generator) generateDimStatement(stmt *parser.DimStatement) {
-	varName := g.toGoIdent(stmt.Name.Value)
+	varName := g.varToGo(stmt.Name.Value)
 	varType := g.typeSpecToGo(stmt.Type)
 
-	if stmt.Value != nil {
-		g.writeLine(fmt.Sprintf("%s %s = %s", varName, varType, g.exprToGo(stmt.Value)))
+	if g.hasDimValue(stmt) {
+		g.writeLine(fmt.Sprintf("%s %s = %s", varName, varType, g.dimValueToGo(stmt)))
 	} else if stmt.ArraySize != nil {
-		g.writeLine(fmt.Sprintf("%s = make([]%s, %s)", varName, varType, g.exprToGo(stmt.ArraySize)))
+		g.writeLine(fmt.Sprintf("%s = make([]%s, %s)", varName, varType, g.arraySizeToGo(stmt)))
+	} else if value := readyValue(stmt.Type, varType); value != "" {
+		g.writeLine(fmt.Sprintf("%s %s = %s", varName, varType, value))
 	} else {
 		g.writeLine(fmt.Sprintf("%s %s", varName, varType))
 	}
 }
 
 func (g *Generator) generateConstStatement(stmt *parser.ConstStatement) {
-	constName := g.toGoIdent(stmt.Name.Value)
+	constName := g.varToGo(stmt.Name.Value)
 	g.writeLine(fmt.Sprintf("const %s = %s", constName, g.exprToGo(stmt.Value)))
 }
 
+// generateConstGroup emits a CONST block as a Go const block. Omitted values
+// count up from the explicit value before them, or from 0, using iota.
+func (g *Generator) generateConstGroup(stmt *parser.ConstGroupStatement) {
+	g.writeLine("const (")
+	g.indent++
+	for i, name := range stmt.Names {
+		constName := g.varToGo(name.Value)
+		switch {
+		case stmt.Values[i] != nil:
+			g.writeLine(fmt.Sprintf("%s = %s", constName, g.exprToGo(stmt.Values[i])))
+		case i == 0:
+			g.writeLine(constName + " = iota")
+		case stmt.Values[i-1] != nil:
+			g.writeLine(fmt.Sprintf("%s = %s + iota - %d", constName, g.exprToGo(stmt.Values[i-1]), i-1))
+		default:
+			// Go repeats the previous expression with the next iota
+			g.writeLine(constName)
+		}
+	}
+	g.indent--
+	g.writeLine(")")
+}
+
 func (g *Generator) generateSubStatement(stmt *parser.SubStatement) {
 	g.writeLine("")
-	funcName := g.toGoIdent(stmt.Name.Value)
+	funcName := g.varToGo(stmt.Name.Value)
 	params := g.generateParams(stmt.Params)
 	g.writeLine(fmt.Sprintf("func %s(%s) {", funcName, params))
 	g.indent++
 	// Track local variables for this sub
 	oldScope := g.currentScope
 	oldFunc := g.currentFunc
-	g.currentScope = analyzer.NewScope(stmt.Name.Value, g.symbols.GlobalScope)
-	g.currentFunc = stmt.Name.Value
+	g.currentScope = analyzer.NewScope(stmt.Name.Value, oldScope)
+	g.currentFunc = funcName
 	// Add parameters to local scope
 	for _, p := range stmt.Params {
 		paramType := g.typeFromTypeSpec(p.Type)
@@ -940,16 +4911,279 @@ func (g *Generator) generateSubStatement(stmt *parser.SubStatement) {
 			Type: paramType,
 		})
 	}
-	g.generateBlockStatement(stmt.Body)
+	g.generateRoutineBody(stmt.Body, nil)
 	g.currentScope = oldScope
 	g.currentFunc = oldFunc
 	g.indent--
 	g.writeLine("}")
 }
 
+// generateTestStatement emits the body of a TEST block as a function with
+// no parameters, and records the Go test that GenerateTests writes for it
+func (g *Generator) generateTestStatement(stmt *parser.TestStatement) {
+	name := identPart(stmt.Name)
+	for i := 2; g.hasTest("Test_" + name); i++ {
+		name = fmt.Sprintf("%s_%d", identPart(stmt.Name), i)
+	}
+	file, line := g.sourceLocation(stmt.Token.Line)
+	g.tests = append(g.tests, TestCase{Name: stmt.Name, GoName: "Test_" + name, File: file, Line: line})
+
+	g.writeLine("")
+	funcName := "dbtest_" + name
+	g.writeLine(fmt.Sprintf("func %s() {", funcName))
+	g.indent++
+	oldScope := g.currentScope
+	oldFunc := g.currentFunc
+	g.currentScope = analyzer.NewScope("TEST "+stmt.Name, oldScope)
+	g.currentFunc = fmt.Sprintf("TEST %q", stmt.Name)
+	g.generateRoutineBody(stmt.Body, nil)
+	g.currentScope = oldScope
+	g.currentFunc = oldFunc
+	g.indent--
+	g.writeLine("}")
+}
+
+// generateBenchmarkStatement emits the body of a BENCHMARK block as a
+// function with no parameters, and records the Go benchmark that
+// GenerateTests writes for it
+func (g *Generator) generateBenchmarkStatement(stmt *parser.BenchmarkStatement) {
+	name := identPart(stmt.Name)
+	for i := 2; g.hasTest("Benchmark_" + name); i++ {
+		name = fmt.Sprintf("%s_%d", identPart(stmt.Name), i)
+	}
+	file, line := g.sourceLocation(stmt.Token.Line)
+	g.benchmarks = append(g.benchmarks, TestCase{Name: stmt.Name, GoName: "Benchmark_" + name, File: file, Line: line})
+
+	g.writeLine("")
+	g.writeLine(fmt.Sprintf("func dbbench_%s() {", name))
+	g.indent++
+	oldScope := g.currentScope
+	oldFunc := g.currentFunc
+	g.currentScope = analyzer.NewScope("BENCHMARK "+stmt.Name, oldScope)
+	g.currentFunc = fmt.Sprintf("BENCHMARK %q", stmt.Name)
+	g.generateRoutineBody(stmt.Body, nil)
+	g.currentScope = oldScope
+	g.currentFunc = oldFunc
+	g.indent--
+	g.writeLine("}")
+}
+
+func (g *Generator) hasTest(goName string) bool {
+	for _, tc := range g.tests {
+		if tc.GoName == goName {
+			return true
+		}
+	}
+	for _, tc := range g.benchmarks {
+		if tc.GoName == goName {
+			return true
+		}
+	}
+	return false
+}
+
+// identPart turns text such as a test name into characters that are valid
+// in a Go identifier
+func identPart(name string) string {
+	var sb strings.Builder
+	for _, r := range name {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			sb.WriteRune(r)
+		} else {
+			sb.WriteByte('_')
+		}
+	}
+	return sb.String()
+}
+
+// generateEmbeds declares the variables of EMBEDFILE and EMBEDDIR
+// statements, filled in by go:embed. An EMBEDDIR is embedded as an
+// embed.FS, then read into a map.
+func (g *Generator) generateEmbeds() {
+	for _, stmt := range g.program.Statements {
+		s, ok := stmt.(*parser.EmbedStatement)
+		if !ok {
+			continue
+		}
+		embed := Embed{
+			Path:   s.Path,
+			Target: fmt.Sprintf("dbasic_embed/%d/%s", len(g.embeds), path.Base(filepath.ToSlash(s.Path))),
+			IsDir:  s.IsDir,
+			Line:   s.Token.Line,
+		}
+		g.embeds = append(g.embeds, embed)
+
+		name := g.varToGo(s.Name.Value)
+		g.writeLine("")
+		g.writeLine("//go:embed " + strconv.Quote(embed.Target))
+		if s.IsDir {
+			g.runtimeFuncs["embedDir"] = true
+			fsName := fmt.Sprintf("_embed%d", len(g.embeds)-1)
+			g.writeLine(fmt.Sprintf("var %s embed.FS", fsName))
+			g.writeLine(fmt.Sprintf("var %s = embedDir(%s, %q)", name, fsName, embed.Target))
+		} else {
+			if _, ok := g.imports["embed"]; !ok {
+				g.imports["embed"] = "_"
+			}
+			g.writeLine(fmt.Sprintf("var %s []byte", name))
+		}
+	}
+}
+
+// Embeds returns the files and directories the generated code embeds, in
+// source order
+func (g *Generator) Embeds() []Embed {
+	return g.embeds
+}
+
+// Tests returns the TEST blocks generated in test mode, in source order
+func (g *Generator) Tests() []TestCase {
+	return g.tests
+}
+
+// Benchmarks returns the BENCHMARK blocks generated in test mode, in source
+// order
+func (g *Generator) Benchmarks() []TestCase {
+	return g.benchmarks
+}
+
+// GenerateTests returns a Go test file with a test for each TEST block and
+// a benchmark for each BENCHMARK block. Call it after Generate, and build
+// it in the same package.
+func (g *Generator) GenerateTests() string {
+	var sb strings.Builder
+	sb.WriteString(`package main
+
+import "testing"
+
+// runTest runs the body of a TEST block. A failed assertion marks the test
+// failed and carries on; a runtime error or failed ASSERT ends it.
+func runTest(t *testing.T, body func()) {
+	testFail = func(msg string) { t.Error(msg) }
+	defer func() {
+		testFail = nil
+		if r := recover(); r != nil {
+			t.Error(r)
+		}
+	}()
+	body()
+}
+
+// runBenchmark runs the body of a BENCHMARK block b.N times. A failed
+// assertion fails the benchmark; a runtime error or failed ASSERT ends it.
+func runBenchmark(b *testing.B, body func()) {
+	testFail = func(msg string) { b.Error(msg) }
+	defer func() {
+		testFail = nil
+		if r := recover(); r != nil {
+			b.Error(r)
+		}
+	}()
+	for i := 0; i < b.N; i++ {
+		body()
+	}
+}
+`)
+	for _, tc := range g.tests {
+		sb.WriteString(fmt.Sprintf("\n// %s runs TEST %q at %s:%d\n", tc.GoName, tc.Name, tc.File, tc.Line))
+		sb.WriteString(fmt.Sprintf("func %s(t *testing.T) {\n\trunTest(t, dbtest_%s)\n}\n", tc.GoName, strings.TrimPrefix(tc.GoName, "Test_")))
+	}
+	for _, bc := range g.benchmarks {
+		sb.WriteString(fmt.Sprintf("\n// %s runs BENCHMARK %q at %s:%d\n", bc.GoName, bc.Name, bc.File, bc.Line))
+		sb.WriteString(fmt.Sprintf("func %s(b *testing.B) {\n\trunBenchmark(b, dbbench_%s)\n}\n", bc.GoName, strings.TrimPrefix(bc.GoName, "Benchmark_")))
+	}
+	return sb.String()
+}
+
+// sourceLocation returns the file and line that a line of the compiled
+// source came from, for error messages
+func (g *Generator) sourceLocation(line int) (string, int) {
+	if g.sourceMap != nil {
+		if file, orig := g.sourceMap(line); orig > 0 {
+			return file, orig
+		}
+	}
+	if g.sourceFile == "" {
+		return "unknown", line
+	}
+	return g.sourceFile, line
+}
+
+// extractLineMap removes the statement marks generateStatement writes from
+// code, and returns the code and the source line of each line the marks
+// enclose. A mark is a line number between NUL bytes where a statement's
+// code starts, and two NUL bytes where it ends; a line belongs to the
+// innermost statement open at its end.
+func (g *Generator) extractLineMap(code string) (string, []LineMapping) {
+	if !strings.Contains(code, "\x00") {
+		return code, nil
+	}
+	var sb strings.Builder
+	var lines []LineMapping
+	var open []int
+	for i, text := range strings.SplitAfter(code, "\n") {
+		for {
+			start := strings.IndexByte(text, 0)
+			if start < 0 {
+				break
+			}
+			end := start + 1 + strings.IndexByte(text[start+1:], 0)
+			if line, err := strconv.Atoi(text[start+1 : end]); err == nil {
+				open = append(open, line)
+			} else if len(open) > 0 {
+				open = open[:len(open)-1]
+			}
+			text = text[:start] + text[end+1:]
+		}
+		sb.WriteString(text)
+		if len(open) > 0 && strings.TrimSpace(text) != "" {
+			file, line := g.sourceLocation(open[len(open)-1])
+			lines = append(lines, LineMapping{GoLine: i + 1, File: file, Line: line})
+		}
+	}
+	return sb.String(), lines
+}
+
+// ReformatLineMap carries a line map over to formatted code, such as
+// go/format writes, which moves lines only by adding and removing blank
+// ones. It returns false if the code has a different number of other lines.
+func ReformatLineMap(lines []LineMapping, code, formatted string) ([]LineMapping, bool) {
+	// The line number in formatted of each nonblank line in code
+	var nonblank []int
+	for i, text := range strings.Split(formatted, "\n") {
+		if strings.TrimSpace(text) != "" {
+			nonblank = append(nonblank, i+1)
+		}
+	}
+	moved := make(map[int]int)
+	n := 0
+	for i, text := range strings.Split(code, "\n") {
+		if strings.TrimSpace(text) == "" {
+			continue
+		}
+		if n == len(nonblank) {
+			return nil, false
+		}
+		moved[i+1] = nonblank[n]
+		n++
+	}
+	if n != len(nonblank) {
+		return nil, false
+	}
+
+	result := make([]LineMapping, 0, len(lines))
+	for _, m := range lines {
+		if goLine, ok := moved[m.GoLine]; ok {
+			m.GoLine = goLine
+			result = append(result, m)
+		}
+	}
+	return result, true
+}
+
 func (g *Generator) generateFunctionStatement(stmt *parser.FunctionStatement) {
 	g.writeLine("")
-	funcName := g.toGoIdent(stmt.Name.Value)
+	funcName := g.varToGo(stmt.Name.Value)
 	params := g.generateParams(stmt.Params)
 	returns := g.generateReturnTypes(stmt.ReturnTypes)
 	g.writeLine(fmt.Sprintf("func %s(%s) %s {", funcName, params, returns))
@@ -957,8 +5191,8 @@ func (g *Generator) generateFunctionStatement(stmt *parser.FunctionStatement) {
 	// Track local variables for this function
 	oldScope := g.currentScope
 	oldFunc := g.currentFunc
-	g.currentScope = analyzer.NewScope(stmt.Name.Value, g.symbols.GlobalScope)
-	g.currentFunc = stmt.Name.Value
+	g.currentScope = analyzer.NewScope(stmt.Name.Value, oldScope)
+	g.currentFunc = funcName
 	// Add parameters to local scope
 	for _, p := range stmt.Params {
 		paramType := g.typeFromTypeSpec(p.Type)
@@ -968,13 +5202,107 @@ func (g *Generator) generateFunctionStatement(stmt *parser.FunctionStatement) {
 			Type: paramType,
 		})
 	}
-	g.generateBlockStatement(stmt.Body)
+	g.generateRoutineBody(stmt.Body, stmt.ReturnTypes)
+	g.currentScope = oldScope
+	g.currentFunc = oldFunc
+	g.indent--
+	g.writeLine("}")
+}
+
+// generateConstructor emits the SUB New of a TYPE as new_TypeName, a function
+// building the value in the named result _self. The body is generated as a
+// WITH block on _self, so .Field = value sets its fields.
+func (g *Generator) generateConstructor(stmt *parser.TypeStatement) {
+	g.writeLine("")
+	typeName := g.toGoIdent(stmt.Name.Value)
+	params := g.generateParams(stmt.Constructor.Params)
+	g.writeLine(fmt.Sprintf("func new_%s(%s) (_self %s) {", typeName, params, typeName))
+	g.indent++
+	oldScope := g.currentScope
+	oldFunc := g.currentFunc
+	g.currentScope = analyzer.NewScope(stmt.Name.Value+".New", oldScope)
+	g.currentFunc = typeName + ".New"
+	for _, p := range stmt.Constructor.Params {
+		g.currentScope.Define(&analyzer.Symbol{
+			Name: p.Name.Value,
+			Kind: analyzer.SymParameter,
+			Type: g.typeFromTypeSpec(p.Type),
+		})
+	}
+	g.withTargets = append(g.withTargets, &withTarget{expr: "_self", typ: g.types.Lookup(stmt.Name.Value)})
+	g.generateBlockStatement(stmt.Constructor.Body)
+	g.withTargets = g.withTargets[:len(g.withTargets)-1]
+	g.writeLine("return")
+	g.currentScope = oldScope
+	g.currentFunc = oldFunc
+	g.indent--
+	g.writeLine("}")
+}
+
+// generateProperty emits a PROPERTY accessor as a method on the TYPE:
+// get_Name with a value receiver, and set_Name with a pointer receiver so
+// the assignment is made to the original value. Like SUB New, the body is
+// generated as a WITH block on the receiver _self.
+func (g *Generator) generateProperty(stmt *parser.TypeStatement, prop *parser.PropertyDeclaration) {
+	structType := g.types.Lookup(stmt.Name.Value)
+	if structType == nil {
+		return
+	}
+	propName := prop.Name.Value
+	if p := structType.Property(propName); p != nil {
+		// Both accessors use the spelling of the first one declared
+		propName = p.Name
+	}
+
+	g.writeLine("")
+	typeName := g.toGoIdent(stmt.Name.Value)
+	oldScope := g.currentScope
+	oldFunc := g.currentFunc
+	g.currentScope = analyzer.NewScope(stmt.Name.Value+"."+propName, oldScope)
+	if prop.IsSet {
+		params := g.generateParams([]*parser.Parameter{prop.Param})
+		g.writeLine(fmt.Sprintf("func (_self *%s) set_%s(%s) {", typeName, propName, params))
+		g.currentFunc = typeName + ".set_" + propName
+		g.currentScope.Define(&analyzer.Symbol{
+			Name: prop.Param.Name.Value,
+			Kind: analyzer.SymParameter,
+			Type: g.typeFromTypeSpec(prop.Param.Type),
+		})
+	} else {
+		returnType := g.typeSpecToGo(prop.ReturnType)
+		g.writeLine(fmt.Sprintf("func (_self %s) get_%s() %s {", typeName, propName, returnType))
+		g.currentFunc = typeName + ".get_" + propName
+	}
+	g.indent++
+	g.withTargets = append(g.withTargets, &withTarget{expr: "_self", typ: structType})
+	g.generateBlockStatement(prop.Body)
+	g.withTargets = g.withTargets[:len(g.withTargets)-1]
 	g.currentScope = oldScope
 	g.currentFunc = oldFunc
 	g.indent--
 	g.writeLine("}")
 }
 
+// propertyOf returns the struct property accessed by expr, or nil if expr
+// is not a property access
+func (g *Generator) propertyOf(expr parser.Expression) *analyzer.StructProperty {
+	member, ok := expr.(*parser.MemberExpression)
+	if !ok {
+		return nil
+	}
+	t := g.exprType(member.Object)
+	if t != nil && t.Kind == analyzer.TypePointer {
+		t = t.ElementType
+	}
+	if t == nil || t.Kind != analyzer.TypeStruct {
+		return nil
+	}
+	if m, _ := g.types.Member(t, member.Member.Value); m != nil {
+		return m.Property
+	}
+	return nil
+}
+
 func (g *Generator) generateMethodStatement(stmt *parser.MethodStatement) {
 	g.writeLine("")
 
@@ -998,7 +5326,7 @@ func (g *Generator) generateMethodStatement(stmt *parser.MethodStatement) {
 	oldScope := g.currentScope
 	oldFunc := g.currentFunc
 	g.currentScope = analyzer.NewScope(stmt.Name.Value, g.symbols.GlobalScope)
-	g.currentFunc = stmt.Name.Value
+	g.currentFunc = strings.TrimPrefix(receiverType, "*") + "." + stmt.Name.Value
 
 	// Add receiver to local scope
 	recvType := g.typeFromTypeSpec(stmt.ReceiverType)
@@ -1018,13 +5346,245 @@ func (g *Generator) generateMethodStatement(stmt *parser.MethodStatement) {
 		})
 	}
 
-	g.generateBlockStatement(stmt.Body)
+	g.generateRoutineBody(stmt.Body, stmt.ReturnTypes)
 	g.currentScope = oldScope
 	g.currentFunc = oldFunc
 	g.indent--
 	g.writeLine("}")
 }
 
+// generateRoutineBody emits the body of a SUB, FUNCTION or METHOD. With an
+// ON ERROR GOTO handler, the statements before the handler label are wrapped
+// in a closure that reports whether they left the routine; a recovered panic
+// falls through to the handler instead. GOSUB subroutines are closures too,
+// declared ahead of the code that calls them. Top-level DIMs are declared
+// ahead of the closures so that all of them can see the variables.
+func (g *Generator) generateRoutineBody(body *parser.BlockStatement, returnTypes []*parser.TypeSpec) {
+	outerResults := g.results
+	g.results = returnTypes
+	defer func() { g.results = outerResults }()
+
+	handler := analyzer.ErrorHandlerIndex(body)
+	subs := g.symbols.Subroutines(body)
+	if handler < 0 && len(subs) == 0 {
+		g.generateBlockStatement(body)
+		return
+	}
+	outerSubs := g.subroutines
+	g.subroutines = subs
+	defer func() { g.subroutines = outerSubs }()
+
+	var trap *errorTrap
+	var decls []string
+	if handler >= 0 {
+		g.runtimeFuncs["trapError"] = true
+		trap = &errorTrap{protected: true}
+		for i, rt := range returnTypes {
+			name := fmt.Sprintf("_r%d", i)
+			trap.results = append(trap.results, name)
+			decls = append(decls, name+" "+g.typeSpecToGo(rt))
+		}
+		g.writeLine("_onError := false")
+		g.writeLine("_line := 0")
+	}
+
+	hoist := body.Statements
+	if len(subs) == 0 {
+		hoist = hoist[:handler]
+	}
+	g.hoisted = make(map[*parser.DimStatement]bool)
+	for _, stmt := range hoist {
+		if dim, ok := stmt.(*parser.DimStatement); ok && !dim.Static {
+			varType := g.typeSpecToGo(dim.Type)
+			if dim.ArraySize != nil {
+				varType = "[]" + varType
+			}
+			g.writeLine(fmt.Sprintf("var %s %s", g.toGoIdent(dim.Name.Value), varType))
+			g.defineLocalDim(dim)
+			g.hoisted[dim] = true
+		}
+	}
+
+	outer := g.trap
+	g.trap = trap
+	defer func() { g.trap = outer }()
+	if len(subs) > 0 {
+		g.generateSubroutines(body)
+	}
+	if handler < 0 {
+		g.generateMainFlow(body, 0, len(body.Statements))
+		return
+	}
+
+	g.writeLine(fmt.Sprintf("%s := func() (%s) {",
+		strings.Join(append(trap.results, "_exit"), ", "), strings.Join(append(decls, "_exit bool"), ", ")))
+	g.indent++
+	g.writeLine("defer func() {")
+	g.indent++
+	g.writeLine("if r := recover(); r != nil {")
+	g.indent++
+	g.writeLine("if !_onError {")
+	g.writeLine("\tpanic(r)")
+	g.writeLine("}")
+	g.writeLine("trapError(r, _line)")
+	g.indent--
+	g.writeLine("}")
+	g.indent--
+	g.writeLine("}()")
+	g.generateMainFlow(body, 0, handler)
+	if !g.endsWithReturn(body, 0, handler) {
+		g.writeLine("return")
+	}
+	g.indent--
+	g.writeLine("}()")
+	g.writeLine("if _exit {")
+	g.writeLine("\t" + strings.TrimSpace("return "+strings.Join(trap.results, ", ")))
+	g.writeLine("}")
+
+	// The handler label itself is dropped: nothing can jump to it
+	trap.protected = false
+	g.generateMainFlow(body, handler+1, len(body.Statements))
+	if len(trap.results) > 0 && !g.endsWithReturn(body, handler+1, len(body.Statements)) {
+		g.writeLine("return " + strings.Join(trap.results, ", "))
+	}
+}
+
+// generateSubroutines declares each GOSUB subroutine of a routine body as a
+// closure. When another subroutine starts inside one, the two share their
+// RETURN, so the outer one finishes by running the inner one.
+func (g *Generator) generateSubroutines(body *parser.BlockStatement) {
+	var names []string
+	for _, sub := range g.subroutines {
+		names = append(names, gosubName(sub.Label))
+	}
+	g.writeLine(fmt.Sprintf("var %s func()", strings.Join(names, ", ")))
+
+	outer := g.gosub
+	g.gosub = true
+	for _, sub := range g.subroutines {
+		g.writeLine(gosubName(sub.Label) + " = func() {")
+		g.indent++
+		for i := sub.Start + 1; i < sub.End; i++ {
+			if inner := analyzer.SubroutineAt(g.subroutines, i); inner != sub {
+				g.writeLine(gosubName(inner.Label) + "()")
+				break
+			}
+			g.generateStatement(body.Statements[i])
+		}
+		g.indent--
+		g.writeLine("}")
+	}
+	g.gosub = outer
+}
+
+// generateMainFlow emits the top-level statements from..to of a routine
+// body, leaving out GOSUB subroutines. Reaching a subroutine label without
+// GOSUB is error 3, RETURN without GOSUB.
+func (g *Generator) generateMainFlow(body *parser.BlockStatement, from, to int) {
+	for i := from; i < to; i++ {
+		if sub := analyzer.SubroutineAt(g.subroutines, i); sub != nil {
+			if g.trap != nil && g.trap.protected {
+				g.writeLine(fmt.Sprintf("_line = %d", body.Statements[i].(*parser.LabelStatement).Token.Line))
+			}
+			g.runtimeFuncs["trapError"] = true
+			g.writeLine("panic(errorNumber(3))")
+			i = sub.End
+			continue
+		}
+		g.generateStatement(body.Statements[i])
+	}
+}
+
+// endsWithReturn reports whether the last statement generateMainFlow emits
+// for from..to is a RETURN
+func (g *Generator) endsWithReturn(body *parser.BlockStatement, from, to int) bool {
+	if to <= from || analyzer.SubroutineAt(g.subroutines, to-1) != nil {
+		return false
+	}
+	return isReturn(body.Statements[to-1])
+}
+
+// gosubName returns the Go name of the closure of a GOSUB subroutine
+func gosubName(label string) string {
+	return "_gosub" + label
+}
+
+// isReturn reports whether stmt is a RETURN statement
+func isReturn(stmt parser.Statement) bool {
+	_, ok := stmt.(*parser.ReturnStatement)
+	return ok
+}
+
+// trapReturn returns the Go statement that leaves a routine from inside its
+// ON ERROR closure, returning vals or, when there are none, the closure's
+// named results
+func (g *Generator) trapReturn(vals []string) string {
+	if len(vals) == 0 {
+		vals = g.trap.results
+	}
+	return "return " + strings.Join(append(vals, "true"), ", ")
+}
+
+// statementLine returns the source line of a statement that can raise an
+// error, or 0
+func statementLine(stmt parser.Statement) int {
+	switch s := stmt.(type) {
+	case *parser.DimStatement:
+		return s.Token.Line
+	case *parser.LetStatement:
+		return s.Token.Line
+	case *parser.AssignmentStatement:
+		return s.Token.Line
+	case *parser.MultiAssignmentStatement:
+		return s.Token.Line
+	case *parser.PrintStatement:
+		return s.Token.Line
+	case *parser.InputStatement:
+		return s.Token.Line
+	case *parser.OpenStatement:
+		return s.Token.Line
+	case *parser.CloseStatement:
+		return s.Token.Line
+	case *parser.LineInputStatement:
+		return s.Token.Line
+	case *parser.GetPutStatement:
+		return s.Token.Line
+	case *parser.IfStatement:
+		return s.Token.Line
+	case *parser.ForStatement:
+		return s.Token.Line
+	case *parser.ForEachStatement:
+		return s.Token.Line
+	case *parser.WithStatement:
+		return s.Token.Line
+	case *parser.LockStatement:
+		return s.Token.Line
+	case *parser.WhileStatement:
+		return s.Token.Line
+	case *parser.DoLoopStatement:
+		return s.Token.Line
+	case *parser.SelectStatement:
+		return s.Token.Line
+	case *parser.SelectChannelStatement:
+		return s.Token.Line
+	case *parser.SpawnStatement:
+		return s.Token.Line
+	case *parser.SendStatement:
+		return s.Token.Line
+	case *parser.ReceiveStatement:
+		return s.Token.Line
+	case *parser.ReturnStatement:
+		return s.Token.Line
+	case *parser.ErrorStatement:
+		return s.Token.Line
+	case *parser.AssertStatement:
+		return s.Token.Line
+	case *parser.ExpressionStatement:
+		return s.Token.Line
+	}
+	return 0
+}
+
 func (g *Generator) generateParams(params []*parser.Parameter) string {
 	var parts []string
 	for _, p := range params {
@@ -1033,6 +5593,10 @@ func (g *Generator) generateParams(params []*parser.Parameter) string {
 		if p.ByRef {
 			paramType = "*" + paramType
 		}
+		if p.ParamArray && p.Type.IsArray {
+			// PARAMARRAY args AS []T -> args ...T
+			paramType = "..." + g.typeSpecToGo(p.Type.ElementType)
+		}
 		parts = append(parts, fmt.Sprintf("%s %s", paramName, paramType))
 	}
 	return strings.Join(parts, ", ")
@@ -1063,9 +5627,24 @@ func (g *Generator) generateBlockStatement(block *parser.BlockStatement) {
 }
 
 func (g *Generator) generateStatement(stmt parser.Statement) {
+	// Mark where the statement's code starts and ends, for LineMap
+	if line := statementLine(stmt); line > 0 {
+		g.output.WriteString(fmt.Sprintf("\x00%d\x00", line))
+		defer g.output.WriteString("\x00\x00")
+	}
+	if g.trap != nil && g.trap.protected {
+		// Track the line for ERL
+		if line := statementLine(stmt); line > 0 {
+			g.writeLine(fmt.Sprintf("_line = %d", line))
+		}
+	}
 	switch s := stmt.(type) {
 	case *parser.DimStatement:
 		g.generateLocalDim(s)
+	case *parser.ConstStatement:
+		g.generateConstStatement(s)
+	case *parser.ConstGroupStatement:
+		g.generateConstGroup(s)
 	case *parser.LetStatement:
 		g.generateLet(s)
 	case *parser.AssignmentStatement:
@@ -1076,16 +5655,34 @@ func (g *Generator) generateStatement(stmt parser.Statement) {
 		g.generatePrint(s)
 	case *parser.InputStatement:
 		g.generateInput(s)
+	case *parser.OpenStatement:
+		g.generateOpen(s)
+	case *parser.CloseStatement:
+		g.generateClose(s)
+	case *parser.LineInputStatement:
+		g.generateLineInput(s)
+	case *parser.GetPutStatement:
+		g.generateGetPut(s)
 	case *parser.IfStatement:
 		g.generateIf(s)
 	case *parser.ForStatement:
-		g.generateFor(s)
+		g.generateLoop(func() { g.generateFor(s) })
+	case *parser.ForEachStatement:
+		g.generateLoop(func() { g.generateForEach(s) })
+	case *parser.WithStatement:
+		g.generateWith(s)
+	case *parser.LockStatement:
+		g.generateLock(s)
+	case *parser.AssertStatement:
+		g.generateAssert(s)
 	case *parser.WhileStatement:
-		g.generateWhile(s)
+		g.generateLoop(func() { g.generateWhile(s) })
 	case *parser.DoLoopStatement:
-		g.generateDoLoop(s)
+		g.generateLoop(func() { g.generateDoLoop(s) })
 	case *parser.SelectStatement:
 		g.generateSelect(s)
+	case *parser.SelectChannelStatement:
+		g.generateSelectChannel(s)
 	case *parser.ReturnStatement:
 		g.generateReturn(s)
 	case *parser.ExitStatement:
@@ -1094,6 +5691,15 @@ func (g *Generator) generateStatement(stmt parser.Statement) {
 		g.generateGoto(s)
 	case *parser.LabelStatement:
 		g.generateLabel(s)
+	case *parser.GosubStatement:
+		g.generateGosub(s)
+	case *parser.CheckStatement:
+		g.generateCheck(s)
+	case *parser.OnErrorStatement:
+		g.writeLine(fmt.Sprintf("_onError = %t", s.Label != ""))
+	case *parser.ErrorStatement:
+		g.runtimeFuncs["trapError"] = true
+		g.writeLine(fmt.Sprintf("panic(errorNumber(%s))", g.exprToGo(s.Code)))
 	case *parser.SpawnStatement:
 		g.generateSpawn(s)
 	case *parser.SendStatement:
@@ -1111,21 +5717,186 @@ func (g *Generator) generateLocalDim(stmt *parser.DimStatement) {
 	varName := g.toGoIdent(stmt.Name.Value)
 	varType := g.typeSpecToGo(stmt.Type)
 
-	// Track the variable type in current scope
+	g.defineLocalDim(stmt)
+
+	if stmt.Static {
+		g.generateStaticDim(stmt)
+		return
+	}
+
+	if g.hoisted[stmt] {
+		// Declared at the start of the routine; only the initializer is left
+		switch {
+		case stmt.ArraySize != nil:
+			g.writeLineWithSource(fmt.Sprintf("%s = make([]%s, %s)", varName, varType, g.arraySizeToGo(stmt)), stmt.Token.Line)
+		case g.hasDimValue(stmt):
+			g.writeLineWithSource(fmt.Sprintf("%s = %s", varName, g.dimValueToGo(stmt)), stmt.Token.Line)
+		case readyValue(stmt.Type, varType) != "":
+			g.writeLineWithSource(fmt.Sprintf("%s = %s", varName, readyValue(stmt.Type, varType)), stmt.Token.Line)
+		}
+		return
+	}
+
+	if stmt.ArraySize != nil {
+		g.writeLineWithSource(fmt.Sprintf("%s := make([]%s, %s)", varName, varType, g.arraySizeToGo(stmt)), stmt.Token.Line)
+	} else if g.hasDimValue(stmt) {
+		g.writeLineWithSource(fmt.Sprintf("var %s %s = %s", varName, varType, g.dimValueToGo(stmt)), stmt.Token.Line)
+	} else if value := readyValue(stmt.Type, varType); value != "" {
+		g.writeLineWithSource(fmt.Sprintf("var %s %s = %s", varName, varType, value), stmt.Token.Line)
+	} else {
+		g.writeLineWithSource(fmt.Sprintf("var %s %s", varName, varType), stmt.Token.Line)
+	}
+}
+
+// readyValue returns the value a DIM without an initializer gives a map,
+// STACK, QUEUE or SET, which are usable as soon as they are declared, or ""
+// for other types
+func readyValue(spec *parser.TypeSpec, goType string) string {
+	switch {
+	case spec == nil:
+		return ""
+	case spec.IsMap:
+		return "make(" + goType + ")"
+	case spec.IsContainer:
+		return "&" + strings.TrimPrefix(goType, "*") + "{}"
+	}
+	return ""
+}
+
+// defineLocalDim tracks the type of a local DIM in the current scope
+func (g *Generator) defineLocalDim(stmt *parser.DimStatement) {
 	t := g.typeFromTypeSpec(stmt.Type)
+	if stmt.ArraySize != nil {
+		t = analyzer.NewSliceType(t)
+		if stmt.LowerBound != nil {
+			t.LowerBound, _ = g.symbols.IntConstant(stmt.LowerBound)
+		}
+	}
 	g.currentScope.Define(&analyzer.Symbol{
 		Name: stmt.Name.Value,
 		Kind: analyzer.SymVariable,
 		Type: t,
 	})
+}
 
-	if stmt.ArraySize != nil {
-		g.writeLineWithSource(fmt.Sprintf("%s := make([]%s, %s)", varName, varType, g.exprToGo(stmt.ArraySize)), stmt.Token.Line)
-	} else if stmt.Value != nil {
-		g.writeLineWithSource(fmt.Sprintf("var %s %s = %s", varName, varType, g.exprToGo(stmt.Value)), stmt.Token.Line)
-	} else {
-		g.writeLineWithSource(fmt.Sprintf("var %s %s", varName, varType), stmt.Token.Line)
+// generateStaticDim hoists a STATIC local to a package-level variable so it
+// keeps its value between calls. The variable is named after the routine
+// that declares it, and references inside the routine are renamed to match.
+func (g *Generator) generateStaticDim(stmt *parser.DimStatement) {
+	goName := fmt.Sprintf("static_%s_%s", identPart(g.currentFunc), stmt.Name.Value)
+	g.currentScope.ResolveLocal(stmt.Name.Value).GoName = goName
+	varType := g.typeSpecToGo(stmt.Type)
+
+	var decl string
+	switch {
+	case stmt.ArraySize != nil:
+		decl = fmt.Sprintf("%s = make([]%s, %s)", goName, varType, g.arraySizeToGo(stmt))
+	case g.hasDimValue(stmt):
+		decl = fmt.Sprintf("%s %s = %s", goName, varType, g.dimValueToGo(stmt))
+	case readyValue(stmt.Type, varType) != "":
+		decl = fmt.Sprintf("%s %s = %s", goName, varType, readyValue(stmt.Type, varType))
+	default:
+		decl = fmt.Sprintf("%s %s", goName, varType)
+	}
+	g.staticVars = append(g.staticVars, decl)
+}
+
+// varToGo returns the Go name of a variable, following STATIC locals to
+// the package-level variable they were hoisted to
+func (g *Generator) varToGo(name string) string {
+	sym := g.currentScope.Resolve(name)
+	if sym != nil && sym.GoName != "" {
+		g.usePlugin(sym)
+		return sym.GoName
+	}
+	if sym == nil && analyzer.IsErrorVariable(name) {
+		g.runtimeFuncs["trapError"] = true
+		if strings.EqualFold(name, "ERR") {
+			return "_errNumber"
+		}
+		return "_errLine"
+	}
+	return g.toGoIdent(name)
+}
+
+// arraySizeToGo generates the length of an array DIM. DIM a(lower TO upper)
+// holds upper - lower + 1 elements.
+func (g *Generator) arraySizeToGo(stmt *parser.DimStatement) string {
+	if stmt.LowerBound == nil {
+		return g.exprToGo(stmt.ArraySize)
+	}
+	lower, _ := g.symbols.IntConstant(stmt.LowerBound)
+	if upper, ok := g.symbols.IntConstant(stmt.ArraySize); ok {
+		return fmt.Sprintf("%d", upper-lower+1)
+	}
+	upper := g.exprToGo(stmt.ArraySize)
+	switch {
+	case lower == 1:
+		return upper
+	case lower < 1:
+		return fmt.Sprintf("(%s) + %d", upper, 1-lower)
+	default:
+		return fmt.Sprintf("(%s) - %d", upper, lower-1)
+	}
+}
+
+// arrayIndexToGo translates an index into an array declared with a lower
+// bound to the 0-based index of the underlying slice
+func (g *Generator) arrayIndexToGo(left parser.Expression, index parser.Expression) string {
+	t := g.exprType(left)
+	if t == nil || t.Kind != analyzer.TypeSlice || t.LowerBound == 0 {
+		return g.exprToGo(index)
+	}
+	if v, ok := analyzer.ArrayLowerBound(index); ok {
+		return fmt.Sprintf("%d", v-t.LowerBound)
+	}
+	if t.LowerBound < 0 {
+		return fmt.Sprintf("%s + %d", g.exprToGo(index), -t.LowerBound)
+	}
+	return fmt.Sprintf("%s - %d", g.exprToGo(index), t.LowerBound)
+}
+
+// dimValueToGo generates the initializer of a DIM statement. A {"key": value}
+// literal initializing a MAP is emitted as a literal of the map's own type.
+func (g *Generator) dimValueToGo(stmt *parser.DimStatement) string {
+	if width, ok := g.fixedWidth(stmt); ok {
+		// STRING * n starts as n spaces, or the value padded to n
+		value := `""`
+		if stmt.Value != nil {
+			value = g.exprToGo(stmt.Value)
+		}
+		return g.fixedStringToGo(value, width)
+	}
+	if lit, ok := stmt.Value.(*parser.JSONLiteral); ok && stmt.Type != nil && stmt.Type.IsMap {
+		var pairs []string
+		for k, v := range lit.Pairs {
+			pairs = append(pairs, fmt.Sprintf("%q: %s", k, g.exprToGo(v)))
+		}
+		sort.Strings(pairs)
+		return fmt.Sprintf("%s{%s}", g.typeSpecToGo(stmt.Type), strings.Join(pairs, ", "))
+	}
+	return g.exprToGo(stmt.Value)
+}
+
+// fixedWidth returns the width of a DIM name AS STRING * n
+func (g *Generator) fixedWidth(stmt *parser.DimStatement) (int, bool) {
+	if stmt.Type == nil || stmt.Type.Width == nil || stmt.ArraySize != nil {
+		return 0, false
 	}
+	return g.symbols.FixedStringWidth(stmt.Type)
+}
+
+// hasDimValue reports whether a DIM is initialized, either with a value or,
+// for STRING * n, with padding
+func (g *Generator) hasDimValue(stmt *parser.DimStatement) bool {
+	_, fixed := g.fixedWidth(stmt)
+	return stmt.Value != nil || fixed
+}
+
+// fixedStringToGo pads or truncates a Go string expression to width bytes
+func (g *Generator) fixedStringToGo(code string, width int) string {
+	g.runtimeFuncs["FixedString"] = true
+	return fmt.Sprintf("FixedString(%s, %d)", code, width)
 }
 
 func (g *Generator) generateLet(stmt *parser.LetStatement) {
@@ -1135,8 +5906,17 @@ func (g *Generator) generateLet(stmt *parser.LetStatement) {
 }
 
 func (g *Generator) generateAssignment(stmt *parser.AssignmentStatement) {
-	left := g.exprToGo(stmt.Left)
 	right := g.exprToGo(stmt.Value)
+	if prop := g.propertyOf(stmt.Left); prop != nil {
+		// Assigning to a property calls its SET accessor
+		object := g.exprToGo(stmt.Left.(*parser.MemberExpression).Object)
+		g.writeLine(fmt.Sprintf("%s.set_%s(%s)", object, prop.Name, right))
+		return
+	}
+	if t := g.exprType(stmt.Left); t != nil && t.Width > 0 {
+		right = g.fixedStringToGo(right, t.Width)
+	}
+	left := g.exprToGo(stmt.Left)
 	g.writeLine(fmt.Sprintf("%s = %s", left, right))
 }
 
@@ -1145,10 +5925,23 @@ func (g *Generator) generateMultiAssignment(stmt *parser.MultiAssignmentStatemen
 	for _, t := range stmt.Targets {
 		targets = append(targets, g.exprToGo(t))
 	}
+	if fields, ok := stmt.Value.(*parser.FieldListExpression); ok {
+		// a, b = value.{A, B} -> a, b = value.A, value.B
+		var values []string
+		for _, member := range fields.Members() {
+			values = append(values, g.memberExprToGo(member))
+		}
+		g.writeLine(fmt.Sprintf("%s = %s", strings.Join(targets, ", "), strings.Join(values, ", ")))
+		return
+	}
 	g.writeLine(fmt.Sprintf("%s = %s", strings.Join(targets, ", "), g.exprToGo(stmt.Value)))
 }
 
 func (g *Generator) generatePrint(stmt *parser.PrintStatement) {
+	if stmt.FileNumber != nil {
+		g.generateFilePrint(stmt)
+		return
+	}
 	if len(stmt.Values) == 0 {
 		g.writeLine("fmt.Println()")
 		return
@@ -1156,7 +5949,7 @@ func (g *Generator) generatePrint(stmt *parser.PrintStatement) {
 
 	var args []string
 	for _, v := range stmt.Values {
-		args = append(args, g.exprToGo(v))
+		args = append(args, g.displayValueToGo(v))
 	}
 
 	// Trailing separator means no newline
@@ -1170,20 +5963,129 @@ func (g *Generator) generatePrint(stmt *parser.PrintStatement) {
 	}
 }
 
-func (g *Generator) generateInput(stmt *parser.InputStatement) {
-	g.imports["bufio"] = ""
-	g.imports["os"] = ""
-	g.imports["strings"] = ""
+// generateFilePrint generates PRINT #n, values
+func (g *Generator) generateFilePrint(stmt *parser.PrintStatement) {
+	g.runtimeFuncs["FileOpen"] = true
+	g.runtimeFuncs["FilePrint"] = true
+	args := []string{g.intToGo(stmt.FileNumber)}
+	for _, v := range stmt.Values {
+		args = append(args, g.displayValueToGo(v))
+	}
+	if len(stmt.Values) > 0 && len(stmt.Separators) >= len(stmt.Values) {
+		g.writeLine(fmt.Sprintf("FilePrint(%s)", strings.Join(args, ", ")))
+	} else {
+		g.writeLine(fmt.Sprintf("FilePrintln(%s)", strings.Join(args, ", ")))
+	}
+}
+
+// displayValueToGo generates a value for PRINT or string interpolation.
+// DATETIME values are shown as "2006-01-02 15:04:05" instead of Go's
+// default form with time zone and monotonic clock reading.
+func (g *Generator) displayValueToGo(expr parser.Expression) string {
+	code := g.exprToGo(expr)
+	if t := g.exprType(expr); t != nil && t.Kind == analyzer.TypeDateTime {
+		return code + `.Format("2006-01-02 15:04:05")`
+	}
+	return code
+}
 
-	varName := g.toGoIdent(stmt.Variable.Value)
+func (g *Generator) generateInput(stmt *parser.InputStatement) {
+	if dim := stmt.Declaration(); dim != nil {
+		g.generateLocalDim(dim)
+	}
+	varName := g.varToGo(stmt.Variable.Value)
 
+	prompt := `""`
 	if stmt.Prompt != nil {
-		g.writeLine(fmt.Sprintf("fmt.Print(%s)", g.exprToGo(stmt.Prompt)))
+		prompt = g.exprToGo(stmt.Prompt)
+	}
+
+	g.runtimeFuncs["InputLine"] = true
+	var goType string
+	if t := g.exprType(stmt.Variable); t != nil {
+		goType = inputGoTypes[t.Kind]
+	}
+	if goType == "" {
+		line := fmt.Sprintf("InputLine(%s)", prompt)
+		if t := g.exprType(stmt.Variable); t != nil && t.Width > 0 {
+			line = g.fixedStringToGo(line, t.Width)
+		}
+		g.writeLine(fmt.Sprintf("%s = %s", varName, line))
+		return
+	}
+	// Other types are parsed, asking again until the line is valid
+	g.runtimeFuncs["InputValue"] = true
+	g.writeLine(fmt.Sprintf("%s = InputValue[%s](%s)", varName, goType, prompt))
+}
+
+// inputGoTypes maps the types INPUT parses to their Go types
+var inputGoTypes = map[analyzer.TypeKind]string{
+	analyzer.TypeInteger: "int",
+	analyzer.TypeLong:    "int64",
+	analyzer.TypeSingle:  "float32",
+	analyzer.TypeDouble:  "float64",
+	analyzer.TypeBoolean: "bool",
+}
+
+func (g *Generator) generateOpen(stmt *parser.OpenStatement) {
+	g.runtimeFuncs["FileOpen"] = true
+	args := fmt.Sprintf("%s, %q, %s", g.exprToGo(stmt.Path), stmt.Mode, g.intToGo(stmt.FileNumber))
+	if stmt.RecordLen != nil {
+		args += ", " + g.intToGo(stmt.RecordLen)
+	}
+	g.writeLine(fmt.Sprintf("FileOpen(%s)", args))
+}
+
+// generateGetPut generates GET #n, pos, var as FileGet(n, pos, &var, width)
+// and PUT as FilePut(n, pos, var, width)
+func (g *Generator) generateGetPut(stmt *parser.GetPutStatement) {
+	g.runtimeFuncs["FileOpen"] = true
+	g.runtimeFuncs["FileGet"] = true
+	pos := "0"
+	if stmt.Position != nil {
+		pos = g.exprToGo(stmt.Position)
+		if t := g.exprType(stmt.Position); t != nil && t.Kind != analyzer.TypeLong {
+			pos = fmt.Sprintf("int64(%s)", pos)
+		}
+	}
+	width := 0
+	if t := g.exprType(stmt.Variable); t != nil {
+		width = t.Width
+	}
+	if stmt.Name() == "GET" {
+		g.writeLine(fmt.Sprintf("FileGet(%s, %s, &%s, %d)", g.intToGo(stmt.FileNumber), pos, g.exprToGo(stmt.Variable), width))
+		return
+	}
+	g.writeLine(fmt.Sprintf("FilePut(%s, %s, %s, %d)", g.intToGo(stmt.FileNumber), pos, g.exprToGo(stmt.Variable), width))
+}
+
+func (g *Generator) generateClose(stmt *parser.CloseStatement) {
+	g.runtimeFuncs["FileOpen"] = true
+	g.runtimeFuncs["FileClose"] = true
+	var numbers []string
+	for _, n := range stmt.FileNumbers {
+		numbers = append(numbers, g.intToGo(n))
+	}
+	g.writeLine(fmt.Sprintf("FileClose(%s)", strings.Join(numbers, ", ")))
+}
+
+func (g *Generator) generateLineInput(stmt *parser.LineInputStatement) {
+	g.runtimeFuncs["FileOpen"] = true
+	g.runtimeFuncs["FileLineInput"] = true
+	line := fmt.Sprintf("FileLineInput(%s)", g.intToGo(stmt.FileNumber))
+	if t := g.exprType(stmt.Variable); t != nil && t.Width > 0 {
+		line = g.fixedStringToGo(line, t.Width)
 	}
+	g.writeLine(fmt.Sprintf("%s = %s", g.varToGo(stmt.Variable.Value), line))
+}
 
-	g.writeLine("_reader := bufio.NewReader(os.Stdin)")
-	g.writeLine(fmt.Sprintf("%s, _ = _reader.ReadString('\\n')", varName))
-	g.writeLine(fmt.Sprintf("%s = strings.TrimRight(%s, \"\\r\\n\")", varName, varName))
+// intToGo generates an integer expression, such as the n of #n, as a Go int
+func (g *Generator) intToGo(n parser.Expression) string {
+	code := g.exprToGo(n)
+	if t := g.exprType(n); t != nil && t.Kind != analyzer.TypeInteger && t.Kind != analyzer.TypeAny {
+		return fmt.Sprintf("int(%s)", code)
+	}
+	return code
 }
 
 func (g *Generator) generateIf(stmt *parser.IfStatement) {
@@ -1210,7 +6112,7 @@ func (g *Generator) generateIf(stmt *parser.IfStatement) {
 }
 
 func (g *Generator) generateFor(stmt *parser.ForStatement) {
-	varName := g.toGoIdent(stmt.Variable.Value)
+	varName := g.varToGo(stmt.Variable.Value)
 	start := g.exprToGo(stmt.Start)
 	end := g.exprToGo(stmt.End)
 
@@ -1236,6 +6138,76 @@ func (g *Generator) generateFor(stmt *parser.ForStatement) {
 	g.writeLine("}")
 }
 
+func (g *Generator) generateForEach(stmt *parser.ForEachStatement) {
+	valueName := g.toGoIdent(stmt.Value.Value)
+	collection := g.exprToGo(stmt.Collection)
+
+	// Loop variables are scoped to the loop body
+	oldScope := g.currentScope
+	g.currentScope = analyzer.NewScope("foreach", oldScope)
+
+	collType := g.exprType(stmt.Collection)
+	keyType, elemType := analyzer.AnyType, analyzer.AnyType
+	if collType != nil {
+		switch collType.Kind {
+		case analyzer.TypeArray, analyzer.TypeSlice:
+			keyType = analyzer.IntegerType
+			elemType = collType.ElementType
+		case analyzer.TypeMap:
+			keyType = collType.KeyType
+			elemType = collType.ElementType
+		case analyzer.TypeStack, analyzer.TypeQueue, analyzer.TypeSet:
+			// Containers are ranged over a copy of their elements
+			keyType = analyzer.IntegerType
+			elemType = collType.ElementType
+			collection += ".Items()"
+		case analyzer.TypeChannel:
+			elemType = collType.ElementType
+		case analyzer.TypeJSON:
+			keyType = analyzer.StringType
+		}
+	}
+	if elemType == nil {
+		elemType = analyzer.AnyType
+	}
+
+	if collType != nil && collType.Kind == analyzer.TypeChannel {
+		// Ranging over a channel yields only values
+		g.writeLineWithSource(fmt.Sprintf("for %s := range %s {", valueName, collection), stmt.Token.Line)
+	} else if stmt.Key != nil {
+		keyName := g.toGoIdent(stmt.Key.Value)
+		g.currentScope.Define(&analyzer.Symbol{
+			Name: stmt.Key.Value,
+			Kind: analyzer.SymVariable,
+			Type: keyType,
+		})
+		g.writeLineWithSource(fmt.Sprintf("for %s, %s := range %s {", keyName, valueName, collection), stmt.Token.Line)
+		// The index of an array with a lower bound counts from that bound
+		if collType != nil && collType.Kind == analyzer.TypeSlice {
+			switch lower := collType.LowerBound; {
+			case lower > 0:
+				g.writeLine(fmt.Sprintf("\t%s += %d", keyName, lower))
+			case lower < 0:
+				g.writeLine(fmt.Sprintf("\t%s -= %d", keyName, -lower))
+			}
+		}
+	} else {
+		g.writeLineWithSource(fmt.Sprintf("for _, %s := range %s {", valueName, collection), stmt.Token.Line)
+	}
+
+	g.currentScope.Define(&analyzer.Symbol{
+		Name: stmt.Value.Value,
+		Kind: analyzer.SymVariable,
+		Type: elemType,
+	})
+
+	g.indent++
+	g.generateBlockStatement(stmt.Body)
+	g.indent--
+	g.writeLine("}")
+	g.currentScope = oldScope
+}
+
 // isNegativeStep checks if the step expression is a negative value
 func (g *Generator) isNegativeStep(step parser.Expression) bool {
 	if step == nil {
@@ -1254,12 +6226,125 @@ func (g *Generator) isNegativeStep(step parser.Expression) bool {
 		return intLit.Value < 0
 	}
 
-	// Check for negative float literal
-	if floatLit, ok := step.(*parser.FloatLiteral); ok {
-		return floatLit.Value < 0
-	}
+	// Check for negative float literal
+	if floatLit, ok := step.(*parser.FloatLiteral); ok {
+		return floatLit.Value < 0
+	}
+
+	return false
+}
+
+// generateWith emits a WITH block as a Go block. Plain variables are referenced
+// directly; other targets are evaluated once into a temporary (by address for
+// addressable structs so field assignments reach the original).
+func (g *Generator) generateWith(stmt *parser.WithStatement) {
+	target := &withTarget{expr: g.exprToGo(stmt.Target), typ: g.exprType(stmt.Target)}
+	binding := ""
+	if _, ok := stmt.Target.(*parser.Identifier); !ok {
+		g.withCount++
+		value := target.expr
+		if target.typ != nil && target.typ.Kind == analyzer.TypeStruct && g.isAddressable(stmt.Target) {
+			value = "&" + value
+			target.typ = analyzer.NewPointerType(target.typ)
+		}
+		target.expr = fmt.Sprintf("with%d", g.withCount)
+		binding = fmt.Sprintf("%s := %s", target.expr, value)
+	}
+
+	g.writeLine("{")
+	g.indent++
+	g.withTargets = append(g.withTargets, target)
+	body := g.captureOutput(func() { g.generateBlockStatement(stmt.Body) })
+	g.withTargets = g.withTargets[:len(g.withTargets)-1]
+	// Go rejects unused variables, so only bind the temporary when the body uses it
+	if binding != "" && target.used {
+		g.writeLine(binding)
+	}
+	g.output.WriteString(body)
+	g.indent--
+	g.writeLine("}")
+}
+
+// generateLock emits LOCK m ... END LOCK as a block that calls m.Lock()
+// before the body and m.Unlock() after it. The analyzer rejects statements
+// that would jump past the unlock.
+func (g *Generator) generateLock(stmt *parser.LockStatement) {
+	mutex := g.exprToGo(stmt.Mutex)
+	g.writeLine("{")
+	g.indent++
+	g.writeLine(mutex + ".Lock()")
+	g.generateBlockStatement(stmt.Body)
+	g.writeLine(mutex + ".Unlock()")
+	g.indent--
+	g.writeLine("}")
+}
+
+// generateAssert emits ASSERT as a check that panics with a DBasicError
+// giving the file, line and routine of the failed assertion. Release builds
+// never evaluate the condition, but keep it in dead code so that variables
+// only ASSERTs read are still used.
+func (g *Generator) generateAssert(stmt *parser.AssertStatement) {
+	if g.releaseMode {
+		g.writeLineWithSource("if false {", stmt.Token.Line)
+		g.writeLine(fmt.Sprintf("\t_ = %s", g.exprToGo(stmt.Condition)))
+		if stmt.Message != nil {
+			g.writeLine(fmt.Sprintf("\t_ = %s", g.exprToGo(stmt.Message)))
+		}
+		g.writeLine("}")
+		return
+	}
+	g.runtimeFuncs["NewErrorAtFunc"] = true
+	sourceFile, line := g.sourceLocation(stmt.Token.Line)
+	funcName := g.currentFunc
+	if funcName == "" {
+		funcName = "main"
+	}
+	message := fmt.Sprintf("%q", "assertion failed: "+stmt.Condition.String())
+	if stmt.Message != nil {
+		message = fmt.Sprintf("%q + %s", "assertion failed: ", g.exprToGo(stmt.Message))
+	}
+	g.writeLineWithSource(fmt.Sprintf("if !(%s) {", g.exprToGo(stmt.Condition)), stmt.Token.Line)
+	g.writeLine(fmt.Sprintf("\tpanic(NewErrorAtFunc(%q, %d, %q, %s))", sourceFile, line, funcName, message))
+	g.writeLine("}")
+}
+
+// isAddressable reports whether & can be applied to the generated expression
+func (g *Generator) isAddressable(expr parser.Expression) bool {
+	switch e := expr.(type) {
+	case *parser.Identifier, *parser.DereferenceExpression:
+		return true
+	case *parser.MemberExpression:
+		return !g.isExprJSONType(e.Object) && g.propertyOf(e) == nil
+	case *parser.IndexExpression:
+		// Map elements are not addressable in Go
+		t := g.exprType(e.Left)
+		return t != nil && (t.Kind == analyzer.TypeArray || t.Kind == analyzer.TypeSlice)
+	}
+	return false
+}
+
+// captureOutput runs fn and returns what it wrote instead of emitting it
+func (g *Generator) captureOutput(fn func()) string {
+	saved := g.output
+	g.output = strings.Builder{}
+	fn()
+	captured := g.output.String()
+	g.output = saved
+	return captured
+}
 
-	return false
+// generateLoop runs gen to emit a loop. A plain break inside a Go select
+// only leaves the select, so EXIT from a SELECT CHANNEL arm breaks to a
+// label, which is written before the loop once it is known to be needed.
+func (g *Generator) generateLoop(gen func()) {
+	loop := &loopContext{selectDepth: g.selectDepth}
+	g.loops = append(g.loops, loop)
+	code := g.captureOutput(gen)
+	g.loops = g.loops[:len(g.loops)-1]
+	if loop.label != "" {
+		g.output.WriteString(loop.label + ":\n")
+	}
+	g.output.WriteString(code)
 }
 
 func (g *Generator) generateWhile(stmt *parser.WhileStatement) {
@@ -1308,6 +6393,16 @@ func (g *Generator) generateDoLoop(stmt *parser.DoLoopStatement) {
 }
 
 func (g *Generator) generateSelect(stmt *parser.SelectStatement) {
+	for _, caseClause := range stmt.Cases {
+		for _, v := range caseClause.Values {
+			switch v.(type) {
+			case *parser.CaseRange, *parser.CaseIs:
+				g.generateSelectChain(stmt)
+				return
+			}
+		}
+	}
+
 	testExpr := g.exprToGo(stmt.TestExpr)
 	g.writeLine(fmt.Sprintf("switch %s {", testExpr))
 
@@ -1332,7 +6427,125 @@ func (g *Generator) generateSelect(stmt *parser.SelectStatement) {
 	g.writeLine("}")
 }
 
+// generateSelectChannel emits SELECT CHANNEL as a Go select statement
+func (g *Generator) generateSelectChannel(stmt *parser.SelectChannelStatement) {
+	g.writeLine("select {")
+	g.selectDepth++
+	for _, arm := range stmt.Cases {
+		switch {
+		case arm.Receive != nil && arm.Receive.Variable == nil:
+			g.writeLine(fmt.Sprintf("case <-%s:", g.exprToGo(arm.Receive.Channel)))
+		case arm.Receive != nil:
+			g.writeLine(fmt.Sprintf("case %s = <-%s:",
+				g.exprToGo(arm.Receive.Variable), g.exprToGo(arm.Receive.Channel)))
+		case arm.Send != nil:
+			g.writeLine(fmt.Sprintf("case %s <- %s:",
+				g.exprToGo(arm.Send.Channel), g.exprToGo(arm.Send.Value)))
+		default:
+			g.writeLine(fmt.Sprintf("case <-time.After(%s):", g.timeoutToGo(arm.Timeout)))
+		}
+		g.indent++
+		g.generateBlockStatement(arm.Body)
+		g.indent--
+	}
+
+	if stmt.Default != nil {
+		g.writeLine("default:")
+		g.indent++
+		g.generateBlockStatement(stmt.Default)
+		g.indent--
+	}
+	g.selectDepth--
+	g.writeLine("}")
+}
+
+// timeoutToGo converts a CASE TIMEOUT value to a time.Duration. Plain
+// numbers are milliseconds, as for Sleep.
+func (g *Generator) timeoutToGo(expr parser.Expression) string {
+	if t := g.exprType(expr); t != nil && t.Kind == analyzer.TypeDuration {
+		return g.exprToGo(expr)
+	}
+	if lit, ok := expr.(*parser.IntegerLiteral); ok {
+		return fmt.Sprintf("%d * time.Millisecond", lit.Value)
+	}
+	return fmt.Sprintf("time.Duration(%s) * time.Millisecond", g.exprToGo(expr))
+}
+
+// generateSelectChain emits a SELECT that uses ranges or IS comparisons as an
+// if/else chain, since Go switch cases only match by equality
+func (g *Generator) generateSelectChain(stmt *parser.SelectStatement) {
+	test := stmt.TestExpr
+	bound := false
+	if _, ok := test.(*parser.Identifier); !ok {
+		// Evaluate the test expression once
+		g.selectCount++
+		name := fmt.Sprintf("sel%d", g.selectCount)
+		g.writeLine("{")
+		g.indent++
+		g.writeLine(fmt.Sprintf("%s := %s", name, g.exprToGo(test)))
+		test = &parser.Identifier{Token: stmt.Token, Value: name}
+		bound = true
+	}
+
+	for i, caseClause := range stmt.Cases {
+		var conds []string
+		for _, v := range caseClause.Values {
+			conds = append(conds, g.caseConditionToGo(test, v))
+		}
+		cond := strings.Join(conds, " || ")
+		if i == 0 {
+			g.writeLine(fmt.Sprintf("if %s {", cond))
+		} else {
+			g.writeLine(fmt.Sprintf("} else if %s {", cond))
+		}
+		g.indent++
+		g.generateBlockStatement(caseClause.Body)
+		g.indent--
+	}
+
+	if stmt.Default != nil {
+		g.writeLine("} else {")
+		g.indent++
+		g.generateBlockStatement(stmt.Default)
+		g.indent--
+	}
+	g.writeLine("}")
+
+	if bound {
+		g.indent--
+		g.writeLine("}")
+	}
+}
+
+// caseConditionToGo converts one CASE value into a Go condition on test
+func (g *Generator) caseConditionToGo(test parser.Expression, value parser.Expression) string {
+	compare := func(op string, right parser.Expression) string {
+		return g.infixExprToGo(&parser.InfixExpression{Left: test, Operator: op, Right: right})
+	}
+	switch v := value.(type) {
+	case *parser.CaseRange:
+		return fmt.Sprintf("(%s && %s)", compare(">=", v.Low), compare("<=", v.High))
+	case *parser.CaseIs:
+		return compare(v.Operator, v.Value)
+	default:
+		return compare("=", value)
+	}
+}
+
 func (g *Generator) generateReturn(stmt *parser.ReturnStatement) {
+	if g.gosub && len(stmt.Values) == 0 {
+		// Back to the GOSUB
+		g.writeLine("return")
+		return
+	}
+	if g.trap != nil && g.trap.protected {
+		var vals []string
+		for _, v := range stmt.Values {
+			vals = append(vals, g.exprToGo(v))
+		}
+		g.writeLine(g.trapReturn(vals))
+		return
+	}
 	if len(stmt.Values) == 0 {
 		g.writeLine("return")
 		return
@@ -1345,13 +6558,73 @@ func (g *Generator) generateReturn(stmt *parser.ReturnStatement) {
 	g.writeLine(fmt.Sprintf("return %s", strings.Join(vals, ", ")))
 }
 
+// generateCheck returns zero values and the error from the routine when the
+// error is not nil
+func (g *Generator) generateCheck(stmt *parser.CheckStatement) {
+	errName := g.exprToGo(stmt.Err)
+	if _, ok := stmt.Err.(*parser.Identifier); ok {
+		g.writeLine(fmt.Sprintf("if %s != nil {", errName))
+	} else {
+		g.writeLine(fmt.Sprintf("if _err := %s; _err != nil {", errName))
+		errName = "_err"
+	}
+
+	var vals []string
+	for _, rt := range g.results[:len(g.results)-1] {
+		vals = append(vals, g.zeroValueToGo(rt))
+	}
+	vals = append(vals, errName)
+	g.indent++
+	if g.trap != nil && g.trap.protected {
+		g.writeLine(g.trapReturn(vals))
+	} else {
+		g.writeLine("return " + strings.Join(vals, ", "))
+	}
+	g.indent--
+	g.writeLine("}")
+}
+
+// zeroValueToGo returns the Go zero value of a type
+func (g *Generator) zeroValueToGo(spec *parser.TypeSpec) string {
+	goType := g.typeSpecToGo(spec)
+	switch goType {
+	case "int", "int8", "int16", "int32", "int64", "uint8", "uint16", "uint32", "uint64", "float32", "float64", "byte", "rune":
+		return "0"
+	case "string":
+		return `""`
+	case "bool":
+		return "false"
+	case "error", "interface{}", "any":
+		return "nil"
+	}
+	for _, prefix := range []string{"*", "[]", "map[", "chan ", "func("} {
+		if strings.HasPrefix(goType, prefix) {
+			return "nil"
+		}
+	}
+	return fmt.Sprintf("*new(%s)", goType)
+}
+
 func (g *Generator) generateExit(stmt *parser.ExitStatement) {
 	// EXIT FOR, EXIT WHILE, EXIT DO all become break
 	// EXIT SUB, EXIT FUNCTION become return
 	switch strings.ToUpper(stmt.ExitType) {
 	case "FOR", "WHILE", "DO":
+		if n := len(g.loops); n > 0 && g.selectDepth > g.loops[n-1].selectDepth {
+			loop := g.loops[n-1]
+			if loop.label == "" {
+				g.labelCount++
+				loop.label = fmt.Sprintf("loop%d", g.labelCount)
+			}
+			g.writeLine("break " + loop.label)
+			return
+		}
 		g.writeLine("break")
 	case "SUB", "FUNCTION":
+		if g.trap != nil && g.trap.protected {
+			g.writeLine(g.trapReturn(nil))
+			return
+		}
 		g.writeLine("return")
 	}
 }
@@ -1360,6 +6633,17 @@ func (g *Generator) generateGoto(stmt *parser.GotoStatement) {
 	g.writeLine(fmt.Sprintf("goto %s", g.toGoIdent(stmt.Label)))
 }
 
+// generateGosub calls the closure of the subroutine a GOSUB names
+func (g *Generator) generateGosub(stmt *parser.GosubStatement) {
+	for _, sub := range g.subroutines {
+		if strings.EqualFold(sub.Label, stmt.Label) {
+			g.writeLine(gosubName(sub.Label) + "()")
+			return
+		}
+	}
+	g.writeLine(fmt.Sprintf("/* GOSUB %s: no subroutine */", stmt.Label))
+}
+
 func (g *Generator) generateLabel(stmt *parser.LabelStatement) {
 	// Labels need to be at column 0 in Go
 	label := g.toGoIdent(stmt.Name)
@@ -1375,6 +6659,10 @@ func (g *Generator) generateSend(stmt *parser.SendStatement) {
 }
 
 func (g *Generator) generateReceive(stmt *parser.ReceiveStatement) {
+	if stmt.Variable == nil {
+		g.writeLine(fmt.Sprintf("<-%s", g.exprToGo(stmt.Channel)))
+		return
+	}
 	g.writeLine(fmt.Sprintf("%s = <-%s", g.exprToGo(stmt.Variable), g.exprToGo(stmt.Channel)))
 }
 
@@ -1385,13 +6673,20 @@ func (g *Generator) exprToGo(expr parser.Expression) string {
 
 	switch e := expr.(type) {
 	case *parser.Identifier:
-		return g.toGoIdent(e.Value)
+		return g.varToGo(e.Value)
 	case *parser.IntegerLiteral:
 		return fmt.Sprintf("%d", e.Value)
 	case *parser.FloatLiteral:
 		return fmt.Sprintf("%v", e.Value)
 	case *parser.StringLiteral:
 		return fmt.Sprintf("%q", e.Value)
+	case *parser.InterpolatedString:
+		return g.interpolatedStringToGo(e)
+	case *parser.DateTimeLiteral:
+		g.imports["time"] = ""
+		t := e.Value
+		return fmt.Sprintf("time.Date(%d, %d, %d, %d, %d, %d, 0, time.Local)",
+			t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second())
 	case *parser.ByteStringLiteral:
 		return fmt.Sprintf("[]byte(%q)", e.Value)
 	case *parser.BooleanLiteral:
@@ -1405,6 +6700,12 @@ func (g *Generator) exprToGo(expr parser.Expression) string {
 		return g.jsonLiteralToGo(e)
 	case *parser.ArrayLiteral:
 		return g.arrayLiteralToGo(e)
+	case *parser.RangeExpression:
+		g.runtimeFuncs["Range"] = true
+		if e.Step != nil {
+			return fmt.Sprintf("Range(%s, %s, %s)", g.exprToGo(e.Start), g.exprToGo(e.End), g.exprToGo(e.Step))
+		}
+		return fmt.Sprintf("Range(%s, %s)", g.exprToGo(e.Start), g.exprToGo(e.End))
 	case *parser.StructLiteral:
 		return g.structLiteralToGo(e)
 	case *parser.SliceLiteral:
@@ -1415,22 +6716,31 @@ func (g *Generator) exprToGo(expr parser.Expression) string {
 		return g.infixExprToGo(e)
 	case *parser.CallExpression:
 		return g.callExprToGo(e)
+	case *parser.NewExpression:
+		return g.newExprToGo(e)
 	case *parser.IndexExpression:
 		if e.IsSlice {
 			// Slice operation: [start:end], [start:], [:end], [:]
 			start := ""
 			end := ""
 			if e.Index != nil {
-				start = g.exprToGo(e.Index)
+				start = g.arrayIndexToGo(e.Left, e.Index)
 			}
 			if e.End != nil {
-				end = g.exprToGo(e.End)
+				end = g.arrayIndexToGo(e.Left, e.End)
 			}
 			return fmt.Sprintf("%s[%s:%s]", g.exprToGo(e.Left), start, end)
 		}
-		return fmt.Sprintf("%s[%s]", g.exprToGo(e.Left), g.exprToGo(e.Index))
+		return fmt.Sprintf("%s[%s]", g.exprToGo(e.Left), g.arrayIndexToGo(e.Left, e.Index))
 	case *parser.MemberExpression:
 		return g.memberExprToGo(e)
+	case *parser.WithTargetExpression:
+		if len(g.withTargets) == 0 {
+			return "/* . outside WITH */"
+		}
+		target := g.withTargets[len(g.withTargets)-1]
+		target.used = true
+		return target.expr
 	case *parser.AddressOfExpression:
 		return fmt.Sprintf("&%s", g.exprToGo(e.Value))
 	case *parser.DereferenceExpression:
@@ -1462,6 +6772,7 @@ func (g *Generator) jsonLiteralToGo(lit *parser.JSONLiteral) string {
 	for k, v := range lit.Pairs {
 		pairs = append(pairs, fmt.Sprintf("%q: %s", k, g.exprToGo(v)))
 	}
+	sort.Strings(pairs)
 	return fmt.Sprintf("map[string]interface{}{%s}", strings.Join(pairs, ", "))
 }
 
@@ -1528,6 +6839,7 @@ func (g *Generator) structLiteralToGo(lit *parser.StructLiteral) string {
 		goFieldName := g.toGoIdent(k)
 		pairs = append(pairs, fmt.Sprintf("%s: %s", goFieldName, g.exprToGo(v)))
 	}
+	sort.Strings(pairs)
 	return fmt.Sprintf("%s{%s}", typeName, strings.Join(pairs, ", "))
 }
 
@@ -1576,6 +6888,36 @@ func (g *Generator) mapTypeToGo(typeName string) string {
 		return "interface{}"
 	case "ERROR":
 		return "error"
+	case "DATETIME":
+		g.imports["time"] = ""
+		return "time.Time"
+	case "DURATION":
+		g.imports["time"] = ""
+		return "time.Duration"
+	case "MUTEX":
+		g.imports["sync"] = ""
+		return "sync.Mutex"
+	case "CONTEXT":
+		g.imports["context"] = ""
+		return "context.Context"
+	case "CONNECTION":
+		g.imports["net"] = ""
+		return "net.Conn"
+	case "LISTENER":
+		g.imports["net"] = ""
+		return "net.Listener"
+	case "DATABASE":
+		g.imports["database/sql"] = ""
+		return "*sql.DB"
+	case "TRANSACTION":
+		g.imports["database/sql"] = ""
+		return "*sql.Tx"
+	case "KVSTORE":
+		g.runtimeFuncs["KVOpen"] = true
+		return "*kvStore"
+	case "IMAGE":
+		g.imports["image"] = ""
+		return "*image.RGBA"
 	default:
 		return typeName
 	}
@@ -1598,6 +6940,10 @@ func (g *Generator) infixExprToGo(expr *parser.InfixExpression) string {
 	left := g.exprToGo(expr.Left)
 	right := g.exprToGo(expr.Right)
 
+	if code, ok := g.timeInfixToGo(expr, left, right); ok {
+		return code
+	}
+
 	switch expr.Operator {
 	case "=":
 		return fmt.Sprintf("(%s == %s)", left, right)
@@ -1611,18 +6957,209 @@ func (g *Generator) infixExprToGo(expr *parser.InfixExpression) string {
 		return fmt.Sprintf("((%s || %s) && !(%s && %s))", left, right, left, right)
 	case "MOD":
 		return fmt.Sprintf("(%s %% %s)", left, right)
+	case "LIKE":
+		g.runtimeFuncs["Like"] = true
+		return fmt.Sprintf("Like(%s, %s)", left, right)
+	case "IN":
+		return g.inToGo(expr, left, right)
 	case "&":
 		return fmt.Sprintf("(%s + %s)", left, right) // String concatenation
 	case "^":
+		// A power of constants is written as its value, an untyped float
+		// constant, so a CONST can hold it
+		if v, ok := g.symbols.Constant(expr); ok {
+			f, _ := constant.Float64Val(v)
+			lit := strconv.FormatFloat(f, 'g', -1, 64)
+			if !strings.ContainsAny(lit, ".e") {
+				lit += ".0"
+			}
+			return lit
+		}
 		g.imports["math"] = ""
 		return fmt.Sprintf("math.Pow(float64(%s), float64(%s))", left, right)
 	case "\\":
 		return fmt.Sprintf("(%s / %s)", left, right) // Integer division
+	case "??":
+		return g.coalesceToGo(expr.Left, expr.Right)
 	default:
 		return fmt.Sprintf("(%s %s %s)", left, expr.Operator, right)
 	}
 }
 
+// sliceHelperToGo generates a call to the generic IndexOf, Contains,
+// Reverse or Unique. A search value is converted to the element type so
+// that Go can infer the type parameter.
+func (g *Generator) sliceHelperToGo(call *parser.CallExpression, args []string) string {
+	name := map[string]string{"INDEXOF": "IndexOf", "CONTAINS": "Contains", "REVERSE": "Reverse", "UNIQUE": "Unique"}[strings.ToUpper(call.Function.(*parser.Identifier).Value)]
+	g.runtimeFuncs[name] = true
+	t := g.exprType(call.Arguments[0])
+	if len(args) == 2 && t != nil && (t.Kind == analyzer.TypeArray || t.Kind == analyzer.TypeSlice) && t.ElementType.IsNumeric() {
+		args[1] = fmt.Sprintf("%s(%s)", t.ElementType.GoType(), args[1])
+	}
+	return fmt.Sprintf("%s(%s)", name, strings.Join(args, ", "))
+}
+
+// containerOpToGo generates an operation on a STACK, QUEUE or SET as a
+// method call, converting a numeric value to the element type
+func (g *Generator) containerOpToGo(call *parser.CallExpression, t *analyzer.Type, args []string) string {
+	name := call.Function.(*parser.Identifier).Value
+	method := strings.ToUpper(name[:1]) + strings.ToLower(name[1:])
+	if len(args) == 2 && t.ElementType.IsNumeric() {
+		args[1] = fmt.Sprintf("%s(%s)", t.ElementType.GoType(), args[1])
+	}
+	return fmt.Sprintf("%s.%s(%s)", args[0], method, strings.Join(args[1:], ", "))
+}
+
+// collectionFuncToGo generates a call to the generic Map, Filter or Reduce.
+// The initial value of Reduce is converted to the function's result type
+// so that Go can infer the type parameter.
+func (g *Generator) collectionFuncToGo(call *parser.CallExpression, args []string) string {
+	name := map[string]string{"MAP": "Map", "FILTER": "Filter", "REDUCE": "Reduce"}[strings.ToUpper(call.Function.(*parser.Identifier).Value)]
+	g.runtimeFuncs[name] = true
+	if len(args) == 3 {
+		if t := g.exprType(call.Arguments[1]); t != nil && t.Kind == analyzer.TypeFunction && len(t.ReturnTypes) == 1 && t.ReturnTypes[0].IsNumeric() {
+			args[2] = fmt.Sprintf("%s(%s)", t.ReturnTypes[0].GoType(), args[2])
+		}
+	}
+	return fmt.Sprintf("%s(%s)", name, strings.Join(args, ", "))
+}
+
+// inToGo generates x IN collection: a map lookup for maps, otherwise a
+// search of the list of values or the slice
+func (g *Generator) inToGo(expr *parser.InfixExpression, left, right string) string {
+	if lit, ok := expr.Right.(*parser.ArrayLiteral); ok {
+		g.runtimeFuncs["InSlice"] = true
+		args := []string{left}
+		for _, elem := range lit.Elements {
+			args = append(args, g.exprToGo(elem))
+		}
+		return fmt.Sprintf("InSlice(%s)", strings.Join(args, ", "))
+	}
+	if t := g.exprType(expr.Right); t != nil && (t.Kind == analyzer.TypeMap || t.Kind == analyzer.TypeJSON) {
+		g.runtimeFuncs["InMap"] = true
+		return fmt.Sprintf("InMap(%s, %s)", left, right)
+	}
+	g.runtimeFuncs["InSlice"] = true
+	return fmt.Sprintf("InSlice(%s, %s...)", left, right)
+}
+
+// interpolatedStringToGo generates fmt.Sprintf for $"text {expr}"
+func (g *Generator) interpolatedStringToGo(expr *parser.InterpolatedString) string {
+	var format, text strings.Builder
+	var args []string
+	for _, part := range expr.Parts {
+		if lit, ok := part.(*parser.StringLiteral); ok {
+			format.WriteString(strings.ReplaceAll(lit.Value, "%", "%%"))
+			text.WriteString(lit.Value)
+		} else {
+			format.WriteString("%v")
+			args = append(args, g.displayValueToGo(part))
+		}
+	}
+	if len(args) == 0 {
+		return fmt.Sprintf("%q", text.String())
+	}
+	g.imports["fmt"] = ""
+	return fmt.Sprintf("fmt.Sprintf(%q, %s)", format.String(), strings.Join(args, ", "))
+}
+
+// coalesceToGo generates value ?? fallback. Untyped values (JSON members,
+// ANY) are converted to the fallback's type; a numeric fallback of a
+// different type is converted to the value's type.
+func (g *Generator) coalesceToGo(value, fallback parser.Expression) string {
+	left := g.exprToGo(value)
+	right := g.exprToGo(fallback)
+	leftType := g.exprType(value)
+	rightType := g.exprType(fallback)
+
+	if g.isExprJSONType(value) || leftType != nil && (leftType.Kind == analyzer.TypeAny || leftType.Kind == analyzer.TypeExternal) {
+		if rightType == nil {
+			return fmt.Sprintf("IfNullAs(%s, %s)", left, right)
+		}
+		if rightType.Kind == analyzer.TypeAny {
+			return fmt.Sprintf("IfNull[interface{}](%s, %s)", left, right)
+		}
+		return fmt.Sprintf("IfNullAs[%s](%s, %s)", rightType.GoType(), left, right)
+	}
+	if leftType != nil && leftType.IsNumeric() && !g.isExprJSONType(fallback) &&
+		(rightType == nil || rightType.IsNumeric() && rightType.Kind != leftType.Kind) {
+		right = fmt.Sprintf("%s(%s)", leftType.GoType(), right)
+	}
+	return fmt.Sprintf("IfNull(%s, %s)", left, right)
+}
+
+// timeInfixToGo generates DATETIME and DURATION operators. Go's time.Time
+// needs method calls; time.Duration is an integer, so only mixed-type
+// arithmetic needs conversions.
+func (g *Generator) timeInfixToGo(expr *parser.InfixExpression, left, right string) (string, bool) {
+	lt, rt := g.exprType(expr.Left), g.exprType(expr.Right)
+	if lt == nil || rt == nil || analyzer.TimeResultType(expr.Operator, lt, rt) == nil {
+		return "", false
+	}
+	dateTime := lt.Kind == analyzer.TypeDateTime
+	switch expr.Operator {
+	case "=":
+		if dateTime {
+			return fmt.Sprintf("%s.Equal(%s)", left, right), true
+		}
+	case "<>":
+		if dateTime {
+			return fmt.Sprintf("!%s.Equal(%s)", left, right), true
+		}
+	case "<":
+		if dateTime {
+			return fmt.Sprintf("%s.Before(%s)", left, right), true
+		}
+	case ">":
+		if dateTime {
+			return fmt.Sprintf("%s.After(%s)", left, right), true
+		}
+	case "<=":
+		if dateTime {
+			return fmt.Sprintf("!%s.After(%s)", left, right), true
+		}
+	case ">=":
+		if dateTime {
+			return fmt.Sprintf("!%s.Before(%s)", left, right), true
+		}
+	case "+":
+		if dateTime {
+			return fmt.Sprintf("%s.Add(%s)", left, right), true
+		}
+		if rt.Kind == analyzer.TypeDateTime {
+			return fmt.Sprintf("%s.Add(%s)", right, left), true
+		}
+	case "-":
+		if dateTime && rt.Kind == analyzer.TypeDateTime {
+			return fmt.Sprintf("%s.Sub(%s)", left, right), true
+		}
+		if dateTime {
+			return fmt.Sprintf("%s.Add(-%s)", left, right), true
+		}
+	case "*":
+		return fmt.Sprintf("time.Duration(float64(%s) * float64(%s))", left, right), true
+	case "/":
+		if rt.Kind == analyzer.TypeDuration {
+			return fmt.Sprintf("(float64(%s) / float64(%s))", left, right), true
+		}
+		return fmt.Sprintf("time.Duration(float64(%s) / float64(%s))", left, right), true
+	}
+	return "", false
+}
+
+// newExprToGo generates a call to the constructor of a TYPE
+func (g *Generator) newExprToGo(expr *parser.NewExpression) string {
+	typeName := expr.TypeName.Value
+	if t := g.types.Lookup(typeName); t != nil {
+		typeName = t.Name
+	}
+	var args []string
+	for _, arg := range expr.Arguments {
+		args = append(args, g.exprToGo(arg))
+	}
+	return fmt.Sprintf("new_%s(%s)", g.toGoIdent(typeName), strings.Join(args, ", "))
+}
+
 func (g *Generator) callExprToGo(call *parser.CallExpression) string {
 	var args []string
 	for _, arg := range call.Arguments {
@@ -1631,14 +7168,46 @@ func (g *Generator) callExprToGo(call *parser.CallExpression) string {
 
 	funcName := g.exprToGo(call.Function)
 
-	// Handle builtin functions that map directly to Go
-	switch strings.ToUpper(funcName) {
+	if runeFunc := g.runeFunc(call); runeFunc != "" {
+		g.runtimeFuncs[runeFunc] = true
+		return fmt.Sprintf("%s(%s)", runeFunc, strings.Join(args, ", "))
+	}
+
+	// RANGE(start, end[, step]) -> Range(start, end[, step]); the name is
+	// checked before escaping because "range" is a Go keyword
+	if ident, ok := call.Function.(*parser.Identifier); ok && strings.EqualFold(ident.Value, "RANGE") {
+		g.runtimeFuncs["Range"] = true
+		return fmt.Sprintf("Range(%s)", strings.Join(args, ", "))
+	}
+
+	// Handle builtin functions that map directly to Go, unless a SUB or
+	// FUNCTION of the program hides them; a container's operations are
+	// still its own
+	builtin := strings.ToUpper(funcName)
+	if ident, ok := call.Function.(*parser.Identifier); ok && !g.isBuiltinCall(ident) && !isContainerOp(builtin) {
+		builtin = ""
+	}
+	switch builtin {
+	case "COMMAND_STR":
+		// Command$(i) -> Command(i)
+		g.runtimeFuncs["Command"] = true
+		return fmt.Sprintf("Command(%s)", strings.Join(args, ", "))
+	case "EOF":
+		// EOF(n) -> FileEOF(n) for a file opened AS #n
+		if len(call.Arguments) == 1 {
+			g.runtimeFuncs["FileOpen"] = true
+			g.runtimeFuncs["FileEOF"] = true
+			return fmt.Sprintf("FileEOF(%s)", g.intToGo(call.Arguments[0]))
+		}
 	case "APPEND":
 		// APPEND(slice, elem) -> append(slice, elem)
 		return fmt.Sprintf("append(%s)", strings.Join(args, ", "))
 	case "LEN":
 		// LEN can work on strings, slices, maps, etc.
 		if len(args) == 1 {
+			if t := g.exprType(call.Arguments[0]); t != nil && t.IsContainer() {
+				return fmt.Sprintf("%s.Len()", args[0])
+			}
 			return fmt.Sprintf("len(%s)", args[0])
 		}
 	case "CAP":
@@ -1664,6 +7233,29 @@ func (g *Generator) callExprToGo(call *parser.CallExpression) string {
 	case "RECOVER":
 		// RECOVER() -> recover()
 		return "recover()"
+	case "INDEXOF", "CONTAINS", "REVERSE", "UNIQUE":
+		// IndexOf/Contains(slice, value), Reverse/Unique(slice) are generic
+		if len(args) == 1 || len(args) == 2 {
+			return g.sliceHelperToGo(call, args)
+		}
+	case "PUSH", "POP", "PEEK", "ENQUEUE", "DEQUEUE", "ADD", "HAS", "REMOVE":
+		// Methods of a STACK, QUEUE or SET; otherwise a routine of the program
+		if len(call.Arguments) > 0 {
+			if t := g.exprType(call.Arguments[0]); t != nil && t.IsContainer() {
+				return g.containerOpToGo(call, t, args)
+			}
+		}
+	case "MAP_", "FILTER", "REDUCE":
+		// Map/Filter(slice, fn) and Reduce(slice, fn, initial) are generic;
+		// Map arrives escaped because "map" is a Go keyword
+		if len(args) == 2 || len(args) == 3 {
+			return g.collectionFuncToGo(call, args)
+		}
+	case "IFNULL":
+		// IFNULL(value, fallback) behaves like value ?? fallback
+		if len(call.Arguments) == 2 {
+			return g.coalesceToGo(call.Arguments[0], call.Arguments[1])
+		}
 	case "NEW":
 		// NEW(Type) -> new(Type)
 		return fmt.Sprintf("new(%s)", strings.Join(args, ", "))
@@ -1691,40 +7283,46 @@ func (g *Generator) callExprToGo(call *parser.CallExpression) string {
 	case "NEWERROR":
 		// NewError(message) -> dbasic.NewErrorAtFunc(file, line, func, message)
 		g.runtimeFuncs["NewErrorAtFunc"] = true
-		sourceFile := g.sourceFile
-		if sourceFile == "" {
-			sourceFile = "unknown"
-		}
+		sourceFile, line := g.sourceLocation(call.Token.Line)
 		funcName := g.currentFunc
 		if funcName == "" {
 			funcName = "main"
 		}
-		return fmt.Sprintf("NewErrorAtFunc(%q, %d, %q, %s)", sourceFile, call.Token.Line, funcName, strings.Join(args, ", "))
+		return fmt.Sprintf("NewErrorAtFunc(%q, %d, %q, %s)", sourceFile, line, funcName, strings.Join(args, ", "))
 	case "ERRORF":
 		// Errorf(format, args...) -> dbasic.ErrorfFunc(file, line, func, format, args...)
 		g.runtimeFuncs["ErrorfFunc"] = true
-		sourceFile := g.sourceFile
-		if sourceFile == "" {
-			sourceFile = "unknown"
-		}
+		sourceFile, line := g.sourceLocation(call.Token.Line)
 		funcName := g.currentFunc
 		if funcName == "" {
 			funcName = "main"
 		}
-		return fmt.Sprintf("ErrorfFunc(%q, %d, %q, %s)", sourceFile, call.Token.Line, funcName, strings.Join(args, ", "))
+		return fmt.Sprintf("ErrorfFunc(%q, %d, %q, %s)", sourceFile, line, funcName, strings.Join(args, ", "))
+	case "ASSERTEQUAL", "ASSERTTRUE":
+		// AssertEqual(got, want, "file:line", message...): the location
+		// goes after the checked values
+		g.runtimeFuncs["AssertEqual"] = true
+		sourceFile, line := g.sourceLocation(call.Token.Line)
+		name, n := "AssertEqual", 2
+		if strings.ToUpper(funcName) == "ASSERTTRUE" {
+			name, n = "AssertTrue", 1
+		}
+		if len(args) < n {
+			break
+		}
+		where := fmt.Sprintf("%q", fmt.Sprintf("%s:%d", sourceFile, line))
+		args = append(args[:n:n], append([]string{where}, args[n:]...)...)
+		return fmt.Sprintf("%s(%s)", name, strings.Join(args, ", "))
 	case "WRAPERROR":
 		// WrapError(err, message) -> dbasic.WrapError(err, file, line, func, message)
 		g.runtimeFuncs["WrapError"] = true
-		sourceFile := g.sourceFile
-		if sourceFile == "" {
-			sourceFile = "unknown"
-		}
+		sourceFile, line := g.sourceLocation(call.Token.Line)
 		funcName := g.currentFunc
 		if funcName == "" {
 			funcName = "main"
 		}
 		if len(args) >= 2 {
-			return fmt.Sprintf("WrapError(%s, %q, %d, %q, %s)", args[0], sourceFile, call.Token.Line, funcName, strings.Join(args[1:], ", "))
+			return fmt.Sprintf("WrapError(%s, %q, %d, %q, %s)", args[0], sourceFile, line, funcName, strings.Join(args[1:], ", "))
 		}
 		return fmt.Sprintf("WrapError(%s)", strings.Join(args, ", "))
 	}
@@ -1732,6 +7330,63 @@ func (g *Generator) callExprToGo(call *parser.CallExpression) string {
 	return fmt.Sprintf("%s(%s)", funcName, strings.Join(args, ", "))
 }
 
+// runeFuncs maps the string functions that OPTION UNICODE switches to
+// their rune-based versions
+var runeFuncs = map[string]string{
+	"LEN":   "LenR",
+	"LEFT":  "LeftR",
+	"RIGHT": "RightR",
+	"MID":   "MidR",
+	"INSTR": "InstrR",
+	"ASC":   "AscR",
+}
+
+// runeFunc returns the rune-based function to call instead of a builtin
+// string function under OPTION UNICODE, or "" to leave the call alone
+// isBuiltinCall reports whether a call names a builtin, and not a SUB,
+// FUNCTION or variable of the program that hides it
+func (g *Generator) isBuiltinCall(ident *parser.Identifier) bool {
+	sym := g.currentScope.Resolve(ident.Value)
+	return sym == nil || sym.Node == nil
+}
+
+// isContainerOp reports whether name is an operation of a STACK, QUEUE or SET
+func isContainerOp(name string) bool {
+	switch strings.ToUpper(name) {
+	case "PUSH", "POP", "PEEK", "ENQUEUE", "DEQUEUE", "ADD", "HAS", "REMOVE":
+		return true
+	}
+	return false
+}
+
+func (g *Generator) runeFunc(call *parser.CallExpression) string {
+	if !g.unicode {
+		return ""
+	}
+	ident, ok := call.Function.(*parser.Identifier)
+	if !ok {
+		return ""
+	}
+	runeFunc, ok := runeFuncs[strings.ToUpper(ident.Value)]
+	if !ok {
+		return ""
+	}
+	if sym := g.currentScope.Resolve(ident.Value); sym != nil && sym.Node != nil {
+		// A SUB or FUNCTION of the program with the same name
+		return ""
+	}
+	if runeFunc == "LenR" {
+		// Len also counts the elements of arrays, slices and maps
+		if len(call.Arguments) != 1 {
+			return ""
+		}
+		if t := g.exprType(call.Arguments[0]); t == nil || t.Kind != analyzer.TypeString {
+			return ""
+		}
+	}
+	return runeFunc
+}
+
 func (g *Generator) typeSpecToGo(spec *parser.TypeSpec) string {
 	if spec == nil {
 		return "interface{}"
@@ -1745,6 +7400,25 @@ func (g *Generator) typeSpecToGo(spec *parser.TypeSpec) string {
 		return "chan " + g.typeSpecToGo(spec.ElementType)
 	}
 
+	if spec.IsMap {
+		return "map[" + g.typeSpecToGo(spec.KeyType) + "]" + g.typeSpecToGo(spec.ElementType)
+	}
+
+	if spec.IsContainer {
+		// STACK -> *Stack[T], QUEUE -> *Queue[T], SET -> *Set[T]
+		name := spec.Name[:1] + strings.ToLower(spec.Name[1:])
+		g.runtimeFuncs[name] = true
+		return "*" + name + "[" + g.typeSpecToGo(spec.ElementType) + "]"
+	}
+
+	if spec.IsFunction {
+		var params []string
+		for _, pt := range spec.ParamTypes {
+			params = append(params, g.typeSpecToGo(pt))
+		}
+		return strings.TrimSpace("func(" + strings.Join(params, ", ") + ") " + g.generateReturnTypes(spec.ReturnTypes))
+	}
+
 	if spec.IsArray {
 		// Slice type (dynamic array)
 		if spec.ArraySize == nil {
@@ -1775,6 +7449,36 @@ func (g *Generator) typeSpecToGo(spec *parser.TypeSpec) string {
 		return "interface{}"
 	case "ERROR":
 		return "error"
+	case "DATETIME":
+		g.imports["time"] = ""
+		return "time.Time"
+	case "DURATION":
+		g.imports["time"] = ""
+		return "time.Duration"
+	case "MUTEX":
+		g.imports["sync"] = ""
+		return "sync.Mutex"
+	case "CONTEXT":
+		g.imports["context"] = ""
+		return "context.Context"
+	case "CONNECTION":
+		g.imports["net"] = ""
+		return "net.Conn"
+	case "LISTENER":
+		g.imports["net"] = ""
+		return "net.Listener"
+	case "DATABASE":
+		g.imports["database/sql"] = ""
+		return "*sql.DB"
+	case "TRANSACTION":
+		g.imports["database/sql"] = ""
+		return "*sql.Tx"
+	case "KVSTORE":
+		g.runtimeFuncs["KVOpen"] = true
+		return "*kvStore"
+	case "IMAGE":
+		g.imports["image"] = ""
+		return "*image.RGBA"
 	default:
 		// Check for custom type
 		if g.types != nil {
@@ -1793,6 +7497,10 @@ func (g *Generator) typeFromTypeSpec(spec *parser.TypeSpec) *analyzer.Type {
 		return analyzer.AnyType
 	}
 
+	if width, ok := g.symbols.FixedStringWidth(spec); ok {
+		return analyzer.NewFixedStringType(width)
+	}
+
 	if spec.IsPointer {
 		return analyzer.NewPointerType(g.typeFromTypeSpec(spec.ElementType))
 	}
@@ -1801,6 +7509,28 @@ func (g *Generator) typeFromTypeSpec(spec *parser.TypeSpec) *analyzer.Type {
 		return analyzer.NewChannelType(g.typeFromTypeSpec(spec.ElementType))
 	}
 
+	if spec.IsMap {
+		return analyzer.NewMapType(g.typeFromTypeSpec(spec.KeyType), g.typeFromTypeSpec(spec.ElementType))
+	}
+
+	if spec.IsContainer {
+		return analyzer.NewContainerType(spec.Name, g.typeFromTypeSpec(spec.ElementType))
+	}
+
+	if spec.IsFunction {
+		var paramTypes, retTypes []*analyzer.Type
+		for _, pt := range spec.ParamTypes {
+			paramTypes = append(paramTypes, g.typeFromTypeSpec(pt))
+		}
+		for _, rt := range spec.ReturnTypes {
+			retTypes = append(retTypes, g.typeFromTypeSpec(rt))
+		}
+		if spec.Name == "SUB" {
+			return analyzer.NewSubType(paramTypes)
+		}
+		return analyzer.NewFunctionType(paramTypes, retTypes)
+	}
+
 	if spec.IsArray {
 		elemType := g.typeFromTypeSpec(spec.ElementType)
 		if spec.ArraySize == nil {
@@ -1825,6 +7555,83 @@ func (g *Generator) typeFromTypeSpec(spec *parser.TypeSpec) *analyzer.Type {
 	return analyzer.AnyType
 }
 
+// exprType returns the type of an expression when it can be determined
+// from the current scope, or nil otherwise
+func (g *Generator) exprType(expr parser.Expression) *analyzer.Type {
+	switch e := expr.(type) {
+	case *parser.Identifier:
+		if sym := g.currentScope.Resolve(e.Value); sym != nil {
+			return sym.Type
+		}
+	case *parser.IndexExpression:
+		t := g.exprType(e.Left)
+		if t == nil {
+			return nil
+		}
+		if e.IsSlice {
+			if t.LowerBound != 0 {
+				// Slicing yields an ordinary 0-based slice
+				return analyzer.NewSliceType(t.ElementType)
+			}
+			return t
+		}
+		if t.Kind == analyzer.TypeArray || t.Kind == analyzer.TypeSlice || t.Kind == analyzer.TypeMap {
+			return t.ElementType
+		}
+	case *parser.MemberExpression:
+		if sym := g.moduleMember(e); sym != nil {
+			return sym.Type
+		}
+		t := g.exprType(e.Object)
+		if t != nil && t.Kind == analyzer.TypePointer {
+			t = t.ElementType
+		}
+		if t != nil && t.Kind == analyzer.TypeStruct {
+			if m, _ := g.types.Member(t, e.Member.Value); m != nil {
+				return m.Type
+			}
+		}
+	case *parser.DereferenceExpression:
+		if t := g.exprType(e.Value); t != nil && t.Kind == analyzer.TypePointer {
+			return t.ElementType
+		}
+	case *parser.WithTargetExpression:
+		if len(g.withTargets) > 0 {
+			return g.withTargets[len(g.withTargets)-1].typ
+		}
+	case *parser.ArrayLiteral, *parser.SliceLiteral:
+		return analyzer.NewSliceType(analyzer.AnyType)
+	case *parser.RangeExpression:
+		return analyzer.NewSliceType(analyzer.IntegerType)
+	case *parser.JSONLiteral:
+		return analyzer.JSONType
+	case *parser.IntegerLiteral:
+		return analyzer.IntegerType
+	case *parser.FloatLiteral:
+		return analyzer.DoubleType
+	case *parser.StringLiteral:
+		return analyzer.StringType
+	case *parser.BooleanLiteral:
+		return analyzer.BooleanType
+	case *parser.DateTimeLiteral:
+		return analyzer.DateTimeType
+	case *parser.NewExpression:
+		return g.types.Lookup(e.TypeName.Value)
+	case *parser.CallExpression:
+		if t := g.exprType(e.Function); t != nil && len(t.ReturnTypes) > 0 {
+			return t.ReturnTypes[0]
+		}
+	case *parser.InfixExpression:
+		if lt, rt := g.exprType(e.Left), g.exprType(e.Right); lt != nil && rt != nil {
+			if (e.Operator == "&" || e.Operator == "+") && lt.Kind == analyzer.TypeString && rt.Kind == analyzer.TypeString {
+				return analyzer.StringType
+			}
+			return analyzer.TimeResultType(e.Operator, lt, rt)
+		}
+	}
+	return nil
+}
+
 // isExprJSONType checks if an expression resolves to a JSON type
 func (g *Generator) isExprJSONType(expr parser.Expression) bool {
 	switch e := expr.(type) {
@@ -1834,22 +7641,37 @@ func (g *Generator) isExprJSONType(expr parser.Expression) bool {
 			return sym.Type.Kind == analyzer.TypeJSON
 		}
 	case *parser.MemberExpression:
+		if sym := g.moduleMember(e); sym != nil {
+			return sym.Type != nil && sym.Type.Kind == analyzer.TypeJSON
+		}
 		// If we're accessing a member of something, check the object
 		return g.isExprJSONType(e.Object)
 	case *parser.IndexExpression:
 		return g.isExprJSONType(e.Left)
 	case *parser.JSONLiteral:
 		return true
+	case *parser.WithTargetExpression:
+		if t := g.exprType(e); t != nil {
+			return t.Kind == analyzer.TypeJSON
+		}
 	}
 	return false
 }
 
 // memberExprToGo generates Go code for a member expression, handling JSON specially
 func (g *Generator) memberExprToGo(expr *parser.MemberExpression) string {
+	if sym := g.moduleMember(expr); sym != nil {
+		// Module members are package-level names prefixed with the module
+		return sym.GoName
+	}
 	if g.isExprJSONType(expr.Object) {
 		// JSON access uses map bracket notation
 		return fmt.Sprintf("%s[%q]", g.exprToGo(expr.Object), expr.Member.Value)
 	}
+	if prop := g.propertyOf(expr); prop != nil {
+		// Reading a property calls its GET accessor
+		return fmt.Sprintf("%s.get_%s()", g.exprToGo(expr.Object), prop.Name)
+	}
 	// Regular struct/package access uses dot notation
 	return fmt.Sprintf("%s.%s", g.exprToGo(expr.Object), g.toGoIdent(expr.Member.Value))
 }
@@ -1896,6 +7718,10 @@ func (g *Generator) toGoIdent(name string) string {
 		return replacement
 	}
 
+	// A name$ with the classic string suffix
+	if strings.HasSuffix(name, "$") {
+		return strings.TrimSuffix(name, "$") + "_str"
+	}
 	return name
 }
 
@@ -0,0 +1,225 @@
+// Package doc builds API documentation from the comments above SUB,
+// FUNCTION, TYPE, INTERFACE and MODULE declarations.
+package doc
+
+import (
+	"fmt"
+	"html"
+	"strings"
+
+	"github.com/zditech/dbasic/pkg/parser"
+)
+
+// Entry is one documented declaration
+type Entry struct {
+	Kind      string // SUB, FUNCTION, TYPE, INTERFACE or MODULE
+	Name      string
+	Signature string // The declaration without its body
+	Doc       string // The comment above it
+	File      string
+	Line      int
+	Members   []*Entry // A module's routines or a type's methods
+}
+
+// Extract returns the declarations in program, in source order. locate
+// maps a line of the program to its original file and line; when file is
+// not empty, only declarations from that file are returned. Methods are
+// listed as members of their type.
+func Extract(program *parser.Program, locate func(line int) (string, int), file string) []*Entry {
+	var entries, methods []*Entry
+	types := make(map[string]*Entry)
+
+	for _, stmt := range program.Statements {
+		e := entry(stmt, locate)
+		if e == nil || (file != "" && e.File != file) {
+			continue
+		}
+		switch {
+		case e.Kind == "TYPE":
+			types[strings.ToUpper(e.Name)] = e
+		case strings.Contains(e.Name, "."):
+			methods = append(methods, e)
+			continue
+		}
+		entries = append(entries, e)
+	}
+
+	for _, m := range methods {
+		receiver := m.Name[:strings.Index(m.Name, ".")]
+		if t := types[strings.ToUpper(receiver)]; t != nil {
+			// Receiver types are stored in upper case
+			m.Name = t.Name + m.Name[len(receiver):]
+			t.Members = append(t.Members, m)
+		} else {
+			entries = append(entries, m)
+		}
+	}
+	return entries
+}
+
+// entry describes a declaration, or returns nil for other statements.
+// Methods are named Type.Method and module routines Module.Routine.
+func entry(stmt parser.Statement, locate func(line int) (string, int)) *Entry {
+	var e *Entry
+	switch s := stmt.(type) {
+	case *parser.SubStatement:
+		e = &Entry{Kind: "SUB", Name: s.Name.Value, Signature: header(s.String()), Doc: s.Doc, Line: s.Token.Line}
+	case *parser.FunctionStatement:
+		e = &Entry{Kind: "FUNCTION", Name: s.Name.Value, Signature: header(s.String()), Doc: s.Doc, Line: s.Token.Line}
+	case *parser.MethodStatement:
+		kind := strings.ToUpper(s.Token.Literal)
+		receiver := s.ReceiverType
+		if receiver.IsPointer && receiver.ElementType != nil {
+			receiver = receiver.ElementType
+		}
+		// String writes FUNCTION whichever keyword was used
+		signature := kind + strings.TrimPrefix(header(s.String()), "FUNCTION")
+		e = &Entry{Kind: kind, Name: receiver.Name + "." + s.Name.Value, Signature: signature, Doc: s.Doc, Line: s.Token.Line}
+	case *parser.TypeStatement:
+		e = &Entry{Kind: "TYPE", Name: s.Name.Value, Signature: typeSignature(s), Doc: s.Doc, Line: s.Token.Line}
+	case *parser.InterfaceStatement:
+		e = &Entry{Kind: "INTERFACE", Name: s.Name.Value, Signature: s.String(), Doc: s.Doc, Line: s.Token.Line}
+	case *parser.ModuleStatement:
+		e = &Entry{Kind: "MODULE", Name: s.Name.Value, Signature: "MODULE " + s.Name.Value, Doc: s.Doc, Line: s.Token.Line}
+		for _, member := range s.Body {
+			if m := entry(member, locate); m != nil {
+				m.Name = s.Name.Value + "." + m.Name
+				e.Members = append(e.Members, m)
+			}
+		}
+	default:
+		return nil
+	}
+	if locate != nil {
+		e.File, e.Line = locate(e.Line)
+	}
+	e.Doc = ownComment(e.Doc)
+	return e
+}
+
+// ownComment drops the part of a doc comment above an INCLUDE: the
+// preprocessor replaces INCLUDE lines with marker comments, which would
+// otherwise join the comments around them into one block
+func ownComment(doc string) string {
+	lines := strings.Split(doc, "\n")
+	for i := len(lines) - 1; i >= 0; i-- {
+		if strings.HasPrefix(lines[i], ">>> BEGIN INCLUDE:") || strings.HasPrefix(lines[i], "<<< END INCLUDE:") {
+			return strings.Join(lines[i+1:], "\n")
+		}
+	}
+	return doc
+}
+
+// header returns the first line of a declaration
+func header(decl string) string {
+	if i := strings.Index(decl, "\n"); i >= 0 {
+		return decl[:i]
+	}
+	return decl
+}
+
+// typeSignature shows a type's fields, constructor and properties, without
+// their bodies
+func typeSignature(t *parser.TypeStatement) string {
+	var sb strings.Builder
+	sb.WriteString("TYPE " + t.Name.Value)
+	if t.Implements != "" {
+		sb.WriteString(" IMPLEMENTS " + t.Implements)
+	}
+	sb.WriteString("\n")
+	for _, e := range t.Embedded {
+		sb.WriteString("    EMBED " + e.TypeName + "\n")
+	}
+	for _, f := range t.Fields {
+		sb.WriteString("    " + f.String() + "\n")
+	}
+	if t.Constructor != nil {
+		sb.WriteString("    " + header(t.Constructor.String()) + "\n")
+	}
+	for _, p := range t.Properties {
+		sb.WriteString("    " + header(p.String()) + "\n")
+	}
+	sb.WriteString("END TYPE")
+	return sb.String()
+}
+
+// anchor returns the id of an entry's heading
+func anchor(e *Entry) string {
+	return strings.ToLower(strings.ReplaceAll(e.Name, ".", "-"))
+}
+
+// Markdown renders entries as a Markdown document
+func Markdown(title string, entries []*Entry) string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "# %s\n\n", title)
+	if len(entries) == 0 {
+		sb.WriteString("No declarations.\n")
+		return sb.String()
+	}
+
+	for _, e := range entries {
+		fmt.Fprintf(&sb, "- [%s](#%s)\n", e.Name, anchor(e))
+	}
+	for _, e := range entries {
+		writeMarkdown(&sb, e, "##")
+	}
+	return sb.String()
+}
+
+func writeMarkdown(sb *strings.Builder, e *Entry, level string) {
+	fmt.Fprintf(sb, "\n%s <a id=\"%s\"></a>%s %s\n\n", level, anchor(e), e.Kind, e.Name)
+	fmt.Fprintf(sb, "```basic\n%s\n```\n\n", e.Signature)
+	if e.Doc != "" {
+		sb.WriteString(e.Doc + "\n\n")
+	}
+	if e.File != "" {
+		fmt.Fprintf(sb, "*%s:%d*\n", e.File, e.Line)
+	}
+	for _, m := range e.Members {
+		writeMarkdown(sb, m, level+"#")
+	}
+}
+
+// HTML renders entries as a standalone HTML page
+func HTML(title string, entries []*Entry) string {
+	var sb strings.Builder
+	sb.WriteString("<!DOCTYPE html>\n<html>\n<head>\n<meta charset=\"utf-8\">\n")
+	fmt.Fprintf(&sb, "<title>%s</title>\n", html.EscapeString(title))
+	sb.WriteString("<style>\n" +
+		"body { font-family: sans-serif; max-width: 50em; margin: 2em auto; padding: 0 1em; }\n" +
+		"pre { background: #f4f4f4; padding: 0.5em; overflow-x: auto; }\n" +
+		".loc { color: #777; font-size: small; }\n" +
+		"</style>\n</head>\n<body>\n")
+	fmt.Fprintf(&sb, "<h1>%s</h1>\n", html.EscapeString(title))
+	if len(entries) == 0 {
+		sb.WriteString("<p>No declarations.</p>\n")
+	} else {
+		sb.WriteString("<ul>\n")
+		for _, e := range entries {
+			fmt.Fprintf(&sb, "<li><a href=\"#%s\">%s</a></li>\n", anchor(e), html.EscapeString(e.Name))
+		}
+		sb.WriteString("</ul>\n")
+		for _, e := range entries {
+			writeHTML(&sb, e, 2)
+		}
+	}
+	sb.WriteString("</body>\n</html>\n")
+	return sb.String()
+}
+
+func writeHTML(sb *strings.Builder, e *Entry, level int) {
+	fmt.Fprintf(sb, "<h%d id=\"%s\">%s %s</h%d>\n", level, anchor(e), e.Kind, html.EscapeString(e.Name), level)
+	fmt.Fprintf(sb, "<pre>%s</pre>\n", html.EscapeString(e.Signature))
+	if e.Doc != "" {
+		// A blank comment line separates paragraphs
+		for _, para := range strings.Split(e.Doc, "\n\n") {
+			fmt.Fprintf(sb, "<p>%s</p>\n", html.EscapeString(para))
+		}
+	}
+	if e.File != "" {
+		fmt.Fprintf(sb, "<p class=\"loc\">%s:%d</p>\n", html.EscapeString(e.File), e.Line)
+	}
+	for _, m := range e.Members {
+		writeHTML(sb, m, level+1)
+	}
+}
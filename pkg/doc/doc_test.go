@@ -0,0 +1,129 @@
+package doc
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/zditech/dbasic/pkg/lexer"
+	"github.com/zditech/dbasic/pkg/parser"
+)
+
+func parse(t *testing.T, input string) *parser.Program {
+	t.Helper()
+	p := parser.New(lexer.New(input))
+	program := p.ParseProgram()
+	if len(p.Errors()) > 0 {
+		t.Fatalf("parse errors: %v", p.Errors())
+	}
+	return program
+}
+
+func TestExtract(t *testing.T) {
+	program := parse(t, `' >>> BEGIN INCLUDE: other.dbas (from lib.dbas:1)
+' <<< END INCLUDE: other.dbas
+' Geometry helpers
+MODULE Geo
+    ' Area of a circle
+    FUNCTION Area(r AS DOUBLE) AS DOUBLE
+        RETURN 3.14 * r * r
+    END FUNCTION
+END MODULE
+
+' Move shifts p
+SUB (BYREF p AS Point) Move(dx AS DOUBLE)
+    p.X = p.X + dx
+END SUB
+
+' A point
+TYPE Point
+    DIM X AS DOUBLE
+    PROPERTY GET Twice() AS DOUBLE
+        RETURN X * 2
+    END PROPERTY
+END TYPE
+
+SUB Main()
+END SUB
+`)
+	// Pretend everything from line 24 on came from another file
+	locate := func(line int) (string, int) {
+		if line >= 24 {
+			return "main.dbas", line - 23
+		}
+		return "lib.dbas", line
+	}
+
+	entries := Extract(program, locate, "lib.dbas")
+	if len(entries) != 2 {
+		t.Fatalf("expected 2 entries, got %d", len(entries))
+	}
+
+	geo := entries[0]
+	if geo.Kind != "MODULE" || geo.Doc != "Geometry helpers" || geo.File != "lib.dbas" || geo.Line != 4 {
+		t.Errorf("unexpected module entry %+v", geo)
+	}
+	if len(geo.Members) != 1 || geo.Members[0].Name != "Geo.Area" || geo.Members[0].Doc != "Area of a circle" {
+		t.Errorf("unexpected module members %+v", geo.Members)
+	}
+
+	point := entries[1]
+	want := "TYPE Point\n    DIM X AS DOUBLE\n    PROPERTY GET Twice() AS DOUBLE\nEND TYPE"
+	if point.Signature != want {
+		t.Errorf("expected type signature %q, got %q", want, point.Signature)
+	}
+	if len(point.Members) != 1 {
+		t.Fatalf("expected the method to be listed with its type, got %+v", point.Members)
+	}
+	move := point.Members[0]
+	if move.Kind != "SUB" || move.Name != "Point.Move" || move.Doc != "Move shifts p" {
+		t.Errorf("unexpected method entry %+v", move)
+	}
+	if !strings.HasPrefix(move.Signature, "SUB (BYREF p AS ") {
+		t.Errorf("expected a SUB signature, got %q", move.Signature)
+	}
+
+	if all := Extract(program, nil, ""); len(all) != 3 || all[2].Name != "Main" {
+		t.Errorf("expected all three declarations without a file filter, got %d", len(all))
+	}
+}
+
+func TestRender(t *testing.T) {
+	entries := []*Entry{{
+		Kind:      "FUNCTION",
+		Name:      "Less",
+		Signature: "FUNCTION Less(a AS INTEGER, b AS INTEGER) AS BOOLEAN",
+		Doc:       "Less reports whether a < b.\n\nIt is a test.",
+		File:      "lib.dbas",
+		Line:      3,
+	}}
+
+	md := Markdown("lib", entries)
+	for _, want := range []string{
+		"# lib\n",
+		"- [Less](#less)\n",
+		"## <a id=\"less\"></a>FUNCTION Less\n",
+		"```basic\nFUNCTION Less(a AS INTEGER, b AS INTEGER) AS BOOLEAN\n```\n",
+		"Less reports whether a < b.\n\nIt is a test.\n",
+		"*lib.dbas:3*\n",
+	} {
+		if !strings.Contains(md, want) {
+			t.Errorf("Markdown is missing %q:\n%s", want, md)
+		}
+	}
+
+	page := HTML("lib", entries)
+	for _, want := range []string{
+		"<title>lib</title>",
+		"<h2 id=\"less\">FUNCTION Less</h2>",
+		"<p>Less reports whether a &lt; b.</p>\n<p>It is a test.</p>",
+		"<p class=\"loc\">lib.dbas:3</p>",
+	} {
+		if !strings.Contains(page, want) {
+			t.Errorf("HTML is missing %q:\n%s", want, page)
+		}
+	}
+
+	if md := Markdown("empty", nil); !strings.Contains(md, "No declarations.") {
+		t.Errorf("expected a note for no declarations, got %q", md)
+	}
+}
@@ -0,0 +1,157 @@
+package errors
+
+import (
+	"regexp"
+	"strings"
+)
+
+// Code is a stable identifier for a kind of diagnostic. Messages change
+// wording from release to release; codes do not, so tools can filter on
+// them and documentation can refer to them.
+type Code struct {
+	ID      string // DB followed by four digits
+	Phase   string // "preprocessor", "parse", "semantic", "warning" or "codegen"
+	Title   string
+	Retired bool           // No longer reported; kept so the ID is never reused
+	pattern *regexp.Regexp // Matches the preprocessor messages with this code
+}
+
+// The IDs of the codes. The parser, the analyzer and the command line tool
+// give each message its code where they report it, so rewording a message
+// never changes its code.
+const (
+	PreprocessorError = "DB0000"
+	IncludeNotFound   = "DB0001"
+	CircularInclude   = "DB0002"
+
+	SyntaxError          = "DB1000"
+	UnexpectedToken      = "DB1001"
+	InvalidLiteral       = "DB1002"
+	MisplacedDeclaration = "DB1003"
+	MissingToken         = "DB1004"
+
+	SemanticError             = "DB2000"
+	UndefinedName             = "DB2001"
+	UnknownType               = "DB2002"
+	DuplicateDefinition       = "DB2003"
+	TypeMismatch              = "DB2004"
+	WrongCount                = "DB2005"
+	UnknownMember             = "DB2006"
+	ConditionNotBoolean       = "DB2007"
+	InvalidOperand            = "DB2008"
+	NotAllowedHere            = "DB2009"
+	NotImplemented            = "DB2010"
+	PropertyNotAccessible     = "DB2011"
+	InvalidChannel            = "DB2012"
+	InvalidMutex              = "DB2013"
+	InvalidJump               = "DB2014"
+	InvalidReturn             = "DB2015"
+	InvalidConstant           = "DB2016"
+	InvalidDeclaration        = "DB2017"
+	NotAValue                 = "DB2018"
+	InvalidMultipleAssignment = "DB2019"
+	ReceiverChangeLost        = "DB2020"
+	UnknownOption             = "DB2021"
+
+	Warning                = "DB3000"
+	NoMain                 = "DB3001"
+	ConditionAssigns       = "DB3002"
+	PrintNeverAssigned     = "DB3003"
+	SpawnDiscardsResult    = "DB3004"
+	InputWithoutConversion = "DB3005"
+	MaybeUnassigned        = "DB3006"
+
+	BuildError  = "DB4000"
+	CannotEmbed = "DB4001"
+)
+
+// Codes lists every code, in order. The first code of each phase is the
+// general one, for messages that no other code of the phase fits.
+// Codes are never renumbered or reused; retired codes stay in the list.
+var Codes = []Code{
+	{ID: PreprocessorError, Phase: "preprocessor", Title: "preprocessor error"},
+	{ID: IncludeNotFound, Phase: "preprocessor", Title: "include file not found",
+		pattern: regexp.MustCompile(`^(cannot read file|cannot resolve path|error reading) `)},
+	{ID: CircularInclude, Phase: "preprocessor", Title: "circular include",
+		pattern: regexp.MustCompile(`circular include`)},
+
+	{ID: SyntaxError, Phase: "parse", Title: "syntax error"},
+	{ID: UnexpectedToken, Phase: "parse", Title: "unexpected token"},
+	{ID: InvalidLiteral, Phase: "parse", Title: "invalid literal"},
+	{ID: MisplacedDeclaration, Phase: "parse", Title: "misplaced declaration"},
+	{ID: MissingToken, Phase: "parse", Title: "missing token"},
+
+	{ID: SemanticError, Phase: "semantic", Title: "semantic error"},
+	{ID: UndefinedName, Phase: "semantic", Title: "undefined name"},
+	{ID: UnknownType, Phase: "semantic", Title: "unknown type"},
+	{ID: DuplicateDefinition, Phase: "semantic", Title: "duplicate definition"},
+	{ID: TypeMismatch, Phase: "semantic", Title: "type mismatch"},
+	{ID: WrongCount, Phase: "semantic", Title: "wrong number of arguments"},
+	{ID: UnknownMember, Phase: "semantic", Title: "unknown member"},
+	{ID: ConditionNotBoolean, Phase: "semantic", Title: "condition is not BOOLEAN"},
+	{ID: InvalidOperand, Phase: "semantic", Title: "invalid operand"},
+	{ID: NotAllowedHere, Phase: "semantic", Title: "statement not allowed here"},
+	{ID: NotImplemented, Phase: "semantic", Title: "interface not implemented"},
+	{ID: PropertyNotAccessible, Phase: "semantic", Title: "property not accessible"},
+	{ID: InvalidChannel, Phase: "semantic", Title: "invalid channel operation"},
+	{ID: InvalidMutex, Phase: "semantic", Title: "invalid MUTEX use"},
+	{ID: InvalidJump, Phase: "semantic", Title: "invalid jump"},
+	{ID: InvalidReturn, Phase: "semantic", Title: "missing or wrong RETURN"},
+	{ID: InvalidConstant, Phase: "semantic", Title: "invalid constant expression"},
+	{ID: InvalidDeclaration, Phase: "semantic", Title: "invalid declaration"},
+	{ID: NotAValue, Phase: "semantic", Title: "name is not a value"},
+	{ID: InvalidMultipleAssignment, Phase: "semantic", Title: "invalid multiple assignment"},
+	{ID: ReceiverChangeLost, Phase: "semantic", Title: "change to a receiver copy is lost"},
+	{ID: UnknownOption, Phase: "semantic", Title: "unknown OPTION"},
+
+	{ID: Warning, Phase: "warning", Title: "warning"},
+	{ID: NoMain, Phase: "warning", Title: "no Main SUB"},
+	{ID: ConditionAssigns, Phase: "warning", Title: "condition reads like an assignment"},
+	{ID: PrintNeverAssigned, Phase: "warning", Title: "PRINT of a variable that is never assigned"},
+	{ID: SpawnDiscardsResult, Phase: "warning", Title: "SPAWN discards a result"},
+	{ID: InputWithoutConversion, Phase: "warning", Title: "INPUT without conversion", Retired: true},
+	{ID: MaybeUnassigned, Phase: "warning", Title: "variable may be read before it is assigned"},
+
+	{ID: BuildError, Phase: "codegen", Title: "build error"},
+	{ID: CannotEmbed, Phase: "codegen", Title: "cannot embed file or directory"},
+}
+
+// phases maps the phase names used by the command line tool to those in
+// error messages
+var phases = map[string]string{
+	"parser":   "parse",
+	"analyzer": "semantic",
+}
+
+// CodeFor returns the code of a message from the given phase, or "" for
+// an unknown phase. Only preprocessor messages, which come from file
+// system errors, are matched against patterns; the other phases report
+// their codes with their messages, and get the phase's general code here.
+func CodeFor(phase, message string) string {
+	if p, ok := phases[phase]; ok {
+		phase = p
+	}
+	fallback := ""
+	for _, c := range Codes {
+		if c.Phase != phase || c.Retired {
+			continue
+		}
+		if fallback == "" {
+			fallback = c.ID
+		} else if c.pattern != nil && c.pattern.MatchString(message) {
+			return c.ID
+		}
+	}
+	return fallback
+}
+
+// LookupCode returns the code with the given ID, or nil if there is none.
+// The ID is not case sensitive.
+func LookupCode(id string) *Code {
+	for i := range Codes {
+		if strings.EqualFold(Codes[i].ID, id) {
+			return &Codes[i]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,77 @@
+package errors
+
+import (
+	"regexp"
+	"testing"
+)
+
+func TestCodesAreOrderedAndUnique(t *testing.T) {
+	idRe := regexp.MustCompile(`^DB\d{4}$`)
+	seen := make(map[string]bool)
+	prev := ""
+	for _, c := range Codes {
+		if !idRe.MatchString(c.ID) {
+			t.Errorf("malformed code %q", c.ID)
+		}
+		if seen[c.ID] {
+			t.Errorf("duplicate code %s", c.ID)
+		}
+		if c.ID <= prev {
+			t.Errorf("code %s is out of order after %s", c.ID, prev)
+		}
+		if c.Title == "" {
+			t.Errorf("code %s has no title", c.ID)
+		}
+		seen[c.ID] = true
+		prev = c.ID
+	}
+}
+
+func TestCodeFor(t *testing.T) {
+	tests := []struct {
+		phase, message, code string
+	}{
+		{"preprocessor", "cannot read file 'lib.dbas': no such file", IncludeNotFound},
+		{"preprocessor", "circular include detected: /src/a.dbas", CircularInclude},
+		{"preprocessor", "no source files", PreprocessorError},
+		{"analyzer", "undefined: y", SemanticError},
+		{"parser", "expected THEN, got NEWLINE instead", SyntaxError},
+		{"warning", "INPUT n reads INTEGER input without conversion", Warning},
+		{"codegen", "cannot embed logo.png: no such file or directory", BuildError},
+		{"linker", "anything", ""},
+	}
+	for _, tt := range tests {
+		if code := CodeFor(tt.phase, tt.message); code != tt.code {
+			t.Errorf("CodeFor(%q, %q) = %q, expected %q", tt.phase, tt.message, code, tt.code)
+		}
+	}
+}
+
+func TestLookupCode(t *testing.T) {
+	if c := LookupCode("db2013"); c == nil || c.Title != "invalid MUTEX use" {
+		t.Errorf("expected DB2013 to be found, got %v", c)
+	}
+	if c := LookupCode(InputWithoutConversion); c == nil || !c.Retired {
+		t.Errorf("expected DB3005 to be kept as a retired code, got %v", c)
+	}
+	if c := LookupCode("DB9999"); c != nil {
+		t.Errorf("expected no code DB9999, got %v", c)
+	}
+}
+
+func TestParseReadsCode(t *testing.T) {
+	text := "semantic error[DB2013] at line 4: cannot assign to MUTEX mu\n  4 | mu = other\n  hint: lock it instead\n"
+	e := Parse(text)
+	if e == nil {
+		t.Fatal("expected the error to parse")
+	}
+	if e.Code != InvalidMutex || e.Line != 4 || e.Message != "cannot assign to MUTEX mu" {
+		t.Errorf("unexpected error: %+v", e)
+	}
+	if e.Source != "mu = other" || e.Hint != "lock it instead" {
+		t.Errorf("unexpected source or hint: %+v", e)
+	}
+	if got := e.Error(); got != text {
+		t.Errorf("expected %q to be written back unchanged, got %q", text, got)
+	}
+}
@@ -2,12 +2,16 @@ package errors
 
 import (
 	"fmt"
+	"regexp"
+	"strconv"
 	"strings"
 )
 
 // CompileError represents a compilation error with source context
 type CompileError struct {
 	Phase    string // "lexer", "parser", "analyzer"
+	Code     string // Stable identifier, such as DB2001; see Codes
+	File     string // Set when the error is not in the main file
 	Line     int
 	Column   int
 	Message  string
@@ -20,11 +24,20 @@ func (e *CompileError) Error() string {
 
 	// Main error message with location
 	if e.Line > 0 {
-		sb.WriteString(fmt.Sprintf("%s error at line %d", e.Phase, e.Line))
+		sb.WriteString(fmt.Sprintf("%s error", e.Phase))
+		if e.Code != "" {
+			sb.WriteString(fmt.Sprintf("[%s]", e.Code))
+		}
+		if e.File != "" {
+			sb.WriteString(fmt.Sprintf(" in %s", e.File))
+		}
+		sb.WriteString(fmt.Sprintf(" at line %d", e.Line))
 		if e.Column > 0 {
 			sb.WriteString(fmt.Sprintf(", column %d", e.Column))
 		}
 		sb.WriteString(": ")
+	} else if e.Code != "" {
+		sb.WriteString(fmt.Sprintf("%s error[%s]: ", e.Phase, e.Code))
 	} else {
 		sb.WriteString(fmt.Sprintf("%s error: ", e.Phase))
 	}
@@ -51,6 +64,36 @@ func (e *CompileError) Error() string {
 	return sb.String()
 }
 
+// Parse reads back an error message in the form Error writes, as the
+// parser and analyzer report them. It returns nil for other messages.
+// The code is looked up from the message when the text has none.
+func Parse(text string) *CompileError {
+	lines := strings.Split(strings.TrimRight(text, "\n"), "\n")
+	m := headerRe.FindStringSubmatch(lines[0])
+	if m == nil {
+		return nil
+	}
+
+	e := &CompileError{Phase: m[1], Code: m[2], File: m[3], Message: m[6]}
+	e.Line, _ = strconv.Atoi(m[4])
+	e.Column, _ = strconv.Atoi(m[5])
+	if e.Code == "" {
+		e.Code = CodeFor(e.Phase, e.Message)
+	}
+	for _, l := range lines[1:] {
+		if hint := strings.TrimSpace(l); strings.HasPrefix(hint, "hint: ") {
+			e.Hint = strings.TrimPrefix(hint, "hint: ")
+		} else if prefix := fmt.Sprintf("  %d | ", e.Line); e.Line > 0 && strings.HasPrefix(l, prefix) {
+			e.Source = strings.TrimPrefix(l, prefix)
+		}
+	}
+	return e
+}
+
+// headerRe matches the first line of an error: phase, code, file, line,
+// column and message
+var headerRe = regexp.MustCompile(`^(\w+) error(?:\[(\w+)\])?(?: in (.+?))?(?: at line (\d+)(?:, column (\d+))?)?: (.*)$`)
+
 // SourceContext holds the source code for error reporting
 type SourceContext struct {
 	lines []string
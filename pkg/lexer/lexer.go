@@ -14,6 +14,7 @@ type Lexer struct {
 	line         int  // current line number
 	column       int  // current column number
 	lines        []string // source lines for error reporting
+	prev         TokenType // type of the token returned last
 }
 
 // New creates a new Lexer for the given input
@@ -69,6 +70,28 @@ func (l *Lexer) peekChar() byte {
 
 // NextToken returns the next token from the input
 func (l *Lexer) NextToken() Token {
+	tok := l.nextToken()
+	if tok.Type == TOKEN_IDENT && endsOperand(l.prev) {
+		if op, ok := OperatorWords[strings.ToUpper(tok.Literal)]; ok {
+			tok.Type = op
+		}
+	}
+	l.prev = tok.Type
+	return tok
+}
+
+// endsOperand reports whether a token of type t can end an operand, so that
+// an operator word after it is the operator
+func endsOperand(t TokenType) bool {
+	switch t {
+	case TOKEN_IDENT, TOKEN_INT, TOKEN_FLOAT, TOKEN_STRING, TOKEN_INTERP_STR, TOKEN_DATE,
+		TOKEN_BYTE_STRING, TOKEN_TRUE, TOKEN_FALSE, TOKEN_NIL, TOKEN_RPAREN, TOKEN_RBRACKET, TOKEN_RBRACE:
+		return true
+	}
+	return false
+}
+
+func (l *Lexer) nextToken() Token {
 	var tok Token
 
 	l.skipWhitespace()
@@ -99,6 +122,14 @@ func (l *Lexer) NextToken() Token {
 		tok = l.newToken(TOKEN_CARET, l.ch)
 	case '@':
 		tok = l.newToken(TOKEN_AT, l.ch)
+	case '?':
+		if l.peekChar() == '?' {
+			ch := l.ch
+			l.readChar()
+			tok = Token{Type: TOKEN_COALESCE, Literal: string(ch) + string(l.ch), Line: l.line, Column: l.column}
+		} else {
+			tok = l.newToken(TOKEN_ILLEGAL, l.ch)
+		}
 	case '&':
 		tok = l.newToken(TOKEN_AMPERSAND, l.ch)
 	case '<':
@@ -147,11 +178,43 @@ func (l *Lexer) NextToken() Token {
 		}
 		tok = l.newToken(TOKEN_DOT, l.ch)
 	case '"':
+		if strings.HasPrefix(l.input[l.position:], `"""`) {
+			// Multi-line string """..."""
+			literal, ok := l.readMultilineString()
+			if !ok {
+				tok.Type = TOKEN_ILLEGAL
+				tok.Literal = `"""`
+				return tok
+			}
+			tok.Type = TOKEN_STRING
+			tok.Literal = literal
+			return tok
+		}
 		tok.Type = TOKEN_STRING
 		tok.Literal = l.readString()
-		tok.Line = l.line
-		tok.Column = l.column
 		return tok
+	case '#':
+		// File number (#1, #f) or date/time literal; an unclosed # is left
+		// as an illegal token
+		if l.isFileNumber() {
+			tok = l.newToken(TOKEN_HASH, l.ch)
+		} else if end := strings.IndexAny(l.input[l.readPosition:], "#\n"); end >= 0 && l.input[l.readPosition+end] == '#' {
+			tok.Type = TOKEN_DATE
+			tok.Literal = strings.TrimSpace(l.input[l.readPosition : l.readPosition+end])
+			for i := 0; i <= end; i++ {
+				l.readChar()
+			}
+		} else {
+			tok = l.newToken(TOKEN_ILLEGAL, l.ch)
+		}
+	case '$':
+		if l.peekChar() == '"' {
+			l.readChar() // skip $
+			tok.Type = TOKEN_INTERP_STR
+			tok.Literal = l.readInterpolatedString()
+			return tok
+		}
+		tok = l.newToken(TOKEN_ILLEGAL, l.ch)
 	case '\'':
 		// Comment - read until end of line
 		tok.Type = TOKEN_COMMENT
@@ -169,14 +232,10 @@ func (l *Lexer) NextToken() Token {
 				l.readChar() // skip B
 				tok.Type = TOKEN_BYTE_STRING
 				tok.Literal = l.readString()
-				tok.Line = l.line
-				tok.Column = l.column
 				return tok
 			}
 			tok.Literal = l.readIdentifier()
 			tok.Type = LookupIdent(strings.ToUpper(tok.Literal))
-			tok.Line = l.line
-			tok.Column = l.column
 			return tok
 		} else if isDigit(l.ch) {
 			tok.Literal = l.readNumber()
@@ -185,8 +244,6 @@ func (l *Lexer) NextToken() Token {
 			} else {
 				tok.Type = TOKEN_INT
 			}
-			tok.Line = l.line
-			tok.Column = l.column
 			return tok
 		} else {
 			tok = l.newToken(TOKEN_ILLEGAL, l.ch)
@@ -250,12 +307,16 @@ func (l *Lexer) skipWhitespace() {
 	}
 }
 
-// readIdentifier reads an identifier
+// readIdentifier reads an identifier, which may end in the classic $
+// suffix of string names such as Command$
 func (l *Lexer) readIdentifier() string {
 	position := l.position
 	for isLetter(l.ch) || isDigit(l.ch) || l.ch == '_' {
 		l.readChar()
 	}
+	if l.ch == '$' && l.peekChar() != '"' {
+		l.readChar()
+	}
 	return l.input[position:l.position]
 }
 
@@ -303,29 +364,128 @@ func (l *Lexer) readString() string {
 
 	for l.ch != '"' && l.ch != 0 {
 		if l.ch == '\\' {
+			l.readEscape(&sb)
+		} else {
+			sb.WriteByte(l.ch)
+		}
+		l.readChar()
+	}
+
+	l.readChar() // skip closing quote
+	return sb.String()
+}
+
+// readMultilineString reads a """...""" literal, returning false if it is
+// not closed. The text is raw: escape sequences are not processed.
+func (l *Lexer) readMultilineString() (string, bool) {
+	l.readChar() // skip opening quotes
+	l.readChar()
+	l.readChar()
+
+	start := l.position
+	for l.ch != 0 && !strings.HasPrefix(l.input[l.position:], `"""`) {
+		l.readChar()
+	}
+	if l.ch == 0 {
+		return "", false
+	}
+	text := l.input[start:l.position]
+
+	l.readChar() // skip closing quotes
+	l.readChar()
+	l.readChar()
+	return dedent(text), true
+}
+
+// dedent trims a multi-line string body. A line break right after the
+// opening quotes is dropped. When the closing quotes sit on their own line,
+// the indentation before them is removed from every line, along with the
+// final line break, so the block can be indented with the surrounding code.
+func dedent(text string) string {
+	text = strings.ReplaceAll(text, "\r\n", "\n")
+	text = strings.TrimPrefix(text, "\n")
+
+	lines := strings.Split(text, "\n")
+	indent := lines[len(lines)-1]
+	if strings.TrimLeft(indent, " \t") != "" {
+		return text
+	}
+	lines = lines[:len(lines)-1]
+	for i, line := range lines {
+		lines[i] = strings.TrimPrefix(line, indent)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// readEscape reads the character after a backslash and writes the escaped value
+func (l *Lexer) readEscape(sb *strings.Builder) {
+	l.readChar()
+	switch l.ch {
+	case 'n':
+		sb.WriteByte('\n')
+	case 't':
+		sb.WriteByte('\t')
+	case 'r':
+		sb.WriteByte('\r')
+	case '"':
+		sb.WriteByte('"')
+	case '\\':
+		sb.WriteByte('\\')
+	default:
+		sb.WriteByte('\\')
+		sb.WriteByte(l.ch)
+	}
+}
+
+// readInterpolatedString reads the body of a $"..." string. Escapes in the
+// text are processed; {expressions} are kept verbatim (including any string
+// literals inside them) for the parser to split out. An interpolated string
+// ends at the end of the line, so an unclosed { is reported on its own line.
+func (l *Lexer) readInterpolatedString() string {
+	var sb strings.Builder
+	l.readChar() // skip opening quote
+
+	depth := 0
+	for l.ch != 0 && l.ch != '\n' && (depth > 0 || l.ch != '"') {
+		switch {
+		case depth > 0 && l.ch == '"':
+			// String literal inside an embedded expression
+			sb.WriteByte(l.ch)
 			l.readChar()
-			switch l.ch {
-			case 'n':
-				sb.WriteByte('\n')
-			case 't':
-				sb.WriteByte('\t')
-			case 'r':
-				sb.WriteByte('\r')
-			case '"':
-				sb.WriteByte('"')
-			case '\\':
-				sb.WriteByte('\\')
-			default:
-				sb.WriteByte('\\')
+			for l.ch != '"' && l.ch != 0 && l.ch != '\n' {
+				if l.ch == '\\' {
+					sb.WriteByte(l.ch)
+					l.readChar()
+				}
 				sb.WriteByte(l.ch)
+				l.readChar()
 			}
-		} else {
+			if l.ch != '"' {
+				return sb.String()
+			}
+			sb.WriteByte(l.ch)
+		case depth == 0 && (l.ch == '{' || l.ch == '}') && l.peekChar() == l.ch:
+			// {{ and }} are literal braces
+			sb.WriteByte(l.ch)
+			l.readChar()
+			sb.WriteByte(l.ch)
+		case l.ch == '{':
+			depth++
+			sb.WriteByte(l.ch)
+		case l.ch == '}' && depth > 0:
+			depth--
+			sb.WriteByte(l.ch)
+		case depth == 0 && l.ch == '\\':
+			l.readEscape(&sb)
+		default:
 			sb.WriteByte(l.ch)
 		}
 		l.readChar()
 	}
 
-	l.readChar() // skip closing quote
+	if l.ch == '"' {
+		l.readChar() // skip closing quote
+	}
 	return sb.String()
 }
 
@@ -339,6 +499,27 @@ func (l *Lexer) readComment() string {
 }
 
 // isLetter checks if a character is a letter
+// isFileNumber reports whether the # at the current position starts a file
+// number: a number or name that ends the statement, is followed by a comma,
+// or has no closing # after it as a date literal would
+func (l *Lexer) isFileNumber() bool {
+	i := l.readPosition
+	for i < len(l.input) && (isLetter(l.input[i]) || isDigit(l.input[i])) {
+		i++
+	}
+	if i == l.readPosition {
+		return false
+	}
+	for i < len(l.input) && (l.input[i] == ' ' || l.input[i] == '\t') {
+		i++
+	}
+	if i == len(l.input) || strings.IndexByte(",\r\n'", l.input[i]) >= 0 {
+		return true
+	}
+	end := strings.IndexAny(l.input[i:], "#\n")
+	return end < 0 || l.input[i+end] != '#'
+}
+
 func isLetter(ch byte) bool {
 	return unicode.IsLetter(rune(ch)) || ch == '_'
 }
@@ -5,7 +5,7 @@ import (
 )
 
 func TestNextToken_Operators(t *testing.T) {
-	input := `+ - * / \ ^ @ & = <> < > <= >= ->`
+	input := `+ - * / \ ^ @ & = <> < > <= >= -> ??`
 
 	tests := []struct {
 		expectedType    TokenType
@@ -26,6 +26,7 @@ func TestNextToken_Operators(t *testing.T) {
 		{TOKEN_LTE, "<="},
 		{TOKEN_GTE, ">="},
 		{TOKEN_ARROW, "->"},
+		{TOKEN_COALESCE, "??"},
 		{TOKEN_EOF, ""},
 	}
 
@@ -168,8 +169,59 @@ INTEGER LONG SINGLE DOUBLE STRING BOOLEAN JSON POINTER CHAN OF`
 	}
 }
 
+func TestNextToken_OperatorWords(t *testing.T) {
+	input := `x IN list
+name LIKE "a*"
+DIM in AS INTEGER
+PRINT like; Len(s) IN t`
+
+	tests := []struct {
+		expectedType    TokenType
+		expectedLiteral string
+	}{
+		{TOKEN_IDENT, "x"},
+		{TOKEN_IN, "IN"},
+		{TOKEN_IDENT, "list"},
+		{TOKEN_NEWLINE, "\n"},
+		{TOKEN_IDENT, "name"},
+		{TOKEN_LIKE, "LIKE"},
+		{TOKEN_STRING, "a*"},
+		{TOKEN_NEWLINE, "\n"},
+		{TOKEN_DIM, "DIM"},
+		{TOKEN_IDENT, "in"},
+		{TOKEN_AS, "AS"},
+		{TOKEN_INTEGER, "INTEGER"},
+		{TOKEN_NEWLINE, "\n"},
+		{TOKEN_PRINT, "PRINT"},
+		{TOKEN_IDENT, "like"},
+		{TOKEN_SEMICOLON, ";"},
+		{TOKEN_IDENT, "Len"},
+		{TOKEN_LPAREN, "("},
+		{TOKEN_IDENT, "s"},
+		{TOKEN_RPAREN, ")"},
+		{TOKEN_IN, "IN"},
+		{TOKEN_IDENT, "t"},
+		{TOKEN_EOF, ""},
+	}
+
+	l := New(input)
+
+	for i, tt := range tests {
+		tok := l.NextToken()
+
+		if tok.Type != tt.expectedType {
+			t.Errorf("tests[%d] - tokentype wrong. expected=%q, got=%q",
+				i, tt.expectedType, tok.Type)
+		}
+		if tok.Literal != tt.expectedLiteral {
+			t.Errorf("tests[%d] - literal wrong. expected=%q, got=%q",
+				i, tt.expectedLiteral, tok.Literal)
+		}
+	}
+}
+
 func TestNextToken_Identifiers(t *testing.T) {
-	input := `myVar another_var var123 _private`
+	input := `myVar another_var var123 _private Command$(1) name$`
 
 	tests := []struct {
 		expectedType    TokenType
@@ -179,6 +231,11 @@ func TestNextToken_Identifiers(t *testing.T) {
 		{TOKEN_IDENT, "another_var"},
 		{TOKEN_IDENT, "var123"},
 		{TOKEN_IDENT, "_private"},
+		{TOKEN_IDENT, "Command$"},
+		{TOKEN_LPAREN, "("},
+		{TOKEN_INT, "1"},
+		{TOKEN_RPAREN, ")"},
+		{TOKEN_IDENT, "name$"},
 		{TOKEN_EOF, ""},
 	}
 
@@ -264,6 +321,154 @@ func TestNextToken_Strings(t *testing.T) {
 	}
 }
 
+func TestNextToken_InterpolatedStrings(t *testing.T) {
+	input := `$"Hi {name}\n" $"{Upper("a}b")} {{x}}" $"{open
+PRINT`
+
+	tests := []struct {
+		expectedType    TokenType
+		expectedLiteral string
+	}{
+		{TOKEN_INTERP_STR, "Hi {name}\n"},
+		{TOKEN_INTERP_STR, `{Upper("a}b")} {{x}}`},
+		{TOKEN_INTERP_STR, "{open"},
+		{TOKEN_NEWLINE, "\n"},
+		{TOKEN_PRINT, "PRINT"},
+		{TOKEN_EOF, ""},
+	}
+
+	l := New(input)
+
+	for i, tt := range tests {
+		tok := l.NextToken()
+
+		if tok.Type != tt.expectedType {
+			t.Errorf("tests[%d] - tokentype wrong. expected=%q, got=%q",
+				i, tt.expectedType, tok.Type)
+		}
+
+		if tok.Literal != tt.expectedLiteral {
+			t.Errorf("tests[%d] - literal wrong. expected=%q, got=%q",
+				i, tt.expectedLiteral, tok.Literal)
+		}
+	}
+}
+
+func TestNextToken_MultilineStrings(t *testing.T) {
+	input := "x = \"\"\"\n    SELECT *\n      FROM t\n    \"\"\"\nPRINT \"\"\"a \\n \"b\" c\"\"\"\nPRINT \"\"\"open"
+
+	tests := []struct {
+		expectedType    TokenType
+		expectedLiteral string
+		expectedLine    int // 0 skips the check
+	}{
+		{TOKEN_IDENT, "x", 1},
+		{TOKEN_ASSIGN, "=", 1},
+		{TOKEN_STRING, "SELECT *\n  FROM t", 1},
+		{TOKEN_NEWLINE, "\n", 0},
+		{TOKEN_PRINT, "PRINT", 5},
+		{TOKEN_STRING, "a \\n \"b\" c", 5},
+		{TOKEN_NEWLINE, "\n", 0},
+		{TOKEN_PRINT, "PRINT", 6},
+		{TOKEN_ILLEGAL, `"""`, 6},
+		{TOKEN_EOF, "", 6},
+	}
+
+	l := New(input)
+
+	for i, tt := range tests {
+		tok := l.NextToken()
+
+		if tok.Type != tt.expectedType {
+			t.Errorf("tests[%d] - tokentype wrong. expected=%q, got=%q",
+				i, tt.expectedType, tok.Type)
+		}
+
+		if tok.Literal != tt.expectedLiteral {
+			t.Errorf("tests[%d] - literal wrong. expected=%q, got=%q",
+				i, tt.expectedLiteral, tok.Literal)
+		}
+
+		if tt.expectedLine != 0 && tok.Line != tt.expectedLine {
+			t.Errorf("tests[%d] - line wrong. expected=%d, got=%d",
+				i, tt.expectedLine, tok.Line)
+		}
+	}
+}
+
+func TestNextToken_DateLiterals(t *testing.T) {
+	input := "#2024-01-15# # 2024-01-15 09:30 # # open\nx"
+
+	tests := []struct {
+		expectedType    TokenType
+		expectedLiteral string
+	}{
+		{TOKEN_DATE, "2024-01-15"},
+		{TOKEN_DATE, "2024-01-15 09:30"},
+		{TOKEN_ILLEGAL, "#"},
+		{TOKEN_IDENT, "open"},
+		{TOKEN_NEWLINE, "\n"},
+		{TOKEN_IDENT, "x"},
+		{TOKEN_EOF, ""},
+	}
+
+	l := New(input)
+
+	for i, tt := range tests {
+		tok := l.NextToken()
+
+		if tok.Type != tt.expectedType {
+			t.Errorf("tests[%d] - tokentype wrong. expected=%q, got=%q",
+				i, tt.expectedType, tok.Type)
+		}
+
+		if tok.Literal != tt.expectedLiteral {
+			t.Errorf("tests[%d] - literal wrong. expected=%q, got=%q",
+				i, tt.expectedLiteral, tok.Literal)
+		}
+	}
+}
+
+func TestNextToken_FileNumbers(t *testing.T) {
+	input := "PRINT #1, \"a#b\"\nCLOSE #f\nd = #12:30#"
+
+	tests := []struct {
+		expectedType    TokenType
+		expectedLiteral string
+	}{
+		{TOKEN_PRINT, "PRINT"},
+		{TOKEN_HASH, "#"},
+		{TOKEN_INT, "1"},
+		{TOKEN_COMMA, ","},
+		{TOKEN_STRING, "a#b"},
+		{TOKEN_NEWLINE, "\n"},
+		{TOKEN_IDENT, "CLOSE"},
+		{TOKEN_HASH, "#"},
+		{TOKEN_IDENT, "f"},
+		{TOKEN_NEWLINE, "\n"},
+		{TOKEN_IDENT, "d"},
+		{TOKEN_ASSIGN, "="},
+		{TOKEN_DATE, "12:30"},
+		{TOKEN_EOF, ""},
+	}
+
+	l := New(input)
+
+	for i, tt := range tests {
+		tok := l.NextToken()
+
+		if tok.Type != tt.expectedType {
+			t.Errorf("tests[%d] - tokentype wrong. expected=%q, got=%q",
+				i, tt.expectedType, tok.Type)
+		}
+
+		if tok.Literal != tt.expectedLiteral {
+			t.Errorf("tests[%d] - literal wrong. expected=%q, got=%q",
+				i, tt.expectedLiteral, tok.Literal)
+		}
+	}
+}
+
 func TestNextToken_Comments(t *testing.T) {
 	input := `DIM x AS INTEGER ' This is a comment
 PRINT x`
@@ -328,6 +533,12 @@ PRINT x`
 	if tok.Line != 3 {
 		t.Errorf("expected line 3, got %d", tok.Line)
 	}
+
+	// A token at the end of a line keeps its own line
+	tok = l.NextToken() // x
+	if tok.Line != 3 || tok.Column != 7 {
+		t.Errorf("expected line 3 column 7, got line %d column %d", tok.Line, tok.Column)
+	}
 }
 
 func TestNextToken_CaseInsensitiveKeywords(t *testing.T) {
@@ -17,7 +17,9 @@ const (
 	TOKEN_INT         // integer literal
 	TOKEN_FLOAT       // float literal
 	TOKEN_STRING      // string literal
+	TOKEN_INTERP_STR  // interpolated string literal $"..."
 	TOKEN_BYTE_STRING // byte string literal B"..."
+	TOKEN_DATE        // date/time literal #2024-01-15#
 	TOKEN_LABEL       // label (identifier followed by :)
 
 	// Operators
@@ -37,6 +39,7 @@ const (
 	TOKEN_LTE        // <=
 	TOKEN_GTE        // >=
 	TOKEN_ARROW      // ->
+	TOKEN_COALESCE   // ??
 
 	// Delimiters
 	TOKEN_LPAREN     // (
@@ -49,6 +52,7 @@ const (
 	TOKEN_COLON      // :
 	TOKEN_SEMICOLON  // ;
 	TOKEN_DOT        // .
+	TOKEN_HASH       // # (file number, as in PRINT #1)
 
 	// Keywords - Declarations
 	TOKEN_DIM
@@ -84,6 +88,7 @@ const (
 	TOKEN_FOR
 	TOKEN_STEP
 	TOKEN_NEXT
+	TOKEN_IN
 	TOKEN_WHILE
 	TOKEN_WEND
 	TOKEN_DO
@@ -109,6 +114,7 @@ const (
 	TOKEN_NOT
 	TOKEN_XOR
 	TOKEN_MOD
+	TOKEN_LIKE
 
 	// Keywords - Boolean/Nil
 	TOKEN_TRUE
@@ -144,7 +150,9 @@ var tokenNames = map[TokenType]string{
 	TOKEN_INT:         "INT",
 	TOKEN_FLOAT:       "FLOAT",
 	TOKEN_STRING:      "STRING",
+	TOKEN_INTERP_STR:  "INTERP_STR",
 	TOKEN_BYTE_STRING: "BYTE_STRING",
+	TOKEN_DATE:        "DATE",
 	TOKEN_LABEL:       "LABEL",
 	TOKEN_PLUS:        "+",
 	TOKEN_MINUS:       "-",
@@ -162,6 +170,7 @@ var tokenNames = map[TokenType]string{
 	TOKEN_LTE:         "<=",
 	TOKEN_GTE:         ">=",
 	TOKEN_ARROW:       "->",
+	TOKEN_COALESCE:    "??",
 	TOKEN_LPAREN:      "(",
 	TOKEN_RPAREN:      ")",
 	TOKEN_LBRACKET:    "[",
@@ -172,6 +181,7 @@ var tokenNames = map[TokenType]string{
 	TOKEN_COLON:       ":",
 	TOKEN_SEMICOLON:   ";",
 	TOKEN_DOT:         ".",
+	TOKEN_HASH:        "#",
 	TOKEN_DIM:         "DIM",
 	TOKEN_AS:          "AS",
 	TOKEN_LET:         "LET",
@@ -201,6 +211,7 @@ var tokenNames = map[TokenType]string{
 	TOKEN_FOR:         "FOR",
 	TOKEN_STEP:        "STEP",
 	TOKEN_NEXT:        "NEXT",
+	TOKEN_IN:          "IN",
 	TOKEN_WHILE:       "WHILE",
 	TOKEN_WEND:        "WEND",
 	TOKEN_DO:          "DO",
@@ -222,6 +233,7 @@ var tokenNames = map[TokenType]string{
 	TOKEN_NOT:         "NOT",
 	TOKEN_XOR:         "XOR",
 	TOKEN_MOD:         "MOD",
+	TOKEN_LIKE:        "LIKE",
 	TOKEN_TRUE:        "TRUE",
 	TOKEN_FALSE:       "FALSE",
 	TOKEN_NIL:         "NIL",
@@ -306,6 +318,22 @@ var Keywords = map[string]TokenType{
 	"MAKE_CHAN": TOKEN_MAKE_CHAN,
 }
 
+// OperatorWords are the word operators that are only keywords straight
+// after an operand, as in x IN list, so programs can still use them as names
+var OperatorWords = map[string]TokenType{
+	"IN":   TOKEN_IN,
+	"LIKE": TOKEN_LIKE,
+}
+
+// ContextualKeywords are the words the parser takes as keywords only where
+// their statement can start, such as LOCK before a mutex. Elsewhere they
+// are names, so programs written before they were added keep working.
+var ContextualKeywords = []string{
+	"ASSERT", "BENCHMARK", "CHECK", "DECLARE", "EACH", "EMBEDDIR", "EMBEDFILE",
+	"INTERFACE", "LOCK", "MODULE", "OPTION", "PARAMARRAY", "PROPERTY", "STATIC",
+	"TEST", "WITH",
+}
+
 // Token represents a lexical token
 type Token struct {
 	Type    TokenType
@@ -0,0 +1,550 @@
+package lsp
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+
+	"github.com/zditech/dbasic/pkg/analyzer"
+	dberrors "github.com/zditech/dbasic/pkg/errors"
+	"github.com/zditech/dbasic/pkg/lexer"
+	"github.com/zditech/dbasic/pkg/parser"
+	"github.com/zditech/dbasic/pkg/plugin"
+	"github.com/zditech/dbasic/pkg/preprocessor"
+	"github.com/zditech/dbasic/pkg/project"
+)
+
+// document is an open file and what the compiler front end found in it.
+// Lines are 0-based and columns are byte offsets into the line, as the
+// lexer counts them; conversion to LSP positions happens at the edges.
+type document struct {
+	uri   string
+	path  string
+	lines []string
+
+	pp       *preprocessor.Result
+	diags    []diagnostic
+	refs     []ref
+	globals  []*analyzer.Symbol
+	routines []int // Lines where SUB, FUNCTION and METHOD blocks start, in order
+}
+
+// ref is an identifier in the document and the symbol it names
+type ref struct {
+	line  int
+	start int
+	end   int
+	sym   *analyzer.Symbol
+	decl  bool // The identifier declares sym
+}
+
+// ppErrorRe matches a preprocessor error, which is prefixed with file:line
+var ppErrorRe = regexp.MustCompile(`^(.+?):(\d+): (.*)`)
+
+// analyze runs the preprocessor, parser and analyzer over text, the
+// current contents of the file at uri. INCLUDEs search includeDirs before
+// the libraries of the project and $DBASIC_PATH, as dbasic check does.
+func analyze(uri, text string, includeDirs []string) *document {
+	d := &document{
+		uri:   uri,
+		path:  uriToPath(uri),
+		lines: strings.Split(text, "\n"),
+	}
+
+	pp := preprocessor.New(filepath.Dir(d.path))
+	dirs := append(append([]string{}, includeDirs...), project.SearchPath(filepath.Dir(d.path))...)
+	pp.SetSearchPath(append(dirs, preprocessor.EnvSearchPath()...))
+	res, err := pp.ProcessSource(d.path, text)
+	if err != nil {
+		d.addPreprocessorErrors(pp.Errors(), err)
+		return d
+	}
+	d.pp = res
+
+	p := parser.New(lexer.New(res.Source))
+	program := p.ParseProgram()
+	if len(p.Errors()) > 0 {
+		d.addErrors(p.Errors())
+		return d
+	}
+
+	a := analyzer.New()
+	a.SetSource(res.Source)
+	// Errors in plugins are left for dbasic check to report
+	if plugins, err := plugin.LoadAll(project.PluginDirs(filepath.Dir(d.path))); err == nil {
+		for _, p := range plugins {
+			a.AddPlugin(p)
+		}
+	}
+	declarePackages(a, pp, program)
+	symbols, errs := a.Analyze(program)
+	d.addErrors(errs)
+
+	d.globals = symbols.GlobalScope.AllSymbols()
+	sort.Slice(d.globals, func(i, j int) bool {
+		return strings.ToUpper(d.globals[i].Name) < strings.ToUpper(d.globals[j].Name)
+	})
+
+	for _, r := range a.References() {
+		line, ok := d.mainLine(r.Ident.Token.Line)
+		if !ok {
+			continue
+		}
+		start := r.Ident.Token.Column - 1
+		d.refs = append(d.refs, ref{
+			line:  line,
+			start: start,
+			end:   start + len(r.Ident.Token.Literal),
+			sym:   r.Symbol,
+			decl:  r.Ident == r.Symbol.Decl,
+		})
+	}
+
+	d.findRoutines(program.Statements)
+	sort.Ints(d.routines)
+
+	return d
+}
+
+// declarePackages gives the analyzer the .dbasi files of the Go packages
+// the program imports, found where INCLUDE looks for files. Errors in them
+// are left for dbasic check to report, as they are not in the document.
+func declarePackages(a *analyzer.Analyzer, pp *preprocessor.Preprocessor, program *parser.Program) {
+	for _, stmt := range program.Statements {
+		is, ok := stmt.(*parser.ImportStatement)
+		if !ok {
+			continue
+		}
+		path := pp.Find(analyzer.DeclarationName(is.Package))
+		if path == "" {
+			continue
+		}
+		if source, err := os.ReadFile(path); err == nil {
+			a.Declare(path, string(source))
+		}
+	}
+}
+
+// findRoutines records where each routine in stmts starts, looking
+// inside modules
+func (d *document) findRoutines(stmts []parser.Statement) {
+	for _, stmt := range stmts {
+		var tok lexer.Token
+		switch s := stmt.(type) {
+		case *parser.SubStatement:
+			tok = s.Token
+		case *parser.FunctionStatement:
+			tok = s.Token
+		case *parser.MethodStatement:
+			tok = s.Token
+		case *parser.ModuleStatement:
+			d.findRoutines(s.Body)
+			continue
+		default:
+			continue
+		}
+		if line, ok := d.mainLine(tok.Line); ok {
+			d.routines = append(d.routines, line)
+		}
+	}
+}
+
+// mainLine maps a 1-based line of preprocessed source to a 0-based line of
+// the document. ok is false for lines that came from an included file,
+// even one with the same name in another directory.
+func (d *document) mainLine(line int) (int, bool) {
+	path, orig := d.pp.GetOriginalPath(line)
+	if orig == 0 || !samePath(path, d.path) {
+		return 0, false
+	}
+	return orig - 1, true
+}
+
+// samePath reports whether two paths name the same file once made absolute
+// and cleaned
+func samePath(a, b string) bool {
+	a, errA := filepath.Abs(a)
+	b, errB := filepath.Abs(b)
+	if errA != nil || errB != nil {
+		return false
+	}
+	if runtime.GOOS == "windows" {
+		return strings.EqualFold(a, b)
+	}
+	return a == b
+}
+
+// includeLine finds the INCLUDE directive in the document that pulled in
+// the preprocessed line, for errors in included files
+func (d *document) includeLine(line int) int {
+	for l := line; l >= 1; l-- {
+		if orig, ok := d.mainLine(l); ok {
+			return orig
+		}
+	}
+	return 0
+}
+
+// addErrors turns parser or analyzer error messages into diagnostics
+func (d *document) addErrors(errs []string) {
+	for _, e := range errs {
+		parsed := dberrors.Parse(e)
+		if parsed == nil {
+			d.diags = append(d.diags, d.diagnostic(0, -1, strings.SplitN(e, "\n", 2)[0]))
+			continue
+		}
+
+		msg := parsed.Message
+		if parsed.Hint != "" {
+			msg += "\nhint: " + parsed.Hint
+		}
+		line, col := parsed.Line, parsed.Column-1
+
+		var diag diagnostic
+		if line == 0 {
+			diag = d.diagnostic(0, -1, msg)
+		} else if orig, ok := d.mainLine(line); ok {
+			diag = d.diagnostic(orig, col, msg)
+		} else {
+			file, orig := d.pp.GetOriginalLocation(line)
+			msg = fmt.Sprintf("in %s:%d: %s", file, orig, msg)
+			diag = d.diagnostic(d.includeLine(line), -1, msg)
+		}
+		diag.Code = parsed.Code
+		d.diags = append(d.diags, diag)
+	}
+}
+
+// addPreprocessorErrors reports a failed INCLUDE on the directive's line
+func (d *document) addPreprocessorErrors(errs []string, err error) {
+	for _, e := range errs {
+		m := ppErrorRe.FindStringSubmatch(e)
+		if m != nil && m[1] == filepath.Base(d.path) {
+			line, _ := strconv.Atoi(m[2])
+			diag := d.diagnostic(line-1, -1, m[3])
+			diag.Code = dberrors.CodeFor("preprocessor", m[3])
+			d.diags = append(d.diags, diag)
+		} else {
+			d.diags = append(d.diags, d.diagnostic(0, -1, e))
+		}
+	}
+	if len(errs) == 0 {
+		d.diags = append(d.diags, d.diagnostic(0, -1, err.Error()))
+	}
+}
+
+// diagnostic builds an error at a byte column of line, or covering the
+// whole line if col is negative
+func (d *document) diagnostic(line, col int, msg string) diagnostic {
+	r := d.lineRange(line)
+	if col >= 0 {
+		r.Start = d.position(line, col)
+		r.End = d.position(line, d.wordEnd(line, col))
+	}
+	return diagnostic{Range: r, Severity: severityError, Source: "dbasic", Message: msg}
+}
+
+// lineRange covers the text of line, without leading indentation
+func (d *document) lineRange(line int) lspRange {
+	if line < 0 || line >= len(d.lines) {
+		return lspRange{}
+	}
+	text := strings.TrimRight(d.lines[line], "\r")
+	indent := len(text) - len(strings.TrimLeft(text, " \t"))
+	return lspRange{Start: d.position(line, indent), End: d.position(line, len(text))}
+}
+
+// wordEnd returns the end of the identifier starting at col, or col+1
+func (d *document) wordEnd(line, col int) int {
+	if line < 0 || line >= len(d.lines) {
+		return col
+	}
+	text := d.lines[line]
+	end := col
+	for end < len(text) && isIdentByte(text[end]) {
+		end++
+	}
+	if end == col && end < len(text) {
+		end++
+	}
+	return end
+}
+
+// position converts a byte column to an LSP position, which counts UTF-16
+// code units
+func (d *document) position(line, col int) position {
+	if line < 0 || line >= len(d.lines) {
+		return position{Line: line, Character: col}
+	}
+	text := d.lines[line]
+	if col > len(text) {
+		col = len(text)
+	}
+	return position{Line: line, Character: len(utf16.Encode([]rune(text[:col])))}
+}
+
+// offset converts an LSP position to a byte column
+func (d *document) offset(pos position) int {
+	if pos.Line < 0 || pos.Line >= len(d.lines) {
+		return 0
+	}
+	text := d.lines[pos.Line]
+	units := 0
+	for i, r := range text {
+		if units >= pos.Character {
+			return i
+		}
+		units += utf16.RuneLen(r)
+	}
+	return len(text)
+}
+
+// refAt finds the identifier under the cursor
+func (d *document) refAt(pos position) *ref {
+	col := d.offset(pos)
+	for i := range d.refs {
+		r := &d.refs[i]
+		if r.line == pos.Line && r.start <= col && col <= r.end {
+			return r
+		}
+	}
+	return nil
+}
+
+// hover describes the symbol under the cursor
+func (d *document) hover(pos position) *hover {
+	r := d.refAt(pos)
+	if r == nil {
+		return nil
+	}
+	rng := lspRange{Start: d.position(r.line, r.start), End: d.position(r.line, r.end)}
+	return &hover{
+		Contents: markupContent{Kind: "markdown", Value: "```dbasic\n" + describe(r.sym) + "\n```"},
+		Range:    &rng,
+	}
+}
+
+// definition finds where the symbol under the cursor is declared, which
+// may be in an included file
+func (d *document) definition(pos position) *location {
+	r := d.refAt(pos)
+	if r == nil || r.sym.Decl == nil {
+		return nil
+	}
+	tok := r.sym.Decl.Token
+	file, line := d.pp.GetOriginalLocation(tok.Line)
+	if line == 0 {
+		return nil
+	}
+
+	if file == filepath.Base(d.path) {
+		start := d.position(line-1, tok.Column-1)
+		end := d.position(line-1, tok.Column-1+len(tok.Literal))
+		return &location{URI: d.uri, Range: lspRange{Start: start, End: end}}
+	}
+	for _, path := range d.pp.IncludedFiles {
+		if filepath.Base(path) == file {
+			// Columns in another file are bytes, which match UTF-16 for
+			// the ASCII identifiers DBasic allows
+			start := position{Line: line - 1, Character: tok.Column - 1}
+			end := position{Line: line - 1, Character: tok.Column - 1 + len(tok.Literal)}
+			return &location{URI: pathToURI(path), Range: lspRange{Start: start, End: end}}
+		}
+	}
+	return nil
+}
+
+// completion lists keywords, global symbols and the locals declared so far
+// in the routine around the cursor. After "Module." it lists the module's
+// members instead.
+func (d *document) completion(pos position, globals []*analyzer.Symbol) []completionItem {
+	text := ""
+	if pos.Line >= 0 && pos.Line < len(d.lines) {
+		text = d.lines[pos.Line][:d.offset(pos)]
+	}
+	if m := memberRe.FindStringSubmatch(text); m != nil {
+		for _, sym := range globals {
+			if sym.Kind == analyzer.SymModule && strings.EqualFold(sym.Name, m[1]) {
+				return symbolItems(sym.Members.AllSymbols())
+			}
+		}
+		return []completionItem{}
+	}
+
+	var items []completionItem
+	for kw := range lexer.Keywords {
+		items = append(items, completionItem{Label: kw, Kind: completionKeyword})
+	}
+	for kw := range lexer.OperatorWords {
+		items = append(items, completionItem{Label: kw, Kind: completionKeyword})
+	}
+	for _, kw := range lexer.ContextualKeywords {
+		items = append(items, completionItem{Label: kw, Kind: completionKeyword})
+	}
+	sort.Slice(items, func(i, j int) bool { return items[i].Label < items[j].Label })
+
+	items = append(items, symbolItems(globals)...)
+	items = append(items, symbolItems(d.locals(pos.Line))...)
+	return items
+}
+
+// memberRe matches a module name and dot before the cursor
+var memberRe = regexp.MustCompile(`([A-Za-z_][A-Za-z0-9_]*)\.[A-Za-z0-9_]*$`)
+
+// locals returns the symbols declared inside the routine around line,
+// before line
+func (d *document) locals(line int) []*analyzer.Symbol {
+	start := -1
+	for _, l := range d.routines {
+		if l <= line {
+			start = l
+		}
+	}
+	if start < 0 {
+		return nil
+	}
+
+	seen := make(map[string]bool)
+	var syms []*analyzer.Symbol
+	for _, r := range d.refs {
+		if !r.decl || r.line < start || r.line > line || r.sym.Scope == nil || r.sym.Scope.Parent == nil {
+			continue
+		}
+		if key := strings.ToUpper(r.sym.Name); !seen[key] {
+			seen[key] = true
+			syms = append(syms, r.sym)
+		}
+	}
+	return syms
+}
+
+// symbolItems turns symbols into completion items
+func symbolItems(syms []*analyzer.Symbol) []completionItem {
+	var items []completionItem
+	for _, sym := range syms {
+		kind := completionVariable
+		switch sym.Kind {
+		case analyzer.SymConstant:
+			kind = completionConstant
+		case analyzer.SymFunction, analyzer.SymSub:
+			kind = completionFunction
+		case analyzer.SymModule:
+			kind = completionModule
+		case analyzer.SymLabel, analyzer.SymImport:
+			continue
+		}
+		items = append(items, completionItem{Label: sym.Name, Kind: kind, Detail: describe(sym)})
+	}
+	return items
+}
+
+// describe renders a symbol the way it would be declared
+func describe(sym *analyzer.Symbol) string {
+	typ := "ANY"
+	if sym.Type != nil {
+		typ = sym.Type.String()
+	}
+
+	switch sym.Kind {
+	case analyzer.SymConstant:
+		return fmt.Sprintf("CONST %s AS %s", sym.Name, typ)
+	case analyzer.SymParameter:
+		if sym.IsByRef {
+			return fmt.Sprintf("BYREF %s AS %s", sym.Name, typ)
+		}
+		return fmt.Sprintf("%s AS %s", sym.Name, typ)
+	case analyzer.SymModule:
+		return "MODULE " + sym.Name
+	case analyzer.SymFunction, analyzer.SymSub:
+		return signature(sym)
+	default:
+		return fmt.Sprintf("DIM %s AS %s", sym.Name, typ)
+	}
+}
+
+// signature renders a SUB or FUNCTION header, with parameter names when
+// the routine is declared in source
+func signature(sym *analyzer.Symbol) string {
+	var params []string
+	switch n := sym.Node.(type) {
+	case *parser.SubStatement:
+		params = paramList(n.Params)
+	case *parser.FunctionStatement:
+		params = paramList(n.Params)
+	default:
+		if sym.Type != nil {
+			for _, p := range sym.Type.ParamTypes {
+				params = append(params, p.String())
+			}
+			if sym.Type.Variadic {
+				params = append(params, "...")
+			}
+		}
+	}
+
+	head := fmt.Sprintf("%s(%s)", sym.Name, strings.Join(params, ", "))
+	if sym.Type == nil || sym.Type.Kind == analyzer.TypeSub {
+		return "SUB " + head
+	}
+
+	var rets []string
+	for _, r := range sym.Type.ReturnTypes {
+		rets = append(rets, r.String())
+	}
+	if len(rets) == 1 {
+		return fmt.Sprintf("FUNCTION %s AS %s", head, rets[0])
+	}
+	return fmt.Sprintf("FUNCTION %s AS (%s)", head, strings.Join(rets, ", "))
+}
+
+func paramList(params []*parser.Parameter) []string {
+	var list []string
+	for _, p := range params {
+		s := p.Name.Value
+		if p.Type != nil {
+			s += " AS " + p.Type.String()
+		}
+		if p.ByRef {
+			s = "BYREF " + s
+		}
+		if p.ParamArray {
+			s = "PARAMARRAY " + s
+		}
+		list = append(list, s)
+	}
+	return list
+}
+
+func isIdentByte(c byte) bool {
+	return c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9'
+}
+
+// uriToPath converts a file:// URI to a local path
+func uriToPath(uri string) string {
+	u, err := url.Parse(uri)
+	if err != nil || u.Scheme != "file" {
+		return uri
+	}
+	path := u.Path
+	if runtime.GOOS == "windows" {
+		// file:///C:/dir/x.dbas has the path /C:/dir/x.dbas
+		path = strings.TrimPrefix(path, "/")
+	}
+	return filepath.FromSlash(path)
+}
+
+// pathToURI converts a local path to a file:// URI
+func pathToURI(path string) string {
+	path = filepath.ToSlash(path)
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+	return (&url.URL{Scheme: "file", Path: path}).String()
+}
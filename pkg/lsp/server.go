@@ -0,0 +1,371 @@
+// Package lsp implements a Language Server Protocol server for DBasic.
+//
+// The server speaks JSON-RPC over a pair of streams, normally stdin and
+// stdout, and keeps every open document analyzed: it publishes parser and
+// analyzer errors as diagnostics and answers hover, go-to-definition and
+// completion requests from the analyzer's symbol table.
+package lsp
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/textproto"
+	"strconv"
+
+	"github.com/zditech/dbasic/pkg/analyzer"
+)
+
+// Server is a language server for one client connection
+type Server struct {
+	in   *bufio.Reader
+	out  io.Writer
+	docs map[string]*document
+
+	// globals keeps each document's symbols from the last analysis that
+	// got past the parser, so completion still works while a line is
+	// half typed
+	globals  map[string][]*analyzer.Symbol
+	shutdown bool
+
+	includeDirs []string // Searched for INCLUDEd files before the project's libraries
+}
+
+// NewServer creates a server that reads requests from in and writes
+// responses to out
+func NewServer(in io.Reader, out io.Writer) *Server {
+	return &Server{
+		in:      bufio.NewReader(in),
+		out:     out,
+		docs:    make(map[string]*document),
+		globals: make(map[string][]*analyzer.Symbol),
+	}
+}
+
+// SetIncludeDirs sets the directories searched for INCLUDEd files, such as
+// the -I flags and the include directories of the configuration
+func (s *Server) SetIncludeDirs(dirs []string) {
+	s.includeDirs = dirs
+}
+
+// Run serves requests until the client sends exit. It returns an error if
+// the connection fails or the client exits without asking to shut down.
+func (s *Server) Run() error {
+	for {
+		msg, err := s.read()
+		if err != nil {
+			if err == io.EOF {
+				return errors.New("connection closed without exit")
+			}
+			return err
+		}
+		if msg.Method == "exit" {
+			if !s.shutdown {
+				return errors.New("exit without shutdown")
+			}
+			return nil
+		}
+		if err := s.handle(msg); err != nil {
+			return err
+		}
+	}
+}
+
+// JSON-RPC error codes
+const (
+	codeParseError     = -32700
+	codeMethodNotFound = -32601
+	codeInvalidRequest = -32600
+)
+
+// message is a JSON-RPC request, notification or response
+type message struct {
+	JSONRPC string           `json:"jsonrpc"`
+	ID      *json.RawMessage `json:"id,omitempty"`
+	Method  string           `json:"method,omitempty"`
+	Params  json.RawMessage  `json:"params,omitempty"`
+	Result  interface{}      `json:"result,omitempty"`
+	Error   *responseError   `json:"error,omitempty"`
+}
+
+type responseError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+// read reads one message, framed by a Content-Length header
+func (s *Server) read() (*message, error) {
+	header, err := textproto.NewReader(s.in).ReadMIMEHeader()
+	if err != nil {
+		return nil, err
+	}
+	length, err := strconv.Atoi(header.Get("Content-Length"))
+	if err != nil {
+		return nil, fmt.Errorf("bad Content-Length header: %q", header.Get("Content-Length"))
+	}
+
+	body := make([]byte, length)
+	if _, err := io.ReadFull(s.in, body); err != nil {
+		return nil, err
+	}
+
+	var msg message
+	if err := json.Unmarshal(body, &msg); err != nil {
+		return &message{Method: "$/invalid", Error: &responseError{Code: codeParseError, Message: err.Error()}}, nil
+	}
+	return &msg, nil
+}
+
+// write sends one message
+func (s *Server) write(msg *message) error {
+	msg.JSONRPC = "2.0"
+	body, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(s.out, "Content-Length: %d\r\n\r\n", len(body)); err != nil {
+		return err
+	}
+	_, err = s.out.Write(body)
+	return err
+}
+
+// reply answers a request. A nil result is sent as JSON null.
+func (s *Server) reply(id *json.RawMessage, result interface{}) error {
+	if result == nil {
+		result = json.RawMessage("null")
+	}
+	return s.write(&message{ID: id, Result: result})
+}
+
+func (s *Server) replyError(id *json.RawMessage, code int, format string, args ...interface{}) error {
+	return s.write(&message{ID: id, Error: &responseError{Code: code, Message: fmt.Sprintf(format, args...)}})
+}
+
+// notify sends a notification to the client
+func (s *Server) notify(method string, params interface{}) error {
+	raw, err := json.Marshal(params)
+	if err != nil {
+		return err
+	}
+	return s.write(&message{Method: method, Params: raw})
+}
+
+// handle dispatches one message. Errors in a request go back to the
+// client; only a failure to write ends the session.
+func (s *Server) handle(msg *message) error {
+	if msg.Error != nil {
+		return s.replyError(nil, msg.Error.Code, "%s", msg.Error.Message)
+	}
+
+	switch msg.Method {
+	case "initialize":
+		return s.reply(msg.ID, initializeResult{
+			Capabilities: serverCapabilities{
+				TextDocumentSync:   syncFull,
+				HoverProvider:      true,
+				DefinitionProvider: true,
+				CompletionProvider: &completionOptions{TriggerCharacters: []string{"."}},
+			},
+			ServerInfo: serverInfo{Name: "dbasic"},
+		})
+	case "initialized", "$/cancelRequest", "$/setTrace", "workspace/didChangeConfiguration":
+		return nil
+	case "shutdown":
+		s.shutdown = true
+		return s.reply(msg.ID, nil)
+
+	case "textDocument/didOpen":
+		var params didOpenParams
+		if err := json.Unmarshal(msg.Params, &params); err != nil {
+			return nil
+		}
+		return s.update(params.TextDocument.URI, params.TextDocument.Text)
+	case "textDocument/didChange":
+		var params didChangeParams
+		if err := json.Unmarshal(msg.Params, &params); err != nil || len(params.ContentChanges) == 0 {
+			return nil
+		}
+		// With full sync the last change holds the whole document
+		return s.update(params.TextDocument.URI, params.ContentChanges[len(params.ContentChanges)-1].Text)
+	case "textDocument/didClose":
+		var params textDocumentParams
+		if err := json.Unmarshal(msg.Params, &params); err != nil {
+			return nil
+		}
+		delete(s.docs, params.TextDocument.URI)
+		delete(s.globals, params.TextDocument.URI)
+		return s.notify("textDocument/publishDiagnostics", publishDiagnosticsParams{
+			URI:         params.TextDocument.URI,
+			Diagnostics: []diagnostic{},
+		})
+
+	case "textDocument/hover":
+		doc, params, err := s.position(msg)
+		if err != nil || doc == nil {
+			return s.reply(msg.ID, nil)
+		}
+		if h := doc.hover(params.Position); h != nil {
+			return s.reply(msg.ID, h)
+		}
+		return s.reply(msg.ID, nil)
+	case "textDocument/definition":
+		doc, params, err := s.position(msg)
+		if err != nil || doc == nil {
+			return s.reply(msg.ID, nil)
+		}
+		if loc := doc.definition(params.Position); loc != nil {
+			return s.reply(msg.ID, loc)
+		}
+		return s.reply(msg.ID, nil)
+	case "textDocument/completion":
+		doc, params, err := s.position(msg)
+		if err != nil || doc == nil {
+			return s.reply(msg.ID, []completionItem{})
+		}
+		return s.reply(msg.ID, doc.completion(params.Position, s.globals[doc.uri]))
+	}
+
+	if msg.ID == nil {
+		// Unknown notifications are ignored, as the protocol requires
+		return nil
+	}
+	if s.shutdown {
+		return s.replyError(msg.ID, codeInvalidRequest, "server is shutting down")
+	}
+	return s.replyError(msg.ID, codeMethodNotFound, "unsupported method: %s", msg.Method)
+}
+
+// update analyzes a new version of a document and publishes its diagnostics
+func (s *Server) update(uri, text string) error {
+	doc := analyze(uri, text, s.includeDirs)
+	s.docs[uri] = doc
+	if doc.globals != nil {
+		s.globals[uri] = doc.globals
+	}
+
+	diags := doc.diags
+	if diags == nil {
+		diags = []diagnostic{}
+	}
+	return s.notify("textDocument/publishDiagnostics", publishDiagnosticsParams{URI: uri, Diagnostics: diags})
+}
+
+// position decodes the parameters of a request about a place in a document
+func (s *Server) position(msg *message) (*document, *textDocumentPositionParams, error) {
+	var params textDocumentPositionParams
+	if err := json.Unmarshal(msg.Params, &params); err != nil {
+		return nil, nil, err
+	}
+	return s.docs[params.TextDocument.URI], &params, nil
+}
+
+// Protocol types, covering the parts of the specification the server uses
+
+const syncFull = 1
+
+const severityError = 1
+
+// Completion item kinds
+const (
+	completionFunction = 3
+	completionVariable = 6
+	completionModule   = 9
+	completionKeyword  = 14
+	completionConstant = 21
+)
+
+type initializeResult struct {
+	Capabilities serverCapabilities `json:"capabilities"`
+	ServerInfo   serverInfo         `json:"serverInfo"`
+}
+
+type serverCapabilities struct {
+	TextDocumentSync   int                `json:"textDocumentSync"`
+	HoverProvider      bool               `json:"hoverProvider"`
+	DefinitionProvider bool               `json:"definitionProvider"`
+	CompletionProvider *completionOptions `json:"completionProvider,omitempty"`
+}
+
+type completionOptions struct {
+	TriggerCharacters []string `json:"triggerCharacters,omitempty"`
+}
+
+type serverInfo struct {
+	Name string `json:"name"`
+}
+
+type textDocumentIdentifier struct {
+	URI string `json:"uri"`
+}
+
+type textDocumentItem struct {
+	URI  string `json:"uri"`
+	Text string `json:"text"`
+}
+
+type textDocumentParams struct {
+	TextDocument textDocumentIdentifier `json:"textDocument"`
+}
+
+type didOpenParams struct {
+	TextDocument textDocumentItem `json:"textDocument"`
+}
+
+type didChangeParams struct {
+	TextDocument   textDocumentIdentifier `json:"textDocument"`
+	ContentChanges []struct {
+		Text string `json:"text"`
+	} `json:"contentChanges"`
+}
+
+type textDocumentPositionParams struct {
+	TextDocument textDocumentIdentifier `json:"textDocument"`
+	Position     position               `json:"position"`
+}
+
+type position struct {
+	Line      int `json:"line"`
+	Character int `json:"character"`
+}
+
+type lspRange struct {
+	Start position `json:"start"`
+	End   position `json:"end"`
+}
+
+type location struct {
+	URI   string   `json:"uri"`
+	Range lspRange `json:"range"`
+}
+
+type diagnostic struct {
+	Range    lspRange `json:"range"`
+	Severity int      `json:"severity"`
+	Code     string   `json:"code,omitempty"`
+	Source   string   `json:"source"`
+	Message  string   `json:"message"`
+}
+
+type publishDiagnosticsParams struct {
+	URI         string       `json:"uri"`
+	Diagnostics []diagnostic `json:"diagnostics"`
+}
+
+type markupContent struct {
+	Kind  string `json:"kind"`
+	Value string `json:"value"`
+}
+
+type hover struct {
+	Contents markupContent `json:"contents"`
+	Range    *lspRange     `json:"range,omitempty"`
+}
+
+type completionItem struct {
+	Label  string `json:"label"`
+	Kind   int    `json:"kind"`
+	Detail string `json:"detail,omitempty"`
+}
@@ -0,0 +1,265 @@
+package lsp
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// session runs a server over the given client messages and returns what
+// it wrote back, along with the error from Run
+func session(t *testing.T, msgs ...map[string]interface{}) ([]map[string]interface{}, error) {
+	t.Helper()
+	var in bytes.Buffer
+	for _, m := range msgs {
+		m["jsonrpc"] = "2.0"
+		body, err := json.Marshal(m)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fmt.Fprintf(&in, "Content-Length: %d\r\n\r\n%s", len(body), body)
+	}
+
+	var out bytes.Buffer
+	runErr := NewServer(&in, &out).Run()
+
+	var replies []map[string]interface{}
+	r := bufio.NewReader(&out)
+	for {
+		header, err := textproto.NewReader(r).ReadMIMEHeader()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("bad header: %v", err)
+		}
+		n, _ := strconv.Atoi(header.Get("Content-Length"))
+		body := make([]byte, n)
+		if _, err := io.ReadFull(r, body); err != nil {
+			t.Fatal(err)
+		}
+		var reply map[string]interface{}
+		if err := json.Unmarshal(body, &reply); err != nil {
+			t.Fatalf("bad reply %s: %v", body, err)
+		}
+		replies = append(replies, reply)
+	}
+	return replies, runErr
+}
+
+func request(id int, method string, params interface{}) map[string]interface{} {
+	return map[string]interface{}{"id": id, "method": method, "params": params}
+}
+
+func notification(method string, params interface{}) map[string]interface{} {
+	return map[string]interface{}{"method": method, "params": params}
+}
+
+func at(uri string, line, char int) map[string]interface{} {
+	return map[string]interface{}{
+		"textDocument": map[string]interface{}{"uri": uri},
+		"position":     map[string]interface{}{"line": line, "character": char},
+	}
+}
+
+// reply finds the response to request id
+func reply(t *testing.T, replies []map[string]interface{}, id int) interface{} {
+	t.Helper()
+	for _, r := range replies {
+		if v, ok := r["id"].(float64); ok && int(v) == id {
+			if r["error"] != nil {
+				t.Fatalf("request %d failed: %v", id, r["error"])
+			}
+			return r["result"]
+		}
+	}
+	t.Fatalf("no reply to request %d", id)
+	return nil
+}
+
+// diagnostics returns the messages of each publishDiagnostics notification,
+// after their codes
+func diagnostics(replies []map[string]interface{}) [][]string {
+	var all [][]string
+	for _, r := range replies {
+		if r["method"] != "textDocument/publishDiagnostics" {
+			continue
+		}
+		msgs := []string{}
+		params := r["params"].(map[string]interface{})
+		for _, d := range params["diagnostics"].([]interface{}) {
+			diag := d.(map[string]interface{})
+			line := diag["range"].(map[string]interface{})["start"].(map[string]interface{})["line"].(float64)
+			msgs = append(msgs, fmt.Sprintf("%d: %v %s", int(line), diag["code"], diag["message"]))
+		}
+		all = append(all, msgs)
+	}
+	return all
+}
+
+func TestServer(t *testing.T) {
+	dir := t.TempDir()
+	lib := "FUNCTION Twice(n AS INTEGER) AS INTEGER\n    RETURN n * 2\nEND FUNCTION\n"
+	if err := os.WriteFile(filepath.Join(dir, "lib.dbas"), []byte(lib), 0644); err != nil {
+		t.Fatal(err)
+	}
+	uri := pathToURI(filepath.Join(dir, "main.dbas"))
+
+	text := `INCLUDE "lib.dbas"
+
+MODULE Geo
+    CONST PI AS DOUBLE = 3.14159
+END MODULE
+
+SUB Main()
+    DIM count AS INTEGER = Twice(2)
+    PRINT "ü", count, Geo.PI
+END SUB
+`
+	broken := strings.Replace(text, "Twice(2)", "Twice(2, 3)", 1)
+
+	replies, err := session(t,
+		request(1, "initialize", map[string]interface{}{}),
+		notification("initialized", map[string]interface{}{}),
+		notification("textDocument/didOpen", map[string]interface{}{
+			"textDocument": map[string]interface{}{"uri": uri, "languageId": "dbasic", "version": 1, "text": broken},
+		}),
+		notification("textDocument/didChange", map[string]interface{}{
+			"textDocument":   map[string]interface{}{"uri": uri, "version": 2},
+			"contentChanges": []interface{}{map[string]interface{}{"text": text}},
+		}),
+		request(2, "textDocument/hover", at(uri, 8, 17)),
+		request(3, "textDocument/definition", at(uri, 7, 28)),
+		request(4, "textDocument/completion", at(uri, 8, 4)),
+		request(5, "textDocument/completion", at(uri, 8, 28)),
+		request(6, "textDocument/hover", at(uri, 1, 0)),
+		request(7, "shutdown", nil),
+		notification("exit", nil),
+	)
+	if err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+
+	caps := reply(t, replies, 1).(map[string]interface{})["capabilities"].(map[string]interface{})
+	if caps["hoverProvider"] != true || caps["definitionProvider"] != true || caps["completionProvider"] == nil {
+		t.Errorf("missing capabilities: %v", caps)
+	}
+
+	diags := diagnostics(replies)
+	if len(diags) != 2 {
+		t.Fatalf("expected 2 diagnostics notifications, got %d", len(diags))
+	}
+	if len(diags[0]) != 1 || !strings.HasPrefix(diags[0][0], "7: DB2005 wrong number of arguments") {
+		t.Errorf("unexpected diagnostics for broken text: %v", diags[0])
+	}
+	if len(diags[1]) != 0 {
+		t.Errorf("expected no diagnostics after the fix, got %v", diags[1])
+	}
+
+	// "count" follows a non-ASCII string, so the range must count UTF-16 units
+	h := reply(t, replies, 2).(map[string]interface{})
+	if v := h["contents"].(map[string]interface{})["value"]; !strings.Contains(v.(string), "DIM count AS INTEGER") {
+		t.Errorf("unexpected hover: %v", v)
+	}
+	start := h["range"].(map[string]interface{})["start"].(map[string]interface{})["character"].(float64)
+	if start != 15 {
+		t.Errorf("expected hover to start at character 15, got %v", start)
+	}
+
+	loc := reply(t, replies, 3).(map[string]interface{})
+	if loc["uri"] != pathToURI(filepath.Join(dir, "lib.dbas")) {
+		t.Errorf("expected definition in lib.dbas, got %v", loc["uri"])
+	}
+	if line := loc["range"].(map[string]interface{})["start"].(map[string]interface{})["line"]; line != 0.0 {
+		t.Errorf("expected definition on line 0, got %v", line)
+	}
+
+	labels := func(id int) map[string]bool {
+		found := make(map[string]bool)
+		for _, item := range reply(t, replies, id).([]interface{}) {
+			found[item.(map[string]interface{})["label"].(string)] = true
+		}
+		return found
+	}
+	items := labels(4)
+	for _, want := range []string{"PRINT", "LOCK", "IN", "Twice", "Geo", "Len", "count", "Main"} {
+		if !items[want] {
+			t.Errorf("completion is missing %s", want)
+		}
+	}
+	members := labels(5)
+	if !members["PI"] || len(members) != 1 {
+		t.Errorf("expected module member completion to list only PI, got %v", members)
+	}
+
+	if r := reply(t, replies, 6); r != nil {
+		t.Errorf("expected no hover on a blank line, got %v", r)
+	}
+}
+
+func TestServerIncludeError(t *testing.T) {
+	uri := pathToURI(filepath.Join(t.TempDir(), "main.dbas"))
+	replies, err := session(t,
+		notification("textDocument/didOpen", map[string]interface{}{
+			"textDocument": map[string]interface{}{"uri": uri, "text": "' app\nINCLUDE \"missing.dbas\"\n"},
+		}),
+		request(1, "shutdown", nil),
+		notification("exit", nil),
+	)
+	if err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	diags := diagnostics(replies)
+	if len(diags) != 1 || len(diags[0]) != 1 || !strings.HasPrefix(diags[0][0], "1: DB0001 cannot read file") {
+		t.Errorf("expected an error on the INCLUDE line, got %v", diags)
+	}
+}
+
+func TestServerExitWithoutShutdown(t *testing.T) {
+	if _, err := session(t, notification("exit", nil)); err == nil {
+		t.Error("expected an error for exit without shutdown")
+	}
+	replies, err := session(t, request(1, "textDocument/rename", map[string]interface{}{}), request(2, "shutdown", nil), notification("exit", nil))
+	if err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	if replies[0]["error"] == nil {
+		t.Errorf("expected an error for an unsupported method, got %v", replies[0])
+	}
+}
+
+func TestAnalyzeIncludes(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"inc/helpers.dbas": "FUNCTION Twice(n AS INTEGER) AS INTEGER\n    RETURN n * 2\nEND FUNCTION\n",
+		"sub/main.dbas":    "' same name as the document\nDIM y AS INTEGER = \"text\"\n",
+	}
+	for name, text := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(text), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	uri := pathToURI(filepath.Join(dir, "main.dbas"))
+	text := "INCLUDE \"helpers.dbas\"\nINCLUDE \"sub/main.dbas\"\nDIM x AS INTEGER = Twice(2)\n"
+
+	d := analyze(uri, text, []string{filepath.Join(dir, "inc")})
+	if len(d.diags) != 1 {
+		t.Fatalf("expected one diagnostic, got %+v", d.diags)
+	}
+	diag := d.diags[0]
+	if diag.Range.Start.Line != 1 || !strings.HasPrefix(diag.Message, "in main.dbas:2: ") {
+		t.Errorf("expected the error in sub/main.dbas on its INCLUDE line, got %+v", diag)
+	}
+}
@@ -2,6 +2,7 @@ package parser
 
 import (
 	"strings"
+	"time"
 
 	"github.com/zditech/dbasic/pkg/lexer"
 )
@@ -54,6 +55,13 @@ type TypeSpec struct {
 	ElementType *TypeSpec   // For POINTER TO and CHAN OF
 	IsArray     bool        // Array type
 	ArraySize   Expression  // Array size expression (can be nil for dynamic)
+	IsMap       bool        // MAP OF K TO V (ElementType holds V)
+	KeyType     *TypeSpec   // For MAP OF K TO V
+	IsContainer bool        // STACK OF X, QUEUE OF X or SET OF X (Name says which)
+	IsFunction  bool        // FUNCTION(...) AS T or SUB(...)
+	ParamTypes  []*TypeSpec // For FUNCTION/SUB types
+	ReturnTypes []*TypeSpec // For FUNCTION types
+	Width       Expression  // For STRING * n, the fixed length
 }
 
 func (t *TypeSpec) TokenLiteral() string { return t.Token.Literal }
@@ -64,12 +72,39 @@ func (t *TypeSpec) String() string {
 	if t.IsChannel {
 		return "CHAN OF " + t.ElementType.String()
 	}
+	if t.IsMap {
+		return "MAP OF " + t.KeyType.String() + " TO " + t.ElementType.String()
+	}
+	if t.IsContainer {
+		return t.Name + " OF " + t.ElementType.String()
+	}
+	if t.IsFunction {
+		var params, rets []string
+		for _, pt := range t.ParamTypes {
+			params = append(params, pt.String())
+		}
+		for _, rt := range t.ReturnTypes {
+			rets = append(rets, rt.String())
+		}
+		sig := t.Name + "(" + strings.Join(params, ", ") + ")"
+		switch len(rets) {
+		case 0:
+			return sig
+		case 1:
+			return sig + " AS " + rets[0]
+		default:
+			return sig + " AS (" + strings.Join(rets, ", ") + ")"
+		}
+	}
 	if t.IsArray {
 		if t.ArraySize != nil {
 			return t.Name + "(" + t.ArraySize.String() + ")"
 		}
 		return t.Name + "()"
 	}
+	if t.Width != nil {
+		return t.Name + " * " + t.Width.String()
+	}
 	return t.Name
 }
 
@@ -91,23 +126,33 @@ func (is *ImportStatement) String() string {
 	return "IMPORT " + is.Package
 }
 
-// DimStatement represents a DIM variable declaration
+// DimStatement represents a DIM or STATIC variable declaration
 type DimStatement struct {
-	Token     lexer.Token // DIM token
-	Name      *Identifier
-	Type      *TypeSpec
-	Value     Expression // Optional initial value
-	ArraySize Expression // For array declarations
+	Token      lexer.Token // DIM or STATIC token
+	Name       *Identifier
+	Type       *TypeSpec
+	Value      Expression // Optional initial value
+	ArraySize  Expression // For array declarations (the upper bound when LowerBound is set)
+	LowerBound Expression // For DIM a(lower TO upper), nil otherwise
+	Static     bool       // STATIC: the value is kept between calls
 }
 
 func (ds *DimStatement) statementNode()       {}
 func (ds *DimStatement) TokenLiteral() string { return ds.Token.Literal }
 func (ds *DimStatement) String() string {
 	var sb strings.Builder
-	sb.WriteString("DIM ")
+	if ds.Static {
+		sb.WriteString("STATIC ")
+	} else {
+		sb.WriteString("DIM ")
+	}
 	sb.WriteString(ds.Name.String())
 	if ds.ArraySize != nil {
 		sb.WriteString("(")
+		if ds.LowerBound != nil {
+			sb.WriteString(ds.LowerBound.String())
+			sb.WriteString(" TO ")
+		}
 		sb.WriteString(ds.ArraySize.String())
 		sb.WriteString(")")
 	}
@@ -147,6 +192,31 @@ func (cs *ConstStatement) String() string {
 	return "CONST " + cs.Name.String() + " AS " + cs.Type.String() + " = " + cs.Value.String()
 }
 
+// ConstGroupStatement represents a CONST ... END CONST block of INTEGER
+// constants. A constant without a value is one more than the constant before
+// it; the first one defaults to 0.
+type ConstGroupStatement struct {
+	Token  lexer.Token
+	Names  []*Identifier
+	Values []Expression // nil where the value is omitted
+}
+
+func (cg *ConstGroupStatement) statementNode()       {}
+func (cg *ConstGroupStatement) TokenLiteral() string { return cg.Token.Literal }
+func (cg *ConstGroupStatement) String() string {
+	var sb strings.Builder
+	sb.WriteString("CONST\n")
+	for i, name := range cg.Names {
+		sb.WriteString(name.String())
+		if cg.Values[i] != nil {
+			sb.WriteString(" = " + cg.Values[i].String())
+		}
+		sb.WriteString("\n")
+	}
+	sb.WriteString("END CONST")
+	return sb.String()
+}
+
 // AssignmentStatement represents a variable assignment
 type AssignmentStatement struct {
 	Token lexer.Token
@@ -180,6 +250,7 @@ func (ms *MultiAssignmentStatement) String() string {
 // PrintStatement represents a PRINT statement
 type PrintStatement struct {
 	Token      lexer.Token
+	FileNumber Expression // For PRINT #n, which writes to an open file
 	Values     []Expression
 	Separators []string // ";" or "," between values
 }
@@ -189,6 +260,12 @@ func (ps *PrintStatement) TokenLiteral() string { return ps.Token.Literal }
 func (ps *PrintStatement) String() string {
 	var sb strings.Builder
 	sb.WriteString("PRINT ")
+	if ps.FileNumber != nil {
+		sb.WriteString("#" + ps.FileNumber.String())
+		if len(ps.Values) > 0 {
+			sb.WriteString(", ")
+		}
+	}
 	for i, v := range ps.Values {
 		sb.WriteString(v.String())
 		if i < len(ps.Separators) {
@@ -203,15 +280,103 @@ type InputStatement struct {
 	Token    lexer.Token
 	Prompt   Expression // Optional prompt string
 	Variable *Identifier
+	Type     *TypeSpec // For INPUT name AS TYPE, which declares the variable
 }
 
 func (is *InputStatement) statementNode()       {}
 func (is *InputStatement) TokenLiteral() string { return is.Token.Literal }
 func (is *InputStatement) String() string {
+	s := "INPUT " + is.Variable.String()
 	if is.Prompt != nil {
-		return "INPUT " + is.Prompt.String() + "; " + is.Variable.String()
+		s = "INPUT " + is.Prompt.String() + "; " + is.Variable.String()
+	}
+	if is.Type != nil {
+		s += " AS " + is.Type.String()
+	}
+	return s
+}
+
+// Declaration returns the DIM equivalent of INPUT name AS TYPE, or nil when
+// INPUT reads into an existing variable
+func (is *InputStatement) Declaration() *DimStatement {
+	if is.Type == nil {
+		return nil
+	}
+	return &DimStatement{Token: is.Token, Name: is.Variable, Type: is.Type}
+}
+
+// OpenStatement represents OPEN path FOR mode AS #n [LEN = size]
+type OpenStatement struct {
+	Token      lexer.Token
+	Path       Expression
+	Mode       string // INPUT, OUTPUT, APPEND, BINARY or RANDOM
+	FileNumber Expression
+	RecordLen  Expression // LEN = size of a RANDOM file's records, or nil
+}
+
+func (os *OpenStatement) statementNode()       {}
+func (os *OpenStatement) TokenLiteral() string { return os.Token.Literal }
+func (os *OpenStatement) String() string {
+	s := "OPEN " + os.Path.String() + " FOR " + os.Mode + " AS #" + os.FileNumber.String()
+	if os.RecordLen != nil {
+		s += " LEN = " + os.RecordLen.String()
+	}
+	return s
+}
+
+// CloseStatement represents CLOSE #n, ...; with no file numbers it closes
+// every open file
+type CloseStatement struct {
+	Token       lexer.Token
+	FileNumbers []Expression
+}
+
+func (cs *CloseStatement) statementNode()       {}
+func (cs *CloseStatement) TokenLiteral() string { return cs.Token.Literal }
+func (cs *CloseStatement) String() string {
+	var numbers []string
+	for _, n := range cs.FileNumbers {
+		numbers = append(numbers, "#"+n.String())
+	}
+	return strings.TrimSpace("CLOSE " + strings.Join(numbers, ", "))
+}
+
+// LineInputStatement represents LINE INPUT #n, var, which reads the next
+// line of an open file
+type LineInputStatement struct {
+	Token      lexer.Token
+	FileNumber Expression
+	Variable   *Identifier
+}
+
+func (ls *LineInputStatement) statementNode()       {}
+func (ls *LineInputStatement) TokenLiteral() string { return ls.Token.Literal }
+func (ls *LineInputStatement) String() string {
+	return "LINE INPUT #" + ls.FileNumber.String() + ", " + ls.Variable.String()
+}
+
+// GetPutStatement represents GET #n, pos, var or PUT #n, pos, var, which
+// read or write a fixed-size record of a BINARY or RANDOM file
+type GetPutStatement struct {
+	Token      lexer.Token // GET or PUT
+	FileNumber Expression
+	Position   Expression // Record number (RANDOM) or byte (BINARY) from 1; nil for the current position
+	Variable   Expression
+}
+
+func (gs *GetPutStatement) statementNode()       {}
+func (gs *GetPutStatement) TokenLiteral() string { return gs.Token.Literal }
+func (gs *GetPutStatement) String() string {
+	pos := ""
+	if gs.Position != nil {
+		pos = gs.Position.String()
 	}
-	return "INPUT " + is.Variable.String()
+	return gs.Name() + " #" + gs.FileNumber.String() + ", " + pos + ", " + gs.Variable.String()
+}
+
+// Name returns GET or PUT
+func (gs *GetPutStatement) Name() string {
+	return strings.ToUpper(gs.Token.Literal)
 }
 
 // IfStatement represents an IF/THEN/ELSE/ENDIF block
@@ -281,6 +446,33 @@ func (fs *ForStatement) String() string {
 	return sb.String()
 }
 
+// ForEachStatement represents a FOR EACH/IN/NEXT loop over a collection
+type ForEachStatement struct {
+	Token      lexer.Token
+	Key        *Identifier // Optional, set for the two-variable form (FOR EACH k, v IN ...)
+	Value      *Identifier
+	Collection Expression
+	Body       *BlockStatement
+}
+
+func (fe *ForEachStatement) statementNode()       {}
+func (fe *ForEachStatement) TokenLiteral() string { return fe.Token.Literal }
+func (fe *ForEachStatement) String() string {
+	var sb strings.Builder
+	sb.WriteString("FOR EACH ")
+	if fe.Key != nil {
+		sb.WriteString(fe.Key.String())
+		sb.WriteString(", ")
+	}
+	sb.WriteString(fe.Value.String())
+	sb.WriteString(" IN ")
+	sb.WriteString(fe.Collection.String())
+	sb.WriteString("\n")
+	sb.WriteString(fe.Body.String())
+	sb.WriteString("NEXT")
+	return sb.String()
+}
+
 // WhileStatement represents a WHILE/WEND loop
 type WhileStatement struct {
 	Token     lexer.Token
@@ -346,10 +538,36 @@ type SelectStatement struct {
 
 type CaseClause struct {
 	Token  lexer.Token
-	Values []Expression
+	Values []Expression // Plain values, *CaseRange or *CaseIs
 	Body   *BlockStatement
 }
 
+// CaseRange represents a CASE low TO high value
+type CaseRange struct {
+	Token lexer.Token // The TO token
+	Low   Expression
+	High  Expression
+}
+
+func (cr *CaseRange) expressionNode()      {}
+func (cr *CaseRange) TokenLiteral() string { return cr.Token.Literal }
+func (cr *CaseRange) String() string {
+	return cr.Low.String() + " TO " + cr.High.String()
+}
+
+// CaseIs represents a CASE IS <op> value comparison
+type CaseIs struct {
+	Token    lexer.Token // The IS token
+	Operator string      // =, <>, <, >, <=, >=
+	Value    Expression
+}
+
+func (ci *CaseIs) expressionNode()      {}
+func (ci *CaseIs) TokenLiteral() string { return ci.Token.Literal }
+func (ci *CaseIs) String() string {
+	return "IS " + ci.Operator + " " + ci.Value.String()
+}
+
 func (ss *SelectStatement) statementNode()       {}
 func (ss *SelectStatement) TokenLiteral() string { return ss.Token.Literal }
 func (ss *SelectStatement) String() string {
@@ -376,6 +594,57 @@ func (ss *SelectStatement) String() string {
 	return sb.String()
 }
 
+// SelectChannelStatement represents a SELECT CHANNEL block, which waits
+// until one of several channel operations can proceed
+type SelectChannelStatement struct {
+	Token   lexer.Token
+	Cases   []*ChannelCase
+	Default *BlockStatement // CASE ELSE: runs when no channel is ready
+}
+
+func (sc *SelectChannelStatement) statementNode()       {}
+func (sc *SelectChannelStatement) TokenLiteral() string { return sc.Token.Literal }
+func (sc *SelectChannelStatement) String() string {
+	var sb strings.Builder
+	sb.WriteString("SELECT CHANNEL\n")
+	for _, c := range sc.Cases {
+		sb.WriteString(c.String())
+	}
+	if sc.Default != nil {
+		sb.WriteString("CASE ELSE\n")
+		sb.WriteString(sc.Default.String())
+	}
+	sb.WriteString("END SELECT")
+	return sb.String()
+}
+
+// ChannelCase is one arm of a SELECT CHANNEL block. Exactly one of Receive,
+// Send and Timeout is set.
+type ChannelCase struct {
+	Token   lexer.Token       // The CASE token
+	Receive *ReceiveStatement // CASE RECEIVE x FROM ch
+	Send    *SendStatement    // CASE SEND v TO ch
+	Timeout Expression        // CASE TIMEOUT ms
+	Body    *BlockStatement
+}
+
+func (cc *ChannelCase) String() string {
+	var sb strings.Builder
+	sb.WriteString("CASE ")
+	switch {
+	case cc.Receive != nil:
+		sb.WriteString(cc.Receive.String())
+	case cc.Send != nil:
+		sb.WriteString(cc.Send.String())
+	default:
+		sb.WriteString("TIMEOUT ")
+		sb.WriteString(cc.Timeout.String())
+	}
+	sb.WriteString("\n")
+	sb.WriteString(cc.Body.String())
+	return sb.String()
+}
+
 // GotoStatement represents a GOTO statement
 type GotoStatement struct {
 	Token lexer.Token
@@ -386,6 +655,17 @@ func (gs *GotoStatement) statementNode()       {}
 func (gs *GotoStatement) TokenLiteral() string { return gs.Token.Literal }
 func (gs *GotoStatement) String() string       { return "GOTO " + gs.Label }
 
+// GosubStatement represents GOSUB label, which runs the statements after
+// the label until RETURN and then continues after the GOSUB
+type GosubStatement struct {
+	Token lexer.Token
+	Label string
+}
+
+func (gs *GosubStatement) statementNode()       {}
+func (gs *GosubStatement) TokenLiteral() string { return gs.Token.Literal }
+func (gs *GosubStatement) String() string       { return "GOSUB " + gs.Label }
+
 // LabelStatement represents a label definition
 type LabelStatement struct {
 	Token lexer.Token
@@ -396,6 +676,73 @@ func (ls *LabelStatement) statementNode()       {}
 func (ls *LabelStatement) TokenLiteral() string { return ls.Token.Literal }
 func (ls *LabelStatement) String() string       { return ls.Name + ":" }
 
+// OnErrorStatement represents ON ERROR GOTO label, or ON ERROR GOTO 0 to
+// turn error trapping off
+type OnErrorStatement struct {
+	Token lexer.Token // the ON token
+	Label string      // Handler label, or "" for ON ERROR GOTO 0
+}
+
+func (os *OnErrorStatement) statementNode()       {}
+func (os *OnErrorStatement) TokenLiteral() string { return os.Token.Literal }
+func (os *OnErrorStatement) String() string {
+	if os.Label == "" {
+		return "ON ERROR GOTO 0"
+	}
+	return "ON ERROR GOTO " + os.Label
+}
+
+// ErrorStatement represents ERROR n, which raises error number n
+type ErrorStatement struct {
+	Token lexer.Token
+	Code  Expression
+}
+
+func (es *ErrorStatement) statementNode()       {}
+func (es *ErrorStatement) TokenLiteral() string { return es.Token.Literal }
+func (es *ErrorStatement) String() string       { return "ERROR " + es.Code.String() }
+
+// OptionStatement represents OPTION name, which changes how the whole
+// program is compiled
+type OptionStatement struct {
+	Token lexer.Token // the OPTION token
+	Name  string      // Option name, e.g. UNICODE
+}
+
+func (os *OptionStatement) statementNode()       {}
+func (os *OptionStatement) TokenLiteral() string { return os.Token.Literal }
+func (os *OptionStatement) String() string       { return "OPTION " + os.Name }
+
+// EmbedStatement represents EMBEDFILE "path" AS name or EMBEDDIR "path" AS
+// name, which build a file or directory into the executable
+type EmbedStatement struct {
+	Token lexer.Token // the EMBEDFILE or EMBEDDIR token
+	Path  string      // Relative to the file the statement is in
+	Name  *Identifier
+	IsDir bool
+}
+
+func (es *EmbedStatement) statementNode()       {}
+func (es *EmbedStatement) TokenLiteral() string { return es.Token.Literal }
+func (es *EmbedStatement) String() string {
+	keyword := "EMBEDFILE"
+	if es.IsDir {
+		keyword = "EMBEDDIR"
+	}
+	return keyword + " \"" + es.Path + "\" AS " + es.Name.String()
+}
+
+// CheckStatement represents CHECK err, which returns from the enclosing
+// FUNCTION with zero values and err when err is not NIL
+type CheckStatement struct {
+	Token lexer.Token // the CHECK token
+	Err   Expression
+}
+
+func (cs *CheckStatement) statementNode()       {}
+func (cs *CheckStatement) TokenLiteral() string { return cs.Token.Literal }
+func (cs *CheckStatement) String() string       { return "CHECK " + cs.Err.String() }
+
 // ReturnStatement represents a RETURN statement
 type ReturnStatement struct {
 	Token  lexer.Token
@@ -431,12 +778,14 @@ type SubStatement struct {
 	Name   *Identifier
 	Params []*Parameter
 	Body   *BlockStatement
+	Doc    string // Comment lines directly above the declaration
 }
 
 type Parameter struct {
-	Name   *Identifier
-	Type   *TypeSpec
-	ByRef  bool // Pass by reference
+	Name       *Identifier
+	Type       *TypeSpec
+	ByRef      bool // Pass by reference
+	ParamArray bool // PARAMARRAY - collects any remaining arguments
 }
 
 func (ss *SubStatement) statementNode()       {}
@@ -453,6 +802,9 @@ func (ss *SubStatement) String() string {
 		if p.ByRef {
 			sb.WriteString("BYREF ")
 		}
+		if p.ParamArray {
+			sb.WriteString("PARAMARRAY ")
+		}
 		sb.WriteString(p.Name.String())
 		sb.WriteString(" AS ")
 		sb.WriteString(p.Type.String())
@@ -470,6 +822,7 @@ type FunctionStatement struct {
 	Params      []*Parameter
 	ReturnTypes []*TypeSpec // Multiple return types
 	Body        *BlockStatement
+	Doc         string // Comment lines directly above the declaration
 }
 
 func (fs *FunctionStatement) statementNode()       {}
@@ -486,6 +839,9 @@ func (fs *FunctionStatement) String() string {
 		if p.ByRef {
 			sb.WriteString("BYREF ")
 		}
+		if p.ParamArray {
+			sb.WriteString("PARAMARRAY ")
+		}
 		sb.WriteString(p.Name.String())
 		sb.WriteString(" AS ")
 		sb.WriteString(p.Type.String())
@@ -512,13 +868,15 @@ func (fs *FunctionStatement) String() string {
 // MethodStatement represents a method definition with receiver
 // FUNCTION (recv AS POINTER TO Type) Name(params) AS ReturnType
 type MethodStatement struct {
-	Token        lexer.Token
-	ReceiverName *Identifier
-	ReceiverType *TypeSpec
-	Name         *Identifier
+	Token         lexer.Token
+	ReceiverName  *Identifier
+	ReceiverType  *TypeSpec
+	ReceiverByRef bool // BYREF recv AS Type, which is sugar for POINTER TO Type
+	Name          *Identifier
 	Params       []*Parameter
 	ReturnTypes  []*TypeSpec
 	Body         *BlockStatement
+	Doc          string // Comment lines directly above the declaration
 }
 
 func (ms *MethodStatement) statementNode()       {}
@@ -526,9 +884,16 @@ func (ms *MethodStatement) TokenLiteral() string { return ms.Token.Literal }
 func (ms *MethodStatement) String() string {
 	var sb strings.Builder
 	sb.WriteString("FUNCTION (")
-	sb.WriteString(ms.ReceiverName.String())
-	sb.WriteString(" AS ")
-	sb.WriteString(ms.ReceiverType.String())
+	if ms.ReceiverByRef {
+		sb.WriteString("BYREF ")
+		sb.WriteString(ms.ReceiverName.String())
+		sb.WriteString(" AS ")
+		sb.WriteString(ms.ReceiverType.ElementType.String())
+	} else {
+		sb.WriteString(ms.ReceiverName.String())
+		sb.WriteString(" AS ")
+		sb.WriteString(ms.ReceiverType.String())
+	}
 	sb.WriteString(") ")
 	sb.WriteString(ms.Name.String())
 	sb.WriteString("(")
@@ -570,11 +935,14 @@ type EmbeddedDeclaration struct {
 }
 
 type TypeStatement struct {
-	Token      lexer.Token
-	Name       *Identifier
-	Implements string // Go interface this type implements (e.g., "tea.Model")
-	Embedded   []*EmbeddedDeclaration
-	Fields     []*FieldDeclaration
+	Token       lexer.Token
+	Name        *Identifier
+	Implements  string // Go interface this type implements (e.g., "tea.Model")
+	Embedded    []*EmbeddedDeclaration
+	Fields      []*FieldDeclaration
+	Constructor *SubStatement // SUB New(...), run by NEW TypeName(...)
+	Properties  []*PropertyDeclaration
+	Doc         string // Comment lines directly above the declaration
 }
 
 func (ts *TypeStatement) statementNode()       {}
@@ -598,6 +966,14 @@ func (ts *TypeStatement) String() string {
 		sb.WriteString(f.String())
 		sb.WriteString("\n")
 	}
+	if ts.Constructor != nil {
+		sb.WriteString(ts.Constructor.String())
+		sb.WriteString("\n")
+	}
+	for _, prop := range ts.Properties {
+		sb.WriteString(prop.String())
+		sb.WriteString("\n")
+	}
 	sb.WriteString("END TYPE")
 	return sb.String()
 }
@@ -615,7 +991,266 @@ func (fd *FieldDeclaration) String() string {
 	return "DIM " + fd.Name.String() + " AS " + fd.Type.String()
 }
 
-// SpawnStatement represents a SPAWN statement (goroutine)
+// PropertyDeclaration represents a PROPERTY GET or PROPERTY SET block in a
+// TYPE definition. The body acts as a WITH block on the value.
+type PropertyDeclaration struct {
+	Token      lexer.Token // The PROPERTY token
+	Name       *Identifier
+	IsSet      bool
+	Param      *Parameter // SET only: receives the assigned value
+	ReturnType *TypeSpec  // GET only
+	Body       *BlockStatement
+}
+
+func (pd *PropertyDeclaration) statementNode()       {}
+func (pd *PropertyDeclaration) TokenLiteral() string { return pd.Token.Literal }
+func (pd *PropertyDeclaration) String() string {
+	var sb strings.Builder
+	if pd.IsSet {
+		sb.WriteString("PROPERTY SET ")
+		sb.WriteString(pd.Name.String())
+		sb.WriteString("(")
+		if pd.Param != nil {
+			sb.WriteString(pd.Param.Name.String())
+			sb.WriteString(" AS ")
+			sb.WriteString(pd.Param.Type.String())
+		}
+		sb.WriteString(")")
+	} else {
+		sb.WriteString("PROPERTY GET ")
+		sb.WriteString(pd.Name.String())
+		sb.WriteString("() AS ")
+		sb.WriteString(pd.ReturnType.String())
+	}
+	sb.WriteString("\n")
+	sb.WriteString(pd.Body.String())
+	sb.WriteString("END PROPERTY")
+	return sb.String()
+}
+
+// InterfaceStatement represents an INTERFACE definition (method set)
+type InterfaceStatement struct {
+	Token   lexer.Token
+	Name    *Identifier
+	Methods []*InterfaceMethod
+	Doc     string // Comment lines directly above the declaration
+}
+
+func (is *InterfaceStatement) statementNode()       {}
+func (is *InterfaceStatement) TokenLiteral() string { return is.Token.Literal }
+func (is *InterfaceStatement) String() string {
+	var sb strings.Builder
+	sb.WriteString("INTERFACE ")
+	sb.WriteString(is.Name.String())
+	sb.WriteString("\n")
+	for _, m := range is.Methods {
+		sb.WriteString("    ")
+		sb.WriteString(m.String())
+		sb.WriteString("\n")
+	}
+	sb.WriteString("END INTERFACE")
+	return sb.String()
+}
+
+// ModuleStatement represents a MODULE ... END MODULE block. Its members are
+// accessed from outside the module with qualified names (Math.Clamp).
+type ModuleStatement struct {
+	Token lexer.Token // MODULE token
+	Name  *Identifier
+	Body  []Statement // SUB, FUNCTION, DIM and CONST declarations
+	Doc   string      // Comment lines directly above the declaration
+}
+
+func (ms *ModuleStatement) statementNode()       {}
+func (ms *ModuleStatement) TokenLiteral() string { return ms.Token.Literal }
+func (ms *ModuleStatement) String() string {
+	var sb strings.Builder
+	sb.WriteString("MODULE ")
+	sb.WriteString(ms.Name.String())
+	sb.WriteString("\n")
+	for _, s := range ms.Body {
+		sb.WriteString(s.String())
+		sb.WriteString("\n")
+	}
+	sb.WriteString("END MODULE")
+	return sb.String()
+}
+
+// InterfaceMethod represents a method signature in an INTERFACE definition
+type InterfaceMethod struct {
+	Token       lexer.Token // SUB or FUNCTION
+	Name        *Identifier
+	Params      []*Parameter
+	ReturnTypes []*TypeSpec
+}
+
+func (im *InterfaceMethod) statementNode()       {}
+func (im *InterfaceMethod) TokenLiteral() string { return im.Token.Literal }
+func (im *InterfaceMethod) String() string {
+	var sb strings.Builder
+	sb.WriteString(im.Token.Literal)
+	sb.WriteString(" ")
+	sb.WriteString(im.Name.String())
+	sb.WriteString("(")
+	for i, p := range im.Params {
+		if i > 0 {
+			sb.WriteString(", ")
+		}
+		if p.ParamArray {
+			sb.WriteString("PARAMARRAY ")
+		}
+		sb.WriteString(p.Name.String())
+		sb.WriteString(" AS ")
+		sb.WriteString(p.Type.String())
+	}
+	sb.WriteString(")")
+	if len(im.ReturnTypes) > 0 {
+		sb.WriteString(" AS ")
+		if len(im.ReturnTypes) > 1 {
+			sb.WriteString("(")
+			for i, t := range im.ReturnTypes {
+				if i > 0 {
+					sb.WriteString(", ")
+				}
+				sb.WriteString(t.String())
+			}
+			sb.WriteString(")")
+		} else {
+			sb.WriteString(im.ReturnTypes[0].String())
+		}
+	}
+	return sb.String()
+}
+
+// WithStatement represents a WITH ... END WITH block
+type WithStatement struct {
+	Token  lexer.Token
+	Target Expression // Object that leading-dot members refer to
+	Body   *BlockStatement
+}
+
+func (ws *WithStatement) statementNode()       {}
+func (ws *WithStatement) TokenLiteral() string { return ws.Token.Literal }
+func (ws *WithStatement) String() string {
+	return "WITH " + ws.Target.String() + "\n" + ws.Body.String() + "END WITH"
+}
+
+// AssertStatement represents ASSERT condition [, message]
+type AssertStatement struct {
+	Token     lexer.Token
+	Condition Expression
+	Message   Expression // nil if omitted
+}
+
+func (as *AssertStatement) statementNode()       {}
+func (as *AssertStatement) TokenLiteral() string { return as.Token.Literal }
+func (as *AssertStatement) String() string {
+	if as.Message == nil {
+		return "ASSERT " + as.Condition.String()
+	}
+	return "ASSERT " + as.Condition.String() + ", " + as.Message.String()
+}
+
+// LockStatement represents a LOCK ... END LOCK block, which holds a MUTEX
+// while its body runs
+type LockStatement struct {
+	Token lexer.Token
+	Mutex Expression
+	Body  *BlockStatement
+}
+
+func (ls *LockStatement) statementNode()       {}
+func (ls *LockStatement) TokenLiteral() string { return ls.Token.Literal }
+func (ls *LockStatement) String() string {
+	return "LOCK " + ls.Mutex.String() + "\n" + ls.Body.String() + "END LOCK"
+}
+
+// TestStatement represents a TEST "name" ... END TEST block, which only
+// dbasic test runs
+type TestStatement struct {
+	Token lexer.Token // The TEST identifier
+	Name  string
+	Body  *BlockStatement
+}
+
+func (ts *TestStatement) statementNode()       {}
+func (ts *TestStatement) TokenLiteral() string { return ts.Token.Literal }
+func (ts *TestStatement) String() string {
+	return "TEST \"" + ts.Name + "\"\n" + ts.Body.String() + "END TEST"
+}
+
+// BenchmarkStatement represents a BENCHMARK "name" ... END BENCHMARK block,
+// whose body dbasic test -bench runs repeatedly to time it
+type BenchmarkStatement struct {
+	Token lexer.Token // The BENCHMARK identifier
+	Name  string
+	Body  *BlockStatement
+}
+
+func (bs *BenchmarkStatement) statementNode()       {}
+func (bs *BenchmarkStatement) TokenLiteral() string { return bs.Token.Literal }
+func (bs *BenchmarkStatement) String() string {
+	return "BENCHMARK \"" + bs.Name + "\"\n" + bs.Body.String() + "END BENCHMARK"
+}
+
+// DeclareStatement represents a line of a .dbasi declaration file, which
+// gives the type of a member of a Go package without defining it:
+// DECLARE PACKAGE "path", DECLARE TYPE Name, DECLARE CONST Name AS T,
+// DECLARE DIM Name AS T, DECLARE SUB Name(params) or
+// DECLARE FUNCTION Name(params) AS T
+type DeclareStatement struct {
+	Token       lexer.Token // The DECLARE identifier
+	Kind        string      // PACKAGE, TYPE, CONST, DIM, SUB or FUNCTION
+	Path        string      // For PACKAGE, the import path
+	Name        *Identifier // Member name, exactly as in Go
+	Params      []*Parameter
+	ReturnTypes []*TypeSpec // For FUNCTION
+	Type        *TypeSpec   // For CONST and DIM
+}
+
+func (ds *DeclareStatement) statementNode()       {}
+func (ds *DeclareStatement) TokenLiteral() string { return ds.Token.Literal }
+func (ds *DeclareStatement) String() string {
+	var sb strings.Builder
+	sb.WriteString("DECLARE ")
+	sb.WriteString(ds.Kind)
+	switch ds.Kind {
+	case "PACKAGE":
+		sb.WriteString(" \"" + ds.Path + "\"")
+		return sb.String()
+	case "TYPE":
+		return sb.String() + " " + ds.Name.String()
+	case "CONST", "DIM":
+		return sb.String() + " " + ds.Name.String() + " AS " + ds.Type.String()
+	}
+	sb.WriteString(" " + ds.Name.String() + "(")
+	for i, p := range ds.Params {
+		if i > 0 {
+			sb.WriteString(", ")
+		}
+		if p.ParamArray {
+			sb.WriteString("PARAMARRAY ")
+		}
+		sb.WriteString(p.Name.String())
+		sb.WriteString(" AS ")
+		sb.WriteString(p.Type.String())
+	}
+	sb.WriteString(")")
+	if len(ds.ReturnTypes) > 1 {
+		sb.WriteString(" AS (")
+		for i, t := range ds.ReturnTypes {
+			if i > 0 {
+				sb.WriteString(", ")
+			}
+			sb.WriteString(t.String())
+		}
+		sb.WriteString(")")
+	} else if len(ds.ReturnTypes) == 1 {
+		sb.WriteString(" AS " + ds.ReturnTypes[0].String())
+	}
+	return sb.String()
+}
+
 type SpawnStatement struct {
 	Token lexer.Token
 	Call  *CallExpression
@@ -641,13 +1276,16 @@ func (ss *SendStatement) String() string {
 // ReceiveStatement represents a RECEIVE ... FROM ... statement
 type ReceiveStatement struct {
 	Token    lexer.Token
-	Variable Expression
+	Variable Expression // nil for RECEIVE FROM ch, which discards the value
 	Channel  Expression
 }
 
 func (rs *ReceiveStatement) statementNode()       {}
 func (rs *ReceiveStatement) TokenLiteral() string { return rs.Token.Literal }
 func (rs *ReceiveStatement) String() string {
+	if rs.Variable == nil {
+		return "RECEIVE FROM " + rs.Channel.String()
+	}
 	return "RECEIVE " + rs.Variable.String() + " FROM " + rs.Channel.String()
 }
 
@@ -726,6 +1364,29 @@ func (sl *StringLiteral) expressionNode()      {}
 func (sl *StringLiteral) TokenLiteral() string { return sl.Token.Literal }
 func (sl *StringLiteral) String() string       { return "\"" + sl.Value + "\"" }
 
+// InterpolatedString represents $"text {expr} text". Parts holds a
+// StringLiteral for each run of text and the embedded expressions between.
+type InterpolatedString struct {
+	Token lexer.Token
+	Parts []Expression
+}
+
+func (is *InterpolatedString) expressionNode()      {}
+func (is *InterpolatedString) TokenLiteral() string { return is.Token.Literal }
+func (is *InterpolatedString) String() string {
+	var sb strings.Builder
+	sb.WriteString("$\"")
+	for _, part := range is.Parts {
+		if text, ok := part.(*StringLiteral); ok {
+			sb.WriteString(strings.NewReplacer("{", "{{", "}", "}}").Replace(text.Value))
+		} else {
+			sb.WriteString("{" + part.String() + "}")
+		}
+	}
+	sb.WriteString("\"")
+	return sb.String()
+}
+
 // ByteStringLiteral represents a byte string literal (B"...")
 type ByteStringLiteral struct {
 	Token lexer.Token
@@ -736,6 +1397,16 @@ func (bs *ByteStringLiteral) expressionNode()      {}
 func (bs *ByteStringLiteral) TokenLiteral() string { return bs.Token.Literal }
 func (bs *ByteStringLiteral) String() string       { return "B\"" + bs.Value + "\"" }
 
+// DateTimeLiteral represents a DATETIME literal such as #2024-01-15 09:30#
+type DateTimeLiteral struct {
+	Token lexer.Token
+	Value time.Time // Wall-clock time; the local time zone is applied at run time
+}
+
+func (dl *DateTimeLiteral) expressionNode()      {}
+func (dl *DateTimeLiteral) TokenLiteral() string { return dl.Token.Literal }
+func (dl *DateTimeLiteral) String() string       { return "#" + dl.Token.Literal + "#" }
+
 // BooleanLiteral represents a boolean literal
 type BooleanLiteral struct {
 	Token lexer.Token
@@ -792,6 +1463,25 @@ func (al *ArrayLiteral) String() string {
 	return "[" + strings.Join(elements, ", ") + "]"
 }
 
+// RangeExpression represents start TO end [STEP step] after FOR EACH ... IN,
+// the INTEGER slice of the values from start to end inclusive
+type RangeExpression struct {
+	Token lexer.Token // the TO token
+	Start Expression
+	End   Expression
+	Step  Expression // nil for a step of 1
+}
+
+func (re *RangeExpression) expressionNode()      {}
+func (re *RangeExpression) TokenLiteral() string { return re.Token.Literal }
+func (re *RangeExpression) String() string {
+	s := re.Start.String() + " TO " + re.End.String()
+	if re.Step != nil {
+		s += " STEP " + re.Step.String()
+	}
+	return s
+}
+
 // StructLiteral represents a struct literal (TypeName{field: value, ...})
 type StructLiteral struct {
 	Token    lexer.Token
@@ -870,6 +1560,24 @@ func (ce *CallExpression) String() string {
 	return ce.Function.String() + "(" + strings.Join(args, ", ") + ")"
 }
 
+// NewExpression represents NEW TypeName(args), which builds a value of a
+// TYPE by running its SUB New constructor
+type NewExpression struct {
+	Token     lexer.Token // NEW
+	TypeName  *Identifier
+	Arguments []Expression
+}
+
+func (ne *NewExpression) expressionNode()      {}
+func (ne *NewExpression) TokenLiteral() string { return ne.Token.Literal }
+func (ne *NewExpression) String() string {
+	var args []string
+	for _, a := range ne.Arguments {
+		args = append(args, a.String())
+	}
+	return "NEW " + ne.TypeName.String() + "(" + strings.Join(args, ", ") + ")"
+}
+
 // IndexExpression represents array/slice indexing or slicing
 type IndexExpression struct {
 	Token   lexer.Token
@@ -909,6 +1617,42 @@ func (me *MemberExpression) String() string {
 	return me.Object.String() + "." + me.Member.String()
 }
 
+// FieldListExpression represents obj.{A, B}, which unpacks several fields
+// on the right of a multiple assignment: a, b = obj.{A, B}
+type FieldListExpression struct {
+	Token  lexer.Token // The '{' token
+	Object Expression
+	Fields []*Identifier
+}
+
+func (fl *FieldListExpression) expressionNode()      {}
+func (fl *FieldListExpression) TokenLiteral() string { return fl.Token.Literal }
+func (fl *FieldListExpression) String() string {
+	var fields []string
+	for _, f := range fl.Fields {
+		fields = append(fields, f.String())
+	}
+	return fl.Object.String() + ".{" + strings.Join(fields, ", ") + "}"
+}
+
+// Members returns obj.Field for each listed field
+func (fl *FieldListExpression) Members() []*MemberExpression {
+	var members []*MemberExpression
+	for _, f := range fl.Fields {
+		members = append(members, &MemberExpression{Token: fl.Token, Object: fl.Object, Member: f})
+	}
+	return members
+}
+
+// WithTargetExpression is the implicit object of a leading-dot member (.Field) inside WITH
+type WithTargetExpression struct {
+	Token lexer.Token // The '.' token
+}
+
+func (wt *WithTargetExpression) expressionNode()      {}
+func (wt *WithTargetExpression) TokenLiteral() string { return wt.Token.Literal }
+func (wt *WithTargetExpression) String() string       { return "" }
+
 // TypeAssertionExpression represents a type assertion: value.(Type)
 type TypeAssertionExpression struct {
 	Token      lexer.Token // The '.' token
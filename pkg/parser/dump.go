@@ -0,0 +1,170 @@
+package parser
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/zditech/dbasic/pkg/lexer"
+)
+
+// dumpNode is a generic view of an AST node, for Dump and DumpJSON
+type dumpNode struct {
+	Node   string
+	Line   int
+	Column int
+	Fields []dumpField
+}
+
+type dumpField struct {
+	Name  string
+	Value interface{} // *dumpNode, []interface{}, map, or a plain value
+}
+
+var tokenType = reflect.TypeOf(lexer.Token{})
+
+// toDump converts a value from the AST. Token fields become the node's
+// position, and nil is returned for empty values, which are left out.
+// Numbers and literal Values are never empty.
+func toDump(v reflect.Value) interface{} {
+	switch v.Kind() {
+	case reflect.Interface, reflect.Ptr:
+		if v.IsNil() {
+			return nil
+		}
+		return toDump(v.Elem())
+	case reflect.Slice:
+		if v.Len() == 0 {
+			return nil
+		}
+		items := make([]interface{}, v.Len())
+		for i := range items {
+			items[i] = toDump(v.Index(i))
+		}
+		return items
+	case reflect.Map:
+		if v.Len() == 0 {
+			return nil
+		}
+		m := make(map[string]interface{}, v.Len())
+		for _, k := range v.MapKeys() {
+			m[fmt.Sprint(k.Interface())] = toDump(v.MapIndex(k))
+		}
+		return m
+	case reflect.Struct:
+		if v.Type() == tokenType {
+			return v.Interface().(lexer.Token).Literal
+		}
+		if v.Type().PkgPath() != tokenType.PkgPath() && v.Type().PkgPath() != reflect.TypeOf(Program{}).PkgPath() {
+			// Values such as time.Time print as themselves
+			return fmt.Sprint(v.Interface())
+		}
+		n := &dumpNode{Node: v.Type().Name()}
+		for i := 0; i < v.NumField(); i++ {
+			f := v.Type().Field(i)
+			if !f.IsExported() {
+				continue
+			}
+			if f.Name == "Token" && f.Type == tokenType {
+				tok := v.Field(i).Interface().(lexer.Token)
+				n.Line, n.Column = tok.Line, tok.Column
+				continue
+			}
+			fv := toDump(v.Field(i))
+			if fv == nil && f.Name == "Value" && v.Field(i).Kind() == reflect.String {
+				fv = ""
+			}
+			if fv != nil {
+				n.Fields = append(n.Fields, dumpField{Name: f.Name, Value: fv})
+			}
+		}
+		return n
+	case reflect.String:
+		if v.Len() == 0 {
+			return nil
+		}
+		return v.String()
+	case reflect.Bool:
+		if !v.Bool() {
+			return nil
+		}
+		return true
+	}
+	return v.Interface()
+}
+
+// Dump returns an indented tree of an AST node and everything below it,
+// for debugging the parser
+func Dump(node Node) string {
+	var sb strings.Builder
+	writeDump(&sb, toDump(reflect.ValueOf(node)), 0)
+	return sb.String()
+}
+
+func writeDump(sb *strings.Builder, v interface{}, depth int) {
+	indent := strings.Repeat("  ", depth)
+	switch v := v.(type) {
+	case *dumpNode:
+		sb.WriteString(v.Node)
+		if v.Line > 0 {
+			fmt.Fprintf(sb, " @%d:%d", v.Line, v.Column)
+		}
+		sb.WriteString("\n")
+		for _, f := range v.Fields {
+			fmt.Fprintf(sb, "%s  %s: ", indent, f.Name)
+			writeDump(sb, f.Value, depth+1)
+		}
+	case []interface{}:
+		fmt.Fprintf(sb, "(%d)\n", len(v))
+		for i, item := range v {
+			fmt.Fprintf(sb, "%s  [%d] ", indent, i)
+			writeDump(sb, item, depth+1)
+		}
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		fmt.Fprintf(sb, "(%d)\n", len(v))
+		for _, k := range keys {
+			fmt.Fprintf(sb, "%s  %q: ", indent, k)
+			writeDump(sb, v[k], depth+1)
+		}
+	case string:
+		fmt.Fprintf(sb, "%q\n", v)
+	default:
+		fmt.Fprintf(sb, "%v\n", v)
+	}
+}
+
+// DumpJSON returns an AST node as JSON. Each node is an object with a
+// "node" type name, its "line" and "column", and its non-empty fields,
+// named in camel case.
+func DumpJSON(node Node) ([]byte, error) {
+	return json.MarshalIndent(toDump(reflect.ValueOf(node)), "", "  ")
+}
+
+// MarshalJSON writes a node's fields in declaration order
+func (n *dumpNode) MarshalJSON() ([]byte, error) {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, `{"node":%q`, n.Node)
+	if n.Line > 0 {
+		fmt.Fprintf(&sb, `,"line":%d,"column":%d`, n.Line, n.Column)
+	}
+	for _, f := range n.Fields {
+		value, err := json.Marshal(f.Value)
+		if err != nil {
+			return nil, err
+		}
+		key, _ := json.Marshal(strings.ToLower(f.Name[:1]) + f.Name[1:])
+		sb.WriteString(",")
+		sb.Write(key)
+		sb.WriteString(":")
+		sb.Write(value)
+	}
+	sb.WriteString("}")
+	return []byte(sb.String()), nil
+}
@@ -4,13 +4,16 @@ import (
 	"fmt"
 	"strconv"
 	"strings"
+	"time"
 
+	dberrors "github.com/zditech/dbasic/pkg/errors"
 	"github.com/zditech/dbasic/pkg/lexer"
 )
 
 // Operator precedence levels
 const (
 	LOWEST int = iota
+	COALESCE_PREC
 	OR_PREC
 	AND_PREC
 	NOT_PREC
@@ -25,6 +28,7 @@ const (
 )
 
 var precedences = map[lexer.TokenType]int{
+	lexer.TOKEN_COALESCE:  COALESCE_PREC,
 	lexer.TOKEN_OR:        OR_PREC,
 	lexer.TOKEN_XOR:       OR_PREC,
 	lexer.TOKEN_AND:       AND_PREC,
@@ -35,6 +39,8 @@ var precedences = map[lexer.TokenType]int{
 	lexer.TOKEN_GT:        LESSGREATER,
 	lexer.TOKEN_LTE:       LESSGREATER,
 	lexer.TOKEN_GTE:       LESSGREATER,
+	lexer.TOKEN_LIKE:      LESSGREATER,
+	lexer.TOKEN_IN:        LESSGREATER,
 	lexer.TOKEN_PLUS:      SUM,
 	lexer.TOKEN_MINUS:     SUM,
 	lexer.TOKEN_AMPERSAND: SUM,
@@ -63,13 +69,18 @@ type Parser struct {
 
 	prefixParseFns map[lexer.TokenType]prefixParseFn
 	infixParseFns  map[lexer.TokenType]infixParseFn
+
+	withDepth int // Nesting depth of WITH blocks (enables leading-dot members)
+
+	comments map[int]string // Comments on lines of their own, by line
 }
 
-// formatError creates a formatted error message with source context
-func (p *Parser) formatError(line, column int, message string, hint string) string {
+// formatError creates a formatted error message with its code, one of the
+// parse codes in package errors, and source context
+func (p *Parser) formatError(line, column int, code string, message string, hint string) string {
 	var sb strings.Builder
 
-	sb.WriteString(fmt.Sprintf("parse error at line %d", line))
+	sb.WriteString(fmt.Sprintf("parse error[%s] at line %d", code, line))
 	if column > 0 {
 		sb.WriteString(fmt.Sprintf(", column %d", column))
 	}
@@ -105,9 +116,13 @@ func New(l *lexer.Lexer) *Parser {
 
 	p.prefixParseFns = make(map[lexer.TokenType]prefixParseFn)
 	p.registerPrefix(lexer.TOKEN_IDENT, p.parseIdentifier)
+	p.registerPrefix(lexer.TOKEN_IN, p.parseOperatorWordName)
+	p.registerPrefix(lexer.TOKEN_LIKE, p.parseOperatorWordName)
 	p.registerPrefix(lexer.TOKEN_INT, p.parseIntegerLiteral)
 	p.registerPrefix(lexer.TOKEN_FLOAT, p.parseFloatLiteral)
 	p.registerPrefix(lexer.TOKEN_STRING, p.parseStringLiteral)
+	p.registerPrefix(lexer.TOKEN_INTERP_STR, p.parseInterpolatedString)
+	p.registerPrefix(lexer.TOKEN_DATE, p.parseDateTimeLiteral)
 	p.registerPrefix(lexer.TOKEN_BYTE_STRING, p.parseByteStringLiteral)
 	p.registerPrefix(lexer.TOKEN_TRUE, p.parseBooleanLiteral)
 	p.registerPrefix(lexer.TOKEN_FALSE, p.parseBooleanLiteral)
@@ -120,6 +135,7 @@ func New(l *lexer.Lexer) *Parser {
 	p.registerPrefix(lexer.TOKEN_AT, p.parseAddressOf)
 	p.registerPrefix(lexer.TOKEN_CARET, p.parseDereference)
 	p.registerPrefix(lexer.TOKEN_MAKE_CHAN, p.parseMakeChan)
+	p.registerPrefix(lexer.TOKEN_DOT, p.parseWithMember)
 
 	p.infixParseFns = make(map[lexer.TokenType]infixParseFn)
 	p.registerInfix(lexer.TOKEN_PLUS, p.parseInfixExpression)
@@ -139,6 +155,9 @@ func New(l *lexer.Lexer) *Parser {
 	p.registerInfix(lexer.TOKEN_AND, p.parseInfixExpression)
 	p.registerInfix(lexer.TOKEN_OR, p.parseInfixExpression)
 	p.registerInfix(lexer.TOKEN_XOR, p.parseInfixExpression)
+	p.registerInfix(lexer.TOKEN_LIKE, p.parseInfixExpression)
+	p.registerInfix(lexer.TOKEN_IN, p.parseInfixExpression)
+	p.registerInfix(lexer.TOKEN_COALESCE, p.parseInfixExpression)
 	p.registerInfix(lexer.TOKEN_LPAREN, p.parseCallExpression)
 	p.registerInfix(lexer.TOKEN_LBRACKET, p.parseIndexExpression)
 	p.registerInfix(lexer.TOKEN_DOT, p.parseMemberExpression)
@@ -161,6 +180,28 @@ func (p *Parser) registerInfix(tokenType lexer.TokenType, fn infixParseFn) {
 func (p *Parser) nextToken() {
 	p.curToken = p.peekToken
 	p.peekToken = p.l.NextToken()
+
+	// Keep whole-line comments for the declarations they document
+	if p.peekToken.Type == lexer.TOKEN_COMMENT && (p.curToken.Type == lexer.TOKEN_NEWLINE || p.curToken.Line == 0) {
+		if p.comments == nil {
+			p.comments = make(map[int]string)
+		}
+		p.comments[p.peekToken.Line] = p.peekToken.Literal
+	}
+}
+
+// docComment returns the block of whole-line comments directly above line,
+// one comment per line
+func (p *Parser) docComment(line int) string {
+	var lines []string
+	for l := line - 1; ; l-- {
+		text, ok := p.comments[l]
+		if !ok {
+			break
+		}
+		lines = append([]string{text}, lines...)
+	}
+	return strings.Join(lines, "\n")
 }
 
 func (p *Parser) curTokenIs(t lexer.TokenType) bool {
@@ -180,6 +221,49 @@ func (p *Parser) expectPeek(t lexer.TokenType) bool {
 	return false
 }
 
+// curIsWord reports whether the current token is the identifier word, for
+// keywords such as LOCK that are names outside their own statements
+func (p *Parser) curIsWord(word string) bool {
+	return p.curTokenIs(lexer.TOKEN_IDENT) && strings.EqualFold(p.curToken.Literal, word)
+}
+
+// peekIsWord reports whether the next token is the identifier word
+func (p *Parser) peekIsWord(word string) bool {
+	return p.peekTokenIs(lexer.TOKEN_IDENT) && strings.EqualFold(p.peekToken.Literal, word)
+}
+
+// expectPeekWord is expectPeek for a keyword that is lexed as an identifier,
+// such as LOCK in END LOCK
+func (p *Parser) expectPeekWord(word string) bool {
+	if p.peekIsWord(word) {
+		p.nextToken()
+		return true
+	}
+	msg := p.formatError(p.peekToken.Line, p.peekToken.Column, dberrors.MissingToken,
+		fmt.Sprintf("expected %s, got %s instead", word, p.peekToken.Type),
+		"close the block with END "+word)
+	p.errors = append(p.errors, msg)
+	return false
+}
+
+// peekIsWithMember reports whether the next token is the dot of a WITH
+// member, as in WITH .Home, and not of a member of the current token, as in
+// lock.Owner
+func (p *Parser) peekIsWithMember() bool {
+	return p.peekTokenIs(lexer.TOKEN_DOT) && p.peekToken.Column > p.curToken.Column+len(p.curToken.Literal)
+}
+
+// peekStartsCondition reports whether the next token can start the
+// condition of an ASSERT. After (, [ or . it is a call, index or member of
+// something named Assert, and after = an assignment to it.
+func (p *Parser) peekStartsCondition() bool {
+	switch p.peekToken.Type {
+	case lexer.TOKEN_LPAREN, lexer.TOKEN_LBRACKET, lexer.TOKEN_DOT:
+		return false
+	}
+	return p.prefixParseFns[p.peekToken.Type] != nil
+}
+
 func (p *Parser) peekError(t lexer.TokenType) {
 	var hint string
 	switch t {
@@ -193,11 +277,14 @@ func (p *Parser) peekError(t lexer.TokenType) {
 		hint = "use = for assignment"
 	case lexer.TOKEN_IDENT:
 		hint = "expected an identifier (variable or function name)"
+	case lexer.TOKEN_IN:
+		hint = "FOR EACH loops use the form FOR EACH item IN collection"
 	}
 
 	msg := p.formatError(
 		p.peekToken.Line,
 		p.peekToken.Column,
+		dberrors.MissingToken,
 		fmt.Sprintf("expected %s, got %s instead", t, p.peekToken.Type),
 		hint,
 	)
@@ -274,12 +361,24 @@ func (p *Parser) parseStatement() Statement {
 	case lexer.TOKEN_IF:
 		return p.parseIfStatement()
 	case lexer.TOKEN_FOR:
-		return p.parseForStatement()
+		// EACH is only a keyword when the loop variable follows it, so
+		// FOR each = 1 TO n counts with a variable named each
+		forToken := p.curToken
+		if !p.expectPeek(lexer.TOKEN_IDENT) {
+			return nil
+		}
+		if p.curIsWord("EACH") && p.peekTokenIs(lexer.TOKEN_IDENT) {
+			return p.parseForEachStatement(forToken)
+		}
+		return p.parseForStatement(forToken)
 	case lexer.TOKEN_WHILE:
 		return p.parseWhileStatement()
 	case lexer.TOKEN_DO:
 		return p.parseDoLoopStatement()
 	case lexer.TOKEN_SELECT:
+		if p.peekTokenIs(lexer.TOKEN_CHANNEL) {
+			return p.parseSelectChannelStatement()
+		}
 		return p.parseSelectStatement()
 	case lexer.TOKEN_TYPE:
 		return p.parseTypeStatement()
@@ -293,17 +392,86 @@ func (p *Parser) parseStatement() Statement {
 		return p.parseExitStatement()
 	case lexer.TOKEN_GOTO:
 		return p.parseGotoStatement()
+	case lexer.TOKEN_GOSUB:
+		return p.parseGosubStatement()
 	case lexer.TOKEN_SPAWN:
 		return p.parseSpawnStatement()
 	case lexer.TOKEN_SEND:
 		return p.parseSendStatement()
 	case lexer.TOKEN_RECEIVE:
 		return p.parseReceiveStatement()
+	case lexer.TOKEN_ERROR_TYPE:
+		return p.parseErrorStatement()
 	case lexer.TOKEN_IDENT:
 		// Check if it's a label (identifier followed by colon)
 		if p.peekTokenIs(lexer.TOKEN_COLON) {
 			return p.parseLabelStatement()
 		}
+		// ON is only a keyword in ON ERROR GOTO
+		if strings.EqualFold(p.curToken.Literal, "ON") && p.peekTokenIs(lexer.TOKEN_ERROR_TYPE) {
+			return p.parseOnErrorStatement()
+		}
+		// TEST is only a keyword when a test name follows it
+		if strings.EqualFold(p.curToken.Literal, "TEST") && p.peekTokenIs(lexer.TOKEN_STRING) {
+			return p.parseTestStatement()
+		}
+		// BENCHMARK is only a keyword when a benchmark name follows it
+		if strings.EqualFold(p.curToken.Literal, "BENCHMARK") && p.peekTokenIs(lexer.TOKEN_STRING) {
+			return p.parseBenchmarkStatement()
+		}
+		// DECLARE is only a keyword in .dbasi declaration files
+		if strings.EqualFold(p.curToken.Literal, "DECLARE") && p.isDeclareKind(p.peekToken) {
+			return p.parseDeclareStatement()
+		}
+		// OPTION is only a keyword when an option name follows it
+		if strings.EqualFold(p.curToken.Literal, "OPTION") && p.peekTokenIs(lexer.TOKEN_IDENT) {
+			return p.parseOptionStatement()
+		}
+		// EMBEDFILE and EMBEDDIR are only keywords when a path follows them
+		if (strings.EqualFold(p.curToken.Literal, "EMBEDFILE") || strings.EqualFold(p.curToken.Literal, "EMBEDDIR")) && p.peekTokenIs(lexer.TOKEN_STRING) {
+			return p.parseEmbedStatement()
+		}
+		// CHECK is only a keyword when an error value follows it
+		if strings.EqualFold(p.curToken.Literal, "CHECK") && p.peekTokenIs(lexer.TOKEN_IDENT) {
+			return p.parseCheckStatement()
+		}
+		// STATIC, INTERFACE, MODULE, WITH and LOCK are only keywords when a
+		// name follows them, and ASSERT when a condition does
+		if p.peekTokenIs(lexer.TOKEN_IDENT) {
+			switch strings.ToUpper(p.curToken.Literal) {
+			case "STATIC":
+				return p.parseDimStatement()
+			case "INTERFACE":
+				return p.parseInterfaceStatement()
+			case "MODULE":
+				return p.parseModuleStatement()
+			}
+		}
+		if p.peekTokenIs(lexer.TOKEN_IDENT) || p.peekIsWithMember() {
+			switch strings.ToUpper(p.curToken.Literal) {
+			case "WITH":
+				return p.parseWithStatement()
+			case "LOCK":
+				return p.parseLockStatement()
+			}
+		}
+		if p.curIsWord("ASSERT") && p.peekStartsCondition() {
+			return p.parseAssertStatement()
+		}
+		// OPEN, CLOSE and LINE INPUT work on file numbers
+		if strings.EqualFold(p.curToken.Literal, "OPEN") && (p.peekTokenIs(lexer.TOKEN_STRING) || p.peekTokenIs(lexer.TOKEN_IDENT)) {
+			return p.parseOpenStatement()
+		}
+		if strings.EqualFold(p.curToken.Literal, "CLOSE") && (p.peekTokenIs(lexer.TOKEN_HASH) || p.peekTokenIs(lexer.TOKEN_NEWLINE) ||
+			p.peekTokenIs(lexer.TOKEN_COMMENT) || p.peekTokenIs(lexer.TOKEN_EOF)) {
+			return p.parseCloseStatement()
+		}
+		if strings.EqualFold(p.curToken.Literal, "LINE") && p.peekTokenIs(lexer.TOKEN_INPUT) {
+			return p.parseLineInputStatement()
+		}
+		if (strings.EqualFold(p.curToken.Literal, "GET") || strings.EqualFold(p.curToken.Literal, "PUT")) && p.peekTokenIs(lexer.TOKEN_HASH) {
+			return p.parseGetPutStatement()
+		}
 		// Otherwise it's an assignment or expression
 		return p.parseAssignmentOrExpression()
 	case lexer.TOKEN_LPAREN:
@@ -314,6 +482,9 @@ func (p *Parser) parseStatement() Statement {
 		// Could be a dereference that's assigned to
 		// e.g., ^ptr = value
 		return p.parseAssignmentOrExpression()
+	case lexer.TOKEN_DOT:
+		// Leading-dot member inside WITH, e.g., .Name = value
+		return p.parseAssignmentOrExpression()
 	default:
 		return p.parseExpressionStatement()
 	}
@@ -347,7 +518,7 @@ func (p *Parser) parseImportStatement() *ImportStatement {
 }
 
 func (p *Parser) parseDimStatement() *DimStatement {
-	stmt := &DimStatement{Token: p.curToken}
+	stmt := &DimStatement{Token: p.curToken, Static: p.curIsWord("STATIC")}
 
 	if !p.expectPeek(lexer.TOKEN_IDENT) {
 		return nil
@@ -360,6 +531,13 @@ func (p *Parser) parseDimStatement() *DimStatement {
 		p.nextToken()
 		p.nextToken()
 		stmt.ArraySize = p.parseExpression(LOWEST)
+		// DIM a(lower TO upper)
+		if p.peekTokenIs(lexer.TOKEN_TO) {
+			p.nextToken()
+			p.nextToken()
+			stmt.LowerBound = stmt.ArraySize
+			stmt.ArraySize = p.parseExpression(LOWEST)
+		}
 		if !p.expectPeek(lexer.TOKEN_RPAREN) {
 			return nil
 		}
@@ -401,7 +579,11 @@ func (p *Parser) parseLetStatement() *LetStatement {
 	return stmt
 }
 
-func (p *Parser) parseConstStatement() *ConstStatement {
+func (p *Parser) parseConstStatement() Statement {
+	if p.peekTokenIs(lexer.TOKEN_NEWLINE) || p.peekTokenIs(lexer.TOKEN_COMMENT) {
+		return p.parseConstGroup()
+	}
+
 	stmt := &ConstStatement{Token: p.curToken}
 
 	if !p.expectPeek(lexer.TOKEN_IDENT) {
@@ -427,6 +609,40 @@ func (p *Parser) parseConstStatement() *ConstStatement {
 	return stmt
 }
 
+// parseConstGroup parses a CONST ... END CONST block, one constant per line
+// with an optional = value
+func (p *Parser) parseConstGroup() Statement {
+	stmt := &ConstGroupStatement{Token: p.curToken}
+
+	p.nextToken()
+	p.skipNewlines()
+
+	for !(p.curTokenIs(lexer.TOKEN_END) && p.peekTokenIs(lexer.TOKEN_CONST)) {
+		if !p.curTokenIs(lexer.TOKEN_IDENT) {
+			msg := p.formatError(p.curToken.Line, p.curToken.Column, dberrors.MissingToken,
+				"expected a constant name or END CONST in CONST block, got "+p.curToken.Literal,
+				"list one constant per line, e.g. Red or Red = 1")
+			p.errors = append(p.errors, msg)
+			return nil
+		}
+		stmt.Names = append(stmt.Names, &Identifier{Token: p.curToken, Value: p.curToken.Literal})
+
+		var value Expression
+		if p.peekTokenIs(lexer.TOKEN_ASSIGN) {
+			p.nextToken()
+			p.nextToken()
+			value = p.parseExpression(LOWEST)
+		}
+		stmt.Values = append(stmt.Values, value)
+
+		p.nextToken()
+		p.skipNewlines()
+	}
+	p.nextToken() // consume CONST
+
+	return stmt
+}
+
 func (p *Parser) parseTypeSpec() *TypeSpec {
 	spec := &TypeSpec{Token: p.curToken}
 
@@ -457,12 +673,80 @@ func (p *Parser) parseTypeSpec() *TypeSpec {
 		}
 		p.nextToken()
 		spec.ElementType = p.parseTypeSpec()
+	case lexer.TOKEN_FUNCTION, lexer.TOKEN_SUB:
+		// Function type: FUNCTION(INTEGER, STRING) AS BOOLEAN or SUB(STRING)
+		spec.IsFunction = true
+		spec.Name = strings.ToUpper(p.curToken.Literal)
+		if !p.expectPeek(lexer.TOKEN_LPAREN) {
+			return nil
+		}
+		for !p.peekTokenIs(lexer.TOKEN_RPAREN) {
+			p.nextToken()
+			// Parameter names are optional: FUNCTION(s AS STRING) or FUNCTION(STRING)
+			if p.curTokenIs(lexer.TOKEN_IDENT) && p.peekTokenIs(lexer.TOKEN_AS) {
+				p.nextToken()
+				p.nextToken()
+			}
+			spec.ParamTypes = append(spec.ParamTypes, p.parseTypeSpec())
+			if !p.peekTokenIs(lexer.TOKEN_COMMA) {
+				break
+			}
+			p.nextToken()
+		}
+		if !p.expectPeek(lexer.TOKEN_RPAREN) {
+			return nil
+		}
+		if spec.Name == "FUNCTION" {
+			if !p.expectPeek(lexer.TOKEN_AS) {
+				return nil
+			}
+			p.nextToken()
+			// Check for multiple return types
+			if p.curTokenIs(lexer.TOKEN_LPAREN) {
+				p.nextToken()
+				for !p.curTokenIs(lexer.TOKEN_RPAREN) && !p.curTokenIs(lexer.TOKEN_EOF) {
+					spec.ReturnTypes = append(spec.ReturnTypes, p.parseTypeSpec())
+					if p.peekTokenIs(lexer.TOKEN_COMMA) {
+						p.nextToken()
+					}
+					p.nextToken()
+				}
+			} else {
+				spec.ReturnTypes = append(spec.ReturnTypes, p.parseTypeSpec())
+			}
+		}
 	case lexer.TOKEN_ANY:
 		spec.Name = "ANY"
 	case lexer.TOKEN_ERROR_TYPE:
 		spec.Name = "ERROR"
 	default:
 		typeName := p.curToken.Literal
+		// MAP OF K TO V - MAP is only special in type position
+		if strings.ToUpper(typeName) == "MAP" && p.peekTokenIs(lexer.TOKEN_OF) {
+			spec.IsMap = true
+			spec.Name = "MAP"
+			p.nextToken() // consume OF
+			p.nextToken()
+			spec.KeyType = p.parseTypeSpec()
+			if !p.expectPeek(lexer.TOKEN_TO) {
+				return nil
+			}
+			p.nextToken()
+			spec.ElementType = p.parseTypeSpec()
+			return spec
+		}
+		// STACK OF T, QUEUE OF T and SET OF T - also only special in type position
+		switch strings.ToUpper(typeName) {
+		case "STACK", "QUEUE", "SET":
+			if p.peekTokenIs(lexer.TOKEN_OF) {
+				spec.IsContainer = true
+				spec.Name = strings.ToUpper(typeName)
+				p.nextToken() // consume OF
+				p.nextToken()
+				spec.ElementType = p.parseTypeSpec()
+				return spec
+			}
+		}
 		// Check for package.Type syntax (e.g., tea.Model)
 		if p.peekTokenIs(lexer.TOKEN_DOT) {
 			p.nextToken() // consume dot
@@ -472,6 +756,12 @@ func (p *Parser) parseTypeSpec() *TypeSpec {
 		} else {
 			spec.Name = strings.ToUpper(typeName)
 		}
+		// Fixed-length string: STRING * n
+		if spec.Name == "STRING" && p.peekTokenIs(lexer.TOKEN_ASTERISK) {
+			p.nextToken()
+			p.nextToken()
+			spec.Width = p.parseExpression(PRODUCT)
+		}
 	}
 
 	return spec
@@ -484,6 +774,19 @@ func (p *Parser) parsePrintStatement() *PrintStatement {
 
 	p.nextToken()
 
+	// PRINT #n, values writes to an open file
+	if p.curTokenIs(lexer.TOKEN_HASH) {
+		stmt.FileNumber = p.parseFileNumber()
+		if stmt.FileNumber == nil {
+			return nil
+		}
+		if !p.peekTokenIs(lexer.TOKEN_COMMA) {
+			return stmt
+		}
+		p.nextToken()
+		p.nextToken()
+	}
+
 	// Empty PRINT
 	if p.curTokenIs(lexer.TOKEN_NEWLINE) || p.curTokenIs(lexer.TOKEN_EOF) {
 		return stmt
@@ -516,14 +819,17 @@ func (p *Parser) parseInputStatement() *InputStatement {
 	// Check for optional prompt
 	if p.curTokenIs(lexer.TOKEN_STRING) {
 		stmt.Prompt = &StringLiteral{Token: p.curToken, Value: p.curToken.Literal}
-		if !p.expectPeek(lexer.TOKEN_SEMICOLON) && !p.expectPeek(lexer.TOKEN_COMMA) {
+		if !p.peekTokenIs(lexer.TOKEN_COMMA) && !p.expectPeek(lexer.TOKEN_SEMICOLON) {
 			return nil
 		}
+		if p.peekTokenIs(lexer.TOKEN_COMMA) {
+			p.nextToken()
+		}
 		p.nextToken()
 	}
 
 	if !p.curTokenIs(lexer.TOKEN_IDENT) {
-		msg := p.formatError(p.curToken.Line, p.curToken.Column,
+		msg := p.formatError(p.curToken.Line, p.curToken.Column, dberrors.MissingToken,
 			"expected identifier in INPUT statement",
 			"INPUT requires a variable to store the user's input")
 		p.errors = append(p.errors, msg)
@@ -532,6 +838,130 @@ func (p *Parser) parseInputStatement() *InputStatement {
 
 	stmt.Variable = &Identifier{Token: p.curToken, Value: p.curToken.Literal}
 
+	// INPUT "prompt", name AS TYPE declares the variable
+	if p.peekTokenIs(lexer.TOKEN_AS) {
+		p.nextToken()
+		p.nextToken()
+		stmt.Type = p.parseTypeSpec()
+	}
+
+	return stmt
+}
+
+// parseFileNumber parses the number after the current # token
+func (p *Parser) parseFileNumber() Expression {
+	p.nextToken()
+	return p.parseExpression(LOWEST)
+}
+
+// expectFileNumber parses #n after the current token
+func (p *Parser) expectFileNumber(stmtName string) Expression {
+	if !p.peekTokenIs(lexer.TOKEN_HASH) {
+		msg := p.formatError(p.peekToken.Line, p.peekToken.Column, dberrors.MissingToken,
+			fmt.Sprintf("expected # file number in %s, got %s", stmtName, p.peekToken.Type),
+			"file numbers are written #1, #2, ... as in OPEN \"data.txt\" FOR INPUT AS #1")
+		p.errors = append(p.errors, msg)
+		return nil
+	}
+	p.nextToken()
+	return p.parseFileNumber()
+}
+
+// parseOpenStatement parses OPEN path FOR mode AS #n
+func (p *Parser) parseOpenStatement() Statement {
+	stmt := &OpenStatement{Token: p.curToken}
+
+	p.nextToken()
+	stmt.Path = p.parseExpression(LOWEST)
+	if !p.expectPeek(lexer.TOKEN_FOR) {
+		return nil
+	}
+	p.nextToken()
+	stmt.Mode = strings.ToUpper(p.curToken.Literal)
+	switch stmt.Mode {
+	case "INPUT", "OUTPUT", "APPEND", "BINARY", "RANDOM":
+	default:
+		msg := p.formatError(p.curToken.Line, p.curToken.Column, dberrors.UnexpectedToken,
+			fmt.Sprintf("unknown file mode %s", p.curToken.Literal),
+			"use FOR INPUT, OUTPUT, APPEND, BINARY or RANDOM")
+		p.errors = append(p.errors, msg)
+		return nil
+	}
+	if !p.expectPeek(lexer.TOKEN_AS) {
+		return nil
+	}
+	stmt.FileNumber = p.expectFileNumber("OPEN")
+	if stmt.FileNumber == nil {
+		return nil
+	}
+	if p.peekTokenIs(lexer.TOKEN_IDENT) && strings.EqualFold(p.peekToken.Literal, "LEN") {
+		p.nextToken()
+		if !p.expectPeek(lexer.TOKEN_ASSIGN) {
+			return nil
+		}
+		p.nextToken()
+		stmt.RecordLen = p.parseExpression(LOWEST)
+	}
+
+	return stmt
+}
+
+// parseGetPutStatement parses GET #n, [pos], var and PUT #n, [pos], var
+func (p *Parser) parseGetPutStatement() Statement {
+	stmt := &GetPutStatement{Token: p.curToken}
+
+	p.nextToken()
+	stmt.FileNumber = p.parseFileNumber()
+	if stmt.FileNumber == nil || !p.expectPeek(lexer.TOKEN_COMMA) {
+		return nil
+	}
+	if !p.peekTokenIs(lexer.TOKEN_COMMA) {
+		p.nextToken()
+		stmt.Position = p.parseExpression(LOWEST)
+	}
+	if !p.expectPeek(lexer.TOKEN_COMMA) {
+		return nil
+	}
+	p.nextToken()
+	stmt.Variable = p.parseExpression(LOWEST)
+	if stmt.Variable == nil {
+		return nil
+	}
+
+	return stmt
+}
+
+// parseCloseStatement parses CLOSE [#n, ...]
+func (p *Parser) parseCloseStatement() Statement {
+	stmt := &CloseStatement{Token: p.curToken}
+
+	if !p.peekTokenIs(lexer.TOKEN_HASH) {
+		return stmt
+	}
+	for {
+		n := p.expectFileNumber("CLOSE")
+		if n == nil {
+			return nil
+		}
+		stmt.FileNumbers = append(stmt.FileNumbers, n)
+		if !p.peekTokenIs(lexer.TOKEN_COMMA) {
+			return stmt
+		}
+		p.nextToken()
+	}
+}
+
+// parseLineInputStatement parses LINE INPUT #n, var
+func (p *Parser) parseLineInputStatement() Statement {
+	stmt := &LineInputStatement{Token: p.curToken}
+
+	p.nextToken() // INPUT
+	stmt.FileNumber = p.expectFileNumber("LINE INPUT")
+	if stmt.FileNumber == nil || !p.expectPeek(lexer.TOKEN_COMMA) || !p.expectPeek(lexer.TOKEN_IDENT) {
+		return nil
+	}
+	stmt.Variable = &Identifier{Token: p.curToken, Value: p.curToken.Literal}
+
 	return stmt
 }
 
@@ -585,12 +1015,10 @@ func (p *Parser) parseIfStatement() *IfStatement {
 	return stmt
 }
 
-func (p *Parser) parseForStatement() *ForStatement {
-	stmt := &ForStatement{Token: p.curToken}
-
-	if !p.expectPeek(lexer.TOKEN_IDENT) {
-		return nil
-	}
+// parseForStatement parses FOR var = start TO end [STEP n] ... NEXT from
+// the loop variable on
+func (p *Parser) parseForStatement(forToken lexer.Token) *ForStatement {
+	stmt := &ForStatement{Token: forToken}
 
 	stmt.Variable = &Identifier{Token: p.curToken, Value: p.curToken.Literal}
 
@@ -626,6 +1054,59 @@ func (p *Parser) parseForStatement() *ForStatement {
 	return stmt
 }
 
+// parseForEachStatement parses FOR EACH [key,] value IN collection ...
+// NEXT from EACH on
+func (p *Parser) parseForEachStatement(forToken lexer.Token) *ForEachStatement {
+	stmt := &ForEachStatement{Token: forToken}
+
+	if !p.expectPeek(lexer.TOKEN_IDENT) {
+		return nil
+	}
+
+	stmt.Value = &Identifier{Token: p.curToken, Value: p.curToken.Literal}
+
+	// Two-variable form: FOR EACH key, value IN collection
+	if p.peekTokenIs(lexer.TOKEN_COMMA) {
+		p.nextToken()
+		if !p.expectPeek(lexer.TOKEN_IDENT) {
+			return nil
+		}
+		stmt.Key = stmt.Value
+		stmt.Value = &Identifier{Token: p.curToken, Value: p.curToken.Literal}
+	}
+
+	if !p.expectPeek(lexer.TOKEN_IN) {
+		return nil
+	}
+
+	p.nextToken()
+	stmt.Collection = p.parseExpression(LOWEST)
+
+	// FOR EACH i IN 1 TO 10 [STEP n] loops over a range
+	if p.peekTokenIs(lexer.TOKEN_TO) {
+		p.nextToken()
+		rng := &RangeExpression{Token: p.curToken, Start: stmt.Collection}
+		p.nextToken()
+		rng.End = p.parseExpression(LOWEST)
+		if p.peekTokenIs(lexer.TOKEN_STEP) {
+			p.nextToken()
+			p.nextToken()
+			rng.Step = p.parseExpression(LOWEST)
+		}
+		stmt.Collection = rng
+	}
+
+	p.nextToken()
+	stmt.Body = p.parseBlockStatement(lexer.TOKEN_NEXT)
+
+	// Handle optional loop variable after NEXT (e.g., NEXT item)
+	if p.peekTokenIs(lexer.TOKEN_IDENT) {
+		p.nextToken() // consume the loop variable
+	}
+
+	return stmt
+}
+
 func (p *Parser) parseWhileStatement() *WhileStatement {
 	stmt := &WhileStatement{Token: p.curToken}
 
@@ -675,6 +1156,34 @@ func (p *Parser) parseDoLoopStatement() *DoLoopStatement {
 	return stmt
 }
 
+// parseCaseValue parses one CASE value: an expression, a range (low TO high)
+// or a comparison (IS > value). IS is only special here, so it stays usable
+// as an identifier elsewhere.
+func (p *Parser) parseCaseValue() Expression {
+	if p.curTokenIs(lexer.TOKEN_IDENT) && strings.EqualFold(p.curToken.Literal, "IS") {
+		switch p.peekToken.Type {
+		case lexer.TOKEN_ASSIGN, lexer.TOKEN_NEQ, lexer.TOKEN_LT,
+			lexer.TOKEN_GT, lexer.TOKEN_LTE, lexer.TOKEN_GTE:
+			caseIs := &CaseIs{Token: p.curToken}
+			p.nextToken()
+			caseIs.Operator = p.curToken.Literal
+			p.nextToken()
+			caseIs.Value = p.parseExpression(LOWEST)
+			return caseIs
+		}
+	}
+
+	value := p.parseExpression(LOWEST)
+	if p.peekTokenIs(lexer.TOKEN_TO) {
+		p.nextToken()
+		caseRange := &CaseRange{Token: p.curToken, Low: value}
+		p.nextToken()
+		caseRange.High = p.parseExpression(LOWEST)
+		return caseRange
+	}
+	return value
+}
+
 func (p *Parser) parseSelectStatement() *SelectStatement {
 	stmt := &SelectStatement{Token: p.curToken}
 
@@ -700,11 +1209,11 @@ func (p *Parser) parseSelectStatement() *SelectStatement {
 			p.nextToken()
 
 			// Parse case values
-			caseClause.Values = append(caseClause.Values, p.parseExpression(LOWEST))
+			caseClause.Values = append(caseClause.Values, p.parseCaseValue())
 			for p.peekTokenIs(lexer.TOKEN_COMMA) {
 				p.nextToken()
 				p.nextToken()
-				caseClause.Values = append(caseClause.Values, p.parseExpression(LOWEST))
+				caseClause.Values = append(caseClause.Values, p.parseCaseValue())
 			}
 
 			p.nextToken()
@@ -721,8 +1230,61 @@ func (p *Parser) parseSelectStatement() *SelectStatement {
 	return stmt
 }
 
+// parseSelectChannelStatement parses SELECT CHANNEL ... END SELECT with
+// CASE RECEIVE x FROM ch, CASE SEND v TO ch, CASE TIMEOUT ms and CASE ELSE arms
+func (p *Parser) parseSelectChannelStatement() Statement {
+	stmt := &SelectChannelStatement{Token: p.curToken}
+
+	p.nextToken() // consume CHANNEL
+	p.nextToken()
+	p.skipNewlines()
+
+	for p.curTokenIs(lexer.TOKEN_CASE) {
+		if p.peekTokenIs(lexer.TOKEN_ELSE) {
+			p.nextToken()
+			p.nextToken()
+			stmt.Default = p.parseBlockStatement(lexer.TOKEN_END, lexer.TOKEN_CASE)
+			continue
+		}
+
+		arm := &ChannelCase{Token: p.curToken}
+		p.nextToken()
+
+		switch {
+		case p.curTokenIs(lexer.TOKEN_RECEIVE):
+			if arm.Receive = p.parseReceiveStatement(); arm.Receive == nil {
+				return nil
+			}
+		case p.curTokenIs(lexer.TOKEN_SEND):
+			if arm.Send = p.parseSendStatement(); arm.Send == nil {
+				return nil
+			}
+		case p.curTokenIs(lexer.TOKEN_IDENT) && strings.EqualFold(p.curToken.Literal, "TIMEOUT"):
+			p.nextToken()
+			arm.Timeout = p.parseExpression(LOWEST)
+		default:
+			msg := p.formatError(p.curToken.Line, p.curToken.Column, dberrors.MissingToken,
+				fmt.Sprintf("expected RECEIVE, SEND or TIMEOUT after CASE in SELECT CHANNEL, got %s", p.curToken.Literal),
+				"use CASE RECEIVE x FROM ch, CASE SEND v TO ch, CASE TIMEOUT ms or CASE ELSE")
+			p.errors = append(p.errors, msg)
+			return nil
+		}
+
+		p.nextToken()
+		arm.Body = p.parseBlockStatement(lexer.TOKEN_CASE, lexer.TOKEN_END)
+		stmt.Cases = append(stmt.Cases, arm)
+	}
+
+	// Expect END SELECT
+	if p.curTokenIs(lexer.TOKEN_END) {
+		p.nextToken() // skip SELECT after END
+	}
+
+	return stmt
+}
+
 func (p *Parser) parseTypeStatement() *TypeStatement {
-	stmt := &TypeStatement{Token: p.curToken}
+	stmt := &TypeStatement{Token: p.curToken, Doc: p.docComment(p.curToken.Line)}
 
 	if !p.expectPeek(lexer.TOKEN_IDENT) {
 		return nil
@@ -793,6 +1355,222 @@ func (p *Parser) parseTypeStatement() *TypeStatement {
 			stmt.Fields = append(stmt.Fields, field)
 		}
 
+		// SUB New(...) declares the constructor run by NEW TypeName(...)
+		if p.curTokenIs(lexer.TOKEN_SUB) {
+			if !p.peekTokenIs(lexer.TOKEN_IDENT) || !strings.EqualFold(p.peekToken.Literal, "New") {
+				msg := p.formatError(p.curToken.Line, p.curToken.Column, dberrors.MisplacedDeclaration,
+					"only SUB New can be declared inside TYPE "+stmt.Name.Value,
+					"declare methods outside the TYPE, e.g. SUB (p AS "+stmt.Name.Value+") Name()")
+				p.errors = append(p.errors, msg)
+				return nil
+			}
+			if stmt.Constructor != nil {
+				msg := p.formatError(p.curToken.Line, p.curToken.Column, dberrors.MisplacedDeclaration,
+					"duplicate constructor for TYPE "+stmt.Name.Value,
+					"a TYPE can have only one SUB New")
+				p.errors = append(p.errors, msg)
+				return nil
+			}
+			// The body acts as a WITH block on the value being built
+			p.withDepth++
+			sub, ok := p.parseSubStatement().(*SubStatement)
+			p.withDepth--
+			if !ok {
+				return nil
+			}
+			stmt.Constructor = sub
+		}
+
+		// PROPERTY GET/SET declares a computed field
+		if p.curIsWord("PROPERTY") && !p.peekTokenIs(lexer.TOKEN_AS) {
+			prop := p.parsePropertyDeclaration()
+			if prop == nil {
+				return nil
+			}
+			stmt.Properties = append(stmt.Properties, prop)
+		}
+
+		p.nextToken()
+		p.skipNewlines()
+	}
+
+	return stmt
+}
+
+// parsePropertyDeclaration parses a property accessor inside a TYPE:
+//
+//	PROPERTY GET Name() AS Type ... END PROPERTY
+//	PROPERTY SET Name(value AS Type) ... END PROPERTY
+func (p *Parser) parsePropertyDeclaration() *PropertyDeclaration {
+	prop := &PropertyDeclaration{Token: p.curToken}
+
+	if !p.expectPeek(lexer.TOKEN_IDENT) {
+		return nil
+	}
+	switch strings.ToUpper(p.curToken.Literal) {
+	case "GET":
+	case "SET":
+		prop.IsSet = true
+	default:
+		msg := p.formatError(p.curToken.Line, p.curToken.Column, dberrors.MissingToken,
+			"expected GET or SET after PROPERTY, got "+p.curToken.Literal,
+			"use PROPERTY GET Name() AS Type or PROPERTY SET Name(value AS Type)")
+		p.errors = append(p.errors, msg)
+		return nil
+	}
+
+	if !p.expectPeek(lexer.TOKEN_IDENT) {
+		return nil
+	}
+	prop.Name = &Identifier{Token: p.curToken, Value: p.curToken.Literal}
+
+	if !p.expectPeek(lexer.TOKEN_LPAREN) {
+		return nil
+	}
+	params := p.parseParameters()
+	if !p.expectPeek(lexer.TOKEN_RPAREN) {
+		return nil
+	}
+
+	if prop.IsSet {
+		if len(params) != 1 || params[0].ByRef || params[0].ParamArray {
+			msg := p.formatError(prop.Token.Line, prop.Token.Column, dberrors.SyntaxError,
+				"PROPERTY SET "+prop.Name.Value+" must take exactly one parameter",
+				"the parameter receives the assigned value, e.g. PROPERTY SET "+prop.Name.Value+"(value AS STRING)")
+			p.errors = append(p.errors, msg)
+			return nil
+		}
+		prop.Param = params[0]
+	} else {
+		if len(params) != 0 {
+			msg := p.formatError(prop.Token.Line, prop.Token.Column, dberrors.SyntaxError,
+				"PROPERTY GET "+prop.Name.Value+" cannot take parameters",
+				"declare it as PROPERTY GET "+prop.Name.Value+"() AS Type")
+			p.errors = append(p.errors, msg)
+			return nil
+		}
+		if !p.expectPeek(lexer.TOKEN_AS) {
+			return nil
+		}
+		p.nextToken()
+		prop.ReturnType = p.parseTypeSpec()
+	}
+
+	// The body acts as a WITH block on the value
+	p.nextToken()
+	p.withDepth++
+	prop.Body = p.parseBlockStatementUntilEnd("PROPERTY")
+	p.withDepth--
+
+	return prop
+}
+
+// parseInterfaceStatement parses INTERFACE Name ... END INTERFACE
+func (p *Parser) parseInterfaceStatement() *InterfaceStatement {
+	stmt := &InterfaceStatement{Token: p.curToken, Doc: p.docComment(p.curToken.Line)}
+
+	if !p.expectPeek(lexer.TOKEN_IDENT) {
+		return nil
+	}
+
+	stmt.Name = &Identifier{Token: p.curToken, Value: p.curToken.Literal}
+
+	p.nextToken()
+	p.skipNewlines()
+
+	// Parse method signatures until END INTERFACE
+	for !p.curTokenIs(lexer.TOKEN_EOF) {
+		if p.curTokenIs(lexer.TOKEN_END) && p.peekIsWord("INTERFACE") {
+			p.nextToken() // consume INTERFACE
+			break
+		}
+
+		if !p.curTokenIs(lexer.TOKEN_SUB) && !p.curTokenIs(lexer.TOKEN_FUNCTION) {
+			msg := p.formatError(p.curToken.Line, p.curToken.Column, dberrors.MissingToken,
+				"expected SUB or FUNCTION in INTERFACE, got "+p.curToken.Literal,
+				"INTERFACE blocks contain method signatures only, e.g. FUNCTION Area() AS DOUBLE")
+			p.errors = append(p.errors, msg)
+			return nil
+		}
+
+		method := &InterfaceMethod{Token: p.curToken}
+
+		if !p.expectPeek(lexer.TOKEN_IDENT) {
+			return nil
+		}
+		method.Name = &Identifier{Token: p.curToken, Value: p.curToken.Literal}
+
+		if !p.expectPeek(lexer.TOKEN_LPAREN) {
+			return nil
+		}
+
+		method.Params = p.parseParameters()
+
+		if !p.expectPeek(lexer.TOKEN_RPAREN) {
+			return nil
+		}
+
+		// FUNCTION signatures declare their return type(s)
+		if method.Token.Type == lexer.TOKEN_FUNCTION && p.peekTokenIs(lexer.TOKEN_AS) {
+			p.nextToken()
+			p.nextToken()
+
+			if p.curTokenIs(lexer.TOKEN_LPAREN) {
+				p.nextToken()
+				for !p.curTokenIs(lexer.TOKEN_RPAREN) {
+					method.ReturnTypes = append(method.ReturnTypes, p.parseTypeSpec())
+					if p.peekTokenIs(lexer.TOKEN_COMMA) {
+						p.nextToken()
+					}
+					p.nextToken()
+				}
+			} else {
+				method.ReturnTypes = append(method.ReturnTypes, p.parseTypeSpec())
+			}
+		}
+
+		stmt.Methods = append(stmt.Methods, method)
+
+		p.nextToken()
+		p.skipNewlines()
+	}
+
+	return stmt
+}
+
+func (p *Parser) parseModuleStatement() Statement {
+	stmt := &ModuleStatement{Token: p.curToken, Doc: p.docComment(p.curToken.Line)}
+
+	if !p.expectPeek(lexer.TOKEN_IDENT) {
+		return nil
+	}
+
+	stmt.Name = &Identifier{Token: p.curToken, Value: p.curToken.Literal}
+
+	p.nextToken()
+	p.skipNewlines()
+
+	// Parse declarations until END MODULE
+	for !p.curTokenIs(lexer.TOKEN_EOF) {
+		if p.curTokenIs(lexer.TOKEN_END) && p.peekIsWord("MODULE") {
+			p.nextToken() // consume MODULE
+			break
+		}
+
+		switch {
+		case (p.curTokenIs(lexer.TOKEN_SUB) || p.curTokenIs(lexer.TOKEN_FUNCTION)) && !p.peekTokenIs(lexer.TOKEN_LPAREN),
+			p.curTokenIs(lexer.TOKEN_DIM), p.curTokenIs(lexer.TOKEN_CONST):
+			if s := p.parseStatement(); s != nil {
+				stmt.Body = append(stmt.Body, s)
+			}
+		default:
+			msg := p.formatError(p.curToken.Line, p.curToken.Column, dberrors.MissingToken,
+				"expected SUB, FUNCTION, DIM or CONST in MODULE, got "+p.curToken.Literal,
+				"MODULE blocks contain declarations only; TYPE, INTERFACE and methods go outside the module")
+			p.errors = append(p.errors, msg)
+			return nil
+		}
+
 		p.nextToken()
 		p.skipNewlines()
 	}
@@ -808,7 +1586,7 @@ func (p *Parser) parseSubStatement() Statement {
 		return p.parseSubMethodStatement(subToken)
 	}
 
-	stmt := &SubStatement{Token: subToken}
+	stmt := &SubStatement{Token: subToken, Doc: p.docComment(subToken.Line)}
 
 	if !p.expectPeek(lexer.TOKEN_IDENT) {
 		return nil
@@ -833,13 +1611,19 @@ func (p *Parser) parseSubStatement() Statement {
 }
 
 func (p *Parser) parseSubMethodStatement(subToken lexer.Token) *MethodStatement {
-	stmt := &MethodStatement{Token: subToken}
+	stmt := &MethodStatement{Token: subToken, Doc: p.docComment(subToken.Line)}
 
 	// Expect opening paren for receiver
 	if !p.expectPeek(lexer.TOKEN_LPAREN) {
 		return nil
 	}
 
+	// BYREF receiver: (BYREF recv AS Type) means (recv AS POINTER TO Type)
+	if p.peekTokenIs(lexer.TOKEN_BYREF) {
+		p.nextToken()
+		stmt.ReceiverByRef = true
+	}
+
 	// Parse receiver name
 	if !p.expectPeek(lexer.TOKEN_IDENT) {
 		return nil
@@ -854,6 +1638,9 @@ func (p *Parser) parseSubMethodStatement(subToken lexer.Token) *MethodStatement
 	// Parse receiver type
 	p.nextToken()
 	stmt.ReceiverType = p.parseTypeSpec()
+	if stmt.ReceiverByRef && stmt.ReceiverType != nil {
+		stmt.ReceiverType = &TypeSpec{Token: stmt.ReceiverType.Token, IsPointer: true, ElementType: stmt.ReceiverType}
+	}
 
 	// Expect closing paren for receiver
 	if !p.expectPeek(lexer.TOKEN_RPAREN) {
@@ -894,7 +1681,7 @@ func (p *Parser) parseFunctionStatement() Statement {
 		return p.parseMethodStatement(funcToken)
 	}
 
-	stmt := &FunctionStatement{Token: funcToken}
+	stmt := &FunctionStatement{Token: funcToken, Doc: p.docComment(funcToken.Line)}
 
 	if !p.expectPeek(lexer.TOKEN_IDENT) {
 		return nil
@@ -917,20 +1704,7 @@ func (p *Parser) parseFunctionStatement() Statement {
 	}
 
 	p.nextToken()
-
-	// Check for multiple return types
-	if p.curTokenIs(lexer.TOKEN_LPAREN) {
-		p.nextToken()
-		for !p.curTokenIs(lexer.TOKEN_RPAREN) {
-			stmt.ReturnTypes = append(stmt.ReturnTypes, p.parseTypeSpec())
-			if p.peekTokenIs(lexer.TOKEN_COMMA) {
-				p.nextToken()
-			}
-			p.nextToken()
-		}
-	} else {
-		stmt.ReturnTypes = append(stmt.ReturnTypes, p.parseTypeSpec())
-	}
+	stmt.ReturnTypes = p.parseReturnTypes()
 
 	p.nextToken()
 	stmt.Body = p.parseBlockStatementUntilEnd("FUNCTION")
@@ -938,14 +1712,38 @@ func (p *Parser) parseFunctionStatement() Statement {
 	return stmt
 }
 
+// parseReturnTypes parses the type after FUNCTION ... AS, or the list of
+// types in parentheses when the function returns several values
+func (p *Parser) parseReturnTypes() []*TypeSpec {
+	if !p.curTokenIs(lexer.TOKEN_LPAREN) {
+		return []*TypeSpec{p.parseTypeSpec()}
+	}
+	var types []*TypeSpec
+	p.nextToken()
+	for !p.curTokenIs(lexer.TOKEN_RPAREN) && !p.curTokenIs(lexer.TOKEN_EOF) {
+		types = append(types, p.parseTypeSpec())
+		if p.peekTokenIs(lexer.TOKEN_COMMA) {
+			p.nextToken()
+		}
+		p.nextToken()
+	}
+	return types
+}
+
 func (p *Parser) parseMethodStatement(funcToken lexer.Token) *MethodStatement {
-	stmt := &MethodStatement{Token: funcToken}
+	stmt := &MethodStatement{Token: funcToken, Doc: p.docComment(funcToken.Line)}
 
 	// Expect opening paren for receiver
 	if !p.expectPeek(lexer.TOKEN_LPAREN) {
 		return nil
 	}
 
+	// BYREF receiver: (BYREF recv AS Type) means (recv AS POINTER TO Type)
+	if p.peekTokenIs(lexer.TOKEN_BYREF) {
+		p.nextToken()
+		stmt.ReceiverByRef = true
+	}
+
 	// Parse receiver name
 	if !p.expectPeek(lexer.TOKEN_IDENT) {
 		return nil
@@ -960,6 +1758,9 @@ func (p *Parser) parseMethodStatement(funcToken lexer.Token) *MethodStatement {
 	// Parse receiver type
 	p.nextToken()
 	stmt.ReceiverType = p.parseTypeSpec()
+	if stmt.ReceiverByRef && stmt.ReceiverType != nil {
+		stmt.ReceiverType = &TypeSpec{Token: stmt.ReceiverType.Token, IsPointer: true, ElementType: stmt.ReceiverType}
+	}
 
 	// Expect closing paren for receiver
 	if !p.expectPeek(lexer.TOKEN_RPAREN) {
@@ -1029,10 +1830,13 @@ func (p *Parser) parseParameters() []*Parameter {
 		} else if p.curTokenIs(lexer.TOKEN_BYVAL) {
 			param.ByRef = false
 			p.nextToken()
+		} else if p.curIsWord("PARAMARRAY") && p.peekTokenIs(lexer.TOKEN_IDENT) {
+			param.ParamArray = true
+			p.nextToken()
 		}
 
 		if !p.curTokenIs(lexer.TOKEN_IDENT) {
-			msg := p.formatError(p.curToken.Line, p.curToken.Column,
+			msg := p.formatError(p.curToken.Line, p.curToken.Column, dberrors.MissingToken,
 				"expected parameter name",
 				"parameters should be: name AS TYPE")
 			p.errors = append(p.errors, msg)
@@ -1052,6 +1856,12 @@ func (p *Parser) parseParameters() []*Parameter {
 		if !p.peekTokenIs(lexer.TOKEN_COMMA) {
 			break
 		}
+		if param.ParamArray {
+			msg := p.formatError(param.Name.Token.Line, param.Name.Token.Column, dberrors.MisplacedDeclaration,
+				"PARAMARRAY must be the last parameter",
+				"move "+param.Name.Value+" to the end of the parameter list")
+			p.errors = append(p.errors, msg)
+		}
 		p.nextToken()
 		p.nextToken()
 	}
@@ -1100,6 +1910,100 @@ func (p *Parser) parseGotoStatement() *GotoStatement {
 	return stmt
 }
 
+// parseGosubStatement parses GOSUB label
+func (p *Parser) parseGosubStatement() Statement {
+	stmt := &GosubStatement{Token: p.curToken}
+
+	if !p.expectPeek(lexer.TOKEN_IDENT) {
+		return nil
+	}
+
+	stmt.Label = p.curToken.Literal
+
+	return stmt
+}
+
+// parseOnErrorStatement parses ON ERROR GOTO label and ON ERROR GOTO 0
+func (p *Parser) parseOnErrorStatement() Statement {
+	stmt := &OnErrorStatement{Token: p.curToken}
+	p.nextToken() // ERROR
+
+	if !p.peekTokenIs(lexer.TOKEN_GOTO) {
+		msg := p.formatError(p.peekToken.Line, p.peekToken.Column, dberrors.MissingToken,
+			fmt.Sprintf("expected GOTO after ON ERROR, got %s", p.peekToken.Literal),
+			"use ON ERROR GOTO label to trap errors, or ON ERROR GOTO 0 to stop trapping them")
+		p.errors = append(p.errors, msg)
+		return nil
+	}
+	p.nextToken()
+
+	p.nextToken()
+	switch {
+	case p.curTokenIs(lexer.TOKEN_INT) && p.curToken.Literal == "0":
+		stmt.Label = ""
+	case p.curTokenIs(lexer.TOKEN_IDENT):
+		stmt.Label = p.curToken.Literal
+	default:
+		msg := p.formatError(p.curToken.Line, p.curToken.Column, dberrors.MissingToken,
+			fmt.Sprintf("expected a label or 0 after ON ERROR GOTO, got %s", p.curToken.Literal),
+			"name a label in the same routine, e.g. ON ERROR GOTO Handler")
+		p.errors = append(p.errors, msg)
+		return nil
+	}
+
+	return stmt
+}
+
+// parseOptionStatement parses OPTION name
+func (p *Parser) parseOptionStatement() Statement {
+	stmt := &OptionStatement{Token: p.curToken}
+	p.nextToken()
+	stmt.Name = strings.ToUpper(p.curToken.Literal)
+	return stmt
+}
+
+// parseEmbedStatement parses EMBEDFILE "path" AS name and EMBEDDIR "path" AS name
+func (p *Parser) parseEmbedStatement() Statement {
+	stmt := &EmbedStatement{Token: p.curToken, IsDir: strings.EqualFold(p.curToken.Literal, "EMBEDDIR")}
+	p.nextToken()
+	stmt.Path = p.curToken.Literal
+
+	if !p.expectPeek(lexer.TOKEN_AS) {
+		return nil
+	}
+	if !p.expectPeek(lexer.TOKEN_IDENT) {
+		return nil
+	}
+	stmt.Name = &Identifier{Token: p.curToken, Value: p.curToken.Literal}
+	return stmt
+}
+
+// parseCheckStatement parses CHECK err
+func (p *Parser) parseCheckStatement() Statement {
+	stmt := &CheckStatement{Token: p.curToken}
+
+	p.nextToken()
+	stmt.Err = p.parseExpression(LOWEST)
+	if stmt.Err == nil {
+		return nil
+	}
+
+	return stmt
+}
+
+// parseErrorStatement parses ERROR n
+func (p *Parser) parseErrorStatement() Statement {
+	stmt := &ErrorStatement{Token: p.curToken}
+
+	p.nextToken()
+	stmt.Code = p.parseExpression(LOWEST)
+	if stmt.Code == nil {
+		return nil
+	}
+
+	return stmt
+}
+
 func (p *Parser) parseLabelStatement() *LabelStatement {
 	stmt := &LabelStatement{
 		Token: p.curToken,
@@ -1109,6 +2013,25 @@ func (p *Parser) parseLabelStatement() *LabelStatement {
 	return stmt
 }
 
+// parseAssertStatement parses ASSERT condition [, message]
+func (p *Parser) parseAssertStatement() Statement {
+	stmt := &AssertStatement{Token: p.curToken}
+
+	p.nextToken()
+	stmt.Condition = p.parseExpression(LOWEST)
+	if stmt.Condition == nil {
+		return nil
+	}
+
+	if p.peekTokenIs(lexer.TOKEN_COMMA) {
+		p.nextToken()
+		p.nextToken()
+		stmt.Message = p.parseExpression(LOWEST)
+	}
+
+	return stmt
+}
+
 func (p *Parser) parseSpawnStatement() *SpawnStatement {
 	stmt := &SpawnStatement{Token: p.curToken}
 
@@ -1117,7 +2040,7 @@ func (p *Parser) parseSpawnStatement() *SpawnStatement {
 	expr := p.parseExpression(LOWEST)
 	call, ok := expr.(*CallExpression)
 	if !ok {
-		msg := p.formatError(p.curToken.Line, p.curToken.Column,
+		msg := p.formatError(p.curToken.Line, p.curToken.Column, dberrors.SyntaxError,
 			"SPAWN requires a function call",
 			"use: SPAWN SubName(args)")
 		p.errors = append(p.errors, msg)
@@ -1147,8 +2070,11 @@ func (p *Parser) parseSendStatement() *SendStatement {
 func (p *Parser) parseReceiveStatement() *ReceiveStatement {
 	stmt := &ReceiveStatement{Token: p.curToken}
 
-	p.nextToken()
-	stmt.Variable = p.parseExpression(LOWEST)
+	// RECEIVE FROM ch discards the value
+	if !p.peekTokenIs(lexer.TOKEN_FROM) {
+		p.nextToken()
+		stmt.Variable = p.parseExpression(LOWEST)
+	}
 
 	if !p.expectPeek(lexer.TOKEN_FROM) {
 		return nil
@@ -1197,7 +2123,7 @@ func (p *Parser) parseAssignmentOrExpression() Statement {
 			if p.curTokenIs(lexer.TOKEN_IDENT) {
 				targets = append(targets, &Identifier{Token: p.curToken, Value: p.curToken.Literal})
 			} else {
-				msg := p.formatError(p.curToken.Line, p.curToken.Column,
+				msg := p.formatError(p.curToken.Line, p.curToken.Column, dberrors.MissingToken,
 					"expected identifier in multiple assignment",
 					"use: a, b = FunctionCall()")
 				p.errors = append(p.errors, msg)
@@ -1248,9 +2174,10 @@ func (p *Parser) parseBlockStatementUntilEnd(blockType string) *BlockStatement {
 
 	for !p.curTokenIs(lexer.TOKEN_EOF) {
 		if p.curTokenIs(lexer.TOKEN_END) {
-			// Check if it's END SUB or END FUNCTION
-			if p.peekTokenIs(lexer.TOKEN_SUB) || p.peekTokenIs(lexer.TOKEN_FUNCTION) {
-				p.nextToken() // consume SUB or FUNCTION
+			// Check if it's END SUB, END FUNCTION or END PROPERTY
+			if p.peekTokenIs(lexer.TOKEN_SUB) || p.peekTokenIs(lexer.TOKEN_FUNCTION) ||
+				p.peekIsWord("PROPERTY") {
+				p.nextToken() // consume SUB, FUNCTION or PROPERTY
 				break
 			}
 		}
@@ -1283,8 +2210,15 @@ func (p *Parser) isEndToken(tokens ...lexer.TokenType) bool {
 
 func (p *Parser) parseExpression(precedence int) Expression {
 	prefix := p.prefixParseFns[p.curToken.Type]
+	if prefix == nil && p.curTokenIs(lexer.TOKEN_ILLEGAL) && p.curToken.Literal == `"""` {
+		msg := p.formatError(p.curToken.Line, p.curToken.Column, dberrors.InvalidLiteral,
+			"unterminated multi-line string",
+			`close the string with """`)
+		p.errors = append(p.errors, msg)
+		return nil
+	}
 	if prefix == nil {
-		msg := p.formatError(p.curToken.Line, p.curToken.Column,
+		msg := p.formatError(p.curToken.Line, p.curToken.Column, dberrors.UnexpectedToken,
 			fmt.Sprintf("unexpected token: %s", p.curToken.Type),
 			"expected an expression (variable, literal, or function call)")
 		p.errors = append(p.errors, msg)
@@ -1304,6 +2238,13 @@ func (p *Parser) parseExpression(precedence int) Expression {
 	return leftExp
 }
 
+// parseOperatorWordName parses IN or LIKE where an operand starts, as in
+// ASSERT in > 0, where they are names
+func (p *Parser) parseOperatorWordName() Expression {
+	p.curToken.Type = lexer.TOKEN_IDENT
+	return p.parseIdentifier()
+}
+
 func (p *Parser) parseIdentifier() Expression {
 	ident := &Identifier{Token: p.curToken, Value: p.curToken.Literal}
 
@@ -1312,9 +2253,28 @@ func (p *Parser) parseIdentifier() Expression {
 		return p.parseStructLiteral(ident)
 	}
 
+	// NEW TypeName(args) runs the TYPE's constructor
+	if strings.EqualFold(ident.Value, "NEW") && p.peekTokenIs(lexer.TOKEN_IDENT) {
+		return p.parseNewExpression()
+	}
+
 	return ident
 }
 
+func (p *Parser) parseNewExpression() Expression {
+	expr := &NewExpression{Token: p.curToken}
+
+	p.nextToken()
+	expr.TypeName = &Identifier{Token: p.curToken, Value: p.curToken.Literal}
+
+	if p.peekTokenIs(lexer.TOKEN_LPAREN) {
+		p.nextToken()
+		expr.Arguments = p.parseExpressionList(lexer.TOKEN_RPAREN)
+	}
+
+	return expr
+}
+
 func (p *Parser) parseStructLiteral(typeName *Identifier) Expression {
 	lit := &StructLiteral{
 		Token:    typeName.Token,
@@ -1346,7 +2306,7 @@ func (p *Parser) parseStructLiteral(typeName *Identifier) Expression {
 		}
 
 		if !p.curTokenIs(lexer.TOKEN_IDENT) {
-			msg := p.formatError(p.curToken.Line, p.curToken.Column,
+			msg := p.formatError(p.curToken.Line, p.curToken.Column, dberrors.MissingToken,
 				"expected field name in struct literal",
 				"struct literals use field: value syntax")
 			p.errors = append(p.errors, msg)
@@ -1390,7 +2350,7 @@ func (p *Parser) parseIntegerLiteral() Expression {
 
 	value, err := strconv.ParseInt(p.curToken.Literal, 0, 64)
 	if err != nil {
-		msg := p.formatError(p.curToken.Line, p.curToken.Column,
+		msg := p.formatError(p.curToken.Line, p.curToken.Column, dberrors.InvalidLiteral,
 			fmt.Sprintf("could not parse %q as integer", p.curToken.Literal),
 			"integer values should be whole numbers like 42 or -17")
 		p.errors = append(p.errors, msg)
@@ -1406,7 +2366,7 @@ func (p *Parser) parseFloatLiteral() Expression {
 
 	value, err := strconv.ParseFloat(p.curToken.Literal, 64)
 	if err != nil {
-		msg := p.formatError(p.curToken.Line, p.curToken.Column,
+		msg := p.formatError(p.curToken.Line, p.curToken.Column, dberrors.InvalidLiteral,
 			fmt.Sprintf("could not parse %q as float", p.curToken.Literal),
 			"float values should be like 3.14 or 2.5e10")
 		p.errors = append(p.errors, msg)
@@ -1421,6 +2381,112 @@ func (p *Parser) parseStringLiteral() Expression {
 	return &StringLiteral{Token: p.curToken, Value: p.curToken.Literal}
 }
 
+// parseInterpolatedString splits $"..." into text and {expression} parts.
+// Each embedded expression is parsed with its own parser.
+func (p *Parser) parseInterpolatedString() Expression {
+	expr := &InterpolatedString{Token: p.curToken}
+	src := p.curToken.Literal
+
+	var text strings.Builder
+	flush := func() {
+		if text.Len() > 0 {
+			expr.Parts = append(expr.Parts, &StringLiteral{Token: p.curToken, Value: text.String()})
+			text.Reset()
+		}
+	}
+
+	for i := 0; i < len(src); i++ {
+		ch := src[i]
+		switch {
+		case (ch == '{' || ch == '}') && i+1 < len(src) && src[i+1] == ch:
+			text.WriteByte(ch)
+			i++
+		case ch == '{':
+			end := interpolationEnd(src, i)
+			if end < 0 {
+				msg := p.formatError(p.curToken.Line, p.curToken.Column, dberrors.InvalidLiteral,
+					"unterminated { in interpolated string",
+					"close the expression with } or use {{ for a literal brace")
+				p.errors = append(p.errors, msg)
+				return nil
+			}
+			part := p.parseInterpolatedPart(src[i+1 : end])
+			if part == nil {
+				return nil
+			}
+			flush()
+			expr.Parts = append(expr.Parts, part)
+			i = end
+		default:
+			text.WriteByte(ch)
+		}
+	}
+	flush()
+
+	return expr
+}
+
+// parseInterpolatedPart parses the source of one {expression}
+func (p *Parser) parseInterpolatedPart(code string) Expression {
+	sub := New(lexer.New(code))
+	sub.withDepth = p.withDepth
+	expr := sub.parseExpression(LOWEST)
+	if len(sub.errors) > 0 || expr == nil || !sub.peekTokenIs(lexer.TOKEN_EOF) {
+		msg := p.formatError(p.curToken.Line, p.curToken.Column, dberrors.InvalidLiteral,
+			fmt.Sprintf("invalid expression {%s} in interpolated string", code),
+			"use {{ and }} for literal braces")
+		p.errors = append(p.errors, msg)
+		return nil
+	}
+	return expr
+}
+
+// interpolationEnd returns the index of the } closing the { at start,
+// skipping nested braces and string literals, or -1 if there is none
+func interpolationEnd(src string, start int) int {
+	depth := 0
+	for i := start; i < len(src); i++ {
+		switch src[i] {
+		case '"':
+			for i++; i < len(src) && src[i] != '"'; i++ {
+				if src[i] == '\\' {
+					i++
+				}
+			}
+		case '{':
+			depth++
+		case '}':
+			depth--
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// dateTimeLayouts are the accepted forms of a #...# literal
+var dateTimeLayouts = []string{
+	"2006-01-02",
+	"2006-01-02 15:04",
+	"2006-01-02 15:04:05",
+	"2006-01-02T15:04",
+	"2006-01-02T15:04:05",
+}
+
+func (p *Parser) parseDateTimeLiteral() Expression {
+	for _, layout := range dateTimeLayouts {
+		if t, err := time.Parse(layout, p.curToken.Literal); err == nil {
+			return &DateTimeLiteral{Token: p.curToken, Value: t}
+		}
+	}
+	msg := p.formatError(p.curToken.Line, p.curToken.Column, dberrors.InvalidLiteral,
+		fmt.Sprintf("invalid DATETIME literal: #%s#", p.curToken.Literal),
+		"use #YYYY-MM-DD#, #YYYY-MM-DD HH:MM# or #YYYY-MM-DD HH:MM:SS#")
+	p.errors = append(p.errors, msg)
+	return nil
+}
+
 func (p *Parser) parseByteStringLiteral() Expression {
 	return &ByteStringLiteral{Token: p.curToken, Value: p.curToken.Literal}
 }
@@ -1441,7 +2507,7 @@ func (p *Parser) parseJSONLiteral() Expression {
 	for !p.curTokenIs(lexer.TOKEN_RBRACE) && !p.curTokenIs(lexer.TOKEN_EOF) {
 		// Parse key
 		if !p.curTokenIs(lexer.TOKEN_STRING) && !p.curTokenIs(lexer.TOKEN_IDENT) {
-			msg := p.formatError(p.curToken.Line, p.curToken.Column,
+			msg := p.formatError(p.curToken.Line, p.curToken.Column, dberrors.MissingToken,
 				"expected string key in JSON object",
 				"JSON keys should be strings like \"name\" or identifiers")
 			p.errors = append(p.errors, msg)
@@ -1492,7 +2558,7 @@ func (p *Parser) parseArrayLiteral() Expression {
 			}
 		}
 		// Not a slice literal, this is an error
-		msg := p.formatError(p.curToken.Line, p.curToken.Column,
+		msg := p.formatError(p.curToken.Line, p.curToken.Column, dberrors.MissingToken,
 			"expected type name after [] for slice literal",
 			"use []Type{elem1, elem2} for slice literals")
 		p.errors = append(p.errors, msg)
@@ -1705,6 +2771,166 @@ func (p *Parser) parseIndexExpression(left Expression) Expression {
 	return exp
 }
 
+// parseWithStatement parses WITH target ... END WITH
+func (p *Parser) parseWithStatement() *WithStatement {
+	stmt := &WithStatement{Token: p.curToken}
+
+	p.nextToken()
+	stmt.Target = p.parseExpression(LOWEST)
+
+	p.nextToken()
+	p.withDepth++
+	stmt.Body = p.parseBlockStatement(lexer.TOKEN_END)
+	p.withDepth--
+
+	// Expect END WITH
+	if !p.expectPeekWord("WITH") {
+		return nil
+	}
+
+	return stmt
+}
+
+// parseLockStatement parses LOCK mutex ... END LOCK
+func (p *Parser) parseLockStatement() Statement {
+	stmt := &LockStatement{Token: p.curToken}
+
+	p.nextToken()
+	stmt.Mutex = p.parseExpression(LOWEST)
+
+	p.nextToken()
+	stmt.Body = p.parseBlockStatement(lexer.TOKEN_END)
+
+	// Expect END LOCK
+	if !p.expectPeekWord("LOCK") {
+		return nil
+	}
+
+	return stmt
+}
+
+// parseTestStatement parses TEST "name" ... END TEST
+func (p *Parser) parseTestStatement() Statement {
+	stmt := &TestStatement{Token: p.curToken}
+
+	p.nextToken()
+	stmt.Name = p.curToken.Literal
+
+	p.nextToken()
+	stmt.Body = p.parseBlockStatement(lexer.TOKEN_END)
+
+	// Expect END TEST
+	if !p.peekTokenIs(lexer.TOKEN_IDENT) || !strings.EqualFold(p.peekToken.Literal, "TEST") {
+		msg := p.formatError(p.peekToken.Line, p.peekToken.Column, dberrors.MissingToken,
+			fmt.Sprintf("expected END TEST, got END %s", p.peekToken.Literal),
+			"close each TEST block with END TEST")
+		p.errors = append(p.errors, msg)
+		return nil
+	}
+	p.nextToken()
+
+	return stmt
+}
+
+// parseBenchmarkStatement parses BENCHMARK "name" ... END BENCHMARK
+func (p *Parser) parseBenchmarkStatement() Statement {
+	stmt := &BenchmarkStatement{Token: p.curToken}
+
+	p.nextToken()
+	stmt.Name = p.curToken.Literal
+
+	p.nextToken()
+	stmt.Body = p.parseBlockStatement(lexer.TOKEN_END)
+
+	// Expect END BENCHMARK
+	if !p.peekTokenIs(lexer.TOKEN_IDENT) || !strings.EqualFold(p.peekToken.Literal, "BENCHMARK") {
+		msg := p.formatError(p.peekToken.Line, p.peekToken.Column, dberrors.MissingToken,
+			fmt.Sprintf("expected END BENCHMARK, got END %s", p.peekToken.Literal),
+			"close each BENCHMARK block with END BENCHMARK")
+		p.errors = append(p.errors, msg)
+		return nil
+	}
+	p.nextToken()
+
+	return stmt
+}
+
+// isDeclareKind reports whether tok can follow DECLARE
+func (p *Parser) isDeclareKind(tok lexer.Token) bool {
+	switch tok.Type {
+	case lexer.TOKEN_TYPE, lexer.TOKEN_CONST, lexer.TOKEN_DIM, lexer.TOKEN_SUB, lexer.TOKEN_FUNCTION:
+		return true
+	}
+	return tok.Type == lexer.TOKEN_IDENT && strings.EqualFold(tok.Literal, "PACKAGE")
+}
+
+// parseDeclareStatement parses a DECLARE line of a .dbasi declaration file.
+// Member names are kept exactly as written, since Go's are case sensitive.
+func (p *Parser) parseDeclareStatement() Statement {
+	stmt := &DeclareStatement{Token: p.curToken}
+	p.nextToken()
+	stmt.Kind = strings.ToUpper(p.curToken.Literal)
+
+	if stmt.Kind == "PACKAGE" {
+		if !p.expectPeek(lexer.TOKEN_STRING) {
+			return nil
+		}
+		stmt.Path = p.curToken.Literal
+		return stmt
+	}
+
+	// Any identifier or keyword names a member
+	p.nextToken()
+	if p.curToken.Type != lexer.LookupIdent(strings.ToUpper(p.curToken.Literal)) {
+		msg := p.formatError(p.curToken.Line, p.curToken.Column, dberrors.MissingToken,
+			fmt.Sprintf("expected name after DECLARE %s, got %s", stmt.Kind, p.curToken.Type),
+			"declare members as they are named in Go, e.g. DECLARE FUNCTION ToUpper(s AS STRING) AS STRING")
+		p.errors = append(p.errors, msg)
+		return nil
+	}
+	stmt.Name = &Identifier{Token: p.curToken, Value: p.curToken.Literal}
+
+	switch stmt.Kind {
+	case "TYPE":
+		return stmt
+	case "CONST", "DIM":
+		if !p.expectPeek(lexer.TOKEN_AS) {
+			return nil
+		}
+		p.nextToken()
+		stmt.Type = p.parseTypeSpec()
+		return stmt
+	}
+
+	if !p.expectPeek(lexer.TOKEN_LPAREN) {
+		return nil
+	}
+	stmt.Params = p.parseParameters()
+	if !p.expectPeek(lexer.TOKEN_RPAREN) {
+		return nil
+	}
+	if stmt.Kind == "FUNCTION" {
+		if !p.expectPeek(lexer.TOKEN_AS) {
+			return nil
+		}
+		p.nextToken()
+		stmt.ReturnTypes = p.parseReturnTypes()
+	}
+	return stmt
+}
+
+// parseWithMember parses a leading-dot member (.Field) that refers to the WITH target
+func (p *Parser) parseWithMember() Expression {
+	if p.withDepth == 0 {
+		msg := p.formatError(p.curToken.Line, p.curToken.Column, dberrors.MisplacedDeclaration,
+			"unexpected '.' outside of a WITH block",
+			"leading-dot members like .Name are only valid between WITH and END WITH")
+		p.errors = append(p.errors, msg)
+		return nil
+	}
+	return p.parseMemberExpression(&WithTargetExpression{Token: p.curToken})
+}
+
 func (p *Parser) parseMemberExpression(left Expression) Expression {
 	dotToken := p.curToken
 
@@ -1715,10 +2941,15 @@ func (p *Parser) parseMemberExpression(left Expression) Expression {
 		return p.parseTypeAssertion(left, dotToken)
 	}
 
+	// Check for a field list: value.{A, B}
+	if p.curTokenIs(lexer.TOKEN_LBRACE) {
+		return p.parseFieldList(left)
+	}
+
 	// After a dot, accept identifiers OR keywords as member names
 	// This allows calling Go methods like .String(), .Error(), .Type(), etc.
 	if !p.curTokenIs(lexer.TOKEN_IDENT) && !p.isKeywordToken(p.curToken.Type) {
-		msg := p.formatError(p.curToken.Line, p.curToken.Column,
+		msg := p.formatError(p.curToken.Line, p.curToken.Column, dberrors.MissingToken,
 			fmt.Sprintf("expected member name, got %s instead", p.curToken.Type),
 			"member access requires an identifier after the dot")
 		p.errors = append(p.errors, msg)
@@ -1742,6 +2973,32 @@ func (p *Parser) parseMemberExpression(left Expression) Expression {
 	return exp
 }
 
+// parseFieldList parses the field list of value.{A, B}
+func (p *Parser) parseFieldList(object Expression) Expression {
+	exp := &FieldListExpression{Token: p.curToken, Object: object}
+
+	for {
+		p.nextToken()
+		if !p.curTokenIs(lexer.TOKEN_IDENT) && !p.isKeywordToken(p.curToken.Type) {
+			msg := p.formatError(p.curToken.Line, p.curToken.Column, dberrors.MissingToken,
+				fmt.Sprintf("expected field name, got %s instead", p.curToken.Type),
+				"use: a, b = value.{FieldA, FieldB}")
+			p.errors = append(p.errors, msg)
+			return nil
+		}
+		exp.Fields = append(exp.Fields, &Identifier{Token: p.curToken, Value: p.curToken.Literal})
+		if !p.peekTokenIs(lexer.TOKEN_COMMA) {
+			break
+		}
+		p.nextToken()
+	}
+
+	if !p.expectPeek(lexer.TOKEN_RBRACE) {
+		return nil
+	}
+	return exp
+}
+
 // parseTypeAssertion parses a type assertion expression: value.(Type)
 func (p *Parser) parseTypeAssertion(value Expression, dotToken lexer.Token) Expression {
 	exp := &TypeAssertionExpression{Token: dotToken, Value: value}
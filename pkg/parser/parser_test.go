@@ -1,6 +1,8 @@
 package parser
 
 import (
+	"fmt"
+	"strings"
 	"testing"
 
 	"github.com/zditech/dbasic/pkg/lexer"
@@ -43,6 +45,100 @@ func TestParseDimStatement(t *testing.T) {
 	}
 }
 
+func TestParseMapType(t *testing.T) {
+	input := `DIM ages AS MAP OF STRING TO INTEGER`
+
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	stmt, ok := program.Statements[0].(*DimStatement)
+	if !ok {
+		t.Fatalf("expected DimStatement, got %T", program.Statements[0])
+	}
+
+	if !stmt.Type.IsMap {
+		t.Fatal("expected map type")
+	}
+
+	if stmt.Type.KeyType.Name != "STRING" {
+		t.Errorf("expected key type STRING, got %s", stmt.Type.KeyType.Name)
+	}
+
+	if stmt.Type.ElementType.Name != "INTEGER" {
+		t.Errorf("expected value type INTEGER, got %s", stmt.Type.ElementType.Name)
+	}
+
+	if stmt.Type.String() != "MAP OF STRING TO INTEGER" {
+		t.Errorf("expected 'MAP OF STRING TO INTEGER', got %s", stmt.Type.String())
+	}
+}
+
+func TestParseContainerTypes(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"DIM undo AS STACK OF STRING", "STACK OF STRING"},
+		{"DIM jobs AS QUEUE OF DOUBLE", "QUEUE OF DOUBLE"},
+		{"DIM seen AS SET OF MAP OF STRING TO INTEGER", "SET OF MAP OF STRING TO INTEGER"},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		stmt, ok := program.Statements[0].(*DimStatement)
+		if !ok {
+			t.Fatalf("expected DimStatement, got %T", program.Statements[0])
+		}
+		if !stmt.Type.IsContainer {
+			t.Errorf("%q: expected container type", tt.input)
+		}
+		if stmt.Type.String() != tt.expected {
+			t.Errorf("expected %q, got %q", tt.expected, stmt.Type.String())
+		}
+	}
+}
+
+func TestParseFunctionType(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+		params   int
+		returns  int
+	}{
+		{"DIM f AS FUNCTION(STRING) AS INTEGER", "FUNCTION(STRING) AS INTEGER", 1, 1},
+		{"DIM f AS FUNCTION(a AS INTEGER, b AS INTEGER) AS (INTEGER, ERROR)", "FUNCTION(INTEGER, INTEGER) AS (INTEGER, ERROR)", 2, 2},
+		{"DIM f AS SUB(STRING)", "SUB(STRING)", 1, 0},
+		{"DIM f AS SUB()", "SUB()", 0, 0},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		stmt := program.Statements[0].(*DimStatement)
+		if !stmt.Type.IsFunction {
+			t.Fatalf("%s: expected function type", tt.input)
+		}
+		if stmt.Type.String() != tt.expected {
+			t.Errorf("expected %q, got %q", tt.expected, stmt.Type.String())
+		}
+		if len(stmt.Type.ParamTypes) != tt.params {
+			t.Errorf("%s: expected %d params, got %d", tt.input, tt.params, len(stmt.Type.ParamTypes))
+		}
+		if len(stmt.Type.ReturnTypes) != tt.returns {
+			t.Errorf("%s: expected %d returns, got %d", tt.input, tt.returns, len(stmt.Type.ReturnTypes))
+		}
+	}
+}
+
 func TestParseDimWithValue(t *testing.T) {
 	input := `DIM x AS INTEGER = 42`
 
@@ -70,6 +166,69 @@ func TestParseDimWithValue(t *testing.T) {
 	}
 }
 
+func TestParseFixedLengthString(t *testing.T) {
+	input := `DIM code AS STRING * 8 = "AB"`
+
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	stmt, ok := program.Statements[0].(*DimStatement)
+	if !ok {
+		t.Fatalf("expected DimStatement, got %T", program.Statements[0])
+	}
+	if stmt.Type.Width == nil || stmt.Type.Width.String() != "8" {
+		t.Fatalf("expected width 8, got %v", stmt.Type.Width)
+	}
+	if stmt.Type.String() != "STRING * 8" {
+		t.Errorf("expected STRING * 8, got %q", stmt.Type.String())
+	}
+	if _, ok := stmt.Value.(*StringLiteral); !ok {
+		t.Errorf("expected StringLiteral value, got %T", stmt.Value)
+	}
+}
+
+func TestParseDimArrayBounds(t *testing.T) {
+	tests := []struct {
+		input    string
+		lower    string
+		size     string
+		expected string
+	}{
+		{"DIM arr(10) AS INTEGER", "", "10", "DIM arr(10) AS INTEGER"},
+		{"DIM arr(1 TO 10) AS INTEGER", "1", "10", "DIM arr(1 TO 10) AS INTEGER"},
+		{"DIM arr(-5 TO n + 1) AS DOUBLE", "(-5)", "(n + 1)", "DIM arr((-5) TO (n + 1)) AS DOUBLE"},
+		{"STATIC seen(1 TO 3) AS STRING", "1", "3", "STATIC seen(1 TO 3) AS STRING"},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		stmt, ok := program.Statements[0].(*DimStatement)
+		if !ok {
+			t.Fatalf("expected DimStatement, got %T", program.Statements[0])
+		}
+
+		lower := ""
+		if stmt.LowerBound != nil {
+			lower = stmt.LowerBound.String()
+		}
+		if lower != tt.lower {
+			t.Errorf("%s: expected lower bound %q, got %q", tt.input, tt.lower, lower)
+		}
+		if stmt.ArraySize.String() != tt.size {
+			t.Errorf("%s: expected size %q, got %q", tt.input, tt.size, stmt.ArraySize.String())
+		}
+		if stmt.String() != tt.expected {
+			t.Errorf("expected %q, got %q", tt.expected, stmt.String())
+		}
+	}
+}
+
 func TestParseLetStatement(t *testing.T) {
 	input := `LET x = 42`
 
@@ -211,6 +370,98 @@ NEXT`
 	}
 }
 
+func TestParseWithStatement(t *testing.T) {
+	input := `WITH person
+    .Name = "Ann"
+    .Age = .Age + 1
+END WITH`
+
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	stmt, ok := program.Statements[0].(*WithStatement)
+	if !ok {
+		t.Fatalf("expected WithStatement, got %T", program.Statements[0])
+	}
+
+	if stmt.Target.String() != "person" {
+		t.Errorf("expected target 'person', got %s", stmt.Target.String())
+	}
+
+	if len(stmt.Body.Statements) != 2 {
+		t.Fatalf("expected 2 body statements, got %d", len(stmt.Body.Statements))
+	}
+
+	assign, ok := stmt.Body.Statements[1].(*AssignmentStatement)
+	if !ok {
+		t.Fatalf("expected AssignmentStatement, got %T", stmt.Body.Statements[1])
+	}
+	member, ok := assign.Left.(*MemberExpression)
+	if !ok {
+		t.Fatalf("expected MemberExpression, got %T", assign.Left)
+	}
+	if _, ok := member.Object.(*WithTargetExpression); !ok {
+		t.Errorf("expected WITH target object, got %T", member.Object)
+	}
+	if assign.String() != ".Age = (.Age + 1)" {
+		t.Errorf("unexpected assignment %q", assign.String())
+	}
+}
+
+func TestParseForEachStatement(t *testing.T) {
+	input := `FOR EACH item IN items
+    PRINT item
+NEXT item`
+
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	stmt, ok := program.Statements[0].(*ForEachStatement)
+	if !ok {
+		t.Fatalf("expected ForEachStatement, got %T", program.Statements[0])
+	}
+
+	if stmt.Key != nil {
+		t.Errorf("expected nil key, got %s", stmt.Key.Value)
+	}
+
+	if stmt.Value.Value != "item" {
+		t.Errorf("expected variable 'item', got %s", stmt.Value.Value)
+	}
+
+	if stmt.Collection.String() != "items" {
+		t.Errorf("expected collection 'items', got %s", stmt.Collection.String())
+	}
+
+	if len(stmt.Body.Statements) != 1 {
+		t.Errorf("expected 1 body statement, got %d", len(stmt.Body.Statements))
+	}
+}
+
+func TestParseForEachKeyValue(t *testing.T) {
+	input := `FOR EACH key, value IN data
+    PRINT key; value
+NEXT`
+
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	stmt := program.Statements[0].(*ForEachStatement)
+	if stmt.Key == nil || stmt.Key.Value != "key" {
+		t.Fatalf("expected key variable 'key', got %v", stmt.Key)
+	}
+
+	if stmt.Value.Value != "value" {
+		t.Errorf("expected value variable 'value', got %s", stmt.Value.Value)
+	}
+}
+
 func TestParseWhileStatement(t *testing.T) {
 	input := `WHILE x > 0
     x = x - 1
@@ -314,6 +565,198 @@ END SUB`
 	}
 }
 
+func TestParseParamArray(t *testing.T) {
+	input := `SUB Report(prefix AS STRING, PARAMARRAY args AS []ANY)
+    PRINT prefix
+END SUB`
+
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	stmt := program.Statements[0].(*SubStatement)
+	if len(stmt.Params) != 2 {
+		t.Fatalf("expected 2 params, got %d", len(stmt.Params))
+	}
+
+	if stmt.Params[0].ParamArray {
+		t.Error("expected first param not to be PARAMARRAY")
+	}
+
+	if !stmt.Params[1].ParamArray {
+		t.Error("expected second param to be PARAMARRAY")
+	}
+}
+
+func TestParseInterfaceStatement(t *testing.T) {
+	input := `INTERFACE Shape
+    FUNCTION Area() AS DOUBLE
+    FUNCTION Bounds() AS (DOUBLE, DOUBLE)
+    SUB Scale(factor AS DOUBLE)
+END INTERFACE`
+
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	stmt, ok := program.Statements[0].(*InterfaceStatement)
+	if !ok {
+		t.Fatalf("expected InterfaceStatement, got %T", program.Statements[0])
+	}
+
+	if stmt.Name.Value != "Shape" {
+		t.Errorf("expected name 'Shape', got %s", stmt.Name.Value)
+	}
+
+	if len(stmt.Methods) != 3 {
+		t.Fatalf("expected 3 methods, got %d", len(stmt.Methods))
+	}
+
+	if len(stmt.Methods[1].ReturnTypes) != 2 {
+		t.Errorf("expected 2 return types for Bounds, got %d", len(stmt.Methods[1].ReturnTypes))
+	}
+
+	if len(stmt.Methods[2].Params) != 1 || len(stmt.Methods[2].ReturnTypes) != 0 {
+		t.Errorf("expected Scale to take 1 param and return nothing, got %s", stmt.Methods[2].String())
+	}
+}
+
+func TestParseTypeConstructor(t *testing.T) {
+	input := `TYPE Person
+    DIM Name AS STRING
+    DIM Age AS INTEGER
+
+    SUB New(name AS STRING, age AS INTEGER)
+        .Name = name
+        .Age = age
+    END SUB
+END TYPE
+
+DIM p AS Person = NEW Person("Ann", 30)`
+
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	if len(program.Statements) != 2 {
+		t.Fatalf("expected 2 statements, got %d", len(program.Statements))
+	}
+
+	ts, ok := program.Statements[0].(*TypeStatement)
+	if !ok {
+		t.Fatalf("expected TypeStatement, got %T", program.Statements[0])
+	}
+	if len(ts.Fields) != 2 {
+		t.Errorf("expected 2 fields, got %d", len(ts.Fields))
+	}
+	if ts.Constructor == nil {
+		t.Fatal("expected a constructor")
+	}
+	if len(ts.Constructor.Params) != 2 || len(ts.Constructor.Body.Statements) != 2 {
+		t.Errorf("unexpected constructor: %s", ts.Constructor.String())
+	}
+
+	dim := program.Statements[1].(*DimStatement)
+	newExpr, ok := dim.Value.(*NewExpression)
+	if !ok {
+		t.Fatalf("expected NewExpression, got %T", dim.Value)
+	}
+	if newExpr.String() != `NEW Person("Ann", 30)` {
+		t.Errorf("expected NEW Person(\"Ann\", 30), got %s", newExpr.String())
+	}
+}
+
+func TestParseTypeProperties(t *testing.T) {
+	input := `TYPE Person
+    DIM First AS STRING
+    DIM Last AS STRING
+
+    PROPERTY GET FullName() AS STRING
+        RETURN .First & " " & .Last
+    END PROPERTY
+
+    PROPERTY SET FullName(value AS STRING)
+        .First = value
+    END PROPERTY
+END TYPE`
+
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	ts, ok := program.Statements[0].(*TypeStatement)
+	if !ok {
+		t.Fatalf("expected TypeStatement, got %T", program.Statements[0])
+	}
+	if len(ts.Fields) != 2 {
+		t.Errorf("expected 2 fields, got %d", len(ts.Fields))
+	}
+	if len(ts.Properties) != 2 {
+		t.Fatalf("expected 2 properties, got %d", len(ts.Properties))
+	}
+
+	get, set := ts.Properties[0], ts.Properties[1]
+	if get.IsSet || get.Name.Value != "FullName" || get.ReturnType.String() != "STRING" {
+		t.Errorf("unexpected getter: %s", get.String())
+	}
+	if !set.IsSet || set.Param == nil || set.Param.Name.Value != "value" {
+		t.Errorf("unexpected setter: %s", set.String())
+	}
+	if len(get.Body.Statements) != 1 || len(set.Body.Statements) != 1 {
+		t.Errorf("expected one statement in each accessor body")
+	}
+}
+
+func TestParseModuleStatement(t *testing.T) {
+	input := `MODULE Math
+    CONST PI AS DOUBLE = 3.14159
+    DIM calls AS INTEGER
+
+    FUNCTION Clamp(x AS INTEGER, lo AS INTEGER, hi AS INTEGER) AS INTEGER
+        RETURN x
+    END FUNCTION
+
+    SUB Reset()
+        calls = 0
+    END SUB
+END MODULE
+
+PRINT Math.Clamp(5, 0, 3)`
+
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	if len(program.Statements) != 2 {
+		t.Fatalf("expected 2 statements, got %d", len(program.Statements))
+	}
+
+	stmt, ok := program.Statements[0].(*ModuleStatement)
+	if !ok {
+		t.Fatalf("expected ModuleStatement, got %T", program.Statements[0])
+	}
+
+	if stmt.Name.Value != "Math" {
+		t.Errorf("expected name 'Math', got %s", stmt.Name.Value)
+	}
+
+	if len(stmt.Body) != 4 {
+		t.Fatalf("expected 4 members, got %d", len(stmt.Body))
+	}
+
+	if _, ok := stmt.Body[2].(*FunctionStatement); !ok {
+		t.Errorf("expected FunctionStatement, got %T", stmt.Body[2])
+	}
+	if _, ok := stmt.Body[3].(*SubStatement); !ok {
+		t.Errorf("expected SubStatement, got %T", stmt.Body[3])
+	}
+}
+
 func TestParseFunctionStatement(t *testing.T) {
 	input := `FUNCTION Add(a AS INTEGER, b AS INTEGER) AS INTEGER
     RETURN a + b
@@ -438,6 +881,11 @@ func TestParseExpressions(t *testing.T) {
 		{"a AND b OR c", "((a AND b) OR c)"},
 		{"NOT flag", "(NOTflag)"},
 		{"-5", "(-5)"},
+		{"x ?? 0 + 1", "(x ?? (0 + 1))"},
+		{"a ?? b OR c", "(a ?? (b OR c))"},
+		{`name LIKE "A" & "*" AND ok`, `((name LIKE ("A" & "*")) AND ok)`},
+		{"x + 1 IN [1, 2] OR done", "(((x + 1) IN [1, 2]) OR done)"},
+		{"book.author.{First, Last}", "book.author.{First, Last}"},
 	}
 
 	for i, tt := range tests {
@@ -631,17 +1079,626 @@ RECEIVE x FROM ch`
 	}
 }
 
-func TestParseLabelAndGoto(t *testing.T) {
-	input := `start:
-    PRINT "Hello"
-    GOTO start`
+func TestParseSelectChannel(t *testing.T) {
+	input := `SELECT CHANNEL
+    CASE RECEIVE x FROM results
+        PRINT x
+    CASE SEND 1 TO jobs
+        PRINT "queued"
+    CASE TIMEOUT 500
+        PRINT "timed out"
+    CASE ELSE
+        PRINT "idle"
+END SELECT`
 
 	l := lexer.New(input)
 	p := New(l)
 	program := p.ParseProgram()
 	checkParserErrors(t, p)
 
-	label, ok := program.Statements[0].(*LabelStatement)
+	if len(program.Statements) != 1 {
+		t.Fatalf("expected 1 statement, got %d", len(program.Statements))
+	}
+
+	stmt, ok := program.Statements[0].(*SelectChannelStatement)
+	if !ok {
+		t.Fatalf("expected SelectChannelStatement, got %T", program.Statements[0])
+	}
+	if len(stmt.Cases) != 3 {
+		t.Fatalf("expected 3 cases, got %d", len(stmt.Cases))
+	}
+	if stmt.Cases[0].Receive == nil || stmt.Cases[0].Receive.Channel.String() != "results" {
+		t.Errorf("expected RECEIVE case, got %s", stmt.Cases[0].String())
+	}
+	if stmt.Cases[1].Send == nil || stmt.Cases[1].Send.Channel.String() != "jobs" {
+		t.Errorf("expected SEND case, got %s", stmt.Cases[1].String())
+	}
+	if stmt.Cases[2].Timeout == nil || stmt.Cases[2].Timeout.String() != "500" {
+		t.Errorf("expected TIMEOUT case, got %s", stmt.Cases[2].String())
+	}
+	for _, c := range stmt.Cases {
+		if len(c.Body.Statements) != 1 {
+			t.Errorf("expected 1 statement in case body, got %d", len(c.Body.Statements))
+		}
+	}
+	if stmt.Default == nil || len(stmt.Default.Statements) != 1 {
+		t.Error("expected CASE ELSE body")
+	}
+}
+
+func TestParseReceiveDiscard(t *testing.T) {
+	input := `RECEIVE FROM Done(ctx)`
+
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	stmt, ok := program.Statements[0].(*ReceiveStatement)
+	if !ok {
+		t.Fatalf("expected ReceiveStatement, got %T", program.Statements[0])
+	}
+	if stmt.Variable != nil {
+		t.Errorf("expected no variable, got %s", stmt.Variable.String())
+	}
+	if stmt.String() != "RECEIVE FROM Done(ctx)" {
+		t.Errorf("unexpected String(): %s", stmt.String())
+	}
+}
+
+func TestParseOnError(t *testing.T) {
+	input := `ON ERROR GOTO Handler
+ON ERROR GOTO 0
+ERROR 53
+on = 1`
+
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	if len(program.Statements) != 4 {
+		t.Fatalf("expected 4 statements, got %d", len(program.Statements))
+	}
+
+	onError, ok := program.Statements[0].(*OnErrorStatement)
+	if !ok || onError.Label != "Handler" {
+		t.Fatalf("expected ON ERROR GOTO Handler, got %s", program.Statements[0].String())
+	}
+	off, ok := program.Statements[1].(*OnErrorStatement)
+	if !ok || off.Label != "" || off.String() != "ON ERROR GOTO 0" {
+		t.Errorf("expected ON ERROR GOTO 0, got %s", program.Statements[1].String())
+	}
+	raise, ok := program.Statements[2].(*ErrorStatement)
+	if !ok || raise.Code.String() != "53" {
+		t.Errorf("expected ERROR 53, got %s", program.Statements[2].String())
+	}
+	// ON is only a keyword before ERROR
+	if _, ok := program.Statements[3].(*AssignmentStatement); !ok {
+		t.Errorf("expected assignment to on, got %T", program.Statements[3])
+	}
+}
+
+func TestParseAssertStatement(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"ASSERT x > 0", "ASSERT (x > 0)"},
+		{`ASSERT Len(s) < 10, "too long: " + s`, `ASSERT (Len(s) < 10), ("too long: " + s)`},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		stmt, ok := program.Statements[0].(*AssertStatement)
+		if !ok {
+			t.Fatalf("expected AssertStatement, got %T", program.Statements[0])
+		}
+		if stmt.String() != tt.expected {
+			t.Errorf("expected %q, got %q", tt.expected, stmt.String())
+		}
+	}
+}
+
+func TestParseOptionStatement(t *testing.T) {
+	input := `Option Unicode
+DIM option AS INTEGER
+option = 1`
+
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	if len(program.Statements) != 3 {
+		t.Fatalf("expected 3 statements, got %d", len(program.Statements))
+	}
+	stmt, ok := program.Statements[0].(*OptionStatement)
+	if !ok {
+		t.Fatalf("expected OptionStatement, got %T", program.Statements[0])
+	}
+	if stmt.String() != "OPTION UNICODE" {
+		t.Errorf("expected OPTION UNICODE, got %q", stmt.String())
+	}
+	if _, ok := program.Statements[2].(*AssignmentStatement); !ok {
+		t.Errorf("expected option = 1 to be an assignment, got %T", program.Statements[2])
+	}
+}
+
+func TestParseEmbedStatement(t *testing.T) {
+	input := `EMBEDFILE "logo.png" AS logo
+EmbedDir "assets" As files`
+
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	if len(program.Statements) != 2 {
+		t.Fatalf("expected 2 statements, got %d", len(program.Statements))
+	}
+	expected := []struct {
+		path, name string
+		isDir      bool
+		str        string
+	}{
+		{"logo.png", "logo", false, `EMBEDFILE "logo.png" AS logo`},
+		{"assets", "files", true, `EMBEDDIR "assets" AS files`},
+	}
+	for i, want := range expected {
+		stmt, ok := program.Statements[i].(*EmbedStatement)
+		if !ok {
+			t.Fatalf("expected EmbedStatement, got %T", program.Statements[i])
+		}
+		if stmt.Path != want.path || stmt.Name.Value != want.name || stmt.IsDir != want.isDir {
+			t.Errorf("unexpected embed %+v", stmt)
+		}
+		if stmt.String() != want.str {
+			t.Errorf("expected %q, got %q", want.str, stmt.String())
+		}
+	}
+}
+
+func TestParseCheckStatement(t *testing.T) {
+	input := `CHECK err
+CHECK Validate(x)
+DIM check AS INTEGER
+check = 1`
+
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	if len(program.Statements) != 4 {
+		t.Fatalf("expected 4 statements, got %d", len(program.Statements))
+	}
+	for i, want := range []string{"CHECK err", "CHECK Validate(x)"} {
+		stmt, ok := program.Statements[i].(*CheckStatement)
+		if !ok {
+			t.Fatalf("expected CheckStatement, got %T", program.Statements[i])
+		}
+		if stmt.String() != want {
+			t.Errorf("expected %q, got %q", want, stmt.String())
+		}
+	}
+	if _, ok := program.Statements[3].(*AssignmentStatement); !ok {
+		t.Errorf("expected check = 1 to be an assignment, got %T", program.Statements[3])
+	}
+}
+
+func TestParseContextualKeywordsAsNames(t *testing.T) {
+	input := `DIM lock AS INTEGER
+DIM in AS INTEGER
+DIM static AS STRING
+lock = in + 1
+module = like
+assert = 2
+with.Name = "x"
+FOR each = 1 TO 3
+NEXT each
+Assert(lock > 0)
+PRINT in; static; property
+LOCK mu
+    in = 2
+END LOCK
+ASSERT in > 1
+FOR EACH v IN items
+NEXT
+IF name LIKE "a*" THEN PRINT name`
+
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	expected := []string{
+		"*parser.DimStatement", "*parser.DimStatement", "*parser.DimStatement",
+		"*parser.AssignmentStatement", "*parser.AssignmentStatement", "*parser.AssignmentStatement",
+		"*parser.AssignmentStatement", "*parser.ForStatement", "*parser.ExpressionStatement",
+		"*parser.PrintStatement", "*parser.LockStatement", "*parser.AssertStatement",
+		"*parser.ForEachStatement", "*parser.IfStatement",
+	}
+	if len(program.Statements) != len(expected) {
+		t.Fatalf("expected %d statements, got %d", len(expected), len(program.Statements))
+	}
+	for i, want := range expected {
+		if got := fmt.Sprintf("%T", program.Statements[i]); got != want {
+			t.Errorf("statement %d: expected %s, got %s", i, want, got)
+		}
+	}
+	if dim := program.Statements[2].(*DimStatement); dim.Static {
+		t.Error("expected DIM static to declare a variable named static")
+	}
+}
+
+func TestParseInputStatement(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`INPUT name`, `INPUT name`},
+		{`INPUT "Name: "; name`, `INPUT "Name: "; name`},
+		{`INPUT "Age: ", age AS INTEGER`, `INPUT "Age: "; age AS INTEGER`},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		stmt, ok := program.Statements[0].(*InputStatement)
+		if !ok {
+			t.Fatalf("expected InputStatement, got %T", program.Statements[0])
+		}
+		if stmt.String() != tt.expected {
+			t.Errorf("expected %q, got %q", tt.expected, stmt.String())
+		}
+	}
+}
+
+func TestParseFileStatements(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`OPEN "data.txt" FOR INPUT AS #1`, `OPEN "data.txt" FOR INPUT AS #1`},
+		{`open path for append as #f`, `OPEN path FOR APPEND AS #f`},
+		{`LINE INPUT #1, line`, `LINE INPUT #1, line`},
+		{`PRINT #2, "total"; n`, `PRINT #2, "total";n`},
+		{`PRINT #2`, `PRINT #2`},
+		{`CLOSE #1, #f`, `CLOSE #1, #f`},
+		{`CLOSE`, `CLOSE`},
+		{`OPEN "people.dat" FOR RANDOM AS #1 LEN = 25`, `OPEN "people.dat" FOR RANDOM AS #1 LEN = 25`},
+		{`OPEN "image.bin" FOR BINARY AS #2`, `OPEN "image.bin" FOR BINARY AS #2`},
+		{`GET #1, 2, person`, `GET #1, 2, person`},
+		{`put #1, , people[i]`, `PUT #1, , (people[i])`},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		if len(program.Statements) != 1 {
+			t.Fatalf("expected 1 statement for %q, got %d", tt.input, len(program.Statements))
+		}
+		if got := program.Statements[0].String(); got != tt.expected {
+			t.Errorf("expected %q, got %q", tt.expected, got)
+		}
+	}
+}
+
+func TestParseConstGroup(t *testing.T) {
+	input := `CONST
+    Idle
+    Running   ' comment
+    Stopped = 10
+    Failed
+END CONST`
+
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	if len(program.Statements) != 1 {
+		t.Fatalf("expected 1 statement, got %d", len(program.Statements))
+	}
+	stmt, ok := program.Statements[0].(*ConstGroupStatement)
+	if !ok {
+		t.Fatalf("expected ConstGroupStatement, got %T", program.Statements[0])
+	}
+
+	names := []string{"Idle", "Running", "Stopped", "Failed"}
+	if len(stmt.Names) != len(names) {
+		t.Fatalf("expected %d names, got %d", len(names), len(stmt.Names))
+	}
+	for i, name := range names {
+		if stmt.Names[i].Value != name {
+			t.Errorf("name %d: expected %s, got %s", i, name, stmt.Names[i].Value)
+		}
+	}
+	if stmt.Values[0] != nil || stmt.Values[3] != nil {
+		t.Errorf("expected omitted values to be nil")
+	}
+	if stmt.Values[2] == nil || stmt.Values[2].String() != "10" {
+		t.Errorf("expected Stopped = 10, got %v", stmt.Values[2])
+	}
+}
+
+func TestParseLockStatement(t *testing.T) {
+	input := `LOCK counter.mu
+    counter.n = counter.n + 1
+    PRINT counter.n
+END LOCK`
+
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	if len(program.Statements) != 1 {
+		t.Fatalf("expected 1 statement, got %d", len(program.Statements))
+	}
+
+	stmt, ok := program.Statements[0].(*LockStatement)
+	if !ok {
+		t.Fatalf("expected LockStatement, got %T", program.Statements[0])
+	}
+	if stmt.Mutex.String() != "counter.mu" {
+		t.Errorf("expected mutex counter.mu, got %s", stmt.Mutex.String())
+	}
+	if len(stmt.Body.Statements) != 2 {
+		t.Errorf("expected 2 statements in LOCK body, got %d", len(stmt.Body.Statements))
+	}
+}
+
+func TestDump(t *testing.T) {
+	input := `SUB Main()
+    DIM s AS STRING = ""
+    PRINT s, 0
+END SUB`
+
+	p := New(lexer.New(input))
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	want := `Program
+  Statements: (1)
+    [0] SubStatement @1:1
+      Name: Identifier @1:5
+        Value: "Main"
+      Body: BlockStatement @2:0
+        Statements: (2)
+          [0] DimStatement @2:5
+            Name: Identifier @2:9
+              Value: "s"
+            Type: TypeSpec @2:14
+              Name: "STRING"
+            Value: StringLiteral @2:23
+              Value: ""
+          [1] PrintStatement @3:5
+            Values: (2)
+              [0] Identifier @3:11
+                Value: "s"
+              [1] IntegerLiteral @3:14
+                Value: 0
+            Separators: (1)
+              [0] ","
+`
+	if got := Dump(program); got != want {
+		t.Errorf("unexpected dump:\n%s\nwant:\n%s", got, want)
+	}
+
+	out, err := DumpJSON(program.Statements[0].(*SubStatement).Name)
+	if err != nil {
+		t.Fatalf("DumpJSON: %v", err)
+	}
+	if got := strings.Join(strings.Fields(string(out)), ""); got != `{"node":"Identifier","line":1,"column":5,"value":"Main"}` {
+		t.Errorf("unexpected JSON %s", got)
+	}
+}
+
+func TestParseDocComments(t *testing.T) {
+	input := `' Program header
+
+' Twice doubles n.
+'
+' It never fails.
+FUNCTION Twice(n AS INTEGER) AS INTEGER
+    ' not a doc comment
+    RETURN n * 2 ' nor this
+END FUNCTION
+SUB Plain()
+END SUB
+' A point
+TYPE Point
+    DIM X AS INTEGER
+END TYPE
+' Reset moves p home
+SUB (BYREF p AS Point) Reset()
+END SUB`
+
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	if len(program.Statements) != 4 {
+		t.Fatalf("expected 4 statements, got %d", len(program.Statements))
+	}
+	if doc := program.Statements[0].(*FunctionStatement).Doc; doc != "Twice doubles n.\n\nIt never fails." {
+		t.Errorf("unexpected FUNCTION doc %q", doc)
+	}
+	if doc := program.Statements[1].(*SubStatement).Doc; doc != "" {
+		t.Errorf("expected no doc on a SUB right after END FUNCTION, got %q", doc)
+	}
+	if doc := program.Statements[2].(*TypeStatement).Doc; doc != "A point" {
+		t.Errorf("unexpected TYPE doc %q", doc)
+	}
+	if doc := program.Statements[3].(*MethodStatement).Doc; doc != "Reset moves p home" {
+		t.Errorf("unexpected method doc %q", doc)
+	}
+}
+
+func TestParseTestStatement(t *testing.T) {
+	input := `TEST "adds numbers"
+    DIM n AS INTEGER = 2
+    IF n > 0 THEN
+        AssertEqual(n + n, 4)
+    END IF
+END TEST
+
+SUB Test()
+END SUB`
+
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	if len(program.Statements) != 2 {
+		t.Fatalf("expected 2 statements, got %d", len(program.Statements))
+	}
+
+	stmt, ok := program.Statements[0].(*TestStatement)
+	if !ok {
+		t.Fatalf("expected TestStatement, got %T", program.Statements[0])
+	}
+	if stmt.Name != "adds numbers" {
+		t.Errorf("expected test name 'adds numbers', got %q", stmt.Name)
+	}
+	if len(stmt.Body.Statements) != 2 {
+		t.Errorf("expected 2 statements in TEST body, got %d", len(stmt.Body.Statements))
+	}
+
+	// TEST is only a keyword before a string, so it still works as a name
+	if _, ok := program.Statements[1].(*SubStatement); !ok {
+		t.Errorf("expected SubStatement, got %T", program.Statements[1])
+	}
+
+	p = New(lexer.New("TEST \"unclosed\"\n    PRINT 1\nEND SUB"))
+	p.ParseProgram()
+	if len(p.Errors()) == 0 {
+		t.Error("expected an error for TEST closed by END SUB")
+	}
+}
+
+func TestParseBenchmarkStatement(t *testing.T) {
+	input := `BENCHMARK "sum to 100"
+    DIM total AS INTEGER
+    FOR i = 1 TO 100
+        total = total + i
+    NEXT i
+END BENCHMARK
+
+SUB Benchmark()
+END SUB`
+
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	if len(program.Statements) != 2 {
+		t.Fatalf("expected 2 statements, got %d", len(program.Statements))
+	}
+
+	stmt, ok := program.Statements[0].(*BenchmarkStatement)
+	if !ok {
+		t.Fatalf("expected BenchmarkStatement, got %T", program.Statements[0])
+	}
+	if stmt.Name != "sum to 100" {
+		t.Errorf("expected benchmark name 'sum to 100', got %q", stmt.Name)
+	}
+	if len(stmt.Body.Statements) != 2 {
+		t.Errorf("expected 2 statements in BENCHMARK body, got %d", len(stmt.Body.Statements))
+	}
+
+	// BENCHMARK is only a keyword before a string, so it still works as a name
+	if _, ok := program.Statements[1].(*SubStatement); !ok {
+		t.Errorf("expected SubStatement, got %T", program.Statements[1])
+	}
+
+	p = New(lexer.New("BENCHMARK \"unclosed\"\n    PRINT 1\nEND TEST"))
+	p.ParseProgram()
+	if len(p.Errors()) == 0 {
+		t.Error("expected an error for BENCHMARK closed by END TEST")
+	}
+}
+
+func TestParseDeclareStatement(t *testing.T) {
+	input := `DECLARE PACKAGE "strings"
+DECLARE TYPE Builder
+DECLARE CONST MaxLen AS INTEGER
+DECLARE DIM ErrTooLong AS ERROR
+DECLARE SUB Print(PARAMARRAY a AS []ANY)
+DECLARE FUNCTION Cut(s AS STRING, sep AS STRING) AS (STRING, STRING, BOOLEAN)
+DECLARE FUNCTION NewBuilder() AS POINTER TO Builder`
+
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	if len(program.Statements) != 7 {
+		t.Fatalf("expected 7 statements, got %d", len(program.Statements))
+	}
+	expected := []struct {
+		kind, name string
+	}{
+		{"PACKAGE", ""}, {"TYPE", "Builder"}, {"CONST", "MaxLen"}, {"DIM", "ErrTooLong"},
+		{"SUB", "Print"}, {"FUNCTION", "Cut"}, {"FUNCTION", "NewBuilder"},
+	}
+	for i, exp := range expected {
+		stmt, ok := program.Statements[i].(*DeclareStatement)
+		if !ok {
+			t.Fatalf("statement %d: expected DeclareStatement, got %T", i, program.Statements[i])
+		}
+		if stmt.Kind != exp.kind {
+			t.Errorf("statement %d: expected kind %s, got %s", i, exp.kind, stmt.Kind)
+		}
+		// Names keep their case, and may be keywords such as PRINT
+		if exp.name != "" && stmt.Name.Value != exp.name {
+			t.Errorf("statement %d: expected name %s, got %s", i, exp.name, stmt.Name.Value)
+		}
+	}
+
+	if pkg := program.Statements[0].(*DeclareStatement); pkg.Path != "strings" {
+		t.Errorf("expected package path strings, got %q", pkg.Path)
+	}
+	if print := program.Statements[4].(*DeclareStatement); len(print.Params) != 1 || !print.Params[0].ParamArray {
+		t.Errorf("expected one PARAMARRAY parameter, got %s", print.String())
+	}
+	cut := program.Statements[5].(*DeclareStatement)
+	if len(cut.Params) != 2 || len(cut.ReturnTypes) != 3 {
+		t.Errorf("expected 2 parameters and 3 results, got %s", cut.String())
+	}
+
+	// DECLARE is only a keyword before what it declares
+	p = New(lexer.New("DIM declare AS INTEGER\ndeclare = 1"))
+	p.ParseProgram()
+	checkParserErrors(t, p)
+}
+
+func TestParseLabelAndGoto(t *testing.T) {
+	input := `start:
+    PRINT "Hello"
+    GOTO start`
+
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	label, ok := program.Statements[0].(*LabelStatement)
 	if !ok {
 		t.Fatalf("expected LabelStatement, got %T", program.Statements[0])
 	}
@@ -658,6 +1715,111 @@ func TestParseLabelAndGoto(t *testing.T) {
 	}
 }
 
+func TestParseGosub(t *testing.T) {
+	input := `GOSUB Greet
+RETURN`
+
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	stmt, ok := program.Statements[0].(*GosubStatement)
+	if !ok {
+		t.Fatalf("expected GosubStatement, got %T", program.Statements[0])
+	}
+	if stmt.Label != "Greet" || stmt.String() != "GOSUB Greet" {
+		t.Errorf("expected GOSUB Greet, got %s", stmt.String())
+	}
+	if _, ok := program.Statements[1].(*ReturnStatement); !ok {
+		t.Errorf("expected ReturnStatement, got %T", program.Statements[1])
+	}
+}
+
+func TestParseForEachRange(t *testing.T) {
+	input := `FOR EACH i IN 1 TO n + 1 STEP 2
+NEXT`
+
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	stmt, ok := program.Statements[0].(*ForEachStatement)
+	if !ok {
+		t.Fatalf("expected ForEachStatement, got %T", program.Statements[0])
+	}
+	rng, ok := stmt.Collection.(*RangeExpression)
+	if !ok {
+		t.Fatalf("expected RangeExpression, got %T", stmt.Collection)
+	}
+	if rng.String() != "1 TO (n + 1) STEP 2" {
+		t.Errorf("expected 1 TO (n + 1) STEP 2, got %s", rng.String())
+	}
+}
+
+func TestParseInterpolatedString(t *testing.T) {
+	input := `$"Hello {name}, next year {age + 1} {{ok}}"`
+
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	stmt, ok := program.Statements[0].(*ExpressionStatement)
+	if !ok {
+		t.Fatalf("expected ExpressionStatement, got %T", program.Statements[0])
+	}
+
+	interp, ok := stmt.Expression.(*InterpolatedString)
+	if !ok {
+		t.Fatalf("expected InterpolatedString, got %T", stmt.Expression)
+	}
+
+	if len(interp.Parts) != 5 {
+		t.Fatalf("expected 5 parts, got %d", len(interp.Parts))
+	}
+
+	if text, ok := interp.Parts[4].(*StringLiteral); !ok || text.Value != " {ok}" {
+		t.Errorf("expected literal braces in last part, got %s", interp.Parts[4].String())
+	}
+
+	if interp.Parts[3].String() != "(age + 1)" {
+		t.Errorf("expected embedded expression (age + 1), got %s", interp.Parts[3].String())
+	}
+
+	if interp.String() != `$"Hello {name}, next year {(age + 1)} {{ok}}"` {
+		t.Errorf("unexpected String(): %s", interp.String())
+	}
+}
+
+func TestParseDateTimeLiteral(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"#2024-01-15#", "2024-01-15 00:00:00"},
+		{"#2024-01-15 09:30#", "2024-01-15 09:30:00"},
+		{"#2024-01-15T09:30:45#", "2024-01-15 09:30:45"},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		stmt := program.Statements[0].(*ExpressionStatement)
+		lit, ok := stmt.Expression.(*DateTimeLiteral)
+		if !ok {
+			t.Fatalf("%s: expected DateTimeLiteral, got %T", tt.input, stmt.Expression)
+		}
+		if got := lit.Value.Format("2006-01-02 15:04:05"); got != tt.expected {
+			t.Errorf("%s: expected %s, got %s", tt.input, tt.expected, got)
+		}
+	}
+}
+
 func TestParseSelectCase(t *testing.T) {
 	input := `SELECT CASE x
 CASE 1
@@ -692,6 +1854,41 @@ END SELECT`
 	}
 }
 
+func TestParseSelectCaseRanges(t *testing.T) {
+	input := `SELECT CASE x
+CASE 1 TO 10, 15
+    PRINT "low"
+CASE IS > 100
+    PRINT "high"
+END SELECT`
+
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	stmt := program.Statements[0].(*SelectStatement)
+	if len(stmt.Cases) != 2 {
+		t.Fatalf("expected 2 cases, got %d", len(stmt.Cases))
+	}
+
+	first := stmt.Cases[0].Values
+	if len(first) != 2 {
+		t.Fatalf("expected 2 values in first case, got %d", len(first))
+	}
+	if r, ok := first[0].(*CaseRange); !ok || r.String() != "1 TO 10" {
+		t.Errorf("expected range 1 TO 10, got %T %s", first[0], first[0].String())
+	}
+
+	is, ok := stmt.Cases[1].Values[0].(*CaseIs)
+	if !ok {
+		t.Fatalf("expected CaseIs, got %T", stmt.Cases[1].Values[0])
+	}
+	if is.Operator != ">" || is.Value.String() != "100" {
+		t.Errorf("expected IS > 100, got %s", is.String())
+	}
+}
+
 func TestParseMultiAssignment(t *testing.T) {
 	input := `result, ok = Divide(10, 2)`
 
@@ -710,6 +1907,26 @@ func TestParseMultiAssignment(t *testing.T) {
 	}
 }
 
+func TestParseByRefReceiver(t *testing.T) {
+	input := `SUB (BYREF c AS Counter) Bump()
+    c.N = c.N + 1
+END SUB`
+
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	stmt, ok := program.Statements[0].(*MethodStatement)
+	if !ok {
+		t.Fatalf("expected MethodStatement, got %T", program.Statements[0])
+	}
+
+	if !stmt.ReceiverByRef || !stmt.ReceiverType.IsPointer || stmt.ReceiverType.ElementType.IsPointer {
+		t.Errorf("expected BYREF receiver of POINTER TO Counter, got %s", stmt.ReceiverType)
+	}
+}
+
 func TestParserErrors(t *testing.T) {
 	tests := []struct {
 		input       string
@@ -718,6 +1935,27 @@ func TestParserErrors(t *testing.T) {
 		{"IF x > 5", "expected THEN"},
 		{"DIM x INTEGER", "expected AS"},
 		{"FUNCTION foo(", "expected"},
+		{"SUB foo(PARAMARRAY a AS []ANY, b AS INTEGER)", "PARAMARRAY must be the last parameter"},
+		{"PRINT .Name", "outside of a WITH block"},
+		{`PRINT $"{x"`, "unterminated {"},
+		{`PRINT $"{1 2}"`, "invalid expression {1 2}"},
+		{"PRINT \"\"\"never closed\n", "unterminated multi-line string"},
+		{"PRINT #2024-13-01#", "invalid DATETIME literal"},
+		{"MODULE M\nPRINT 1\nEND MODULE", "expected SUB, FUNCTION, DIM or CONST in MODULE"},
+		{"TYPE T\nSUB Greet()\nEND SUB\nEND TYPE", "only SUB New can be declared inside TYPE"},
+		{"LOCK mu\nPRINT 1\nEND IF", "expected LOCK, got IF"},
+		{"ON ERROR RESUME NEXT", "expected GOTO after ON ERROR, got RESUME"},
+		{"ON ERROR GOTO 10", "expected a label or 0 after ON ERROR GOTO, got 10"},
+		{"SELECT CHANNEL\nCASE x > 1\nEND SELECT", "expected RECEIVE, SEND or TIMEOUT after CASE in SELECT CHANNEL"},
+		{"TYPE T\nPROPERTY LET X(v AS INTEGER)\nEND PROPERTY\nEND TYPE", "expected GET or SET after PROPERTY"},
+		{"TYPE T\nPROPERTY GET X(v AS INTEGER) AS INTEGER\nEND PROPERTY\nEND TYPE", "PROPERTY GET X cannot take parameters"},
+		{"TYPE T\nPROPERTY SET X()\nEND PROPERTY\nEND TYPE", "PROPERTY SET X must take exactly one parameter"},
+		{"MODULE M\nSTATIC n AS INTEGER\nEND MODULE", "expected SUB, FUNCTION, DIM or CONST in MODULE"},
+		{"CONST\nRed AS INTEGER\nEND CONST", "expected a constant name or END CONST in CONST block"},
+		{`OPEN "a.txt" FOR READ AS #1`, "unknown file mode READ"},
+		{`OPEN "a.txt" FOR INPUT AS 1`, "expected # file number in OPEN"},
+		{"LINE INPUT line", "expected # file number in LINE INPUT"},
+		{"GET #1, person", "expected ,"},
 	}
 
 	for i, tt := range tests {
@@ -732,6 +1970,32 @@ func TestParserErrors(t *testing.T) {
 	}
 }
 
+func TestParserErrorCodes(t *testing.T) {
+	tests := []struct {
+		input string
+		code  string
+	}{
+		{"IF x > 5", "DB1004"},
+		{"PRINT )", "DB1001"},
+		{"PRINT #2024-13-01#", "DB1002"},
+		{"TYPE T\nSUB Greet()\nEND SUB\nEND TYPE", "DB1003"},
+		{"SPAWN x", "DB1000"},
+	}
+
+	for _, tt := range tests {
+		p := New(lexer.New(tt.input))
+		p.ParseProgram()
+		errors := p.Errors()
+		if len(errors) == 0 {
+			t.Errorf("%q: expected errors, got none", tt.input)
+			continue
+		}
+		if want := "parse error[" + tt.code + "]"; !strings.HasPrefix(errors[0], want) {
+			t.Errorf("%q: expected %s, got %q", tt.input, want, errors[0])
+		}
+	}
+}
+
 func TestCompleteProgram(t *testing.T) {
 	input := `' A complete DBasic program
 IMPORT "fmt"
@@ -0,0 +1,172 @@
+// Package plugin loads plugins, Go packages that add builtins to DBasic
+// without changes to the compiler.
+//
+// A plugin is a directory of Go source files. Each function that DBasic
+// programs may call is registered by a //dbasic:builtin line in its doc
+// comment, giving its DBasic signature as a DECLARE line would:
+//
+//	//dbasic:builtin FUNCTION Slugify(s AS STRING) AS STRING
+//	func Slugify(s string) string {
+//		return strings.ToLower(strings.Join(strings.Fields(s), "-"))
+//	}
+//
+// The analyzer checks calls against the signatures, and the generated
+// program embeds the source of every plugin it calls into, as it does the
+// runtime's helpers. The package clause is dropped and the imports are
+// merged with the program's, so everything else at the top level of the
+// files comes along, unexported helpers included. Test files are left out.
+package plugin
+
+import (
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/zditech/dbasic/pkg/lexer"
+	dbparser "github.com/zditech/dbasic/pkg/parser"
+)
+
+// Directive starts the line of a doc comment that registers a builtin
+const Directive = "//dbasic:builtin"
+
+// Builtin is a function a plugin adds
+type Builtin struct {
+	Name string                     // Name of the Go function
+	File string                     // File it is in, for errors
+	Decl *dbparser.DeclareStatement // DBasic signature, a SUB or FUNCTION
+}
+
+// Plugin is a loaded plugin directory
+type Plugin struct {
+	Dir      string
+	Name     string // Last element of Dir
+	Builtins []*Builtin
+	Imports  []string // Packages the source imports, as "path" or "alias path"
+	Source   string   // Go declarations to embed, without package clause or imports
+}
+
+// Load reads the plugin in dir
+func Load(dir string) (*Plugin, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "*.go"))
+	if err != nil {
+		return nil, err
+	}
+	p := &Plugin{Dir: dir, Name: filepath.Base(dir)}
+	imports := make(map[string]bool)
+	var source strings.Builder
+	fset := token.NewFileSet()
+	for _, path := range files {
+		if strings.HasSuffix(path, "_test.go") {
+			continue
+		}
+		src, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		f, err := parser.ParseFile(fset, path, src, parser.ParseComments)
+		if err != nil {
+			return nil, err
+		}
+		name := p.Name + "/" + filepath.Base(path)
+
+		// The declarations start after the imports
+		start := f.Name.End()
+		for _, imp := range f.Imports {
+			alias := ""
+			if imp.Name != nil {
+				alias = imp.Name.Name + " "
+			}
+			impPath, _ := strconv.Unquote(imp.Path.Value)
+			imports[alias+impPath] = true
+		}
+		for _, decl := range f.Decls {
+			if gen, ok := decl.(*ast.GenDecl); ok && gen.Tok == token.IMPORT {
+				start = gen.End()
+			}
+		}
+		fmt.Fprintf(&source, "// From plugin %s\n%s\n", name, strings.TrimSpace(string(src[fset.Position(start).Offset:])))
+
+		for _, decl := range f.Decls {
+			fn, ok := decl.(*ast.FuncDecl)
+			if !ok || fn.Doc == nil {
+				continue
+			}
+			for _, c := range fn.Doc.List {
+				if !strings.HasPrefix(c.Text, Directive+" ") {
+					continue
+				}
+				b, err := builtin(fn, c, name, fset)
+				if err != nil {
+					return nil, err
+				}
+				p.Builtins = append(p.Builtins, b)
+			}
+		}
+	}
+	if len(p.Builtins) == 0 {
+		return nil, fmt.Errorf("%s: no functions marked %s", dir, Directive)
+	}
+
+	for imp := range imports {
+		p.Imports = append(p.Imports, imp)
+	}
+	sort.Strings(p.Imports)
+	p.Source = source.String()
+	return p, nil
+}
+
+// builtin reads the signature a directive gives fn, and checks that it
+// has the parameters and results fn does
+func builtin(fn *ast.FuncDecl, c *ast.Comment, file string, fset *token.FileSet) (*Builtin, error) {
+	line := fset.Position(c.Pos()).Line
+	errorf := func(format string, args ...interface{}) error {
+		return fmt.Errorf("%s:%d: %s", file, line, fmt.Sprintf(format, args...))
+	}
+	if fn.Recv != nil || fn.Type.TypeParams != nil {
+		return nil, errorf("%s must be a function without receiver or type parameters", fn.Name.Name)
+	}
+
+	// Lines keep their number so that the analyzer reports errors where they are
+	text := strings.TrimSpace(strings.TrimPrefix(c.Text, Directive))
+	p := dbparser.New(lexer.New(strings.Repeat("\n", line-1) + "DECLARE " + text))
+	program := p.ParseProgram()
+	if len(p.Errors()) > 0 {
+		return nil, errorf("%s", p.Errors()[0])
+	}
+	var decl *dbparser.DeclareStatement
+	if len(program.Statements) == 1 {
+		decl, _ = program.Statements[0].(*dbparser.DeclareStatement)
+	}
+	if decl == nil || decl.Kind != "SUB" && decl.Kind != "FUNCTION" {
+		return nil, errorf("expected %s SUB or FUNCTION name(params)", Directive)
+	}
+	if !strings.EqualFold(decl.Name.Value, fn.Name.Name) {
+		return nil, errorf("%s declares %s, not %s", Directive, decl.Name.Value, fn.Name.Name)
+	}
+	if n := fn.Type.Params.NumFields(); n != len(decl.Params) {
+		return nil, errorf("%s has %d parameter(s), and its declaration %d", fn.Name.Name, n, len(decl.Params))
+	}
+	if n := fn.Type.Results.NumFields(); n != len(decl.ReturnTypes) {
+		return nil, errorf("%s has %d result(s), and its declaration %d", fn.Name.Name, n, len(decl.ReturnTypes))
+	}
+	return &Builtin{Name: fn.Name.Name, File: file, Decl: decl}, nil
+}
+
+// LoadAll reads the plugins in dirs
+func LoadAll(dirs []string) ([]*Plugin, error) {
+	var plugins []*Plugin
+	for _, dir := range dirs {
+		p, err := Load(dir)
+		if err != nil {
+			return nil, err
+		}
+		plugins = append(plugins, p)
+	}
+	return plugins, nil
+}
@@ -0,0 +1,106 @@
+package plugin
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func writePlugin(t *testing.T, files map[string]string) string {
+	dir := filepath.Join(t.TempDir(), "textutil")
+	if err := os.Mkdir(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	for name, src := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(src), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestLoad(t *testing.T) {
+	dir := writePlugin(t, map[string]string{
+		"text.go": `// Package textutil adds text builtins
+package textutil
+
+import (
+	"strings"
+	u "unicode"
+)
+
+//dbasic:builtin FUNCTION Slugify(s AS STRING) AS STRING
+func Slugify(s string) string {
+	return strings.ToLower(strings.Join(strings.FieldsFunc(s, notWord), "-"))
+}
+
+func notWord(r rune) bool { return !u.IsLetter(r) && !u.IsDigit(r) }
+`,
+		"join.go": `package textutil
+
+import "strings"
+
+// JoinAll joins its arguments with sep
+//
+//dbasic:builtin FUNCTION JoinAll(sep AS STRING, PARAMARRAY parts AS []STRING) AS STRING
+func JoinAll(sep string, parts ...string) string { return strings.Join(parts, sep) }
+`,
+		"text_test.go": `package textutil
+
+//dbasic:builtin SUB Ignored()
+func Ignored() {}
+`,
+	})
+
+	p, err := Load(dir)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	var names []string
+	for _, b := range p.Builtins {
+		names = append(names, b.Name+" "+b.Decl.Kind)
+	}
+	if want := []string{"JoinAll FUNCTION", "Slugify FUNCTION"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("expected builtins %v, got %v", want, names)
+	}
+	if b := p.Builtins[0]; b.File != "textutil/join.go" || b.Decl.Token.Line != 7 {
+		t.Errorf("expected JoinAll at textutil/join.go line 7, got %s line %d", b.File, b.Decl.Token.Line)
+	}
+	if want := []string{"strings", "u unicode"}; !reflect.DeepEqual(p.Imports, want) {
+		t.Errorf("expected imports %v, got %v", want, p.Imports)
+	}
+	for _, s := range []string{"func notWord(", "func JoinAll(", "// From plugin textutil/text.go"} {
+		if !strings.Contains(p.Source, s) {
+			t.Errorf("expected the source to contain %q:\n%s", s, p.Source)
+		}
+	}
+	for _, s := range []string{"package", "import", "Ignored"} {
+		if strings.Contains(p.Source, s) {
+			t.Errorf("expected the source not to contain %q:\n%s", s, p.Source)
+		}
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	tests := []struct {
+		src  string
+		want string
+	}{
+		{"func F() {}\n", "no functions marked //dbasic:builtin"},
+		{"//dbasic:builtin SUB G()\nfunc F() {}\n", "textutil/f.go:3: //dbasic:builtin declares G, not F"},
+		{"//dbasic:builtin CONST F AS INTEGER\nfunc F() {}\n", "expected //dbasic:builtin SUB or FUNCTION"},
+		{"//dbasic:builtin SUB F(a AS INTEGER)\nfunc F() {}\n", "F has 0 parameter(s), and its declaration 1"},
+		{"//dbasic:builtin SUB F()\nfunc F() int { return 0 }\n", "F has 1 result(s), and its declaration 0"},
+		{"type T int\n\n//dbasic:builtin SUB F()\nfunc (T) F() {}\n", "F must be a function without receiver"},
+		{"//dbasic:builtin FUNCTION F( AS INTEGER\nfunc F() int { return 0 }\n", "textutil/f.go:3: "},
+	}
+	for _, tt := range tests {
+		dir := writePlugin(t, map[string]string{"f.go": "package textutil\n\n" + tt.src})
+		_, err := Load(dir)
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%q: expected error containing %q, got %v", tt.src, tt.want, err)
+		}
+	}
+}
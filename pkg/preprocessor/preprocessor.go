@@ -14,6 +14,7 @@ import (
 type SourceMapping struct {
 	File string
 	Line int
+	Path string // Absolute path of File
 }
 
 // Result contains the preprocessed source and metadata.
@@ -31,6 +32,23 @@ type Preprocessor struct {
 	includedList []string        // Ordered list of included files
 	lineMap      []SourceMapping
 	errors       []string
+	sources      map[string]string // Contents to use instead of reading a file
+	searchPath   []string          // Directories to look for INCLUDEd files in
+}
+
+// SearchPathEnv is the environment variable listing directories that
+// INCLUDE searches, separated like PATH.
+const SearchPathEnv = "DBASIC_PATH"
+
+// EnvSearchPath returns the directories listed in $DBASIC_PATH
+func EnvSearchPath() []string {
+	var dirs []string
+	for _, dir := range filepath.SplitList(os.Getenv(SearchPathEnv)) {
+		if dir != "" {
+			dirs = append(dirs, dir)
+		}
+	}
+	return dirs
 }
 
 // New creates a new preprocessor with the given base directory.
@@ -41,19 +59,47 @@ func New(baseDir string) *Preprocessor {
 	}
 }
 
+// SetSearchPath sets the directories in which an INCLUDE with a relative
+// path is looked for, in order, when the file is not found relative to the
+// file that includes it.
+func (p *Preprocessor) SetSearchPath(dirs []string) {
+	p.searchPath = dirs
+}
+
 // Process preprocesses the source file, expanding INCLUDE directives.
 func (p *Preprocessor) Process(filename string) (*Result, error) {
-	absPath, err := filepath.Abs(filename)
+	return p.ProcessFiles([]string{filename})
+}
+
+// ProcessFiles preprocesses several source files into one program, in
+// order. The first is the main file. A file that an earlier one already
+// INCLUDEs is not added again.
+func (p *Preprocessor) ProcessFiles(filenames []string) (*Result, error) {
+	if len(filenames) == 0 {
+		return nil, fmt.Errorf("no source files")
+	}
+	absPath, err := filepath.Abs(filenames[0])
 	if err != nil {
-		return nil, fmt.Errorf("cannot resolve path '%s': %v", filename, err)
+		return nil, fmt.Errorf("cannot resolve path '%s': %v", filenames[0], err)
 	}
 
 	// Set base directory from the main file's location
 	p.baseDir = filepath.Dir(absPath)
 
-	source, err := p.processFile(absPath, 0)
-	if err != nil {
-		return nil, err
+	var source strings.Builder
+	for _, filename := range filenames {
+		path, err := filepath.Abs(filename)
+		if err != nil {
+			return nil, fmt.Errorf("cannot resolve path '%s': %v", filename, err)
+		}
+		if p.includedSet[path] {
+			continue
+		}
+		text, err := p.processFile(path, 0)
+		if err != nil {
+			return nil, err
+		}
+		source.WriteString(text)
 	}
 
 	if len(p.errors) > 0 {
@@ -61,13 +107,24 @@ func (p *Preprocessor) Process(filename string) (*Result, error) {
 	}
 
 	return &Result{
-		Source:        source,
+		Source:        source.String(),
 		LineMap:       p.lineMap,
 		MainFile:      absPath,
 		IncludedFiles: p.includedList,
 	}, nil
 }
 
+// ProcessSource is like Process, but takes the main file's contents from
+// source instead of reading the file, as an editor with unsaved changes needs.
+func (p *Preprocessor) ProcessSource(filename, source string) (*Result, error) {
+	absPath, err := filepath.Abs(filename)
+	if err != nil {
+		return nil, fmt.Errorf("cannot resolve path '%s': %v", filename, err)
+	}
+	p.sources = map[string]string{absPath: source}
+	return p.Process(absPath)
+}
+
 // processFile reads and processes a single file, recursively handling includes.
 func (p *Preprocessor) processFile(filename string, depth int) (string, error) {
 	const maxDepth = 100 // Prevent infinite recursion
@@ -90,9 +147,13 @@ func (p *Preprocessor) processFile(filename string, depth int) (string, error) {
 	p.includedList = append(p.includedList, absPath)
 
 	// Read the file
-	content, err := os.ReadFile(absPath)
-	if err != nil {
-		return "", fmt.Errorf("cannot read file '%s': %v", filename, err)
+	content, ok := p.sources[absPath]
+	if !ok {
+		data, err := os.ReadFile(absPath)
+		if err != nil {
+			return "", fmt.Errorf("cannot read file '%s': %v", filename, err)
+		}
+		content = string(data)
 	}
 
 	// Get the directory of this file for resolving relative includes
@@ -101,7 +162,7 @@ func (p *Preprocessor) processFile(filename string, depth int) (string, error) {
 
 	// Process line by line
 	var output strings.Builder
-	scanner := bufio.NewScanner(strings.NewReader(string(content)))
+	scanner := bufio.NewScanner(strings.NewReader(content))
 	lineNum := 0
 
 	// Regex to match INCLUDE "filename" (case insensitive)
@@ -115,13 +176,14 @@ func (p *Preprocessor) processFile(filename string, depth int) (string, error) {
 		if matches := includeRe.FindStringSubmatch(line); matches != nil {
 			includePath := matches[1]
 
-			// Resolve the include path relative to the current file
+			// Resolve the include path relative to the current file, or
+			// else the search path
 			if !filepath.IsAbs(includePath) {
-				includePath = filepath.Join(fileDir, includePath)
+				includePath = p.resolveInclude(fileDir, includePath)
 			}
 
 			// Add a comment showing where the include came from (useful for debugging)
-			p.lineMap = append(p.lineMap, SourceMapping{File: baseName, Line: lineNum})
+			p.lineMap = append(p.lineMap, SourceMapping{File: baseName, Line: lineNum, Path: absPath})
 			output.WriteString(fmt.Sprintf("' >>> BEGIN INCLUDE: %s (from %s:%d)\n",
 				filepath.Base(includePath), baseName, lineNum))
 
@@ -136,11 +198,11 @@ func (p *Preprocessor) processFile(filename string, depth int) (string, error) {
 			output.WriteString(includedSource)
 
 			// Add end marker
-			p.lineMap = append(p.lineMap, SourceMapping{File: baseName, Line: lineNum})
+			p.lineMap = append(p.lineMap, SourceMapping{File: baseName, Line: lineNum, Path: absPath})
 			output.WriteString(fmt.Sprintf("' <<< END INCLUDE: %s\n", filepath.Base(includePath)))
 		} else {
 			// Regular line - add to output and track source mapping
-			p.lineMap = append(p.lineMap, SourceMapping{File: baseName, Line: lineNum})
+			p.lineMap = append(p.lineMap, SourceMapping{File: baseName, Line: lineNum, Path: absPath})
 			output.WriteString(line)
 			output.WriteString("\n")
 		}
@@ -153,6 +215,33 @@ func (p *Preprocessor) processFile(filename string, depth int) (string, error) {
 	return output.String(), nil
 }
 
+// resolveInclude finds a relative INCLUDE path in dir, the including file's
+// directory, or else in the search path. If the file is in none of them, the
+// path in dir is returned, for the error to name.
+func (p *Preprocessor) resolveInclude(dir, path string) string {
+	local := filepath.Join(dir, path)
+	if _, err := os.Stat(local); err == nil {
+		return local
+	}
+	for _, searchDir := range p.searchPath {
+		candidate := filepath.Join(searchDir, path)
+		if _, err := os.Stat(candidate); err == nil {
+			return candidate
+		}
+	}
+	return local
+}
+
+// Find looks for a file by relative path where INCLUDE would: in the base
+// directory, then in the search path. It returns "" if there is none.
+func (p *Preprocessor) Find(path string) string {
+	found := p.resolveInclude(p.baseDir, path)
+	if _, err := os.Stat(found); err != nil {
+		return ""
+	}
+	return found
+}
+
 // Errors returns any errors encountered during preprocessing.
 func (p *Preprocessor) Errors() []string {
 	return p.errors
@@ -167,3 +256,13 @@ func (r *Result) GetOriginalLocation(line int) (string, int) {
 	mapping := r.LineMap[line-1]
 	return mapping.File, mapping.Line
 }
+
+// GetOriginalPath is like GetOriginalLocation, but returns the file's
+// absolute path.
+func (r *Result) GetOriginalPath(line int) (string, int) {
+	if line < 1 || line > len(r.LineMap) {
+		return "", 0
+	}
+	mapping := r.LineMap[line-1]
+	return mapping.Path, mapping.Line
+}
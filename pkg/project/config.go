@@ -0,0 +1,161 @@
+package project
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ConfigName is the file name of the user's configuration
+const ConfigName = "config.toml"
+
+// Config is the user's configuration, which gives defaults for the dbasic
+// command. Flags and environment variables take precedence over it. It
+// looks like this, with every key optional:
+//
+//	verbose = true
+//	cache = "~/.dbasic-cache"     # or "off"
+//	include = ["~/dbasic/lib"]
+//	goos = "linux"
+//	goarch = "arm64"
+//	tempdir = "/fast/tmp"
+//
+// Paths are absolute once loaded; ~ stands for the home directory, and
+// relative paths are relative to the configuration's directory.
+type Config struct {
+	Verbose bool
+	Cache   string   // Directory to cache build files in, or "off"
+	Include []string // Searched for INCLUDEd files after the -I directories
+	GOOS    string   // Target of dbasic build
+	GOARCH  string
+	TempDir string // Where temporary build directories are made
+}
+
+// ConfigPath returns the path of the user's configuration:
+// $DBASIC_CONFIG, or else dbasic/config.toml in $XDG_CONFIG_HOME or
+// ~/.config. It returns "" if DBASIC_CONFIG is off or there is no home
+// directory.
+func ConfigPath() string {
+	switch path := os.Getenv("DBASIC_CONFIG"); path {
+	case "off":
+		return ""
+	case "":
+	default:
+		return path
+	}
+	dir := os.Getenv("XDG_CONFIG_HOME")
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return ""
+		}
+		dir = filepath.Join(home, ".config")
+	}
+	return filepath.Join(dir, "dbasic", ConfigName)
+}
+
+// LoadConfig reads the configuration at path. A missing file, or a path of
+// "", gives the defaults.
+func LoadConfig(path string) (*Config, error) {
+	if path == "" {
+		return &Config{}, nil
+	}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return &Config{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	path, err = filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+	c, err := ParseConfig(filepath.Dir(path), string(data))
+	if err != nil {
+		return nil, fmt.Errorf("%s:%v", path, err)
+	}
+	return c, nil
+}
+
+// ParseConfig reads a configuration's contents. Relative paths are
+// resolved against dir.
+func ParseConfig(dir, text string) (*Config, error) {
+	c := &Config{}
+	for i, line := range strings.Split(text, "\n") {
+		lineNum := i + 1
+		line = strings.TrimSpace(stripComment(line))
+		if line == "" {
+			continue
+		}
+		if strings.HasPrefix(line, "[") {
+			return nil, fmt.Errorf("%d: the configuration has no tables", lineNum)
+		}
+
+		key, value, ok := splitKeyValue(line)
+		if !ok {
+			return nil, fmt.Errorf("%d: expected key = value", lineNum)
+		}
+		key, err := parseKey(key)
+		if err != nil {
+			return nil, fmt.Errorf("%d: %v", lineNum, err)
+		}
+		switch key {
+		case "verbose":
+			c.Verbose, err = parseBool(value)
+		case "cache":
+			c.Cache, err = unquote(value)
+			if c.Cache != "off" {
+				c.Cache = configPath(dir, c.Cache)
+			}
+		case "include":
+			c.Include, err = parseArray(value)
+			for i, inc := range c.Include {
+				c.Include[i] = configPath(dir, inc)
+			}
+		case "goos":
+			c.GOOS, err = unquote(value)
+		case "goarch":
+			c.GOARCH, err = unquote(value)
+		case "tempdir":
+			c.TempDir, err = unquote(value)
+			c.TempDir = configPath(dir, c.TempDir)
+		default:
+			err = fmt.Errorf("unknown key %q", key)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%d: %v", lineNum, err)
+		}
+	}
+	return c, nil
+}
+
+// configPath resolves a path in the configuration, which may start with ~
+// for the home directory
+func configPath(dir, name string) string {
+	if name == "" {
+		return ""
+	}
+	if name == "~" || strings.HasPrefix(name, "~/") {
+		if home, err := os.UserHomeDir(); err == nil {
+			name = home + name[1:]
+		}
+	}
+	name = filepath.FromSlash(name)
+	if filepath.IsAbs(name) {
+		return name
+	}
+	return filepath.Join(dir, name)
+}
+
+// parseBool reads true or false
+func parseBool(s string) (bool, error) {
+	switch s {
+	case "true":
+		return true, nil
+	case "false":
+		return false, nil
+	}
+	return false, fmt.Errorf("expected true or false, got %s", s)
+}
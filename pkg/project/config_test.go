@@ -0,0 +1,88 @@
+package project
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseConfig(t *testing.T) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		t.Skip("no home directory")
+	}
+	dir := filepath.FromSlash("/etc/dbasic")
+	c, err := ParseConfig(dir, `# Defaults for every build
+verbose = true
+cache = "~/.dbasic-cache"
+include = ["lib", "~/dbasic", "/opt/dbasic"]
+goos = "linux"      # a Raspberry Pi
+goarch = "arm64"
+tempdir = '/fast/tmp'
+`)
+	if err != nil {
+		t.Fatalf("ParseConfig: %v", err)
+	}
+	want := &Config{
+		Verbose: true,
+		Cache:   filepath.Join(home, ".dbasic-cache"),
+		Include: []string{filepath.Join(dir, "lib"), filepath.Join(home, "dbasic"), filepath.FromSlash("/opt/dbasic")},
+		GOOS:    "linux",
+		GOARCH:  "arm64",
+		TempDir: filepath.FromSlash("/fast/tmp"),
+	}
+	if !reflect.DeepEqual(c, want) {
+		t.Errorf("expected %+v, got %+v", want, c)
+	}
+
+	if c, err := ParseConfig(dir, `cache = "off"`); err != nil || c.Cache != "off" {
+		t.Errorf("expected the cache to be off, got %q (%v)", c.Cache, err)
+	}
+}
+
+func TestParseConfigErrors(t *testing.T) {
+	tests := []struct {
+		text string
+		want string
+	}{
+		{"[build]", "1: the configuration has no tables"},
+		{"verbose = yes", "1: expected true or false, got yes"},
+		{"\ngoos = linux", "2: expected a string"},
+		{"include = \"lib\"", "1: expected an array of strings"},
+		{"output = \"a\"", `1: unknown key "output"`},
+	}
+	for _, tt := range tests {
+		_, err := ParseConfig("/", tt.text)
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%q: expected error containing %q, got %v", tt.text, tt.want, err)
+		}
+	}
+}
+
+func TestLoadConfig(t *testing.T) {
+	dir := t.TempDir()
+	c, err := LoadConfig(filepath.Join(dir, ConfigName))
+	if err != nil || !reflect.DeepEqual(c, &Config{}) {
+		t.Errorf("expected the defaults for a missing file, got %+v (%v)", c, err)
+	}
+
+	path := filepath.Join(dir, ConfigName)
+	if err := os.WriteFile(path, []byte("verbose = true\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if c, err := LoadConfig(path); err != nil || !c.Verbose {
+		t.Errorf("expected verbose, got %+v (%v)", c, err)
+	}
+
+	t.Setenv("DBASIC_CONFIG", "")
+	t.Setenv("XDG_CONFIG_HOME", dir)
+	if got := ConfigPath(); got != filepath.Join(dir, "dbasic", ConfigName) {
+		t.Errorf("unexpected configuration path %q", got)
+	}
+	t.Setenv("DBASIC_CONFIG", "off")
+	if got := ConfigPath(); got != "" {
+		t.Errorf("expected no configuration path, got %q", got)
+	}
+}
@@ -0,0 +1,469 @@
+// Package project loads dbasic.toml, the manifest that describes a DBasic
+// program made of several source files.
+//
+// A manifest looks like this:
+//
+//	[project]
+//	name = "inventory"
+//	main = "main.dbas"
+//	sources = ["lib/*.dbas", "report.dbas"]
+//	output = "inventory"
+//	plugins = ["plugins/barcode"]
+//
+//	[dependencies]
+//	"github.com/charmbracelet/bubbletea" = "v1.3.10"
+//
+//	[libraries]
+//	"github.com/someone/strutil" = "v0.2.0"
+//
+// Every key is optional. The name defaults to the project directory's name,
+// main to main.dbas and output to the name. Dependencies pin the version of
+// Go modules that the generated program imports. Libraries are DBasic code
+// in git repositories, which dbasic get fetches into LibrariesDir. Plugins
+// are directories of Go source that add builtins; see package plugin.
+package project
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ManifestName is the file name of a project manifest
+const ManifestName = "dbasic.toml"
+
+// LockName is the file name of a lock file, which records the versions of
+// the Go modules a program's generated code was built with
+const LockName = "dbasic.lock"
+
+// ErrNoManifest is returned by Find when no directory has a manifest
+var ErrNoManifest = errors.New("no " + ManifestName + " found")
+
+// LibrariesDir is the directory in a project that libraries are fetched
+// into, one directory each. It is searched for INCLUDEd files.
+const LibrariesDir = "dbasic_libs"
+
+// Dependency pins a Go module to a version
+type Dependency struct {
+	Module  string
+	Version string
+}
+
+// Library is DBasic code fetched from a git repository
+type Library struct {
+	URL string // Repository, as given to dbasic get
+	Ref string // Commit, tag or branch to check out
+}
+
+// Name returns the directory the library is fetched into: the last element
+// of its URL, without .git
+func (l Library) Name() string {
+	name := strings.TrimRight(l.URL, "/")
+	if i := strings.LastIndexAny(name, "/:\\"); i >= 0 {
+		name = name[i+1:]
+	}
+	return strings.TrimSuffix(name, ".git")
+}
+
+// Project is a loaded manifest. Paths are absolute.
+type Project struct {
+	Dir          string // Directory holding the manifest
+	Name         string
+	Main         string   // Entry point, the file with SUB Main
+	Sources      []string // Source patterns, relative to Dir
+	Output       string   // Executable to build
+	Plugins      []string // Plugin directories
+	Dependencies []Dependency
+	Libraries    []Library
+}
+
+// Find looks for a manifest in dir and its parents and loads the first one
+func Find(dir string) (*Project, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		path := filepath.Join(dir, ManifestName)
+		if _, err := os.Stat(path); err == nil {
+			return Load(path)
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return nil, ErrNoManifest
+		}
+		dir = parent
+	}
+}
+
+// Load reads the manifest at path
+func Load(path string) (*Project, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	path, err = filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+	p, err := Parse(filepath.Dir(path), string(data))
+	if err != nil {
+		return nil, fmt.Errorf("%s:%v", path, err)
+	}
+	return p, nil
+}
+
+// Parse reads a manifest's contents. Relative paths are resolved against dir.
+func Parse(dir, text string) (*Project, error) {
+	p := &Project{Dir: dir}
+
+	table := ""
+	lines := strings.Split(text, "\n")
+	for i := 0; i < len(lines); i++ {
+		lineNum := i + 1
+		line := strings.TrimSpace(stripComment(lines[i]))
+		if line == "" {
+			continue
+		}
+
+		if strings.HasPrefix(line, "[") {
+			if !strings.HasSuffix(line, "]") {
+				return nil, fmt.Errorf("%d: unterminated table header", lineNum)
+			}
+			table = strings.TrimSpace(line[1 : len(line)-1])
+			if table != "project" && table != "dependencies" && table != "libraries" {
+				return nil, fmt.Errorf("%d: unknown table [%s]", lineNum, table)
+			}
+			continue
+		}
+
+		key, value, ok := splitKeyValue(line)
+		if !ok {
+			return nil, fmt.Errorf("%d: expected key = value", lineNum)
+		}
+		key, err := parseKey(key)
+		if err != nil {
+			return nil, fmt.Errorf("%d: %v", lineNum, err)
+		}
+		// An array may continue over the following lines
+		for strings.HasPrefix(value, "[") && !strings.HasSuffix(value, "]") && i+1 < len(lines) {
+			i++
+			value += " " + strings.TrimSpace(stripComment(lines[i]))
+		}
+
+		switch table {
+		case "dependencies":
+			version, err := unquote(value)
+			if err != nil {
+				return nil, fmt.Errorf("%d: version of %s: %v", lineNum, key, err)
+			}
+			p.Dependencies = append(p.Dependencies, Dependency{Module: key, Version: version})
+		case "libraries":
+			ref, err := unquote(value)
+			if err != nil {
+				return nil, fmt.Errorf("%d: ref of %s: %v", lineNum, key, err)
+			}
+			p.Libraries = append(p.Libraries, Library{URL: key, Ref: ref})
+		case "project":
+			var err error
+			switch key {
+			case "name":
+				p.Name, err = unquote(value)
+			case "main":
+				p.Main, err = unquote(value)
+			case "output":
+				p.Output, err = unquote(value)
+			case "sources":
+				p.Sources, err = parseArray(value)
+			case "plugins":
+				p.Plugins, err = parseArray(value)
+			default:
+				err = fmt.Errorf("unknown key %q", key)
+			}
+			if err != nil {
+				return nil, fmt.Errorf("%d: %v", lineNum, err)
+			}
+		default:
+			return nil, fmt.Errorf("%d: %s must be in a table such as [project]", lineNum, key)
+		}
+	}
+
+	if p.Name == "" {
+		p.Name = filepath.Base(dir)
+	}
+	if p.Main == "" {
+		p.Main = "main.dbas"
+	}
+	if p.Output == "" {
+		p.Output = p.Name
+	}
+	p.Main = p.path(p.Main)
+	p.Output = p.path(p.Output)
+	for i, dir := range p.Plugins {
+		p.Plugins[i] = p.path(dir)
+	}
+	return p, nil
+}
+
+// path resolves a manifest path against the project directory
+func (p *Project) path(name string) string {
+	name = filepath.FromSlash(name)
+	if filepath.IsAbs(name) {
+		return name
+	}
+	return filepath.Join(p.Dir, name)
+}
+
+// LibraryDir returns the directory a library is fetched into
+func (p *Project) LibraryDir(l Library) string {
+	return filepath.Join(p.Dir, LibrariesDir, l.Name())
+}
+
+// SearchPath returns the directories to search for INCLUDEd files of a
+// program in dir: the libraries directory of its project, if it has one
+func SearchPath(dir string) []string {
+	p, err := Find(dir)
+	if err != nil {
+		return nil
+	}
+	return []string{filepath.Join(p.Dir, LibrariesDir)}
+}
+
+// PluginDirs returns the plugin directories of the project a program in
+// dir belongs to, if it has one
+func PluginDirs(dir string) []string {
+	p, err := Find(dir)
+	if err != nil {
+		return nil
+	}
+	return p.Plugins
+}
+
+// SetLibrary records a library in the manifest at path, replacing the entry
+// with the same URL or adding one to the [libraries] table, which is
+// created if needed. The rest of the file is left as it is.
+func SetLibrary(path string, lib Library) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	entry := strconv.Quote(lib.URL) + " = " + strconv.Quote(lib.Ref)
+	lines := strings.Split(strings.TrimRight(string(data), "\n"), "\n")
+
+	table, last := "", -1 // last is the line to add the entry after
+	for i, l := range lines {
+		line := strings.TrimSpace(stripComment(l))
+		switch {
+		case strings.HasPrefix(line, "["):
+			table = strings.Trim(line, "[] ")
+			if table == "libraries" {
+				last = i
+			}
+		case table == "libraries" && line != "":
+			if key, _, ok := splitKeyValue(line); ok {
+				if url, err := parseKey(key); err == nil && url == lib.URL {
+					lines[i] = entry
+					return writeLines(path, lines)
+				}
+			}
+			last = i
+		}
+	}
+
+	if last < 0 {
+		if len(lines) > 0 && strings.TrimSpace(lines[0]) != "" {
+			lines = append(lines, "")
+		}
+		lines = append(lines, "[libraries]", entry)
+	} else {
+		lines = append(lines[:last+1], append([]string{entry}, lines[last+1:]...)...)
+	}
+	return writeLines(path, lines)
+}
+
+func writeLines(path string, lines []string) error {
+	return os.WriteFile(path, []byte(strings.TrimLeft(strings.Join(lines, "\n"), "\n")+"\n"), 0644)
+}
+
+// SourceFiles expands the source patterns into the files to compile along
+// with Main, in the order they are listed. Main itself is left out, and so
+// is any file matched twice.
+func (p *Project) SourceFiles() ([]string, error) {
+	seen := map[string]bool{p.Main: true}
+	var files []string
+	for _, pattern := range p.Sources {
+		matches, err := filepath.Glob(p.path(pattern))
+		if err != nil {
+			return nil, fmt.Errorf("bad source pattern %q: %v", pattern, err)
+		}
+		if len(matches) == 0 {
+			return nil, fmt.Errorf("source %q matches no files", pattern)
+		}
+		sort.Strings(matches)
+		for _, m := range matches {
+			if !seen[m] {
+				seen[m] = true
+				files = append(files, m)
+			}
+		}
+	}
+	return files, nil
+}
+
+// ReadLock reads the modules in a lock file. A missing file has none.
+func ReadLock(path string) ([]Dependency, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var deps []Dependency
+	for i, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("%s:%d: expected a module and its version", path, i+1)
+		}
+		deps = append(deps, Dependency{Module: fields[0], Version: fields[1]})
+	}
+	return deps, nil
+}
+
+// WriteLock writes a lock file, with the modules sorted
+func WriteLock(path string, deps []Dependency) error {
+	sorted := append([]Dependency{}, deps...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Module < sorted[j].Module })
+
+	var sb strings.Builder
+	sb.WriteString("# Versions of the Go modules used by the generated code, kept by dbasic.\n")
+	sb.WriteString("# Commit this file; delete it to move to the latest versions.\n")
+	for _, d := range sorted {
+		sb.WriteString(d.Module + " " + d.Version + "\n")
+	}
+	return os.WriteFile(path, []byte(sb.String()), 0644)
+}
+
+// MergeLock returns the modules of lock at the versions in deps, followed
+// by the modules of deps that lock does not have. Modules in lock that deps
+// lacks are kept, as other programs may use them.
+func MergeLock(lock, deps []Dependency) []Dependency {
+	versions := make(map[string]string)
+	for _, d := range deps {
+		versions[d.Module] = d.Version
+	}
+	merged := make([]Dependency, 0, len(lock)+len(deps))
+	seen := make(map[string]bool)
+	for _, d := range lock {
+		if v, ok := versions[d.Module]; ok {
+			d.Version = v
+		}
+		merged = append(merged, d)
+		seen[d.Module] = true
+	}
+	for _, d := range deps {
+		if !seen[d.Module] {
+			merged = append(merged, d)
+			seen[d.Module] = true
+		}
+	}
+	return merged
+}
+
+// stripComment removes a # comment, leaving # inside strings alone
+func stripComment(line string) string {
+	var quote byte
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		switch {
+		case quote != 0:
+			if c == '\\' && quote == '"' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '#':
+			return line[:i]
+		}
+	}
+	return line
+}
+
+// splitKeyValue splits a line at the first = outside a quoted key
+func splitKeyValue(line string) (string, string, bool) {
+	start := 0
+	if strings.HasPrefix(line, `"`) {
+		// A quoted key such as a module path may contain anything but a quote
+		end := strings.Index(line[1:], `"`)
+		if end < 0 {
+			return "", "", false
+		}
+		start = end + 2
+	}
+	eq := strings.Index(line[start:], "=")
+	if eq < 0 {
+		return "", "", false
+	}
+	eq += start
+	key := strings.TrimSpace(line[:eq])
+	value := strings.TrimSpace(line[eq+1:])
+	return key, value, key != "" && value != ""
+}
+
+// parseKey reads a bare or quoted key
+func parseKey(s string) (string, error) {
+	if strings.HasPrefix(s, `"`) || strings.HasPrefix(s, "'") {
+		return unquote(s)
+	}
+	for _, c := range s {
+		if !(c == '_' || c == '-' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9') {
+			return "", fmt.Errorf("bad key %s", s)
+		}
+	}
+	return s, nil
+}
+
+// unquote reads a basic or literal string
+func unquote(s string) (string, error) {
+	switch {
+	case strings.HasPrefix(s, `"`):
+		v, err := strconv.Unquote(s)
+		if err != nil {
+			return "", fmt.Errorf("bad string %s", s)
+		}
+		return v, nil
+	case strings.HasPrefix(s, "'") && len(s) >= 2 && strings.HasSuffix(s, "'"):
+		return s[1 : len(s)-1], nil
+	}
+	return "", fmt.Errorf("expected a string, got %s", s)
+}
+
+// parseArray reads an array of strings
+func parseArray(s string) ([]string, error) {
+	if !strings.HasPrefix(s, "[") || !strings.HasSuffix(s, "]") {
+		return nil, fmt.Errorf("expected an array of strings, got %s", s)
+	}
+	var items []string
+	for _, item := range strings.Split(s[1:len(s)-1], ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		v, err := unquote(item)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, v)
+	}
+	return items, nil
+}
@@ -0,0 +1,253 @@
+package project
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	dir := filepath.FromSlash("/work/inventory")
+	p, err := Parse(dir, `# Inventory tool
+[project]
+name = "stock"          # not the directory name
+main = 'src/app.dbas'
+sources = [
+    "lib/*.dbas",       # helpers
+    "report#1.dbas",
+]
+plugins = ["plugins/barcode"]
+
+[dependencies]
+"github.com/charmbracelet/bubbletea" = "v1.3.10"
+golang-x-text = "v0.3.8"
+
+[libraries]
+"github.com/someone/strutil" = "v0.2.0"
+`)
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+
+	if p.Name != "stock" {
+		t.Errorf("expected name stock, got %q", p.Name)
+	}
+	if p.Main != filepath.Join(dir, "src", "app.dbas") {
+		t.Errorf("unexpected main %q", p.Main)
+	}
+	if p.Output != filepath.Join(dir, "stock") {
+		t.Errorf("expected output to default to the name, got %q", p.Output)
+	}
+	if want := []string{"lib/*.dbas", "report#1.dbas"}; !reflect.DeepEqual(p.Sources, want) {
+		t.Errorf("expected sources %v, got %v", want, p.Sources)
+	}
+	if want := []string{filepath.Join(dir, "plugins", "barcode")}; !reflect.DeepEqual(p.Plugins, want) {
+		t.Errorf("expected plugins %v, got %v", want, p.Plugins)
+	}
+	want := []Dependency{
+		{Module: "github.com/charmbracelet/bubbletea", Version: "v1.3.10"},
+		{Module: "golang-x-text", Version: "v0.3.8"},
+	}
+	if !reflect.DeepEqual(p.Dependencies, want) {
+		t.Errorf("expected dependencies %v, got %v", want, p.Dependencies)
+	}
+	libs := []Library{{URL: "github.com/someone/strutil", Ref: "v0.2.0"}}
+	if !reflect.DeepEqual(p.Libraries, libs) {
+		t.Errorf("expected libraries %v, got %v", libs, p.Libraries)
+	}
+	if lib := p.LibraryDir(libs[0]); lib != filepath.Join(dir, LibrariesDir, "strutil") {
+		t.Errorf("unexpected library directory %q", lib)
+	}
+}
+
+func TestLibraryName(t *testing.T) {
+	tests := map[string]string{
+		"github.com/someone/strutil":              "strutil",
+		"https://github.com/someone/strutil.git/": "strutil",
+		"git@github.com:someone/strutil.git":      "strutil",
+		"git@example.com:strutil":                 "strutil",
+		"../strutil":                              "strutil",
+	}
+	for url, want := range tests {
+		if got := (Library{URL: url}).Name(); got != want {
+			t.Errorf("Name of %q: expected %q, got %q", url, want, got)
+		}
+	}
+}
+
+func TestSetLibrary(t *testing.T) {
+	path := filepath.Join(t.TempDir(), ManifestName)
+	read := func() string {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(data)
+	}
+	set := func(url, ref string) {
+		if err := SetLibrary(path, Library{URL: url, Ref: ref}); err != nil {
+			t.Fatalf("SetLibrary: %v", err)
+		}
+	}
+
+	// The table is created, after the rest of the file
+	initial := "[project]\nname = \"app\" # comment\n"
+	if err := os.WriteFile(path, []byte(initial), 0644); err != nil {
+		t.Fatal(err)
+	}
+	set("github.com/a/one", "v1.0.0")
+	want := initial + "\n[libraries]\n\"github.com/a/one\" = \"v1.0.0\"\n"
+	if got := read(); got != want {
+		t.Errorf("expected\n%s\ngot\n%s", want, got)
+	}
+
+	// Entries are added to the end of the table and replaced in place
+	if err := os.WriteFile(path, []byte(read()+"\n[dependencies]\n\"example.com/m\" = \"v1.0.0\"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	set("github.com/a/two", "main")
+	set("github.com/a/one", "v1.1.0")
+	want = initial + "\n[libraries]\n\"github.com/a/one\" = \"v1.1.0\"\n\"github.com/a/two\" = \"main\"\n\n[dependencies]\n\"example.com/m\" = \"v1.0.0\"\n"
+	if got := read(); got != want {
+		t.Errorf("expected\n%s\ngot\n%s", want, got)
+	}
+
+	p, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if len(p.Libraries) != 2 || p.Libraries[0].Ref != "v1.1.0" || p.Libraries[1].URL != "github.com/a/two" {
+		t.Errorf("unexpected libraries %v", p.Libraries)
+	}
+
+	// An empty manifest gets just the table
+	if err := os.WriteFile(path, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	set("github.com/a/one", "v1.0.0")
+	if got := read(); got != "[libraries]\n\"github.com/a/one\" = \"v1.0.0\"\n" {
+		t.Errorf("unexpected manifest %q", got)
+	}
+}
+
+func TestParseDefaults(t *testing.T) {
+	dir := filepath.FromSlash("/work/inventory")
+	p, err := Parse(dir, "")
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	if p.Name != "inventory" || p.Main != filepath.Join(dir, "main.dbas") || p.Output != filepath.Join(dir, "inventory") {
+		t.Errorf("unexpected defaults: %+v", p)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{"name = \"x\"", "1: name must be in a table"},
+		{"[project]\nname = x", "2: expected a string, got x"},
+		{"[project]\nentry = \"main.dbas\"", "2: unknown key \"entry\""},
+		{"[build]", "1: unknown table [build]"},
+		{"[project\n", "1: unterminated table header"},
+		{"[project]\nsources = \"a.dbas\"", "2: expected an array of strings"},
+		{"[project]\nsources = [\"a.dbas\", b]", "2: expected a string, got b"},
+		{"[project]\nname", "2: expected key = value"},
+		{"[dependencies]\n\"example.com/m\" = 1", "2: version of example.com/m: expected a string"},
+		{"[libraries]\n\"example.com/lib\" = v1", "2: ref of example.com/lib: expected a string"},
+	}
+	for _, tt := range tests {
+		_, err := Parse("/work", tt.input)
+		if err == nil || !strings.HasPrefix(err.Error(), tt.want) {
+			t.Errorf("Parse(%q): expected error %q, got %v", tt.input, tt.want, err)
+		}
+	}
+}
+
+func TestFindAndSourceFiles(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"main.dbas", "lib/b.dbas", "lib/a.dbas", "report.dbas"} {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	manifest := "[project]\nsources = [\"report.dbas\", \"lib/*.dbas\", \"*.dbas\"]\n"
+	if err := os.WriteFile(filepath.Join(dir, ManifestName), []byte(manifest), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// The manifest is found from a subdirectory
+	p, err := Find(filepath.Join(dir, "lib"))
+	if err != nil {
+		t.Fatalf("Find: %v", err)
+	}
+	files, err := p.SourceFiles()
+	if err != nil {
+		t.Fatalf("SourceFiles: %v", err)
+	}
+	var got []string
+	for _, f := range files {
+		rel, _ := filepath.Rel(dir, f)
+		got = append(got, filepath.ToSlash(rel))
+	}
+	if want := []string{"report.dbas", "lib/a.dbas", "lib/b.dbas"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("expected source files %v, got %v", want, got)
+	}
+
+	p.Sources = append(p.Sources, "missing/*.dbas")
+	if _, err := p.SourceFiles(); err == nil || !strings.Contains(err.Error(), "matches no files") {
+		t.Errorf("expected an error for a pattern that matches nothing, got %v", err)
+	}
+
+	if _, err := Find(t.TempDir()); err != ErrNoManifest {
+		t.Errorf("expected ErrNoManifest, got %v", err)
+	}
+}
+
+func TestLock(t *testing.T) {
+	path := filepath.Join(t.TempDir(), LockName)
+	if deps, err := ReadLock(path); err != nil || deps != nil {
+		t.Fatalf("expected a missing lock file to be empty, got %v, %v", deps, err)
+	}
+
+	lock := []Dependency{
+		{Module: "github.com/mattn/go-sqlite3", Version: "v1.14.22"},
+		{Module: "github.com/charmbracelet/bubbletea", Version: "v1.3.10"},
+	}
+	merged := MergeLock(lock, []Dependency{
+		{Module: "github.com/charmbracelet/bubbletea", Version: "v1.3.11"},
+		{Module: "golang.org/x/sys", Version: "v0.30.0"},
+	})
+	want := []Dependency{
+		{Module: "github.com/mattn/go-sqlite3", Version: "v1.14.22"},
+		{Module: "github.com/charmbracelet/bubbletea", Version: "v1.3.11"},
+		{Module: "golang.org/x/sys", Version: "v0.30.0"},
+	}
+	if !reflect.DeepEqual(merged, want) {
+		t.Errorf("expected %v, got %v", want, merged)
+	}
+
+	if err := WriteLock(path, merged); err != nil {
+		t.Fatalf("WriteLock: %v", err)
+	}
+	data, _ := os.ReadFile(path)
+	if !strings.HasSuffix(string(data), "\ngithub.com/charmbracelet/bubbletea v1.3.11\ngithub.com/mattn/go-sqlite3 v1.14.22\ngolang.org/x/sys v0.30.0\n") {
+		t.Errorf("expected the modules in order, got:\n%s", data)
+	}
+	read, err := ReadLock(path)
+	if err != nil || len(read) != 3 || read[0].Module != "github.com/charmbracelet/bubbletea" {
+		t.Errorf("unexpected lock read back: %v, %v", read, err)
+	}
+
+	os.WriteFile(path, []byte("example.com/m\n"), 0644)
+	if _, err := ReadLock(path); err == nil || !strings.Contains(err.Error(), ":1: expected a module and its version") {
+		t.Errorf("expected an error for a line without a version, got %v", err)
+	}
+}
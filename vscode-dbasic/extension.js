@@ -0,0 +1,36 @@
+// Starts the DBasic language server (`dbasic lsp`) for .dbas files, which
+// provides diagnostics, hover, go-to-definition and completion.
+const vscode = require('vscode');
+const { LanguageClient } = require('vscode-languageclient/node');
+
+let client;
+
+function activate(context) {
+    const config = vscode.workspace.getConfiguration('dbasic');
+    if (!config.get('languageServer.enabled', true)) {
+        return;
+    }
+
+    const command = config.get('languageServer.path', 'dbasic');
+    const server = { command, args: ['lsp'] };
+
+    client = new LanguageClient(
+        'dbasic',
+        'DBasic Language Server',
+        { run: server, debug: server },
+        { documentSelector: [{ scheme: 'file', language: 'dbasic' }] }
+    );
+    client.start().catch((err) => {
+        vscode.window.showWarningMessage(
+            `DBasic language server failed to start (${command} lsp): ${err.message}. ` +
+            'Set dbasic.languageServer.path to the dbasic compiler.'
+        );
+    });
+    context.subscriptions.push({ dispose: () => client && client.stop() });
+}
+
+function deactivate() {
+    return client ? client.stop() : undefined;
+}
+
+module.exports = { activate, deactivate };